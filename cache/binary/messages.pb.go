@@ -106,6 +106,8 @@ func (m *Node) GetTags() []string {
 type Way struct {
 	Tags []string `protobuf:"bytes,1,rep,name=tags" json:"tags,omitempty"`
 	Refs []int64  `protobuf:"varint,2,rep,packed,name=refs" json:"refs,omitempty"`
+	Lats []int64  `protobuf:"zigzag64,3,rep,packed,name=lats" json:"lats,omitempty"`
+	Lons []int64  `protobuf:"zigzag64,4,rep,packed,name=lons" json:"lons,omitempty"`
 }
 
 func (m *Way) Reset()                    { *m = Way{} }
@@ -127,6 +129,20 @@ func (m *Way) GetRefs() []int64 {
 	return nil
 }
 
+func (m *Way) GetLats() []int64 {
+	if m != nil {
+		return m.Lats
+	}
+	return nil
+}
+
+func (m *Way) GetLons() []int64 {
+	if m != nil {
+		return m.Lons
+	}
+	return nil
+}
+
 type Relation struct {
 	Tags        []string              `protobuf:"bytes,1,rep,name=tags" json:"tags,omitempty"`
 	MemberIds   []int64               `protobuf:"varint,2,rep,name=member_ids,json=memberIds" json:"member_ids,omitempty"`
@@ -293,6 +309,42 @@ func (m *Way) MarshalTo(dAtA []byte) (int, error) {
 		i = encodeVarintMessages(dAtA, i, uint64(j1))
 		i += copy(dAtA[i:], dAtA2[:j1])
 	}
+	if len(m.Lats) > 0 {
+		var j3 int
+		dAtA5 := make([]byte, len(m.Lats)*10)
+		for _, num := range m.Lats {
+			x4 := (uint64(num) << 1) ^ uint64((num >> 63))
+			for x4 >= 1<<7 {
+				dAtA5[j3] = uint8(uint64(x4)&0x7f | 0x80)
+				j3++
+				x4 >>= 7
+			}
+			dAtA5[j3] = uint8(x4)
+			j3++
+		}
+		dAtA[i] = 0x1a
+		i++
+		i = encodeVarintMessages(dAtA, i, uint64(j3))
+		i += copy(dAtA[i:], dAtA5[:j3])
+	}
+	if len(m.Lons) > 0 {
+		var j6 int
+		dAtA8 := make([]byte, len(m.Lons)*10)
+		for _, num := range m.Lons {
+			x7 := (uint64(num) << 1) ^ uint64((num >> 63))
+			for x7 >= 1<<7 {
+				dAtA8[j6] = uint8(uint64(x7)&0x7f | 0x80)
+				j6++
+				x7 >>= 7
+			}
+			dAtA8[j6] = uint8(x7)
+			j6++
+		}
+		dAtA[i] = 0x22
+		i++
+		i = encodeVarintMessages(dAtA, i, uint64(j6))
+		i += copy(dAtA[i:], dAtA8[:j6])
+	}
 	return i, nil
 }
 
@@ -469,6 +521,20 @@ func (m *Way) Size() (n int) {
 		}
 		n += 1 + sovMessages(uint64(l)) + l
 	}
+	if len(m.Lats) > 0 {
+		l = 0
+		for _, e := range m.Lats {
+			l += sozMessages(uint64(e))
+		}
+		n += 1 + sovMessages(uint64(l)) + l
+	}
+	if len(m.Lons) > 0 {
+		l = 0
+		for _, e := range m.Lons {
+			l += sozMessages(uint64(e))
+		}
+		n += 1 + sovMessages(uint64(l)) + l
+	}
 	return n
 }
 
@@ -786,6 +852,134 @@ func (m *Way) Unmarshal(dAtA []byte) error {
 			} else {
 				return fmt.Errorf("proto: wrong wireType = %d for field Refs", wireType)
 			}
+		case 3:
+			if wireType == 0 {
+				var v uint64
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowMessages
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					v |= (uint64(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				v = (v >> 1) ^ uint64((int64(v&1)<<63)>>63)
+				m.Lats = append(m.Lats, int64(v))
+			} else if wireType == 2 {
+				var packedLen int
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowMessages
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					packedLen |= (int(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				if packedLen < 0 {
+					return ErrInvalidLengthMessages
+				}
+				postIndex := iNdEx + packedLen
+				if postIndex > l {
+					return io.ErrUnexpectedEOF
+				}
+				for iNdEx < postIndex {
+					var v uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowMessages
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						v |= (uint64(b) & 0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					v = (v >> 1) ^ uint64((int64(v&1)<<63)>>63)
+					m.Lats = append(m.Lats, int64(v))
+				}
+			} else {
+				return fmt.Errorf("proto: wrong wireType = %d for field Lats", wireType)
+			}
+		case 4:
+			if wireType == 0 {
+				var v uint64
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowMessages
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					v |= (uint64(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				v = (v >> 1) ^ uint64((int64(v&1)<<63)>>63)
+				m.Lons = append(m.Lons, int64(v))
+			} else if wireType == 2 {
+				var packedLen int
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowMessages
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					packedLen |= (int(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				if packedLen < 0 {
+					return ErrInvalidLengthMessages
+				}
+				postIndex := iNdEx + packedLen
+				if postIndex > l {
+					return io.ErrUnexpectedEOF
+				}
+				for iNdEx < postIndex {
+					var v uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowMessages
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						v |= (uint64(b) & 0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					v = (v >> 1) ^ uint64((int64(v&1)<<63)>>63)
+					m.Lons = append(m.Lons, int64(v))
+				}
+			} else {
+				return fmt.Errorf("proto: wrong wireType = %d for field Lons", wireType)
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipMessages(dAtA[iNdEx:])
@@ -1389,27 +1583,28 @@ var (
 func init() { proto.RegisterFile("cache/binary/messages.proto", fileDescriptorMessages) }
 
 var fileDescriptorMessages = []byte{
-	// 334 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x90, 0xd1, 0x4e, 0xc2, 0x30,
-	0x14, 0x86, 0xe9, 0xda, 0x28, 0x1c, 0xd0, 0x2c, 0x8d, 0x21, 0x4b, 0x88, 0x13, 0x77, 0xc5, 0x8d,
-	0x23, 0xfa, 0x00, 0x26, 0x20, 0x5c, 0x90, 0x20, 0x24, 0x95, 0x84, 0x78, 0x65, 0x0a, 0xab, 0x48,
-	0xb2, 0xad, 0x64, 0xed, 0xcd, 0xde, 0xc2, 0xc7, 0xe2, 0x4e, 0x9f, 0xc0, 0x18, 0x7c, 0x11, 0xb3,
-	0x16, 0x86, 0x17, 0xde, 0x9d, 0xff, 0xfb, 0xcf, 0xbf, 0x9d, 0xbf, 0xd0, 0x5a, 0xf2, 0xe5, 0x9b,
-	0xe8, 0x2e, 0xd6, 0x29, 0xcf, 0xf2, 0x6e, 0x22, 0x94, 0xe2, 0x2b, 0xa1, 0xc2, 0x4d, 0x26, 0xb5,
-	0xa4, 0x27, 0x16, 0x07, 0x63, 0x20, 0x13, 0x19, 0x09, 0xea, 0x01, 0x89, 0x65, 0xba, 0xf2, 0x50,
-	0xdb, 0xe9, 0x9c, 0xf5, 0xc9, 0xf6, 0xeb, 0xaa, 0xc2, 0x0c, 0xa1, 0x4d, 0xc0, 0x31, 0xd7, 0x9e,
-	0xf3, 0xc7, 0x28, 0x00, 0xa5, 0x40, 0x34, 0x5f, 0x29, 0x0f, 0xb7, 0x71, 0xa7, 0xc6, 0xcc, 0x1c,
-	0xdc, 0x02, 0x9e, 0xf3, 0xbc, 0xb4, 0xd0, 0xd1, 0xa2, 0x4d, 0x20, 0x99, 0x78, 0x55, 0x9e, 0xd3,
-	0xc6, 0x1d, 0xdc, 0x77, 0x5c, 0xc4, 0x8c, 0x0e, 0x3e, 0x10, 0x54, 0x99, 0x88, 0xb9, 0x5e, 0xcb,
-	0xf4, 0xdf, 0xe0, 0x25, 0x40, 0x22, 0x92, 0x85, 0xc8, 0x5e, 0xd6, 0xd1, 0x3e, 0xce, 0x6a, 0x96,
-	0x8c, 0x22, 0x45, 0xef, 0xa1, 0xb1, 0xb7, 0x75, 0xbe, 0x11, 0xf6, 0x9c, 0xf3, 0xbb, 0x56, 0x68,
-	0xfb, 0x85, 0x87, 0x4f, 0x87, 0x8f, 0x66, 0x69, 0x96, 0x6f, 0x04, 0xab, 0x27, 0xe5, 0xac, 0xe8,
-	0x75, 0x99, 0xcf, 0x64, 0x2c, 0x94, 0x47, 0xcc, 0xaf, 0xf7, 0x2b, 0xac, 0x40, 0xc1, 0x0d, 0xc0,
-	0x31, 0x4d, 0xab, 0x40, 0x26, 0xd3, 0xc1, 0xd0, 0xad, 0xd0, 0x53, 0xc0, 0xf3, 0xde, 0xb3, 0x8b,
-	0x68, 0x03, 0xaa, 0x6c, 0x38, 0xee, 0xcd, 0x46, 0xd3, 0x89, 0xeb, 0x04, 0x4f, 0x50, 0x1f, 0x88,
-	0x58, 0xf3, 0x07, 0x29, 0xb3, 0x48, 0xd1, 0x0b, 0xc0, 0xc5, 0xe1, 0x45, 0x25, 0x6a, 0x7a, 0x17,
-	0xb2, 0x78, 0x8e, 0x98, 0x6b, 0xdb, 0xc7, 0x62, 0xa3, 0x0d, 0x97, 0xa9, 0xad, 0x71, 0xe0, 0x32,
-	0x55, 0x7d, 0x77, 0xbb, 0xf3, 0xd1, 0xe7, 0xce, 0x47, 0xdf, 0x3b, 0x1f, 0xbd, 0xff, 0xf8, 0x95,
-	0xdf, 0x00, 0x00, 0x00, 0xff, 0xff, 0x7f, 0x85, 0x01, 0xea, 0xdf, 0x01, 0x00, 0x00,
+	// 338 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x90, 0xd1, 0x6a, 0xc2, 0x30,
+	0x14, 0x86, 0x4d, 0x13, 0x36, 0x3d, 0xba, 0x51, 0xc2, 0x90, 0x82, 0xac, 0x73, 0xbd, 0xf2, 0x66,
+	0x15, 0xf6, 0x00, 0x03, 0x9d, 0x5e, 0x08, 0x4e, 0x21, 0x13, 0x64, 0x57, 0x23, 0xda, 0xcc, 0x15,
+	0xda, 0x46, 0x9a, 0xdc, 0xf4, 0x2d, 0xf6, 0x58, 0xde, 0x6d, 0x4f, 0x30, 0x86, 0x7b, 0x91, 0xd1,
+	0x46, 0xab, 0x83, 0xed, 0x2e, 0xe7, 0xfb, 0xcf, 0x39, 0x7f, 0xce, 0x0f, 0xad, 0x25, 0x5f, 0xbe,
+	0x8a, 0xee, 0x22, 0x4c, 0x78, 0x9a, 0x75, 0x63, 0xa1, 0x14, 0x5f, 0x09, 0xe5, 0xaf, 0x53, 0xa9,
+	0x25, 0x3d, 0x31, 0xd8, 0x1b, 0x03, 0x99, 0xc8, 0x40, 0x50, 0x07, 0x48, 0x24, 0x93, 0x95, 0x83,
+	0xda, 0x56, 0xe7, 0xac, 0x4f, 0x36, 0x9f, 0x57, 0x15, 0x56, 0x10, 0xda, 0x04, 0x1c, 0x71, 0xed,
+	0x58, 0x47, 0x42, 0x0e, 0x28, 0x05, 0xa2, 0xf9, 0x4a, 0x39, 0xb8, 0x8d, 0x3b, 0x35, 0x56, 0xbc,
+	0x3d, 0x0e, 0x78, 0xce, 0xb3, 0x52, 0x42, 0x07, 0x89, 0x36, 0x81, 0xa4, 0xe2, 0x45, 0x39, 0x56,
+	0x1b, 0x77, 0x70, 0xdf, 0xb2, 0x11, 0x2b, 0xea, 0x9c, 0x47, 0x5c, 0x9b, 0x35, 0xd4, 0xf0, 0xbc,
+	0x2e, 0xb8, 0x4c, 0x94, 0x43, 0x8e, 0xb8, 0x4c, 0x94, 0xf7, 0x8e, 0xa0, 0xca, 0x44, 0xc4, 0x75,
+	0x28, 0x93, 0x3f, 0x8d, 0x2e, 0x01, 0x62, 0x11, 0x2f, 0x44, 0xfa, 0x1c, 0x06, 0x3b, 0x3b, 0x56,
+	0x33, 0x64, 0x14, 0x28, 0x7a, 0x07, 0x8d, 0x9d, 0xac, 0xb3, 0xb5, 0x30, 0xbe, 0xe7, 0xb7, 0x2d,
+	0xdf, 0xe4, 0xe1, 0xef, 0x57, 0xfb, 0x0f, 0x45, 0xd3, 0x2c, 0x5b, 0x0b, 0x56, 0x8f, 0xcb, 0xb7,
+	0xa2, 0xd7, 0xe5, 0x7c, 0x2a, 0x23, 0x61, 0xfe, 0x57, 0xdb, 0xb7, 0xb0, 0x1c, 0x79, 0x37, 0x00,
+	0x87, 0x69, 0x5a, 0x05, 0x32, 0x99, 0x0e, 0x86, 0x76, 0x85, 0x9e, 0x02, 0x9e, 0xf7, 0x9e, 0x6c,
+	0x44, 0x1b, 0x50, 0x65, 0xc3, 0x71, 0x6f, 0x36, 0x9a, 0x4e, 0x6c, 0xcb, 0x7b, 0x84, 0xfa, 0x40,
+	0x44, 0x9a, 0xdf, 0x4b, 0x99, 0x06, 0x8a, 0x5e, 0x00, 0x0e, 0x03, 0x73, 0x92, 0xb9, 0x3b, 0x2f,
+	0xcb, 0x98, 0xac, 0x7f, 0x62, 0xc2, 0xbf, 0x63, 0xea, 0xdb, 0x9b, 0xad, 0x8b, 0x3e, 0xb6, 0x2e,
+	0xfa, 0xda, 0xba, 0xe8, 0xed, 0xdb, 0xad, 0xfc, 0x0c, 0x00, 0xa7, 0xcc, 0x11, 0xbc, 0x0f, 0x02,
+	0x00, 0x00,
 }
 
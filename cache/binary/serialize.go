@@ -1,9 +1,17 @@
 package binary
 
-import osm "github.com/omniscale/go-osm"
+import (
+	"math"
+
+	osm "github.com/omniscale/go-osm"
+)
 
 const coordFactor float64 = 11930464.7083 // ((2<<31)-1)/360.0
 
+// wayCoordFactor is the precision of the node locations of ways, the same
+// as the default granularity of PBF files (100 nanodegrees).
+const wayCoordFactor float64 = 1e7
+
 func CoordToInt(coord float64) uint32 {
 	return uint32((coord + 180.0) * coordFactor)
 }
@@ -57,6 +65,17 @@ func MarshalWay(way *osm.Way) ([]byte, error) {
 	deltaPack(way.Refs)
 	pbfWay.Refs = way.Refs
 	pbfWay.Tags = tagsAsArray(way.Tags)
+	if len(way.Nodes) > 0 && len(way.Nodes) == len(way.Refs) {
+		// node locations from files with LocationsOnWays
+		pbfWay.Lats = make([]int64, len(way.Nodes))
+		pbfWay.Lons = make([]int64, len(way.Nodes))
+		for i, nd := range way.Nodes {
+			pbfWay.Lats[i] = int64(math.Round(nd.Lat * wayCoordFactor))
+			pbfWay.Lons[i] = int64(math.Round(nd.Long * wayCoordFactor))
+		}
+		deltaPack(pbfWay.Lats)
+		deltaPack(pbfWay.Lons)
+	}
 	return pbfWay.Marshal()
 }
 
@@ -71,6 +90,16 @@ func UnmarshalWay(data []byte) (way *osm.Way, err error) {
 	deltaUnpack(pbfWay.Refs)
 	way.Refs = pbfWay.Refs
 	way.Tags = tagsFromArray(pbfWay.Tags)
+	if len(pbfWay.Lats) > 0 && len(pbfWay.Lats) == len(way.Refs) && len(pbfWay.Lons) == len(way.Refs) {
+		deltaUnpack(pbfWay.Lats)
+		deltaUnpack(pbfWay.Lons)
+		way.Nodes = make([]osm.Node, len(way.Refs))
+		for i, ref := range way.Refs {
+			way.Nodes[i].ID = ref
+			way.Nodes[i].Lat = float64(pbfWay.Lats[i]) / wayCoordFactor
+			way.Nodes[i].Long = float64(pbfWay.Lons[i]) / wayCoordFactor
+		}
+	}
 	return way, nil
 }
 
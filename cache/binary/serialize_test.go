@@ -1,6 +1,7 @@
 package binary
 
 import (
+	"math"
 	"testing"
 
 	osm "github.com/omniscale/go-osm"
@@ -67,6 +68,32 @@ func TestMarshalWay(t *testing.T) {
 		t.Error("nodes do not match")
 	}
 
+	if way.Nodes != nil {
+		t.Error("unexpected node locations", way.Nodes)
+	}
+}
+
+func TestMarshalWayLocations(t *testing.T) {
+	way := &osm.Way{}
+	way.Refs = []int64{1, 2, 3}
+	way.Nodes = []osm.Node{
+		{Element: osm.Element{ID: 1}, Long: 8.1234567, Lat: 53.1},
+		{Element: osm.Element{ID: 2}, Long: -179.9999999, Lat: -85},
+		{Element: osm.Element{ID: 3}, Long: 180, Lat: 0.0000001},
+	}
+	expected := append([]osm.Node(nil), way.Nodes...)
+
+	data, _ := MarshalWay(way)
+	way, _ = UnmarshalWay(data)
+
+	if len(way.Nodes) != 3 {
+		t.Fatal("node locations do not match", way.Nodes)
+	}
+	for i, nd := range way.Nodes {
+		if nd.ID != expected[i].ID || math.Abs(nd.Long-expected[i].Long) > 1e-9 || math.Abs(nd.Lat-expected[i].Lat) > 1e-9 {
+			t.Errorf("node %d does not match: %v != %v", i, nd, expected[i])
+		}
+	}
 }
 
 func BenchmarkMarshalWay(b *testing.B) {
@@ -29,6 +29,9 @@ type osmCacheOptions struct {
 	Relations   cacheOptions
 	CoordsIndex cacheOptions
 	WaysIndex   cacheOptions
+	RowHashes   cacheOptions
+	Geometries  cacheOptions
+	Versions    cacheOptions
 }
 
 const defaultConfig = `
@@ -82,6 +85,30 @@ const defaultConfig = `
         "MaxOpenFiles": 64,
         "MaxFileSizeM": 8,
         "BlockRestartInterval": 128
+    },
+    "RowHashes": {
+        "CacheSizeM": 16,
+        "WriteBufferSizeM": 32,
+        "BlockSizeK": 0,
+        "MaxOpenFiles": 64,
+        "MaxFileSizeM": 8,
+        "BlockRestartInterval": 128
+    },
+    "Geometries": {
+        "CacheSizeM": 16,
+        "WriteBufferSizeM": 32,
+        "BlockSizeK": 0,
+        "MaxOpenFiles": 64,
+        "MaxFileSizeM": 32,
+        "BlockRestartInterval": 128
+    },
+    "Versions": {
+        "CacheSizeM": 16,
+        "WriteBufferSizeM": 64,
+        "BlockSizeK": 0,
+        "MaxOpenFiles": 64,
+        "MaxFileSizeM": 32,
+        "BlockRestartInterval": 256
     }
 }
 `
@@ -106,3 +133,43 @@ func init() {
 		}
 	}
 }
+
+// Sizes are the memory sizes of the caches, from the cache section of the
+// config file. Zero values keep the defaults (or the values from
+// IMPOSM_CACHE_CONFIG).
+type Sizes struct {
+	// LRUMB is the size of the LevelDB block cache of each cache in MB.
+	LRUMB int `json:"lru_mb,omitempty"`
+	// WriteBufferMB is the size of the LevelDB write buffer of each cache
+	// in MB.
+	WriteBufferMB int `json:"write_buffer_mb,omitempty"`
+	// CoordsBunches is the number of coords bunches that are kept in
+	// memory.
+	CoordsBunches int `json:"coords_bunches,omitempty"`
+}
+
+// SetSizes sets the memory sizes of all caches. Needs to be called before
+// the caches are opened.
+func SetSizes(s Sizes) {
+	for _, opts := range []*cacheOptions{
+		&globalCacheOptions.Coords.cacheOptions,
+		&globalCacheOptions.Ways,
+		&globalCacheOptions.Nodes,
+		&globalCacheOptions.Relations,
+		&globalCacheOptions.CoordsIndex,
+		&globalCacheOptions.WaysIndex,
+		&globalCacheOptions.RowHashes,
+		&globalCacheOptions.Geometries,
+		&globalCacheOptions.Versions,
+	} {
+		if s.LRUMB > 0 {
+			opts.CacheSizeM = s.LRUMB
+		}
+		if s.WriteBufferMB > 0 {
+			opts.WriteBufferSizeM = s.WriteBufferMB
+		}
+	}
+	if s.CoordsBunches > 0 {
+		globalCacheOptions.Coords.BunchCacheCapacity = s.CoordsBunches
+	}
+}
@@ -7,6 +7,9 @@ import (
 
 	osm "github.com/omniscale/go-osm"
 	"github.com/omniscale/imposm3/cache/binary"
+	"github.com/omniscale/imposm3/memory"
+	"github.com/omniscale/imposm3/priority"
+	"github.com/omniscale/imposm3/stats"
 )
 
 type byID []osm.Node
@@ -93,7 +96,7 @@ func newDeltaCoordsCache(path string) (*DeltaCoordsCache, error) {
 	coordsCache.bunchSize = int64(globalCacheOptions.Coords.BunchSize)
 	coordsCache.lruList = list.New()
 	// mem req for cache approx. capacity*bunchSize*40
-	coordsCache.capacity = int64(globalCacheOptions.Coords.BunchCacheCapacity)
+	coordsCache.capacity = int64(memory.Scale(globalCacheOptions.Coords.BunchCacheCapacity))
 	coordsCache.table = make(map[int64]*coordsBunch, coordsCache.capacity)
 	return &coordsCache, nil
 }
@@ -163,6 +166,10 @@ func (c *DeltaCoordsCache) FillWay(way *osm.Way) error {
 	if way == nil {
 		return nil
 	}
+	// ways from files with LocationsOnWays already have their nodes
+	if len(way.Nodes) > 0 && len(way.Nodes) == len(way.Refs) {
+		return nil
+	}
 	way.Nodes = make([]osm.Node, len(way.Refs))
 
 	var err error
@@ -281,6 +288,7 @@ func (c *DeltaCoordsCache) putCoordsPacked(bunchID int64, nodes []osm.Node) erro
 	data := make([]byte, 512)
 	data = binary.MarshalDeltaNodes(nodes, data)
 
+	priority.WaitCache(len(data))
 	err := c.db.Put(c.wo, keyBuf, data)
 	if err != nil {
 		return err
@@ -323,8 +331,10 @@ func (c *DeltaCoordsCache) getBunch(bunchID int64) (*coordsBunch, error) {
 		bunch = &coordsBunch{id: bunchID, coords: nodes, elem: elem}
 		needsGet = true
 		c.table[bunchID] = bunch
+		stats.CacheMiss("coords")
 	} else {
 		c.lruList.MoveToFront(bunch.elem)
+		stats.CacheHit("coords")
 	}
 	bunch.Lock()
 	err := c.CheckCapacity()
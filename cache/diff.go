@@ -13,6 +13,7 @@ import (
 	"github.com/omniscale/imposm3/cache/binary"
 	"github.com/omniscale/imposm3/element"
 	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/priority"
 )
 
 type DiffCache struct {
@@ -20,7 +21,11 @@ type DiffCache struct {
 	Coords    *CoordsRefIndex    // Stores which ways a coord references
 	CoordsRel *CoordsRelRefIndex // Stores which relations a coord references
 	Ways      *WaysRefIndex      // Stores which relations a way references
+	RowHashes *RowHashes         // Stores the hashes of the inserted rows, nil if not opened
 	opened    bool
+	// Geometries stores the geometries of inserted ways and relations,
+	// nil if not opened.
+	Geometries *Geometries
 }
 
 func NewDiffCache(dir string) *DiffCache {
@@ -41,6 +46,14 @@ func (c *DiffCache) Close() {
 		c.Ways.Close()
 		c.Ways = nil
 	}
+	if c.RowHashes != nil {
+		c.RowHashes.Close()
+		c.RowHashes = nil
+	}
+	if c.Geometries != nil {
+		c.Geometries.Close()
+		c.Geometries = nil
+	}
 }
 
 func (c *DiffCache) Flush() {
@@ -105,9 +118,51 @@ func (c *DiffCache) Remove() error {
 	if err := os.RemoveAll(filepath.Join(c.Dir, "ways_index")); err != nil {
 		return err
 	}
+	if err := os.RemoveAll(filepath.Join(c.Dir, "row_hashes")); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(c.Dir, "geometries")); err != nil {
+		return err
+	}
 	return nil
 }
 
+// OpenRowHashes opens the row hashes of -skip-unchanged. They are not
+// opened by Open.
+func (c *DiffCache) OpenRowHashes() error {
+	var err error
+	c.RowHashes, err = newRowHashes(filepath.Join(c.Dir, "row_hashes"))
+	return err
+}
+
+// RemoveRowHashes removes the row hashes. They need to be removed if the
+// tables are updated without them, as they would no longer match the rows.
+func (c *DiffCache) RemoveRowHashes() error {
+	if c.RowHashes != nil {
+		c.RowHashes.Close()
+		c.RowHashes = nil
+	}
+	return os.RemoveAll(filepath.Join(c.Dir, "row_hashes"))
+}
+
+// OpenGeometries opens the geometries of -reuse-geometries. They are not
+// opened by Open.
+func (c *DiffCache) OpenGeometries() error {
+	var err error
+	c.Geometries, err = newGeometries(filepath.Join(c.Dir, "geometries"))
+	return err
+}
+
+// RemoveGeometries removes the geometries. They need to be removed if
+// diff imports run without them, as they are not updated.
+func (c *DiffCache) RemoveGeometries() error {
+	if c.Geometries != nil {
+		c.Geometries.Close()
+		c.Geometries = nil
+	}
+	return os.RemoveAll(filepath.Join(c.Dir, "geometries"))
+}
+
 const bufferSize = 64 * 1024
 
 type idRef struct {
@@ -308,6 +363,7 @@ func (index *bunchRefCache) Add(id, ref int64) error {
 	defer bytePool.release(data)
 	data = binary.MarshalIDRefsBunch2(idRefBunch.idRefs, data)
 
+	priority.WaitCache(len(data))
 	return index.db.Put(index.wo, keyBuf, data)
 }
 
@@ -334,6 +390,7 @@ func (index *bunchRefCache) DeleteRef(id, ref int64) error {
 			data := bytePool.get()
 			defer bytePool.release(data)
 			data = binary.MarshalIDRefsBunch2(idRefs, data)
+			priority.WaitCache(len(data))
 			return index.db.Put(index.wo, keyBuf, data)
 		}
 	}
@@ -363,6 +420,7 @@ func (index *bunchRefCache) Delete(id int64) error {
 			data := bytePool.get()
 			defer bytePool.release(data)
 			data = binary.MarshalIDRefsBunch2(idRefs, data)
+			priority.WaitCache(len(data))
 			return index.db.Put(index.wo, keyBuf, data)
 		}
 	}
@@ -506,8 +564,10 @@ func (index *bunchRefCache) writeRefs(idRefs idRefBunches) error {
 		close(putc)
 	}()
 
+	var size int
 	for item := range putc {
 		batch.Put(item.bunchIDBuf, item.data)
+		size += len(item.data)
 		bytePool.release(item.data)
 	}
 
@@ -519,6 +579,7 @@ func (index *bunchRefCache) writeRefs(idRefs idRefBunches) error {
 		case idRefBunchesPool <- idRefs:
 		}
 	}()
+	priority.WaitCache(size)
 	return index.db.Write(index.wo, batch)
 }
 
@@ -0,0 +1,52 @@
+package cache
+
+// GeometryKind is the type of a stored geometry. Ways can have a
+// LineString and a Polygon geometry.
+type GeometryKind byte
+
+const (
+	LineStringGeometry   GeometryKind = 'l'
+	PolygonGeometry      GeometryKind = 'p'
+	MultiPolygonGeometry GeometryKind = 'r'
+)
+
+func geometryKeyBuf(kind GeometryKind, id int64) []byte {
+	return append([]byte{byte(kind)}, idToKeyBuf(id)...)
+}
+
+// Geometries stores the WKB geometries of inserted ways and relations by
+// their OSM ID. Diff imports reuse them for elements where only tags were
+// changed.
+type Geometries struct {
+	cache
+}
+
+func newGeometries(path string) (*Geometries, error) {
+	cache := Geometries{}
+	cache.options = &globalCacheOptions.Geometries
+	err := cache.open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &cache, err
+}
+
+// Get returns the WKB of the geometry, or NotFound.
+func (c *Geometries) Get(kind GeometryKind, id int64) ([]byte, error) {
+	data, err := c.db.Get(c.ro, geometryKeyBuf(kind, id))
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, NotFound
+	}
+	return data, nil
+}
+
+func (c *Geometries) Put(kind GeometryKind, id int64, wkb []byte) error {
+	return c.db.Put(c.wo, geometryKeyBuf(kind, id), wkb)
+}
+
+func (c *Geometries) Delete(kind GeometryKind, id int64) error {
+	return c.db.Delete(c.wo, geometryKeyBuf(kind, id))
+}
@@ -1,9 +1,13 @@
 package cache
 
 import (
+	"context"
+
 	"github.com/jmhodges/levigo"
 	osm "github.com/omniscale/go-osm"
 	"github.com/omniscale/imposm3/cache/binary"
+	"github.com/omniscale/imposm3/memory"
+	"github.com/omniscale/imposm3/priority"
 )
 
 type NodesCache struct {
@@ -32,6 +36,7 @@ func (p *NodesCache) PutNode(node *osm.Node) error {
 	if err != nil {
 		return err
 	}
+	priority.WaitCache(len(data))
 	return p.db.Put(p.wo, keyBuf, data)
 }
 
@@ -39,7 +44,7 @@ func (p *NodesCache) PutNodes(nodes []osm.Node) (int, error) {
 	batch := levigo.NewWriteBatch()
 	defer batch.Close()
 
-	var n int
+	var n, size int
 	for _, node := range nodes {
 		if node.ID == SKIP {
 			continue
@@ -53,8 +58,10 @@ func (p *NodesCache) PutNodes(nodes []osm.Node) (int, error) {
 			return 0, err
 		}
 		batch.Put(keyBuf, data)
+		size += len(data)
 		n++
 	}
+	priority.WaitCache(size)
 	return n, p.db.Write(p.wo, batch)
 }
 
@@ -80,8 +87,15 @@ func (p *NodesCache) DeleteNode(id int64) error {
 	return p.db.Delete(p.wo, keyBuf)
 }
 
+// Iter returns all cached nodes.
 func (p *NodesCache) Iter() chan *osm.Node {
-	nodes := make(chan *osm.Node)
+	return p.IterContext(context.Background())
+}
+
+// IterContext returns all cached nodes. The iteration stops early if ctx
+// is cancelled.
+func (p *NodesCache) IterContext(ctx context.Context) chan *osm.Node {
+	nodes := make(chan *osm.Node, memory.CacheBuffer(0))
 	go func() {
 		ro := levigo.NewReadOptions()
 		ro.SetFillCache(false)
@@ -98,8 +112,11 @@ func (p *NodesCache) Iter() chan *osm.Node {
 				panic(err)
 			}
 			node.ID = idFromKeyBuf(it.Key())
-
-			nodes <- node
+			select {
+			case nodes <- node:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 	return nodes
@@ -8,6 +8,7 @@ import (
 
 	"github.com/jmhodges/levigo"
 	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/memory"
 )
 
 var (
@@ -22,6 +23,7 @@ type OSMCache struct {
 	Ways      *WaysCache
 	Nodes     *NodesCache
 	Relations *RelationsCache
+	Versions  *Versions // Stores the versions of all elements, nil if not opened
 	opened    bool
 }
 
@@ -42,6 +44,10 @@ func (c *OSMCache) Close() {
 		c.Relations.Close()
 		c.Relations = nil
 	}
+	if c.Versions != nil {
+		c.Versions.Close()
+		c.Versions = nil
+	}
 }
 
 func NewOSMCache(dir string) *OSMCache {
@@ -77,6 +83,15 @@ func (c *OSMCache) Open() error {
 	return nil
 }
 
+// OpenVersions opens the versions of the cached elements, for imports of
+// multiple files. They are not opened by Open, as most imports read a
+// single file.
+func (c *OSMCache) OpenVersions() error {
+	var err error
+	c.Versions, err = newVersions(filepath.Join(c.dir, "versions"))
+	return err
+}
+
 func (c *OSMCache) Exists() bool {
 	if c.opened {
 		return true
@@ -96,6 +111,9 @@ func (c *OSMCache) Exists() bool {
 	if _, err := os.Stat(filepath.Join(c.dir, "inserted_ways")); !os.IsNotExist(err) {
 		return true
 	}
+	if _, err := os.Stat(filepath.Join(c.dir, "versions")); !os.IsNotExist(err) {
+		return true
+	}
 	return false
 }
 
@@ -118,6 +136,9 @@ func (c *OSMCache) Remove() error {
 	if err := os.RemoveAll(filepath.Join(c.dir, "inserted_ways")); err != nil {
 		return err
 	}
+	if err := os.RemoveAll(filepath.Join(c.dir, "versions")); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -160,7 +181,7 @@ func (c *cache) open(path string) error {
 	opts := levigo.NewOptions()
 	opts.SetCreateIfMissing(true)
 	if c.options.CacheSizeM > 0 {
-		c.cache = levigo.NewLRUCache(c.options.CacheSizeM * 1024 * 1024)
+		c.cache = levigo.NewLRUCache(memory.Scale(c.options.CacheSizeM) * 1024 * 1024)
 		opts.SetCache(c.cache)
 	}
 	if c.options.MaxOpenFiles > 0 {
@@ -170,7 +191,7 @@ func (c *cache) open(path string) error {
 		opts.SetBlockRestartInterval(c.options.BlockRestartInterval)
 	}
 	if c.options.WriteBufferSizeM > 0 {
-		opts.SetWriteBufferSize(c.options.WriteBufferSizeM * 1024 * 1024)
+		opts.SetWriteBufferSize(memory.Scale(c.options.WriteBufferSizeM) * 1024 * 1024)
 	}
 	if c.options.BlockSizeK > 0 {
 		opts.SetBlockSize(c.options.BlockSizeK * 1024)
@@ -1,9 +1,13 @@
 package cache
 
 import (
+	"context"
+
 	"github.com/jmhodges/levigo"
 	osm "github.com/omniscale/go-osm"
 	"github.com/omniscale/imposm3/cache/binary"
+	"github.com/omniscale/imposm3/memory"
+	"github.com/omniscale/imposm3/priority"
 )
 
 type RelationsCache struct {
@@ -29,6 +33,7 @@ func (p *RelationsCache) PutRelation(relation *osm.Relation) error {
 	if err != nil {
 		return err
 	}
+	priority.WaitCache(len(data))
 	return p.db.Put(p.wo, keyBuf, data)
 }
 
@@ -36,6 +41,7 @@ func (p *RelationsCache) PutRelations(rels []osm.Relation) error {
 	batch := levigo.NewWriteBatch()
 	defer batch.Close()
 
+	var size int
 	for _, rel := range rels {
 		if rel.ID == SKIP {
 			continue
@@ -49,12 +55,21 @@ func (p *RelationsCache) PutRelations(rels []osm.Relation) error {
 			return err
 		}
 		batch.Put(keyBuf, data)
+		size += len(data)
 	}
+	priority.WaitCache(size)
 	return p.db.Write(p.wo, batch)
 }
 
+// Iter returns all cached relations.
 func (p *RelationsCache) Iter() chan *osm.Relation {
-	rels := make(chan *osm.Relation)
+	return p.IterContext(context.Background())
+}
+
+// IterContext returns all cached relations. The iteration stops early if ctx
+// is cancelled.
+func (p *RelationsCache) IterContext(ctx context.Context) chan *osm.Relation {
+	rels := make(chan *osm.Relation, memory.CacheBuffer(0))
 	go func() {
 		ro := levigo.NewReadOptions()
 		ro.SetFillCache(false)
@@ -71,8 +86,11 @@ func (p *RelationsCache) Iter() chan *osm.Relation {
 				panic(err)
 			}
 			rel.ID = idFromKeyBuf(it.Key())
-
-			rels <- rel
+			select {
+			case rels <- rel:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 	return rels
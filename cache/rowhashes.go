@@ -0,0 +1,66 @@
+package cache
+
+import (
+	bin "encoding/binary"
+
+	"github.com/jmhodges/levigo"
+)
+
+// RowKey identifies the rows of an element in a table.
+type RowKey struct {
+	Table string
+	ID    int64
+}
+
+func (k RowKey) keyBuf() []byte {
+	return append([]byte(k.Table+"\x00"), idToKeyBuf(k.ID)...)
+}
+
+// RowHashes stores a hash of the mapped values of the rows of each element
+// and table. Diff imports use them to skip rows that are unchanged.
+type RowHashes struct {
+	cache
+}
+
+func newRowHashes(path string) (*RowHashes, error) {
+	cache := RowHashes{}
+	cache.options = &globalCacheOptions.RowHashes
+	err := cache.open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &cache, err
+}
+
+// Get returns the hash of the rows, ok is false if no hash is stored.
+func (c *RowHashes) Get(key RowKey) (hash uint64, ok bool, err error) {
+	data, err := c.db.Get(c.ro, key.keyBuf())
+	if err != nil || len(data) != 8 {
+		return 0, false, err
+	}
+	return bin.BigEndian.Uint64(data), true, nil
+}
+
+// Put stores all hashes in a single batch.
+func (c *RowHashes) Put(hashes map[RowKey]uint64) error {
+	batch := levigo.NewWriteBatch()
+	defer batch.Close()
+
+	for key, hash := range hashes {
+		data := make([]byte, 8)
+		bin.BigEndian.PutUint64(data, hash)
+		batch.Put(key.keyBuf(), data)
+	}
+	return c.db.Write(c.wo, batch)
+}
+
+// Delete removes all hashes in a single batch.
+func (c *RowHashes) Delete(keys []RowKey) error {
+	batch := levigo.NewWriteBatch()
+	defer batch.Close()
+
+	for _, key := range keys {
+		batch.Delete(key.keyBuf())
+	}
+	return c.db.Write(c.wo, batch)
+}
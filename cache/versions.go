@@ -0,0 +1,68 @@
+package cache
+
+import (
+	bin "encoding/binary"
+
+	"github.com/jmhodges/levigo"
+)
+
+// Element kinds of the versions cache. Nodes and ways share the same ID
+// space in OSM, but not in the cache.
+const (
+	NodeVersion     byte = 'n'
+	WayVersion      byte = 'w'
+	RelationVersion byte = 'r'
+)
+
+// Versions stores the version of each cached element, for imports of
+// multiple files with overlapping elements. Only elements that are not
+// older than the cached version are cached again.
+type Versions struct {
+	cache
+}
+
+func newVersions(path string) (*Versions, error) {
+	cache := Versions{}
+	cache.options = &globalCacheOptions.Versions
+	err := cache.open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &cache, err
+}
+
+func versionKeyBuf(kind byte, id int64) []byte {
+	return append([]byte{kind}, idToKeyBuf(id)...)
+}
+
+// Keep returns whether each element is at least as new as the cached
+// element and it stores the versions of these elements. Elements without
+// version (0) are always kept, their version is not stored.
+func (c *Versions) Keep(kind byte, ids []int64, versions []int32) ([]bool, error) {
+	batch := levigo.NewWriteBatch()
+	defer batch.Close()
+
+	keep := make([]bool, len(ids))
+	for i, id := range ids {
+		keep[i] = true
+		if versions[i] <= 0 {
+			continue
+		}
+		key := versionKeyBuf(kind, id)
+		data, err := c.db.Get(c.ro, key)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 4 && int32(bin.BigEndian.Uint32(data)) > versions[i] {
+			keep[i] = false
+			continue
+		}
+		data = make([]byte, 4)
+		bin.BigEndian.PutUint32(data, uint32(versions[i]))
+		batch.Put(key, data)
+	}
+	if err := c.db.Write(c.wo, batch); err != nil {
+		return nil, err
+	}
+	return keep, nil
+}
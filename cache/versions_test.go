@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestVersions(t *testing.T) {
+	cacheDir, _ := ioutil.TempDir("", "imposm_test")
+	defer os.RemoveAll(cacheDir)
+
+	versions, err := newVersions(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer versions.Close()
+
+	keep, err := versions.Keep(WayVersion, []int64{1, 2, 3}, []int32{3, 3, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keep, []bool{true, true, true}) {
+		t.Errorf("unexpected result %v", keep)
+	}
+
+	// older, equal and newer versions, and elements without version
+	keep, err = versions.Keep(WayVersion, []int64{1, 2, 3, 4}, []int32{2, 3, 1, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keep, []bool{false, true, true, true}) {
+		t.Errorf("unexpected result %v", keep)
+	}
+	keep, err = versions.Keep(WayVersion, []int64{1, 2}, []int32{4, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keep, []bool{true, true}) {
+		t.Errorf("unexpected result %v", keep)
+	}
+
+	// nodes and ways have separate IDs
+	keep, err = versions.Keep(NodeVersion, []int64{1}, []int32{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !keep[0] {
+		t.Error("node with way ID not kept")
+	}
+}
@@ -1,9 +1,13 @@
 package cache
 
 import (
+	"context"
+
 	"github.com/jmhodges/levigo"
 	osm "github.com/omniscale/go-osm"
 	"github.com/omniscale/imposm3/cache/binary"
+	"github.com/omniscale/imposm3/memory"
+	"github.com/omniscale/imposm3/priority"
 )
 
 type WaysCache struct {
@@ -29,6 +33,7 @@ func (c *WaysCache) PutWay(way *osm.Way) error {
 	if err != nil {
 		return err
 	}
+	priority.WaitCache(len(data))
 	return c.db.Put(c.wo, keyBuf, data)
 }
 
@@ -36,6 +41,7 @@ func (c *WaysCache) PutWays(ways []osm.Way) error {
 	batch := levigo.NewWriteBatch()
 	defer batch.Close()
 
+	var size int
 	for _, way := range ways {
 		if way.ID == SKIP {
 			continue
@@ -46,7 +52,9 @@ func (c *WaysCache) PutWays(ways []osm.Way) error {
 			return err
 		}
 		batch.Put(keyBuf, data)
+		size += len(data)
 	}
+	priority.WaitCache(size)
 	return c.db.Write(c.wo, batch)
 }
 
@@ -72,8 +80,15 @@ func (c *WaysCache) DeleteWay(id int64) error {
 	return c.db.Delete(c.wo, keyBuf)
 }
 
+// Iter returns all cached ways.
 func (c *WaysCache) Iter() chan *osm.Way {
-	ways := make(chan *osm.Way, 1024)
+	return c.IterContext(context.Background())
+}
+
+// IterContext returns all cached ways. The iteration stops early if ctx
+// is cancelled.
+func (c *WaysCache) IterContext(ctx context.Context) chan *osm.Way {
+	ways := make(chan *osm.Way, memory.CacheBuffer(1024))
 	go func() {
 		ro := levigo.NewReadOptions()
 		ro.SetFillCache(false)
@@ -90,7 +105,11 @@ func (c *WaysCache) Iter() chan *osm.Way {
 				panic(err)
 			}
 			way.ID = idFromKeyBuf(it.Key())
-			ways <- way
+			select {
+			case ways <- way:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 	return ways
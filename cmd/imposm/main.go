@@ -5,13 +5,18 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/omniscale/imposm3"
+	"github.com/omniscale/imposm3/cache"
 	"github.com/omniscale/imposm3/cache/query"
 	"github.com/omniscale/imposm3/config"
 	"github.com/omniscale/imposm3/import_"
 	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/memory"
+	"github.com/omniscale/imposm3/priority"
 	"github.com/omniscale/imposm3/stats"
+	"github.com/omniscale/imposm3/tune"
 	"github.com/omniscale/imposm3/update"
 )
 
@@ -21,7 +26,12 @@ func PrintCmds() {
 	fmt.Println("\timport")
 	fmt.Println("\tdiff")
 	fmt.Println("\trun")
+	fmt.Println("\treplication set-sequence")
 	fmt.Println("\tquery-cache")
+	fmt.Println("\tcheck-geometries")
+	fmt.Println("\tquery")
+	fmt.Println("\tanalyze")
+	fmt.Println("\ttune")
 	fmt.Println("\tversion")
 }
 
@@ -45,6 +55,16 @@ func Main(usage func()) {
 		if opts.Base.HTTPProfile != "" {
 			stats.StartHTTPPProf(opts.Base.HTTPProfile)
 		}
+		if opts.Base.HTTPMetrics != "" {
+			stats.StartHTTPMetrics(opts.Base.HTTPMetrics)
+		}
+		memory.SetBudget(opts.Base.MaxMemory)
+		memory.SetBuffers(opts.Base.Buffers)
+		cache.SetSizes(opts.Base.CacheSizes)
+		if opts.Base.LowPriority {
+			priority.SetLowPriority(opts.Base.LowPriorityRates)
+		}
+		startProgress(opts.Base)
 		import_.Import(opts)
 	case "diff":
 		opts, files := config.ParseDiffImport(os.Args[2:])
@@ -52,6 +72,16 @@ func Main(usage func()) {
 		if opts.HTTPProfile != "" {
 			stats.StartHTTPPProf(opts.HTTPProfile)
 		}
+		if opts.HTTPMetrics != "" {
+			stats.StartHTTPMetrics(opts.HTTPMetrics)
+		}
+		memory.SetBudget(opts.MaxMemory)
+		memory.SetBuffers(opts.Buffers)
+		cache.SetSizes(opts.CacheSizes)
+		if opts.LowPriority {
+			priority.SetLowPriority(opts.LowPriorityRates)
+		}
+		startProgress(opts)
 		update.Diff(opts, files)
 	case "run":
 		opts := config.ParseRunImport(os.Args[2:])
@@ -59,9 +89,37 @@ func Main(usage func()) {
 		if opts.HTTPProfile != "" {
 			stats.StartHTTPPProf(opts.HTTPProfile)
 		}
+		if opts.HTTPMetrics != "" {
+			stats.StartHTTPMetrics(opts.HTTPMetrics)
+		}
+		memory.SetBudget(opts.MaxMemory)
+		memory.SetBuffers(opts.Buffers)
+		cache.SetSizes(opts.CacheSizes)
+		if opts.LowPriority {
+			priority.SetLowPriority(opts.LowPriorityRates)
+		}
+		startProgress(opts)
 		update.Run(opts)
+	case "replication":
+		if len(os.Args) < 3 || os.Args[2] != "set-sequence" {
+			usage()
+			log.Fatalf("invalid replication command, expected set-sequence")
+		}
+		opts := config.ParseSetSequence(os.Args[3:])
+		update.SetSequence(opts)
 	case "query-cache":
 		query.Query(os.Args[2:])
+	case "check-geometries":
+		opts := config.ParseCheckGeometries(os.Args[2:])
+		import_.CheckGeometries(opts)
+	case "query":
+		opts := config.ParseQuery(os.Args[2:])
+		import_.Query(opts)
+	case "analyze":
+		opts := config.ParseAnalyze(os.Args[2:])
+		import_.Analyze(opts)
+	case "tune":
+		tune.Tune(os.Args[2:])
 	case "version":
 		fmt.Println(imposm3.Version)
 		os.Exit(0)
@@ -73,6 +131,17 @@ func Main(usage func()) {
 
 }
 
+// startProgress starts to write JSON progress records, if enabled with
+// -progress.
+func startProgress(opts config.Base) {
+	if opts.Progress == "" {
+		return
+	}
+	if err := stats.StartProgress(opts.Progress, opts.CacheDir, 5*time.Second); err != nil {
+		log.Fatal(err)
+	}
+}
+
 func main() {
 	Main(PrintCmds)
 }
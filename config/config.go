@@ -6,31 +6,183 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/omniscale/imposm3/cache"
+	"github.com/omniscale/imposm3/expire"
 	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/memory"
+	"github.com/omniscale/imposm3/notify"
+	"github.com/omniscale/imposm3/priority"
+	"github.com/omniscale/imposm3/proj"
 )
 
 type Config struct {
 	CacheDir            string          `json:"cachedir"`
 	DiffDir             string          `json:"diffdir"`
-	Connection          string          `json:"connection"`
+	Connection          Connections     `json:"connection"`
 	MappingFile         string          `json:"mapping"`
 	LimitTo             string          `json:"limitto"`
 	LimitToCacheBuffer  float64         `json:"limitto_cache_buffer"`
 	Srid                int             `json:"srid"`
+	Proj                string          `json:"proj"`
 	Schemas             Schemas         `json:"schemas"`
 	ExpireTilesDir      string          `json:"expiretiles_dir"`
 	ExpireTilesZoom     int             `json:"expiretiles_zoom"`
+	ExpireTilesFormats  List            `json:"expiretiles_formats"`
 	ReplicationURL      string          `json:"replication_url"`
 	ReplicationInterval MinutesInterval `json:"replication_interval"`
 	DiffStateBefore     MinutesInterval `json:"diff_state_before"`
+	// Grants are the privileges for each role that are granted on all
+	// tables after the import and after each deployment.
+	Grants map[string][]string `json:"grants"`
+	// Buffers are the sizes of the channels between the stages of the
+	// import.
+	Buffers memory.Buffers `json:"buffers"`
+	// MaxMemory is the memory budget in MB, if -max-memory is not set.
+	MaxMemory int `json:"max_memory"`
+	// IndexJobs is the number of indices that are created in parallel, if
+	// -index-jobs is not set.
+	IndexJobs int `json:"index_jobs"`
+	// Cache are the memory sizes of the caches.
+	Cache cache.Sizes `json:"cache"`
+	// LowPriority are the limits of -low-priority.
+	LowPriority priority.Rates `json:"low_priority"`
+	// SkipUnchanged enables -skip-unchanged for diff imports.
+	SkipUnchanged bool `json:"skip_unchanged"`
+	// Changesets enables -changesets for imposm run.
+	Changesets bool `json:"changesets"`
+	// ChangesetReplicationURL is the URL of the changeset replication.
+	ChangesetReplicationURL string `json:"changeset_replication_url"`
+	// ExpireTilesWebhook receives the expired tiles after diff imports.
+	ExpireTilesWebhook expire.WebhookConfig `json:"expiretiles_webhook"`
+	// ExpireTilesMinZoom is the lowest zoom level of the expired tiles,
+	// expiretiles_zoom if not set.
+	ExpireTilesMinZoom *int `json:"expiretiles_minzoom"`
+	// ExpireTilesBuffer is the buffer around changed geometries, e.g.
+	// "16px" or "50m".
+	ExpireTilesBuffer expire.Buffer `json:"expiretiles_buffer"`
+	// SingleTransaction enables -single-transaction for imposm diff.
+	SingleTransaction bool `json:"single_transaction"`
+	// DiffWorkers is the number of parallel connections of diff imports,
+	// if -diff-workers is not set.
+	DiffWorkers int `json:"diff_workers"`
+	// DiffState is where the state of the last diff import is stored
+	// (file, database or both), if -diff-state is not set.
+	DiffState string `json:"diff_state"`
+	// NotifyURL is the NATS or AMQP URL that receives a summary of each
+	// diff import, if -notify is not set.
+	NotifyURL string `json:"notify_url"`
+	// ReuseGeometries enables -reuse-geometries for diff imports.
+	ReuseGeometries bool `json:"reuse_geometries"`
+	// LimitToLogSkipped enables -limitto-log-skipped for diff imports.
+	LimitToLogSkipped bool `json:"limitto_log_skipped"`
+	// Replication configures the requests to the replication_url.
+	Replication Replication `json:"replication"`
+	// CatchUpBatch is the maximum number of diffs that imposm run merges
+	// into one import, if -catch-up-batch is not set.
+	CatchUpBatch int `json:"catch_up_batch"`
+	// DeadLetters is the file for elements that could not be inserted by
+	// diff imports, if -dead-letters is not set.
+	DeadLetters string `json:"dead_letters"`
+	// AllowMappingChanges continues diff imports with a mapping that is
+	// incompatible to the mapping of the import, if set.
+	AllowMappingChanges bool `json:"allow_mapping_changes"`
+	// ExpireTilesRotation compresses and removes old expire tiles.
+	ExpireTilesRotation expire.RotationConfig `json:"expiretiles_rotation"`
+}
+
+// Connections is a list of connection parameters. It is decoded from a
+// single JSON string or from a list of strings.
+type Connections []string
+
+func (c *Connections) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*c = Connections{s}
+		if s == "" {
+			*c = nil
+		}
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return errors.New("connection needs to be a string or a list of strings")
+	}
+	*c = l
+	return nil
+}
+
+func (c *Connections) String() string {
+	return strings.Join(*c, ", ")
+}
+
+// Set appends the connection, for repeated -connection options.
+func (c *Connections) Set(v string) error {
+	*c = append(*c, v)
+	return nil
+}
+
+// List is a list of comma separated values, e.g. for -expiretiles-formats.
+// It is decoded from a JSON list.
+type List []string
+
+func (l *List) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set sets all comma separated values.
+func (l *List) Set(v string) error {
+	*l = nil
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			*l = append(*l, part)
+		}
+	}
+	return nil
+}
+
+// Files is a list of file names, for repeated options.
+type Files []string
+
+func (f *Files) String() string {
+	return strings.Join(*f, ", ")
+}
+
+// Set appends the file name. An empty name removes all previous files.
+func (f *Files) Set(v string) error {
+	if v == "" {
+		*f = nil
+		return nil
+	}
+	*f = append(*f, v)
+	return nil
 }
 
 type Schemas struct {
 	Import     string `json:"import"`
 	Production string `json:"production"`
 	Backup     string `json:"backup"`
+	// BackupGenerations is the number of backups that are kept during
+	// deployment.
+	BackupGenerations int `json:"backup_generations"`
+}
+
+// Replication configures the requests to the replication server, e.g. for
+// mirrors behind an authentication proxy.
+type Replication struct {
+	// Username and Password are sent with HTTP basic auth.
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Token is sent as bearer token.
+	Token string `json:"token"`
+	// Headers are added to each request.
+	Headers map[string]string `json:"headers"`
+	// Checksum is md5 or sha256 to verify each diff with the checksum
+	// file next to it (e.g. 123.osc.gz.sha256).
+	Checksum string `json:"checksum"`
 }
 
 const defaultSrid = 3857
@@ -38,25 +190,84 @@ const defaultCacheDir = "/tmp/imposm3"
 const defaultSchemaImport = "import"
 const defaultSchemaProduction = "public"
 const defaultSchemaBackup = "backup"
+const defaultChangesetReplicationURL = "https://planet.openstreetmap.org/replication/changesets/"
+const defaultBackupGenerations = 1
 
 type Base struct {
+	// Connection is the first connection of Connections.
 	Connection          string
+	Connections         Connections
 	CacheDir            string
 	DiffDir             string
 	MappingFile         string
 	Srid                int
+	Proj                string
 	LimitTo             string
 	LimitToCacheBuffer  float64
 	ConfigFile          string
 	HTTPProfile         string
+	HTTPMetrics         string
+	MaxMemory           int
+	Progress            string
 	Quiet               bool
 	Schemas             Schemas
 	ExpireTilesDir      string
 	ExpireTilesZoom     int
+	ExpireTilesMinZoom  int
+	ExpireTilesBuffer   expire.Buffer
+	ExpireTilesFormats  List
+	ExpireTilesWebhook  expire.WebhookConfig
 	ReplicationURL      string
 	ReplicationInterval time.Duration
 	DiffStateBefore     time.Duration
 	ForceDiffImport     bool
+	Grants              map[string][]string
+	Buffers             memory.Buffers
+	IndexJobs           int
+	CacheSizes          cache.Sizes
+	LowPriority         bool
+	LowPriorityRates    priority.Rates
+	// SkipUnchanged skips the delete and insert of rows that are not
+	// changed by a diff import.
+	SkipUnchanged bool
+	// Changesets imports the changeset replication into the changesets
+	// table.
+	Changesets              bool
+	ChangesetReplicationURL string
+	// SingleTransaction applies all change files of a diff import in a
+	// single database transaction.
+	SingleTransaction bool
+	// DiffWorkers is the number of connections that update the tables
+	// of diff imports in parallel.
+	DiffWorkers int
+	// DiffState is where the state of the last diff import is stored:
+	// in last.state.txt of the DiffDir (file), in the database
+	// (database) or both.
+	DiffState string
+	// NotifyURL is the nats:// or amqp:// URL that receives a summary of
+	// each diff import.
+	NotifyURL string
+	// ReuseGeometries stores the geometries of ways and relations in the
+	// diff cache and reuses them if only tags are modified.
+	ReuseGeometries bool
+	// LimitToLogSkipped logs each element of a diff import that is
+	// skipped, as it is outside of LimitTo.
+	LimitToLogSkipped bool
+	// Replication configures the requests to the ReplicationURL.
+	Replication Replication
+	// CatchUpBatch is the maximum number of downloaded diffs that imposm
+	// run merges into a single import while it is behind.
+	CatchUpBatch int
+	// DeadLetters is the file that records the elements that could not be
+	// inserted by diff imports. The import continues with the next
+	// element.
+	DeadLetters string
+	// AllowMappingChanges only warns if the mapping of diff imports is
+	// incompatible to the mapping of the import.
+	AllowMappingChanges bool
+	// ExpireTilesRotation compresses and removes old files of
+	// ExpireTilesDir.
+	ExpireTilesRotation expire.RotationConfig
 }
 
 func (o *Base) updateFromConfig() error {
@@ -87,9 +298,35 @@ func (o *Base) updateFromConfig() error {
 	if conf.Schemas.Backup != "" && o.Schemas.Backup == defaultSchemaBackup {
 		o.Schemas.Backup = conf.Schemas.Backup
 	}
+	if conf.Schemas.BackupGenerations != 0 && o.Schemas.BackupGenerations == defaultBackupGenerations {
+		o.Schemas.BackupGenerations = conf.Schemas.BackupGenerations
+	}
+	if o.Schemas.BackupGenerations < 1 {
+		return errors.New("backup_generations needs to be 1 or larger")
+	}
+
+	o.Grants = conf.Grants
+
+	if conf.Buffers.Read < 0 || conf.Buffers.Cache < 0 || conf.Buffers.Database < 0 {
+		return errors.New("buffers need to be 0 (default) or larger")
+	}
+	o.Buffers = conf.Buffers
+
+	if conf.MaxMemory < 0 || conf.IndexJobs < 0 {
+		return errors.New("max_memory and index_jobs need to be 0 (default) or larger")
+	}
+	if o.MaxMemory == 0 {
+		o.MaxMemory = conf.MaxMemory
+	}
+	o.IndexJobs = conf.IndexJobs
+	o.CacheSizes = conf.Cache
+	o.LowPriorityRates = conf.LowPriority
 
-	if o.Connection == "" {
-		o.Connection = conf.Connection
+	if len(o.Connections) == 0 {
+		o.Connections = conf.Connection
+	}
+	if len(o.Connections) > 0 {
+		o.Connection = o.Connections[0]
 	}
 	if conf.Srid == 0 {
 		conf.Srid = defaultSrid
@@ -100,6 +337,9 @@ func (o *Base) updateFromConfig() error {
 	if o.MappingFile == "" {
 		o.MappingFile = conf.MappingFile
 	}
+	if o.Proj == "" {
+		o.Proj = conf.Proj
+	}
 	if o.LimitTo == "" {
 		o.LimitTo = conf.LimitTo
 	}
@@ -123,6 +363,23 @@ func (o *Base) updateFromConfig() error {
 	if o.ExpireTilesZoom < 6 || o.ExpireTilesZoom > 18 {
 		o.ExpireTilesZoom = 14
 	}
+	if o.ExpireTilesMinZoom < 0 && conf.ExpireTilesMinZoom != nil {
+		o.ExpireTilesMinZoom = *conf.ExpireTilesMinZoom
+	}
+	if o.ExpireTilesMinZoom < 0 || o.ExpireTilesMinZoom > o.ExpireTilesZoom {
+		o.ExpireTilesMinZoom = o.ExpireTilesZoom
+	}
+	if o.ExpireTilesBuffer.Value == 0 {
+		o.ExpireTilesBuffer = conf.ExpireTilesBuffer
+	}
+	if len(o.ExpireTilesFormats) == 0 {
+		o.ExpireTilesFormats = conf.ExpireTilesFormats
+	}
+	webhookURL := o.ExpireTilesWebhook.URL
+	o.ExpireTilesWebhook = conf.ExpireTilesWebhook
+	if webhookURL != "" {
+		o.ExpireTilesWebhook.URL = webhookURL
+	}
 
 	if conf.ReplicationInterval.Duration != 0 && o.ReplicationInterval == time.Minute {
 		o.ReplicationInterval = conf.ReplicationInterval.Duration
@@ -144,47 +401,187 @@ func (o *Base) updateFromConfig() error {
 	if conf.DiffStateBefore.Duration != 0 && o.DiffStateBefore == 0 {
 		o.DiffStateBefore = conf.DiffStateBefore.Duration
 	}
+	if conf.SkipUnchanged {
+		o.SkipUnchanged = true
+	}
+	if conf.Changesets {
+		o.Changesets = true
+	}
+	if conf.SingleTransaction {
+		o.SingleTransaction = true
+	}
+	if o.DiffWorkers == 0 {
+		o.DiffWorkers = conf.DiffWorkers
+	}
+	if o.DiffState == "" {
+		o.DiffState = conf.DiffState
+	}
+	if o.DiffState == "" {
+		o.DiffState = "file"
+	}
+	if o.NotifyURL == "" {
+		o.NotifyURL = conf.NotifyURL
+	}
+	if conf.ReuseGeometries {
+		o.ReuseGeometries = true
+	}
+	if conf.LimitToLogSkipped {
+		o.LimitToLogSkipped = true
+	}
+	o.Replication = conf.Replication
+	if o.CatchUpBatch == 0 {
+		o.CatchUpBatch = conf.CatchUpBatch
+	}
+	if o.DeadLetters == "" {
+		o.DeadLetters = conf.DeadLetters
+	}
+	if conf.AllowMappingChanges {
+		o.AllowMappingChanges = true
+	}
+	if conf.ExpireTilesRotation.Compress {
+		o.ExpireTilesRotation.Compress = true
+	}
+	if o.ExpireTilesRotation.MaxDays == 0 {
+		o.ExpireTilesRotation.MaxDays = conf.ExpireTilesRotation.MaxDays
+	}
+	if o.ExpireTilesRotation.MaxSizeMB == 0 {
+		o.ExpireTilesRotation.MaxSizeMB = conf.ExpireTilesRotation.MaxSizeMB
+	}
+	o.ChangesetReplicationURL = conf.ChangesetReplicationURL
+	if o.ChangesetReplicationURL == "" {
+		o.ChangesetReplicationURL = defaultChangesetReplicationURL
+	}
 	return nil
 }
 
+// DiffStateInFile returns whether the diff state is stored in
+// last.state.txt.
+func (o *Base) DiffStateInFile() bool {
+	return o.DiffState != "database"
+}
+
+// DiffStateInDatabase returns whether the diff state is stored in the
+// database.
+func (o *Base) DiffStateInDatabase() bool {
+	return o.DiffState == "database" || o.DiffState == "both"
+}
+
 func (o *Base) check() []error {
 	errs := []error{}
-	if o.Srid != 3857 && o.Srid != 4326 {
-		errs = append(errs, errors.New("only -srid=3857 or -srid=4326 are supported"))
+	if o.Proj != "" {
+		if err := proj.Register(o.Srid, o.Proj); err != nil {
+			errs = append(errs, err)
+		}
+	} else if !proj.Supported(o.Srid) {
+		errs = append(errs, fmt.Errorf("unsupported -srid=%d, set the projection with -proj", o.Srid))
 	}
 	if o.MappingFile == "" {
 		errs = append(errs, errors.New("missing mapping"))
 	}
+	if err := expire.CheckFormats(o.ExpireTilesFormats); err != nil {
+		errs = append(errs, err)
+	}
+	if o.DiffState != "file" && o.DiffState != "database" && o.DiffState != "both" {
+		errs = append(errs, fmt.Errorf("unknown -diff-state %q, expected file, database or both", o.DiffState))
+	}
+	if o.ExpireTilesRotation.MaxDays < 0 || o.ExpireTilesRotation.MaxSizeMB < 0 {
+		errs = append(errs, errors.New("-expiretiles-max-days and -expiretiles-max-size need to be positive"))
+	}
+	if o.CatchUpBatch < 0 {
+		errs = append(errs, errors.New("-catch-up-batch needs to be positive"))
+	}
+	if o.DiffWorkers < 0 {
+		errs = append(errs, errors.New("-diff-workers needs to be positive"))
+	}
+	if o.DeadLetters != "" && o.DiffWorkers > 1 {
+		// workers report errors only at the end of the transaction
+		errs = append(errs, errors.New("-dead-letters can not be combined with -diff-workers"))
+	}
+	if o.SingleTransaction && o.DiffWorkers > 1 {
+		// each worker commits its own transaction
+		errs = append(errs, errors.New("-single-transaction can not be combined with -diff-workers"))
+	}
+	if o.NotifyURL != "" {
+		if _, err := notify.New(o.NotifyURL); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c := o.Replication.Checksum; c != "" && c != "md5" && c != "sha256" {
+		errs = append(errs, fmt.Errorf("unknown replication checksum %q, expected md5 or sha256", c))
+	}
+	if o.Progress == "json" {
+		for _, c := range o.Connections {
+			if writesStdout(c) {
+				// progress records would be mixed into the output
+				errs = append(errs, fmt.Errorf("-progress json can not be combined with the output to stdout of %q, use -progress json:/path/to/socket", c))
+			}
+		}
+	}
 	return errs
 }
 
+// writesStdout returns whether the output of the connection is written to
+// stdout (geojsonseq:- and pgdump:-).
+func writesStdout(connection string) bool {
+	if connection == "geojsonseq:-" {
+		return true
+	}
+	if strings.HasPrefix(connection, "pgdump:") {
+		fields := strings.Fields(strings.TrimPrefix(connection, "pgdump:"))
+		return len(fields) > 0 && fields[0] == "-"
+	}
+	return false
+}
+
 type Import struct {
 	Base             Base
 	Overwritecache   bool
 	Appendcache      bool
-	Read             string
+	Read             string // first file of ReadFiles
+	ReadFiles        Files
+	BBox             string
+	LowDisk          bool
 	Write            bool
+	DryRun           bool
 	Optimize         bool
 	Diff             bool
 	DeployProduction bool
 	RevertDeploy     bool
 	RemoveBackup     bool
+	Unlogged         bool
+	KeepUnlogged     bool
+	IndexJobs        int
+	TileServerConfig string
+	// OnlyTables are the tables of -only-tables, all tables if empty.
+	OnlyTables []string
+	Resume     bool
+	Report     string
+	// CheckGeometries reports invalid, empty and degenerate geometries
+	// after -write.
+	CheckGeometries bool
 }
 
 func addBaseFlags(opts *Base, flags *flag.FlagSet) {
-	flags.StringVar(&opts.Connection, "connection", "", "connection parameters")
+	flags.Var(&opts.Connections, "connection", "connection parameters (repeat for multiple outputs)")
 	flags.StringVar(&opts.CacheDir, "cachedir", defaultCacheDir, "cache directory")
 	flags.StringVar(&opts.DiffDir, "diffdir", "", "diff directory for last.state.txt")
+	flags.StringVar(&opts.DiffState, "diff-state", "", "store the state of the last diff import in a file, the database or both (default file)")
 	flags.StringVar(&opts.MappingFile, "mapping", "", "mapping file")
 	flags.IntVar(&opts.Srid, "srid", defaultSrid, "srs id")
+	flags.StringVar(&opts.Proj, "proj", "", "proj string of -srid, for projections that are not built-in")
 	flags.StringVar(&opts.LimitTo, "limitto", "", "limit to geometries")
 	flags.Float64Var(&opts.LimitToCacheBuffer, "limittocachebuffer", 0.0, "limit to buffer for cache")
 	flags.StringVar(&opts.ConfigFile, "config", "", "config (json)")
 	flags.StringVar(&opts.HTTPProfile, "httpprofile", "", "bind address for profile server")
+	flags.StringVar(&opts.HTTPMetrics, "httpmetrics", "", "bind address for Prometheus metrics server")
+	flags.IntVar(&opts.MaxMemory, "max-memory", 0, "memory budget in MB, reduces buffers and workers")
+	flags.StringVar(&opts.Progress, "progress", "", "write progress records as JSON (json for stdout, json:/path/to/socket; outputs to stdout require the socket)")
 	flags.BoolVar(&opts.Quiet, "quiet", false, "quiet log output")
+	flags.BoolVar(&opts.LowPriority, "low-priority", false, "use fewer CPUs and limit the write rates to the database and the cache")
 	flags.StringVar(&opts.Schemas.Import, "dbschema-import", defaultSchemaImport, "db schema for imports")
 	flags.StringVar(&opts.Schemas.Production, "dbschema-production", defaultSchemaProduction, "db schema for production")
 	flags.StringVar(&opts.Schemas.Backup, "dbschema-backup", defaultSchemaBackup, "db schema for backups")
+	flags.IntVar(&opts.Schemas.BackupGenerations, "backup-generations", defaultBackupGenerations, "number of backups to keep")
 }
 
 func ParseImport(args []string) Import {
@@ -194,13 +591,24 @@ func ParseImport(args []string) Import {
 	addBaseFlags(&opts.Base, flags)
 	flags.BoolVar(&opts.Overwritecache, "overwritecache", false, "overwritecache")
 	flags.BoolVar(&opts.Appendcache, "appendcache", false, "append cache")
-	flags.StringVar(&opts.Read, "read", "", "read")
+	flags.Var(&opts.ReadFiles, "read", "read PBF file or URL (repeat for multiple files)")
+	flags.StringVar(&opts.BBox, "bbox", "", "read only elements in bbox (minlon,minlat,maxlon,maxlat)")
+	flags.BoolVar(&opts.LowDisk, "low-disk", false, "only cache ways of relations, read all other ways again from the file for -write")
 	flags.BoolVar(&opts.Write, "write", false, "write")
+	flags.BoolVar(&opts.DryRun, "dry-run", false, "map all elements and count the rows of each table, without writing to the database")
 	flags.BoolVar(&opts.Optimize, "optimize", false, "optimize")
 	flags.BoolVar(&opts.Diff, "diff", false, "enable diff support")
 	flags.BoolVar(&opts.DeployProduction, "deployproduction", false, "deploy production")
 	flags.BoolVar(&opts.RevertDeploy, "revertdeploy", false, "revert deploy to production")
 	flags.BoolVar(&opts.RemoveBackup, "removebackup", false, "remove backups from deploy")
+	flags.BoolVar(&opts.Unlogged, "unlogged", false, "create unlogged tables and convert them after the import")
+	flags.BoolVar(&opts.KeepUnlogged, "keep-unlogged", false, "create unlogged tables and keep them unlogged")
+	flags.IntVar(&opts.IndexJobs, "index-jobs", 0, "number of indices to create in parallel (default: number of CPUs)")
+	flags.StringVar(&opts.Report, "report", "", "write the duration and throughput of each phase as JSON into this file")
+	flags.BoolVar(&opts.Resume, "resume", false, "resume an interrupted import, skip completed phases (no checkpoints within a phase)")
+	flags.StringVar(&opts.TileServerConfig, "tileserver-config", "", "write tile server config (tegola:file or martin:file)")
+	flags.BoolVar(&opts.CheckGeometries, "check-geometries", false, "report invalid, empty and degenerate geometries after -write")
+	onlyTables := flags.String("only-tables", "", "only write and deploy these tables (comma separated), from the existing cache")
 	flags.DurationVar(&opts.Base.DiffStateBefore, "diff-state-before", 0, "set initial diff sequence before")
 	flags.DurationVar(&opts.Base.ReplicationInterval, "replication-interval", time.Minute, "replication interval as duration (1m, 1h, 24h)")
 
@@ -218,10 +626,21 @@ func ParseImport(args []string) Import {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if len(opts.ReadFiles) > 0 {
+		opts.Read = opts.ReadFiles[0]
+	}
+	for _, name := range strings.Split(*onlyTables, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			opts.OnlyTables = append(opts.OnlyTables, name)
+		}
+	}
 	err = opts.Base.updateFromConfig()
 	if err != nil {
 		log.Fatal(err)
 	}
+	if opts.IndexJobs == 0 {
+		opts.IndexJobs = opts.Base.IndexJobs
+	}
 	errs := opts.Base.check()
 	if len(errs) != 0 {
 		reportErrors(errs)
@@ -236,11 +655,26 @@ func ParseDiffImport(args []string) (Base, []string) {
 
 	addBaseFlags(&opts, flags)
 	flags.StringVar(&opts.ExpireTilesDir, "expiretiles-dir", "", "write expire tiles into dir")
-	flags.IntVar(&opts.ExpireTilesZoom, "expiretiles-zoom", 14, "write expire tiles in this zoom level")
+	flags.IntVar(&opts.ExpireTilesZoom, "expiretiles-zoom", 14, "write expire tiles in this (max) zoom level")
+	flags.IntVar(&opts.ExpireTilesMinZoom, "expiretiles-minzoom", -1, "also write parent expire tiles down to this zoom level (default -expiretiles-zoom)")
+	flags.Var(&opts.ExpireTilesBuffer, "expiretiles-buffer", "buffer around changed geometries in pixels or meters (e.g. 16px, 50m)")
+	flags.Var(&opts.ExpireTilesFormats, "expiretiles-formats", "comma separated formats of expire tiles (tiles, geojson, quadkey, bitmap, polygons)")
+	flags.StringVar(&opts.ExpireTilesWebhook.URL, "expiretiles-webhook", "", "post expired tiles to this URL")
+	flags.BoolVar(&opts.ExpireTilesRotation.Compress, "expiretiles-compress", false, "compress the expire tiles of each day into a tar.gz archive")
+	flags.IntVar(&opts.ExpireTilesRotation.MaxDays, "expiretiles-max-days", 0, "remove expire tiles that are older than this number of days")
+	flags.IntVar(&opts.ExpireTilesRotation.MaxSizeMB, "expiretiles-max-size", 0, "remove the oldest expire tiles if -expiretiles-dir is larger (in MB)")
 	flags.BoolVar(&opts.ForceDiffImport, "force", false, "force import of diff if sequence was already imported")
+	flags.BoolVar(&opts.SkipUnchanged, "skip-unchanged", false, "skip rows that are not changed by the diff")
+	flags.BoolVar(&opts.ReuseGeometries, "reuse-geometries", false, "reuse the geometries of ways and relations if only tags are modified")
+	flags.BoolVar(&opts.LimitToLogSkipped, "limitto-log-skipped", false, "log diff elements that are skipped as they are outside of limitto")
+	flags.StringVar(&opts.DeadLetters, "dead-letters", "", "record elements that could not be inserted in this file and continue")
+	flags.BoolVar(&opts.AllowMappingChanges, "allow-mapping-changes", false, "only warn if the mapping is incompatible to the mapping of the import")
+	flags.IntVar(&opts.DiffWorkers, "diff-workers", 0, "number of connections that update the tables in parallel (default: single transaction)")
+	flags.BoolVar(&opts.SingleTransaction, "single-transaction", false, "apply all diff files in a single transaction")
+	flags.StringVar(&opts.NotifyURL, "notify", "", "publish a summary of each diff import to this nats:// or amqp:// URL")
 
 	flags.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s %s [args] [.osc.gz, ...]\n\n", os.Args[0], os.Args[1])
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [args] [.osc.gz, ... | -]\n\n", os.Args[0], os.Args[1])
 		flags.PrintDefaults()
 		os.Exit(2)
 	}
@@ -260,6 +694,15 @@ func ParseDiffImport(args []string) (Base, []string) {
 	}
 
 	errs := opts.check()
+	stdin := 0
+	for _, f := range flags.Args() {
+		if f == "-" {
+			stdin++
+		}
+	}
+	if stdin > 1 {
+		errs = append(errs, errors.New("stdin (-) can only be read once"))
+	}
 	if len(errs) != 0 {
 		reportErrors(errs)
 		flags.Usage()
@@ -274,8 +717,24 @@ func ParseRunImport(args []string) Base {
 
 	addBaseFlags(&opts, flags)
 	flags.StringVar(&opts.ExpireTilesDir, "expiretiles-dir", "", "write expire tiles into dir")
-	flags.IntVar(&opts.ExpireTilesZoom, "expiretiles-zoom", 14, "write expire tiles in this zoom level")
-	flags.DurationVar(&opts.ReplicationInterval, "replication-interval", time.Minute, "replication interval as duration (1m, 1h, 24h)")
+	flags.IntVar(&opts.ExpireTilesZoom, "expiretiles-zoom", 14, "write expire tiles in this (max) zoom level")
+	flags.IntVar(&opts.ExpireTilesMinZoom, "expiretiles-minzoom", -1, "also write parent expire tiles down to this zoom level (default -expiretiles-zoom)")
+	flags.Var(&opts.ExpireTilesBuffer, "expiretiles-buffer", "buffer around changed geometries in pixels or meters (e.g. 16px, 50m)")
+	flags.Var(&opts.ExpireTilesFormats, "expiretiles-formats", "comma separated formats of expire tiles (tiles, geojson, quadkey, bitmap, polygons)")
+	flags.StringVar(&opts.ExpireTilesWebhook.URL, "expiretiles-webhook", "", "post expired tiles to this URL")
+	flags.BoolVar(&opts.ExpireTilesRotation.Compress, "expiretiles-compress", false, "compress the expire tiles of each day into a tar.gz archive")
+	flags.IntVar(&opts.ExpireTilesRotation.MaxDays, "expiretiles-max-days", 0, "remove expire tiles that are older than this number of days")
+	flags.IntVar(&opts.ExpireTilesRotation.MaxSizeMB, "expiretiles-max-size", 0, "remove the oldest expire tiles if -expiretiles-dir is larger (in MB)")
+	flags.DurationVar(&opts.ReplicationInterval, "replication-interval", time.Minute, "replication interval as duration (1m, 1h, 24h), if it can't be detected from the server")
+	flags.BoolVar(&opts.SkipUnchanged, "skip-unchanged", false, "skip rows that are not changed by the diff")
+	flags.BoolVar(&opts.ReuseGeometries, "reuse-geometries", false, "reuse the geometries of ways and relations if only tags are modified")
+	flags.BoolVar(&opts.LimitToLogSkipped, "limitto-log-skipped", false, "log diff elements that are skipped as they are outside of limitto")
+	flags.StringVar(&opts.DeadLetters, "dead-letters", "", "record elements that could not be inserted in this file and continue")
+	flags.BoolVar(&opts.AllowMappingChanges, "allow-mapping-changes", false, "only warn if the mapping is incompatible to the mapping of the import")
+	flags.IntVar(&opts.DiffWorkers, "diff-workers", 0, "number of connections that update the tables in parallel (default: single transaction)")
+	flags.BoolVar(&opts.Changesets, "changesets", false, "import changesets into the changesets table")
+	flags.IntVar(&opts.CatchUpBatch, "catch-up-batch", 0, "merge up to this number of downloaded diffs into one import while behind")
+	flags.StringVar(&opts.NotifyURL, "notify", "", "publish a summary of each diff import to this nats:// or amqp:// URL")
 
 	flags.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s %s [args] [.osc.gz, ...]\n\n", os.Args[0], os.Args[1])
@@ -306,6 +765,243 @@ func ParseRunImport(args []string) Base {
 	return opts
 }
 
+// CheckGeometries are the options of imposm check-geometries.
+type CheckGeometries struct {
+	Base Base
+	// ImportSchema checks the tables in the import schema instead of the
+	// production schema.
+	ImportSchema bool
+	// Limit is the number of reported features of each table.
+	Limit int
+	// MinSize is the size in meters of degenerate geometries: polygons
+	// with a smaller area than MinSize² and shorter lines.
+	MinSize float64
+}
+
+func ParseCheckGeometries(args []string) CheckGeometries {
+	flags := flag.NewFlagSet("check-geometries", flag.ExitOnError)
+	opts := CheckGeometries{}
+
+	addBaseFlags(&opts.Base, flags)
+	flags.BoolVar(&opts.ImportSchema, "import-schema", false, "check the tables in the import schema instead of the production schema")
+	flags.IntVar(&opts.Limit, "limit", 10, "number of reported features of each table")
+	flags.Float64Var(&opts.MinSize, "min-size", 0.01, "report polygons with a smaller area than min-size² and shorter lines (in meters)")
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [args]\n\n", os.Args[0], os.Args[1])
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+
+	if len(args) == 0 {
+		flags.Usage()
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = opts.Base.updateFromConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	errs := opts.Base.check()
+	if len(opts.Base.Connections) == 0 {
+		errs = append(errs, errors.New("missing connection"))
+	}
+	if opts.Limit < 0 || opts.MinSize < 0 {
+		errs = append(errs, errors.New("-limit and -min-size need to be positive"))
+	}
+	if len(errs) != 0 {
+		reportErrors(errs)
+		flags.Usage()
+	}
+	return opts
+}
+
+// Query are the options of imposm query.
+type Query struct {
+	Base Base
+	// Node, Way or Relation is the OSM id of the queried element.
+	Node     int64
+	Way      int64
+	Relation int64
+	// ImportSchema queries the tables in the import schema instead of the
+	// production schema.
+	ImportSchema bool
+}
+
+func ParseQuery(args []string) Query {
+	flags := flag.NewFlagSet("query", flag.ExitOnError)
+	opts := Query{}
+
+	addBaseFlags(&opts.Base, flags)
+	flags.Int64Var(&opts.Node, "node", 0, "OSM id of the node")
+	flags.Int64Var(&opts.Way, "way", 0, "OSM id of the way")
+	flags.Int64Var(&opts.Relation, "relation", 0, "OSM id of the relation")
+	flags.BoolVar(&opts.ImportSchema, "import-schema", false, "query the tables in the import schema instead of the production schema")
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [args]\n\n", os.Args[0], os.Args[1])
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+
+	if len(args) == 0 {
+		flags.Usage()
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = opts.Base.updateFromConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	errs := opts.Base.check()
+	n := 0
+	for _, id := range []int64{opts.Node, opts.Way, opts.Relation} {
+		if id != 0 {
+			n++
+		}
+	}
+	if n != 1 {
+		errs = append(errs, errors.New("need one of -node, -way or -relation"))
+	}
+	if len(errs) != 0 {
+		reportErrors(errs)
+		flags.Usage()
+	}
+	return opts
+}
+
+// Analyze are the options of imposm analyze.
+type Analyze struct {
+	Base Base
+	// Read is the PBF, o5m or OSM XML file (or URL) to analyze.
+	Read string
+	// Limit is the number of reported keys.
+	Limit int
+	// Values is the number of reported values of each key.
+	Values int
+	// MinCount is the number of elements of unmapped tags to report
+	// them as frequent.
+	MinCount int64
+}
+
+func ParseAnalyze(args []string) Analyze {
+	flags := flag.NewFlagSet("analyze", flag.ExitOnError)
+	opts := Analyze{}
+
+	addBaseFlags(&opts.Base, flags)
+	flags.StringVar(&opts.Read, "read", "", "read PBF file or URL")
+	flags.IntVar(&opts.Limit, "limit", 50, "number of reported keys")
+	flags.IntVar(&opts.Values, "values", 10, "number of reported values of each key")
+	flags.Int64Var(&opts.MinCount, "min-count", 1000, "report unmapped tags of at least min-count elements")
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [args]\n\n", os.Args[0], os.Args[1])
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+
+	if len(args) == 0 {
+		flags.Usage()
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = opts.Base.updateFromConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	errs := opts.Base.check()
+	if opts.Read == "" {
+		errs = append(errs, errors.New("missing -read"))
+	}
+	if opts.Limit < 0 || opts.Values < 0 || opts.MinCount < 0 {
+		errs = append(errs, errors.New("-limit, -values and -min-count need to be positive"))
+	}
+	if len(errs) != 0 {
+		reportErrors(errs)
+		flags.Usage()
+	}
+	return opts
+}
+
+// SetSequence are the options of imposm replication set-sequence.
+type SetSequence struct {
+	Base Base
+	// Sequence is the new sequence of the last imported diff.
+	Sequence int
+	// RewindHours sets the sequence to the diff that is this number of
+	// hours before the last imported diff, instead of Sequence.
+	RewindHours int
+	// Force allows to set a sequence after the last imported diff.
+	Force bool
+}
+
+func ParseSetSequence(args []string) SetSequence {
+	flags := flag.NewFlagSet("set-sequence", flag.ExitOnError)
+	opts := SetSequence{}
+
+	addBaseFlags(&opts.Base, flags)
+	flags.IntVar(&opts.RewindHours, "rewind-hours", 0, "rewind to the diff this number of hours before the last imported diff")
+	flags.BoolVar(&opts.Force, "force", false, "allow sequences after the last imported diff (skips diffs)")
+	flags.DurationVar(&opts.Base.ReplicationInterval, "replication-interval", time.Minute, "replication interval as duration (1m, 1h, 24h), if it can't be detected from the server")
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s replication set-sequence [args] [sequence]\n\n", os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+
+	if len(args) == 0 {
+		flags.Usage()
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = opts.Base.updateFromConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	errs := opts.Base.check()
+	switch {
+	case flags.NArg() == 0 && opts.RewindHours <= 0:
+		errs = append(errs, errors.New("missing sequence or -rewind-hours"))
+	case flags.NArg() > 0 && opts.RewindHours != 0:
+		errs = append(errs, errors.New("sequence can not be combined with -rewind-hours"))
+	case flags.NArg() > 1:
+		errs = append(errs, errors.New("only one sequence allowed"))
+	case flags.NArg() == 1:
+		seq, err := strconv.Atoi(flags.Arg(0))
+		if err != nil || seq <= 0 {
+			errs = append(errs, fmt.Errorf("invalid sequence %q", flags.Arg(0)))
+		}
+		opts.Sequence = seq
+	}
+	if len(errs) != 0 {
+		reportErrors(errs)
+		flags.Usage()
+	}
+
+	return opts
+}
+
 func reportErrors(errs []error) {
 	fmt.Println("errors in config/options:")
 	for _, err := range errs {
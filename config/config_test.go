@@ -0,0 +1,97 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/omniscale/imposm3/proj"
+)
+
+func TestConnectionsUnmarshal(t *testing.T) {
+	for _, tc := range []struct {
+		json     string
+		expected Connections
+	}{
+		{`{}`, nil},
+		{`{"connection": ""}`, nil},
+		{`{"connection": "postgis://localhost"}`, Connections{"postgis://localhost"}},
+		{`{"connection": ["postgis://localhost", "flatgeobuf:/tmp/out"]}`, Connections{"postgis://localhost", "flatgeobuf:/tmp/out"}},
+	} {
+		conf := Config{}
+		if err := json.Unmarshal([]byte(tc.json), &conf); err != nil {
+			t.Fatal(tc.json, err)
+		}
+		if !reflect.DeepEqual(conf.Connection, tc.expected) {
+			t.Errorf("%s: unexpected connections %#v", tc.json, conf.Connection)
+		}
+	}
+
+	conf := Config{}
+	if err := json.Unmarshal([]byte(`{"connection": 1}`), &conf); err == nil {
+		t.Error("expected error for invalid connection")
+	}
+}
+
+func TestParseImportReadFiles(t *testing.T) {
+	opts := ParseImport([]string{"-read", "a.osm.pbf", "-read", "b.osm.pbf", "-mapping", "mapping.yml", "-connection", "postgis://localhost"})
+	if !reflect.DeepEqual(opts.ReadFiles, Files{"a.osm.pbf", "b.osm.pbf"}) || opts.Read != "a.osm.pbf" {
+		t.Errorf("unexpected read files %#v %q", opts.ReadFiles, opts.Read)
+	}
+
+	opts = ParseImport([]string{"-read", "a.osm.pbf", "-read=", "-mapping", "mapping.yml", "-connection", "postgis://localhost"})
+	if len(opts.ReadFiles) != 0 || opts.Read != "" {
+		t.Errorf("unexpected read files %#v %q", opts.ReadFiles, opts.Read)
+	}
+}
+
+func TestParseImportConfigFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "imposm-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"mapping": "mapping.yml", "max_memory": 4000, "index_jobs": 2, "cache": {"lru_mb": 64}}`)
+	f.Close()
+
+	opts := ParseImport([]string{"-config", f.Name()})
+	if opts.Base.MaxMemory != 4000 || opts.IndexJobs != 2 || opts.Base.CacheSizes.LRUMB != 64 {
+		t.Errorf("unexpected options %+v", opts)
+	}
+
+	opts = ParseImport([]string{"-config", f.Name(), "-max-memory", "2000", "-index-jobs", "8"})
+	if opts.Base.MaxMemory != 2000 || opts.IndexJobs != 8 {
+		t.Errorf("unexpected options %+v", opts)
+	}
+}
+
+func TestParseImportProj(t *testing.T) {
+	opts := ParseImport([]string{"-mapping", "mapping.yml", "-connection", "postgis://localhost", "-srid", "31467",
+		"-proj", "+proj=tmerc +lat_0=0 +lon_0=9 +k=1 +x_0=3500000 +y_0=0 +datum=potsdam +units=m"})
+	if opts.Base.Srid != 31467 || !proj.Supported(31467) {
+		t.Errorf("projection of 31467 not registered")
+	}
+}
+
+func TestCheckProgressStdout(t *testing.T) {
+	for _, tc := range []struct {
+		progress    string
+		connections []string
+		fails       bool
+	}{
+		{"json", []string{"postgis://localhost"}, false},
+		{"json", []string{"postgis://localhost", "geojsonseq:-"}, true},
+		{"json", []string{"pgdump:- prefix=osm_"}, true},
+		{"json", []string{"pgdump:/tmp/import.sql"}, false},
+		{"json:/tmp/progress.sock", []string{"geojsonseq:-"}, false},
+		{"", []string{"geojsonseq:-"}, false},
+	} {
+		opts := Base{MappingFile: "mapping.yml", Srid: 3857, DiffState: "file", Progress: tc.progress, Connections: tc.connections}
+		errs := opts.check()
+		if tc.fails && len(errs) != 1 || !tc.fails && len(errs) != 0 {
+			t.Errorf("unexpected errors for %q %v: %v", tc.progress, tc.connections, errs)
+		}
+	}
+}
@@ -3,8 +3,10 @@ package database
 import (
 	"errors"
 	"strings"
+	"time"
 
 	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/go-osm/state"
 	"github.com/omniscale/imposm3/geom"
 	"github.com/omniscale/imposm3/mapping"
 	"github.com/omniscale/imposm3/mapping/config"
@@ -16,6 +18,33 @@ type Config struct {
 	ImportSchema     string
 	ProductionSchema string
 	BackupSchema     string
+	// BackupGenerations is the number of backups that are kept by Deploy.
+	BackupGenerations int
+	// Unlogged creates all tables without write-ahead log during the
+	// import. Tables are converted to logged tables at the end of the
+	// import, unless KeepUnlogged is set.
+	Unlogged     bool
+	KeepUnlogged bool
+	// IndexJobs is the number of indices that are created in parallel
+	// after the import. Defaults to the number of CPUs.
+	IndexJobs int
+	// Grants are the privileges for each role that are granted on all
+	// tables.
+	Grants map[string][]string
+	// PartialImport is set if only some tables of the mapping are
+	// imported. All other tables need to remain unchanged.
+	PartialImport bool
+	// DiffWorkers is the number of connections that update the tables of
+	// a diff import in parallel. Tables are updated in a single
+	// transaction if not set.
+	DiffWorkers int
+	// Savepoints wraps each insert of diff imports in a savepoint, so that
+	// a failed insert does not abort the whole transaction.
+	Savepoints bool
+	// ClipLimitTo is set for imports with -limitto. Ways and relations
+	// that cross the border of the limitto geometry can be inserted in
+	// multiple parts with the same OSM id.
+	ClipLimitTo bool
 }
 
 type DB interface {
@@ -65,6 +94,196 @@ type Optimizer interface {
 	Optimize() error
 }
 
+// Migrator updates existing tables before diff imports, e.g. by adding
+// columns that were added to the mapping.
+type Migrator interface {
+	Migrate() error
+}
+
+// Resumer is implemented by databases that keep all written rows in
+// persistent tables after the process exits, so that an interrupted import
+// can be resumed after the write phase. Databases that keep the data in
+// memory or in temporary files until Finish repeat the write phase with
+// -resume.
+type Resumer interface {
+	ResumableWrite() bool
+}
+
+// WriteResumer is implemented by databases that can continue an interrupted
+// write phase after the last committed rows. CommitWrite commits all rows
+// that were inserted and returns the position of the last committed row of
+// each table, the following rows are inserted with new transactions.
+// Returns nil positions if the rows of a table can't be positioned.
+// ResumeWrite removes all rows after the positions and begins an import
+// that appends to the existing tables.
+type WriteResumer interface {
+	CommitWrite() (map[string]int64, error)
+	ResumeWrite(positions map[string]int64) error
+}
+
+// OSMInserter is implemented by databases that store the OSM elements
+// themselves and not only the mapped rows, e.g. for OSM extracts. The
+// elements are passed after they were inserted into at least one table.
+// Ways include their nodes and relations their member ways and nodes.
+// Coordinates are projected into the SRID of the import.
+type OSMInserter interface {
+	InsertNode(osm.Node) error
+	InsertWay(osm.Way) error
+	InsertRelation(osm.Relation) error
+}
+
+// OSMElementStorer is implemented by databases that wrap other databases
+// (e.g. multiple outputs) and implement OSMInserter to pass the elements
+// on, even if no wrapped database stores them.
+type OSMElementStorer interface {
+	StoresOSMElements() bool
+}
+
+// StoresOSMElements returns whether db is an OSMInserter that stores the
+// elements itself or in a wrapped database.
+func StoresOSMElements(db interface{}) bool {
+	if s, ok := db.(OSMElementStorer); ok {
+		return s.StoresOSMElements()
+	}
+	_, ok := db.(OSMInserter)
+	return ok
+}
+
+// Quarantiner is implemented by databases that store the elements that
+// matched a table but whose geometry could not be built. wkb is a hex
+// encoded EWKB of the available coordinates (e.g. a LineString of all
+// nodes), or nil.
+type Quarantiner interface {
+	InsertInvalid(elem osm.Element, reason error, wkb []byte, matches []mapping.Match) error
+}
+
+// InvalidStorer is implemented by databases that wrap other databases and
+// implement Quarantiner to pass the elements on, even if no wrapped
+// database stores them.
+type InvalidStorer interface {
+	StoresInvalid() bool
+}
+
+// StoresInvalid returns whether db is a Quarantiner that stores the
+// elements itself or in a wrapped database.
+func StoresInvalid(db interface{}) bool {
+	if s, ok := db.(InvalidStorer); ok {
+		return s.StoresInvalid()
+	}
+	_, ok := db.(Quarantiner)
+	return ok
+}
+
+// ChangesetInserter is implemented by databases that store the metadata
+// of changesets. Changesets that are already stored are updated, as
+// changesets are published again when they are closed.
+type ChangesetInserter interface {
+	InsertChangesets([]osm.Changeset) error
+}
+
+// ChangeRecorder is implemented by databases that record the changed
+// elements of diff imports, e.g. in a change table. old is the previous
+// state of modified and deleted elements, if the diff contains it (e.g.
+// augmented diffs), or nil. RecordsChanges returns false if recording is
+// disabled.
+type ChangeRecorder interface {
+	RecordsChanges() bool
+	RecordChange(change osm.Diff, old *osm.Element) error
+}
+
+// StateStore is implemented by databases that store the state of the last
+// imported diff and the statistics of each diff import, e.g. for
+// containers without a persistent diff directory. WriteState is called
+// within the transaction of a diff import, s is nil for diffs without
+// state file. LastState returns nil if no state is stored.
+type StateStore interface {
+	LastState() (*state.DiffState, error)
+	WriteState(s *state.DiffState, stats DiffStats) error
+}
+
+// StateStorer is implemented by databases that wrap other databases and
+// implement StateStore to pass the states on, even if no wrapped database
+// stores them.
+type StateStorer interface {
+	StoresStates() bool
+}
+
+// StateStoreOf returns db as StateStore, if db stores diff states itself
+// or in a wrapped database.
+func StateStoreOf(db interface{}) (StateStore, bool) {
+	store, ok := db.(StateStore)
+	if !ok {
+		return nil, false
+	}
+	if s, ok := db.(StateStorer); ok && !s.StoresStates() {
+		return nil, false
+	}
+	return store, true
+}
+
+// DiffSequencer is implemented by databases that record the sequence of
+// the imported diff, e.g. with the changed rows. SetDiffSequence is called
+// before Begin, seq is 0 if the sequence is unknown.
+type DiffSequencer interface {
+	SetDiffSequence(seq int)
+}
+
+// DiffStats are the statistics of a diff import.
+type DiffStats struct {
+	Created  int64
+	Modified int64
+	Deleted  int64
+	Duration time.Duration
+}
+
+// TileServerConfigWriter writes the configuration of a tile server (e.g.
+// tegola:/path/config.toml) with all tables of the mapping.
+type TileServerConfigWriter interface {
+	WriteTileServerConfig(dest string, production bool) error
+}
+
+// GeometryChecker reports invalid, empty and degenerate geometries of all
+// tables in the production or import schema. Issues lists up to limit
+// features of each table. Polygons with an area below minSize² and lines
+// shorter than minSize (in meters) are reported as degenerate.
+type GeometryChecker interface {
+	CheckGeometries(production bool, limit int, minSize float64) ([]GeometryReport, error)
+}
+
+// GeometryReport contains the geometry issues of a table.
+type GeometryReport struct {
+	Table      string // with schema
+	Rows       int64
+	Invalid    int64
+	Empty      int64
+	Degenerate int64
+	Issues     []GeometryIssue
+}
+
+// GeometryIssue is a single feature with an invalid, empty or degenerate
+// geometry.
+type GeometryIssue struct {
+	ID    int64
+	Issue string // invalid, empty or degenerate
+	// Reason is the reason of invalid geometries (ST_IsValidReason).
+	Reason string
+}
+
+// RowFinder returns the rows with the OSM id of the tables and generalized
+// tables in names. Tables that do not exist are skipped. The id is the id
+// in the database (e.g. negative for relations).
+type RowFinder interface {
+	FindRows(names []string, id int64, production bool) ([]TableRows, error)
+}
+
+// TableRows are the rows of an element in a table.
+type TableRows struct {
+	Table string // with schema
+	// Rows are JSON objects of the columns, with the type of the
+	// geometries instead of the geometries.
+	Rows []string
+}
+
 var databases map[string]func(Config, *config.Mapping) (DB, error)
 
 func init() {
@@ -0,0 +1,8 @@
+/*
+Package dryrun implements the database interfaces for imports with -dry-run.
+
+Rows are not written anywhere. The number of rows and the estimated size of
+each table are counted and logged at the end of the import, e.g. to check
+changes of the mapping before a long import.
+*/
+package dryrun
@@ -0,0 +1,174 @@
+package dryrun
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/mapping/config"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	database.Register("dryrun", New)
+}
+
+// table counts the rows of a table. rows and bytes are the first fields for
+// the 64-bit alignment of atomic operations.
+type table struct {
+	rows    int64
+	bytes   int64
+	spec    *database.TableSpec
+	geomCol int
+}
+
+type DryRun struct {
+	Tables            []*database.TableSpec
+	GeneralizedTables map[string]*config.GeneralizedTable
+	tables            map[string]*table
+}
+
+// New returns a DryRun database that counts the rows of each table.
+func New(conf database.Config, m *config.Mapping) (database.DB, error) {
+	tables, err := database.NewTableSpecs(m, conf.Srid)
+	if err != nil {
+		return nil, err
+	}
+	db := &DryRun{
+		Tables:            tables,
+		GeneralizedTables: m.GeneralizedTables,
+		tables:            make(map[string]*table, len(tables)),
+	}
+	for _, spec := range tables {
+		db.tables[spec.Name] = &table{spec: spec, geomCol: spec.GeometryColumn()}
+	}
+	return db, nil
+}
+
+func (db *DryRun) Init() error  { return nil }
+func (db *DryRun) Begin() error { return nil }
+func (db *DryRun) End() error   { return nil }
+func (db *DryRun) Abort() error { return nil }
+func (db *DryRun) Close() error { return nil }
+
+func (db *DryRun) count(tableName string, row []interface{}) error {
+	t, ok := db.tables[tableName]
+	if !ok {
+		return errors.Errorf("unknown table %s", tableName)
+	}
+	var size int64
+	for i, v := range row {
+		if i == t.geomCol {
+			// hex encoded EWKB
+			if wkb, ok := v.(string); ok {
+				size += int64(len(wkb) / 2)
+			}
+			continue
+		}
+		size += valueSize(v)
+	}
+	atomic.AddInt64(&t.rows, 1)
+	atomic.AddInt64(&t.bytes, size)
+	return nil
+}
+
+// valueSize returns the estimated size of a column value in bytes, without
+// any overhead of the database.
+func valueSize(v interface{}) int64 {
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(len(v))
+	case bool, int8:
+		return 1
+	case int16:
+		return 2
+	case int32, float32:
+		return 4
+	case int, int64, float64:
+		return 8
+	case map[string]string:
+		var size int64
+		for k, v := range v {
+			size += int64(len(k) + len(v))
+		}
+		return size
+	default:
+		return int64(len(fmt.Sprint(v)))
+	}
+}
+
+func (db *DryRun) insert(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	for _, match := range matches {
+		if err := db.count(match.Table.Name, match.Row(&elem, &geom)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *DryRun) InsertPoint(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return db.insert(elem, geom, matches)
+}
+
+func (db *DryRun) InsertLineString(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return db.insert(elem, geom, matches)
+}
+
+func (db *DryRun) InsertPolygon(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return db.insert(elem, geom, matches)
+}
+
+func (db *DryRun) InsertRelationMember(rel osm.Relation, m osm.Member, geom geom.Geometry, matches []mapping.Match) error {
+	for _, match := range matches {
+		if err := db.count(match.Table.Name, match.MemberRow(&rel, &m, &geom)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Generalize does nothing, generalized tables are not counted.
+func (db *DryRun) Generalize() error {
+	if len(db.GeneralizedTables) > 0 {
+		log.Printf("[info] dry-run: %d generalized tables are not counted", len(db.GeneralizedTables))
+	}
+	return nil
+}
+
+func (db *DryRun) EnableGeneralizeUpdates() {}
+
+func (db *DryRun) GeneralizeUpdates() error { return nil }
+
+// Finish logs the number of rows and the estimated size of all tables.
+func (db *DryRun) Finish() error {
+	var rows, bytes int64
+	for _, spec := range db.Tables {
+		t := db.tables[spec.Name]
+		log.Printf("[info] dry-run: %-30s %12d rows %10s", spec.Name, t.rows, formatBytes(t.bytes))
+		rows += t.rows
+		bytes += t.bytes
+	}
+	log.Printf("[info] dry-run: %-30s %12d rows %10s", "total", rows, formatBytes(bytes))
+	return nil
+}
+
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit && exp < 4; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTP"[exp])
+}
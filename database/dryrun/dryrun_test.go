@@ -0,0 +1,60 @@
+package dryrun
+
+import (
+	"testing"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/mapping"
+)
+
+func TestCount(t *testing.T) {
+	spec := &database.TableSpec{
+		Name: "roads",
+		Columns: []database.ColumnSpec{
+			{Name: "osm_id", FieldType: mapping.ColumnType{GoType: "int64"}},
+			{Name: "geometry", FieldType: mapping.ColumnType{GoType: "geometry"}},
+			{Name: "name", FieldType: mapping.ColumnType{GoType: "string"}},
+			{Name: "tags", FieldType: mapping.ColumnType{GoType: "hstore_string"}},
+		},
+	}
+	db := &DryRun{
+		Tables: []*database.TableSpec{spec},
+		tables: map[string]*table{"roads": {spec: spec, geomCol: 1}},
+	}
+
+	// LINESTRING(1 2, 3 4) with SRID 4326, 45 bytes
+	wkb := "0102000020E610000002000000000000000000F03F000000000000004000000000000008400000000000001040"
+	if err := db.count("roads", []interface{}{int64(42), wkb, "Main Street", map[string]string{"highway": "primary"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.count("roads", []interface{}{int64(43), wkb, nil, nil}); err != nil {
+		t.Fatal(err)
+	}
+	tbl := db.tables["roads"]
+	if tbl.rows != 2 {
+		t.Errorf("unexpected rows %d", tbl.rows)
+	}
+	if expected := int64(8 + 45 + 11 + 14 + 8 + 45); tbl.bytes != expected {
+		t.Errorf("unexpected bytes %d, expected %d", tbl.bytes, expected)
+	}
+
+	if err := db.count("unknown", nil); err == nil {
+		t.Error("expected error for unknown table")
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for _, tc := range []struct {
+		b        int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KiB"},
+		{5 << 30, "5.0 GiB"},
+	} {
+		if s := formatBytes(tc.b); s != tc.expected {
+			t.Errorf("formatBytes(%d) = %q, expected %q", tc.b, s, tc.expected)
+		}
+	}
+}
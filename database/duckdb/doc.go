@@ -0,0 +1,10 @@
+/*
+Package duckdb implements the database interfaces for DuckDB database files.
+
+All rows are written into temporary GeoParquet files during the import.
+Finish loads these files into the DuckDB database with the duckdb command
+and converts all geometry columns with the spatial extension.
+
+Diff imports are not supported.
+*/
+package duckdb
@@ -0,0 +1,141 @@
+package duckdb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/database/geoparquet"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping/config"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	database.Register("duckdb", New)
+}
+
+type DuckDB struct {
+	*geoparquet.GeoParquet
+	Filename string
+	duckdb   string
+}
+
+// New returns a DuckDB database that imports all tables into the
+// DuckDB file of the connection string (duckdb:/path/to/file.duckdb).
+// Existing tables with the same name are replaced.
+//
+// The rowgroup and compression options of the geoparquet output can be
+// used for the temporary files.
+func New(conf database.Config, m *config.Mapping) (database.DB, error) {
+	gp, err := geoparquet.NewGeoParquet(conf, m, "duckdb")
+	if err != nil {
+		return nil, err
+	}
+	db := &DuckDB{
+		GeoParquet: gp,
+		// NewGeoParquet uses the connection path as output directory
+		Filename: gp.Dir,
+	}
+	if db.duckdb, err = exec.LookPath("duckdb"); err != nil {
+		return nil, errors.Wrap(err, "duckdb output requires the duckdb command")
+	}
+	return db, nil
+}
+
+// Init creates the temporary directory for the GeoParquet files next to
+// the DuckDB file.
+func (db *DuckDB) Init() error {
+	if err := os.MkdirAll(filepath.Dir(db.Filename), 0755); err != nil {
+		return errors.Wrapf(err, "creating directory for %s", db.Filename)
+	}
+	dir, err := ioutil.TempDir(filepath.Dir(db.Filename), "."+filepath.Base(db.Filename)+"-")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary directory")
+	}
+	db.Dir = dir
+	return db.GeoParquet.Init()
+}
+
+// Abort removes all temporary files.
+func (db *DuckDB) Abort() error {
+	db.GeoParquet.Abort()
+	return db.removeTmp()
+}
+
+func (db *DuckDB) Close() error {
+	return db.Abort()
+}
+
+func (db *DuckDB) removeTmp() error {
+	if db.Dir == "" || db.Dir == db.Filename {
+		return nil
+	}
+	err := os.RemoveAll(db.Dir)
+	db.Dir = db.Filename
+	return err
+}
+
+// Generalize does nothing, generalized tables are only supported by PostGIS.
+func (db *DuckDB) Generalize() error {
+	if len(db.GeneralizedTables) > 0 {
+		log.Printf("[warn] generalized tables are not supported by DuckDB output, skipping %d tables", len(db.GeneralizedTables))
+	}
+	return nil
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+func quoteString(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// loadSQL returns the SQL to create all tables from the GeoParquet files.
+// The tables are created before the spatial extension is loaded, so that
+// the geometries are read as WKB blobs and not converted by newer DuckDB
+// versions.
+func (db *DuckDB) loadSQL() string {
+	buf := &bytes.Buffer{}
+	buf.WriteString("BEGIN;\n")
+	for _, spec := range db.Tables {
+		files := db.Files(spec.Name)
+		quoted := make([]string, len(files))
+		for i, f := range files {
+			quoted[i] = quoteString(f)
+		}
+		fmt.Fprintf(buf, "CREATE OR REPLACE TABLE %s AS SELECT * FROM read_parquet([%s]);\n",
+			quoteIdent(spec.Name), strings.Join(quoted, ", "))
+	}
+	buf.WriteString("COMMIT;\nINSTALL spatial;\nLOAD spatial;\nBEGIN;\n")
+	for _, spec := range db.Tables {
+		if col := spec.GeometryColumn(); col >= 0 {
+			name := quoteIdent(spec.Columns[col].Name)
+			fmt.Fprintf(buf, "ALTER TABLE %s ALTER %s TYPE GEOMETRY USING ST_GeomFromWKB(%s);\n",
+				quoteIdent(spec.Name), name, name)
+		}
+	}
+	buf.WriteString("COMMIT;\nCHECKPOINT;\n")
+	return buf.String()
+}
+
+// Finish writes the GeoParquet files and loads them into the DuckDB file.
+func (db *DuckDB) Finish() error {
+	if err := db.GeoParquet.Finish(); err != nil {
+		return err
+	}
+	defer log.Step("Loading tables into DuckDB " + db.Filename)()
+
+	cmd := exec.Command(db.duckdb, "-bail", db.Filename)
+	cmd.Stdin = strings.NewReader(db.loadSQL())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Errorf("loading tables into %s: %s %s", db.Filename, err, out)
+	}
+	return db.removeTmp()
+}
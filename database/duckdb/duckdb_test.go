@@ -0,0 +1,80 @@
+package duckdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/database/geoparquet"
+	"github.com/omniscale/imposm3/mapping"
+)
+
+func TestLoadSQL(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "imposm3_duckdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	db := &DuckDB{
+		GeoParquet: &geoparquet.GeoParquet{
+			Config:       database.Config{Srid: 4326},
+			RowGroupSize: 10,
+			Tables: []*database.TableSpec{
+				{
+					Name: "roads",
+					Srid: 4326,
+					Columns: []database.ColumnSpec{
+						{Name: "osm_id", FieldType: mapping.ColumnType{GoType: "int64"}},
+						{Name: "geometry", FieldType: mapping.ColumnType{GoType: "geometry"}},
+					},
+				},
+				{
+					Name: `"routes"`,
+					Columns: []database.ColumnSpec{
+						{Name: "osm_id", FieldType: mapping.ColumnType{GoType: "int64"}},
+					},
+				},
+			},
+		},
+		Filename: filepath.Join(tmpdir, "osm.duckdb"),
+	}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(db.Dir, tmpdir+"/.osm.duckdb-") {
+		t.Errorf("unexpected temporary directory %s", db.Dir)
+	}
+	if err := db.GeoParquet.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	sql := db.loadSQL()
+	for _, expected := range []string{
+		"CREATE OR REPLACE TABLE \"roads\" AS SELECT * FROM read_parquet(['" + filepath.Join(db.Dir, "roads.parquet") + "']);\n",
+		"CREATE OR REPLACE TABLE \"\"\"routes\"\"\" AS SELECT",
+		"LOAD spatial;\n",
+		"ALTER TABLE \"roads\" ALTER \"geometry\" TYPE GEOMETRY USING ST_GeomFromWKB(\"geometry\");\n",
+	} {
+		if !strings.Contains(sql, expected) {
+			t.Errorf("%q not found in\n%s", expected, sql)
+		}
+	}
+	if strings.Contains(sql, "ALTER TABLE \"\"\"routes\"\"\"") {
+		t.Errorf("unexpected ALTER for table without geometry\n%s", sql)
+	}
+	if strings.Index(sql, "LOAD spatial") < strings.Index(sql, "read_parquet") {
+		t.Errorf("spatial loaded before tables are created\n%s", sql)
+	}
+
+	dir := db.Dir
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("temporary directory %s not removed", dir)
+	}
+}
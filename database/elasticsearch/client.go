@@ -0,0 +1,182 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const bulkMaxBytes = 5 << 20
+
+// client is a minimal client for the REST API.
+type client struct {
+	baseURL string
+	http    *http.Client
+}
+
+func newClient(baseURL string) *client {
+	return &client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// request sends the body to the path and decodes the JSON response into
+// result (if not nil). Returns an error for all non 2xx responses, except
+// for the allowed status codes.
+func (c *client) request(method, path string, body io.Reader, contentType string, result interface{}, allowed ...int) (int, error) {
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, errors.Wrapf(err, "%s %s", method, path)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		for _, code := range allowed {
+			if resp.StatusCode == code {
+				io.Copy(ioutil.Discard, resp.Body)
+				return resp.StatusCode, nil
+			}
+		}
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp.StatusCode, errors.Errorf("%s %s: %s %s", method, path, resp.Status, msg)
+	}
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return resp.StatusCode, errors.Wrapf(err, "decoding response of %s %s", method, path)
+		}
+	} else {
+		io.Copy(ioutil.Discard, resp.Body)
+	}
+	return resp.StatusCode, nil
+}
+
+func (c *client) json(method, path string, body interface{}, result interface{}, allowed ...int) (int, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		r = bytes.NewReader(b)
+	}
+	return c.request(method, path, r, "application/json", result, allowed...)
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string          `json:"_id"`
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// bulk collects actions and sends them with the bulk API.
+type bulk struct {
+	client *client
+	// ordered serializes all requests, so that deletes and inserts of the
+	// same document are applied in order.
+	ordered bool
+
+	mu  sync.Mutex
+	buf *bytes.Buffer
+	err error
+
+	sendMu sync.Mutex
+}
+
+func newBulk(c *client, ordered bool) *bulk {
+	return &bulk{client: c, ordered: ordered, buf: &bytes.Buffer{}}
+}
+
+// add adds the action and the optional document (both newline terminated).
+func (b *bulk) add(action, doc []byte) error {
+	b.mu.Lock()
+	if b.err != nil {
+		err := b.err
+		b.mu.Unlock()
+		return err
+	}
+	b.buf.Write(action)
+	b.buf.Write(doc)
+	if b.buf.Len() < bulkMaxBytes {
+		b.mu.Unlock()
+		return nil
+	}
+	return b.flushLocked()
+}
+
+// flush sends all collected actions.
+func (b *bulk) flush() error {
+	b.mu.Lock()
+	return b.flushLocked()
+}
+
+// flushLocked sends all collected actions and unlocks b.mu. The actions are
+// sent in order if b.ordered is set.
+func (b *bulk) flushLocked() error {
+	if b.err != nil || b.buf.Len() == 0 {
+		err := b.err
+		b.mu.Unlock()
+		return err
+	}
+	body := b.buf
+	b.buf = &bytes.Buffer{}
+	if b.ordered {
+		b.sendMu.Lock()
+		defer b.sendMu.Unlock()
+	}
+	b.mu.Unlock()
+
+	err := b.send(body)
+	if err != nil {
+		b.mu.Lock()
+		if b.err == nil {
+			b.err = err
+		}
+		b.mu.Unlock()
+	}
+	return err
+}
+
+func (b *bulk) send(body *bytes.Buffer) error {
+	resp := bulkResponse{}
+	if _, err := b.client.request("POST", "/_bulk", body, "application/x-ndjson", &resp); err != nil {
+		return err
+	}
+	if !resp.Errors {
+		return nil
+	}
+	for _, item := range resp.Items {
+		for action, r := range item {
+			// deleting missing documents is fine
+			if r.Error != nil && !(action == "delete" && r.Status == http.StatusNotFound) {
+				return errors.Errorf("bulk %s of %s: %s", action, r.ID, r.Error)
+			}
+		}
+	}
+	return nil
+}
+
+func bulkAction(action, index, id string) []byte {
+	meta := map[string]string{"_index": index}
+	if id != "" {
+		meta["_id"] = id
+	}
+	b, _ := json.Marshal(map[string]interface{}{action: meta})
+	return append(b, '\n')
+}
@@ -0,0 +1,8 @@
+/*
+Package elasticsearch implements the database interfaces for Elasticsearch
+and OpenSearch.
+
+Each table is indexed into a separate index. Rows are sent with the bulk API
+during imports and diff imports.
+*/
+package elasticsearch
@@ -0,0 +1,403 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/geom/ewkb"
+	"github.com/omniscale/imposm3/geom/geojson"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/mapping/config"
+	"github.com/omniscale/imposm3/proj"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	database.Register("elasticsearch", New)
+	database.Register("opensearch", New)
+}
+
+type index struct {
+	spec    *database.TableSpec
+	name    string
+	geomCol int
+	idCol   int
+	names   [][]byte // JSON encoded column names
+	// members is set for relation_member tables with multiple documents for
+	// each OSM ID
+	members bool
+	// parts is set for tables with geometries that are clipped to -limitto
+	// or subdivided, with a document for each part of an OSM element
+	parts bool
+}
+
+type Elasticsearch struct {
+	Config            database.Config
+	URL               string
+	Prefix            string
+	OpenSearch        bool
+	Indices           []*index
+	GeneralizedTables map[string]*config.GeneralizedTable
+	client            *client
+	indices           map[string]*index
+	bulk              *bulk
+}
+
+// New returns an Elasticsearch or OpenSearch database that indexes each
+// table into a separate index
+// (elasticsearch:http://localhost:9200?prefix=osm_). The prefix parameter
+// sets the prefix of all index names (default osm_, NONE for no prefix).
+func New(conf database.Config, m *config.Mapping) (database.DB, error) {
+	parts := strings.SplitN(conf.ConnectionParams, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, errors.Errorf("missing URL in %s connection", parts[0])
+	}
+	u, err := url.Parse(parts[1])
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing %s URL", parts[0])
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("unsupported URL %q for %s connection", parts[1], parts[0])
+	}
+	query := u.Query()
+	prefix := query.Get("prefix")
+	query.Del("prefix")
+	u.RawQuery = query.Encode()
+	if prefix == "NONE" {
+		prefix = ""
+	} else if prefix == "" {
+		prefix = "osm_"
+	}
+
+	specs, err := database.NewTableSpecs(m, conf.Srid)
+	if err != nil {
+		return nil, err
+	}
+	db := &Elasticsearch{
+		Config:            conf,
+		URL:               u.String(),
+		Prefix:            prefix,
+		OpenSearch:        parts[0] == "opensearch",
+		GeneralizedTables: m.GeneralizedTables,
+		client:            newClient(u.String()),
+		indices:           make(map[string]*index),
+	}
+	for _, spec := range specs {
+		idx := &index{
+			spec:    spec,
+			name:    strings.ToLower(prefix + spec.Name),
+			geomCol: spec.GeometryColumn(),
+			idCol:   -1,
+			members: mapping.TableType(m.Tables[spec.Name].Type) == mapping.RelationMemberTable,
+			parts:   splitsIntoParts(conf, m.Tables[spec.Name]),
+		}
+		for i, col := range spec.Columns {
+			if col.FieldType.Name == "id" && idx.idCol == -1 {
+				idx.idCol = i
+			}
+			name, _ := json.Marshal(col.Name)
+			idx.names = append(idx.names, name)
+		}
+		db.Indices = append(db.Indices, idx)
+		db.indices[spec.Name] = idx
+	}
+	return db, nil
+}
+
+// splitsIntoParts returns whether the geometries of the table can be
+// inserted in multiple parts with the same OSM ID, because they are clipped
+// to the -limitto geometry or subdivided.
+func splitsIntoParts(conf database.Config, t *config.Table) bool {
+	if t.Subdivide > 0 {
+		return true
+	}
+	return conf.ClipLimitTo && t.LimitTo != "centroid" && mapping.TableType(t.Type) != mapping.PointTable
+}
+
+// fieldMapping returns the mapping of the column.
+func (db *Elasticsearch) fieldMapping(col database.ColumnSpec) map[string]interface{} {
+	switch col.FieldType.GoType {
+	case "geometry", "validated_geometry":
+		// skip geometries that are rejected as invalid
+		return map[string]interface{}{"type": "geo_shape", "ignore_malformed": true}
+	case "bool":
+		return map[string]interface{}{"type": "boolean"}
+	case "int8":
+		return map[string]interface{}{"type": "short"}
+	case "int32":
+		return map[string]interface{}{"type": "integer"}
+	case "int64":
+		return map[string]interface{}{"type": "long"}
+	case "float32":
+		return map[string]interface{}{"type": "float"}
+	case "hstore_string":
+		if db.OpenSearch {
+			return map[string]interface{}{"type": "flat_object"}
+		}
+		return map[string]interface{}{"type": "flattened"}
+	default:
+		return map[string]interface{}{
+			"type":   "text",
+			"fields": map[string]interface{}{"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 256}},
+		}
+	}
+}
+
+// Init deletes and creates all indices. Refreshing is disabled until
+// Finish.
+func (db *Elasticsearch) Init() error {
+	for _, idx := range db.Indices {
+		if _, err := db.client.request("DELETE", "/"+idx.name, nil, "", nil, http.StatusNotFound); err != nil {
+			return errors.Wrapf(err, "deleting index %s", idx.name)
+		}
+		props := make(map[string]interface{})
+		for _, col := range idx.spec.Columns {
+			props[col.Name] = db.fieldMapping(col)
+		}
+		body := map[string]interface{}{
+			"settings": map[string]interface{}{"index": map[string]interface{}{"refresh_interval": "-1"}},
+			"mappings": map[string]interface{}{"properties": props},
+		}
+		if _, err := db.client.json("PUT", "/"+idx.name, body, nil); err != nil {
+			return errors.Wrapf(err, "creating index %s", idx.name)
+		}
+	}
+	return nil
+}
+
+// Begin starts the ordered bulk indexing for diff imports.
+func (db *Elasticsearch) Begin() error {
+	db.bulk = newBulk(db.client, true)
+	return nil
+}
+
+// BeginBulk starts the bulk indexing for the initial import.
+func (db *Elasticsearch) BeginBulk() error {
+	db.bulk = newBulk(db.client, false)
+	return nil
+}
+
+// End sends all remaining documents. Indices are refreshed after diff
+// imports, so that the next diff import can delete relation members and
+// parts.
+func (db *Elasticsearch) End() error {
+	if err := db.bulk.flush(); err != nil {
+		return err
+	}
+	if db.bulk.ordered {
+		return db.refresh()
+	}
+	return nil
+}
+
+// Abort discards all collected documents. Documents that were already sent
+// are not removed.
+func (db *Elasticsearch) Abort() error {
+	db.bulk = newBulk(db.client, db.bulk != nil && db.bulk.ordered)
+	return nil
+}
+
+func (db *Elasticsearch) Close() error { return nil }
+
+func (db *Elasticsearch) refresh() error {
+	names := make([]string, len(db.Indices))
+	for i, idx := range db.Indices {
+		names[i] = idx.name
+	}
+	_, err := db.client.request("POST", "/"+strings.Join(names, ",")+"/_refresh", nil, "", nil)
+	return errors.Wrap(err, "refreshing indices")
+}
+
+func toWgs(g *ewkb.Geometry, p proj.Projection) {
+	for i, c := range g.Coords {
+		g.Coords[i].X, g.Coords[i].Y = p.Inverse(c.X, c.Y)
+	}
+	for _, r := range g.Rings {
+		for i, c := range r {
+			r[i].X, r[i].Y = p.Inverse(c.X, c.Y)
+		}
+	}
+	for i := range g.Geoms {
+		toWgs(&g.Geoms[i], p)
+	}
+}
+
+// document returns the JSON document of the row, terminated by a newline.
+func (db *Elasticsearch) document(idx *index, row []interface{}) ([]byte, error) {
+	buf := make([]byte, 0, 256)
+	buf = append(buf, '{')
+	first := true
+	for i, v := range row {
+		if v == nil {
+			continue
+		}
+		var val []byte
+		switch idx.spec.Columns[i].FieldType.GoType {
+		case "geometry", "validated_geometry":
+			wkb, ok := v.(string)
+			if !ok || wkb == "" {
+				continue
+			}
+			g, err := ewkb.DecodeHex([]byte(wkb))
+			if err != nil {
+				return nil, errors.Wrapf(err, "decoding geometry for %s", idx.spec.Name)
+			}
+			if g.IsEmpty() {
+				continue
+			}
+			if db.Config.Srid != 4326 {
+				toWgs(g, proj.Lookup(db.Config.Srid))
+			}
+			val = geojson.AppendGeometry(nil, g)
+		case "hstore_string":
+			s, ok := v.(string)
+			if !ok {
+				continue
+			}
+			val, _ = json.Marshal(database.ParseHstore(s))
+		default:
+			var err error
+			if val, err = json.Marshal(v); err != nil {
+				// e.g. NaN values
+				continue
+			}
+		}
+		if !first {
+			buf = append(buf, ',')
+		}
+		first = false
+		buf = append(buf, idx.names[i]...)
+		buf = append(buf, ':')
+		buf = append(buf, val...)
+	}
+	return append(buf, "}\n"...), nil
+}
+
+// docID returns the document ID for the row. Documents of relation_member
+// tables and of tables without ID column get a generated ID. The ID of
+// parts is the OSM ID with the hash of the geometry as suffix (e.g.
+// 42-8c3f2a1b9d0e4f57), so that parts don't replace each other.
+func (idx *index) docID(row []interface{}) string {
+	if idx.members || idx.idCol < 0 {
+		return ""
+	}
+	id, ok := database.AsInt64(row[idx.idCol])
+	if !ok {
+		return ""
+	}
+	docID := strconv.FormatInt(id, 10)
+	if idx.parts && idx.geomCol >= 0 {
+		wkb, _ := row[idx.geomCol].(string)
+		h := fnv.New64a()
+		h.Write([]byte(wkb))
+		docID += "-" + strconv.FormatUint(h.Sum64(), 16)
+	}
+	return docID
+}
+
+func (db *Elasticsearch) writeRow(table string, row []interface{}) error {
+	idx, ok := db.indices[table]
+	if !ok {
+		return errors.Errorf("unknown table %s", table)
+	}
+	doc, err := db.document(idx, row)
+	if err != nil {
+		return err
+	}
+	return db.bulk.add(bulkAction("index", idx.name, idx.docID(row)), doc)
+}
+
+func (db *Elasticsearch) insert(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	for _, match := range matches {
+		if err := db.writeRow(match.Table.Name, match.Row(&elem, &geom)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *Elasticsearch) InsertPoint(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return db.insert(elem, geom, matches)
+}
+
+func (db *Elasticsearch) InsertLineString(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return db.insert(elem, geom, matches)
+}
+
+func (db *Elasticsearch) InsertPolygon(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return db.insert(elem, geom, matches)
+}
+
+func (db *Elasticsearch) InsertRelationMember(rel osm.Relation, m osm.Member, geom geom.Geometry, matches []mapping.Match) error {
+	for _, match := range matches {
+		if err := db.writeRow(match.Table.Name, match.MemberRow(&rel, &m, &geom)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes all documents of the OSM ID. Documents of relation_member
+// tables and tables with parts are deleted with a delete-by-query request,
+// after all previous actions are sent.
+func (db *Elasticsearch) Delete(id int64, matches []mapping.Match) error {
+	for _, match := range matches {
+		idx, ok := db.indices[match.Table.Name]
+		if !ok {
+			return errors.Errorf("unknown table %s", match.Table.Name)
+		}
+		if idx.idCol < 0 {
+			return errors.Errorf("missing id column in %s", idx.spec.Name)
+		}
+		if !idx.members && !idx.parts {
+			if err := db.bulk.add(bulkAction("delete", idx.name, strconv.FormatInt(id, 10)), nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := db.bulk.flush(); err != nil {
+			return err
+		}
+		query := map[string]interface{}{
+			"query": map[string]interface{}{
+				"term": map[string]interface{}{idx.spec.Columns[idx.idCol].Name: id},
+			},
+		}
+		if _, err := db.client.json("POST", "/"+idx.name+"/_delete_by_query?conflicts=proceed", query, nil); err != nil {
+			return errors.Wrapf(err, "deleting %d from %s", id, idx.name)
+		}
+	}
+	return nil
+}
+
+// Generalize does nothing, generalized tables are only supported by PostGIS.
+func (db *Elasticsearch) Generalize() error {
+	if len(db.GeneralizedTables) > 0 {
+		log.Printf("[warn] generalized tables are not supported by Elasticsearch output, skipping %d tables", len(db.GeneralizedTables))
+	}
+	return nil
+}
+
+func (db *Elasticsearch) EnableGeneralizeUpdates() {}
+
+func (db *Elasticsearch) GeneralizeUpdates() error { return nil }
+
+// Finish enables refreshing of all indices.
+func (db *Elasticsearch) Finish() error {
+	defer log.Step("Refreshing indices")()
+	for _, idx := range db.Indices {
+		body := map[string]interface{}{"index": map[string]interface{}{"refresh_interval": nil}}
+		if _, err := db.client.json("PUT", "/"+idx.name+"/_settings", body, nil); err != nil {
+			return errors.Wrapf(err, "updating settings of %s", idx.name)
+		}
+	}
+	return db.refresh()
+}
@@ -0,0 +1,219 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/mapping"
+)
+
+type request struct {
+	method, path, body string
+}
+
+type fakeServer struct {
+	mu       sync.Mutex
+	requests []request
+	bulkResp string
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	s.mu.Lock()
+	s.requests = append(s.requests, request{r.Method, r.URL.RequestURI(), string(body)})
+	s.mu.Unlock()
+	switch {
+	case r.Method == "DELETE":
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"index_not_found_exception"}`))
+	case r.URL.Path == "/_bulk" && s.bulkResp != "":
+		w.Write([]byte(s.bulkResp))
+	default:
+		w.Write([]byte(`{"errors":false}`))
+	}
+}
+
+func testDB(url string) *Elasticsearch {
+	spec := &database.TableSpec{
+		Name: "pois",
+		Srid: 4326,
+		Columns: []database.ColumnSpec{
+			{Name: "osm_id", FieldType: mapping.ColumnType{Name: "id", GoType: "int64"}},
+			{Name: "geometry", FieldType: mapping.ColumnType{GoType: "geometry"}},
+			{Name: "name", FieldType: mapping.ColumnType{GoType: "string"}},
+			{Name: "tags", FieldType: mapping.ColumnType{GoType: "hstore_string"}},
+		},
+	}
+	members := &database.TableSpec{
+		Name: "route_members",
+		Columns: []database.ColumnSpec{
+			{Name: "osm_id", FieldType: mapping.ColumnType{Name: "id", GoType: "int64"}},
+		},
+	}
+	db := &Elasticsearch{
+		Config:  database.Config{Srid: 4326},
+		client:  newClient(url),
+		indices: make(map[string]*index),
+	}
+	for _, s := range []*database.TableSpec{spec, members} {
+		idx := &index{spec: s, name: "osm_" + s.Name, geomCol: s.GeometryColumn(), idCol: 0, members: s == members}
+		for _, col := range s.Columns {
+			name, _ := json.Marshal(col.Name)
+			idx.names = append(idx.names, name)
+		}
+		db.Indices = append(db.Indices, idx)
+		db.indices[s.Name] = idx
+	}
+	return db
+}
+
+func TestInit(t *testing.T) {
+	fs := &fakeServer{}
+	srv := httptest.NewServer(fs)
+	defer srv.Close()
+	db := testDB(srv.URL)
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fs.requests) != 4 || fs.requests[0].method != "DELETE" || fs.requests[1].path != "/osm_pois" {
+		t.Fatalf("unexpected requests %v", fs.requests)
+	}
+	var body struct {
+		Mappings struct {
+			Properties map[string]map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal([]byte(fs.requests[1].body), &body); err != nil {
+		t.Fatal(err)
+	}
+	for col, typ := range map[string]string{"osm_id": "long", "geometry": "geo_shape", "name": "text", "tags": "flattened"} {
+		if body.Mappings.Properties[col]["type"] != typ {
+			t.Errorf("unexpected mapping for %s: %v", col, body.Mappings.Properties[col])
+		}
+	}
+}
+
+func TestBulk(t *testing.T) {
+	fs := &fakeServer{}
+	srv := httptest.NewServer(fs)
+	defer srv.Close()
+	db := testDB(srv.URL)
+
+	if err := db.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	// POINT(1 2) with SRID 4326
+	wkb := "0101000020E6100000000000000000F03F0000000000000040"
+	if err := db.writeRow("pois", []interface{}{int64(42), wkb, "Cafe", `"amenity"=>"cafe", "name"=>"Caf\"e"`}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.writeRow("route_members", []interface{}{int64(-7)}); err != nil {
+		t.Fatal(err)
+	}
+	pois := mapping.Match{Table: mapping.DestTable{Name: "pois"}}
+	members := mapping.Match{Table: mapping.DestTable{Name: "route_members"}}
+	if err := db.Delete(43, []mapping.Match{pois, members}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.End(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fs.requests) != 3 {
+		t.Fatalf("unexpected requests %v", fs.requests)
+	}
+	// delete_by_query is sent after all previous actions
+	expected := `{"index":{"_id":"42","_index":"osm_pois"}}` + "\n" +
+		`{"osm_id":42,"geometry":{"type":"Point","coordinates":[1,2]},"name":"Cafe","tags":{"amenity":"cafe","name":"Caf\"e"}}` + "\n" +
+		`{"index":{"_index":"osm_route_members"}}` + "\n" +
+		`{"osm_id":-7}` + "\n" +
+		`{"delete":{"_id":"43","_index":"osm_pois"}}` + "\n"
+	if r := fs.requests[0]; r.path != "/_bulk" || r.body != expected {
+		t.Errorf("unexpected bulk request %s\n%s\n%s", r.path, r.body, expected)
+	}
+	if r := fs.requests[1]; r.path != "/osm_route_members/_delete_by_query?conflicts=proceed" ||
+		r.body != `{"query":{"term":{"osm_id":43}}}` {
+		t.Errorf("unexpected delete request %v", r)
+	}
+	if r := fs.requests[2]; r.path != "/osm_pois,osm_route_members/_refresh" {
+		t.Errorf("unexpected refresh request %v", r)
+	}
+}
+
+func TestBulkErrors(t *testing.T) {
+	fs := &fakeServer{bulkResp: `{"errors":true,"items":[` +
+		`{"delete":{"_id":"1","status":404,"error":null}},` +
+		`{"index":{"_id":"2","status":400,"error":{"type":"mapper_parsing_exception"}}}]}`}
+	srv := httptest.NewServer(fs)
+	defer srv.Close()
+	db := testDB(srv.URL)
+	db.BeginBulk()
+	if err := db.writeRow("pois", []interface{}{int64(2), nil, "foo", nil}); err != nil {
+		t.Fatal(err)
+	}
+	err := db.End()
+	if err == nil || !strings.Contains(err.Error(), "mapper_parsing_exception") {
+		t.Errorf("expected error, got %v", err)
+	}
+	if err := db.writeRow("pois", []interface{}{int64(3), nil, "foo", nil}); err == nil {
+		t.Error("expected error for subsequent insert")
+	}
+}
+
+func TestParts(t *testing.T) {
+	fs := &fakeServer{}
+	srv := httptest.NewServer(fs)
+	defer srv.Close()
+	db := testDB(srv.URL)
+	idx := db.indices["pois"]
+	idx.parts = true
+
+	db.Begin()
+	// POINT(1 2) and POINT(3 4), parts of the same OSM element
+	for _, wkb := range []string{
+		"0101000020E6100000000000000000F03F0000000000000040",
+		"0101000020E610000000000000000008400000000000001040",
+	} {
+		if err := db.writeRow("pois", []interface{}{int64(42), wkb, nil, nil}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete(43, []mapping.Match{{Table: mapping.DestTable{Name: "pois"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.End(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fs.requests) != 3 {
+		t.Fatalf("unexpected requests %v", fs.requests)
+	}
+	var ids []string
+	for i, line := range strings.Split(fs.requests[0].body, "\n") {
+		if i%2 != 0 || line == "" {
+			continue
+		}
+		var action struct {
+			Index struct {
+				ID string `json:"_id"`
+			}
+		}
+		if err := json.Unmarshal([]byte(line), &action); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, action.Index.ID)
+	}
+	if len(ids) != 2 || ids[0] == ids[1] || !strings.HasPrefix(ids[0], "42-") || !strings.HasPrefix(ids[1], "42-") {
+		t.Errorf("unexpected document ids %v", ids)
+	}
+	if r := fs.requests[1]; r.path != "/osm_pois/_delete_by_query?conflicts=proceed" ||
+		r.body != `{"query":{"term":{"osm_id":43}}}` {
+		t.Errorf("unexpected delete request %v", r)
+	}
+}
@@ -0,0 +1,7 @@
+/*
+Package flatgeobuf implements the database interfaces for FlatGeobuf files.
+
+Each table is written into a separate .fgb file, including a packed Hilbert
+R-tree as spatial index. Diff imports are not supported.
+*/
+package flatgeobuf
@@ -0,0 +1,388 @@
+package flatgeobuf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/geom/ewkb"
+	"github.com/pkg/errors"
+)
+
+var magicBytes = []byte{'f', 'g', 'b', 3, 'f', 'g', 'b', 0}
+
+const defaultIndexNodeSize = 16
+
+type columnType uint8
+
+const (
+	colByte columnType = iota
+	colUByte
+	colBool
+	colShort
+	colUShort
+	colInt
+	colUInt
+	colLong
+	colULong
+	colFloat
+	colDouble
+	colString
+	colJSON
+	colDateTime
+	colBinary
+)
+
+var columnTypes = map[string]columnType{
+	"bool":          colBool,
+	"int8":          colShort,
+	"int32":         colInt,
+	"int64":         colLong,
+	"float32":       colFloat,
+	"string":        colString,
+	"hstore_string": colString,
+}
+
+type column struct {
+	name  string
+	typ   columnType
+	index int // index in row
+}
+
+// feature is a feature written to the spool file. bbox.offset is the
+// position in the spool file.
+type feature struct {
+	bbox nodeItem
+	size uint32
+}
+
+// tableWriter writes all features of a single table. Features are spooled
+// in a temporary file as they arrive and the final FlatGeobuf file is
+// written by finish, after all features are known and sorted.
+type tableWriter struct {
+	mu            sync.Mutex
+	spec          *database.TableSpec
+	filename      string
+	columns       []column
+	geomCol       int
+	indexNodeSize int
+
+	spool     *os.File
+	w         *bufio.Writer
+	spoolSize int64
+	features  []feature
+	hasZ      bool
+	noIndex   bool
+}
+
+func newTableWriter(spec *database.TableSpec, filename string, indexNodeSize int) (*tableWriter, error) {
+	tw := &tableWriter{
+		spec:          spec,
+		filename:      filename,
+		geomCol:       spec.GeometryColumn(),
+		indexNodeSize: indexNodeSize,
+	}
+	for i, col := range spec.Columns {
+		if col.IsGeometry() {
+			continue
+		}
+		typ, ok := columnTypes[col.FieldType.GoType]
+		if !ok {
+			return nil, errors.Errorf("unhandled column type %s for %s", col.FieldType.GoType, col.Name)
+		}
+		tw.columns = append(tw.columns, column{name: col.Name, typ: typ, index: i})
+	}
+
+	var err error
+	tw.spool, err = ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+"-")
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating spool file for %s", filename)
+	}
+	tw.w = bufio.NewWriterSize(tw.spool, 1<<20)
+	return tw, nil
+}
+
+// insert adds a new feature. row contains the values of all columns as
+// returned by mapping.Match.Row.
+func (tw *tableWriter) insert(row []interface{}) error {
+	f := &fbTable{}
+	bbox := emptyNodeItem(0)
+	hasGeom := false
+	hasZ := false
+	if tw.geomCol >= 0 {
+		if wkb, ok := row[tw.geomCol].(string); ok && wkb != "" {
+			g, err := ewkb.DecodeHex([]byte(wkb))
+			if err != nil {
+				return errors.Wrapf(err, "decoding geometry for %s", tw.spec.Name)
+			}
+			if !g.IsEmpty() {
+				bbox.minx, bbox.miny, bbox.maxx, bbox.maxy = g.Bounds()
+				f.addRef(0, geometryTable(g))
+				hasGeom = true
+				hasZ = g.HasZ
+			}
+		}
+	}
+	if props := tw.properties(row); len(props) > 0 {
+		f.addRef(1, byteVector(props))
+	}
+	buf := finishSizePrefixed(f)
+
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if _, err := tw.w.Write(buf); err != nil {
+		return errors.Wrapf(err, "writing feature for %s", tw.spec.Name)
+	}
+	bbox.offset = uint64(tw.spoolSize)
+	tw.features = append(tw.features, feature{bbox: bbox, size: uint32(len(buf))})
+	tw.spoolSize += int64(len(buf))
+	if !hasGeom {
+		// The packed R-tree requires a bbox for each feature.
+		tw.noIndex = true
+	}
+	tw.hasZ = tw.hasZ || hasZ
+	return nil
+}
+
+func (tw *tableWriter) properties(row []interface{}) []byte {
+	var buf []byte
+	var tmp [8]byte
+	for i, col := range tw.columns {
+		v := row[col.index]
+		if v == nil {
+			continue
+		}
+		var val []byte
+		switch col.typ {
+		case colBool:
+			b, ok := database.AsInt64(v)
+			if !ok {
+				continue
+			}
+			if b != 0 {
+				val = []byte{1}
+			} else {
+				val = []byte{0}
+			}
+		case colShort:
+			n, ok := database.AsInt64(v)
+			if !ok {
+				continue
+			}
+			binary.LittleEndian.PutUint16(tmp[:], uint16(int16(n)))
+			val = tmp[:2]
+		case colInt:
+			n, ok := database.AsInt64(v)
+			if !ok {
+				continue
+			}
+			binary.LittleEndian.PutUint32(tmp[:], uint32(int32(n)))
+			val = tmp[:4]
+		case colLong:
+			n, ok := database.AsInt64(v)
+			if !ok {
+				continue
+			}
+			binary.LittleEndian.PutUint64(tmp[:], uint64(n))
+			val = tmp[:8]
+		case colFloat:
+			n, ok := database.AsFloat64(v)
+			if !ok {
+				continue
+			}
+			binary.LittleEndian.PutUint32(tmp[:], math.Float32bits(float32(n)))
+			val = tmp[:4]
+		case colString:
+			s, ok := v.(string)
+			if !ok {
+				continue
+			}
+			binary.LittleEndian.PutUint32(tmp[:], uint32(len(s)))
+			val = append(tmp[:4:4], s...)
+		}
+		buf = append(buf, byte(i), byte(i>>8))
+		buf = append(buf, val...)
+	}
+	return buf
+}
+
+func geometryTable(g *ewkb.Geometry) *fbTable {
+	t := &fbTable{}
+	var coords []ewkb.Coord
+	var ends []uint32
+
+	switch g.Type {
+	case ewkb.Point, ewkb.LineString:
+		coords = g.Coords
+	case ewkb.Polygon:
+		for _, r := range g.Rings {
+			coords = append(coords, r...)
+			ends = append(ends, uint32(len(coords)))
+		}
+	case ewkb.MultiPoint:
+		for _, p := range g.Geoms {
+			coords = append(coords, p.Coords...)
+		}
+	case ewkb.MultiLineString:
+		for _, l := range g.Geoms {
+			coords = append(coords, l.Coords...)
+			ends = append(ends, uint32(len(coords)))
+		}
+	case ewkb.MultiPolygon, ewkb.GeometryCollection:
+		parts := make(fbTableVector, len(g.Geoms))
+		for i := range g.Geoms {
+			parts[i] = geometryTable(&g.Geoms[i])
+		}
+		t.addRef(7, parts)
+	}
+
+	if len(ends) > 1 {
+		t.addRef(0, uint32Vector(ends))
+	}
+	if len(coords) > 0 {
+		xy := make([]float64, 0, len(coords)*2)
+		for _, c := range coords {
+			xy = append(xy, c.X, c.Y)
+		}
+		t.addRef(1, float64Vector(xy))
+		if g.HasZ {
+			z := make([]float64, len(coords))
+			for i, c := range coords {
+				z[i] = c.Z
+			}
+			t.addRef(2, float64Vector(z))
+		}
+	}
+	t.addUint8(6, uint8(g.Type))
+	return t
+}
+
+func (tw *tableWriter) header(extent nodeItem, indexNodeSize int) []byte {
+	h := &fbTable{}
+	h.addRef(0, fbString(tw.spec.Name))
+	if len(tw.features) > 0 && !math.IsInf(extent.minx, 0) {
+		h.addRef(1, float64Vector([]float64{extent.minx, extent.miny, extent.maxx, extent.maxy}))
+	}
+	geomType := ewkb.Unknown
+	if tw.spec.GeometryType == "point" {
+		geomType = ewkb.Point
+	}
+	h.addUint8(2, uint8(geomType))
+	h.addBool(3, tw.hasZ)
+
+	if len(tw.columns) > 0 {
+		cols := make(fbTableVector, len(tw.columns))
+		for i, c := range tw.columns {
+			cols[i] = &fbTable{}
+			cols[i].addRef(0, fbString(c.name))
+			cols[i].addUint8(1, uint8(c.typ))
+		}
+		h.addRef(7, cols)
+	}
+	h.addUint64(8, uint64(len(tw.features)))
+	h.addUint16(9, uint16(indexNodeSize))
+	if tw.spec.Srid != 0 {
+		crs := &fbTable{}
+		crs.addRef(0, fbString("EPSG"))
+		crs.addInt32(1, int32(tw.spec.Srid))
+		h.addRef(10, crs)
+	}
+	return finishSizePrefixed(h)
+}
+
+// finish writes the FlatGeobuf file with all spooled features and removes
+// the spool file.
+func (tw *tableWriter) finish() error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if err := tw.w.Flush(); err != nil {
+		return errors.Wrapf(err, "writing spool file for %s", tw.spec.Name)
+	}
+
+	extent := emptyNodeItem(0)
+	for i := range tw.features {
+		extent.expand(&tw.features[i].bbox)
+	}
+
+	indexNodeSize := tw.indexNodeSize
+	if tw.noIndex || len(tw.features) == 0 {
+		indexNodeSize = 0
+	}
+	if indexNodeSize > 0 {
+		hilbertSort(tw.features, extent)
+	}
+
+	tmpName := tw.filename + ".tmp"
+	f, err := os.Create(tmpName)
+	if err != nil {
+		return errors.Wrapf(err, "creating %s", tmpName)
+	}
+	defer os.Remove(tmpName)
+	defer f.Close()
+	w := bufio.NewWriterSize(f, 1<<20)
+
+	if _, err := w.Write(magicBytes); err != nil {
+		return errors.Wrapf(err, "writing %s", tmpName)
+	}
+	if _, err := w.Write(tw.header(extent, indexNodeSize)); err != nil {
+		return errors.Wrapf(err, "writing %s", tmpName)
+	}
+
+	if indexNodeSize > 0 {
+		leaves := make([]nodeItem, len(tw.features))
+		var offset uint64
+		for i, feat := range tw.features {
+			leaves[i] = feat.bbox
+			leaves[i].offset = offset
+			offset += uint64(feat.size)
+		}
+		if err := writePackedRTree(w, leaves, indexNodeSize); err != nil {
+			return errors.Wrapf(err, "writing index to %s", tmpName)
+		}
+	}
+
+	var buf []byte
+	for _, feat := range tw.features {
+		if cap(buf) < int(feat.size) {
+			buf = make([]byte, feat.size)
+		}
+		buf = buf[:feat.size]
+		if _, err := tw.spool.ReadAt(buf, int64(feat.bbox.offset)); err != nil && err != io.EOF {
+			return errors.Wrapf(err, "reading spool file for %s", tw.spec.Name)
+		}
+		if _, err := w.Write(buf); err != nil {
+			return errors.Wrapf(err, "writing %s", tmpName)
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return errors.Wrapf(err, "writing %s", tmpName)
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "writing %s", tmpName)
+	}
+	if err := os.Rename(tmpName, tw.filename); err != nil {
+		return errors.Wrapf(err, "renaming %s", tmpName)
+	}
+	return tw.removeSpool()
+}
+
+// removeSpool closes and removes the spool file. Does nothing if the spool
+// file is already removed.
+func (tw *tableWriter) removeSpool() error {
+	if tw.spool == nil {
+		return nil
+	}
+	tw.spool.Close()
+	err := os.Remove(tw.spool.Name())
+	tw.spool = nil
+	tw.features = nil
+	return err
+}
@@ -0,0 +1,209 @@
+package flatgeobuf
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// This file contains a minimal FlatBuffers encoder, just enough to write
+// the FlatGeobuf header and feature tables.
+//
+// Other than the official builders, it serializes front-to-back: each table
+// is preceded by its vtable and all referenced objects (strings, vectors,
+// sub-tables) are appended after the table. All offsets are relative to
+// the start of the size prefixed buffer, so alignment works as expected by
+// readers that verify size prefixed buffers.
+
+type fbBuilder struct {
+	buf []byte
+}
+
+func (b *fbBuilder) pad(align int) {
+	for len(b.buf)%align != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+func (b *fbBuilder) putUint32(v uint32) {
+	b.buf = append(b.buf, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(b.buf[len(b.buf)-4:], v)
+}
+
+// patchOffset writes the uoffset from pos to target at pos.
+func (b *fbBuilder) patchOffset(pos, target int) {
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(target-pos))
+}
+
+type fbObject interface {
+	// build appends the object and returns the position other objects should
+	// point to.
+	build(b *fbBuilder) int
+}
+
+type fbString string
+
+func (s fbString) build(b *fbBuilder) int {
+	b.pad(4)
+	pos := len(b.buf)
+	b.putUint32(uint32(len(s)))
+	b.buf = append(b.buf, s...)
+	b.buf = append(b.buf, 0)
+	return pos
+}
+
+// fbVector is a vector of scalars. data contains the little endian encoded
+// elements.
+type fbVector struct {
+	elemSize int
+	data     []byte
+}
+
+func (v *fbVector) build(b *fbBuilder) int {
+	for len(b.buf)%4 != 0 || (len(b.buf)+4)%v.elemSize != 0 {
+		b.buf = append(b.buf, 0)
+	}
+	pos := len(b.buf)
+	b.putUint32(uint32(len(v.data) / v.elemSize))
+	b.buf = append(b.buf, v.data...)
+	return pos
+}
+
+func float64Vector(vals []float64) *fbVector {
+	data := make([]byte, len(vals)*8)
+	for i, v := range vals {
+		binary.LittleEndian.PutUint64(data[i*8:], math.Float64bits(v))
+	}
+	return &fbVector{elemSize: 8, data: data}
+}
+
+func uint32Vector(vals []uint32) *fbVector {
+	data := make([]byte, len(vals)*4)
+	for i, v := range vals {
+		binary.LittleEndian.PutUint32(data[i*4:], v)
+	}
+	return &fbVector{elemSize: 4, data: data}
+}
+
+func byteVector(data []byte) *fbVector {
+	return &fbVector{elemSize: 1, data: data}
+}
+
+type fbTableVector []*fbTable
+
+func (v fbTableVector) build(b *fbBuilder) int {
+	b.pad(4)
+	pos := len(b.buf)
+	b.putUint32(uint32(len(v)))
+	for range v {
+		b.putUint32(0)
+	}
+	for i, t := range v {
+		tpos := t.build(b)
+		b.patchOffset(pos+4+i*4, tpos)
+	}
+	return pos
+}
+
+type fbField struct {
+	id     int
+	size   int
+	scalar uint64
+	ref    fbObject
+}
+
+type fbTable struct {
+	fields []fbField
+}
+
+func (t *fbTable) addScalar(id, size int, v uint64) {
+	t.fields = append(t.fields, fbField{id: id, size: size, scalar: v})
+}
+
+func (t *fbTable) addUint8(id int, v uint8)   { t.addScalar(id, 1, uint64(v)) }
+func (t *fbTable) addUint16(id int, v uint16) { t.addScalar(id, 2, uint64(v)) }
+func (t *fbTable) addInt32(id int, v int32)   { t.addScalar(id, 4, uint64(uint32(v))) }
+func (t *fbTable) addUint64(id int, v uint64) { t.addScalar(id, 8, v) }
+func (t *fbTable) addBool(id int, v bool) {
+	if v {
+		t.addUint8(id, 1)
+	} else {
+		t.addUint8(id, 0)
+	}
+}
+
+// addRef adds a reference to a string, vector or table.
+func (t *fbTable) addRef(id int, obj fbObject) {
+	t.fields = append(t.fields, fbField{id: id, size: 4, ref: obj})
+}
+
+func (t *fbTable) build(b *fbBuilder) int {
+	fields := make([]fbField, len(t.fields))
+	copy(fields, t.fields)
+	// larger fields first to reduce padding
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].size > fields[j].size })
+
+	maxID := -1
+	align := 4
+	for _, f := range fields {
+		if f.id > maxID {
+			maxID = f.id
+		}
+		if f.size > align {
+			align = f.size
+		}
+	}
+
+	offsets := make([]int, len(fields))
+	size := 4 // soffset to vtable
+	for i, f := range fields {
+		for size%f.size != 0 {
+			size++
+		}
+		offsets[i] = size
+		size += f.size
+	}
+
+	b.pad(2)
+	vtPos := len(b.buf)
+	vt := make([]byte, 4+2*(maxID+1))
+	binary.LittleEndian.PutUint16(vt[0:], uint16(len(vt)))
+	binary.LittleEndian.PutUint16(vt[2:], uint16(size))
+	for i, f := range fields {
+		binary.LittleEndian.PutUint16(vt[4+2*f.id:], uint16(offsets[i]))
+	}
+	b.buf = append(b.buf, vt...)
+
+	b.pad(align)
+	pos := len(b.buf)
+	b.buf = append(b.buf, make([]byte, size)...)
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(int32(pos-vtPos)))
+	for i, f := range fields {
+		p := b.buf[pos+offsets[i]:]
+		switch f.size {
+		case 1:
+			p[0] = byte(f.scalar)
+		case 2:
+			binary.LittleEndian.PutUint16(p, uint16(f.scalar))
+		case 4:
+			binary.LittleEndian.PutUint32(p, uint32(f.scalar))
+		case 8:
+			binary.LittleEndian.PutUint64(p, f.scalar)
+		}
+	}
+	for i, f := range fields {
+		if f.ref != nil {
+			b.patchOffset(pos+offsets[i], f.ref.build(b))
+		}
+	}
+	return pos
+}
+
+// finishSizePrefixed serializes root as size prefixed buffer.
+func finishSizePrefixed(root *fbTable) []byte {
+	b := &fbBuilder{buf: make([]byte, 8, 256)}
+	b.patchOffset(4, root.build(b))
+	b.pad(4)
+	binary.LittleEndian.PutUint32(b.buf[0:], uint32(len(b.buf)-4))
+	return b.buf
+}
@@ -0,0 +1,157 @@
+package flatgeobuf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/mapping/config"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	database.Register("flatgeobuf", New)
+}
+
+type FlatGeobuf struct {
+	Config            database.Config
+	Dir               string
+	Tables            []*database.TableSpec
+	GeneralizedTables map[string]*config.GeneralizedTable
+	writers           map[string]*tableWriter
+}
+
+// New returns a FlatGeobuf database that writes one .fgb file for each
+// table into the directory of the connection string
+// (flatgeobuf:/path/to/dir).
+func New(conf database.Config, m *config.Mapping) (database.DB, error) {
+	dir := strings.TrimPrefix(conf.ConnectionParams, "flatgeobuf:")
+	if dir == "" {
+		return nil, errors.New("missing output directory in flatgeobuf connection")
+	}
+	tables, err := database.NewTableSpecs(m, conf.Srid)
+	if err != nil {
+		return nil, err
+	}
+	return &FlatGeobuf{
+		Config:            conf,
+		Dir:               dir,
+		Tables:            tables,
+		GeneralizedTables: m.GeneralizedTables,
+	}, nil
+}
+
+func (fgb *FlatGeobuf) filename(table string) string {
+	return filepath.Join(fgb.Dir, table+".fgb")
+}
+
+// Init creates the output directory and the spool files of all tables.
+func (fgb *FlatGeobuf) Init() error {
+	if err := os.MkdirAll(fgb.Dir, 0755); err != nil {
+		return errors.Wrapf(err, "creating output directory %s", fgb.Dir)
+	}
+	fgb.writers = make(map[string]*tableWriter, len(fgb.Tables))
+	for _, spec := range fgb.Tables {
+		tw, err := newTableWriter(spec, fgb.filename(spec.Name), defaultIndexNodeSize)
+		if err != nil {
+			return err
+		}
+		fgb.writers[spec.Name] = tw
+	}
+	return nil
+}
+
+func (fgb *FlatGeobuf) Begin() error { return nil }
+func (fgb *FlatGeobuf) End() error   { return nil }
+
+// Abort removes all spool files. Existing .fgb files are kept.
+func (fgb *FlatGeobuf) Abort() error {
+	return fgb.removeSpools()
+}
+
+func (fgb *FlatGeobuf) Close() error {
+	return fgb.removeSpools()
+}
+
+func (fgb *FlatGeobuf) removeSpools() error {
+	var lastErr error
+	for _, tw := range fgb.writers {
+		if err := tw.removeSpool(); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (fgb *FlatGeobuf) insert(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	for _, match := range matches {
+		row := match.Row(&elem, &geom)
+		if err := fgb.writeRow(match.Table.Name, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fgb *FlatGeobuf) writeRow(table string, row []interface{}) error {
+	tw, ok := fgb.writers[table]
+	if !ok {
+		return errors.Errorf("unknown table %s", table)
+	}
+	return tw.insert(row)
+}
+
+func (fgb *FlatGeobuf) InsertPoint(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return fgb.insert(elem, geom, matches)
+}
+
+func (fgb *FlatGeobuf) InsertLineString(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return fgb.insert(elem, geom, matches)
+}
+
+func (fgb *FlatGeobuf) InsertPolygon(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return fgb.insert(elem, geom, matches)
+}
+
+func (fgb *FlatGeobuf) InsertRelationMember(rel osm.Relation, m osm.Member, geom geom.Geometry, matches []mapping.Match) error {
+	for _, match := range matches {
+		row := match.MemberRow(&rel, &m, &geom)
+		if err := fgb.writeRow(match.Table.Name, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Generalize does nothing, generalized tables are only supported by PostGIS.
+func (fgb *FlatGeobuf) Generalize() error {
+	if len(fgb.GeneralizedTables) > 0 {
+		log.Printf("[warn] generalized tables are not supported by FlatGeobuf output, skipping %d tables", len(fgb.GeneralizedTables))
+	}
+	return nil
+}
+
+func (fgb *FlatGeobuf) EnableGeneralizeUpdates() {}
+
+func (fgb *FlatGeobuf) GeneralizeUpdates() error { return nil }
+
+// Finish sorts the features and writes the .fgb file with the spatial
+// index for each table.
+func (fgb *FlatGeobuf) Finish() error {
+	defer log.Step("Writing FlatGeobuf files")()
+
+	for _, spec := range fgb.Tables {
+		tw := fgb.writers[spec.Name]
+		step := log.Step("Writing " + tw.filename)
+		if err := tw.finish(); err != nil {
+			return err
+		}
+		step()
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package flatgeobuf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/mapping"
+)
+
+// fbTableReader reads fields from a serialized FlatBuffers table.
+type fbTableReader struct {
+	t   *testing.T
+	buf []byte
+	pos int
+}
+
+func rootTable(t *testing.T, buf []byte) fbTableReader {
+	// skip size prefix
+	pos := 4 + int(binary.LittleEndian.Uint32(buf[4:]))
+	return fbTableReader{t, buf, pos}
+}
+
+func (r fbTableReader) field(id int) (int, bool) {
+	vt := r.pos - int(int32(binary.LittleEndian.Uint32(r.buf[r.pos:])))
+	if vt%2 != 0 {
+		r.t.Fatalf("unaligned vtable at %d", vt)
+	}
+	vtSize := int(binary.LittleEndian.Uint16(r.buf[vt:]))
+	if 4+2*id >= vtSize {
+		return 0, false
+	}
+	off := int(binary.LittleEndian.Uint16(r.buf[vt+4+2*id:]))
+	if off == 0 {
+		return 0, false
+	}
+	return r.pos + off, true
+}
+
+func (r fbTableReader) uint8(id int) uint8 {
+	if p, ok := r.field(id); ok {
+		return r.buf[p]
+	}
+	return 0
+}
+
+func (r fbTableReader) uint64(id int) uint64 {
+	if p, ok := r.field(id); ok {
+		if p%8 != 0 {
+			r.t.Fatalf("unaligned uint64 field %d", id)
+		}
+		return binary.LittleEndian.Uint64(r.buf[p:])
+	}
+	return 0
+}
+
+func (r fbTableReader) ref(id int) (int, bool) {
+	p, ok := r.field(id)
+	if !ok {
+		return 0, false
+	}
+	return p + int(binary.LittleEndian.Uint32(r.buf[p:])), true
+}
+
+func (r fbTableReader) string(id int) string {
+	p, ok := r.ref(id)
+	if !ok {
+		return ""
+	}
+	n := int(binary.LittleEndian.Uint32(r.buf[p:]))
+	return string(r.buf[p+4 : p+4+n])
+}
+
+func (r fbTableReader) float64s(id int) []float64 {
+	p, ok := r.ref(id)
+	if !ok {
+		return nil
+	}
+	if (p+4)%8 != 0 {
+		r.t.Fatalf("unaligned float64 vector %d", id)
+	}
+	n := int(binary.LittleEndian.Uint32(r.buf[p:]))
+	vals := make([]float64, n)
+	for i := range vals {
+		vals[i] = math.Float64frombits(binary.LittleEndian.Uint64(r.buf[p+4+i*8:]))
+	}
+	return vals
+}
+
+func (r fbTableReader) bytes(id int) []byte {
+	p, ok := r.ref(id)
+	if !ok {
+		return nil
+	}
+	n := int(binary.LittleEndian.Uint32(r.buf[p:]))
+	return r.buf[p+4 : p+4+n]
+}
+
+func (r fbTableReader) tables(id int) []fbTableReader {
+	p, ok := r.ref(id)
+	if !ok {
+		return nil
+	}
+	n := int(binary.LittleEndian.Uint32(r.buf[p:]))
+	tables := make([]fbTableReader, n)
+	for i := range tables {
+		e := p + 4 + i*4
+		tables[i] = fbTableReader{r.t, r.buf, e + int(binary.LittleEndian.Uint32(r.buf[e:]))}
+	}
+	return tables
+}
+
+func pointWkb(x, y float64) string {
+	buf := &bytes.Buffer{}
+	buf.WriteByte(1)
+	binary.Write(buf, binary.LittleEndian, uint32(1|0x20000000))
+	binary.Write(buf, binary.LittleEndian, uint32(4326))
+	binary.Write(buf, binary.LittleEndian, x)
+	binary.Write(buf, binary.LittleEndian, y)
+	return hex.EncodeToString(buf.Bytes())
+}
+
+func TestTableWriter(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "imposm3_fgb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	spec := &database.TableSpec{
+		Name:         "pois",
+		GeometryType: "point",
+		Srid:         4326,
+		Columns: []database.ColumnSpec{
+			{Name: "osm_id", FieldType: mapping.ColumnType{GoType: "int64"}},
+			{Name: "geometry", FieldType: mapping.ColumnType{GoType: "geometry"}},
+			{Name: "name", FieldType: mapping.ColumnType{GoType: "string"}},
+		},
+	}
+	filename := filepath.Join(tmpdir, "pois.fgb")
+	tw, err := newTableWriter(spec, filename, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const numFeatures = 100
+	for i := 0; i < numFeatures; i++ {
+		var name interface{}
+		if i%2 == 0 {
+			name = "poi"
+		}
+		row := []interface{}{int64(i), pointWkb(float64(i%10), float64(i/10)), name}
+		if err := tw.insert(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(tmpdir, "*"))
+	if len(files) != 1 {
+		t.Errorf("spool or tmp files not removed: %v", files)
+	}
+
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:8], magicBytes) {
+		t.Fatal("invalid magic bytes")
+	}
+	buf = buf[8:]
+	headerSize := 4 + int(binary.LittleEndian.Uint32(buf))
+	h := rootTable(t, buf[:headerSize])
+	if name := h.string(0); name != "pois" {
+		t.Errorf("unexpected name %q", name)
+	}
+	if env := h.float64s(1); len(env) != 4 || env[0] != 0 || env[1] != 0 || env[2] != 9 || env[3] != 9 {
+		t.Errorf("unexpected envelope %v", env)
+	}
+	if typ := h.uint8(2); typ != 1 {
+		t.Errorf("unexpected geometry type %d", typ)
+	}
+	if n := h.uint64(8); n != numFeatures {
+		t.Errorf("unexpected features_count %d", n)
+	}
+	cols := h.tables(7)
+	if len(cols) != 2 || cols[0].string(0) != "osm_id" || cols[0].uint8(1) != uint8(colLong) ||
+		cols[1].string(0) != "name" || cols[1].uint8(1) != uint8(colString) {
+		t.Errorf("unexpected columns")
+	}
+	crs, ok := h.ref(10)
+	if !ok {
+		t.Fatal("missing crs")
+	}
+	crsTable := fbTableReader{t, buf, crs}
+	if crsTable.string(0) != "EPSG" {
+		t.Errorf("unexpected crs org %q", crsTable.string(0))
+	}
+	if p, ok := crsTable.field(1); !ok || binary.LittleEndian.Uint32(buf[p:]) != 4326 {
+		t.Errorf("unexpected crs code")
+	}
+	buf = buf[headerSize:]
+
+	// 100 leaves + 25 + 7 + 2 + 1 nodes for node size 4
+	indexSize := (100 + 25 + 7 + 2 + 1) * nodeItemSize
+	root := buf[:nodeItemSize]
+	if minx := math.Float64frombits(binary.LittleEndian.Uint64(root)); minx != 0 {
+		t.Errorf("unexpected root minx %v", minx)
+	}
+	if maxy := math.Float64frombits(binary.LittleEndian.Uint64(root[24:])); maxy != 9 {
+		t.Errorf("unexpected root maxy %v", maxy)
+	}
+	index := buf[:indexSize]
+	buf = buf[indexSize:]
+
+	// check that all leaves point to features with the same bbox
+	for i := indexSize/nodeItemSize - numFeatures; i < indexSize/nodeItemSize; i++ {
+		node := index[i*nodeItemSize:]
+		x := math.Float64frombits(binary.LittleEndian.Uint64(node))
+		y := math.Float64frombits(binary.LittleEndian.Uint64(node[8:]))
+		offset := binary.LittleEndian.Uint64(node[32:])
+		size := 4 + int(binary.LittleEndian.Uint32(buf[offset:]))
+
+		f := rootTable(t, buf[offset:int(offset)+size])
+		p, ok := f.ref(0)
+		if !ok {
+			t.Fatal("missing geometry")
+		}
+		g := fbTableReader{t, f.buf, p}
+		if typ := g.uint8(6); typ != 1 {
+			t.Errorf("unexpected geometry type %d", typ)
+		}
+		if xy := g.float64s(1); len(xy) != 2 || xy[0] != x || xy[1] != y {
+			t.Errorf("unexpected coords %v for %v %v", xy, x, y)
+		}
+		props := f.bytes(1)
+		if len(props) < 10 || binary.LittleEndian.Uint16(props) != 0 {
+			t.Fatalf("unexpected properties %v", props)
+		}
+		id := int64(binary.LittleEndian.Uint64(props[2:]))
+		if float64(id%10) != x || float64(id/10) != y {
+			t.Errorf("unexpected osm_id %d for %v %v", id, x, y)
+		}
+		if id%2 == 0 {
+			if !bytes.Equal(props[10:], []byte{1, 0, 3, 0, 0, 0, 'p', 'o', 'i'}) {
+				t.Errorf("unexpected name property %v", props[10:])
+			}
+		} else if len(props) != 10 {
+			t.Errorf("unexpected name property %v", props[10:])
+		}
+	}
+}
+
+func TestLevelBounds(t *testing.T) {
+	for _, tc := range []struct {
+		numItems, nodeSize int
+		expected           [][2]int
+	}{
+		{1, 16, [][2]int{{1, 2}, {0, 1}}},
+		{16, 16, [][2]int{{1, 17}, {0, 1}}},
+		{17, 16, [][2]int{{3, 20}, {1, 3}, {0, 1}}},
+	} {
+		bounds := levelBounds(tc.numItems, tc.nodeSize)
+		if len(bounds) != len(tc.expected) {
+			t.Errorf("unexpected bounds %v for %d", bounds, tc.numItems)
+			continue
+		}
+		for i := range bounds {
+			if bounds[i] != tc.expected[i] {
+				t.Errorf("unexpected bounds %v for %d", bounds, tc.numItems)
+			}
+		}
+	}
+}
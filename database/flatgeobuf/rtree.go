@@ -0,0 +1,185 @@
+package flatgeobuf
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+)
+
+// nodeItem is a node of the packed Hilbert R-tree. offset is the byte
+// offset of the feature for leaf nodes and the index of the first child
+// node for all other nodes.
+type nodeItem struct {
+	minx, miny, maxx, maxy float64
+	offset                 uint64
+}
+
+const nodeItemSize = 40
+
+func emptyNodeItem(offset uint64) nodeItem {
+	return nodeItem{
+		minx: math.Inf(1), miny: math.Inf(1),
+		maxx: math.Inf(-1), maxy: math.Inf(-1),
+		offset: offset,
+	}
+}
+
+func (n *nodeItem) expand(o *nodeItem) {
+	n.minx = math.Min(n.minx, o.minx)
+	n.miny = math.Min(n.miny, o.miny)
+	n.maxx = math.Max(n.maxx, o.maxx)
+	n.maxy = math.Max(n.maxy, o.maxy)
+}
+
+func (n *nodeItem) writeTo(w io.Writer) error {
+	var buf [nodeItemSize]byte
+	binary.LittleEndian.PutUint64(buf[0:], math.Float64bits(n.minx))
+	binary.LittleEndian.PutUint64(buf[8:], math.Float64bits(n.miny))
+	binary.LittleEndian.PutUint64(buf[16:], math.Float64bits(n.maxx))
+	binary.LittleEndian.PutUint64(buf[24:], math.Float64bits(n.maxy))
+	binary.LittleEndian.PutUint64(buf[32:], n.offset)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// levelBounds returns the [start, end) node indices of each tree level,
+// starting with the leaves. The root is always at index 0.
+func levelBounds(numItems, nodeSize int) [][2]int {
+	n := numItems
+	numNodes := n
+	levelNumNodes := []int{n}
+	for {
+		n = (n + nodeSize - 1) / nodeSize
+		numNodes += n
+		levelNumNodes = append(levelNumNodes, n)
+		if n == 1 {
+			break
+		}
+	}
+	bounds := make([][2]int, len(levelNumNodes))
+	n = numNodes
+	for i, size := range levelNumNodes {
+		bounds[i] = [2]int{n - size, n}
+		n -= size
+	}
+	return bounds
+}
+
+// writePackedRTree writes the index for the (already sorted) leaves.
+func writePackedRTree(w io.Writer, leaves []nodeItem, nodeSize int) error {
+	bounds := levelBounds(len(leaves), nodeSize)
+	numNodes := bounds[0][1]
+	leafStart := bounds[0][0]
+
+	// only the inner nodes are kept in memory, leaves are accessed directly
+	inner := make([]nodeItem, leafStart)
+	node := func(i int) *nodeItem {
+		if i >= leafStart {
+			return &leaves[i-leafStart]
+		}
+		return &inner[i]
+	}
+	for i := 0; i < len(bounds)-1; i++ {
+		pos, end := bounds[i][0], bounds[i][1]
+		parent := bounds[i+1][0]
+		for pos < end {
+			n := emptyNodeItem(uint64(pos))
+			for j := 0; j < nodeSize && pos < end; j++ {
+				n.expand(node(pos))
+				pos++
+			}
+			inner[parent] = n
+			parent++
+		}
+	}
+	for i := 0; i < numNodes; i++ {
+		if err := node(i).writeTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const hilbertMax = (1 << 16) - 1
+
+// hilbert returns the Hilbert curve index of x/y, each in the range of 0
+// to hilbertMax. Based on public domain code from
+// https://github.com/rawrunprotected/hilbert_curves
+func hilbert(x, y uint32) uint32 {
+	a := x ^ y
+	b := 0xFFFF ^ a
+	c := 0xFFFF ^ (x | y)
+	d := x & (y ^ 0xFFFF)
+
+	A := a | (b >> 1)
+	B := (a >> 1) ^ a
+	C := ((c >> 1) ^ (b & (d >> 1))) ^ c
+	D := ((a & (c >> 1)) ^ (d >> 1)) ^ d
+
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 2)) ^ (b & (b >> 2))
+	B = (a & (b >> 2)) ^ (b & ((a ^ b) >> 2))
+	C ^= (a & (c >> 2)) ^ (b & (d >> 2))
+	D ^= (b & (c >> 2)) ^ ((a ^ b) & (d >> 2))
+
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 4)) ^ (b & (b >> 4))
+	B = (a & (b >> 4)) ^ (b & ((a ^ b) >> 4))
+	C ^= (a & (c >> 4)) ^ (b & (d >> 4))
+	D ^= (b & (c >> 4)) ^ ((a ^ b) & (d >> 4))
+
+	a, b, c, d = A, B, C, D
+	C ^= (a & (c >> 8)) ^ (b & (d >> 8))
+	D ^= (b & (c >> 8)) ^ ((a ^ b) & (d >> 8))
+
+	a = C ^ (C >> 1)
+	b = D ^ (D >> 1)
+
+	i0 := x ^ y
+	i1 := b | (0xFFFF ^ (i0 | a))
+
+	i0 = (i0 | (i0 << 8)) & 0x00FF00FF
+	i0 = (i0 | (i0 << 4)) & 0x0F0F0F0F
+	i0 = (i0 | (i0 << 2)) & 0x33333333
+	i0 = (i0 | (i0 << 1)) & 0x55555555
+
+	i1 = (i1 | (i1 << 8)) & 0x00FF00FF
+	i1 = (i1 | (i1 << 4)) & 0x0F0F0F0F
+	i1 = (i1 | (i1 << 2)) & 0x33333333
+	i1 = (i1 | (i1 << 1)) & 0x55555555
+
+	return (i1 << 1) | i0
+}
+
+// hilbertSort sorts the features by the Hilbert index of their bbox center
+// within extent.
+func hilbertSort(features []feature, extent nodeItem) {
+	width := extent.maxx - extent.minx
+	height := extent.maxy - extent.miny
+	values := make([]uint32, len(features))
+	for i := range features {
+		f := &features[i]
+		var x, y uint32
+		if width != 0 {
+			x = uint32(math.Floor(hilbertMax * ((f.bbox.minx+f.bbox.maxx)/2 - extent.minx) / width))
+		}
+		if height != 0 {
+			y = uint32(math.Floor(hilbertMax * ((f.bbox.miny+f.bbox.maxy)/2 - extent.miny) / height))
+		}
+		values[i] = hilbert(x, y)
+	}
+	sort.Sort(&byHilbert{features, values})
+}
+
+type byHilbert struct {
+	features []feature
+	values   []uint32
+}
+
+func (h *byHilbert) Len() int           { return len(h.features) }
+func (h *byHilbert) Less(i, j int) bool { return h.values[i] > h.values[j] }
+func (h *byHilbert) Swap(i, j int) {
+	h.features[i], h.features[j] = h.features[j], h.features[i]
+	h.values[i], h.values[j] = h.values[j], h.values[i]
+}
@@ -0,0 +1,9 @@
+/*
+Package geojsonseq implements the database interfaces for newline delimited
+GeoJSON (GeoJSONSeq) output.
+
+Each row is written as a single GeoJSON feature, either into a .geojsonl file
+for each table or into stdout, e.g. for piping into tippecanoe. Diff imports
+are not supported.
+*/
+package geojsonseq
@@ -0,0 +1,260 @@
+package geojsonseq
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/geom/ewkb"
+	"github.com/omniscale/imposm3/geom/geojson"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/mapping/config"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	database.Register("geojsonseq", New)
+}
+
+// output is a newline delimited GeoJSON stream. It is shared by all tables
+// when writing to stdout.
+type output struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+	c  io.Closer
+}
+
+func (o *output) write(line []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.w == nil {
+		return errors.New("output already closed")
+	}
+	_, err := o.w.Write(line)
+	return err
+}
+
+func (o *output) close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.w == nil {
+		return nil
+	}
+	err := o.w.Flush()
+	o.w = nil
+	if o.c != nil {
+		if cerr := o.c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+type table struct {
+	spec    *database.TableSpec
+	geomCol int
+	names   [][]byte // JSON encoded column names
+	out     *output
+	// layer is added as tippecanoe layer name when all tables are written
+	// into one stream
+	layer []byte
+}
+
+type GeoJSONSeq struct {
+	Config            database.Config
+	Dir               string
+	Stdout            bool
+	Tables            []*database.TableSpec
+	GeneralizedTables map[string]*config.GeneralizedTable
+	tables            map[string]*table
+	outputs           []*output
+}
+
+// New returns a GeoJSONSeq database that writes newline delimited GeoJSON
+// features. Features are written into a .geojsonl file for each table in
+// the directory of the connection string (geojsonseq:/path/to/dir), or all
+// into stdout (geojsonseq:-).
+func New(conf database.Config, m *config.Mapping) (database.DB, error) {
+	dir := strings.TrimPrefix(conf.ConnectionParams, "geojsonseq:")
+	if dir == "" {
+		return nil, errors.New("missing output directory in geojsonseq connection, use - for stdout")
+	}
+	tables, err := database.NewTableSpecs(m, conf.Srid)
+	if err != nil {
+		return nil, err
+	}
+	db := &GeoJSONSeq{
+		Config:            conf,
+		Tables:            tables,
+		GeneralizedTables: m.GeneralizedTables,
+	}
+	if dir == "-" {
+		db.Stdout = true
+	} else {
+		db.Dir = dir
+	}
+	return db, nil
+}
+
+// Init creates the output files of all tables.
+func (db *GeoJSONSeq) Init() error {
+	var stdout *output
+	if db.Stdout {
+		stdout = &output{w: bufio.NewWriterSize(os.Stdout, 1<<16)}
+		db.outputs = append(db.outputs, stdout)
+	} else if err := os.MkdirAll(db.Dir, 0755); err != nil {
+		return errors.Wrapf(err, "creating output directory %s", db.Dir)
+	}
+
+	db.tables = make(map[string]*table, len(db.Tables))
+	for _, spec := range db.Tables {
+		t := &table{spec: spec, geomCol: spec.GeometryColumn()}
+		for _, col := range spec.Columns {
+			name, _ := json.Marshal(col.Name)
+			t.names = append(t.names, name)
+		}
+		if stdout != nil {
+			t.out = stdout
+			t.layer, _ = json.Marshal(spec.Name)
+		} else {
+			filename := filepath.Join(db.Dir, spec.Name+".geojsonl")
+			f, err := os.Create(filename)
+			if err != nil {
+				return errors.Wrapf(err, "creating %s", filename)
+			}
+			t.out = &output{w: bufio.NewWriterSize(f, 1<<20), c: f}
+			db.outputs = append(db.outputs, t.out)
+		}
+		db.tables[spec.Name] = t
+	}
+	return nil
+}
+
+func (db *GeoJSONSeq) Begin() error { return nil }
+func (db *GeoJSONSeq) End() error   { return nil }
+func (db *GeoJSONSeq) Abort() error { return db.Close() }
+
+func (db *GeoJSONSeq) Close() error {
+	var lastErr error
+	for _, o := range db.outputs {
+		if err := o.close(); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// feature returns the GeoJSON feature for the row, terminated by a newline.
+func (t *table) feature(row []interface{}) ([]byte, error) {
+	buf := make([]byte, 0, 256)
+	buf = append(buf, `{"type":"Feature",`...)
+	if t.layer != nil {
+		buf = append(buf, `"tippecanoe":{"layer":`...)
+		buf = append(buf, t.layer...)
+		buf = append(buf, "},"...)
+	}
+	buf = append(buf, `"properties":{`...)
+	first := true
+	for i, v := range row {
+		if i == t.geomCol || v == nil {
+			continue
+		}
+		val, err := json.Marshal(v)
+		if err != nil {
+			// e.g. NaN values
+			continue
+		}
+		if !first {
+			buf = append(buf, ',')
+		}
+		first = false
+		buf = append(buf, t.names[i]...)
+		buf = append(buf, ':')
+		buf = append(buf, val...)
+	}
+	buf = append(buf, `},"geometry":`...)
+
+	var g *ewkb.Geometry
+	if t.geomCol >= 0 {
+		if wkb, ok := row[t.geomCol].(string); ok && wkb != "" {
+			var err error
+			g, err = ewkb.DecodeHex([]byte(wkb))
+			if err != nil {
+				return nil, errors.Wrapf(err, "decoding geometry for %s", t.spec.Name)
+			}
+		}
+	}
+	if g != nil {
+		buf = geojson.AppendGeometry(buf, g)
+	} else {
+		buf = append(buf, "null"...)
+	}
+	return append(buf, "}\n"...), nil
+}
+
+func (db *GeoJSONSeq) writeRow(table string, row []interface{}) error {
+	t, ok := db.tables[table]
+	if !ok {
+		return errors.Errorf("unknown table %s", table)
+	}
+	line, err := t.feature(row)
+	if err != nil {
+		return err
+	}
+	return t.out.write(line)
+}
+
+func (db *GeoJSONSeq) insert(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	for _, match := range matches {
+		if err := db.writeRow(match.Table.Name, match.Row(&elem, &geom)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *GeoJSONSeq) InsertPoint(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return db.insert(elem, geom, matches)
+}
+
+func (db *GeoJSONSeq) InsertLineString(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return db.insert(elem, geom, matches)
+}
+
+func (db *GeoJSONSeq) InsertPolygon(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return db.insert(elem, geom, matches)
+}
+
+func (db *GeoJSONSeq) InsertRelationMember(rel osm.Relation, m osm.Member, geom geom.Geometry, matches []mapping.Match) error {
+	for _, match := range matches {
+		if err := db.writeRow(match.Table.Name, match.MemberRow(&rel, &m, &geom)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Generalize does nothing, generalized tables are only supported by PostGIS.
+func (db *GeoJSONSeq) Generalize() error {
+	if len(db.GeneralizedTables) > 0 {
+		log.Printf("[warn] generalized tables are not supported by GeoJSONSeq output, skipping %d tables", len(db.GeneralizedTables))
+	}
+	return nil
+}
+
+func (db *GeoJSONSeq) EnableGeneralizeUpdates() {}
+
+func (db *GeoJSONSeq) GeneralizeUpdates() error { return nil }
+
+// Finish flushes and closes all outputs.
+func (db *GeoJSONSeq) Finish() error {
+	return db.Close()
+}
@@ -0,0 +1,52 @@
+package geojsonseq
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/mapping"
+)
+
+func TestFeature(t *testing.T) {
+	spec := &database.TableSpec{
+		Name: "roads",
+		Columns: []database.ColumnSpec{
+			{Name: "osm_id", FieldType: mapping.ColumnType{GoType: "int64"}},
+			{Name: "geometry", FieldType: mapping.ColumnType{GoType: "geometry"}},
+			{Name: "name", FieldType: mapping.ColumnType{GoType: "string"}},
+			{Name: "oneway", FieldType: mapping.ColumnType{GoType: "int8"}},
+		},
+	}
+	tbl := &table{spec: spec, geomCol: 1}
+	for _, col := range spec.Columns {
+		name, _ := json.Marshal(col.Name)
+		tbl.names = append(tbl.names, name)
+	}
+
+	// LINESTRING(1 2, 3 4) with SRID 4326
+	wkb := "0102000020E610000002000000000000000000F03F000000000000004000000000000008400000000000001040"
+	line, err := tbl.feature([]interface{}{int64(42), wkb, `Main "Street"`, nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"type":"Feature","properties":{"osm_id":42,"name":"Main \"Street\""},` +
+		`"geometry":{"type":"LineString","coordinates":[[1,2],[3,4]]}}` + "\n"
+	if string(line) != expected {
+		t.Errorf("unexpected feature\n%s\n%s", line, expected)
+	}
+
+	tbl.layer = []byte(`"roads"`)
+	line, err = tbl.feature([]interface{}{int64(42), "", nil, -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `{"type":"Feature","tippecanoe":{"layer":"roads"},"properties":{"osm_id":42,"oneway":-1},"geometry":null}` + "\n"
+	if string(line) != expected {
+		t.Errorf("unexpected feature\n%s\n%s", line, expected)
+	}
+
+	if _, err := tbl.feature([]interface{}{int64(42), "01FF", nil, nil}); err == nil {
+		t.Error("expected error for invalid geometry")
+	}
+}
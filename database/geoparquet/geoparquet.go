@@ -0,0 +1,486 @@
+package geoparquet
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/geom/ewkb"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/mapping/config"
+	"github.com/omniscale/imposm3/proj"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	database.Register("geoparquet", New)
+}
+
+const defaultRowGroupSize = 64 * 1024
+
+var physicalTypes = map[string]columnSchema{
+	"bool":               {typ: typeBoolean},
+	"int8":               {typ: typeInt32},
+	"int32":              {typ: typeInt32},
+	"int64":              {typ: typeInt64},
+	"float32":            {typ: typeFloat},
+	"string":             {typ: typeByteArray, utf8: true},
+	"hstore_string":      {typ: typeByteArray, utf8: true},
+	"geometry":           {typ: typeByteArray},
+	"validated_geometry": {typ: typeByteArray},
+}
+
+type GeoParquet struct {
+	Config            database.Config
+	Dir               string
+	Grid              int
+	Codec             compressionCodec
+	RowGroupSize      int
+	Tables            []*database.TableSpec
+	GeneralizedTables map[string]*config.GeneralizedTable
+	tables            map[string]*table
+	extentOnce        sync.Once
+	gridExtent        [4]float64
+}
+
+// New returns a GeoParquet database that writes a .parquet file for each
+// table into the directory of the connection string
+// (geoparquet:/path/to/dir). The following options can be appended as
+// query parameters:
+//
+//	grid=N: partition each table into a grid of NxN cells
+//	compression=gzip|none: page compression (default gzip)
+//	rowgroup=N: number of rows for each row group
+func New(conf database.Config, m *config.Mapping) (database.DB, error) {
+	db, err := NewGeoParquet(conf, m, "geoparquet")
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// NewGeoParquet returns a new GeoParquet database for connection strings
+// with the given prefix.
+func NewGeoParquet(conf database.Config, m *config.Mapping, prefix string) (*GeoParquet, error) {
+	params := strings.TrimPrefix(conf.ConnectionParams, prefix+":")
+	db := &GeoParquet{
+		Config:            conf,
+		Codec:             codecGzip,
+		RowGroupSize:      defaultRowGroupSize,
+		GeneralizedTables: m.GeneralizedTables,
+	}
+	if idx := strings.IndexByte(params, '?'); idx >= 0 {
+		opts, err := url.ParseQuery(params[idx+1:])
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing %s options", prefix)
+		}
+		params = params[:idx]
+		if err := db.parseOptions(opts); err != nil {
+			return nil, err
+		}
+	}
+	if params == "" {
+		return nil, errors.Errorf("missing output directory in %s connection", prefix)
+	}
+	db.Dir = params
+	if _, err := proj.ProjJSON(conf.Srid); err != nil {
+		return nil, errors.Wrapf(err, "CRS metadata of %s output", prefix)
+	}
+
+	var err error
+	db.Tables, err = database.NewTableSpecs(m, conf.Srid)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *GeoParquet) parseOptions(opts url.Values) error {
+	for k, v := range opts {
+		switch k {
+		case "grid":
+			n, err := strconv.Atoi(v[0])
+			if err != nil || n < 1 {
+				return errors.Errorf("invalid grid option %q", v[0])
+			}
+			db.Grid = n
+		case "rowgroup":
+			n, err := strconv.Atoi(v[0])
+			if err != nil || n < 1 {
+				return errors.Errorf("invalid rowgroup option %q", v[0])
+			}
+			db.RowGroupSize = n
+		case "compression":
+			switch v[0] {
+			case "gzip":
+				db.Codec = codecGzip
+			case "none":
+				db.Codec = codecUncompressed
+			default:
+				return errors.Errorf("unsupported compression %q", v[0])
+			}
+		default:
+			return errors.Errorf("unknown option %q", k)
+		}
+	}
+	return nil
+}
+
+type cell struct {
+	x, y int
+}
+
+type table struct {
+	mu      sync.Mutex
+	db      *GeoParquet
+	spec    *database.TableSpec
+	columns []columnSchema
+	geomCol int
+	files   map[cell]*file
+}
+
+type file struct {
+	mu       sync.Mutex
+	filename string
+	f        *os.File
+	bw       *bufio.Writer
+	pw       *parquetWriter
+	bbox     [4]float64
+	geomType map[string]struct{}
+}
+
+// Init creates the output directory.
+func (db *GeoParquet) Init() error {
+	if err := os.MkdirAll(db.Dir, 0755); err != nil {
+		return errors.Wrapf(err, "creating output directory %s", db.Dir)
+	}
+	db.tables = make(map[string]*table, len(db.Tables))
+	for _, spec := range db.Tables {
+		t := &table{
+			db:      db,
+			spec:    spec,
+			geomCol: spec.GeometryColumn(),
+			files:   make(map[cell]*file),
+		}
+		for _, col := range spec.Columns {
+			cs, ok := physicalTypes[col.FieldType.GoType]
+			if !ok {
+				return errors.Errorf("unhandled column type %s for %s", col.FieldType.GoType, col.Name)
+			}
+			cs.name = col.Name
+			t.columns = append(t.columns, cs)
+		}
+		db.tables[spec.Name] = t
+	}
+	return nil
+}
+
+func (db *GeoParquet) Begin() error { return nil }
+func (db *GeoParquet) End() error   { return nil }
+
+// Abort removes all incomplete files.
+func (db *GeoParquet) Abort() error {
+	for _, t := range db.tables {
+		for _, f := range t.files {
+			f.remove()
+		}
+	}
+	return nil
+}
+
+func (db *GeoParquet) Close() error {
+	return db.Abort()
+}
+
+// extent returns the extent of the grid partitions, the area of use of
+// the projection.
+func (db *GeoParquet) extent() [4]float64 {
+	db.extentOnce.Do(func() {
+		db.gridExtent, _ = proj.Extent(db.Config.Srid)
+	})
+	return db.gridExtent
+}
+
+// cell returns the grid cell for the center of the bbox.
+func (db *GeoParquet) cell(minx, miny, maxx, maxy float64) cell {
+	if db.Grid <= 1 || math.IsInf(minx, 0) {
+		return cell{}
+	}
+	ext := db.extent()
+	clamp := func(v int) int {
+		if v < 0 {
+			return 0
+		}
+		if v >= db.Grid {
+			return db.Grid - 1
+		}
+		return v
+	}
+	return cell{
+		x: clamp(int(((minx+maxx)/2 - ext[0]) / (ext[2] - ext[0]) * float64(db.Grid))),
+		y: clamp(int(((miny+maxy)/2 - ext[1]) / (ext[3] - ext[1]) * float64(db.Grid))),
+	}
+}
+
+func (t *table) filename(c cell) string {
+	if t.db.Grid <= 1 || t.geomCol < 0 {
+		return filepath.Join(t.db.Dir, t.spec.Name+".parquet")
+	}
+	return filepath.Join(t.db.Dir, t.spec.Name, fmt.Sprintf("grid=%d_%d", c.x, c.y), "data.parquet")
+}
+
+// file returns the output file for the grid cell, creates a new file if
+// necessary.
+func (t *table) file(c cell) (*file, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if f, ok := t.files[c]; ok {
+		return f, nil
+	}
+	filename := t.filename(c)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, errors.Wrapf(err, "creating directory for %s", filename)
+	}
+	fp, err := os.Create(filename + ".tmp")
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating %s", filename)
+	}
+	f := &file{
+		filename: filename,
+		f:        fp,
+		bw:       bufio.NewWriterSize(fp, 1<<20),
+		bbox:     [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)},
+		geomType: make(map[string]struct{}),
+	}
+	f.pw, err = newParquetWriter(f.bw, t.columns, t.db.Codec, t.db.RowGroupSize)
+	if err != nil {
+		fp.Close()
+		return nil, errors.Wrapf(err, "writing %s", filename)
+	}
+	t.files[c] = f
+	return f, nil
+}
+
+func (t *table) insert(row []interface{}) error {
+	minx, miny, maxx, maxy := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	var geomType string
+	if t.geomCol >= 0 {
+		if wkb, ok := row[t.geomCol].(string); ok && wkb != "" {
+			g, err := ewkb.DecodeHex([]byte(wkb))
+			if err != nil {
+				return errors.Wrapf(err, "decoding geometry for %s", t.spec.Name)
+			}
+			minx, miny, maxx, maxy = g.Bounds()
+			geomType = g.Type.String()
+			if g.HasZ {
+				geomType += " Z"
+			}
+			row[t.geomCol] = g.WKB()
+		} else {
+			row[t.geomCol] = nil
+		}
+	}
+
+	f, err := t.file(t.db.cell(minx, miny, maxx, maxy))
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.pw == nil {
+		return errors.Errorf("%s already closed", f.filename)
+	}
+	if geomType != "" {
+		f.geomType[geomType] = struct{}{}
+		f.bbox[0] = math.Min(f.bbox[0], minx)
+		f.bbox[1] = math.Min(f.bbox[1], miny)
+		f.bbox[2] = math.Max(f.bbox[2], maxx)
+		f.bbox[3] = math.Max(f.bbox[3], maxy)
+	}
+	if err := f.pw.appendRow(row); err != nil {
+		return errors.Wrapf(err, "writing %s", f.filename)
+	}
+	return nil
+}
+
+type geoMetadata struct {
+	Version       string                       `json:"version"`
+	PrimaryColumn string                       `json:"primary_column"`
+	Columns       map[string]geoColumnMetadata `json:"columns"`
+}
+
+type geoColumnMetadata struct {
+	Encoding      string          `json:"encoding"`
+	GeometryTypes []string        `json:"geometry_types"`
+	CRS           json.RawMessage `json:"crs,omitempty"`
+	BBox          []float64       `json:"bbox,omitempty"`
+}
+
+func (t *table) geoMetadata(f *file) (string, error) {
+	col := geoColumnMetadata{
+		Encoding:      "WKB",
+		GeometryTypes: []string{},
+	}
+	for typ := range f.geomType {
+		col.GeometryTypes = append(col.GeometryTypes, typ)
+	}
+	sort.Strings(col.GeometryTypes)
+	if !math.IsInf(f.bbox[0], 0) {
+		col.BBox = f.bbox[:]
+	}
+	// CRS defaults to OGC:CRS84 (EPSG:4326 with lon/lat axis order)
+	if t.spec.Srid != 4326 {
+		crs, err := proj.ProjJSON(t.spec.Srid)
+		if err != nil {
+			return "", err
+		}
+		col.CRS = crs
+	}
+	name := t.spec.Columns[t.geomCol].Name
+	meta := geoMetadata{
+		Version:       "1.0.0",
+		PrimaryColumn: name,
+		Columns:       map[string]geoColumnMetadata{name: col},
+	}
+	b, err := json.Marshal(meta)
+	return string(b), err
+}
+
+func (f *file) close(kv []keyValue) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.pw.close(kv); err != nil {
+		return errors.Wrapf(err, "writing %s", f.filename)
+	}
+	f.pw = nil
+	if err := f.bw.Flush(); err != nil {
+		return errors.Wrapf(err, "writing %s", f.filename)
+	}
+	if err := f.f.Close(); err != nil {
+		return errors.Wrapf(err, "writing %s", f.filename)
+	}
+	return errors.Wrapf(os.Rename(f.filename+".tmp", f.filename), "renaming %s", f.filename)
+}
+
+// remove closes and removes the file if it is not finished yet.
+func (f *file) remove() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.pw == nil {
+		return
+	}
+	f.pw = nil
+	f.f.Close()
+	os.Remove(f.filename + ".tmp")
+}
+
+// finish closes all files of the table. Creates an empty file if the table
+// has no rows.
+func (t *table) finish() error {
+	if t.geomCol < 0 || t.db.Grid <= 1 {
+		if _, err := t.file(cell{}); err != nil {
+			return err
+		}
+	}
+	for _, f := range t.files {
+		var kv []keyValue
+		if t.geomCol >= 0 {
+			geo, err := t.geoMetadata(f)
+			if err != nil {
+				return err
+			}
+			kv = append(kv, keyValue{"geo", geo})
+		}
+		if err := f.close(kv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *GeoParquet) writeRow(table string, row []interface{}) error {
+	t, ok := db.tables[table]
+	if !ok {
+		return errors.Errorf("unknown table %s", table)
+	}
+	return t.insert(row)
+}
+
+func (db *GeoParquet) insert(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	for _, match := range matches {
+		if err := db.writeRow(match.Table.Name, match.Row(&elem, &geom)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *GeoParquet) InsertPoint(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return db.insert(elem, geom, matches)
+}
+
+func (db *GeoParquet) InsertLineString(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return db.insert(elem, geom, matches)
+}
+
+func (db *GeoParquet) InsertPolygon(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return db.insert(elem, geom, matches)
+}
+
+func (db *GeoParquet) InsertRelationMember(rel osm.Relation, m osm.Member, geom geom.Geometry, matches []mapping.Match) error {
+	for _, match := range matches {
+		if err := db.writeRow(match.Table.Name, match.MemberRow(&rel, &m, &geom)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Generalize does nothing, generalized tables are only supported by PostGIS.
+func (db *GeoParquet) Generalize() error {
+	if len(db.GeneralizedTables) > 0 {
+		log.Printf("[warn] generalized tables are not supported by GeoParquet output, skipping %d tables", len(db.GeneralizedTables))
+	}
+	return nil
+}
+
+func (db *GeoParquet) EnableGeneralizeUpdates() {}
+
+func (db *GeoParquet) GeneralizeUpdates() error { return nil }
+
+// Finish writes the remaining rows and the metadata of all files.
+func (db *GeoParquet) Finish() error {
+	defer log.Step("Writing GeoParquet files")()
+	for _, spec := range db.Tables {
+		if err := db.tables[spec.Name].finish(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Files returns the names of all written files for the table.
+func (db *GeoParquet) Files(table string) []string {
+	t, ok := db.tables[table]
+	if !ok {
+		return nil
+	}
+	var files []string
+	for _, f := range t.files {
+		files = append(files, f.filename)
+	}
+	sort.Strings(files)
+	return files
+}
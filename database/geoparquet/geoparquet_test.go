@@ -0,0 +1,270 @@
+package geoparquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/proj"
+)
+
+// thriftReader decodes compact protocol structs into maps of field ids to
+// values (int64, bool, []byte, []interface{} or map[int16]interface{}).
+type thriftReader struct {
+	t   *testing.T
+	buf []byte
+	pos int
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		r.t.Fatal("invalid varint")
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case tTrue:
+		return true
+	case tFalse:
+		return false
+	case tI32, tI64:
+		return r.zigzag()
+	case tBinary:
+		n := int(r.varint())
+		v := r.buf[r.pos : r.pos+n]
+		r.pos += n
+		return v
+	case tList:
+		h := r.buf[r.pos]
+		r.pos++
+		size := int(h >> 4)
+		if size == 15 {
+			size = int(r.varint())
+		}
+		elems := make([]interface{}, size)
+		for i := range elems {
+			elems[i] = r.value(h & 0x0f)
+		}
+		return elems
+	case tStruct:
+		return r.readStruct()
+	}
+	r.t.Fatalf("unsupported thrift type %d", typ)
+	return nil
+}
+
+func (r *thriftReader) readStruct() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var last int16
+	for {
+		h := r.buf[r.pos]
+		r.pos++
+		if h == 0 {
+			return fields
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		fields[id] = r.value(h & 0x0f)
+		last = id
+	}
+}
+
+func TestGeoParquet(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "imposm3_geoparquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	db := &GeoParquet{
+		Config:       database.Config{Srid: 4326},
+		Dir:          tmpdir,
+		Codec:        codecGzip,
+		RowGroupSize: 3,
+		Tables: []*database.TableSpec{{
+			Name: "pois",
+			Srid: 4326,
+			Columns: []database.ColumnSpec{
+				{Name: "osm_id", FieldType: mapping.ColumnType{GoType: "int64"}},
+				{Name: "geometry", FieldType: mapping.ColumnType{GoType: "geometry"}},
+				{Name: "name", FieldType: mapping.ColumnType{GoType: "string"}},
+				{Name: "wheelchair", FieldType: mapping.ColumnType{GoType: "bool"}},
+			},
+		}},
+	}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for i, wkb := range []string{
+		"0101000020E6100000000000000000F03F0000000000000040", // POINT(1 2)
+		"0101000020E610000000000000000008400000000000001040", // POINT(3 4)
+		"",
+		"0101000020E6100000000000000000F0BF0000000000000000", // POINT(-1 0)
+	} {
+		var name interface{}
+		if i != 1 {
+			name = "poi"
+		}
+		if err := db.writeRow("pois", []interface{}{int64(i + 100), wkb, name, i%2 == 0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := ioutil.ReadFile(filepath.Join(tmpdir, "pois.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:4], parquetMagic) || !bytes.Equal(buf[len(buf)-4:], parquetMagic) {
+		t.Fatal("missing magic bytes")
+	}
+	metaSize := int(binary.LittleEndian.Uint32(buf[len(buf)-8:]))
+	r := &thriftReader{t: t, buf: buf, pos: len(buf) - 8 - metaSize}
+	meta := r.readStruct()
+	if r.pos != len(buf)-8 {
+		t.Errorf("metadata not fully decoded")
+	}
+
+	if numRows := meta[3].(int64); numRows != 4 {
+		t.Errorf("unexpected num_rows %d", numRows)
+	}
+	var names []string
+	for _, s := range meta[2].([]interface{}) {
+		names = append(names, string(s.(map[int16]interface{})[4].([]byte)))
+	}
+	if len(names) != 5 || names[0] != "schema" || names[1] != "osm_id" || names[4] != "wheelchair" {
+		t.Errorf("unexpected schema %v", names)
+	}
+
+	rowGroups := meta[4].([]interface{})
+	if len(rowGroups) != 2 {
+		t.Fatalf("unexpected row groups %v", rowGroups)
+	}
+
+	kv := meta[5].([]interface{})[0].(map[int16]interface{})
+	if string(kv[1].([]byte)) != "geo" {
+		t.Fatalf("unexpected metadata key %s", kv[1])
+	}
+	var geo geoMetadata
+	if err := json.Unmarshal(kv[2].([]byte), &geo); err != nil {
+		t.Fatal(err)
+	}
+	geomMeta := geo.Columns["geometry"]
+	if geo.PrimaryColumn != "geometry" || geomMeta.Encoding != "WKB" ||
+		len(geomMeta.GeometryTypes) != 1 || geomMeta.GeometryTypes[0] != "Point" {
+		t.Errorf("unexpected geo metadata %s", kv[2])
+	}
+	if bbox := geomMeta.BBox; len(bbox) != 4 || bbox[0] != -1 || bbox[1] != 0 || bbox[2] != 3 || bbox[3] != 4 {
+		t.Errorf("unexpected bbox %v", bbox)
+	}
+
+	// check the name column of the first row group
+	chunk := rowGroups[0].(map[int16]interface{})[1].([]interface{})[2].(map[int16]interface{})[3].(map[int16]interface{})
+	if chunk[5].(int64) != 3 {
+		t.Errorf("unexpected num_values %v", chunk[5])
+	}
+	r = &thriftReader{t: t, buf: buf, pos: int(chunk[9].(int64))}
+	header := r.readStruct()
+	size := int(header[3].(int64))
+	zr, err := gzip.NewReader(bytes.NewReader(buf[r.pos : r.pos+size]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 4 byte length, bit-packed run header (1 group), def levels 1, 0, 1
+	expected := []byte{2, 0, 0, 0, 3, 5, 3, 0, 0, 0, 'p', 'o', 'i', 3, 0, 0, 0, 'p', 'o', 'i'}
+	if !bytes.Equal(page, expected) {
+		t.Errorf("unexpected page %v", page)
+	}
+}
+
+func TestCell(t *testing.T) {
+	db := &GeoParquet{Config: database.Config{Srid: 4326}, Grid: 4}
+	for _, tc := range []struct {
+		bbox     [4]float64
+		expected cell
+	}{
+		{[4]float64{-180, -90, -180, -90}, cell{0, 0}},
+		{[4]float64{10, 50, 11, 51}, cell{2, 3}},
+		{[4]float64{179, -89, 181, -89}, cell{3, 0}},
+		{[4]float64{-170, 80, 170, 80}, cell{2, 3}},
+	} {
+		if c := db.cell(tc.bbox[0], tc.bbox[1], tc.bbox[2], tc.bbox[3]); c != tc.expected {
+			t.Errorf("unexpected cell %v for %v", c, tc.bbox)
+		}
+	}
+}
+
+func TestGeoMetadataCRS(t *testing.T) {
+	for _, tc := range []struct {
+		srid int
+		crs  string
+	}{
+		{4326, ""},
+		{3857, `"code":3857}}`},
+		{25832, `"name":"ETRS89 / UTM zone 32N"`},
+	} {
+		tbl := &table{
+			spec: &database.TableSpec{Name: "pois", Srid: tc.srid, Columns: []database.ColumnSpec{
+				{Name: "geometry", FieldType: mapping.ColumnType{GoType: "geometry"}},
+			}},
+		}
+		meta, err := tbl.geoMetadata(&file{geomType: map[string]struct{}{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var geo geoMetadata
+		if err := json.Unmarshal([]byte(meta), &geo); err != nil {
+			t.Fatal(err)
+		}
+		crs := string(geo.Columns["geometry"].CRS)
+		if tc.crs == "" && crs != "" || !strings.Contains(crs, tc.crs) {
+			t.Errorf("%d: unexpected CRS %s", tc.srid, crs)
+		}
+	}
+}
+
+func TestCellUTM(t *testing.T) {
+	// UTM zone 32N covers 6°E to 12°E, the grid should span the zone
+	// and not the whole web mercator extent.
+	db := &GeoParquet{Config: database.Config{Srid: 32632}, Grid: 4}
+	p := proj.Lookup(32632)
+	for _, tc := range []struct {
+		lon, lat float64
+		want     cell
+	}{
+		{6.5, 1, cell{0, 0}},
+		{11.5, 1, cell{3, 0}},
+		{9, 30, cell{1, 1}},
+		{9.5, 80, cell{2, 3}},
+	} {
+		x, y := p.Forward(tc.lon, tc.lat)
+		if c := db.cell(x, y, x, y); c != tc.want {
+			t.Errorf("%v %v: got cell %v, want %v", tc.lon, tc.lat, c, tc.want)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package geoparquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/pkg/errors"
+)
+
+// This file contains a minimal Parquet writer. It supports flat schemas of
+// optional columns with PLAIN encoded values in V1 data pages.
+
+var parquetMagic = []byte("PAR1")
+
+type physicalType int32
+
+const (
+	typeBoolean   physicalType = 0
+	typeInt32     physicalType = 1
+	typeInt64     physicalType = 2
+	typeFloat     physicalType = 4
+	typeDouble    physicalType = 5
+	typeByteArray physicalType = 6
+)
+
+type compressionCodec int32
+
+const (
+	codecUncompressed compressionCodec = 0
+	codecGzip         compressionCodec = 2
+)
+
+const (
+	encodingPlain = 0
+	encodingRLE   = 3
+)
+
+const (
+	repetitionOptional = 1
+	convertedTypeUTF8  = 0
+	pageTypeData       = 0
+)
+
+type columnSchema struct {
+	name string
+	typ  physicalType
+	utf8 bool
+}
+
+// columnBuffer contains the values of a single column for the current row
+// group. defs is 1 for each non-null value and data contains the PLAIN
+// encoded non-null values (booleans as one byte each).
+type columnBuffer struct {
+	defs []byte
+	data []byte
+}
+
+type chunkMeta struct {
+	dataPageOffset   int64
+	numValues        int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+type rowGroupMeta struct {
+	columns  []chunkMeta
+	numRows  int64
+	byteSize int64
+}
+
+type keyValue struct {
+	key, value string
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type parquetWriter struct {
+	w            *countingWriter
+	columns      []columnSchema
+	codec        compressionCodec
+	rowGroupSize int
+	pageSize     int
+
+	buffers   []columnBuffer
+	numRows   int
+	rowGroups []rowGroupMeta
+}
+
+func newParquetWriter(w io.Writer, columns []columnSchema, codec compressionCodec, rowGroupSize int) (*parquetWriter, error) {
+	pw := &parquetWriter{
+		w:            &countingWriter{w: w},
+		columns:      columns,
+		codec:        codec,
+		rowGroupSize: rowGroupSize,
+		pageSize:     1 << 20,
+		buffers:      make([]columnBuffer, len(columns)),
+	}
+	if _, err := pw.w.Write(parquetMagic); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// appendRow adds a row. vals needs to contain a value for each column, nil
+// for nulls. Values that can not be converted to the column type are
+// stored as null.
+func (pw *parquetWriter) appendRow(vals []interface{}) error {
+	var tmp [8]byte
+	for i, col := range pw.columns {
+		buf := &pw.buffers[i]
+		var val []byte
+		if v := vals[i]; v != nil {
+			switch col.typ {
+			case typeBoolean:
+				if n, ok := database.AsInt64(v); ok {
+					if n != 0 {
+						val = []byte{1}
+					} else {
+						val = []byte{0}
+					}
+				}
+			case typeInt32:
+				if n, ok := database.AsInt64(v); ok {
+					binary.LittleEndian.PutUint32(tmp[:], uint32(int32(n)))
+					val = tmp[:4]
+				}
+			case typeInt64:
+				if n, ok := database.AsInt64(v); ok {
+					binary.LittleEndian.PutUint64(tmp[:], uint64(n))
+					val = tmp[:8]
+				}
+			case typeFloat:
+				if n, ok := database.AsFloat64(v); ok {
+					binary.LittleEndian.PutUint32(tmp[:], math.Float32bits(float32(n)))
+					val = tmp[:4]
+				}
+			case typeDouble:
+				if n, ok := database.AsFloat64(v); ok {
+					binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(n))
+					val = tmp[:8]
+				}
+			case typeByteArray:
+				var b []byte
+				switch v := v.(type) {
+				case []byte:
+					b = v
+				case string:
+					b = []byte(v)
+				}
+				if b != nil {
+					binary.LittleEndian.PutUint32(tmp[:], uint32(len(b)))
+					buf.data = append(buf.data, tmp[:4]...)
+					val = b
+				}
+			}
+		}
+		if val == nil {
+			buf.defs = append(buf.defs, 0)
+		} else {
+			buf.defs = append(buf.defs, 1)
+			buf.data = append(buf.data, val...)
+		}
+	}
+	pw.numRows++
+	if pw.numRows >= pw.rowGroupSize {
+		return pw.flushRowGroup()
+	}
+	return nil
+}
+
+func (pw *parquetWriter) valueSize(typ physicalType, data []byte) int {
+	switch typ {
+	case typeBoolean:
+		return 1
+	case typeInt32, typeFloat:
+		return 4
+	case typeInt64, typeDouble:
+		return 8
+	default:
+		return 4 + int(binary.LittleEndian.Uint32(data))
+	}
+}
+
+func (pw *parquetWriter) flushRowGroup() error {
+	if pw.numRows == 0 {
+		return nil
+	}
+	rg := rowGroupMeta{numRows: int64(pw.numRows)}
+	for i := range pw.columns {
+		chunk, err := pw.writeChunk(i)
+		if err != nil {
+			return err
+		}
+		rg.columns = append(rg.columns, chunk)
+		rg.byteSize += chunk.uncompressedSize
+		pw.buffers[i].defs = pw.buffers[i].defs[:0]
+		pw.buffers[i].data = pw.buffers[i].data[:0]
+	}
+	pw.rowGroups = append(pw.rowGroups, rg)
+	pw.numRows = 0
+	return nil
+}
+
+// writeChunk writes the buffered values of column i as one or more data
+// pages.
+func (pw *parquetWriter) writeChunk(i int) (chunkMeta, error) {
+	col := pw.columns[i]
+	buf := &pw.buffers[i]
+	meta := chunkMeta{
+		dataPageOffset: pw.w.n,
+		numValues:      int64(len(buf.defs)),
+	}
+
+	row, pos := 0, 0
+	for row < len(buf.defs) {
+		end, endPos := row, pos
+		for end < len(buf.defs) && (end == row || endPos-pos < pw.pageSize) {
+			if buf.defs[end] == 1 {
+				endPos += pw.valueSize(col.typ, buf.data[endPos:])
+			}
+			end++
+		}
+		header, body, uncompressedSize, err := pw.encodePage(col.typ, buf.defs[row:end], buf.data[pos:endPos])
+		if err != nil {
+			return meta, err
+		}
+		if _, err := pw.w.Write(header); err != nil {
+			return meta, err
+		}
+		if _, err := pw.w.Write(body); err != nil {
+			return meta, err
+		}
+		meta.uncompressedSize += int64(len(header) + uncompressedSize)
+		meta.compressedSize += int64(len(header) + len(body))
+		row, pos = end, endPos
+	}
+	return meta, nil
+}
+
+func (pw *parquetWriter) encodePage(typ physicalType, defs, data []byte) (header, body []byte, uncompressedSize int, err error) {
+	page := &bytes.Buffer{}
+
+	// definition levels with the RLE/bit-packing hybrid encoding, as a
+	// single bit-packed run with bit width 1
+	groups := (len(defs) + 7) / 8
+	levels := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+groups)
+	levels = levels[:binary.PutUvarint(levels, uint64(groups<<1|1))]
+	packed := make([]byte, groups)
+	for i, d := range defs {
+		packed[i/8] |= d << uint(i%8)
+	}
+	levels = append(levels, packed...)
+	binary.Write(page, binary.LittleEndian, uint32(len(levels)))
+	page.Write(levels)
+
+	if typ == typeBoolean {
+		bits := make([]byte, (len(data)+7)/8)
+		for i, b := range data {
+			bits[i/8] |= b << uint(i%8)
+		}
+		page.Write(bits)
+	} else {
+		page.Write(data)
+	}
+
+	uncompressedSize = page.Len()
+	body = page.Bytes()
+	if pw.codec == codecGzip {
+		compressed := &bytes.Buffer{}
+		zw := gzip.NewWriter(compressed)
+		if _, err := zw.Write(body); err != nil {
+			return nil, nil, 0, errors.Wrap(err, "compressing page")
+		}
+		if err := zw.Close(); err != nil {
+			return nil, nil, 0, errors.Wrap(err, "compressing page")
+		}
+		body = compressed.Bytes()
+	}
+
+	t := &thriftWriter{}
+	t.structBegin()
+	t.i32(1, pageTypeData)
+	t.i32(2, int32(uncompressedSize))
+	t.i32(3, int32(len(body)))
+	t.structField(5)
+	t.i32(1, int32(len(defs)))
+	t.i32(2, encodingPlain)
+	t.i32(3, encodingRLE)
+	t.i32(4, encodingRLE)
+	t.structEnd()
+	t.structEnd()
+	return t.buf, body, uncompressedSize, nil
+}
+
+// close writes the remaining rows and the file metadata.
+func (pw *parquetWriter) close(kv []keyValue) error {
+	if err := pw.flushRowGroup(); err != nil {
+		return err
+	}
+
+	var numRows int64
+	for _, rg := range pw.rowGroups {
+		numRows += rg.numRows
+	}
+
+	t := &thriftWriter{}
+	t.structBegin()
+	t.i32(1, 1) // version
+	t.structList(2, len(pw.columns)+1, func(i int) {
+		if i == 0 {
+			t.string(4, "schema")
+			t.i32(5, int32(len(pw.columns)))
+			return
+		}
+		col := pw.columns[i-1]
+		t.i32(1, int32(col.typ))
+		t.i32(3, repetitionOptional)
+		t.string(4, col.name)
+		if col.utf8 {
+			t.i32(6, convertedTypeUTF8)
+		}
+	})
+	t.i64(3, numRows)
+	t.structList(4, len(pw.rowGroups), func(i int) {
+		rg := pw.rowGroups[i]
+		t.structList(1, len(rg.columns), func(j int) {
+			chunk := rg.columns[j]
+			t.i64(2, chunk.dataPageOffset)
+			t.structField(3)
+			t.i32(1, int32(pw.columns[j].typ))
+			t.i32List(2, []int32{encodingPlain, encodingRLE})
+			t.stringList(3, []string{pw.columns[j].name})
+			t.i32(4, int32(pw.codec))
+			t.i64(5, chunk.numValues)
+			t.i64(6, chunk.uncompressedSize)
+			t.i64(7, chunk.compressedSize)
+			t.i64(9, chunk.dataPageOffset)
+			t.structEnd()
+		})
+		t.i64(2, rg.byteSize)
+		t.i64(3, rg.numRows)
+	})
+	if len(kv) > 0 {
+		t.structList(5, len(kv), func(i int) {
+			t.string(1, kv[i].key)
+			t.string(2, kv[i].value)
+		})
+	}
+	t.string(6, "imposm3")
+	t.structEnd()
+
+	if _, err := pw.w.Write(t.buf); err != nil {
+		return err
+	}
+	if err := binary.Write(pw.w, binary.LittleEndian, uint32(len(t.buf))); err != nil {
+		return err
+	}
+	_, err := pw.w.Write(parquetMagic)
+	return err
+}
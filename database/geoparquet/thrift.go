@@ -0,0 +1,124 @@
+package geoparquet
+
+import "encoding/binary"
+
+// Thrift compact protocol types.
+const (
+	tTrue   = 1
+	tFalse  = 2
+	tI32    = 5
+	tI64    = 6
+	tBinary = 8
+	tList   = 9
+	tStruct = 12
+)
+
+// thriftWriter encodes Thrift structs with the compact protocol, as used by
+// the Parquet file and page metadata.
+type thriftWriter struct {
+	buf        []byte
+	lastFields []int16
+	last       int16
+}
+
+func (w *thriftWriter) varint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	w.buf = append(w.buf, tmp[:n]...)
+}
+
+func (w *thriftWriter) zigzag(v int64) {
+	w.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (w *thriftWriter) fieldHeader(id int16, typ byte) {
+	if delta := id - w.last; delta > 0 && delta <= 15 {
+		w.buf = append(w.buf, byte(delta)<<4|typ)
+	} else {
+		w.buf = append(w.buf, typ)
+		w.zigzag(int64(id))
+	}
+	w.last = id
+}
+
+func (w *thriftWriter) structBegin() {
+	w.lastFields = append(w.lastFields, w.last)
+	w.last = 0
+}
+
+func (w *thriftWriter) structEnd() {
+	w.buf = append(w.buf, 0) // stop field
+	w.last = w.lastFields[len(w.lastFields)-1]
+	w.lastFields = w.lastFields[:len(w.lastFields)-1]
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.fieldHeader(id, tI32)
+	w.zigzag(int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.fieldHeader(id, tI64)
+	w.zigzag(v)
+}
+
+func (w *thriftWriter) bool(id int16, v bool) {
+	if v {
+		w.fieldHeader(id, tTrue)
+	} else {
+		w.fieldHeader(id, tFalse)
+	}
+}
+
+func (w *thriftWriter) binary(id int16, v []byte) {
+	w.fieldHeader(id, tBinary)
+	w.varint(uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+func (w *thriftWriter) string(id int16, v string) {
+	w.binary(id, []byte(v))
+}
+
+// structField starts a struct field, call structEnd after writing all
+// fields of the struct.
+func (w *thriftWriter) structField(id int16) {
+	w.fieldHeader(id, tStruct)
+	w.structBegin()
+}
+
+func (w *thriftWriter) listHeader(id int16, elemType byte, size int) {
+	w.fieldHeader(id, tList)
+	if size < 15 {
+		w.buf = append(w.buf, byte(size)<<4|elemType)
+	} else {
+		w.buf = append(w.buf, 0xf0|elemType)
+		w.varint(uint64(size))
+	}
+}
+
+func (w *thriftWriter) i32List(id int16, vals []int32) {
+	w.listHeader(id, tI32, len(vals))
+	for _, v := range vals {
+		w.zigzag(int64(v))
+	}
+}
+
+func (w *thriftWriter) stringList(id int16, vals []string) {
+	w.listHeader(id, tBinary, len(vals))
+	for _, v := range vals {
+		w.varint(uint64(len(v)))
+		w.buf = append(w.buf, v...)
+	}
+}
+
+// structList writes a list of n structs. elem is called for each struct
+// and needs to write all fields (without structBegin/End).
+func (w *thriftWriter) structList(id int16, n int, elem func(i int)) {
+	w.listHeader(id, tStruct, n)
+	for i := 0; i < n; i++ {
+		w.structBegin()
+		elem(i)
+		w.structEnd()
+	}
+}
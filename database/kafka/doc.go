@@ -0,0 +1,9 @@
+/*
+Package kafka implements the database interfaces for Apache Kafka.
+
+All inserts and deletes are published as JSON messages into Kafka topics,
+during imports and diff imports. The package contains a minimal producer for
+the Kafka protocol (Metadata v1 and Produce v3 requests with uncompressed
+record batches).
+*/
+package kafka
@@ -0,0 +1,333 @@
+package kafka
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/geom/ewkb"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/mapping/config"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	database.Register("kafka", New)
+}
+
+type table struct {
+	spec    *database.TableSpec
+	topic   string
+	geomCol int
+	idCol   int
+	names   [][]byte // JSON encoded column names
+	name    []byte   // JSON encoded table name
+}
+
+type Kafka struct {
+	Config            database.Config
+	Brokers           []string
+	Acks              int16
+	Tables            []*table
+	GeneralizedTables map[string]*config.GeneralizedTable
+	tables            map[string]*table
+
+	mu       sync.Mutex
+	producer *producer
+}
+
+// New returns a Kafka database that publishes all inserts and deletes as
+// JSON messages (kafka:broker1:9092,broker2:9092). Messages are published
+// into a topic for each table (prefixed with osm_ by default), or into a
+// single topic. The following options can be appended as query parameters:
+//
+//	topic=name: publish all messages into this topic
+//	prefix=osm_: prefix of the topic names (NONE for no prefix)
+//	acks=all|1|0: required acknowledgements (default all)
+func New(conf database.Config, m *config.Mapping) (database.DB, error) {
+	params := strings.TrimPrefix(strings.TrimPrefix(conf.ConnectionParams, "kafka:"), "//")
+	opts := url.Values{}
+	if idx := strings.IndexByte(params, '?'); idx >= 0 {
+		var err error
+		opts, err = url.ParseQuery(params[idx+1:])
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing kafka options")
+		}
+		params = params[:idx]
+	}
+	db := &Kafka{
+		Config:            conf,
+		Acks:              -1,
+		GeneralizedTables: m.GeneralizedTables,
+		tables:            make(map[string]*table),
+	}
+	for _, addr := range strings.Split(params, ",") {
+		if addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "9092")
+		}
+		db.Brokers = append(db.Brokers, addr)
+	}
+	if len(db.Brokers) == 0 {
+		return nil, errors.New("missing brokers in kafka connection")
+	}
+
+	prefix := "osm_"
+	for k, v := range opts {
+		switch k {
+		case "topic":
+		case "prefix":
+			prefix = v[0]
+			if prefix == "NONE" {
+				prefix = ""
+			}
+		case "acks":
+			switch v[0] {
+			case "all", "-1":
+				db.Acks = -1
+			case "1":
+				db.Acks = 1
+			case "0":
+				db.Acks = 0
+			default:
+				return nil, errors.Errorf("invalid acks option %q", v[0])
+			}
+		default:
+			return nil, errors.Errorf("unknown kafka option %q", k)
+		}
+	}
+
+	specs, err := database.NewTableSpecs(m, conf.Srid)
+	if err != nil {
+		return nil, err
+	}
+	for _, spec := range specs {
+		t := &table{
+			spec:    spec,
+			topic:   prefix + spec.Name,
+			geomCol: spec.GeometryColumn(),
+			idCol:   -1,
+		}
+		if topic := opts.Get("topic"); topic != "" {
+			t.topic = topic
+		}
+		t.name, _ = json.Marshal(spec.Name)
+		for i, col := range spec.Columns {
+			if col.FieldType.Name == "id" && t.idCol == -1 {
+				t.idCol = i
+			}
+			name, _ := json.Marshal(col.Name)
+			t.names = append(t.names, name)
+		}
+		db.Tables = append(db.Tables, t)
+		db.tables[spec.Name] = t
+	}
+	return db, nil
+}
+
+// connect loads the metadata of all topics, if not already loaded.
+func (db *Kafka) connect() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.producer != nil {
+		return nil
+	}
+	var topics []string
+	seen := make(map[string]bool)
+	for _, t := range db.Tables {
+		if !seen[t.topic] {
+			seen[t.topic] = true
+			topics = append(topics, t.topic)
+		}
+	}
+	p := newProducer(db.Brokers, db.Acks)
+	if err := p.init(topics); err != nil {
+		return err
+	}
+	db.producer = p
+	return nil
+}
+
+func (db *Kafka) Init() error      { return db.connect() }
+func (db *Kafka) Begin() error     { return db.connect() }
+func (db *Kafka) BeginBulk() error { return db.connect() }
+
+// End sends all remaining messages.
+func (db *Kafka) End() error {
+	return db.producer.flush()
+}
+
+// Abort discards all messages that are not sent yet.
+func (db *Kafka) Abort() error {
+	if db.producer == nil {
+		return nil
+	}
+	for _, parts := range db.producer.topics {
+		for _, part := range parts {
+			part.mu.Lock()
+			part.records = nil
+			part.size = 0
+			part.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+func (db *Kafka) Close() error {
+	if db.producer != nil {
+		db.producer.close()
+	}
+	return nil
+}
+
+// key returns the message key. All messages of the same OSM ID and table
+// have the same key and are published into the same partition.
+func (t *table) key(id int64, hasID bool) []byte {
+	if !hasID {
+		return []byte(t.spec.Name)
+	}
+	return []byte(t.spec.Name + ":" + strconv.FormatInt(id, 10))
+}
+
+// message returns the JSON message for the row. row is nil for deletes.
+func (t *table) message(op string, id int64, hasID bool, row []interface{}) ([]byte, error) {
+	buf := make([]byte, 0, 256)
+	buf = append(buf, `{"op":"`...)
+	buf = append(buf, op...)
+	buf = append(buf, `","table":`...)
+	buf = append(buf, t.name...)
+	if hasID {
+		buf = append(buf, `,"id":`...)
+		buf = strconv.AppendInt(buf, id, 10)
+	}
+	if row == nil {
+		return append(buf, '}'), nil
+	}
+
+	buf = append(buf, `,"columns":{`...)
+	first := true
+	for i, v := range row {
+		if i == t.geomCol || v == nil {
+			continue
+		}
+		val, err := json.Marshal(v)
+		if err != nil {
+			// e.g. NaN values
+			continue
+		}
+		if !first {
+			buf = append(buf, ',')
+		}
+		first = false
+		buf = append(buf, t.names[i]...)
+		buf = append(buf, ':')
+		buf = append(buf, val...)
+	}
+	buf = append(buf, '}')
+
+	if t.geomCol >= 0 {
+		buf = append(buf, `,"geometry":`...)
+		wkb, ok := row[t.geomCol].(string)
+		if !ok || wkb == "" {
+			buf = append(buf, "null"...)
+		} else {
+			g, err := ewkb.DecodeHex([]byte(wkb))
+			if err != nil {
+				return nil, errors.Wrapf(err, "decoding geometry for %s", t.spec.Name)
+			}
+			buf = append(buf, '"')
+			buf = append(buf, hex.EncodeToString(g.WKB())...)
+			buf = append(buf, '"')
+		}
+	}
+	return append(buf, '}'), nil
+}
+
+func (db *Kafka) writeRow(table string, row []interface{}) error {
+	t, ok := db.tables[table]
+	if !ok {
+		return errors.Errorf("unknown table %s", table)
+	}
+	var id int64
+	hasID := false
+	if t.idCol >= 0 {
+		id, hasID = database.AsInt64(row[t.idCol])
+	}
+	msg, err := t.message("insert", id, hasID, row)
+	if err != nil {
+		return err
+	}
+	return db.producer.send(t.topic, t.key(id, hasID), msg)
+}
+
+func (db *Kafka) insert(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	for _, match := range matches {
+		if err := db.writeRow(match.Table.Name, match.Row(&elem, &geom)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *Kafka) InsertPoint(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return db.insert(elem, geom, matches)
+}
+
+func (db *Kafka) InsertLineString(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return db.insert(elem, geom, matches)
+}
+
+func (db *Kafka) InsertPolygon(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return db.insert(elem, geom, matches)
+}
+
+func (db *Kafka) InsertRelationMember(rel osm.Relation, m osm.Member, geom geom.Geometry, matches []mapping.Match) error {
+	for _, match := range matches {
+		if err := db.writeRow(match.Table.Name, match.MemberRow(&rel, &m, &geom)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete publishes a delete message for each table.
+func (db *Kafka) Delete(id int64, matches []mapping.Match) error {
+	for _, match := range matches {
+		t, ok := db.tables[match.Table.Name]
+		if !ok {
+			return errors.Errorf("unknown table %s", match.Table.Name)
+		}
+		msg, _ := t.message("delete", id, true, nil)
+		if err := db.producer.send(t.topic, t.key(id, true), msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Generalize does nothing, generalized tables are only supported by PostGIS.
+func (db *Kafka) Generalize() error {
+	if len(db.GeneralizedTables) > 0 {
+		log.Printf("[warn] generalized tables are not supported by Kafka output, skipping %d tables", len(db.GeneralizedTables))
+	}
+	return nil
+}
+
+func (db *Kafka) EnableGeneralizeUpdates() {}
+
+func (db *Kafka) GeneralizeUpdates() error { return nil }
+
+// Finish sends all remaining messages.
+func (db *Kafka) Finish() error {
+	return db.producer.flush()
+}
@@ -0,0 +1,249 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/mapping"
+)
+
+func TestMurmur2(t *testing.T) {
+	// test cases from the Java client
+	for s, expected := range map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	} {
+		if h := murmur2([]byte(s)); h != expected {
+			t.Errorf("unexpected hash %d for %s, expected %d", h, s, expected)
+		}
+	}
+}
+
+type producedRecord struct {
+	topic     string
+	partition int32
+	key       string
+	value     string
+}
+
+// fakeBroker handles Metadata and Produce requests of a single broker with
+// two partitions for each topic.
+type fakeBroker struct {
+	t       *testing.T
+	l       net.Listener
+	mu      sync.Mutex
+	records []producedRecord
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{t: t, l: l}
+	go b.serve()
+	return b
+}
+
+func (b *fakeBroker) serve() {
+	for {
+		conn, err := b.l.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *fakeBroker) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		d := &decoder{buf: req}
+		apiKey, apiVersion, correlationID := d.int16(), d.int16(), d.int32()
+		d.string() // client id
+		resp := &encoder{buf: make([]byte, 4)}
+		resp.int32(correlationID)
+		switch {
+		case apiKey == apiMetadata && apiVersion == 1:
+			b.metadata(d, resp)
+		case apiKey == apiProduce && apiVersion == 3:
+			b.produce(d, resp)
+		default:
+			b.t.Errorf("unexpected request %d v%d", apiKey, apiVersion)
+			return
+		}
+		binary.BigEndian.PutUint32(resp.buf, uint32(len(resp.buf)-4))
+		conn.Write(resp.buf)
+	}
+}
+
+func (b *fakeBroker) metadata(d *decoder, resp *encoder) {
+	var topics []string
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		topics = append(topics, d.string())
+	}
+	host, port, _ := net.SplitHostPort(b.l.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	resp.int32(1)
+	resp.int32(7) // node id
+	resp.string(host)
+	resp.int32(int32(portNum))
+	resp.nullString()
+	resp.int32(7) // controller
+	resp.int32(int32(len(topics)))
+	for _, topic := range topics {
+		resp.int16(0)
+		resp.string(topic)
+		resp.int8(0)
+		resp.int32(2)
+		for p := int32(0); p < 2; p++ {
+			resp.int16(0)
+			resp.int32(p)
+			resp.int32(7) // leader
+			resp.int32(1)
+			resp.int32(7)
+			resp.int32(1)
+			resp.int32(7)
+		}
+	}
+}
+
+func (b *fakeBroker) produce(d *decoder, resp *encoder) {
+	d.string() // transactional id
+	if acks := d.int16(); acks != -1 {
+		b.t.Errorf("unexpected acks %d", acks)
+	}
+	d.int32() // timeout
+	resp.int32(1)
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		topic := d.string()
+		resp.string(topic)
+		np := d.arrayLen()
+		resp.int32(int32(np))
+		for j := 0; j < np; j++ {
+			partition := d.int32()
+			b.decodeBatch(topic, partition, d.bytes())
+			resp.int32(partition)
+			resp.int16(0)
+			resp.int64(0)
+			resp.int64(-1)
+		}
+	}
+	resp.int32(0) // throttle time
+}
+
+func (b *fakeBroker) decodeBatch(topic string, partition int32, batch []byte) {
+	d := &decoder{buf: batch}
+	d.int64()
+	if l := d.int32(); int(l) != len(batch)-12 {
+		b.t.Errorf("unexpected batch length %d", l)
+	}
+	d.int32()
+	if magic := d.int8(); magic != 2 {
+		b.t.Errorf("unexpected magic %d", magic)
+	}
+	crc := uint32(d.int32())
+	if c := crc32.Checksum(d.buf, castagnoli); c != crc {
+		b.t.Errorf("invalid crc %x != %x", c, crc)
+	}
+	d.int16()
+	d.int32()
+	d.int64()
+	d.int64()
+	d.int64()
+	d.int16()
+	d.int32()
+	n := int(d.int32())
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := 0; i < n; i++ {
+		d.varint() // length
+		d.int8()
+		d.varint()
+		d.varint()
+		key := d.next(int(d.varint()))
+		value := d.next(int(d.varint()))
+		d.varint()
+		b.records = append(b.records, producedRecord{topic, partition, string(key), string(value)})
+	}
+	if d.err != nil {
+		b.t.Error(d.err)
+	}
+}
+
+func TestProduce(t *testing.T) {
+	broker := newFakeBroker(t)
+	defer broker.l.Close()
+
+	spec := &database.TableSpec{
+		Name: "pois",
+		Columns: []database.ColumnSpec{
+			{Name: "osm_id", FieldType: mapping.ColumnType{Name: "id", GoType: "int64"}},
+			{Name: "geometry", FieldType: mapping.ColumnType{GoType: "geometry"}},
+			{Name: "name", FieldType: mapping.ColumnType{GoType: "string"}},
+		},
+	}
+	tbl := &table{spec: spec, topic: "osm_pois", geomCol: 1, idCol: 0, name: []byte(`"pois"`)}
+	tbl.names = [][]byte{[]byte(`"osm_id"`), []byte(`"geometry"`), []byte(`"name"`)}
+	db := &Kafka{
+		Brokers: []string{broker.l.Addr().String()},
+		Acks:    -1,
+		Tables:  []*table{tbl},
+		tables:  map[string]*table{"pois": tbl},
+	}
+	if err := db.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// POINT(1 2) with SRID 4326
+	wkb := "0101000020E6100000000000000000F03F0000000000000040"
+	if err := db.writeRow("pois", []interface{}{int64(42), wkb, "Cafe"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(43, []mapping.Match{{Table: mapping.DestTable{Name: "pois"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.End(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(broker.records) != 2 {
+		t.Fatalf("unexpected records %v", broker.records)
+	}
+	for _, expected := range []producedRecord{
+		{"osm_pois", int32(partitionFor([]byte("pois:42"), 2)), "pois:42",
+			`{"op":"insert","table":"pois","id":42,"columns":{"osm_id":42,"name":"Cafe"},` +
+				`"geometry":"0101000000000000000000f03f0000000000000040"}`},
+		{"osm_pois", int32(partitionFor([]byte("pois:43"), 2)), "pois:43",
+			`{"op":"delete","table":"pois","id":43}`},
+	} {
+		found := false
+		for _, r := range broker.records {
+			if r == expected {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("record %v not found in %v", expected, broker.records)
+		}
+	}
+}
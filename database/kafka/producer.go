@@ -0,0 +1,338 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	maxBatchBytes   = 1 << 20
+	maxBatchRecords = 10000
+	clientID        = "imposm3"
+)
+
+// broker is a connection to a single Kafka broker. Requests are sent
+// sequentially.
+type broker struct {
+	addr    string
+	timeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// roundTrip sends the request and returns the response body (without
+// the response header). Returns nil if noResponse is set.
+func (b *broker) roundTrip(req []byte, correlationID int32, noResponse bool) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	resp, err := b.roundTripLocked(req, correlationID, noResponse)
+	if err != nil && b.conn != nil {
+		// connection is in an unknown state
+		b.conn.Close()
+		b.conn = nil
+	}
+	return resp, err
+}
+
+func (b *broker) roundTripLocked(req []byte, correlationID int32, noResponse bool) ([]byte, error) {
+	if b.conn == nil {
+		conn, err := net.DialTimeout("tcp", b.addr, b.timeout)
+		if err != nil {
+			return nil, errors.Wrapf(err, "connecting to kafka broker %s", b.addr)
+		}
+		b.conn = conn
+	}
+	b.conn.SetDeadline(time.Now().Add(b.timeout))
+	if _, err := b.conn.Write(req); err != nil {
+		return nil, errors.Wrapf(err, "sending request to kafka broker %s", b.addr)
+	}
+	if noResponse {
+		return nil, nil
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(b.conn, size[:]); err != nil {
+		return nil, errors.Wrapf(err, "reading response from kafka broker %s", b.addr)
+	}
+	resp := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(b.conn, resp); err != nil {
+		return nil, errors.Wrapf(err, "reading response from kafka broker %s", b.addr)
+	}
+	if len(resp) < 4 || int32(binary.BigEndian.Uint32(resp)) != correlationID {
+		return nil, errors.Errorf("unexpected response from kafka broker %s", b.addr)
+	}
+	return resp[4:], nil
+}
+
+func (b *broker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn != nil {
+		b.conn.Close()
+		b.conn = nil
+	}
+}
+
+type partition struct {
+	producer *producer
+	topic    string
+	id       int32
+	leader   *broker
+
+	mu      sync.Mutex
+	records []record
+	size    int
+}
+
+// producer sends records to the leaders of the partitions. Records of each
+// partition are collected and sent in batches.
+type producer struct {
+	bootstrap     []string
+	acks          int16
+	timeout       time.Duration
+	correlationID int32
+
+	brokers map[int32]*broker
+	topics  map[string][]*partition
+
+	errMu sync.Mutex
+	err   error
+}
+
+func newProducer(bootstrap []string, acks int16) *producer {
+	return &producer{
+		bootstrap: bootstrap,
+		acks:      acks,
+		timeout:   30 * time.Second,
+		brokers:   make(map[int32]*broker),
+		topics:    make(map[string][]*partition),
+	}
+}
+
+func (p *producer) nextCorrelationID() int32 {
+	return atomic.AddInt32(&p.correlationID, 1)
+}
+
+type topicMetadata struct {
+	err        int16
+	name       string
+	partitions []partitionMetadata
+}
+
+type partitionMetadata struct {
+	err    int16
+	id     int32
+	leader int32
+}
+
+func decodeMetadata(resp []byte) (map[int32]string, []topicMetadata, error) {
+	d := &decoder{buf: resp}
+	brokers := make(map[int32]string)
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller id
+	var topics []topicMetadata
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		t := topicMetadata{err: d.int16(), name: d.string()}
+		d.int8() // is internal
+		for j, m := 0, d.arrayLen(); j < m; j++ {
+			pm := partitionMetadata{err: d.int16(), id: d.int32(), leader: d.int32()}
+			for k, r := 0, d.arrayLen(); k < r; k++ {
+				d.int32() // replicas
+			}
+			for k, r := 0, d.arrayLen(); k < r; k++ {
+				d.int32() // isr
+			}
+			t.partitions = append(t.partitions, pm)
+		}
+		topics = append(topics, t)
+	}
+	return brokers, topics, d.err
+}
+
+// metadata requests the metadata of the topics from the first reachable
+// bootstrap broker.
+func (p *producer) metadata(topics []string) (map[int32]string, []topicMetadata, error) {
+	e := &encoder{}
+	e.int32(int32(len(topics)))
+	for _, t := range topics {
+		e.string(t)
+	}
+	var lastErr error
+	for _, addr := range p.bootstrap {
+		b := &broker{addr: addr, timeout: p.timeout}
+		id := p.nextCorrelationID()
+		resp, err := b.roundTrip(request(apiMetadata, 1, id, clientID, e.buf), id, false)
+		b.close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return decodeMetadata(resp)
+	}
+	return nil, nil, lastErr
+}
+
+// init loads the partitions and leaders of all topics. Topics that are
+// automatically created by the broker can have no leader for a short time,
+// so the metadata is requested again in this case.
+func (p *producer) init(topics []string) error {
+	var brokers map[int32]string
+	var meta []topicMetadata
+	var err error
+	for retry := 0; ; retry++ {
+		brokers, meta, err = p.metadata(topics)
+		if err != nil {
+			return err
+		}
+		err = nil
+		for _, t := range meta {
+			if t.err == errNone && len(t.partitions) == 0 {
+				err = errors.Errorf("kafka topic %s has no partitions", t.name)
+			} else if t.err != errNone {
+				err = errors.Errorf("kafka topic %s not available (error %d)", t.name, t.err)
+			}
+			for _, pm := range t.partitions {
+				if _, ok := brokers[pm.leader]; pm.err != errNone || !ok {
+					err = errors.Errorf("kafka topic %s partition %d not available (error %d)", t.name, pm.id, pm.err)
+				}
+			}
+		}
+		if err == nil || retry >= 10 {
+			break
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	if err != nil {
+		return err
+	}
+
+	for id, addr := range brokers {
+		p.brokers[id] = &broker{addr: addr, timeout: p.timeout}
+	}
+	for _, t := range meta {
+		parts := make([]*partition, len(t.partitions))
+		for _, pm := range t.partitions {
+			if int(pm.id) >= len(parts) {
+				return errors.Errorf("unexpected partition %d of kafka topic %s", pm.id, t.name)
+			}
+			parts[pm.id] = &partition{producer: p, topic: t.name, id: pm.id, leader: p.brokers[pm.leader]}
+		}
+		p.topics[t.name] = parts
+	}
+	return nil
+}
+
+func (p *producer) setErr(err error) {
+	p.errMu.Lock()
+	if p.err == nil {
+		p.err = err
+	}
+	p.errMu.Unlock()
+}
+
+func (p *producer) lastErr() error {
+	p.errMu.Lock()
+	defer p.errMu.Unlock()
+	return p.err
+}
+
+// send adds the record to the partition of the key. Records are sent when
+// the batch of the partition is full, or with flush.
+func (p *producer) send(topic string, key, value []byte) error {
+	if err := p.lastErr(); err != nil {
+		return err
+	}
+	parts, ok := p.topics[topic]
+	if !ok {
+		return errors.Errorf("unknown kafka topic %s", topic)
+	}
+	part := parts[partitionFor(key, len(parts))]
+	part.mu.Lock()
+	defer part.mu.Unlock()
+	part.records = append(part.records, record{key: key, value: value})
+	part.size += len(key) + len(value)
+	if part.size >= maxBatchBytes || len(part.records) >= maxBatchRecords {
+		return part.flushLocked()
+	}
+	return nil
+}
+
+// flush sends the records of all partitions.
+func (p *producer) flush() error {
+	for _, parts := range p.topics {
+		for _, part := range parts {
+			part.mu.Lock()
+			err := part.flushLocked()
+			part.mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return p.lastErr()
+}
+
+func (part *partition) flushLocked() error {
+	if len(part.records) == 0 {
+		return nil
+	}
+	p := part.producer
+	e := &encoder{}
+	e.nullString() // transactional id
+	e.int16(p.acks)
+	e.int32(int32(p.timeout / time.Millisecond))
+	e.int32(1)
+	e.string(part.topic)
+	e.int32(1)
+	e.int32(part.id)
+	e.bytes(recordBatch(part.records, time.Now().UnixNano()/int64(time.Millisecond)))
+	part.records = part.records[:0]
+	part.size = 0
+
+	id := p.nextCorrelationID()
+	resp, err := part.leader.roundTrip(request(apiProduce, 3, id, clientID, e.buf), id, p.acks == 0)
+	if err == nil && resp != nil {
+		err = decodeProduceResponse(resp)
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "producing to kafka topic %s partition %d", part.topic, part.id)
+		p.setErr(err)
+	}
+	return err
+}
+
+func decodeProduceResponse(resp []byte) error {
+	d := &decoder{buf: resp}
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		d.string() // topic
+		for j, m := 0, d.arrayLen(); j < m; j++ {
+			d.int32() // partition
+			code := d.int16()
+			d.int64() // base offset
+			d.int64() // log append time
+			if code != errNone && d.err == nil {
+				return errors.Errorf("error code %d", code)
+			}
+		}
+	}
+	return d.err
+}
+
+func (p *producer) close() {
+	for _, b := range p.brokers {
+		b.close()
+	}
+}
@@ -0,0 +1,247 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	apiProduce  = 0
+	apiMetadata = 3
+)
+
+const errNone = 0
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// encoder writes the primitive types of the Kafka protocol.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) int8(v int8) { e.buf = append(e.buf, byte(v)) }
+
+func (e *encoder) int16(v int16) {
+	e.buf = append(e.buf, byte(v>>8), byte(v))
+}
+
+func (e *encoder) int32(v int32) {
+	e.buf = append(e.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (e *encoder) int64(v int64) {
+	e.int32(int32(v >> 32))
+	e.int32(int32(v))
+}
+
+func (e *encoder) varint(v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	e.buf = append(e.buf, tmp[:n]...)
+}
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) nullString() { e.int16(-1) }
+
+func (e *encoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// decoder reads the primitive types of the Kafka protocol. The first error
+// is stored in err and all following reads return zero values.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.buf) < n {
+		d.err = errors.New("short kafka response")
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *decoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errors.New("invalid varint in kafka response")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+// arrayLen returns the length of the following array (0 for null arrays).
+func (d *decoder) arrayLen() int {
+	n := int(d.int32())
+	if n < 0 {
+		return 0
+	}
+	if n > len(d.buf) {
+		d.err = errors.New("invalid array length in kafka response")
+		return 0
+	}
+	return n
+}
+
+// request returns the size prefixed request with a v1 request header.
+func request(apiKey, apiVersion int16, correlationID int32, clientID string, body []byte) []byte {
+	e := &encoder{buf: make([]byte, 4, 4+14+len(clientID)+len(body))}
+	e.int16(apiKey)
+	e.int16(apiVersion)
+	e.int32(correlationID)
+	e.string(clientID)
+	e.buf = append(e.buf, body...)
+	binary.BigEndian.PutUint32(e.buf, uint32(len(e.buf)-4))
+	return e.buf
+}
+
+type record struct {
+	key, value []byte
+}
+
+// recordBatch returns an uncompressed record batch (magic v2) with all
+// records.
+func recordBatch(records []record, timestamp int64) []byte {
+	e := &encoder{}
+	e.int64(0)  // base offset
+	e.int32(0)  // batch length, set below
+	e.int32(-1) // partition leader epoch
+	e.int8(2)   // magic
+	e.int32(0)  // crc, set below
+	crcStart := len(e.buf)
+	e.int16(0) // attributes: no compression, create time
+	e.int32(int32(len(records) - 1))
+	e.int64(timestamp)
+	e.int64(timestamp)
+	e.int64(-1) // producer id
+	e.int16(-1) // producer epoch
+	e.int32(-1) // base sequence
+	e.int32(int32(len(records)))
+
+	rec := &encoder{}
+	for i, r := range records {
+		rec.buf = rec.buf[:0]
+		rec.int8(0)   // attributes
+		rec.varint(0) // timestamp delta
+		rec.varint(int64(i))
+		if r.key == nil {
+			rec.varint(-1)
+		} else {
+			rec.varint(int64(len(r.key)))
+			rec.buf = append(rec.buf, r.key...)
+		}
+		rec.varint(int64(len(r.value)))
+		rec.buf = append(rec.buf, r.value...)
+		rec.varint(0) // headers
+		e.varint(int64(len(rec.buf)))
+		e.buf = append(e.buf, rec.buf...)
+	}
+
+	binary.BigEndian.PutUint32(e.buf[8:], uint32(len(e.buf)-12))
+	binary.BigEndian.PutUint32(e.buf[crcStart-4:], crc32.Checksum(e.buf[crcStart:], castagnoli))
+	return e.buf
+}
+
+// murmur2 is the hash function of the default partitioner of the Java
+// client, so that messages with the same key are sent to the same
+// partition as with other producers.
+func murmur2(data []byte) int32 {
+	const (
+		seed = uint32(0x9747b28c)
+		m    = uint32(0x5bd1e995)
+		r    = 24
+	)
+	length := len(data)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+// partitionFor returns the partition for the key, like the default
+// partitioner of the Java client.
+func partitionFor(key []byte, numPartitions int) int {
+	return int(murmur2(key)&0x7fffffff) % numPartitions
+}
@@ -0,0 +1,147 @@
+package mbtiles
+
+import "github.com/omniscale/imposm3/geom/ewkb"
+
+type rect struct {
+	minx, miny, maxx, maxy float64
+}
+
+func (r rect) contains(c ewkb.Coord) bool {
+	return c.X >= r.minx && c.X <= r.maxx && c.Y >= r.miny && c.Y <= r.maxy
+}
+
+func (r rect) intersects(o rect) bool {
+	return r.minx <= o.maxx && r.maxx >= o.minx && r.miny <= o.maxy && r.maxy >= o.miny
+}
+
+// clipSegment clips the segment a-b to r (Liang-Barsky).
+func clipSegment(a, b ewkb.Coord, r rect) (ewkb.Coord, ewkb.Coord, bool) {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	t0, t1 := 0.0, 1.0
+	for _, e := range [4][2]float64{
+		{-dx, a.X - r.minx},
+		{dx, r.maxx - a.X},
+		{-dy, a.Y - r.miny},
+		{dy, r.maxy - a.Y},
+	} {
+		p, q := e[0], e[1]
+		if p == 0 {
+			if q < 0 {
+				return a, b, false
+			}
+			continue
+		}
+		t := q / p
+		if p < 0 {
+			if t > t1 {
+				return a, b, false
+			}
+			if t > t0 {
+				t0 = t
+			}
+		} else {
+			if t < t0 {
+				return a, b, false
+			}
+			if t < t1 {
+				t1 = t
+			}
+		}
+	}
+	ca, cb := a, b
+	if t0 > 0 {
+		ca = ewkb.Coord{X: a.X + t0*dx, Y: a.Y + t0*dy}
+	}
+	if t1 < 1 {
+		cb = ewkb.Coord{X: a.X + t1*dx, Y: a.Y + t1*dy}
+	}
+	return ca, cb, true
+}
+
+// clipLine clips a line string to r. Returns multiple parts if the line
+// leaves and re-enters r.
+func clipLine(coords []ewkb.Coord, r rect) [][]ewkb.Coord {
+	var parts [][]ewkb.Coord
+	var cur []ewkb.Coord
+	for i := 0; i+1 < len(coords); i++ {
+		a, b := coords[i], coords[i+1]
+		ca, cb, ok := clipSegment(a, b, r)
+		if !ok {
+			if cur != nil {
+				parts = append(parts, cur)
+				cur = nil
+			}
+			continue
+		}
+		if cur == nil {
+			cur = []ewkb.Coord{ca, cb}
+		} else {
+			cur = append(cur, cb)
+		}
+		if cb != b {
+			// segment leaves r
+			parts = append(parts, cur)
+			cur = nil
+		}
+	}
+	if cur != nil {
+		parts = append(parts, cur)
+	}
+	return parts
+}
+
+// clipRing clips a closed ring to r (Sutherland-Hodgman). The result can
+// contain degenerated edges along the border of r, which is fine for
+// rendering. Returns nil if the ring is outside of r.
+func clipRing(ring []ewkb.Coord, r rect) []ewkb.Coord {
+	out := ring
+	for edge := 0; edge < 4; edge++ {
+		in := out
+		if len(in) == 0 {
+			return nil
+		}
+		out = make([]ewkb.Coord, 0, len(in)+4)
+		inside := func(c ewkb.Coord) bool {
+			switch edge {
+			case 0:
+				return c.X >= r.minx
+			case 1:
+				return c.X <= r.maxx
+			case 2:
+				return c.Y >= r.miny
+			default:
+				return c.Y <= r.maxy
+			}
+		}
+		intersect := func(a, b ewkb.Coord) ewkb.Coord {
+			var t float64
+			switch edge {
+			case 0:
+				t = (r.minx - a.X) / (b.X - a.X)
+			case 1:
+				t = (r.maxx - a.X) / (b.X - a.X)
+			case 2:
+				t = (r.miny - a.Y) / (b.Y - a.Y)
+			default:
+				t = (r.maxy - a.Y) / (b.Y - a.Y)
+			}
+			return ewkb.Coord{X: a.X + t*(b.X-a.X), Y: a.Y + t*(b.Y-a.Y)}
+		}
+		prev := in[len(in)-1]
+		for _, c := range in {
+			if inside(c) {
+				if !inside(prev) {
+					out = append(out, intersect(prev, c))
+				}
+				out = append(out, c)
+			} else if inside(prev) {
+				out = append(out, intersect(prev, c))
+			}
+			prev = c
+		}
+	}
+	if len(out) < 3 {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,12 @@
+/*
+Package mbtiles implements the database interfaces for MBTiles files with
+Mapbox Vector Tiles.
+
+Each table is written as a separate layer. The zoom levels of each table can
+be configured with the tiles option in the mapping. All features are clipped
+and encoded into spool files during the import. The tiles are assembled and
+written into the MBTiles file (with the sqlite3 command) by Finish.
+
+This output is experimental. Diff imports are not supported.
+*/
+package mbtiles
@@ -0,0 +1,690 @@
+package mbtiles
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/geom/ewkb"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/mapping/config"
+	"github.com/omniscale/imposm3/proj"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	database.Register("mbtiles", New)
+}
+
+const pole = 6378137 * math.Pi
+
+type layer struct {
+	index            int
+	spec             *database.TableSpec
+	geomCol          int
+	idCol            int
+	minZoom, maxZoom int
+}
+
+// zoomSpool contains the encoded features of all tiles of a single zoom
+// level.
+type zoomSpool struct {
+	mu   sync.Mutex
+	f    *os.File
+	w    *bufio.Writer
+	size int64
+}
+
+type MBTiles struct {
+	Config            database.Config
+	Filename          string
+	MinZoom           int
+	MaxZoom           int
+	Buffer            int
+	Extent            int
+	Layers            []*layer
+	GeneralizedTables map[string]*config.GeneralizedTable
+	sqlite            string
+	tmpDir            string
+	spools            []*zoomSpool
+	layers            map[string]*layer
+
+	boundsMu sync.Mutex
+	bounds   [4]float64
+}
+
+// New returns an MBTiles database that renders all features into vector
+// tiles (mbtiles:/path/to/file.mbtiles). The following options can be
+// appended as query parameters: minzoom, maxzoom (0-14 by default), buffer
+// (64) and extent (4096).
+//
+// The MBTiles file is written with the sqlite3 command line tool.
+func New(conf database.Config, m *config.Mapping) (database.DB, error) {
+	params := strings.TrimPrefix(conf.ConnectionParams, "mbtiles:")
+	db := &MBTiles{
+		Config:            conf,
+		MaxZoom:           14,
+		Buffer:            64,
+		Extent:            4096,
+		GeneralizedTables: m.GeneralizedTables,
+		bounds:            [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)},
+	}
+	if idx := strings.IndexByte(params, '?'); idx >= 0 {
+		opts, err := url.ParseQuery(params[idx+1:])
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing mbtiles options")
+		}
+		params = params[:idx]
+		for k, v := range opts {
+			n, err := strconv.Atoi(v[0])
+			if err != nil || n < 0 {
+				return nil, errors.Errorf("invalid mbtiles option %s=%q", k, v[0])
+			}
+			switch k {
+			case "minzoom":
+				db.MinZoom = n
+			case "maxzoom":
+				db.MaxZoom = n
+			case "buffer":
+				db.Buffer = n
+			case "extent":
+				db.Extent = n
+			default:
+				return nil, errors.Errorf("unknown mbtiles option %q", k)
+			}
+		}
+	}
+	if params == "" {
+		return nil, errors.New("missing filename in mbtiles connection")
+	}
+	if db.MaxZoom > 24 || db.MinZoom > db.MaxZoom || db.Extent == 0 {
+		return nil, errors.Errorf("invalid zoom levels %d-%d or extent %d", db.MinZoom, db.MaxZoom, db.Extent)
+	}
+	db.Filename = params
+
+	var err error
+	if db.sqlite, err = exec.LookPath("sqlite3"); err != nil {
+		return nil, errors.Wrap(err, "mbtiles output requires the sqlite3 command")
+	}
+
+	specs, err := database.NewTableSpecs(m, conf.Srid)
+	if err != nil {
+		return nil, err
+	}
+	db.layers = make(map[string]*layer)
+	for i, spec := range specs {
+		l := &layer{
+			index:   i,
+			spec:    spec,
+			geomCol: spec.GeometryColumn(),
+			idCol:   -1,
+			minZoom: db.MinZoom,
+			maxZoom: db.MaxZoom,
+		}
+		for j, col := range spec.Columns {
+			if col.FieldType.Name == "id" {
+				l.idCol = j
+				break
+			}
+		}
+		if tiles := m.Tables[spec.Name].Tiles; tiles != nil {
+			if tiles.MinZoom != nil && *tiles.MinZoom > l.minZoom {
+				l.minZoom = *tiles.MinZoom
+			}
+			if tiles.MaxZoom != nil && *tiles.MaxZoom < l.maxZoom {
+				l.maxZoom = *tiles.MaxZoom
+			}
+		}
+		if l.geomCol < 0 {
+			log.Printf("[warn] table %s has no geometry column, skipping for mbtiles", spec.Name)
+			continue
+		}
+		db.Layers = append(db.Layers, l)
+		db.layers[spec.Name] = l
+	}
+	return db, nil
+}
+
+// Init creates the spool files for all zoom levels.
+func (db *MBTiles) Init() error {
+	if err := os.MkdirAll(filepath.Dir(db.Filename), 0755); err != nil {
+		return errors.Wrapf(err, "creating directory for %s", db.Filename)
+	}
+	var err error
+	db.tmpDir, err = ioutil.TempDir(filepath.Dir(db.Filename), "."+filepath.Base(db.Filename)+"-")
+	if err != nil {
+		return errors.Wrap(err, "creating spool directory")
+	}
+	db.spools = make([]*zoomSpool, db.MaxZoom+1)
+	for z := db.MinZoom; z <= db.MaxZoom; z++ {
+		f, err := os.Create(filepath.Join(db.tmpDir, fmt.Sprintf("%d.spool", z)))
+		if err != nil {
+			return errors.Wrap(err, "creating spool file")
+		}
+		db.spools[z] = &zoomSpool{f: f, w: bufio.NewWriterSize(f, 1<<20)}
+	}
+	return nil
+}
+
+func (db *MBTiles) Begin() error { return nil }
+func (db *MBTiles) End() error   { return nil }
+func (db *MBTiles) Abort() error { return db.Close() }
+
+// Close removes all spool files.
+func (db *MBTiles) Close() error {
+	for _, s := range db.spools {
+		if s != nil {
+			s.f.Close()
+		}
+	}
+	db.spools = nil
+	if db.tmpDir == "" {
+		return nil
+	}
+	err := os.RemoveAll(db.tmpDir)
+	db.tmpDir = ""
+	return err
+}
+
+// toMerc transforms the geometry from the projection p to EPSG:3857.
+func toMerc(g *ewkb.Geometry, p proj.Projection) {
+	for i, c := range g.Coords {
+		g.Coords[i].X, g.Coords[i].Y = proj.WgsToMerc(p.Inverse(c.X, c.Y))
+	}
+	for _, r := range g.Rings {
+		for i, c := range r {
+			r[i].X, r[i].Y = proj.WgsToMerc(p.Inverse(c.X, c.Y))
+		}
+	}
+	for i := range g.Geoms {
+		toMerc(&g.Geoms[i], p)
+	}
+}
+
+func (db *MBTiles) updateBounds(minx, miny, maxx, maxy float64) {
+	minx, miny = proj.MercToWgs(minx, miny)
+	maxx, maxy = proj.MercToWgs(maxx, maxy)
+	db.boundsMu.Lock()
+	db.bounds[0] = math.Min(db.bounds[0], minx)
+	db.bounds[1] = math.Min(db.bounds[1], miny)
+	db.bounds[2] = math.Max(db.bounds[2], maxx)
+	db.bounds[3] = math.Max(db.bounds[3], maxy)
+	db.boundsMu.Unlock()
+}
+
+// encodeProperties returns the column index and the encoded value of each
+// non-null column.
+func (l *layer) encodeProperties(row []interface{}) []byte {
+	w := pbWriter{}
+	for i, v := range row {
+		if i == l.geomCol || v == nil {
+			continue
+		}
+		val := encodeValue(v, l.spec.Columns[i].FieldType.GoType)
+		if val == nil {
+			continue
+		}
+		w.varint(uint64(i))
+		w.varint(uint64(len(val)))
+		w.buf = append(w.buf, val...)
+	}
+	return w.buf
+}
+
+func (db *MBTiles) writeRow(table string, row []interface{}) error {
+	l, ok := db.layers[table]
+	if !ok {
+		return nil
+	}
+	wkb, ok := row[l.geomCol].(string)
+	if !ok || wkb == "" {
+		return nil
+	}
+	g, err := ewkb.DecodeHex([]byte(wkb))
+	if err != nil {
+		return errors.Wrapf(err, "decoding geometry for %s", table)
+	}
+	if g.IsEmpty() {
+		return nil
+	}
+	if db.Config.Srid != 3857 {
+		toMerc(g, proj.Lookup(db.Config.Srid))
+	}
+	minx, miny, maxx, maxy := g.Bounds()
+	db.updateBounds(minx, miny, maxx, maxy)
+
+	var id uint64
+	if l.idCol >= 0 {
+		if n, ok := database.AsInt64(row[l.idCol]); ok && n >= 0 {
+			id = uint64(n) + 1
+		}
+	}
+	props := l.encodeProperties(row)
+
+	for z := l.minZoom; z <= l.maxZoom; z++ {
+		n := 1 << uint(z)
+		span := 2 * pole / float64(n)
+		buf := float64(db.Buffer) / float64(db.Extent) * span
+		clamp := func(v float64) int {
+			i := int(math.Floor(v))
+			if i < 0 {
+				return 0
+			}
+			if i >= n {
+				return n - 1
+			}
+			return i
+		}
+		tx0, tx1 := clamp((minx-buf+pole)/span), clamp((maxx+buf+pole)/span)
+		ty0, ty1 := clamp((pole-maxy-buf)/span), clamp((pole-miny+buf)/span)
+
+		for tx := tx0; tx <= tx1; tx++ {
+			for ty := ty0; ty <= ty1; ty++ {
+				tminx := -pole + float64(tx)*span
+				tmaxy := pole - float64(ty)*span
+				r := rect{tminx - buf, tmaxy - span - buf, tminx + span + buf, tmaxy + buf}
+				t := tileTransform{minx: tminx, maxy: tmaxy, scale: float64(db.Extent) / span}
+				geomType, cmds := encodeGeometry(g, r, t)
+				if len(cmds) == 0 {
+					continue
+				}
+				if err := db.spool(z, tx, ty, l.index, geomType, id, cmds, props); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// spool writes a single feature of a tile into the spool file of the zoom
+// level. Each record starts with the tile x/y and the length of the
+// record, followed by the layer index, geometry type, id+1 (0 for no ID),
+// the geometry commands and the encoded properties.
+func (db *MBTiles) spool(z, x, y, layer, geomType int, id uint64, cmds []uint32, props []byte) error {
+	w := pbWriter{buf: make([]byte, 12, 12+len(cmds)*2+len(props)+16)}
+	w.varint(uint64(layer))
+	w.varint(uint64(geomType))
+	w.varint(id)
+	w.varint(uint64(len(cmds)))
+	for _, c := range cmds {
+		w.varint(uint64(c))
+	}
+	w.buf = append(w.buf, props...)
+	binary.LittleEndian.PutUint32(w.buf[0:], uint32(x))
+	binary.LittleEndian.PutUint32(w.buf[4:], uint32(y))
+	binary.LittleEndian.PutUint32(w.buf[8:], uint32(len(w.buf)-12))
+
+	s := db.spools[z]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(w.buf); err != nil {
+		return errors.Wrap(err, "writing spool file")
+	}
+	s.size += int64(len(w.buf))
+	return nil
+}
+
+func (db *MBTiles) insert(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	for _, match := range matches {
+		if err := db.writeRow(match.Table.Name, match.Row(&elem, &geom)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *MBTiles) InsertPoint(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return db.insert(elem, geom, matches)
+}
+
+func (db *MBTiles) InsertLineString(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return db.insert(elem, geom, matches)
+}
+
+func (db *MBTiles) InsertPolygon(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return db.insert(elem, geom, matches)
+}
+
+func (db *MBTiles) InsertRelationMember(rel osm.Relation, m osm.Member, geom geom.Geometry, matches []mapping.Match) error {
+	for _, match := range matches {
+		if err := db.writeRow(match.Table.Name, match.MemberRow(&rel, &m, &geom)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Generalize does nothing, generalized tables are only supported by PostGIS.
+func (db *MBTiles) Generalize() error {
+	if len(db.GeneralizedTables) > 0 {
+		log.Printf("[warn] generalized tables are not supported by MBTiles output, skipping %d tables", len(db.GeneralizedTables))
+	}
+	return nil
+}
+
+func (db *MBTiles) EnableGeneralizeUpdates() {}
+
+func (db *MBTiles) GeneralizeUpdates() error { return nil }
+
+type record struct {
+	tile   uint64
+	offset int64
+	size   uint32
+}
+
+// records returns all records of the spool file, sorted by tile.
+func (s *zoomSpool) records() ([]record, error) {
+	if err := s.w.Flush(); err != nil {
+		return nil, err
+	}
+	var recs []record
+	r := bufio.NewReaderSize(io.NewSectionReader(s.f, 0, s.size), 1<<20)
+	var header [12]byte
+	var offset int64
+	for offset < s.size {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, err
+		}
+		size := binary.LittleEndian.Uint32(header[8:])
+		recs = append(recs, record{
+			tile:   uint64(binary.LittleEndian.Uint32(header[0:]))<<32 | uint64(binary.LittleEndian.Uint32(header[4:])),
+			offset: offset + 12,
+			size:   size,
+		})
+		if _, err := r.Discard(int(size)); err != nil {
+			return nil, err
+		}
+		offset += 12 + int64(size)
+	}
+	sort.SliceStable(recs, func(i, j int) bool { return recs[i].tile < recs[j].tile })
+	return recs, nil
+}
+
+type layerBuilder struct {
+	keys     map[string]uint32
+	keyList  []string
+	values   map[string]uint32
+	valList  []string
+	features [][]byte
+}
+
+func (lb *layerBuilder) key(k string) uint32 {
+	if idx, ok := lb.keys[k]; ok {
+		return idx
+	}
+	idx := uint32(len(lb.keyList))
+	lb.keys[k] = idx
+	lb.keyList = append(lb.keyList, k)
+	return idx
+}
+
+func (lb *layerBuilder) value(v string) uint32 {
+	if idx, ok := lb.values[v]; ok {
+		return idx
+	}
+	idx := uint32(len(lb.valList))
+	lb.values[v] = idx
+	lb.valList = append(lb.valList, v)
+	return idx
+}
+
+// addFeature decodes a spooled record and adds the feature.
+func (lb *layerBuilder) addFeature(l *layer, rec []byte) error {
+	d := pbReader{buf: rec}
+	d.varint() // layer
+	geomType := d.varint()
+	id := d.varint()
+	cmds := make([]uint32, d.varint())
+	for i := range cmds {
+		cmds[i] = uint32(d.varint())
+	}
+	var tags []uint32
+	for d.pos < len(d.buf) {
+		col := int(d.varint())
+		val := d.bytes()
+		if d.err != nil || col >= len(l.spec.Columns) {
+			break
+		}
+		tags = append(tags, lb.key(l.spec.Columns[col].Name), lb.value(string(val)))
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	f := pbWriter{}
+	if id > 0 {
+		f.uint(1, id-1)
+	}
+	f.packed(2, tags)
+	f.uint(3, geomType)
+	f.packed(4, cmds)
+	lb.features = append(lb.features, f.buf)
+	return nil
+}
+
+func (lb *layerBuilder) encode(name string, extent int) []byte {
+	w := pbWriter{}
+	w.uint(15, 2)
+	w.bytes(1, []byte(name))
+	for _, f := range lb.features {
+		w.bytes(2, f)
+	}
+	for _, k := range lb.keyList {
+		w.bytes(3, []byte(k))
+	}
+	for _, v := range lb.valList {
+		w.bytes(4, []byte(v))
+	}
+	w.uint(5, uint64(extent))
+	return w.buf
+}
+
+type pbReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *pbReader) varint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		r.err = errors.New("invalid varint in spool record")
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *pbReader) bytes() []byte {
+	n := int(r.varint())
+	if r.err != nil || r.pos+n > len(r.buf) {
+		r.err = errors.New("invalid spool record")
+		return nil
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+// buildTile creates the gzipped vector tile from the spooled records.
+func (db *MBTiles) buildTile(f io.ReaderAt, recs []record) ([]byte, error) {
+	builders := make(map[int]*layerBuilder)
+	for _, rec := range recs {
+		buf := make([]byte, rec.size)
+		if _, err := f.ReadAt(buf, rec.offset); err != nil {
+			return nil, errors.Wrap(err, "reading spool file")
+		}
+		idx, _ := binary.Uvarint(buf)
+		lb, ok := builders[int(idx)]
+		if !ok {
+			lb = &layerBuilder{keys: make(map[string]uint32), values: make(map[string]uint32)}
+			builders[int(idx)] = lb
+		}
+		if err := lb.addFeature(db.Layers[idx], buf); err != nil {
+			return nil, err
+		}
+	}
+
+	tile := pbWriter{}
+	for _, l := range db.Layers {
+		if lb, ok := builders[l.index]; ok {
+			tile.bytes(3, lb.encode(l.spec.Name, db.Extent))
+		}
+	}
+
+	gz := &bytes.Buffer{}
+	w := gzip.NewWriter(gz)
+	if _, err := w.Write(tile.buf); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return gz.Bytes(), nil
+}
+
+func sqlString(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+type vectorLayer struct {
+	ID      string            `json:"id"`
+	Fields  map[string]string `json:"fields"`
+	MinZoom int               `json:"minzoom"`
+	MaxZoom int               `json:"maxzoom"`
+}
+
+func (db *MBTiles) metadata() [][2]string {
+	var layers []vectorLayer
+	for _, l := range db.Layers {
+		vl := vectorLayer{ID: l.spec.Name, Fields: make(map[string]string), MinZoom: l.minZoom, MaxZoom: l.maxZoom}
+		for i, col := range l.spec.Columns {
+			if i == l.geomCol {
+				continue
+			}
+			switch col.FieldType.GoType {
+			case "bool":
+				vl.Fields[col.Name] = "Boolean"
+			case "int8", "int32", "int64", "float32":
+				vl.Fields[col.Name] = "Number"
+			default:
+				vl.Fields[col.Name] = "String"
+			}
+		}
+		layers = append(layers, vl)
+	}
+	vectorLayers, _ := json.Marshal(map[string]interface{}{"vector_layers": layers})
+
+	name := strings.TrimSuffix(filepath.Base(db.Filename), filepath.Ext(db.Filename))
+	meta := [][2]string{
+		{"name", name},
+		{"format", "pbf"},
+		{"type", "baselayer"},
+		{"minzoom", strconv.Itoa(db.MinZoom)},
+		{"maxzoom", strconv.Itoa(db.MaxZoom)},
+		{"json", string(vectorLayers)},
+		{"generator", "imposm3"},
+	}
+	if !math.IsInf(db.bounds[0], 0) {
+		meta = append(meta, [2]string{"bounds", fmt.Sprintf("%f,%f,%f,%f",
+			db.bounds[0], db.bounds[1], db.bounds[2], db.bounds[3])})
+	}
+	return meta
+}
+
+// Finish builds all tiles and writes the MBTiles file.
+func (db *MBTiles) Finish() error {
+	defer log.Step("Writing MBTiles " + db.Filename)()
+
+	tmpName := db.Filename + ".tmp"
+	os.Remove(tmpName)
+	cmd := exec.Command(db.sqlite, "-bail", tmpName)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return errors.Wrap(err, "starting sqlite3")
+	}
+	writeErr := db.writeSQL(stdin)
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		return errors.Errorf("writing %s with sqlite3: %s %s", tmpName, err, stderr.String())
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	if err := os.Rename(tmpName, db.Filename); err != nil {
+		return errors.Wrapf(err, "renaming %s", tmpName)
+	}
+	return db.Close()
+}
+
+func (db *MBTiles) writeSQL(out io.Writer) error {
+	w := bufio.NewWriterSize(out, 1<<20)
+	fmt.Fprint(w, `PRAGMA synchronous=OFF;
+PRAGMA journal_mode=OFF;
+CREATE TABLE metadata (name TEXT, value TEXT);
+CREATE TABLE tiles (zoom_level INTEGER, tile_column INTEGER, tile_row INTEGER, tile_data BLOB);
+BEGIN;
+`)
+	numTiles := 0
+	for z := db.MinZoom; z <= db.MaxZoom; z++ {
+		s := db.spools[z]
+		recs, err := s.records()
+		if err != nil {
+			return errors.Wrapf(err, "reading spool file for zoom level %d", z)
+		}
+		for i := 0; i < len(recs); {
+			j := i
+			for j < len(recs) && recs[j].tile == recs[i].tile {
+				j++
+			}
+			tile, err := db.buildTile(s.f, recs[i:j])
+			if err != nil {
+				return err
+			}
+			x, y := int(recs[i].tile>>32), int(recs[i].tile&0xffffffff)
+			// MBTiles uses TMS tile rows
+			row := (1 << uint(z)) - 1 - y
+			fmt.Fprintf(w, "INSERT INTO tiles VALUES (%d,%d,%d,X'%s');\n", z, x, row, hex.EncodeToString(tile))
+			numTiles++
+			i = j
+		}
+		log.Printf("[info] zoom level %d: %d features", z, len(recs))
+	}
+	for _, kv := range db.metadata() {
+		fmt.Fprintf(w, "INSERT INTO metadata VALUES (%s,%s);\n", sqlString(kv[0]), sqlString(kv[1]))
+	}
+	fmt.Fprint(w, `COMMIT;
+CREATE UNIQUE INDEX tile_index ON tiles (zoom_level, tile_column, tile_row);
+CREATE UNIQUE INDEX name ON metadata (name);
+`)
+	log.Printf("[info] wrote %d tiles", numTiles)
+	return w.Flush()
+}
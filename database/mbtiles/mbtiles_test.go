@@ -0,0 +1,91 @@
+package mbtiles
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/mapping"
+)
+
+func TestMBTiles(t *testing.T) {
+	sqlite, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not found")
+	}
+	tmpdir, err := ioutil.TempDir("", "imposm3_mbtiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	spec := &database.TableSpec{
+		Name: "pois",
+		Srid: 4326,
+		Columns: []database.ColumnSpec{
+			{Name: "osm_id", FieldType: mapping.ColumnType{Name: "id", GoType: "int64"}},
+			{Name: "geometry", FieldType: mapping.ColumnType{GoType: "geometry"}},
+			{Name: "name", FieldType: mapping.ColumnType{GoType: "string"}},
+		},
+	}
+	l := &layer{spec: spec, geomCol: 1, idCol: 0, minZoom: 0, maxZoom: 2}
+	db := &MBTiles{
+		Config:   database.Config{Srid: 4326},
+		Filename: filepath.Join(tmpdir, "out.mbtiles"),
+		MaxZoom:  2,
+		Buffer:   64,
+		Extent:   4096,
+		Layers:   []*layer{l},
+		layers:   map[string]*layer{"pois": l},
+		sqlite:   sqlite,
+		bounds:   [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)},
+	}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for i, wkb := range []string{
+		"0101000020E6100000000000000000F03F0000000000000040", // POINT(1 2)
+		"0101000020E6100000000000000000F0BF0000000000000000", // POINT(-1 0)
+		"",
+	} {
+		if err := db.writeRow("pois", []interface{}{int64(i), wkb, "poi"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(sql string) string {
+		out, err := exec.Command(sqlite, db.Filename, sql).CombinedOutput()
+		if err != nil {
+			t.Fatal(err, string(out))
+		}
+		return strings.TrimSpace(string(out))
+	}
+	// both points are near 0/0 and are also added to the neighbouring tiles
+	// because of the buffer
+	if n := query("SELECT count(*) FROM tiles WHERE zoom_level = 0"); n != "1" {
+		t.Errorf("unexpected number of z0 tiles %s", n)
+	}
+	if n := query("SELECT count(*) FROM tiles WHERE substr(hex(tile_data), 1, 4) != '1F8B'"); n != "0" {
+		t.Errorf("found %s tiles that are not gzipped", n)
+	}
+	if row := query("SELECT tile_column, tile_row FROM tiles WHERE zoom_level = 2 ORDER BY 1, 2"); row != "1|1\n1|2\n2|1\n2|2" {
+		t.Errorf("unexpected z2 tiles %q", row)
+	}
+	if v := query("SELECT value FROM metadata WHERE name = 'bounds'"); v != "-1.000000,0.000000,1.000000,2.000000" {
+		t.Errorf("unexpected bounds %q", v)
+	}
+	if v := query("SELECT value FROM metadata WHERE name = 'json'"); !strings.Contains(v, `"fields":{"name":"String","osm_id":"Number"}`) {
+		t.Errorf("unexpected json metadata %q", v)
+	}
+	if _, err := os.Stat(db.Filename + ".tmp"); !os.IsNotExist(err) {
+		t.Error("temporary file not removed")
+	}
+}
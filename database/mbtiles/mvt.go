@@ -0,0 +1,270 @@
+package mbtiles
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/geom/ewkb"
+)
+
+// This file contains a minimal encoder for Mapbox Vector Tiles (version 2).
+
+const (
+	geomTypePoint      = 1
+	geomTypeLineString = 2
+	geomTypePolygon    = 3
+)
+
+const (
+	cmdMoveTo    = 1
+	cmdLineTo    = 2
+	cmdClosePath = 7
+)
+
+type pbWriter struct {
+	buf []byte
+}
+
+func (w *pbWriter) varint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	w.buf = append(w.buf, tmp[:n]...)
+}
+
+func (w *pbWriter) key(field, wireType int) {
+	w.varint(uint64(field<<3 | wireType))
+}
+
+func (w *pbWriter) uint(field int, v uint64) {
+	w.key(field, 0)
+	w.varint(v)
+}
+
+func (w *pbWriter) bytes(field int, b []byte) {
+	w.key(field, 2)
+	w.varint(uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *pbWriter) packed(field int, vals []uint32) {
+	if len(vals) == 0 {
+		return
+	}
+	p := pbWriter{}
+	for _, v := range vals {
+		p.varint(uint64(v))
+	}
+	w.bytes(field, p.buf)
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+// encodeValue returns the encoded vector_tile.Tile.Value for v. Returns nil
+// for unsupported values.
+func encodeValue(v interface{}, goType string) []byte {
+	w := pbWriter{}
+	switch goType {
+	case "bool":
+		b, ok := v.(bool)
+		if !ok {
+			return nil
+		}
+		if b {
+			w.uint(7, 1)
+		} else {
+			w.uint(7, 0)
+		}
+	case "int8", "int32", "int64":
+		n, ok := database.AsInt64(v)
+		if !ok {
+			return nil
+		}
+		w.uint(6, zigzag(n))
+	case "float32":
+		f, ok := database.AsFloat64(v)
+		if !ok || math.IsNaN(f) {
+			return nil
+		}
+		w.key(2, 5)
+		var tmp [4]byte
+		binary.LittleEndian.PutUint32(tmp[:], math.Float32bits(float32(f)))
+		w.buf = append(w.buf, tmp[:]...)
+	default:
+		s, ok := v.(string)
+		if !ok {
+			return nil
+		}
+		w.bytes(1, []byte(s))
+	}
+	return w.buf
+}
+
+type point struct {
+	x, y int32
+}
+
+// tileTransform converts projected coordinates into tile coordinates.
+type tileTransform struct {
+	minx, maxy float64
+	scale      float64
+}
+
+func (t tileTransform) point(c ewkb.Coord) point {
+	return point{
+		x: int32(math.Round((c.X - t.minx) * t.scale)),
+		y: int32(math.Round((t.maxy - c.Y) * t.scale)),
+	}
+}
+
+// points converts the coordinates, removes consecutive duplicates.
+func (t tileTransform) points(coords []ewkb.Coord) []point {
+	pts := make([]point, 0, len(coords))
+	for _, c := range coords {
+		p := t.point(c)
+		if len(pts) > 0 && pts[len(pts)-1] == p {
+			continue
+		}
+		pts = append(pts, p)
+	}
+	return pts
+}
+
+// geomEncoder creates the command sequence of a feature geometry.
+type geomEncoder struct {
+	cmds []uint32
+	cur  point
+}
+
+func (e *geomEncoder) command(id, count int) {
+	e.cmds = append(e.cmds, uint32(id&0x7|count<<3))
+}
+
+func (e *geomEncoder) params(pts []point) {
+	for _, p := range pts {
+		e.cmds = append(e.cmds,
+			uint32(zigzag(int64(p.x-e.cur.x))),
+			uint32(zigzag(int64(p.y-e.cur.y))),
+		)
+		e.cur = p
+	}
+}
+
+func (e *geomEncoder) points(pts []point) {
+	if len(pts) == 0 {
+		return
+	}
+	e.command(cmdMoveTo, len(pts))
+	e.params(pts)
+}
+
+func (e *geomEncoder) line(pts []point) {
+	if len(pts) < 2 {
+		return
+	}
+	e.command(cmdMoveTo, 1)
+	e.params(pts[:1])
+	e.command(cmdLineTo, len(pts)-1)
+	e.params(pts[1:])
+}
+
+// ring adds a polygon ring. The ring needs to be closed and is reversed if
+// the winding order does not match exterior. Returns false for rings
+// without area.
+func (e *geomEncoder) ring(pts []point, exterior bool) bool {
+	if len(pts) > 1 && pts[0] == pts[len(pts)-1] {
+		pts = pts[:len(pts)-1]
+	}
+	if len(pts) < 3 {
+		return false
+	}
+	area := ringArea(pts)
+	if area == 0 {
+		return false
+	}
+	// exterior rings have a positive area in tile coordinates (y down)
+	if (area > 0) != exterior {
+		rev := make([]point, len(pts))
+		for i, p := range pts {
+			rev[len(pts)-1-i] = p
+		}
+		pts = rev
+	}
+	e.command(cmdMoveTo, 1)
+	e.params(pts[:1])
+	e.command(cmdLineTo, len(pts)-1)
+	e.params(pts[1:])
+	e.command(cmdClosePath, 1)
+	return true
+}
+
+// ringArea returns twice the signed area of the (unclosed) ring.
+func ringArea(pts []point) int64 {
+	var area int64
+	for i := range pts {
+		j := (i + 1) % len(pts)
+		area += int64(pts[i].x)*int64(pts[j].y) - int64(pts[j].x)*int64(pts[i].y)
+	}
+	return area
+}
+
+// encodeGeometry clips the geometry to r and returns the MVT geometry type
+// and commands. Returns no commands if nothing remains of the geometry.
+// GeometryCollections are not supported.
+func encodeGeometry(g *ewkb.Geometry, r rect, t tileTransform) (int, []uint32) {
+	e := &geomEncoder{}
+	switch g.Type {
+	case ewkb.Point, ewkb.MultiPoint:
+		var pts []point
+		addPoints := func(g *ewkb.Geometry) {
+			for _, c := range g.Coords {
+				if r.contains(c) {
+					pts = append(pts, t.point(c))
+				}
+			}
+		}
+		addPoints(g)
+		for i := range g.Geoms {
+			addPoints(&g.Geoms[i])
+		}
+		e.points(pts)
+		return geomTypePoint, e.cmds
+	case ewkb.LineString, ewkb.MultiLineString:
+		lines := [][]ewkb.Coord{g.Coords}
+		if g.Type == ewkb.MultiLineString {
+			lines = lines[:0]
+			for i := range g.Geoms {
+				lines = append(lines, g.Geoms[i].Coords)
+			}
+		}
+		for _, l := range lines {
+			for _, part := range clipLine(l, r) {
+				e.line(t.points(part))
+			}
+		}
+		return geomTypeLineString, e.cmds
+	case ewkb.Polygon, ewkb.MultiPolygon:
+		polygons := []ewkb.Geometry{*g}
+		if g.Type == ewkb.MultiPolygon {
+			polygons = g.Geoms
+		}
+		for _, p := range polygons {
+			for i, ring := range p.Rings {
+				clipped := clipRing(ring, r)
+				if clipped == nil {
+					if i == 0 {
+						break
+					}
+					continue
+				}
+				if !e.ring(t.points(clipped), i == 0) && i == 0 {
+					break
+				}
+			}
+		}
+		return geomTypePolygon, e.cmds
+	}
+	return 0, nil
+}
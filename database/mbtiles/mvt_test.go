@@ -0,0 +1,78 @@
+package mbtiles
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/omniscale/imposm3/geom/ewkb"
+)
+
+func coords(xy ...float64) []ewkb.Coord {
+	var cs []ewkb.Coord
+	for i := 0; i+1 < len(xy); i += 2 {
+		cs = append(cs, ewkb.Coord{X: xy[i], Y: xy[i+1]})
+	}
+	return cs
+}
+
+func TestClipLine(t *testing.T) {
+	r := rect{0, 0, 10, 10}
+	parts := clipLine(coords(-5, 5, 5, 5, 5, 15, 8, 15, 8, 5, 15, 5), r)
+	expected := [][]ewkb.Coord{
+		coords(0, 5, 5, 5, 5, 10),
+		coords(8, 10, 8, 5, 10, 5),
+	}
+	if !reflect.DeepEqual(parts, expected) {
+		t.Errorf("unexpected parts %v", parts)
+	}
+
+	if parts := clipLine(coords(20, 20, 30, 30), r); len(parts) != 0 {
+		t.Errorf("unexpected parts %v", parts)
+	}
+}
+
+func TestClipRing(t *testing.T) {
+	r := rect{0, 0, 10, 10}
+	ring := clipRing(coords(-5, -5, 5, -5, 5, 5, -5, 5, -5, -5), r)
+	if len(ring) < 4 {
+		t.Fatalf("unexpected ring %v", ring)
+	}
+	for _, c := range ring {
+		if !r.contains(c) || c.X > 5 || c.Y > 5 {
+			t.Errorf("coord %v outside of clip rect", c)
+		}
+	}
+
+	if ring := clipRing(coords(20, 20, 30, 20, 30, 30, 20, 20), r); ring != nil {
+		t.Errorf("unexpected ring %v", ring)
+	}
+}
+
+func TestEncodeGeometry(t *testing.T) {
+	r := rect{0, 0, 4096, 4096}
+	tr := tileTransform{minx: 0, maxy: 4096, scale: 1}
+
+	typ, cmds := encodeGeometry(&ewkb.Geometry{Type: ewkb.Point, Coords: coords(10, 4086)}, r, tr)
+	if typ != geomTypePoint || !reflect.DeepEqual(cmds, []uint32{9, 20, 20}) {
+		t.Errorf("unexpected point %d %v", typ, cmds)
+	}
+
+	typ, cmds = encodeGeometry(&ewkb.Geometry{Type: ewkb.LineString, Coords: coords(0, 4096, 100, 3996)}, r, tr)
+	if typ != geomTypeLineString || !reflect.DeepEqual(cmds, []uint32{9, 0, 0, 10, 200, 200}) {
+		t.Errorf("unexpected linestring %d %v", typ, cmds)
+	}
+
+	typ, cmds = encodeGeometry(&ewkb.Geometry{Type: ewkb.Point, Coords: coords(-10, 10)}, r, tr)
+	if len(cmds) != 0 {
+		t.Errorf("unexpected point outside of tile %d %v", typ, cmds)
+	}
+
+	poly := &ewkb.Geometry{Type: ewkb.Polygon, Rings: [][]ewkb.Coord{coords(0, 0, 100, 0, 100, 100, 0, 100, 0, 0)}}
+	typ, cmds = encodeGeometry(poly, r, tr)
+	if typ != geomTypePolygon || len(cmds) != 11 {
+		t.Fatalf("unexpected polygon %d %v", typ, cmds)
+	}
+	if cmds[0] != 9 || cmds[3] != 2|3<<3 || cmds[10] != 15 {
+		t.Errorf("unexpected polygon commands %v", cmds)
+	}
+}
@@ -0,0 +1,397 @@
+package database
+
+import (
+	"strings"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/go-osm/state"
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/mapping/config"
+	"github.com/pkg/errors"
+)
+
+// OpenAll opens a database for each connection. Multiple databases are
+// combined into a single DB that passes all calls to each database, so
+// that a single import writes into all outputs.
+func OpenAll(conf Config, connections []string, m *config.Mapping) (DB, error) {
+	if len(connections) == 1 {
+		conf.ConnectionParams = connections[0]
+		return Open(conf, m)
+	}
+	if len(connections) == 0 {
+		return nil, errors.New("missing connection")
+	}
+
+	dbs := make([]DB, 0, len(connections))
+	for _, c := range connections {
+		conf.ConnectionParams = c
+		db, err := Open(conf, m)
+		if err != nil {
+			for _, db := range dbs {
+				db.Close()
+			}
+			return nil, errors.Wrapf(err, "opening %s", connectionType(c))
+		}
+		dbs = append(dbs, db)
+	}
+	return newMultiDB(dbs, connections), nil
+}
+
+func connectionType(c string) string {
+	return strings.SplitN(c, ":", 2)[0]
+}
+
+// newMultiDB returns a DB for all dbs. The returned DB only implements
+// Deleter if all dbs are deletable, as updates need to be applied to all
+// outputs. It always implements OSMInserter, Quarantiner and StateStore,
+// use StoresOSMElements, StoresInvalid and StateStoreOf to check whether
+// any db supports them.
+func newMultiDB(dbs []DB, connections []string) DB {
+	names := make([]string, len(connections))
+	for i, c := range connections {
+		names[i] = connectionType(c)
+	}
+	m := &multiDB{dbs: dbs, names: names}
+	for _, db := range dbs {
+		if _, ok := db.(Deleter); !ok {
+			return m
+		}
+	}
+	return &deletableMultiDB{m}
+}
+
+type multiDB struct {
+	dbs   []DB
+	names []string
+}
+
+func (m *multiDB) each(f func(DB) error) error {
+	for i, db := range m.dbs {
+		if err := f(db); err != nil {
+			return errors.Wrapf(err, "%s output", m.names[i])
+		}
+	}
+	return nil
+}
+
+func (m *multiDB) Init() error  { return m.each(DB.Init) }
+func (m *multiDB) Begin() error { return m.each(DB.Begin) }
+func (m *multiDB) End() error   { return m.each(DB.End) }
+
+func (m *multiDB) BeginBulk() error {
+	return m.each(func(db DB) error {
+		if db, ok := db.(BulkBeginner); ok {
+			return db.BeginBulk()
+		}
+		return db.Begin()
+	})
+}
+
+// Abort aborts all databases, even if one of them returns an error.
+func (m *multiDB) Abort() error {
+	var firstErr error
+	for i, db := range m.dbs {
+		if err := db.Abort(); err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "%s output", m.names[i])
+		}
+	}
+	return firstErr
+}
+
+// Close closes all databases, even if one of them returns an error.
+func (m *multiDB) Close() error {
+	var firstErr error
+	for i, db := range m.dbs {
+		if err := db.Close(); err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "%s output", m.names[i])
+		}
+	}
+	return firstErr
+}
+
+func (m *multiDB) InsertPoint(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return m.each(func(db DB) error { return db.InsertPoint(elem, geom, matches) })
+}
+
+func (m *multiDB) InsertLineString(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return m.each(func(db DB) error { return db.InsertLineString(elem, geom, matches) })
+}
+
+func (m *multiDB) InsertPolygon(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return m.each(func(db DB) error { return db.InsertPolygon(elem, geom, matches) })
+}
+
+func (m *multiDB) InsertRelationMember(rel osm.Relation, member osm.Member, geom geom.Geometry, matches []mapping.Match) error {
+	return m.each(func(db DB) error { return db.InsertRelationMember(rel, member, geom, matches) })
+}
+
+func (m *multiDB) Generalize() error {
+	return m.each(func(db DB) error {
+		if db, ok := db.(Generalizer); ok {
+			return db.Generalize()
+		}
+		return nil
+	})
+}
+
+func (m *multiDB) EnableGeneralizeUpdates() {
+	for _, db := range m.dbs {
+		if db, ok := db.(Generalizer); ok {
+			db.EnableGeneralizeUpdates()
+		}
+	}
+}
+
+func (m *multiDB) GeneralizeUpdates() error {
+	return m.each(func(db DB) error {
+		if db, ok := db.(Generalizer); ok {
+			return db.GeneralizeUpdates()
+		}
+		return nil
+	})
+}
+
+func (m *multiDB) Finish() error {
+	return m.each(func(db DB) error {
+		if db, ok := db.(Finisher); ok {
+			return db.Finish()
+		}
+		return nil
+	})
+}
+
+// Optimize optimizes all databases that support it and skips the others.
+func (m *multiDB) Optimize() error {
+	for i, db := range m.dbs {
+		if db, ok := db.(Optimizer); ok {
+			if err := db.Optimize(); err != nil {
+				return errors.Wrapf(err, "%s output", m.names[i])
+			}
+		} else {
+			log.Printf("[warn] %s output not optimizable, skipping", m.names[i])
+		}
+	}
+	return nil
+}
+
+// StoresOSMElements returns whether any database stores OSM elements.
+func (m *multiDB) StoresOSMElements() bool {
+	for _, db := range m.dbs {
+		if StoresOSMElements(db) {
+			return true
+		}
+	}
+	return false
+}
+
+// StoresInvalid returns whether any database stores invalid elements.
+func (m *multiDB) StoresInvalid() bool {
+	for _, db := range m.dbs {
+		if StoresInvalid(db) {
+			return true
+		}
+	}
+	return false
+}
+
+// StoresStates returns whether any database stores diff states.
+func (m *multiDB) StoresStates() bool {
+	for _, db := range m.dbs {
+		if _, ok := StateStoreOf(db); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// osmInsert calls f for all databases that store OSM elements.
+func (m *multiDB) osmInsert(f func(OSMInserter) error) error {
+	return m.each(func(db DB) error {
+		if db, ok := db.(OSMInserter); ok {
+			return f(db)
+		}
+		return nil
+	})
+}
+
+func (m *multiDB) InsertNode(nd osm.Node) error {
+	return m.osmInsert(func(db OSMInserter) error { return db.InsertNode(nd) })
+}
+
+func (m *multiDB) InsertWay(w osm.Way) error {
+	return m.osmInsert(func(db OSMInserter) error { return db.InsertWay(w) })
+}
+
+func (m *multiDB) InsertRelation(r osm.Relation) error {
+	return m.osmInsert(func(db OSMInserter) error { return db.InsertRelation(r) })
+}
+
+func (m *multiDB) InsertInvalid(elem osm.Element, reason error, wkb []byte, matches []mapping.Match) error {
+	return m.each(func(db DB) error {
+		if db, ok := db.(Quarantiner); ok {
+			return db.InsertInvalid(elem, reason, wkb, matches)
+		}
+		return nil
+	})
+}
+
+func (m *multiDB) InsertChangesets(changesets []osm.Changeset) error {
+	return m.each(func(db DB) error {
+		if db, ok := db.(ChangesetInserter); ok {
+			return db.InsertChangesets(changesets)
+		}
+		return nil
+	})
+}
+
+// RecordsChanges returns whether any database records changes.
+func (m *multiDB) RecordsChanges() bool {
+	for _, db := range m.dbs {
+		if db, ok := db.(ChangeRecorder); ok && db.RecordsChanges() {
+			return true
+		}
+	}
+	return false
+}
+
+// ResumableWrite returns whether all databases can be resumed after the
+// write phase.
+func (m *multiDB) ResumableWrite() bool {
+	for _, db := range m.dbs {
+		if db, ok := db.(Resumer); !ok || !db.ResumableWrite() {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *multiDB) RecordChange(change osm.Diff, old *osm.Element) error {
+	return m.each(func(db DB) error {
+		if db, ok := db.(ChangeRecorder); ok && db.RecordsChanges() {
+			return db.RecordChange(change, old)
+		}
+		return nil
+	})
+}
+
+// LastState returns the oldest state of all databases that store states,
+// so that no diff is missing in any database. Returns nil if any of these
+// databases has no state.
+func (m *multiDB) LastState() (*state.DiffState, error) {
+	var last *state.DiffState
+	found := false
+	for i, db := range m.dbs {
+		if db, ok := db.(StateStore); ok {
+			s, err := db.LastState()
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s output", m.names[i])
+			}
+			if s == nil {
+				return nil, nil
+			}
+			if !found || s.Sequence < last.Sequence {
+				last = s
+			}
+			found = true
+		}
+	}
+	if !found {
+		return nil, errors.New("no output stores diff states")
+	}
+	return last, nil
+}
+
+func (m *multiDB) WriteState(s *state.DiffState, stats DiffStats) error {
+	found := false
+	err := m.each(func(db DB) error {
+		if db, ok := db.(StateStore); ok {
+			found = true
+			return db.WriteState(s, stats)
+		}
+		return nil
+	})
+	if err == nil && !found {
+		return errors.New("no output stores diff states")
+	}
+	return err
+}
+
+func (m *multiDB) SetDiffSequence(seq int) {
+	for _, db := range m.dbs {
+		if db, ok := db.(DiffSequencer); ok {
+			db.SetDiffSequence(seq)
+		}
+	}
+}
+
+// WriteTileServerConfig writes the config of the first database that
+// supports it.
+func (m *multiDB) WriteTileServerConfig(dest string, production bool) error {
+	for i, db := range m.dbs {
+		if db, ok := db.(TileServerConfigWriter); ok {
+			return errors.Wrapf(db.WriteTileServerConfig(dest, production), "%s output", m.names[i])
+		}
+	}
+	return errors.New("no output supports tile server configs")
+}
+
+// CheckGeometries checks the geometries of the first database that
+// supports it.
+func (m *multiDB) CheckGeometries(production bool, limit int, minSize float64) ([]GeometryReport, error) {
+	for i, db := range m.dbs {
+		if db, ok := db.(GeometryChecker); ok {
+			reports, err := db.CheckGeometries(production, limit, minSize)
+			return reports, errors.Wrapf(err, "%s output", m.names[i])
+		}
+	}
+	return nil, errors.New("no output supports geometry checks")
+}
+
+// FindRows returns the rows of the first database that supports it.
+func (m *multiDB) FindRows(names []string, id int64, production bool) ([]TableRows, error) {
+	for i, db := range m.dbs {
+		if db, ok := db.(RowFinder); ok {
+			rows, err := db.FindRows(names, id, production)
+			return rows, errors.Wrapf(err, "%s output", m.names[i])
+		}
+	}
+	return nil, errors.New("no output supports row queries")
+}
+
+// Migrate migrates all databases that support it.
+func (m *multiDB) Migrate() error {
+	return m.each(func(db DB) error {
+		if db, ok := db.(Migrator); ok {
+			return db.Migrate()
+		}
+		return nil
+	})
+}
+
+// deploy calls f for all databases that are deployable and skips the others.
+func (m *multiDB) deploy(f func(Deployer) error) error {
+	for i, db := range m.dbs {
+		if db, ok := db.(Deployer); ok {
+			if err := f(db); err != nil {
+				return errors.Wrapf(err, "%s output", m.names[i])
+			}
+		} else {
+			log.Printf("[warn] %s output not deployable, skipping", m.names[i])
+		}
+	}
+	return nil
+}
+
+func (m *multiDB) Deploy() error       { return m.deploy(Deployer.Deploy) }
+func (m *multiDB) RevertDeploy() error { return m.deploy(Deployer.RevertDeploy) }
+func (m *multiDB) RemoveBackup() error { return m.deploy(Deployer.RemoveBackup) }
+
+type deletableMultiDB struct {
+	*multiDB
+}
+
+func (m *deletableMultiDB) Delete(id int64, matches []mapping.Match) error {
+	return m.each(func(db DB) error { return db.(Deleter).Delete(id, matches) })
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/go-osm/state"
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/mapping"
+)
+
+type recordingDb struct {
+	nullDb
+	calls []string
+	err   error
+}
+
+func (r *recordingDb) Begin() error {
+	r.calls = append(r.calls, "begin")
+	return r.err
+}
+
+func (r *recordingDb) InsertPoint(osm.Element, geom.Geometry, []mapping.Match) error {
+	r.calls = append(r.calls, "point")
+	return r.err
+}
+
+func (r *recordingDb) Close() error {
+	r.calls = append(r.calls, "close")
+	return r.err
+}
+
+type deletableDb struct {
+	recordingDb
+}
+
+func (d *deletableDb) BeginBulk() error {
+	d.calls = append(d.calls, "beginbulk")
+	return nil
+}
+
+func (d *deletableDb) Delete(int64, []mapping.Match) error {
+	d.calls = append(d.calls, "delete")
+	return nil
+}
+
+func TestMultiDB(t *testing.T) {
+	a := &recordingDb{}
+	b := &deletableDb{}
+	db := newMultiDB([]DB{a, b}, []string{"a:foo", "b:"})
+
+	if _, ok := db.(Deleter); ok {
+		t.Error("multi db with non-deletable db should not be deletable")
+	}
+	if err := db.(BulkBeginner).BeginBulk(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertPoint(osm.Element{}, geom.Geometry{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.calls) != 2 || a.calls[0] != "begin" || a.calls[1] != "point" {
+		t.Errorf("unexpected calls %v", a.calls)
+	}
+	if len(b.calls) != 2 || b.calls[0] != "beginbulk" || b.calls[1] != "point" {
+		t.Errorf("unexpected calls %v", b.calls)
+	}
+
+	a.err = errors.New("failed")
+	err := db.InsertPoint(osm.Element{}, geom.Geometry{}, nil)
+	if err == nil || err.Error() != "a output: failed" {
+		t.Errorf("unexpected error %v", err)
+	}
+	if len(b.calls) != 2 {
+		t.Errorf("insert not stopped after error %v", b.calls)
+	}
+
+	// all dbs are closed, even after an error
+	if err := db.Close(); err == nil {
+		t.Error("expected error")
+	}
+	if b.calls[len(b.calls)-1] != "close" {
+		t.Errorf("db not closed %v", b.calls)
+	}
+}
+
+func TestMultiDBDeletable(t *testing.T) {
+	a := &deletableDb{}
+	b := &deletableDb{}
+	db := newMultiDB([]DB{a, b}, []string{"a:", "b:"})
+
+	delDb, ok := db.(Deleter)
+	if !ok {
+		t.Fatal("multi db not deletable")
+	}
+	if err := delDb.Delete(1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.calls) != 1 || len(b.calls) != 1 {
+		t.Errorf("unexpected calls %v %v", a.calls, b.calls)
+	}
+}
+
+type stateDb struct {
+	recordingDb
+}
+
+func (s *stateDb) LastState() (*state.DiffState, error) { return nil, nil }
+
+func (s *stateDb) WriteState(*state.DiffState, DiffStats) error {
+	s.calls = append(s.calls, "state")
+	return nil
+}
+
+func (s *stateDb) InsertNode(osm.Node) error         { return nil }
+func (s *stateDb) InsertWay(osm.Way) error           { return nil }
+func (s *stateDb) InsertRelation(osm.Relation) error { return nil }
+
+func TestMultiDBCapabilities(t *testing.T) {
+	db := newMultiDB([]DB{&recordingDb{}, &deletableDb{}}, []string{"a:", "b:"})
+	if _, ok := StateStoreOf(db); ok {
+		t.Error("multi db without state store should not store states")
+	}
+	if StoresOSMElements(db) || StoresInvalid(db) {
+		t.Error("multi db without OSM or quarantine tables should not store elements")
+	}
+
+	s := &stateDb{}
+	db = newMultiDB([]DB{&recordingDb{}, s}, []string{"a:", "s:"})
+	store, ok := StateStoreOf(db)
+	if !ok {
+		t.Fatal("multi db with state store should store states")
+	}
+	if err := store.WriteState(nil, DiffStats{}); err != nil || len(s.calls) != 1 {
+		t.Errorf("unexpected state write %v %v", err, s.calls)
+	}
+	if !StoresOSMElements(db) || StoresInvalid(db) {
+		t.Error("unexpected capabilities of multi db")
+	}
+	// a single database is not wrapped
+	if _, ok := StateStoreOf(s); !ok || !StoresOSMElements(s) {
+		t.Error("unexpected capabilities of state db")
+	}
+}
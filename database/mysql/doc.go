@@ -0,0 +1,4 @@
+/*
+Package mysql implements the database interfaces for MySQL and MariaDB.
+*/
+package mysql
@@ -0,0 +1,170 @@
+package mysql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/mapping/config"
+	"github.com/pkg/errors"
+)
+
+var mysqlTypes = map[string]string{
+	"string":             "TEXT",
+	"bool":               "BOOL",
+	"int8":               "SMALLINT",
+	"int32":              "INT",
+	"int64":              "BIGINT",
+	"float32":            "FLOAT",
+	"hstore_string":      "TEXT",
+	"geometry":           "GEOMETRY",
+	"validated_geometry": "GEOMETRY",
+}
+
+type ColumnSpec struct {
+	Name      string
+	FieldType mapping.ColumnType
+	Type      string
+}
+
+func (col *ColumnSpec) IsGeometry() bool {
+	return col.Type == "GEOMETRY"
+}
+
+type TableSpec struct {
+	Name     string
+	FullName string
+	Columns  []ColumnSpec
+	Srid     int
+	// NotNullGeometry is set for all tables with a geometry for each row.
+	// Only these tables get a spatial index, as MySQL does not support
+	// spatial indices on nullable columns.
+	NotNullGeometry bool
+	// AxisOrder is set to add the axis-order option for geographic SRIDs
+	// in MySQL 8.
+	AxisOrder bool
+}
+
+func NewTableSpec(my *MySQL, t *config.Table) (*TableSpec, error) {
+	tableType := mapping.TableType(t.Type)
+	spec := TableSpec{
+		Name:            t.Name,
+		FullName:        my.Prefix + t.Name,
+		Srid:            my.Config.Srid,
+		NotNullGeometry: tableType != mapping.RelationTable && tableType != mapping.RelationMemberTable,
+	}
+	for _, column := range t.Columns {
+		columnType, err := mapping.MakeColumnType(column)
+		if err != nil {
+			return nil, err
+		}
+		typ, ok := mysqlTypes[columnType.GoType]
+		if !ok {
+			return nil, errors.Errorf("unhandled column type %v", columnType)
+		}
+		spec.Columns = append(spec.Columns, ColumnSpec{column.Name, *columnType, typ})
+	}
+	return &spec, nil
+}
+
+func quoteIdent(s string) string {
+	return "`" + strings.Replace(s, "`", "``", -1) + "`"
+}
+
+func (spec *TableSpec) idColumn() string {
+	for _, col := range spec.Columns {
+		if col.FieldType.Name == "id" {
+			return col.Name
+		}
+	}
+	return ""
+}
+
+func (spec *TableSpec) CreateTableSQL(mariaDB bool) string {
+	foundIDCol := false
+	for _, col := range spec.Columns {
+		if col.Name == "id" {
+			foundIDCol = true
+		}
+	}
+
+	cols := []string{}
+	if !foundIDCol {
+		// Create explicit id column only if there is no id configured.
+		cols = append(cols, "`id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY")
+	}
+	for _, col := range spec.Columns {
+		sql := quoteIdent(col.Name) + " " + col.Type
+		if col.IsGeometry() && spec.NotNullGeometry {
+			sql += " NOT NULL"
+			if !mariaDB {
+				// MySQL 8 only uses spatial indices for columns with SRID
+				sql += fmt.Sprintf(" SRID %d", spec.Srid)
+			}
+		}
+		cols = append(cols, sql)
+	}
+	return fmt.Sprintf("CREATE TABLE %s (\n    %s\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+		quoteIdent(spec.FullName), strings.Join(cols, ",\n    "))
+}
+
+func (spec *TableSpec) columnNames() string {
+	var cols []string
+	for _, col := range spec.Columns {
+		cols = append(cols, quoteIdent(col.Name))
+	}
+	return strings.Join(cols, ", ")
+}
+
+// valuesSQL returns the placeholders for a single row.
+func (spec *TableSpec) valuesSQL() string {
+	var vars []string
+	for _, col := range spec.Columns {
+		if col.IsGeometry() {
+			if spec.AxisOrder {
+				vars = append(vars, fmt.Sprintf("ST_GeomFromWKB(?, %d, 'axis-order=long-lat')", spec.Srid))
+			} else {
+				vars = append(vars, fmt.Sprintf("ST_GeomFromWKB(?, %d)", spec.Srid))
+			}
+		} else {
+			vars = append(vars, "?")
+		}
+	}
+	return "(" + strings.Join(vars, ", ") + ")"
+}
+
+// InsertSQL returns the INSERT statement for n rows.
+func (spec *TableSpec) InsertSQL(n int) string {
+	values := spec.valuesSQL()
+	rows := make([]string, n)
+	for i := range rows {
+		rows[i] = values
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES %s",
+		quoteIdent(spec.FullName), spec.columnNames(), strings.Join(rows, ", "))
+}
+
+func (spec *TableSpec) DeleteSQL() (string, error) {
+	idCol := spec.idColumn()
+	if idCol == "" {
+		return "", errors.Errorf("missing id column in %s", spec.Name)
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE %s = ?", quoteIdent(spec.FullName), quoteIdent(idCol)), nil
+}
+
+// IndexSQL returns the statements to create the spatial index and the
+// index for the OSM ID, required for diff updates.
+func (spec *TableSpec) IndexSQL() []string {
+	var stmts []string
+	for _, col := range spec.Columns {
+		if col.IsGeometry() && spec.NotNullGeometry {
+			stmts = append(stmts, fmt.Sprintf("CREATE SPATIAL INDEX %s ON %s (%s)",
+				quoteIdent(spec.FullName+"_geom"), quoteIdent(spec.FullName), quoteIdent(col.Name)))
+		}
+		if col.FieldType.Name == "id" {
+			stmts = append(stmts, fmt.Sprintf("CREATE INDEX %s ON %s (%s)",
+				quoteIdent(spec.FullName+"_"+col.Name+"_idx"), quoteIdent(spec.FullName), quoteIdent(col.Name)))
+		}
+	}
+	return stmts
+}
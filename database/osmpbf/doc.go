@@ -0,0 +1,9 @@
+/*
+Package osmpbf implements the database interfaces for filtered OSM PBF
+extracts.
+
+All nodes, ways and relations that are inserted into any table of the
+mapping are written back into a single .osm.pbf file, together with the
+nodes and ways they reference. Diff imports are not supported.
+*/
+package osmpbf
@@ -0,0 +1,274 @@
+package osmpbf
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/mapping/config"
+	"github.com/omniscale/imposm3/proj"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	database.Register("osmpbf", New)
+}
+
+// blockSize is the maximum number of elements in each PrimitiveBlock.
+const blockSize = 8000
+
+// node coordinates are stored in 100 nanodegrees (the default PBF
+// granularity)
+type node struct {
+	lon, lat int64
+	tags     osm.Tags
+}
+
+type way struct {
+	refs []int64
+	tags osm.Tags
+}
+
+type relation struct {
+	members []osm.Member
+	tags    osm.Tags
+}
+
+type OSMPBF struct {
+	Config   database.Config
+	Filename string
+
+	mu    sync.Mutex
+	nodes map[int64]*node
+	ways  map[int64]*way
+	rels  map[int64]*relation
+}
+
+// New returns an OSMPBF database that writes all inserted OSM elements into
+// the PBF file of the connection string (osmpbf:/path/to/extract.osm.pbf).
+func New(conf database.Config, m *config.Mapping) (database.DB, error) {
+	filename := strings.TrimPrefix(conf.ConnectionParams, "osmpbf:")
+	if filename == "" {
+		return nil, errors.New("missing output file in osmpbf connection")
+	}
+	return &OSMPBF{
+		Config:   conf,
+		Filename: filename,
+	}, nil
+}
+
+// Init resets all collected elements. The elements are kept in memory till
+// Finish.
+func (pbf *OSMPBF) Init() error {
+	pbf.nodes = make(map[int64]*node)
+	pbf.ways = make(map[int64]*way)
+	pbf.rels = make(map[int64]*relation)
+	return nil
+}
+
+func (pbf *OSMPBF) Begin() error { return nil }
+func (pbf *OSMPBF) End() error   { return nil }
+func (pbf *OSMPBF) Abort() error { return pbf.Close() }
+
+func (pbf *OSMPBF) Close() error {
+	pbf.mu.Lock()
+	defer pbf.mu.Unlock()
+	pbf.nodes, pbf.ways, pbf.rels = nil, nil, nil
+	return nil
+}
+
+// The geometries are not required, all elements are collected with
+// InsertNode, InsertWay and InsertRelation.
+func (pbf *OSMPBF) InsertPoint(osm.Element, geom.Geometry, []mapping.Match) error      { return nil }
+func (pbf *OSMPBF) InsertLineString(osm.Element, geom.Geometry, []mapping.Match) error { return nil }
+func (pbf *OSMPBF) InsertPolygon(osm.Element, geom.Geometry, []mapping.Match) error    { return nil }
+func (pbf *OSMPBF) InsertRelationMember(osm.Relation, osm.Member, geom.Geometry, []mapping.Match) error {
+	return nil
+}
+
+func (pbf *OSMPBF) InsertNode(nd osm.Node) error {
+	pbf.mu.Lock()
+	defer pbf.mu.Unlock()
+	pbf.addNode(nd)
+	return nil
+}
+
+func (pbf *OSMPBF) InsertWay(w osm.Way) error {
+	pbf.mu.Lock()
+	defer pbf.mu.Unlock()
+	pbf.addWay(w)
+	return nil
+}
+
+// InsertRelation adds the relation with all member ways and nodes. Member
+// relations are only referenced.
+func (pbf *OSMPBF) InsertRelation(r osm.Relation) error {
+	pbf.mu.Lock()
+	defer pbf.mu.Unlock()
+	members := make([]osm.Member, len(r.Members))
+	for i, m := range r.Members {
+		members[i] = osm.Member{ID: m.ID, Type: m.Type, Role: m.Role}
+		if m.Way != nil {
+			pbf.addWay(*m.Way)
+		} else if m.Node != nil {
+			pbf.addNode(*m.Node)
+		}
+	}
+	rel, ok := pbf.rels[r.ID]
+	if !ok {
+		rel = &relation{}
+		pbf.rels[r.ID] = rel
+	}
+	rel.members = members
+	if len(r.Tags) > 0 {
+		rel.tags = r.Tags
+	}
+	return nil
+}
+
+// addNode adds or updates a node. Nodes are also added as references of
+// ways, without their tags, so existing tags are kept.
+func (pbf *OSMPBF) addNode(nd osm.Node) {
+	n, ok := pbf.nodes[nd.ID]
+	if !ok {
+		n = &node{}
+		n.lon, n.lat = pbf.coord(nd)
+		pbf.nodes[nd.ID] = n
+	}
+	if len(nd.Tags) > 0 {
+		n.tags = nd.Tags
+	}
+}
+
+func (pbf *OSMPBF) addWay(w osm.Way) {
+	wy, ok := pbf.ways[w.ID]
+	if !ok {
+		wy = &way{refs: w.Refs}
+		pbf.ways[w.ID] = wy
+	}
+	if len(w.Tags) > 0 {
+		wy.tags = w.Tags
+	}
+	for _, nd := range w.Nodes {
+		pbf.addNode(nd)
+	}
+}
+
+// coord returns the EPSG:4326 coordinate of a (projected) node.
+func (pbf *OSMPBF) coord(nd osm.Node) (lon, lat int64) {
+	long, la := nd.Long, nd.Lat
+	if pbf.Config.Srid != 4326 {
+		long, la = proj.Lookup(pbf.Config.Srid).Inverse(long, la)
+	}
+	return int64(math.Round(long * 1e7)), int64(math.Round(la * 1e7))
+}
+
+func (pbf *OSMPBF) Generalize() error { return nil }
+
+func (pbf *OSMPBF) EnableGeneralizeUpdates() {}
+
+func (pbf *OSMPBF) GeneralizeUpdates() error { return nil }
+
+// Finish writes all collected elements, sorted by type and ID.
+func (pbf *OSMPBF) Finish() error {
+	defer log.Step("Writing " + pbf.Filename)()
+	pbf.mu.Lock()
+	defer pbf.mu.Unlock()
+
+	log.Printf("[info] Writing %d nodes, %d ways and %d relations",
+		len(pbf.nodes), len(pbf.ways), len(pbf.rels))
+
+	f, err := os.Create(pbf.Filename)
+	if err != nil {
+		return errors.Wrapf(err, "creating %s", pbf.Filename)
+	}
+	defer f.Close()
+	w := bufio.NewWriterSize(f, 1<<20)
+
+	nodeIDs := make([]int64, 0, len(pbf.nodes))
+	for id := range pbf.nodes {
+		nodeIDs = append(nodeIDs, id)
+	}
+	wayIDs := make([]int64, 0, len(pbf.ways))
+	for id := range pbf.ways {
+		wayIDs = append(wayIDs, id)
+	}
+	relIDs := make([]int64, 0, len(pbf.rels))
+	for id := range pbf.rels {
+		relIDs = append(relIDs, id)
+	}
+	sortIDs(nodeIDs)
+	sortIDs(wayIDs)
+	sortIDs(relIDs)
+
+	if err := writeBlob(w, "OSMHeader", headerBlock(pbf.bbox())); err != nil {
+		return errors.Wrapf(err, "writing %s", pbf.Filename)
+	}
+	for _, ids := range chunks(nodeIDs) {
+		if err := writeBlob(w, "OSMData", denseNodesBlock(ids, pbf.nodes)); err != nil {
+			return errors.Wrapf(err, "writing %s", pbf.Filename)
+		}
+	}
+	for _, ids := range chunks(wayIDs) {
+		if err := writeBlob(w, "OSMData", waysBlock(ids, pbf.ways)); err != nil {
+			return errors.Wrapf(err, "writing %s", pbf.Filename)
+		}
+	}
+	for _, ids := range chunks(relIDs) {
+		if err := writeBlob(w, "OSMData", relationsBlock(ids, pbf.rels)); err != nil {
+			return errors.Wrapf(err, "writing %s", pbf.Filename)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return errors.Wrapf(err, "writing %s", pbf.Filename)
+	}
+	return f.Close()
+}
+
+// bbox returns the extent of all nodes, or nil if there are no nodes.
+func (pbf *OSMPBF) bbox() *bbox {
+	if len(pbf.nodes) == 0 {
+		return nil
+	}
+	bb := bbox{math.MaxInt64, math.MaxInt64, math.MinInt64, math.MinInt64}
+	for _, nd := range pbf.nodes {
+		if nd.lon < bb.minLon {
+			bb.minLon = nd.lon
+		}
+		if nd.lat < bb.minLat {
+			bb.minLat = nd.lat
+		}
+		if nd.lon > bb.maxLon {
+			bb.maxLon = nd.lon
+		}
+		if nd.lat > bb.maxLat {
+			bb.maxLat = nd.lat
+		}
+	}
+	return &bb
+}
+
+func sortIDs(ids []int64) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+}
+
+// chunks splits ids into chunks of blockSize.
+func chunks(ids []int64) [][]int64 {
+	var result [][]int64
+	for len(ids) > blockSize {
+		result = append(result, ids[:blockSize])
+		ids = ids[blockSize:]
+	}
+	if len(ids) > 0 {
+		result = append(result, ids)
+	}
+	return result
+}
@@ -0,0 +1,146 @@
+package osmpbf
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/go-osm/parser/pbf"
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/mapping/config"
+	"github.com/omniscale/imposm3/proj"
+)
+
+func mercNode(id int64, long, lat float64, tags osm.Tags) osm.Node {
+	nd := osm.Node{Element: osm.Element{ID: id, Tags: tags}, Long: long, Lat: lat}
+	proj.NodeToMerc(&nd)
+	return nd
+}
+
+func TestWritePBF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imposm_osmpbf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "extract.osm.pbf")
+
+	db, err := New(database.Config{ConnectionParams: "osmpbf:" + filename, Srid: 3857}, &config.Mapping{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	pbfDB := db.(*OSMPBF)
+
+	n1 := mercNode(1, 10.0, 53.5, osm.Tags{"amenity": "cafe"})
+	n2 := mercNode(2, 10.1, 53.6, nil)
+	n3 := mercNode(3, 10.2, 53.4, nil)
+	if err := pbfDB.InsertNode(n1); err != nil {
+		t.Fatal(err)
+	}
+	// n1 is referenced without tags
+	way := osm.Way{
+		Element: osm.Element{ID: 10, Tags: osm.Tags{"highway": "primary"}},
+		Refs:    []int64{1, 2, 3},
+		Nodes:   []osm.Node{mercNode(1, 10.0, 53.5, nil), n2, n3},
+	}
+	if err := pbfDB.InsertWay(way); err != nil {
+		t.Fatal(err)
+	}
+	member := osm.Way{
+		Element: osm.Element{ID: 11},
+		Refs:    []int64{3, 2},
+		Nodes:   []osm.Node{n3, n2},
+	}
+	rel := osm.Relation{
+		Element: osm.Element{ID: 20, Tags: osm.Tags{"type": "route"}},
+		Members: []osm.Member{
+			{ID: 11, Type: osm.WayMember, Role: "forward", Way: &member},
+			{ID: 1, Type: osm.NodeMember, Role: "stop"},
+			{ID: 21, Type: osm.RelationMember},
+		},
+	}
+	if err := pbfDB.InsertRelation(rel); err != nil {
+		t.Fatal(err)
+	}
+	if err := pbfDB.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	nodes := make(chan []osm.Node, 16)
+	ways := make(chan []osm.Way, 16)
+	rels := make(chan []osm.Relation, 16)
+	p := pbf.New(f, pbf.Config{Nodes: nodes, Ways: ways, Relations: rels})
+	if err := p.Parse(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var gotNodes []osm.Node
+	for nds := range nodes {
+		gotNodes = append(gotNodes, nds...)
+	}
+	if len(gotNodes) != 3 {
+		t.Fatalf("unexpected nodes %v", gotNodes)
+	}
+	for i, exp := range []struct {
+		id        int64
+		long, lat float64
+		tags      int
+	}{{1, 10.0, 53.5, 1}, {2, 10.1, 53.6, 0}, {3, 10.2, 53.4, 0}} {
+		nd := gotNodes[i]
+		if nd.ID != exp.id || math.Abs(nd.Long-exp.long) > 1e-7 || math.Abs(nd.Lat-exp.lat) > 1e-7 || len(nd.Tags) != exp.tags {
+			t.Errorf("unexpected node %v", nd)
+		}
+	}
+	if gotNodes[0].Tags["amenity"] != "cafe" {
+		t.Errorf("unexpected tags of node %v", gotNodes[0])
+	}
+
+	var gotWays []osm.Way
+	for ws := range ways {
+		gotWays = append(gotWays, ws...)
+	}
+	if len(gotWays) != 2 {
+		t.Fatalf("unexpected ways %v", gotWays)
+	}
+	if w := gotWays[0]; w.ID != 10 || w.Tags["highway"] != "primary" || len(w.Refs) != 3 || w.Refs[2] != 3 {
+		t.Errorf("unexpected way %v", w)
+	}
+	if w := gotWays[1]; w.ID != 11 || len(w.Tags) != 0 || len(w.Refs) != 2 || w.Refs[0] != 3 || w.Refs[1] != 2 {
+		t.Errorf("unexpected way %v", w)
+	}
+
+	var gotRels []osm.Relation
+	for rs := range rels {
+		gotRels = append(gotRels, rs...)
+	}
+	if len(gotRels) != 1 {
+		t.Fatalf("unexpected relations %v", gotRels)
+	}
+	r := gotRels[0]
+	if r.ID != 20 || r.Tags["type"] != "route" || len(r.Members) != 3 {
+		t.Fatalf("unexpected relation %v", r)
+	}
+	for i, exp := range []osm.Member{
+		{ID: 11, Type: osm.WayMember, Role: "forward"},
+		{ID: 1, Type: osm.NodeMember, Role: "stop"},
+		{ID: 21, Type: osm.RelationMember},
+	} {
+		m := r.Members[i]
+		if m.ID != exp.ID || m.Type != exp.Type || m.Role != exp.Role {
+			t.Errorf("unexpected member %v", m)
+		}
+	}
+}
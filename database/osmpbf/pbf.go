@@ -0,0 +1,251 @@
+package osmpbf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"sort"
+
+	osm "github.com/omniscale/go-osm"
+)
+
+// Minimal protocol buffer encoding of the OSM PBF format.
+// See https://wiki.openstreetmap.org/wiki/PBF_Format
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func appendKey(b []byte, field int, wire int) []byte {
+	return appendVarint(b, uint64(field)<<3|uint64(wire))
+}
+
+func appendUintField(b []byte, field int, v uint64) []byte {
+	b = appendKey(b, field, wireVarint)
+	return appendVarint(b, v)
+}
+
+func appendSintField(b []byte, field int, v int64) []byte {
+	b = appendKey(b, field, wireVarint)
+	return appendVarint(b, zigzag(v))
+}
+
+func appendBytesField(b []byte, field int, data []byte) []byte {
+	b = appendKey(b, field, wireBytes)
+	b = appendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendStringField(b []byte, field int, s string) []byte {
+	return appendBytesField(b, field, []byte(s))
+}
+
+// appendPacked appends vs as packed repeated varint field. Signed values
+// need to be zigzag encoded by the caller.
+func appendPacked(b []byte, field int, vs []uint64) []byte {
+	if len(vs) == 0 {
+		return b
+	}
+	var body []byte
+	for _, v := range vs {
+		body = appendVarint(body, v)
+	}
+	return appendBytesField(b, field, body)
+}
+
+// deltas returns the zigzag encoded differences of vs.
+func deltas(vs []int64) []uint64 {
+	result := make([]uint64, len(vs))
+	var last int64
+	for i, v := range vs {
+		result[i] = zigzag(v - last)
+		last = v
+	}
+	return result
+}
+
+// stringTable collects all strings of a primitive block. Index 0 is
+// reserved as delimiter.
+type stringTable struct {
+	index   map[string]uint64
+	strings []string
+}
+
+func newStringTable() *stringTable {
+	return &stringTable{
+		index:   map[string]uint64{"": 0},
+		strings: []string{""},
+	}
+}
+
+func (st *stringTable) id(s string) uint64 {
+	if id, ok := st.index[s]; ok {
+		return id
+	}
+	id := uint64(len(st.strings))
+	st.index[s] = id
+	st.strings = append(st.strings, s)
+	return id
+}
+
+// tagIDs returns the string table IDs of all keys and values, sorted by
+// key.
+func (st *stringTable) tagIDs(tags osm.Tags) (keys, vals []uint64) {
+	names := make([]string, 0, len(tags))
+	for k := range tags {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		keys = append(keys, st.id(k))
+		vals = append(vals, st.id(tags[k]))
+	}
+	return keys, vals
+}
+
+// primitiveBlock returns an encoded PrimitiveBlock with a single
+// PrimitiveGroup. Coordinates use the default granularity of 100
+// nanodegrees.
+func primitiveBlock(st *stringTable, group []byte) []byte {
+	var table []byte
+	for _, s := range st.strings {
+		table = appendStringField(table, 1, s)
+	}
+	b := appendBytesField(nil, 1, table)
+	return appendBytesField(b, 2, group)
+}
+
+// denseNodesBlock encodes all nodes as DenseNodes.
+func denseNodesBlock(ids []int64, nodes map[int64]*node) []byte {
+	st := newStringTable()
+	lons := make([]int64, len(ids))
+	lats := make([]int64, len(ids))
+	var keysVals []uint64
+	for i, id := range ids {
+		nd := nodes[id]
+		lons[i], lats[i] = nd.lon, nd.lat
+		keys, vals := st.tagIDs(nd.tags)
+		for j := range keys {
+			keysVals = append(keysVals, keys[j], vals[j])
+		}
+		keysVals = append(keysVals, 0)
+	}
+
+	var dense []byte
+	dense = appendPacked(dense, 1, deltas(ids))
+	dense = appendPacked(dense, 8, deltas(lats))
+	dense = appendPacked(dense, 9, deltas(lons))
+	dense = appendPacked(dense, 10, keysVals)
+
+	group := appendBytesField(nil, 2, dense)
+	return primitiveBlock(st, group)
+}
+
+func waysBlock(ids []int64, ways map[int64]*way) []byte {
+	st := newStringTable()
+	var group []byte
+	for _, id := range ids {
+		w := ways[id]
+		keys, vals := st.tagIDs(w.tags)
+		var b []byte
+		b = appendUintField(b, 1, uint64(id))
+		b = appendPacked(b, 2, keys)
+		b = appendPacked(b, 3, vals)
+		b = appendPacked(b, 8, deltas(w.refs))
+		group = appendBytesField(group, 3, b)
+	}
+	return primitiveBlock(st, group)
+}
+
+func relationsBlock(ids []int64, rels map[int64]*relation) []byte {
+	st := newStringTable()
+	var group []byte
+	for _, id := range ids {
+		r := rels[id]
+		keys, vals := st.tagIDs(r.tags)
+		roles := make([]uint64, len(r.members))
+		memIDs := make([]int64, len(r.members))
+		types := make([]uint64, len(r.members))
+		for i, m := range r.members {
+			roles[i] = st.id(m.Role)
+			memIDs[i] = m.ID
+			types[i] = uint64(m.Type)
+		}
+		var b []byte
+		b = appendUintField(b, 1, uint64(id))
+		b = appendPacked(b, 2, keys)
+		b = appendPacked(b, 3, vals)
+		b = appendPacked(b, 8, roles)
+		b = appendPacked(b, 9, deltas(memIDs))
+		b = appendPacked(b, 10, types)
+		group = appendBytesField(group, 4, b)
+	}
+	return primitiveBlock(st, group)
+}
+
+type bbox struct {
+	minLon, minLat, maxLon, maxLat int64
+}
+
+// headerBlock returns an encoded HeaderBlock. The bbox is optional.
+func headerBlock(bb *bbox) []byte {
+	var b []byte
+	if bb != nil {
+		// HeaderBBox is in nanodegrees
+		var h []byte
+		h = appendSintField(h, 1, bb.minLon*100)
+		h = appendSintField(h, 2, bb.maxLon*100)
+		h = appendSintField(h, 3, bb.maxLat*100)
+		h = appendSintField(h, 4, bb.minLat*100)
+		b = appendBytesField(b, 1, h)
+	}
+	b = appendStringField(b, 4, "OsmSchema-V0.6")
+	b = appendStringField(b, 4, "DenseNodes")
+	b = appendStringField(b, 5, "Sort.Type_then_ID")
+	b = appendStringField(b, 16, "imposm3")
+	return b
+}
+
+// writeBlob writes data as zlib compressed blob with the BlobHeader of
+// type typ (OSMHeader or OSMData).
+func writeBlob(w io.Writer, typ string, data []byte) error {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	blob := appendUintField(nil, 2, uint64(len(data)))
+	blob = appendBytesField(blob, 3, compressed.Bytes())
+
+	header := appendStringField(nil, 1, typ)
+	header = appendUintField(header, 3, uint64(len(blob)))
+
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(header)))
+	if _, err := w.Write(size[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(blob)
+	return err
+}
@@ -0,0 +1,155 @@
+package postgis
+
+import (
+	"encoding/json"
+	"fmt"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/pkg/errors"
+)
+
+// Changed elements of diff imports are stored in the changes table (e.g.
+// osm_changes) of the production schema, if the record_changes option is
+// enabled. The rows are inserted in the transaction of the diff import.
+// The old_ columns are only filled for diffs with the previous state of
+// the elements (augmented diffs). The table is not part of the mapping and
+// it is not rotated by deployments.
+
+// changesTable returns the name of the changes table.
+func (pg *PostGIS) changesTable() string {
+	return pg.Prefix + "changes"
+}
+
+func changesCreateSQL(schema, table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s"."%s" (
+    id BIGSERIAL PRIMARY KEY,
+    osm_type TEXT NOT NULL,
+    osm_id BIGINT NOT NULL,
+    action TEXT NOT NULL,
+    version INTEGER,
+    changeset BIGINT,
+    user_name TEXT,
+    timestamp TIMESTAMPTZ,
+    tags JSONB,
+    old_version INTEGER,
+    old_changeset BIGINT,
+    old_user_name TEXT,
+    old_timestamp TIMESTAMPTZ,
+    old_tags JSONB,
+    imported_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`, schema, table),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%[2]s_osm_id" ON "%[1]s"."%[2]s" (osm_type, osm_id)`, schema, table),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%[2]s_changeset" ON "%[1]s"."%[2]s" (changeset)`, schema, table),
+	}
+}
+
+func changesInsertSQL(schema, table string) string {
+	return fmt.Sprintf(`INSERT INTO "%s"."%s" (osm_type, osm_id, action, version, changeset, user_name, timestamp, tags, old_version, old_changeset, old_user_name, old_timestamp, old_tags)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`, schema, table)
+}
+
+// elementValues returns the version, changeset, user name, timestamp and
+// tags of elem. Unknown values are nil, e.g. the metadata of elements
+// from diffs without metadata.
+func elementValues(elem *osm.Element) ([]interface{}, error) {
+	values := []interface{}{nil, nil, nil, nil, nil}
+	if elem == nil {
+		return values, nil
+	}
+	if m := elem.Metadata; m != nil {
+		values[0] = m.Version
+		values[1] = m.Changeset
+		values[2] = m.UserName
+		if !m.Timestamp.IsZero() {
+			values[3] = m.Timestamp
+		}
+	}
+	if len(elem.Tags) > 0 {
+		b, err := json.Marshal(elem.Tags)
+		if err != nil {
+			return nil, err
+		}
+		values[4] = string(b)
+	}
+	return values, nil
+}
+
+// changeValues returns the values for changesInsertSQL.
+func changeValues(change osm.Diff, old *osm.Element) ([]interface{}, error) {
+	var typ string
+	var elem *osm.Element
+	switch {
+	case change.Node != nil:
+		typ, elem = "node", &change.Node.Element
+	case change.Way != nil:
+		typ, elem = "way", &change.Way.Element
+	case change.Rel != nil:
+		typ, elem = "relation", &change.Rel.Element
+	default:
+		return nil, errors.New("change without element")
+	}
+	var action string
+	switch {
+	case change.Create:
+		action = "create"
+	case change.Modify:
+		action = "modify"
+	default:
+		action = "delete"
+	}
+
+	newValues, err := elementValues(elem)
+	if err != nil {
+		return nil, err
+	}
+	if change.Delete {
+		// deleted elements have no tags
+		newValues[4] = nil
+	}
+	oldValues, err := elementValues(old)
+	if err != nil {
+		return nil, err
+	}
+	values := []interface{}{typ, elem.ID, action}
+	values = append(values, newValues...)
+	return append(values, oldValues...), nil
+}
+
+// RecordsChanges returns whether the record_changes option is enabled.
+func (pg *PostGIS) RecordsChanges() bool {
+	return pg.RecordChanges
+}
+
+// RecordChange inserts a changed element into the changes table, within
+// the transaction of the diff import.
+func (pg *PostGIS) RecordChange(change osm.Diff, old *osm.Element) error {
+	if !pg.RecordChanges {
+		return nil
+	}
+	schema := pg.Config.ProductionSchema
+	table := pg.changesTable()
+
+	exec := pg.Db.Exec
+	if pg.txRouter != nil && pg.txRouter.tx != nil {
+		exec = pg.txRouter.tx.Exec
+	}
+
+	if !pg.changesCreated {
+		for _, sql := range changesCreateSQL(schema, table) {
+			if _, err := exec(sql); err != nil {
+				return &SQLError{sql, err}
+			}
+		}
+		pg.changesCreated = true
+	}
+	values, err := changeValues(change, old)
+	if err != nil {
+		return err
+	}
+	sql := changesInsertSQL(schema, table)
+	if _, err := exec(sql, values...); err != nil {
+		return errors.Wrapf(&SQLError{sql, err}, "recording change of %s %d", values[0], values[1])
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package postgis
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	osm "github.com/omniscale/go-osm"
+)
+
+func TestChangesSQL(t *testing.T) {
+	sql := strings.Join(changesCreateSQL("public", "osm_changes"), ";\n")
+	for _, part := range []string{
+		`CREATE TABLE IF NOT EXISTS "public"."osm_changes" (`,
+		`old_tags JSONB`,
+		`CREATE INDEX IF NOT EXISTS "osm_changes_osm_id" ON "public"."osm_changes" (osm_type, osm_id)`,
+	} {
+		if !strings.Contains(sql, part) {
+			t.Errorf("missing %q in %s", part, sql)
+		}
+	}
+	sql = changesInsertSQL("public", "osm_changes")
+	if !strings.HasPrefix(sql, `INSERT INTO "public"."osm_changes" (osm_type, osm_id, action, `) {
+		t.Errorf("unexpected insert %s", sql)
+	}
+}
+
+func TestChangeValues(t *testing.T) {
+	ts := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	old := &osm.Element{
+		ID:       2,
+		Tags:     osm.Tags{"highway": "track"},
+		Metadata: &osm.Metadata{Version: 1, Changeset: 90, UserName: "other", Timestamp: ts},
+	}
+	way := &osm.Way{Element: osm.Element{
+		ID:       2,
+		Tags:     osm.Tags{"highway": "residential"},
+		Metadata: &osm.Metadata{Version: 2, Changeset: 100, UserName: "mapper", Timestamp: ts},
+	}}
+
+	values, err := changeValues(osm.Diff{Modify: true, Way: way}, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{"way", int64(2), "modify",
+		int32(2), int64(100), "mapper", ts, `{"highway":"residential"}`,
+		int32(1), int64(90), "other", ts, `{"highway":"track"}`,
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("unexpected values\n%#v\nexpected\n%#v", values, expected)
+	}
+
+	// osc without metadata
+	values, err = changeValues(osm.Diff{Delete: true, Node: &osm.Node{Element: osm.Element{ID: 3}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []interface{}{"node", int64(3), "delete", nil, nil, nil, nil, nil, nil, nil, nil, nil, nil}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("unexpected values\n%#v\nexpected\n%#v", values, expected)
+	}
+}
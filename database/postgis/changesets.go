@@ -0,0 +1,137 @@
+package postgis
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/pkg/errors"
+)
+
+// Changesets are stored in the changesets table (e.g. osm_changesets) of
+// the production schema. The table is not part of the mapping and it is
+// not rotated by deployments. It is created with the first changesets.
+
+// changesetsTable returns the name of the changesets table.
+func (pg *PostGIS) changesetsTable() string {
+	return pg.Prefix + "changesets"
+}
+
+func changesetsCreateSQL(schema, table string, srid int) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s"."%s" (
+    id BIGINT PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    closed_at TIMESTAMPTZ,
+    open BOOLEAN,
+    user_id INTEGER,
+    user_name TEXT,
+    num_changes INTEGER,
+    comment TEXT,
+    tags JSONB,
+    bbox geometry(Polygon, %d)
+)`, schema, table, srid),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%[2]s_bbox" ON "%[1]s"."%[2]s" USING GIST (bbox)`, schema, table),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%[2]s_created_at" ON "%[1]s"."%[2]s" (created_at)`, schema, table),
+	}
+}
+
+func changesetsInsertSQL(schema, table string, srid int) string {
+	return fmt.Sprintf(`INSERT INTO "%s"."%s" (id, created_at, closed_at, open, user_id, user_name, num_changes, comment, tags, bbox)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, ST_Transform(ST_MakeEnvelope($10, $11, $12, $13, 4326), %d))
+ON CONFLICT (id) DO UPDATE SET
+    created_at = EXCLUDED.created_at,
+    closed_at = EXCLUDED.closed_at,
+    open = EXCLUDED.open,
+    user_id = EXCLUDED.user_id,
+    user_name = EXCLUDED.user_name,
+    num_changes = EXCLUDED.num_changes,
+    comment = EXCLUDED.comment,
+    tags = EXCLUDED.tags,
+    bbox = EXCLUDED.bbox`, schema, table, srid)
+}
+
+// changesetValues returns the values for changesetsInsertSQL.
+func changesetValues(cs *osm.Changeset, srid int) ([]interface{}, error) {
+	var closedAt, comment, tags interface{}
+	if !cs.ClosedAt.IsZero() {
+		closedAt = cs.ClosedAt
+	}
+	if c, ok := cs.Tags["comment"]; ok {
+		comment = c
+	}
+	if len(cs.Tags) > 0 {
+		b, err := json.Marshal(cs.Tags)
+		if err != nil {
+			return nil, err
+		}
+		tags = string(b)
+	}
+	values := []interface{}{cs.ID, cs.CreatedAt, closedAt, cs.Open, cs.UserID, cs.UserName, cs.NumChanges, comment, tags}
+
+	if cs.MaxExtent == [4]float64{} {
+		// no changes
+		return append(values, nil, nil, nil, nil), nil
+	}
+	ext := cs.MaxExtent
+	if srid == 3857 {
+		// ST_Transform fails for the poles
+		for _, i := range []int{1, 3} {
+			ext[i] = math.Max(-85.0511, math.Min(85.0511, ext[i]))
+		}
+	}
+	return append(values, ext[0], ext[1], ext[2], ext[3]), nil
+}
+
+// InsertChangesets inserts or updates the changesets in a single
+// transaction. The transaction is repeated after transient errors.
+func (pg *PostGIS) InsertChangesets(changesets []osm.Changeset) error {
+	schema := pg.Config.ProductionSchema
+	table := pg.changesetsTable()
+	srid := pg.Config.Srid
+
+	insert := func() error {
+		tx, err := pg.Db.Begin()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if tx != nil {
+				tx.Rollback()
+			}
+		}()
+
+		if !pg.changesetsCreated {
+			for _, sql := range changesetsCreateSQL(schema, table, srid) {
+				if _, err := tx.Exec(sql); err != nil {
+					return &SQLError{sql, err}
+				}
+			}
+		}
+		sql := changesetsInsertSQL(schema, table, srid)
+		stmt, err := tx.Prepare(sql)
+		if err != nil {
+			return &SQLError{sql, err}
+		}
+		defer stmt.Close()
+		for i := range changesets {
+			values, err := changesetValues(&changesets[i], srid)
+			if err != nil {
+				return err
+			}
+			if _, err := stmt.Exec(values...); err != nil {
+				return errors.Wrapf(&SQLError{sql, err}, "inserting changeset %d", changesets[i].ID)
+			}
+		}
+		err = tx.Commit()
+		tx = nil
+		if err != nil {
+			return err
+		}
+		pg.changesetsCreated = true
+		return nil
+	}
+	err := insert()
+	return pg.Retry.retry("inserting changesets", err, insert)
+}
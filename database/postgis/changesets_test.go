@@ -0,0 +1,67 @@
+package postgis
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	osm "github.com/omniscale/go-osm"
+)
+
+func TestChangesetsSQL(t *testing.T) {
+	sql := strings.Join(changesetsCreateSQL("public", "osm_changesets", 3857), ";\n")
+	for _, part := range []string{
+		`CREATE TABLE IF NOT EXISTS "public"."osm_changesets" (`,
+		`id BIGINT PRIMARY KEY`,
+		`bbox geometry(Polygon, 3857)`,
+		`CREATE INDEX IF NOT EXISTS "osm_changesets_bbox" ON "public"."osm_changesets" USING GIST (bbox)`,
+	} {
+		if !strings.Contains(sql, part) {
+			t.Errorf("missing %q in %s", part, sql)
+		}
+	}
+	sql = changesetsInsertSQL("public", "osm_changesets", 3857)
+	for _, part := range []string{
+		`INSERT INTO "public"."osm_changesets" (id, `,
+		`ST_Transform(ST_MakeEnvelope($10, $11, $12, $13, 4326), 3857)`,
+		`ON CONFLICT (id) DO UPDATE SET`,
+	} {
+		if !strings.Contains(sql, part) {
+			t.Errorf("missing %q in %s", part, sql)
+		}
+	}
+}
+
+func TestChangesetValues(t *testing.T) {
+	created := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	values, err := changesetValues(&osm.Changeset{
+		ID:         1,
+		CreatedAt:  created,
+		Open:       true,
+		UserID:     42,
+		UserName:   "mapper",
+		NumChanges: 2,
+		MaxExtent:  [4]float64{9.9, 53.5, 10.1, 90},
+		Tags:       osm.Tags{"comment": "Add cafes"},
+	}, 3857)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{int64(1), created, nil, true, int32(42), "mapper", int32(2),
+		"Add cafes", `{"comment":"Add cafes"}`, 9.9, 53.5, 10.1, 85.0511}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("unexpected values\n%#v\nexpected\n%#v", values, expected)
+	}
+
+	values, err = changesetValues(&osm.Changeset{ID: 2, CreatedAt: created}, 4326)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 7; i < len(values); i++ {
+		if values[i] != nil {
+			t.Errorf("expected nil for comment, tags and bbox, got %#v", values)
+			break
+		}
+	}
+}
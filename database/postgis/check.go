@@ -0,0 +1,146 @@
+package postgis
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/pkg/errors"
+)
+
+// checkTable is a table (or generalized table) with a geometry column for
+// CheckGeometries.
+type checkTable struct {
+	Schema         string
+	Table          string
+	GeometryColumn string
+	IDColumn       string
+	Geography      bool
+}
+
+// checkTables returns all tables with geometries, in the production or
+// import schema.
+func (pg *PostGIS) checkTables(production bool) []checkTable {
+	var tables []checkTable
+	add := func(schema, productionSchema, table string, spec *TableSpec) {
+		geomCol, idCol := "", "id"
+		for _, col := range spec.Columns {
+			if col.Type.Name() == "GEOMETRY" {
+				geomCol = col.Name
+			} else if col.FieldType.Name == "id" {
+				idCol = col.Name
+			}
+		}
+		if geomCol == "" {
+			return
+		}
+		if production {
+			schema = productionSchema
+		}
+		tables = append(tables, checkTable{
+			Schema:         schema,
+			Table:          table,
+			GeometryColumn: geomCol,
+			IDColumn:       idCol,
+			Geography:      spec.Geography,
+		})
+	}
+	for _, spec := range pg.Tables {
+		add(spec.Schema, spec.ProductionSchema, spec.FullName, spec)
+	}
+	for _, gen := range pg.GeneralizedTables {
+		add(gen.Schema, gen.ProductionSchema, gen.FullName, gen.Source)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Table < tables[j].Table })
+	return tables
+}
+
+// geometrySQL returns the geometry column, geography columns are casted
+// to geometries.
+func (t checkTable) geometrySQL() string {
+	if t.Geography {
+		return fmt.Sprintf(`"%s"::geometry`, t.GeometryColumn)
+	}
+	return fmt.Sprintf(`"%s"`, t.GeometryColumn)
+}
+
+// issueConditions returns the SQL conditions of empty, invalid and
+// degenerate geometries. minSize is in the units of the geometry.
+func (t checkTable) issueConditions(minSize float64) (empty, invalid, degenerate string) {
+	g := t.geometrySQL()
+	empty = fmt.Sprintf(`ST_IsEmpty(%s)`, g)
+	invalid = fmt.Sprintf(`NOT ST_IsEmpty(%[1]s) AND NOT ST_IsValid(%[1]s)`, g)
+	degenerate = fmt.Sprintf(`NOT ST_IsEmpty(%[1]s) AND ST_IsValid(%[1]s) AND (ST_Dimension(%[1]s) = 2 AND ST_Area(%[1]s) < %[2]v OR ST_Dimension(%[1]s) = 1 AND ST_Length(%[1]s) < %[3]v)`,
+		g, minSize*minSize, minSize)
+	return empty, invalid, degenerate
+}
+
+// countSQL returns the number of rows and of empty, invalid and degenerate
+// geometries.
+func (t checkTable) countSQL(minSize float64) string {
+	empty, invalid, degenerate := t.issueConditions(minSize)
+	return fmt.Sprintf(`SELECT count("%s"), count(*) FILTER (WHERE %s), count(*) FILTER (WHERE %s), count(*) FILTER (WHERE %s) FROM "%s"."%s"`,
+		t.GeometryColumn, empty, invalid, degenerate, t.Schema, t.Table)
+}
+
+// issuesSQL returns the id, issue and reason of up to limit features with
+// an issue.
+func (t checkTable) issuesSQL(minSize float64, limit int) string {
+	empty, invalid, degenerate := t.issueConditions(minSize)
+	return fmt.Sprintf(`SELECT "%[1]s", CASE WHEN %[2]s THEN 'empty' WHEN %[3]s THEN 'invalid' ELSE 'degenerate' END, CASE WHEN %[3]s THEN ST_IsValidReason(%[4]s) ELSE '' END FROM "%[6]s"."%[7]s" WHERE %[2]s OR %[3]s OR %[5]s LIMIT %[8]d`,
+		t.IDColumn, empty, invalid, t.geometrySQL(), degenerate, t.Schema, t.Table, limit)
+}
+
+// CheckGeometries reports invalid, empty and degenerate geometries of all
+// tables and generalized tables. Tables that do not exist are skipped.
+func (pg *PostGIS) CheckGeometries(production bool, limit int, minSize float64) ([]database.GeometryReport, error) {
+	tx, err := pg.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// only reads
+	defer tx.Rollback()
+
+	var reports []database.GeometryReport
+	for _, t := range pg.checkTables(production) {
+		exists, err := tableExists(tx, t.Schema, t.Table)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		size := minSize
+		if t.Geography || pg.Config.Srid == 4326 {
+			size /= 6378137 * math.Pi / 180
+		}
+
+		r := database.GeometryReport{Table: t.Schema + "." + t.Table}
+		sql := t.countSQL(size)
+		if err := tx.QueryRow(sql).Scan(&r.Rows, &r.Empty, &r.Invalid, &r.Degenerate); err != nil {
+			return nil, &SQLError{sql, err}
+		}
+		if r.Empty+r.Invalid+r.Degenerate > 0 && limit > 0 {
+			sql := t.issuesSQL(size, limit)
+			rows, err := tx.Query(sql)
+			if err != nil {
+				return nil, &SQLError{sql, err}
+			}
+			for rows.Next() {
+				var issue database.GeometryIssue
+				if err := rows.Scan(&issue.ID, &issue.Issue, &issue.Reason); err != nil {
+					rows.Close()
+					return nil, err
+				}
+				r.Issues = append(r.Issues, issue)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return nil, errors.Wrapf(err, "checking %s", r.Table)
+			}
+		}
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
@@ -0,0 +1,78 @@
+package postgis
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/mapping/config"
+)
+
+func TestCheckTables(t *testing.T) {
+	pg := &PostGIS{
+		Prefix: "osm_",
+		Config: database.Config{ImportSchema: "import", ProductionSchema: "public", Srid: 3857},
+		Tables: map[string]*TableSpec{},
+	}
+	for _, tbl := range []*config.Table{
+		{Name: "roads", Type: "linestring", Columns: []*config.Column{
+			{Name: "osm_id", Type: "id"},
+			{Name: "geometry", Type: "geometry"},
+		}},
+		{Name: "members", Type: "relation", Columns: []*config.Column{
+			{Name: "osm_id", Type: "id"},
+		}},
+	} {
+		spec, err := NewTableSpec(pg, tbl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pg.Tables[tbl.Name] = spec
+	}
+	pg.GeneralizedTables = map[string]*GeneralizedTableSpec{
+		"roads_gen": {FullName: "osm_roads_gen", Schema: "import", ProductionSchema: "public", Source: pg.Tables["roads"]},
+	}
+
+	tables := pg.checkTables(true)
+	if len(tables) != 2 {
+		t.Fatalf("unexpected tables %v", tables)
+	}
+	if tables[0] != (checkTable{Schema: "public", Table: "osm_roads", GeometryColumn: "geometry", IDColumn: "osm_id"}) {
+		t.Errorf("unexpected table %v", tables[0])
+	}
+	if tables[1].Table != "osm_roads_gen" || tables[1].Schema != "public" {
+		t.Errorf("unexpected generalized table %v", tables[1])
+	}
+	if tables := pg.checkTables(false); tables[0].Schema != "import" {
+		t.Errorf("unexpected schema %v", tables[0])
+	}
+}
+
+func TestCheckSQL(t *testing.T) {
+	tbl := checkTable{Schema: "import", Table: "osm_roads", GeometryColumn: "geometry", IDColumn: "osm_id"}
+	sql := tbl.countSQL(0.5)
+	for _, s := range []string{
+		`count("geometry")`,
+		`FILTER (WHERE ST_IsEmpty("geometry"))`,
+		`NOT ST_IsValid("geometry")`,
+		`ST_Area("geometry") < 0.25`,
+		`ST_Length("geometry") < 0.5`,
+		`FROM "import"."osm_roads"`,
+	} {
+		if !strings.Contains(sql, s) {
+			t.Errorf("missing %s in %s", s, sql)
+		}
+	}
+
+	tbl.Geography = true
+	sql = tbl.issuesSQL(0.5, 10)
+	for _, s := range []string{
+		`SELECT "osm_id"`,
+		`ST_IsValidReason("geometry"::geometry)`,
+		`LIMIT 10`,
+	} {
+		if !strings.Contains(sql, s) {
+			t.Errorf("missing %s in %s", s, sql)
+		}
+	}
+}
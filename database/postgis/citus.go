@@ -0,0 +1,87 @@
+package postgis
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/omniscale/imposm3/log"
+)
+
+// Tables are created as distributed tables when importing into a Citus
+// cluster. The tables are distributed by the OSM ID, so that all rows of
+// an element are in the same shard and updates only touch a single shard.
+// All rows are written through the coordinator, Imposm does not route
+// COPYs to the shards.
+
+// hasClusterOption returns whether any table has a cluster option.
+func (pg *PostGIS) hasClusterOption() bool {
+	for _, tbl := range pg.Tables {
+		if tbl.Cluster != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// distributionColumn returns the column to distribute the table by. Tables
+// without OSM ID column are distributed by their serial id.
+func distributionColumn(columns []ColumnSpec) string {
+	for _, col := range columns {
+		if col.FieldType.Name == "id" {
+			return col.Name
+		}
+	}
+	return "id"
+}
+
+// DistributeSQL returns the statement to create the distributed table.
+func (spec *TableSpec) DistributeSQL() string {
+	return fmt.Sprintf(`SELECT create_distributed_table('"%s"."%s"', '%s')`,
+		spec.Schema, spec.FullName, distributionColumn(spec.Columns))
+}
+
+// DistributeSQL returns the statements to distribute the generalized table.
+// The table is colocated with its source and the local data, copied by
+// create_distributed_table, is removed afterwards.
+func (spec *GeneralizedTableSpec) DistributeSQL() []string {
+	table := fmt.Sprintf(`"%s"."%s"`, spec.Schema, spec.FullName)
+	source := fmt.Sprintf(`"%s"."%s"`, spec.Source.Schema, spec.Source.FullName)
+	return []string{
+		fmt.Sprintf(`SELECT create_distributed_table('%s', '%s', colocate_with => '%s')`,
+			table, distributionColumn(spec.Source.Columns), source),
+		fmt.Sprintf(`SELECT truncate_local_data_after_distributing_table('%s')`, table),
+	}
+}
+
+// distributeTables creates distributed tables of all (empty) import tables.
+func (pg *PostGIS) distributeTables() error {
+	defer log.Step("Creating distributed tables")()
+
+	worker := int(runtime.GOMAXPROCS(0))
+	if worker < 1 {
+		worker = 1
+	}
+	p := newWorkerPool(worker, len(pg.Tables))
+	for _, tbl := range pg.Tables {
+		tbl := tbl // for following closure
+		p.in <- func() error {
+			sql := tbl.DistributeSQL()
+			if _, err := pg.Db.Exec(sql); err != nil {
+				return &SQLError{sql, err}
+			}
+			return nil
+		}
+	}
+	return p.wait()
+}
+
+// distributeGeneralizedTable distributes a generalized table after it was
+// created from its source table.
+func (pg *PostGIS) distributeGeneralizedTable(spec *GeneralizedTableSpec) error {
+	for _, sql := range spec.DistributeSQL() {
+		if _, err := pg.Db.Exec(sql); err != nil {
+			return &SQLError{sql, err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package postgis
+
+import (
+	"testing"
+
+	"github.com/omniscale/imposm3/mapping"
+)
+
+func TestDistributeSQL(t *testing.T) {
+	spec, err := testTableSpec(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql := spec.DistributeSQL(); sql != `SELECT create_distributed_table('"import"."osm_roads"', 'osm_id')` {
+		t.Errorf("unexpected sql %s", sql)
+	}
+
+	// tables without OSM ID are distributed by the serial id
+	noID := &TableSpec{Schema: "import", FullName: "osm_misc", Columns: []ColumnSpec{
+		{Name: "name", FieldType: mapping.ColumnType{Name: "string"}},
+	}}
+	if sql := noID.DistributeSQL(); sql != `SELECT create_distributed_table('"import"."osm_misc"', 'id')` {
+		t.Errorf("unexpected sql %s", sql)
+	}
+
+	gen := &GeneralizedTableSpec{Schema: "import", FullName: "osm_roads_gen0", Source: spec}
+	stmts := gen.DistributeSQL()
+	if len(stmts) != 2 ||
+		stmts[0] != `SELECT create_distributed_table('"import"."osm_roads_gen0"', 'osm_id', colocate_with => '"import"."osm_roads"')` ||
+		stmts[1] != `SELECT truncate_local_data_after_distributing_table('"import"."osm_roads_gen0"')` {
+		t.Errorf("unexpected sql %v", stmts)
+	}
+}
+
+func TestHasClusterOption(t *testing.T) {
+	pg := &PostGIS{Tables: map[string]*TableSpec{
+		"roads": {FullName: "osm_roads"},
+	}}
+	if pg.hasClusterOption() {
+		t.Error("expected no cluster option")
+	}
+	pg.Tables["buildings"] = &TableSpec{FullName: "osm_buildings", Cluster: "geometry"}
+	if !pg.hasClusterOption() {
+		t.Error("expected cluster option")
+	}
+}
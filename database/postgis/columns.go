@@ -3,6 +3,7 @@ package postgis
 import (
 	"fmt"
 
+	"github.com/omniscale/imposm3/geom"
 	"github.com/omniscale/imposm3/log"
 )
 
@@ -38,17 +39,48 @@ func (t *geometryType) Name() string {
 }
 
 func (t *geometryType) PrepareInsertSQL(i int, spec *TableSpec) string {
+	if spec.Geography {
+		// the geometries of geography tables are already in EPSG:4326,
+		// see TableSpec.geographyRow
+		return fmt.Sprintf("$%d::Geometry::Geography", i)
+	}
 	return fmt.Sprintf("$%d::Geometry",
 		i,
 	)
 }
 
 func (t *geometryType) GeneralizeSQL(colSpec *ColumnSpec, spec *GeneralizedTableSpec) string {
-	return fmt.Sprintf(`ST_SimplifyPreserveTopology("%s", %f) as "%s"`,
-		colSpec.Name, spec.Tolerance, colSpec.Name,
+	return fmt.Sprintf(`%s as "%s"`,
+		orientedSQL(simplifySQL(colSpec.Name, spec), spec.PolygonOrientation),
+		colSpec.Name,
 	)
 }
 
+// simplifySQL simplifies the geometry column with the tolerance and
+// algorithm of the generalized table. Visvalingam-Whyatt uses the square of
+// the tolerance as minimum area. Topology tables are simplified after the
+// insert, see simplifyTopology.
+func simplifySQL(column string, spec *GeneralizedTableSpec) string {
+	if spec.SimplifyAlgorithm == geom.Topology {
+		return fmt.Sprintf(`"%s"`, column)
+	}
+	if spec.SimplifyAlgorithm == "visvalingam" {
+		return fmt.Sprintf(`ST_SimplifyVW("%s", %f)`, column, spec.Tolerance*spec.Tolerance)
+	}
+	return fmt.Sprintf(`ST_SimplifyPreserveTopology("%s", %f)`, column, spec.Tolerance)
+}
+
+// orientedSQL forces the ring orientation of the polygons of geometrySQL.
+func orientedSQL(geometrySQL, orientation string) string {
+	switch orientation {
+	case "rfc7946":
+		return "ST_ForcePolygonCCW(" + geometrySQL + ")"
+	case "postgis":
+		return "ST_ForcePolygonCW(" + geometrySQL + ")"
+	}
+	return geometrySQL
+}
+
 type validatedGeometryType struct {
 	geometryType
 }
@@ -58,11 +90,23 @@ func (t *validatedGeometryType) GeneralizeSQL(colSpec *ColumnSpec, spec *General
 		// TODO return warning earlier
 		log.Printf("[warn] validated_geometry column returns polygon geometries for %s", spec.FullName)
 	}
-	return fmt.Sprintf(`ST_Buffer(ST_SimplifyPreserveTopology("%s", %f), 0) as "%s"`,
-		colSpec.Name, spec.Tolerance, colSpec.Name,
+	return fmt.Sprintf(`%s as "%s"`,
+		orientedSQL("ST_Buffer("+simplifySQL(colSpec.Name, spec)+", 0)", spec.PolygonOrientation),
+		colSpec.Name,
 	)
 }
 
+// additionalGeometryType is an additional point or polygon column, besides
+// the geometry column of the table. The column accepts geometries in any
+// SRID.
+type additionalGeometryType struct {
+	simpleColumnType
+}
+
+func (t *additionalGeometryType) PrepareInsertSQL(i int, spec *TableSpec) string {
+	return fmt.Sprintf("$%d::Geometry", i)
+}
+
 var pgTypes map[string]ColumnType
 
 func init() {
@@ -76,5 +120,7 @@ func init() {
 		"hstore_string":      &simpleColumnType{"HSTORE"},
 		"geometry":           &geometryType{"GEOMETRY"},
 		"validated_geometry": &validatedGeometryType{geometryType{"GEOMETRY"}},
+		"point_geometry":     &additionalGeometryType{simpleColumnType{"GEOMETRY(POINT)"}},
+		"polygon_geometry":   &additionalGeometryType{simpleColumnType{"GEOMETRY(POLYGON)"}},
 	}
 }
@@ -0,0 +1,175 @@
+package postgis
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/pkg/errors"
+)
+
+var copyBinaryHeader = []byte("PGCOPY\n\377\r\n\000\000\000\000\000\000\000\000\000")
+
+// columnEncoder appends the binary representation of a value to buf.
+type columnEncoder func(buf []byte, v interface{}) ([]byte, error)
+
+// copyEncoder encodes rows in the binary COPY format.
+type copyEncoder struct {
+	w        *bufio.Writer
+	encoders []columnEncoder
+	buf      []byte
+}
+
+func newCopyEncoder(w io.Writer, spec *TableSpec) (*copyEncoder, error) {
+	enc := &copyEncoder{w: bufio.NewWriterSize(w, 1<<20)}
+	for _, col := range spec.Columns {
+		e, ok := columnEncoders[col.Type.Name()]
+		if !ok {
+			return nil, errors.Errorf("binary COPY of %s columns not supported", col.Type.Name())
+		}
+		enc.encoders = append(enc.encoders, e)
+	}
+	if _, err := enc.w.Write(copyBinaryHeader); err != nil {
+		return nil, err
+	}
+	return enc, nil
+}
+
+// Encode writes a single row.
+func (enc *copyEncoder) Encode(row []interface{}) error {
+	buf := enc.buf[:0]
+	buf = appendInt16(buf, int16(len(enc.encoders)))
+	for i, e := range enc.encoders {
+		if row[i] == nil {
+			buf = appendInt32(buf, -1)
+			continue
+		}
+		lenPos := len(buf)
+		buf = appendInt32(buf, 0)
+		var err error
+		buf, err = e(buf, row[i])
+		if err == errNull {
+			buf = appendInt32(buf[:lenPos], -1)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint32(buf[lenPos:], uint32(len(buf)-lenPos-4))
+	}
+	enc.buf = buf
+	_, err := enc.w.Write(buf)
+	return err
+}
+
+// Close writes the trailer and flushes all rows. It does not close the
+// underlying writer.
+func (enc *copyEncoder) Close() error {
+	if _, err := enc.w.Write(appendInt16(nil, -1)); err != nil {
+		return err
+	}
+	return enc.w.Flush()
+}
+
+// errNull is returned by columnEncoders for values that are stored as NULL.
+var errNull = errors.New("null value")
+
+var columnEncoders = map[string]columnEncoder{
+	"VARCHAR": func(buf []byte, v interface{}) ([]byte, error) {
+		if s, ok := v.(string); ok {
+			return append(buf, s...), nil
+		}
+		return append(buf, fmt.Sprint(v)...), nil
+	},
+	"BOOL": func(buf []byte, v interface{}) ([]byte, error) {
+		n, ok := database.AsInt64(v)
+		if !ok {
+			return nil, errors.Errorf("invalid bool value %v", v)
+		}
+		if n != 0 {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	},
+	"SMALLINT": func(buf []byte, v interface{}) ([]byte, error) {
+		n, ok := database.AsInt64(v)
+		if !ok {
+			return nil, errors.Errorf("invalid smallint value %v", v)
+		}
+		return appendInt16(buf, int16(n)), nil
+	},
+	"INT": func(buf []byte, v interface{}) ([]byte, error) {
+		n, ok := database.AsInt64(v)
+		if !ok {
+			return nil, errors.Errorf("invalid int value %v", v)
+		}
+		return appendInt32(buf, int32(n)), nil
+	},
+	"BIGINT": func(buf []byte, v interface{}) ([]byte, error) {
+		n, ok := database.AsInt64(v)
+		if !ok {
+			return nil, errors.Errorf("invalid bigint value %v", v)
+		}
+		return appendInt32(appendInt32(buf, int32(n>>32)), int32(n)), nil
+	},
+	"REAL": func(buf []byte, v interface{}) ([]byte, error) {
+		f, ok := database.AsFloat64(v)
+		if !ok {
+			return nil, errors.Errorf("invalid real value %v", v)
+		}
+		return appendInt32(buf, int32(math.Float32bits(float32(f)))), nil
+	},
+	"HSTORE": func(buf []byte, v interface{}) ([]byte, error) {
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.Errorf("invalid hstore value %v", v)
+		}
+		tags := database.ParseHstore(s)
+		buf = appendInt32(buf, int32(len(tags)))
+		for k, v := range tags {
+			buf = appendInt32(buf, int32(len(k)))
+			buf = append(buf, k...)
+			buf = appendInt32(buf, int32(len(v)))
+			buf = append(buf, v...)
+		}
+		return buf, nil
+	},
+	// Geometries are passed as hex encoded EWKB and transfered as binary
+	// EWKB.
+	"GEOMETRY":          encodeGeometry,
+	"GEOMETRY(POINT)":   encodeGeometry,
+	"GEOMETRY(POLYGON)": encodeGeometry,
+}
+
+func encodeGeometry(buf []byte, v interface{}) ([]byte, error) {
+	var h string
+	switch v := v.(type) {
+	case string:
+		h = v
+	case []byte:
+		h = string(v)
+	default:
+		return nil, errors.Errorf("invalid geometry value %v", v)
+	}
+	if h == "" {
+		return buf, errNull
+	}
+	n := len(buf)
+	buf = append(buf, make([]byte, hex.DecodedLen(len(h)))...)
+	if _, err := hex.Decode(buf[n:], []byte(h)); err != nil {
+		return nil, errors.Wrap(err, "decoding geometry")
+	}
+	return buf, nil
+}
+
+func appendInt16(buf []byte, v int16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+func appendInt32(buf []byte, v int32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
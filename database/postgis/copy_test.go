@@ -0,0 +1,79 @@
+package postgis
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestCopyEncoder(t *testing.T) {
+	spec, err := testTableSpec(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	enc, err := newCopyEncoder(buf, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode([]interface{}{int64(-2), "0101000000000000000000F03F0000000000000040", "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode([]interface{}{int64(1), "", nil}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "5047434f50590aff0d0a00" + "00000000" + "00000000" +
+		"0003" +
+		"00000008" + "fffffffffffffffe" +
+		"00000015" + "0101000000000000000000f03f0000000000000040" +
+		"00000003" + "666f6f" +
+		"0003" +
+		"00000008" + "0000000000000001" +
+		"ffffffff" +
+		"ffffffff" +
+		"ffff"
+	if h := hex.EncodeToString(buf.Bytes()); h != expected {
+		t.Errorf("unexpected COPY data\n%s\n%s", h, expected)
+	}
+}
+
+func TestCopyEncoderValues(t *testing.T) {
+	for _, tc := range []struct {
+		typ      string
+		value    interface{}
+		expected string
+	}{
+		{"BOOL", true, "01"},
+		{"BOOL", 0, "00"},
+		{"SMALLINT", int64(-1), "ffff"},
+		{"INT", 258, "00000102"},
+		{"REAL", float32(1.5), "3fc00000"},
+		{"VARCHAR", 12, "3132"},
+		{"HSTORE", `"a"=>"b"`, "00000001" + "00000001" + "61" + "00000001" + "62"},
+	} {
+		buf, err := columnEncoders[tc.typ](nil, tc.value)
+		if err != nil {
+			t.Fatal(tc.typ, err)
+		}
+		if h := hex.EncodeToString(buf); h != tc.expected {
+			t.Errorf("%s %v: unexpected %s, expected %s", tc.typ, tc.value, h, tc.expected)
+		}
+	}
+
+	if _, err := columnEncoders["GEOMETRY"](nil, "zz"); err == nil {
+		t.Error("expected error for invalid geometry")
+	}
+}
+
+func TestCopyEncoderColumnTypes(t *testing.T) {
+	// all column types need an encoder for the bulk import
+	for name, typ := range pgTypes {
+		if _, ok := columnEncoders[typ.Name()]; !ok {
+			t.Errorf("no COPY encoder for %s (%s)", typ.Name(), name)
+		}
+	}
+}
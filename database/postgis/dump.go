@@ -0,0 +1,379 @@
+package postgis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/mapping/config"
+	"github.com/pkg/errors"
+)
+
+// Dump writes the import as plain SQL script, in the same format as
+// pg_dump. The script creates all tables in the import schema, fills them
+// with COPY ... FROM stdin and creates all generalized tables and indices
+// afterwards. It can be loaded with psql without Imposm.
+//
+// Rows are spooled into a temporary file for each table, as COPY requires
+// all rows of a table in a single block.
+type Dump struct {
+	pg     *PostGIS // table specs only, Dump does not connect to a database
+	Path   string   // output file or - for stdout
+	tables map[string]*dumpTable
+}
+
+type dumpTable struct {
+	spec  *TableSpec
+	mu    sync.Mutex
+	spool *os.File
+	w     *bufio.Writer
+	buf   []byte
+}
+
+// NewDump returns a Dump for the pgdump connection
+// (pgdump:/path/to/import.sql or pgdump:- for stdout). The prefix,
+// tablespace and index_tablespace parameters can follow the path, separated
+// by spaces, like for PostGIS connections.
+func NewDump(conf database.Config, m *config.Mapping) (database.DB, error) {
+	fields := strings.Fields(strings.TrimPrefix(conf.ConnectionParams, "pgdump:"))
+	if len(fields) == 0 {
+		return nil, errors.New("missing output file in pgdump connection, use - for stdout")
+	}
+	params := strings.Join(fields[1:], " ")
+
+	pg := &PostGIS{
+		Tables:            make(map[string]*TableSpec),
+		GeneralizedTables: make(map[string]*GeneralizedTableSpec),
+		Config:            conf,
+	}
+	// the tables are created and filled within the script, unlogged tables
+	// would not survive a crash after the restore
+	pg.Config.Unlogged = false
+
+	params, pg.Prefix = stripPrefixFromConnectionParams(params)
+	params, pg.Tablespace = stripParamFromConnectionParams(params, "tablespace")
+	params, pg.IndexTablespace = stripParamFromConnectionParams(params, "index_tablespace")
+	if params != "" {
+		return nil, errors.Errorf("unsupported pgdump parameters %q", params)
+	}
+	if err := pg.prepareTables(m); err != nil {
+		return nil, err
+	}
+	return &Dump{pg: pg, Path: fields[0]}, nil
+}
+
+func (d *Dump) tableNames() []string {
+	names := make([]string, 0, len(d.pg.Tables))
+	for name := range d.pg.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Init creates the spool files of all tables.
+func (d *Dump) Init() error {
+	dir := os.TempDir()
+	if d.Path != "-" {
+		dir = filepath.Dir(d.Path)
+	}
+	d.tables = make(map[string]*dumpTable, len(d.pg.Tables))
+	for _, name := range d.tableNames() {
+		f, err := ioutil.TempFile(dir, ".imposm-pgdump-"+name+"-")
+		if err != nil {
+			d.removeSpools()
+			return errors.Wrapf(err, "creating spool file for %s", name)
+		}
+		d.tables[name] = &dumpTable{
+			spec:  d.pg.Tables[name],
+			spool: f,
+			w:     bufio.NewWriterSize(f, 1<<20),
+		}
+	}
+	return nil
+}
+
+func (d *Dump) removeSpools() {
+	for _, t := range d.tables {
+		t.spool.Close()
+		os.Remove(t.spool.Name())
+	}
+	d.tables = nil
+}
+
+func (d *Dump) Begin() error { return nil }
+func (d *Dump) End() error   { return nil }
+
+// Abort removes all spool files.
+func (d *Dump) Abort() error {
+	d.removeSpools()
+	return nil
+}
+
+func (d *Dump) Close() error {
+	d.removeSpools()
+	return nil
+}
+
+func (t *dumpTable) write(row []interface{}) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = appendCopyTextRow(t.buf[:0], t.spec.Columns, row)
+	_, err := t.w.Write(t.buf)
+	return err
+}
+
+func (d *Dump) insert(table string, row []interface{}) error {
+	t, ok := d.tables[table]
+	if !ok {
+		return errors.Errorf("unknown table %s", table)
+	}
+	if err := t.spec.geographyRow(row); err != nil {
+		return errors.Wrapf(err, "inserting into %q", table)
+	}
+	return t.write(row)
+}
+
+func (d *Dump) insertMatches(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	for _, match := range matches {
+		if err := d.insert(match.Table.Name, match.Row(&elem, &geom)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Dump) InsertPoint(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return d.insertMatches(elem, geom, matches)
+}
+
+func (d *Dump) InsertLineString(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return d.insertMatches(elem, geom, matches)
+}
+
+func (d *Dump) InsertPolygon(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return d.insertMatches(elem, geom, matches)
+}
+
+func (d *Dump) InsertRelationMember(rel osm.Relation, m osm.Member, geom geom.Geometry, matches []mapping.Match) error {
+	for _, match := range matches {
+		if err := d.insert(match.Table.Name, match.MemberRow(&rel, &m, &geom)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Finish writes the SQL script and removes all spool files.
+func (d *Dump) Finish() error {
+	defer log.Step("Writing SQL dump")()
+	defer d.removeSpools()
+
+	out := io.Writer(os.Stdout)
+	if d.Path != "-" {
+		f, err := os.Create(d.Path)
+		if err != nil {
+			return errors.Wrapf(err, "creating %s", d.Path)
+		}
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriterSize(out, 1<<20)
+	if err := d.writeScript(w); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func (d *Dump) writeScript(w *bufio.Writer) error {
+	if err := writeStatements(w, "Tables", d.preDataSQL()); err != nil {
+		return err
+	}
+	for _, name := range d.tableNames() {
+		t := d.tables[name]
+		if err := t.w.Flush(); err != nil {
+			return err
+		}
+		if _, err := t.spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "\n--\n-- Data for %s\n--\n\n", t.spec.FullName)
+		fmt.Fprintf(w, "%s;\n", t.spec.CopyTextSQL())
+		if _, err := io.Copy(w, t.spool); err != nil {
+			return errors.Wrapf(err, "copying rows of %s", name)
+		}
+		if _, err := w.WriteString("\\.\n"); err != nil {
+			return err
+		}
+	}
+	return writeStatements(w, "Generalized tables and indices", d.postDataSQL())
+}
+
+func writeStatements(w *bufio.Writer, title string, stmts []string) error {
+	fmt.Fprintf(w, "\n--\n-- %s\n--\n\n", title)
+	for _, sql := range stmts {
+		sql = strings.TrimRight(strings.TrimSpace(sql), ";")
+		if _, err := fmt.Fprintf(w, "%s;\n", sql); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preDataSQL returns the statements to create the schemas and tables.
+func (d *Dump) preDataSQL() []string {
+	stmts := []string{
+		"SET statement_timeout = 0",
+		"SET lock_timeout = 0",
+		"SET client_encoding = 'UTF8'",
+		"SET standard_conforming_strings = on",
+		"SET client_min_messages = warning",
+		"CREATE EXTENSION IF NOT EXISTS postgis",
+	}
+	if d.hasHstore() {
+		stmts = append(stmts, "CREATE EXTENSION IF NOT EXISTS hstore")
+	}
+	for _, schema := range d.pg.importSchemas() {
+		if schema != "public" {
+			stmts = append(stmts, fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS "%s"`, schema))
+		}
+	}
+	for _, name := range d.tableNames() {
+		spec := d.pg.Tables[name]
+		stmts = append(stmts, fmt.Sprintf(`DROP TABLE IF EXISTS "%s"."%s" CASCADE`, spec.Schema, spec.FullName))
+		stmts = appendHook(stmts, spec.Hooks.BeforeCreate, spec.Schema, spec.FullName)
+		stmts = append(stmts, spec.CreateTableSQL())
+		if spec.Partition != nil {
+			stmts = append(stmts, spec.CreatePartitionsSQL()...)
+		} else if sql := addGeometryColumnSQL(spec.FullName, *spec); sql != "" {
+			stmts = append(stmts, sql)
+		}
+		stmts = append(stmts, spec.CommentSQL()...)
+		stmts = appendHook(stmts, spec.Hooks.AfterCreate, spec.Schema, spec.FullName)
+	}
+	return stmts
+}
+
+// postDataSQL returns the statements to create the generalized tables and
+// all indices.
+func (d *Dump) postDataSQL() []string {
+	var stmts []string
+	for _, name := range d.pg.sortedGeneralizedTables() {
+		spec := d.pg.GeneralizedTables[name]
+		stmts = append(stmts, fmt.Sprintf(`DROP TABLE IF EXISTS "%s"."%s" CASCADE`, spec.Schema, spec.FullName))
+		stmts = appendHook(stmts, spec.Hooks.BeforeCreate, spec.Schema, spec.FullName)
+		stmts = append(stmts, spec.CreateTableSQL())
+		stmts = append(stmts, spec.CommentSQL()...)
+		stmts = appendHook(stmts, spec.Hooks.AfterCreate, spec.Schema, spec.FullName)
+	}
+	for _, name := range d.tableNames() {
+		spec := d.pg.Tables[name]
+		stmts = append(stmts, createIndexSQL(spec.Schema, spec.FullName, spec.Columns, spec.GeometryIndex, spec.Indexes, spec.IndexTablespace, !spec.hasPrimaryKey())...)
+		stmts = append(stmts, fmt.Sprintf(`ANALYZE "%s"."%s"`, spec.Schema, spec.FullName))
+	}
+	for _, name := range d.pg.sortedGeneralizedTables() {
+		spec := d.pg.GeneralizedTables[name]
+		stmts = append(stmts, createIndexSQL(spec.Schema, spec.FullName, spec.Source.Columns, spec.GeometryIndex, spec.Indexes, spec.IndexTablespace, true)...)
+		stmts = append(stmts, fmt.Sprintf(`ANALYZE "%s"."%s"`, spec.Schema, spec.FullName))
+	}
+	for _, name := range d.tableNames() {
+		spec := d.pg.Tables[name]
+		stmts = appendHook(stmts, spec.Hooks.AfterImport, spec.Schema, spec.FullName)
+	}
+	for _, name := range d.pg.sortedGeneralizedTables() {
+		spec := d.pg.GeneralizedTables[name]
+		stmts = appendHook(stmts, spec.Hooks.AfterImport, spec.Schema, spec.FullName)
+	}
+	return stmts
+}
+
+func appendHook(stmts []string, hook, schema, table string) []string {
+	if sql := hookSQL(hook, schema, table); sql != "" {
+		return append(stmts, sql)
+	}
+	return stmts
+}
+
+func (d *Dump) hasHstore() bool {
+	for _, spec := range d.pg.Tables {
+		for _, col := range spec.Columns {
+			if col.Type.Name() == "HSTORE" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var copyTextReplacer = strings.NewReplacer(
+	"\\", "\\\\",
+	"\n", "\\n",
+	"\r", "\\r",
+	"\t", "\\t",
+)
+
+// appendCopyTextRow appends a row in the text format of COPY, terminated
+// by a newline. NULL values and empty geometries are written as \N.
+func appendCopyTextRow(buf []byte, columns []ColumnSpec, row []interface{}) []byte {
+	for i, v := range row {
+		if i > 0 {
+			buf = append(buf, '\t')
+		}
+		switch v := v.(type) {
+		case nil:
+			buf = append(buf, `\N`...)
+		case string:
+			if v == "" && columns[i].Type.Name() == "GEOMETRY" {
+				buf = append(buf, `\N`...)
+			} else {
+				buf = append(buf, copyTextReplacer.Replace(v)...)
+			}
+		case bool:
+			if v {
+				buf = append(buf, 't')
+			} else {
+				buf = append(buf, 'f')
+			}
+		case float32:
+			buf = appendCopyFloat(buf, float64(v), 32)
+		case float64:
+			buf = appendCopyFloat(buf, v, 64)
+		default:
+			if n, ok := database.AsInt64(v); ok {
+				buf = strconv.AppendInt(buf, n, 10)
+			} else {
+				buf = append(buf, copyTextReplacer.Replace(fmt.Sprint(v))...)
+			}
+		}
+	}
+	return append(buf, '\n')
+}
+
+func appendCopyFloat(buf []byte, f float64, bitSize int) []byte {
+	switch {
+	case math.IsNaN(f):
+		return append(buf, "NaN"...)
+	case math.IsInf(f, 1):
+		return append(buf, "Infinity"...)
+	case math.IsInf(f, -1):
+		return append(buf, "-Infinity"...)
+	}
+	return strconv.AppendFloat(buf, f, 'g', -1, bitSize)
+}
+
+func init() {
+	database.Register("pgdump", NewDump)
+}
@@ -0,0 +1,97 @@
+package postgis
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/mapping/config"
+)
+
+func TestAppendCopyTextRow(t *testing.T) {
+	spec, err := testTableSpec(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := appendCopyTextRow(nil, spec.Columns, []interface{}{int64(42), "0101000020110F0000", "Main\tStreet\\\n"})
+	if string(row) != "42\t0101000020110F0000\tMain\\tStreet\\\\\\n\n" {
+		t.Errorf("unexpected row %q", row)
+	}
+	row = appendCopyTextRow(nil, spec.Columns, []interface{}{int64(-1), "", nil})
+	if string(row) != "-1\t\\N\t\\N\n" {
+		t.Errorf("unexpected row %q", row)
+	}
+	row = appendCopyTextRow(row[:0], []ColumnSpec{{}, {}, {}}, []interface{}{true, float32(1.5), int8(-1)})
+	if string(row) != "t\t1.5\t-1\n" {
+		t.Errorf("unexpected row %q", row)
+	}
+}
+
+func TestDumpSQL(t *testing.T) {
+	m := &config.Mapping{
+		Tables: config.Tables{
+			"roads": {
+				Name: "roads",
+				Type: "linestring",
+				Columns: []*config.Column{
+					{Name: "osm_id", Type: "id"},
+					{Name: "geometry", Type: "geometry"},
+					{Name: "tags", Type: "hstore_tags"},
+				},
+			},
+		},
+		GeneralizedTables: config.GeneralizedTables{
+			"roads_gen0": {
+				Name:            "roads_gen0",
+				SourceTableName: "roads",
+				Tolerance:       50,
+			},
+		},
+	}
+	db, err := NewDump(database.Config{
+		ConnectionParams: "pgdump:/tmp/import.sql prefix=hh",
+		ImportSchema:     "import",
+		Srid:             3857,
+	}, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := db.(*Dump)
+	if d.Path != "/tmp/import.sql" {
+		t.Errorf("unexpected path %q", d.Path)
+	}
+
+	pre := strings.Join(d.preDataSQL(), "\n")
+	for _, part := range []string{
+		`CREATE EXTENSION IF NOT EXISTS hstore`,
+		`CREATE SCHEMA IF NOT EXISTS "import"`,
+		`DROP TABLE IF EXISTS "import"."hh_roads" CASCADE`,
+		`CREATE TABLE IF NOT EXISTS "import"."hh_roads"`,
+		`SELECT AddGeometryColumn('import', 'hh_roads', 'geometry', '3857', 'LINESTRING', 2);`,
+	} {
+		if !strings.Contains(pre, part) {
+			t.Errorf("missing %q in\n%s", part, pre)
+		}
+	}
+	post := strings.Join(d.postDataSQL(), "\n")
+	for _, part := range []string{
+		`CREATE TABLE "import"."hh_roads_gen0" AS (SELECT`,
+		`CREATE INDEX IF NOT EXISTS "hh_roads_geom" ON "import"."hh_roads" USING GIST ("geometry")`,
+		`CREATE INDEX IF NOT EXISTS "hh_roads_gen0_geom" ON "import"."hh_roads_gen0" USING GIST ("geometry")`,
+		`ANALYZE "import"."hh_roads"`,
+	} {
+		if !strings.Contains(post, part) {
+			t.Errorf("missing %q in\n%s", part, post)
+		}
+	}
+	if strings.Index(post, `"hh_roads_gen0" AS`) > strings.Index(post, `"hh_roads_geom"`) {
+		t.Error("generalized tables should be created before indices")
+	}
+
+	if _, err := NewDump(database.Config{ConnectionParams: "pgdump:"}, m); err == nil {
+		t.Error("expected error for missing path")
+	}
+	if _, err := NewDump(database.Config{ConnectionParams: "pgdump:- sslmode=disable"}, m); err == nil {
+		t.Error("expected error for unsupported parameter")
+	}
+}
@@ -0,0 +1,92 @@
+package postgis
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/pkg/errors"
+)
+
+// findRowsSQL returns the query of the rows with the OSM id $1 as JSON
+// objects. Geometry columns are returned as their geometry type.
+func findRowsSQL(schema, table string, spec *TableSpec) (string, bool) {
+	idCol := ""
+	var cols []string
+	for _, col := range spec.Columns {
+		if strings.HasPrefix(col.Type.Name(), "GEOMETRY") {
+			cols = append(cols, fmt.Sprintf(`GeometryType("%[1]s"::geometry) AS "%[1]s"`, col.Name))
+			continue
+		}
+		if col.FieldType.Name == "id" && idCol == "" {
+			idCol = col.Name
+		}
+		cols = append(cols, fmt.Sprintf(`"%s"`, col.Name))
+	}
+	if idCol == "" {
+		return "", false
+	}
+	return fmt.Sprintf(`SELECT row_to_json(r)::text FROM (SELECT %s FROM "%s"."%s" WHERE "%s" = $1) AS r`,
+		strings.Join(cols, ", "), schema, table, idCol), true
+}
+
+// FindRows returns the rows with the OSM id of the tables and generalized
+// tables in names, in the production or import schema.
+func (pg *PostGIS) FindRows(names []string, id int64, production bool) ([]database.TableRows, error) {
+	tx, err := pg.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// only reads
+	defer tx.Rollback()
+
+	var result []database.TableRows
+	for _, name := range names {
+		var schema, table string
+		var spec *TableSpec
+		if t, ok := pg.Tables[name]; ok {
+			schema, table, spec = t.Schema, t.FullName, t
+			if production {
+				schema = t.ProductionSchema
+			}
+		} else if t, ok := pg.GeneralizedTables[name]; ok {
+			schema, table, spec = t.Schema, t.FullName, t.Source
+			if production {
+				schema = t.ProductionSchema
+			}
+		} else {
+			continue
+		}
+		sql, ok := findRowsSQL(schema, table, spec)
+		if !ok {
+			continue
+		}
+		exists, err := tableExists(tx, schema, table)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+
+		r := database.TableRows{Table: schema + "." + table}
+		rows, err := tx.Query(sql, id)
+		if err != nil {
+			return nil, &SQLError{sql, err}
+		}
+		for rows.Next() {
+			var row string
+			if err := rows.Scan(&row); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			r.Rows = append(r.Rows, row)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, errors.Wrapf(err, "querying %s", r.Table)
+		}
+		result = append(result, r)
+	}
+	return result, nil
+}
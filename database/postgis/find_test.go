@@ -0,0 +1,44 @@
+package postgis
+
+import (
+	"testing"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/mapping/config"
+)
+
+func TestFindRowsSQL(t *testing.T) {
+	pg := &PostGIS{
+		Prefix: "osm_",
+		Config: database.Config{ImportSchema: "import", Srid: 3857},
+	}
+	spec, err := NewTableSpec(pg, &config.Table{
+		Name: "admin",
+		Type: "relation",
+		Columns: []*config.Column{
+			{Name: "osm_id", Type: "id"},
+			{Name: "name", Type: "string", Key: "name"},
+			{Name: "geometry", Type: "geometry"},
+			{Name: "capital", Type: "member_point", Args: map[string]interface{}{"role": "admin_centre"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, ok := findRowsSQL("public", "osm_admin", spec)
+	if !ok || sql != `SELECT row_to_json(r)::text FROM (SELECT "osm_id", "name", GeometryType("geometry"::geometry) AS "geometry", GeometryType("capital"::geometry) AS "capital" FROM "public"."osm_admin" WHERE "osm_id" = $1) AS r` {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+
+	spec, err = NewTableSpec(pg, &config.Table{
+		Name:    "noid",
+		Type:    "point",
+		Columns: []*config.Column{{Name: "geometry", Type: "geometry"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := findRowsSQL("public", "osm_noid", spec); ok {
+		t.Error("expected no SQL for table without id")
+	}
+}
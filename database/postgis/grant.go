@@ -0,0 +1,110 @@
+package postgis
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/omniscale/imposm3/log"
+	"github.com/pkg/errors"
+)
+
+var tablePrivileges = map[string]bool{
+	"SELECT":     true,
+	"INSERT":     true,
+	"UPDATE":     true,
+	"DELETE":     true,
+	"TRUNCATE":   true,
+	"REFERENCES": true,
+	"TRIGGER":    true,
+	"ALL":        true,
+}
+
+// checkGrants checks that all privileges are valid table privileges.
+func checkGrants(grants map[string][]string) error {
+	for role, privileges := range grants {
+		if len(privileges) == 0 {
+			return errors.Errorf("missing privileges for role %q", role)
+		}
+		for _, p := range privileges {
+			if !tablePrivileges[strings.ToUpper(p)] {
+				return errors.Errorf("unknown privilege %q for role %q", p, role)
+			}
+		}
+	}
+	return nil
+}
+
+func roleSQL(role string) string {
+	if strings.ToUpper(role) == "PUBLIC" {
+		return "PUBLIC"
+	}
+	return `"` + role + `"`
+}
+
+// grantSQL returns the statements to grant the privileges on a table and
+// the usage of its schema to role.
+func grantSQL(schema, table, role string, privileges []string) []string {
+	return []string{
+		fmt.Sprintf(`GRANT USAGE ON SCHEMA "%s" TO %s`, schema, roleSQL(role)),
+		fmt.Sprintf(`GRANT %s ON TABLE "%s"."%s" TO %s`,
+			strings.ToUpper(strings.Join(privileges, ", ")), schema, table, roleSQL(role)),
+	}
+}
+
+// grant applies all grants to the import tables, or to the production
+// tables (and views) after a deployment.
+func (pg *PostGIS) grant(production bool) error {
+	if len(pg.Config.Grants) == 0 {
+		return nil
+	}
+	defer log.Step("Granting privileges")()
+
+	tx, err := pg.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollbackIfTx(&tx)
+
+	for _, t := range pg.deployTables() {
+		schema := t.Import
+		if production {
+			schema = t.Production
+		}
+		exists, err := tableExists(tx, schema, t.Name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if err := pg.grantTable(tx, schema, t.Name); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	tx = nil // set nil to prevent rollback
+	return nil
+}
+
+// grantTable applies all grants to a single table or view.
+func (pg *PostGIS) grantTable(tx *sql.Tx, schema, table string) error {
+	roles := make([]string, 0, len(pg.Config.Grants))
+	for role := range pg.Config.Grants {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	for _, role := range roles {
+		for _, sql := range grantSQL(schema, table, role, pg.Config.Grants[role]) {
+			if _, err := tx.Exec(sql); err != nil {
+				return &SQLError{sql, err}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package postgis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckGrants(t *testing.T) {
+	if err := checkGrants(map[string][]string{"tiles": {"select"}, "editor": {"SELECT", "UPDATE"}}); err != nil {
+		t.Error(err)
+	}
+	if err := checkGrants(map[string][]string{"tiles": {"SELECT; DROP TABLE osm_roads"}}); err == nil {
+		t.Error("expected error for invalid privilege")
+	}
+	if err := checkGrants(map[string][]string{"tiles": {}}); err == nil {
+		t.Error("expected error for missing privileges")
+	}
+}
+
+func TestGrantSQL(t *testing.T) {
+	stmts := grantSQL("public", "osm_roads", "tiles", []string{"select", "update"})
+	expected := []string{
+		`GRANT USAGE ON SCHEMA "public" TO "tiles"`,
+		`GRANT SELECT, UPDATE ON TABLE "public"."osm_roads" TO "tiles"`,
+	}
+	if !reflect.DeepEqual(stmts, expected) {
+		t.Errorf("unexpected SQL %v", stmts)
+	}
+	stmts = grantSQL("public", "osm_roads", "public", []string{"SELECT"})
+	if stmts[1] != `GRANT SELECT ON TABLE "public"."osm_roads" TO PUBLIC` {
+		t.Errorf("unexpected SQL %v", stmts)
+	}
+}
@@ -0,0 +1,511 @@
+package postgis
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/lib/pq"
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/geom/ewkb"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/mapping/config"
+	"github.com/omniscale/imposm3/proj"
+	"github.com/pkg/errors"
+)
+
+// LandPolygonSpec is a table with the land polygons that are built from the
+// coastlines of the source table. The polygons are split into the tiles of
+// Grid, so that updates only rebuild the tiles of the changed coastlines.
+// Coastlines that can't be closed are stored in a second table (ErrorsName)
+// and the water polygons (the tiles without the land) in a third table
+// (WaterName).
+type LandPolygonSpec struct {
+	Name             string
+	FullName         string
+	ErrorsName       string
+	WaterName        string
+	Schema           string
+	ProductionSchema string
+	BackupSchema     string
+	SourceName       string
+	Source           *TableSpec
+	Srid             int
+	Grid             geom.TileGrid
+	Tablespace       string
+	IndexTablespace  string
+	Unlogged         bool
+	Description      string
+}
+
+func NewLandPolygonSpec(pg *PostGIS, t *config.LandPolygonTable) (*LandPolygonSpec, error) {
+	spec := LandPolygonSpec{
+		Name:            t.Name,
+		FullName:        pg.Prefix + t.Name,
+		ErrorsName:      pg.Prefix + t.Name + "_errors",
+		WaterName:       pg.Prefix + t.Name + "_water",
+		SourceName:      t.SourceTableName,
+		Srid:            pg.Config.Srid,
+		Tablespace:      pg.Tablespace,
+		IndexTablespace: pg.IndexTablespace,
+		Unlogged:        pg.Config.Unlogged,
+		Description:     t.Description,
+	}
+	spec.Schema, spec.ProductionSchema, spec.BackupSchema = pg.tableSchemas(t.Schemas)
+	if t.Tablespace != "" {
+		spec.Tablespace = t.Tablespace
+	}
+	if t.IndexTablespace != "" {
+		spec.IndexTablespace = t.IndexTablespace
+	}
+
+	spec.Grid.Size = t.TileSize
+	if spec.Grid.Size == 0 {
+		spec.Grid.Size = 100000
+		if spec.Srid == 4326 {
+			spec.Grid.Size = 1
+		}
+	}
+	if spec.Grid.Size < 0 {
+		return nil, errors.Errorf("invalid tile_size %v", t.TileSize)
+	}
+	var ok bool
+	if t.BBox == nil {
+		spec.Grid.Extent, ok = proj.Extent(spec.Srid)
+	} else {
+		if len(t.BBox) != 4 || t.BBox[0] >= t.BBox[2] || t.BBox[1] >= t.BBox[3] {
+			return nil, errors.Errorf("invalid bbox %v, expected minx, miny, maxx, maxy", t.BBox)
+		}
+		spec.Grid.Extent, ok = proj.Bounds(spec.Srid, [4]float64{t.BBox[0], t.BBox[1], t.BBox[2], t.BBox[3]})
+	}
+	if !ok {
+		return nil, errors.Errorf("unsupported SRID %d", spec.Srid)
+	}
+	return &spec, nil
+}
+
+// tables returns the land polygon, errors and water table.
+func (spec *LandPolygonSpec) tables() []string {
+	return []string{spec.FullName, spec.ErrorsName, spec.WaterName}
+}
+
+// CreateTableSQL returns the statements to create the land polygon, the
+// errors and the water table.
+func (spec *LandPolygonSpec) CreateTableSQL() []string {
+	create := func(table, columns, geometryType string) string {
+		return fmt.Sprintf(`CREATE%s TABLE "%s"."%s" (id SERIAL PRIMARY KEY, tile_x INT NOT NULL, tile_y INT NOT NULL, %sgeometry GEOMETRY(%s, %d))%s`,
+			unloggedSQL(spec.Unlogged), spec.Schema, table, columns, geometryType, spec.Srid, tablespaceSQL(spec.Tablespace))
+	}
+	stmts := []string{
+		create(spec.FullName, "osm_ids BIGINT[] NOT NULL, ", "Polygon"),
+		create(spec.ErrorsName, "osm_ids BIGINT[] NOT NULL, ", "LineString"),
+		create(spec.WaterName, "", "Polygon"),
+	}
+	if spec.Description != "" {
+		stmts = append(stmts, fmt.Sprintf(`COMMENT ON TABLE "%s"."%s" IS %s`,
+			spec.Schema, spec.FullName, quoteLiteral(spec.Description)))
+	}
+	return stmts
+}
+
+// IndexSQL returns the statements to create the geometry, tile and OSM ids
+// indices of the tables.
+func (spec *LandPolygonSpec) IndexSQL() []string {
+	var stmts []string
+	for _, table := range spec.tables() {
+		stmts = append(stmts,
+			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s_geom" ON "%s"."%s" USING GIST (geometry)%s`,
+				table, spec.Schema, table, tablespaceSQL(spec.IndexTablespace)),
+			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s_tile_idx" ON "%s"."%s" USING BTREE (tile_x, tile_y)%s`,
+				table, spec.Schema, table, tablespaceSQL(spec.IndexTablespace)),
+		)
+		if table != spec.WaterName {
+			stmts = append(stmts,
+				fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s_osm_ids_idx" ON "%s"."%s" USING GIN (osm_ids)%s`,
+					table, spec.Schema, table, tablespaceSQL(spec.IndexTablespace)),
+			)
+		}
+	}
+	return stmts
+}
+
+// sourceColumns returns the OSM id and geometry column of the source.
+func (spec *LandPolygonSpec) sourceColumns() (id, geometry string) {
+	id = "id"
+	for _, col := range spec.Source.Columns {
+		if col.Type.Name() == "GEOMETRY" {
+			geometry = col.Name
+		} else if col.FieldType.Name == "id" {
+			id = col.Name
+		}
+	}
+	return id, geometry
+}
+
+// SelectSQL returns the query of the coastlines of the source table, of
+// the coastlines with the ids in $1 if ids is true.
+func (spec *LandPolygonSpec) SelectSQL(ids bool) string {
+	idCol, geomCol := spec.sourceColumns()
+	var where string
+	if ids {
+		where = fmt.Sprintf(` WHERE "%s" = ANY($1::bigint[])`, idCol)
+	}
+	return fmt.Sprintf(`SELECT "%s", "%s" FROM "%s"."%s"%s`,
+		idCol, geomCol, spec.Source.Schema, spec.Source.FullName, where)
+}
+
+// BoxSelectSQL returns the query of the coastlines of the source table
+// that intersect the box $1, $2, $3, $4.
+func (spec *LandPolygonSpec) BoxSelectSQL() string {
+	idCol, geomCol := spec.sourceColumns()
+	return fmt.Sprintf(`SELECT "%[1]s", "%[2]s" FROM "%[3]s"."%[4]s" WHERE "%[2]s" && ST_MakeEnvelope($1, $2, $3, $4, %[5]d)`,
+		idCol, geomCol, spec.Source.Schema, spec.Source.FullName, spec.Srid)
+}
+
+// TilesSQL returns the query of the tiles of table with one of the
+// coastlines in $1.
+func (spec *LandPolygonSpec) TilesSQL(table string) string {
+	return fmt.Sprintf(`SELECT DISTINCT tile_x, tile_y FROM "%s"."%s" WHERE osm_ids && $1::bigint[]`,
+		spec.Schema, table)
+}
+
+// LandSQL returns the query whether the tile $1, $2 contains land at the
+// point $3, $4.
+func (spec *LandPolygonSpec) LandSQL() string {
+	return fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM "%s"."%s" WHERE tile_x = $1 AND tile_y = $2 AND ST_Intersects(geometry, ST_SetSRID(ST_MakePoint($3, $4), %d)))`,
+		spec.Schema, spec.FullName, spec.Srid)
+}
+
+// DeleteSQL returns the statement that removes all rows of table in the
+// tiles with the x in $1 and the y in $2.
+func (spec *LandPolygonSpec) DeleteSQL(table string) string {
+	return fmt.Sprintf(`DELETE FROM "%s"."%s" WHERE (tile_x, tile_y) IN (SELECT * FROM unnest($1::int[], $2::int[]))`,
+		spec.Schema, table)
+}
+
+// InsertSQL returns the statement to insert a row into the land polygon or
+// errors table.
+func (spec *LandPolygonSpec) InsertSQL(table string) string {
+	return fmt.Sprintf(`INSERT INTO "%s"."%s" (tile_x, tile_y, osm_ids, geometry) VALUES ($1, $2, $3::bigint[], $4::geometry)`,
+		spec.Schema, table)
+}
+
+// WaterSQL returns the statement that inserts the water polygons of the
+// tiles with the x in $1 and the y in $2: the box of each tile without
+// the land polygons of the tile.
+func (spec *LandPolygonSpec) WaterSQL() string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	size, ext := f(spec.Grid.Size), spec.Grid.Extent
+	box := fmt.Sprintf(`ST_MakeEnvelope(GREATEST(x * %[1]s, %[2]s), GREATEST(y * %[1]s, %[3]s), LEAST((x + 1) * %[1]s, %[4]s), LEAST((y + 1) * %[1]s, %[5]s), %[6]d)`,
+		size, f(ext[0]), f(ext[1]), f(ext[2]), f(ext[3]), spec.Srid)
+	return fmt.Sprintf(`INSERT INTO "%[1]s"."%[2]s" (tile_x, tile_y, geometry) SELECT x, y, geom FROM (`+
+		`SELECT x, y, (ST_Dump(ST_Difference(%[3]s, COALESCE((SELECT ST_Union(geometry) FROM "%[1]s"."%[4]s" WHERE tile_x = x AND tile_y = y), ST_SetSRID('GEOMETRYCOLLECTION EMPTY'::geometry, %[5]d))))).geom `+
+		`FROM unnest($1::int[], $2::int[]) AS t(x, y)) AS water WHERE GeometryType(geom) = 'POLYGON'`,
+		spec.Schema, spec.WaterName, box, spec.FullName, spec.Srid)
+}
+
+// buildLandPolygons creates and fills the tables of spec from all
+// coastlines of the source table.
+func (pg *PostGIS) buildLandPolygons(spec *LandPolygonSpec) error {
+	defer log.Step(fmt.Sprintf("Building land polygons %s from %s",
+		spec.FullName, spec.Source.FullName))()
+
+	tx, err := pg.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollbackIfTx(&tx)
+
+	for _, table := range spec.tables() {
+		if err := dropTableIfExists(tx, spec.Schema, table); err != nil {
+			return errors.Wrap(err, "dropping existing table")
+		}
+	}
+	for _, sql := range spec.CreateTableSQL() {
+		if _, err := tx.Exec(sql); err != nil {
+			return &SQLError{sql, err}
+		}
+	}
+	ways, err := queryCoastlines(tx, spec.SelectSQL(false))
+	if err != nil {
+		return err
+	}
+	tileWays := make(map[geom.Tile][]geom.CoastlineWay)
+	for _, w := range ways {
+		for _, t := range spec.Grid.TilesOf(w.Coords) {
+			tileWays[t] = append(tileWays[t], w)
+		}
+	}
+	tiles := make(map[geom.Tile]*geom.BoxLand)
+	lo, hi := spec.Grid.Range()
+	for x := lo.X; x <= hi.X; x++ {
+		for y := lo.Y; y <= hi.Y; y++ {
+			t := geom.Tile{X: x, Y: y}
+			bl := geom.LandInBox(tileWays[t], spec.Grid.Box(t), spec.Grid.Extent)
+			tiles[t] = &bl
+		}
+	}
+	geom.FillTiles(tiles)
+	if err := insertLandPolygons(tx, spec, tiles); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrapf(err, "commiting tx for land polygons %q", spec.FullName)
+	}
+	tx = nil // set nil to prevent rollback
+	return nil
+}
+
+// updateLandPolygons rebuilds the tiles of the changed coastlines: the
+// tiles that contained the coastlines before and the tiles of their new
+// geometries. Tiles without coastlines take the land of their neighbours.
+// Neighbours without coastlines are rebuilt as well if their land changes,
+// e.g. if a coastline was reversed.
+func (pg *PostGIS) updateLandPolygons(tx *sql.Tx, spec *LandPolygonSpec, ids []int64) error {
+	touched := make(map[geom.Tile]bool)
+	for _, table := range []string{spec.FullName, spec.ErrorsName} {
+		sql := spec.TilesSQL(table)
+		rows, err := tx.Query(sql, pq.Array(ids))
+		if err != nil {
+			return &SQLError{sql, err}
+		}
+		for rows.Next() {
+			var t geom.Tile
+			if err := rows.Scan(&t.X, &t.Y); err != nil {
+				rows.Close()
+				return err
+			}
+			touched[t] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return errors.Wrapf(err, "querying tiles of %s", table)
+		}
+	}
+	ways, err := queryCoastlines(tx, spec.SelectSQL(true), pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	for _, w := range ways {
+		for _, t := range spec.Grid.TilesOf(w.Coords) {
+			touched[t] = true
+		}
+	}
+
+	tiles := make(map[geom.Tile]*geom.BoxLand)
+	for t := range touched {
+		bl, _, err := tileLand(tx, spec, t)
+		if err != nil {
+			return err
+		}
+		tiles[t] = bl
+	}
+	// tiles without coastlines take the previous land of their unchanged
+	// neighbours, or of the changed tiles with FillTiles
+	for t, bl := range tiles {
+		if !bl.Unknown {
+			continue
+		}
+		for _, n := range geom.Neighbours(t) {
+			if _, ok := tiles[n.Tile]; ok || !spec.Grid.Contains(n.Tile) {
+				continue
+			}
+			p := sideCenter(spec.Grid.Box(t), n.Side)
+			land, err := previousLand(tx, spec, n.Tile, p)
+			if err != nil {
+				return err
+			}
+			bl.SetBackground(land)
+			break
+		}
+	}
+	geom.FillTiles(tiles)
+
+	// rebuild neighbours without coastlines if their land changed
+	var queue []geom.Tile
+	for t := range tiles {
+		queue = append(queue, t)
+	}
+	for len(queue) > 0 {
+		t := queue[0]
+		queue = queue[1:]
+		for _, n := range geom.Neighbours(t) {
+			if _, ok := tiles[n.Tile]; ok || !spec.Grid.Contains(n.Tile) {
+				continue
+			}
+			land := tiles[t].SideLand(n.Side)
+			prev, err := previousLand(tx, spec, n.Tile, sideCenter(spec.Grid.Box(t), n.Side))
+			if err != nil {
+				return err
+			}
+			if prev == land {
+				continue
+			}
+			bl, coastlines, err := tileLand(tx, spec, n.Tile)
+			if err != nil {
+				return err
+			}
+			if coastlines > 0 {
+				continue
+			}
+			bl.SetBackground(land)
+			tiles[n.Tile] = bl
+			queue = append(queue, n.Tile)
+		}
+	}
+
+	log.Printf("[info] rebuilding %d tiles of land polygons %s for %d changed coastlines",
+		len(tiles), spec.FullName, len(ids))
+	xs, ys := tileArrays(tiles)
+	for _, table := range spec.tables() {
+		sql := spec.DeleteSQL(table)
+		if _, err := tx.Exec(sql, pq.Array(xs), pq.Array(ys)); err != nil {
+			return &SQLError{sql, err}
+		}
+	}
+	return insertLandPolygons(tx, spec, tiles)
+}
+
+// tileLand builds the land of the tile from the coastlines of the source
+// table. Returns the number of coastlines of the tile.
+func tileLand(tx *sql.Tx, spec *LandPolygonSpec, t geom.Tile) (*geom.BoxLand, int, error) {
+	box := spec.Grid.Box(t)
+	ways, err := queryCoastlines(tx, spec.BoxSelectSQL(), box[0], box[1], box[2], box[3])
+	if err != nil {
+		return nil, 0, err
+	}
+	bl := geom.LandInBox(ways, box, spec.Grid.Extent)
+	return &bl, len(ways), nil
+}
+
+// previousLand returns whether the land polygons of the tile contain p.
+func previousLand(tx *sql.Tx, spec *LandPolygonSpec, t geom.Tile, p ewkb.Coord) (bool, error) {
+	var land bool
+	sql := spec.LandSQL()
+	if err := tx.QueryRow(sql, t.X, t.Y, p.X, p.Y).Scan(&land); err != nil {
+		return false, &SQLError{sql, err}
+	}
+	return land, nil
+}
+
+// sideCenter returns the center of the side of the box.
+func sideCenter(box [4]float64, side int) ewkb.Coord {
+	cx, cy := (box[0]+box[2])/2, (box[1]+box[3])/2
+	switch side {
+	case geom.SideBottom:
+		return ewkb.Coord{X: cx, Y: box[1]}
+	case geom.SideRight:
+		return ewkb.Coord{X: box[2], Y: cy}
+	case geom.SideTop:
+		return ewkb.Coord{X: cx, Y: box[3]}
+	default:
+		return ewkb.Coord{X: box[0], Y: cy}
+	}
+}
+
+// tileArrays returns the x and y of the tiles, sorted by x and y.
+func tileArrays(tiles map[geom.Tile]*geom.BoxLand) ([]int64, []int64) {
+	sorted := make([]geom.Tile, 0, len(tiles))
+	for t := range tiles {
+		sorted = append(sorted, t)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].X != sorted[j].X {
+			return sorted[i].X < sorted[j].X
+		}
+		return sorted[i].Y < sorted[j].Y
+	})
+	xs := make([]int64, len(sorted))
+	ys := make([]int64, len(sorted))
+	for i, t := range sorted {
+		xs[i], ys[i] = int64(t.X), int64(t.Y)
+	}
+	return xs, ys
+}
+
+// queryCoastlines returns the coastlines of the query, with the OSM id and
+// the hex encoded EWKB geometry.
+func queryCoastlines(tx *sql.Tx, query string, args ...interface{}) ([]geom.CoastlineWay, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, &SQLError{query, err}
+	}
+	defer rows.Close()
+
+	var ways []geom.CoastlineWay
+	for rows.Next() {
+		var id int64
+		var wkb string
+		if err := rows.Scan(&id, &wkb); err != nil {
+			return nil, err
+		}
+		g, err := ewkb.DecodeHex([]byte(wkb))
+		if err != nil {
+			return nil, errors.Wrapf(err, "decoding coastline %d", id)
+		}
+		if g.Type != ewkb.LineString {
+			continue
+		}
+		ways = append(ways, geom.CoastlineWay{ID: id, Coords: g.Coords})
+	}
+	return ways, rows.Err()
+}
+
+// insertLandPolygons inserts the land polygons and the errors of the tiles,
+// and the water polygons of the tiles.
+func insertLandPolygons(tx *sql.Tx, spec *LandPolygonSpec, tiles map[geom.Tile]*geom.BoxLand) error {
+	insert := func(table string, t geom.Tile, ids []int64, g ewkb.Geometry) error {
+		if ids == nil {
+			ids = []int64{} // boxes of tiles without coastlines
+		}
+		g.SRID = spec.Srid
+		sql := spec.InsertSQL(table)
+		if _, err := tx.Exec(sql, t.X, t.Y, pq.Array(ids), hex.EncodeToString(g.EWKB())); err != nil {
+			return &SQLError{sql, err}
+		}
+		return nil
+	}
+	errs := 0
+	for t, bl := range tiles {
+		for _, p := range bl.Polygons {
+			if err := insert(spec.FullName, t, p.IDs, ewkb.Geometry{Type: ewkb.Polygon, Rings: p.Rings}); err != nil {
+				return err
+			}
+		}
+		for _, r := range bl.Errors {
+			errs++
+			if err := insert(spec.ErrorsName, t, r.IDs, ewkb.Geometry{Type: ewkb.LineString, Coords: r.Coords}); err != nil {
+				return err
+			}
+		}
+	}
+	if errs > 0 {
+		log.Printf("[warn]: %d coastlines of %s are not closed, see %s", errs, spec.Source.FullName, spec.ErrorsName)
+	}
+
+	xs, ys := tileArrays(tiles)
+	sql := spec.WaterSQL()
+	if _, err := tx.Exec(sql, pq.Array(xs), pq.Array(ys)); err != nil {
+		return &SQLError{sql, err}
+	}
+	return nil
+}
+
+// recordCoastlines records the id for all land polygons with a source in
+// matches.
+func (pg *PostGIS) recordCoastlines(id int64, matches []mapping.Match) {
+	for _, spec := range pg.LandPolygons {
+		for _, m := range matches {
+			if m.Table.Name == spec.SourceName {
+				pg.updateIDsMu.Lock()
+				pg.coastlineIDs[spec.Name] = append(pg.coastlineIDs[spec.Name], id)
+				pg.updateIDsMu.Unlock()
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package postgis
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/mapping/config"
+)
+
+func TestLandPolygonSpec(t *testing.T) {
+	pg := &PostGIS{
+		Prefix: "osm_",
+		Config: database.Config{ImportSchema: "import", Srid: 3857},
+	}
+	source, err := NewTableSpec(pg, &config.Table{
+		Name: "coastlines",
+		Type: "linestring",
+		Columns: []*config.Column{
+			{Name: "osm_id", Type: "id"},
+			{Name: "geometry", Type: "geometry"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := NewLandPolygonSpec(pg, &config.LandPolygonTable{Name: "land", SourceTableName: "coastlines"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec.Source = source
+
+	if spec.FullName != "osm_land" || spec.ErrorsName != "osm_land_errors" || spec.WaterName != "osm_land_water" {
+		t.Errorf("unexpected names %s %s %s", spec.FullName, spec.ErrorsName, spec.WaterName)
+	}
+	if spec.Grid.Size != 100000 || spec.Grid.Extent[2] != 20037508.342789244 {
+		t.Errorf("unexpected grid %v", spec.Grid)
+	}
+	stmts := spec.CreateTableSQL()
+	if len(stmts) != 3 ||
+		!strings.HasPrefix(stmts[0], `CREATE TABLE "import"."osm_land" (id SERIAL PRIMARY KEY, tile_x INT NOT NULL, tile_y INT NOT NULL, osm_ids`) || !strings.Contains(stmts[0], "GEOMETRY(Polygon, 3857)") ||
+		!strings.HasPrefix(stmts[1], `CREATE TABLE "import"."osm_land_errors" (`) || !strings.Contains(stmts[1], "GEOMETRY(LineString, 3857)") ||
+		!strings.HasPrefix(stmts[2], `CREATE TABLE "import"."osm_land_water" (`) || strings.Contains(stmts[2], "osm_ids") {
+		t.Errorf("unexpected SQL %v", stmts)
+	}
+	if sql := spec.SelectSQL(true); sql != `SELECT "osm_id", "geometry" FROM "import"."osm_coastlines" WHERE "osm_id" = ANY($1::bigint[])` {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+	if sql := spec.BoxSelectSQL(); sql != `SELECT "osm_id", "geometry" FROM "import"."osm_coastlines" WHERE "geometry" && ST_MakeEnvelope($1, $2, $3, $4, 3857)` {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+	if sql := spec.TilesSQL(spec.ErrorsName); sql != `SELECT DISTINCT tile_x, tile_y FROM "import"."osm_land_errors" WHERE osm_ids && $1::bigint[]` {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+	if sql := spec.DeleteSQL(spec.WaterName); sql != `DELETE FROM "import"."osm_land_water" WHERE (tile_x, tile_y) IN (SELECT * FROM unnest($1::int[], $2::int[]))` {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+	if sql := spec.WaterSQL(); !strings.HasPrefix(sql, `INSERT INTO "import"."osm_land_water" (tile_x, tile_y, geometry)`) ||
+		!strings.Contains(sql, `ST_MakeEnvelope(GREATEST(x * 100000, -20037508.342789244), GREATEST(y * 100000, -20037508.342789244), LEAST((x + 1) * 100000, 20037508.342789244)`) ||
+		!strings.Contains(sql, `(SELECT ST_Union(geometry) FROM "import"."osm_land" WHERE tile_x = x AND tile_y = y)`) {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+	if stmts := spec.IndexSQL(); len(stmts) != 8 || !strings.Contains(stmts[2], `"osm_land_osm_ids_idx" ON "import"."osm_land" USING GIN (osm_ids)`) ||
+		!strings.Contains(stmts[7], `"osm_land_water_tile_idx" ON "import"."osm_land_water" USING BTREE (tile_x, tile_y)`) {
+		t.Errorf("unexpected SQL %v", stmts)
+	}
+
+	spec, err = NewLandPolygonSpec(pg, &config.LandPolygonTable{Name: "land", TileSize: 50000, BBox: []float64{5, 50, 10, 55}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Grid.Size != 50000 || spec.Grid.Extent[0] < 556000 || spec.Grid.Extent[0] > 557000 {
+		t.Errorf("unexpected grid %v", spec.Grid)
+	}
+	for _, bbox := range [][]float64{{5, 50, 10}, {10, 50, 5, 55}} {
+		if _, err := NewLandPolygonSpec(pg, &config.LandPolygonTable{Name: "land", BBox: bbox}); err == nil {
+			t.Errorf("expected error for bbox %v", bbox)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package postgis
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/omniscale/imposm3/log"
+	"github.com/pkg/errors"
+)
+
+// formattedTypes maps our column types to the type names returned by
+// format_type.
+var formattedTypes = map[string]string{
+	"VARCHAR":         "character varying",
+	"BOOL":            "boolean",
+	"SMALLINT":        "smallint",
+	"INT":             "integer",
+	"BIGINT":          "bigint",
+	"REAL":            "real",
+	"HSTORE":          "hstore",
+	"GEOMETRY":        "geometry",
+	"GEOMETRY(POINT)": "geometry(Point)",
+}
+
+// columnTypeMatches returns whether the type of an existing column (as
+// returned by format_type) matches the column spec.
+func columnTypeMatches(col ColumnSpec, formatted string, geography bool) bool {
+	expected := formattedTypes[col.Type.Name()]
+	if expected == "geometry" {
+		if geography {
+			expected = "geography"
+		}
+		return formatted == expected || strings.HasPrefix(formatted, expected+"(")
+	}
+	return formatted == expected
+}
+
+// addColumnSQL returns the ALTER TABLE statement to add col.
+func addColumnSQL(schema, table string, col ColumnSpec, geometryType string, srid int, geography bool) string {
+	colType := col.Type.Name()
+	if colType == "GEOMETRY" {
+		if geography {
+			colType = fmt.Sprintf("geography(%s, 4326)", geometryType)
+		} else {
+			colType = fmt.Sprintf("geometry(%s, %d)", geometryType, srid)
+		}
+	}
+	return fmt.Sprintf(`ALTER TABLE "%s"."%s" ADD COLUMN "%s" %s`, schema, table, col.Name, colType)
+}
+
+// tableColumns returns the formatted types of all columns of a table.
+func tableColumns(tx *sql.Tx, schema, table string) (map[string]string, error) {
+	sql := fmt.Sprintf(`SELECT attname, format_type(atttypid, atttypmod) FROM pg_attribute WHERE attrelid = '"%s"."%s"'::regclass AND attnum > 0 AND NOT attisdropped`,
+		schema, table)
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, &SQLError{sql, err}
+	}
+	defer rows.Close()
+	cols := make(map[string]string)
+	for rows.Next() {
+		var name, colType string
+		if err := rows.Scan(&name, &colType); err != nil {
+			return nil, err
+		}
+		cols[name] = colType
+	}
+	return cols, rows.Err()
+}
+
+// migrateTable adds all missing columns and indices of a table. Returns an
+// error for missing tables and for columns with a different type, as these
+// tables need to be imported again.
+func (pg *PostGIS) migrateTable(tx *sql.Tx, schema, tableName string, columns []ColumnSpec, geometryType string, srid int, geography bool, indexes []IndexSpec, tablespace string) error {
+	exists, err := tableExists(tx, schema, tableName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.Errorf("table %q does not exist in schema %q, new tables require an import", tableName, schema)
+	}
+	existing, err := tableColumns(tx, schema, tableName)
+	if err != nil {
+		return err
+	}
+
+	for _, col := range columns {
+		colType, ok := existing[col.Name]
+		if ok {
+			if !columnTypeMatches(col, colType, geography) {
+				return errors.Errorf("column %q of %q is %s and not %s, changed columns require an import",
+					col.Name, tableName, colType, col.Type.Name())
+			}
+			continue
+		}
+		sql := addColumnSQL(schema, tableName, col, geometryType, srid, geography)
+		log.Printf("[info] Adding column %q to %q", col.Name, tableName)
+		if _, err := tx.Exec(sql); err != nil {
+			return &SQLError{sql, err}
+		}
+		for _, sql := range commentSQL(schema, tableName, "", []ColumnSpec{col}) {
+			if _, err := tx.Exec(sql); err != nil {
+				return &SQLError{sql, err}
+			}
+		}
+	}
+	for _, idx := range indexes {
+		sql := idx.CreateSQL(schema, tableName, tablespace)
+		if _, err := tx.Exec(sql); err != nil {
+			return &SQLError{sql, err}
+		}
+	}
+	return nil
+}
+
+// Migrate updates existing tables to the mapping before diff imports. New
+// columns and indices are added to the tables. Other changes (new tables or
+// columns with a different type) require an import.
+func (pg *PostGIS) Migrate() error {
+	defer log.Step("Checking tables for new columns")()
+
+	tx, err := pg.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollbackIfTx(&tx)
+
+	names := make([]string, 0, len(pg.Tables))
+	for name := range pg.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		spec := pg.Tables[name]
+		if err := pg.migrateTable(tx, spec.Schema, spec.FullName, spec.Columns, spec.geometryTypeSQL(), spec.Srid, spec.Geography, spec.Indexes, spec.IndexTablespace); err != nil {
+			return err
+		}
+	}
+
+	names = names[:0]
+	for name := range pg.GeneralizedTables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		spec := pg.GeneralizedTables[name]
+		if err := pg.migrateTable(tx, spec.Schema, spec.FullName, spec.Source.Columns, spec.Source.geometryTypeSQL(), spec.Source.Srid, false, spec.Indexes, spec.IndexTablespace); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	tx = nil
+	return nil
+}
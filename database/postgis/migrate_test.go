@@ -0,0 +1,45 @@
+package postgis
+
+import (
+	"testing"
+
+	"github.com/omniscale/imposm3/mapping/config"
+)
+
+func TestColumnTypeMatches(t *testing.T) {
+	spec, err := testTableSpec(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// osm_id, geometry, name
+	for _, tc := range []struct {
+		col       int
+		formatted string
+		match     bool
+	}{
+		{0, "bigint", true},
+		{0, "integer", false},
+		{1, "geometry(LineString,3857)", true},
+		{1, "geometry", true},
+		{1, "geography(Point,4326)", false},
+		{2, "character varying", true},
+		{2, "text", false},
+	} {
+		if m := columnTypeMatches(spec.Columns[tc.col], tc.formatted, false); m != tc.match {
+			t.Errorf("unexpected match %v for %s and %s", m, spec.Columns[tc.col].Name, tc.formatted)
+		}
+	}
+}
+
+func TestAddColumnSQL(t *testing.T) {
+	spec, err := testTableSpec(&config.TablePartition{Partitions: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql := addColumnSQL("public", spec.FullName, spec.Columns[2], spec.geometryTypeSQL(), spec.Srid, false); sql != `ALTER TABLE "public"."osm_roads" ADD COLUMN "name" VARCHAR` {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+	if sql := addColumnSQL("public", spec.FullName, spec.Columns[1], spec.geometryTypeSQL(), spec.Srid, false); sql != `ALTER TABLE "public"."osm_roads" ADD COLUMN "geometry" geometry(LINESTRING, 3857)` {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+}
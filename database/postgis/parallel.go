@@ -0,0 +1,150 @@
+package postgis
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+
+	"github.com/omniscale/imposm3/memory"
+	"github.com/pkg/errors"
+)
+
+// txWorker applies the inserts and deletes of some tables of a diff import
+// in its own connection and transaction. The operations of each table are
+// executed in order.
+type txWorker struct {
+	tx   *sql.Tx
+	ops  chan func() error
+	done chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func newTxWorker(pg *PostGIS) (*txWorker, error) {
+	tx, err := pg.Db.Begin()
+	err = pg.Retry.retry("begin postgis transaction", err, func() (err error) {
+		tx, err = pg.Db.Begin()
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "begin postgis transaction")
+	}
+	w := &txWorker{
+		tx:   tx,
+		ops:  make(chan func() error, memory.DatabaseBuffer()),
+		done: make(chan struct{}),
+	}
+	go w.loop()
+	return w, nil
+}
+
+func (w *txWorker) loop() {
+	defer close(w.done)
+	for op := range w.ops {
+		if w.firstErr() != nil {
+			// the transaction is lost anyway
+			continue
+		}
+		if err := op(); err != nil {
+			w.mu.Lock()
+			w.err = err
+			w.mu.Unlock()
+		}
+	}
+}
+
+func (w *txWorker) firstErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// do queues the operation. Returns the error of a previous operation.
+func (w *txWorker) do(op func() error) error {
+	if err := w.firstErr(); err != nil {
+		return err
+	}
+	w.ops <- op
+	return nil
+}
+
+// wait waits till all queued operations are executed. Returns the first
+// error of all operations.
+func (w *txWorker) wait() error {
+	if w.ops != nil {
+		close(w.ops)
+		w.ops = nil
+		<-w.done
+	}
+	return w.firstErr()
+}
+
+// asyncTableTx passes all inserts and deletes of a table to a txWorker.
+type asyncTableTx struct {
+	TableTx
+	worker *txWorker
+}
+
+func (tt *asyncTableTx) Insert(row []interface{}) error {
+	return tt.worker.do(func() error { return tt.TableTx.Insert(row) })
+}
+
+func (tt *asyncTableTx) Delete(id int64) error {
+	return tt.worker.do(func() error { return tt.TableTx.Delete(id) })
+}
+
+// diffWorkerTables returns the tables that are updated by each diff
+// worker. Tables are distributed round-robin by name. Source tables of
+// generalized tables are not included, as the generalized tables are
+// updated from these tables in the transaction of the TxRouter. The same
+// applies to the sources of land polygons.
+func diffWorkerTables(pg *PostGIS, workers int) [][]string {
+	sources := make(map[*TableSpec]bool)
+	for _, gen := range pg.GeneralizedTables {
+		if gen.Source != nil {
+			sources[gen.Source] = true
+		}
+	}
+	for _, spec := range pg.LandPolygons {
+		sources[spec.Source] = true
+	}
+	var names []string
+	for name, spec := range pg.Tables {
+		if !sources[spec] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	if workers > len(names) {
+		workers = len(names)
+	}
+	tables := make([][]string, workers)
+	for i, name := range names {
+		tables[i%workers] = append(tables[i%workers], name)
+	}
+	return tables
+}
+
+// startWorkers starts the workers of a parallel diff import. Returns the
+// worker of each table that is not updated in the transaction of the
+// TxRouter.
+func (txr *TxRouter) startWorkers(pg *PostGIS) (map[string]*txWorker, error) {
+	tables := diffWorkerTables(pg, pg.Config.DiffWorkers)
+	if pg.Pool.MaxConns > 0 && len(tables) >= pg.Pool.MaxConns {
+		return nil, errors.Errorf("%d diff workers need more than pool_max_conns=%d connections", len(tables), pg.Pool.MaxConns)
+	}
+	workerOf := make(map[string]*txWorker)
+	for _, names := range tables {
+		w, err := newTxWorker(pg)
+		if err != nil {
+			return nil, err
+		}
+		txr.workers = append(txr.workers, w)
+		for _, name := range names {
+			workerOf[name] = w
+		}
+	}
+	return workerOf, nil
+}
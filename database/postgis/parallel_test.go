@@ -0,0 +1,64 @@
+package postgis
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDiffWorkerTables(t *testing.T) {
+	roads := &TableSpec{Name: "roads"}
+	pg := &PostGIS{
+		Tables: map[string]*TableSpec{
+			"roads":     roads,
+			"buildings": {Name: "buildings"},
+			"landuse":   {Name: "landuse"},
+			"places":    {Name: "places"},
+		},
+		GeneralizedTables: map[string]*GeneralizedTableSpec{
+			"roads_gen0": {Name: "roads_gen0", Source: roads},
+		},
+	}
+	tables := diffWorkerTables(pg, 2)
+	expected := [][]string{{"buildings", "places"}, {"landuse"}}
+	if !reflect.DeepEqual(tables, expected) {
+		t.Errorf("unexpected tables %v, expected %v", tables, expected)
+	}
+
+	tables = diffWorkerTables(pg, 8)
+	if len(tables) != 3 {
+		t.Errorf("expected one worker for each table, got %v", tables)
+	}
+}
+
+func TestTxWorker(t *testing.T) {
+	w := &txWorker{
+		ops:  make(chan func() error, 1),
+		done: make(chan struct{}),
+	}
+	go w.loop()
+
+	var executed []int
+	for i := 0; i < 4; i++ {
+		i := i
+		err := w.do(func() error {
+			executed = append(executed, i)
+			if i == 1 {
+				return errors.New("failed")
+			}
+			return nil
+		})
+		if err != nil && i < 2 {
+			t.Fatal(err)
+		}
+	}
+	if err := w.wait(); err == nil || err.Error() != "failed" {
+		t.Errorf("expected error of second operation, got %v", err)
+	}
+	if !reflect.DeepEqual(executed, []int{0, 1}) {
+		t.Errorf("operations after the error should be skipped, executed %v", executed)
+	}
+	if err := w.do(func() error { return nil }); err == nil {
+		t.Error("expected error for operation after failed one")
+	}
+}
@@ -4,10 +4,13 @@ import (
 	"database/sql"
 	"fmt"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	_ "github.com/jackc/pgx/v4/stdlib"
 	pq "github.com/lib/pq"
 	osm "github.com/omniscale/go-osm"
 	"github.com/omniscale/imposm3/database"
@@ -45,38 +48,65 @@ func createTable(tx *sql.Tx, spec TableSpec) error {
 		return err
 	}
 
+	if err := execHook(tx, "before_create", spec.Hooks.BeforeCreate, spec.Schema, spec.FullName); err != nil {
+		return err
+	}
+
 	sql = spec.CreateTableSQL()
 	_, err = tx.Exec(sql)
 	if err != nil {
 		return &SQLError{sql, err}
 	}
 
-	err = addGeometryColumn(tx, spec.FullName, spec)
-	if err != nil {
-		return err
+	if spec.Partition != nil {
+		// geometry column is already part of CreateTableSQL
+		for _, sql := range spec.CreatePartitionsSQL() {
+			if _, err := tx.Exec(sql); err != nil {
+				return &SQLError{sql, err}
+			}
+		}
+	} else {
+		err = addGeometryColumn(tx, spec.FullName, spec)
+		if err != nil {
+			return err
+		}
 	}
-	return nil
+	for _, sql := range spec.CommentSQL() {
+		if _, err := tx.Exec(sql); err != nil {
+			return &SQLError{sql, err}
+		}
+	}
+	return execHook(tx, "after_create", spec.Hooks.AfterCreate, spec.Schema, spec.FullName)
 }
 
-func addGeometryColumn(tx *sql.Tx, tableName string, spec TableSpec) error {
-	colName := ""
+// addGeometryColumnSQL returns the statement to add the geometry column.
+// Returns an empty string for tables without geometry. Geography columns
+// are added with ALTER TABLE, as AddGeometryColumn only supports geometry.
+func addGeometryColumnSQL(tableName string, spec TableSpec) string {
 	for _, col := range spec.Columns {
 		if col.Type.Name() == "GEOMETRY" {
-			colName = col.Name
-			break
+			if spec.Geography {
+				return addColumnSQL(spec.Schema, tableName, col, spec.geometryTypeSQL(), spec.Srid, true) + ";"
+			}
+			geomType, dims := spec.geometryTypeDims()
+			return fmt.Sprintf("SELECT AddGeometryColumn('%s', '%s', '%s', '%d', '%s', %d);",
+				spec.Schema, tableName, col.Name, spec.Srid, geomType, dims)
 		}
 	}
+	return ""
+}
 
-	if colName == "" {
+func addGeometryColumn(tx *sql.Tx, tableName string, spec TableSpec) error {
+	sql := addGeometryColumnSQL(tableName, spec)
+	if sql == "" {
 		return nil
 	}
-
-	geomType := strings.ToUpper(spec.GeometryType)
-	if geomType == "POLYGON" {
-		geomType = "GEOMETRY" // for multipolygon support
+	if spec.Geography {
+		if _, err := tx.Exec(sql); err != nil {
+			return &SQLError{sql, err}
+		}
+		return nil
 	}
-	sql := fmt.Sprintf("SELECT AddGeometryColumn('%s', '%s', '%s', '%d', '%s', 2);",
-		spec.Schema, tableName, colName, spec.Srid, geomType)
 	row := tx.QueryRow(sql)
 	var void interface{}
 	err := row.Scan(&void)
@@ -97,9 +127,9 @@ func getPostgisVersion(tx *sql.Tx) (string, error) {
 	return version, nil
 }
 
-func populateGeometryColumn(tx *sql.Tx, tableName string, spec TableSpec) error {
+func populateGeometryColumn(tx *sql.Tx, schema, tableName string) error {
 	sql := fmt.Sprintf("SELECT Populate_Geometry_Columns('%s.%s'::regclass);",
-		spec.Schema, tableName)
+		schema, tableName)
 	row := tx.QueryRow(sql)
 	var void interface{}
 	err := row.Scan(&void)
@@ -139,8 +169,10 @@ func (pg *PostGIS) createSchema(schema string) error {
 
 // Init creates schema and tables, drops existing data.
 func (pg *PostGIS) Init() error {
-	if err := pg.createSchema(pg.Config.ImportSchema); err != nil {
-		return err
+	for _, schema := range pg.importSchemas() {
+		if err := pg.createSchema(schema); err != nil {
+			return err
+		}
 	}
 
 	tx, err := pg.Db.Begin()
@@ -152,94 +184,216 @@ func (pg *PostGIS) Init() error {
 		if err := createTable(tx, *spec); err != nil {
 			return err
 		}
+		if pg.Quarantine {
+			if err := dropTableIfExists(tx, spec.Schema, quarantineTable(spec)); err != nil {
+				return err
+			}
+		}
 	}
 	err = tx.Commit()
 	if err != nil {
 		return err
 	}
 	tx = nil
+	if pg.Citus {
+		return pg.distributeTables()
+	}
 	return nil
 }
 
-// Finish creates spatial indices on all tables.
-func (pg *PostGIS) Finish() error {
-	defer log.Step("Creating geometry indices")()
+// ResumableWrite returns true, the written rows are committed into the
+// tables of the import schema.
+func (pg *PostGIS) ResumableWrite() bool { return true }
 
-	worker := int(runtime.GOMAXPROCS(0))
-	if worker < 1 {
-		worker = 1
+// Finish creates spatial indices on all tables, clusters all tables with
+// a cluster option, converts unlogged tables, grants privileges and
+// executes the after_import hooks.
+func (pg *PostGIS) Finish() error {
+	if err := pg.createIndices(); err != nil {
+		return err
 	}
-
-	p := newWorkerPool(worker, len(pg.Tables)+len(pg.GeneralizedTables))
-	for _, tbl := range pg.Tables {
-		tableName := tbl.FullName
-		table := tbl
-		p.in <- func() error {
-			return createIndex(pg, tableName, table.Columns, false)
+	if pg.UpsertUpdates {
+		for _, tbl := range pg.Tables {
+			if err := createUniqueIDIndex(pg, tbl); err != nil {
+				return err
+			}
 		}
 	}
-
-	for _, tbl := range pg.GeneralizedTables {
-		tableName := tbl.FullName
-		table := tbl
-		p.in <- func() error {
-			return createIndex(pg, tableName, table.Source.Columns, true)
+	if !pg.clustered {
+		// tables are already clustered by Optimize otherwise
+		if err := pg.clusterTables(false); err != nil {
+			return errors.Wrap(err, "clustering tables")
 		}
 	}
-
-	err := p.wait()
-	if err != nil {
+	if pg.Config.Unlogged && !pg.Config.KeepUnlogged {
+		if err := pg.setLogged(); err != nil {
+			return err
+		}
+	}
+	if err := pg.grant(false); err != nil {
 		return err
 	}
 
+	for _, tbl := range pg.Tables {
+		if err := execHook(pg.Db, "after_import", tbl.Hooks.AfterImport, tbl.Schema, tbl.FullName); err != nil {
+			return err
+		}
+	}
+	for _, tbl := range pg.GeneralizedTables {
+		if err := execHook(pg.Db, "after_import", tbl.Hooks.AfterImport, tbl.Schema, tbl.FullName); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func createIndex(pg *PostGIS, tableName string, columns []ColumnSpec, generalizedTable bool) error {
-	foundIDCol := false
-	for _, cs := range columns {
-		if cs.Name == "id" {
-			foundIDCol = true
+// setLogged converts all unlogged tables to logged tables. This writes the
+// complete tables into the write-ahead log.
+func (pg *PostGIS) setLogged() error {
+	defer log.Step("Converting unlogged tables")()
+
+	var tables [][2]string // schema and table name
+	for _, tbl := range pg.Tables {
+		if tbl.Partition != nil {
+			for i := 0; i < tbl.Partition.Partitions; i++ {
+				tables = append(tables, [2]string{tbl.Schema, tbl.PartitionName(i)})
+			}
+			continue
+		}
+		tables = append(tables, [2]string{tbl.Schema, tbl.FullName})
+	}
+	for _, tbl := range pg.GeneralizedTables {
+		tables = append(tables, [2]string{tbl.Schema, tbl.FullName})
+	}
+	for _, spec := range pg.LandPolygons {
+		for _, table := range spec.tables() {
+			tables = append(tables, [2]string{spec.Schema, table})
 		}
 	}
 
-	for _, col := range columns {
-		if col.Type.Name() == "GEOMETRY" {
-			sql := fmt.Sprintf(`CREATE INDEX "%s_geom" ON "%s"."%s" USING GIST ("%s")`,
-				tableName, pg.Config.ImportSchema, tableName, col.Name)
-			step := log.Step(fmt.Sprintf("Creating geometry index on %s", tableName))
+	worker := int(runtime.GOMAXPROCS(0))
+	if worker < 1 {
+		worker = 1
+	}
+	p := newWorkerPool(worker, len(tables))
+	for _, tbl := range tables {
+		schema, tableName := tbl[0], tbl[1]
+		p.in <- func() error {
+			sql := fmt.Sprintf(`ALTER TABLE "%s"."%s" SET LOGGED`, schema, tableName)
+			step := log.Step(fmt.Sprintf("Converting %s to logged table", tableName))
 			_, err := pg.Db.Exec(sql)
 			step()
 			if err != nil {
-				return err
+				return &SQLError{sql, err}
 			}
+			return nil
 		}
-		if col.FieldType.Name == "id" && (foundIDCol || generalizedTable) {
-			// Create index for OSM ID required for diff updates, but only if
-			// the table does have an `id` column.
-			// The explicit `id` column prevented the creation of our composite
-			// PRIMARY KEY index of id (serial) and OSM ID.
-			// Generalized tables also do not have a PRIMARY KEY.
-			sql := fmt.Sprintf(`CREATE INDEX "%s_%s_idx" ON "%s"."%s" USING BTREE ("%s")`,
-				tableName, col.Name, pg.Config.ImportSchema, tableName, col.Name)
-			step := log.Step(fmt.Sprintf("Creating OSM id index on %s", tableName))
-			_, err := pg.Db.Exec(sql)
+	}
+	return p.wait()
+}
+
+// createIndices creates the geometry index, the OSM id index, if the table
+// has no PRIMARY KEY (generalized tables or tables with an explicit `id`
+// column), and all additional indices of all tables. Each index is created
+// in a separate task, so that multiple indices of large tables are created
+// in parallel.
+func (pg *PostGIS) createIndices() error {
+	defer log.Step("Creating geometry indices")()
+
+	type index struct {
+		tableName string
+		sql       string
+	}
+	var indices []index
+	for _, tbl := range pg.Tables {
+		for _, sql := range createIndexSQL(tbl.Schema, tbl.FullName, tbl.Columns, tbl.GeometryIndex, tbl.Indexes, tbl.IndexTablespace, !tbl.hasPrimaryKey()) {
+			indices = append(indices, index{tbl.FullName, sql})
+		}
+	}
+	for _, tbl := range pg.GeneralizedTables {
+		for _, sql := range createIndexSQL(tbl.Schema, tbl.FullName, tbl.Source.Columns, tbl.GeometryIndex, tbl.Indexes, tbl.IndexTablespace, true) {
+			indices = append(indices, index{tbl.FullName, sql})
+		}
+	}
+	for _, spec := range pg.LandPolygons {
+		for _, sql := range spec.IndexSQL() {
+			indices = append(indices, index{spec.FullName, sql})
+		}
+	}
+
+	p := newWorkerPool(pg.indexJobs(), len(indices))
+	for i := range indices {
+		idx := indices[i] // for following closure
+		p.in <- func() error {
+			step := log.Step(fmt.Sprintf("Creating index on %s", idx.tableName))
+			_, err := pg.Db.Exec(idx.sql)
 			step()
 			if err != nil {
-				return err
+				return &SQLError{idx.sql, err}
 			}
+			return nil
 		}
 	}
-	return nil
+	return p.wait()
+}
+
+// indexJobs returns the number of indices that are created in parallel,
+// the number of CPUs if not configured.
+func (pg *PostGIS) indexJobs() int {
+	if pg.Config.IndexJobs > 0 {
+		return pg.Config.IndexJobs
+	}
+	worker := int(runtime.GOMAXPROCS(0))
+	if worker < 1 {
+		worker = 1
+	}
+	return worker
+}
+
+// createIndexSQL returns the statements to create the geometry index, the
+// OSM ID index and all additional indices of a table.
+func createIndexSQL(schema, tableName string, columns []ColumnSpec, geometryIndex string, indexes []IndexSpec, tablespace string, noPrimaryKey bool) []string {
+	var stmts []string
+	for _, col := range columns {
+		if col.Type.Name() == "GEOMETRY" {
+			stmts = append(stmts, fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s_geom" ON "%s"."%s" USING %s ("%s")%s`,
+				tableName, schema, tableName, strings.ToUpper(geometryIndex), col.Name, tablespaceSQL(tablespace)))
+		}
+		if col.FieldType.Name == "id" && noPrimaryKey {
+			// Create index for OSM ID required for diff updates, but only if
+			// the table does not have our composite PRIMARY KEY index of id
+			// (serial) and OSM ID.
+			stmts = append(stmts, fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s_%s_idx" ON "%s"."%s" USING BTREE ("%s")%s`,
+				tableName, col.Name, schema, tableName, col.Name, tablespaceSQL(tablespace)))
+		}
+	}
+	for _, idx := range indexes {
+		stmts = append(stmts, idx.CreateSQL(schema, tableName, tablespace))
+	}
+	return stmts
 }
 
 func (pg *PostGIS) GeneralizeUpdates() error {
 	defer log.Step("Updating generalized tables")()
+	// generalized tables are updated from the source tables
+	if err := pg.txRouter.flushDeletes(); err != nil {
+		return err
+	}
 	for _, table := range pg.sortedGeneralizedTables() {
 		if ids, ok := pg.updatedIDs[table]; ok {
 			for _, id := range ids {
 				pg.txRouter.Insert(table, []interface{}{id})
 			}
+			if spec := pg.GeneralizedTables[table]; spec.SimplifyAlgorithm == geom.Topology {
+				if err := pg.updateTopology(pg.txRouter.tx, spec, ids); err != nil {
+					return errors.Wrapf(err, "updating topology of %s", spec.FullName)
+				}
+			}
+		}
+	}
+	for name, ids := range pg.coastlineIDs {
+		if err := pg.updateLandPolygons(pg.txRouter.tx, pg.LandPolygons[name], ids); err != nil {
+			return errors.Wrapf(err, "updating land polygons %s", name)
 		}
 	}
 	return nil
@@ -297,6 +451,12 @@ func (pg *PostGIS) Generalize() error {
 			return err
 		}
 	}
+
+	for _, spec := range pg.LandPolygons {
+		if err := pg.buildLandPolygons(spec); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -310,117 +470,199 @@ func (pg *PostGIS) generalizeTable(table *GeneralizedTableSpec) error {
 	}
 	defer rollbackIfTx(&tx)
 
-	var where string
-	if table.Where != "" {
-		where = " WHERE " + table.Where
-	}
-	var cols []string
-
-	for _, col := range table.Source.Columns {
-		cols = append(cols, col.Type.GeneralizeSQL(&col, table))
-	}
-
-	if err := dropTableIfExists(tx, pg.Config.ImportSchema, table.FullName); err != nil {
+	if err := dropTableIfExists(tx, table.Schema, table.FullName); err != nil {
 		return errors.Wrap(err, "dropping existing table")
 	}
 
-	columnSQL := strings.Join(cols, ",\n")
-
-	var sourceTable string
-	if table.SourceGeneralized != nil {
-		sourceTable = table.SourceGeneralized.FullName
-	} else {
-		sourceTable = table.Source.FullName
+	if err := execHook(tx, "before_create", table.Hooks.BeforeCreate, table.Schema, table.FullName); err != nil {
+		return err
 	}
-	sql := fmt.Sprintf(`CREATE TABLE "%s"."%s" AS (SELECT %s FROM "%s"."%s"%s)`,
-		pg.Config.ImportSchema, table.FullName, columnSQL, pg.Config.ImportSchema,
-		sourceTable, where)
 
+	sql := table.CreateTableSQL()
 	_, err = tx.Exec(sql)
 	if err != nil {
 		return &SQLError{sql, err}
 	}
+	if table.SimplifyAlgorithm == geom.Topology {
+		if err := pg.simplifyTopology(tx, table, table.TopologySelectSQL()); err != nil {
+			return errors.Wrapf(err, "simplifying topology of %s", table.FullName)
+		}
+	}
 
 	postgisVersion, err := getPostgisVersion(tx)
 	if err != nil {
 		return errors.Wrap(err, "detecting PostGIS version")
 	}
 	if strings.HasPrefix(postgisVersion, "0.") || strings.HasPrefix(postgisVersion, "1.") {
-		err = populateGeometryColumn(tx, table.FullName, *table.Source)
+		err = populateGeometryColumn(tx, table.Schema, table.FullName)
 		if err != nil {
 			return errors.Wrap(err, "populating GeometryColumn for PostGIS < 2")
 		}
 	}
 
+	for _, sql := range table.CommentSQL() {
+		if _, err := tx.Exec(sql); err != nil {
+			return &SQLError{sql, err}
+		}
+	}
+
+	if err := execHook(tx, "after_create", table.Hooks.AfterCreate, table.Schema, table.FullName); err != nil {
+		return err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return errors.Wrapf(err, "commiting tx for generalizes table %q", table.FullName)
 	}
 	tx = nil // set nil to prevent rollback
+	if pg.Citus {
+		return pg.distributeGeneralizedTable(table)
+	}
 	return nil
 }
 
-// Optimize clusters tables on new GeoHash index.
+// Optimize clusters all tables on new GeoHash index, or on the geometry
+// index for tables with cluster: geometry.
 func (pg *PostGIS) Optimize() error {
 	defer log.Step("Clustering on geometry")()
+	if err := pg.clusterTables(true); err != nil {
+		return errors.Wrap(err, "optimizing database")
+	}
+	pg.clustered = true
+	return nil
+}
 
+// clusterTables clusters all tables, or only the tables with a cluster
+// option if all is false.
+func (pg *PostGIS) clusterTables(all bool) error {
+	if pg.Citus {
+		if all || pg.hasClusterOption() {
+			log.Printf("[warn] distributed tables can't be clustered, skipping")
+		}
+		return nil
+	}
 	worker := int(runtime.GOMAXPROCS(0))
 	if worker < 1 {
 		worker = 1
 	}
 
-	p := newWorkerPool(worker, len(pg.Tables)+len(pg.GeneralizedTables))
+	tasks := len(pg.Tables) + len(pg.GeneralizedTables)
+	for _, tbl := range pg.Tables {
+		if tbl.Partition != nil {
+			tasks += tbl.Partition.Partitions
+		}
+	}
+	p := newWorkerPool(worker, tasks)
 
 	for _, tbl := range pg.Tables {
 		tableName := tbl.FullName
 		table := tbl
+		if !all && table.Cluster == "" {
+			continue
+		}
+		if table.Partition != nil {
+			// Partitioned tables can't be clustered, cluster each partition
+			// and analyze the partitioned table afterwards.
+			for i := 0; i < table.Partition.Partitions; i++ {
+				partName := table.PartitionName(i)
+				p.in <- func() error {
+					return clusterTable(pg, table.Schema, partName, table.Cluster, table.Srid, table.Geography, table.Columns, table.IndexTablespace)
+				}
+			}
+			continue
+		}
 		p.in <- func() error {
-			return clusterTable(pg, tableName, table.Srid, table.Columns)
+			return clusterTable(pg, table.Schema, tableName, table.Cluster, table.Srid, table.Geography, table.Columns, table.IndexTablespace)
 		}
 	}
 	for _, tbl := range pg.GeneralizedTables {
 		tableName := tbl.FullName
 		table := tbl
+		if !all && table.Cluster == "" {
+			continue
+		}
 		p.in <- func() error {
-			return clusterTable(pg, tableName, table.Source.Srid, table.Source.Columns)
+			return clusterTable(pg, table.Schema, tableName, table.Cluster, table.Source.Srid, false, table.Source.Columns, table.IndexTablespace)
 		}
 	}
 
 	err := p.wait()
 	if err != nil {
-		return errors.Wrap(err, "optimizing database")
+		return err
 	}
 
+	for _, tbl := range pg.Tables {
+		if tbl.Partition != nil && (all || tbl.Cluster != "") {
+			if err := analyzeTable(pg, tbl.Schema, tbl.FullName); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
-func clusterTable(pg *PostGIS, tableName string, srid int, columns []ColumnSpec) error {
+// clusterTable clusters the table on the geometry index (method geometry)
+// or on a new GeoHash index (all other methods).
+func clusterTable(pg *PostGIS, schema, tableName string, method string, srid int, geography bool, columns []ColumnSpec, tablespace string) error {
 	for _, col := range columns {
-		if col.Type.Name() == "GEOMETRY" {
-			step := log.Step(fmt.Sprintf("Indexing %q on geohash", tableName))
-			sql := fmt.Sprintf(`CREATE INDEX "%s_geom_geohash" ON "%s"."%s" (ST_GeoHash(ST_Transform(ST_SetSRID(Box2D(%s), %d), 4326)))`,
-				tableName, pg.Config.ImportSchema, tableName, col.Name, srid)
+		if col.Type.Name() != "GEOMETRY" {
+			continue
+		}
+		if method == "geometry" {
+			// Optimize is called before Finish, create geometry index
+			// if it does not exist yet.
+			sql := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s_geom" ON "%s"."%s" USING GIST ("%s")%s`,
+				tableName, schema, tableName, col.Name, tablespaceSQL(tablespace))
+			step := log.Step(fmt.Sprintf("Creating geometry index on %s", tableName))
 			_, err := pg.Db.Exec(sql)
 			step()
 			if err != nil {
-				return errors.Wrapf(err, "indexing %q on geohash", tableName)
+				return errors.Wrapf(err, "indexing %q on geometry", tableName)
 			}
 
-			step = log.Step(fmt.Sprintf("Clustering %q on geohash", tableName))
-			sql = fmt.Sprintf(`CLUSTER "%s_geom_geohash" ON "%s"."%s"`,
-				tableName, pg.Config.ImportSchema, tableName)
+			step = log.Step(fmt.Sprintf("Clustering %q on geometry", tableName))
+			sql = fmt.Sprintf(`CLUSTER "%s_geom" ON "%s"."%s"`,
+				tableName, schema, tableName)
 			_, err = pg.Db.Exec(sql)
 			step()
 			if err != nil {
-				return errors.Wrapf(err, "clusering %q on geohash", tableName)
+				return errors.Wrapf(err, "clustering %q on geometry", tableName)
 			}
 			break
 		}
+
+		geom := col.Name
+		if geography {
+			geom = col.Name + "::geometry"
+			srid = 4326
+		}
+		step := log.Step(fmt.Sprintf("Indexing %q on geohash", tableName))
+		sql := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s_geom_geohash" ON "%s"."%s" (ST_GeoHash(ST_Transform(ST_SetSRID(Box2D(%s), %d), 4326)))%s`,
+			tableName, schema, tableName, geom, srid, tablespaceSQL(tablespace))
+		_, err := pg.Db.Exec(sql)
+		step()
+		if err != nil {
+			return errors.Wrapf(err, "indexing %q on geohash", tableName)
+		}
+
+		step = log.Step(fmt.Sprintf("Clustering %q on geohash", tableName))
+		sql = fmt.Sprintf(`CLUSTER "%s_geom_geohash" ON "%s"."%s"`,
+			tableName, schema, tableName)
+		_, err = pg.Db.Exec(sql)
+		step()
+		if err != nil {
+			return errors.Wrapf(err, "clusering %q on geohash", tableName)
+		}
+		break
 	}
 
+	return analyzeTable(pg, schema, tableName)
+}
+
+func analyzeTable(pg *PostGIS, schema, tableName string) error {
 	step := log.Step(fmt.Sprintf("Analysing %q", tableName))
 	sql := fmt.Sprintf(`ANALYSE "%s"."%s"`,
-		pg.Config.ImportSchema, tableName)
+		schema, tableName)
 	_, err := pg.Db.Exec(sql)
 	step()
 	if err != nil {
@@ -433,36 +675,76 @@ func clusterTable(pg *PostGIS, tableName string, srid int, columns []ColumnSpec)
 type PostGIS struct {
 	Db                      *sql.DB
 	Params                  string
+	Pool                    PoolConfig
+	Retry                   RetryConfig
 	Config                  database.Config
 	Tables                  map[string]*TableSpec
 	GeneralizedTables       map[string]*GeneralizedTableSpec
+	LandPolygons            map[string]*LandPolygonSpec
 	Prefix                  string
+	Tablespace              string
+	IndexTablespace         string
+	UpsertUpdates           bool
+	DeployViews             bool
+	Citus                   bool // create distributed tables
+	Quarantine              bool // store invalid geometries
+	RecordChanges           bool // store changed elements of diff imports
+	TableChanges            bool // store changed ids of each table
+	TableChangesRetention   time.Duration
+	diffSequence            int
+	clustered               bool // set after Optimize
+	bulkImport              bool
+	appendBulk              bool // bulk imports append to existing rows
 	txRouter                *TxRouter
 	updateGeneralizedTables bool
 
-	updateIDsMu sync.Mutex
-	updatedIDs  map[string][]int64
+	updateIDsMu  sync.Mutex
+	updatedIDs   map[string][]int64
+	coastlineIDs map[string][]int64 // changed coastlines of each land polygon table
+
+	quarantineMu     sync.Mutex
+	quarantineTables map[string]bool // created quarantine tables
+
+	changesetsCreated bool
+	changesCreated    bool
+
+	// savepointMu serializes the inserts with savepoints, as all tables
+	// share the same transaction
+	savepointMu sync.Mutex
 }
 
 func (pg *PostGIS) Open() error {
 	var err error
 
-	pg.Db, err = sql.Open("postgres", pg.Params)
+	pg.Db, err = sql.Open(pg.Pool.Driver, pg.Params)
 	if err != nil {
 		return errors.Wrap(err, "opening Postgres DB")
 	}
+	pg.Pool.apply(pg.Db)
 	// check that the connection actually works
 	err = pg.Db.Ping()
+	err = pg.Retry.retry("connecting to Postgres DB", err, pg.Db.Ping)
 	if err != nil {
 		return errors.Wrap(err, "ping Postgres DB")
 	}
 	return nil
 }
 
+// insert inserts the row into the table. The geometries of geography
+// tables are transformed to EPSG:4326.
+func (pg *PostGIS) insert(table string, row []interface{}) error {
+	if spec, ok := pg.Tables[table]; ok {
+		if err := spec.geographyRow(row); err != nil {
+			return errors.Wrapf(err, "inserting into %q", table)
+		}
+	}
+	return pg.txRouter.Insert(table, row)
+}
+
 func (pg *PostGIS) InsertPoint(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
 	for _, match := range matches {
 		row := match.Row(&elem, &geom)
-		if err := pg.txRouter.Insert(match.Table.Name, row); err != nil {
+		if err := pg.insert(match.Table.Name, row); err != nil {
 			return err
 		}
 	}
@@ -472,7 +754,7 @@ func (pg *PostGIS) InsertPoint(elem osm.Element, geom geom.Geometry, matches []m
 func (pg *PostGIS) InsertLineString(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
 	for _, match := range matches {
 		row := match.Row(&elem, &geom)
-		if err := pg.txRouter.Insert(match.Table.Name, row); err != nil {
+		if err := pg.insert(match.Table.Name, row); err != nil {
 			return err
 		}
 	}
@@ -486,6 +768,7 @@ func (pg *PostGIS) InsertLineString(elem osm.Element, geom geom.Geometry, matche
 			}
 			pg.updateIDsMu.Unlock()
 		}
+		pg.recordCoastlines(elem.ID, matches)
 	}
 	return nil
 }
@@ -493,7 +776,7 @@ func (pg *PostGIS) InsertLineString(elem osm.Element, geom geom.Geometry, matche
 func (pg *PostGIS) InsertPolygon(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
 	for _, match := range matches {
 		row := match.Row(&elem, &geom)
-		if err := pg.txRouter.Insert(match.Table.Name, row); err != nil {
+		if err := pg.insert(match.Table.Name, row); err != nil {
 			return err
 		}
 	}
@@ -514,7 +797,7 @@ func (pg *PostGIS) InsertPolygon(elem osm.Element, geom geom.Geometry, matches [
 func (pg *PostGIS) InsertRelationMember(rel osm.Relation, m osm.Member, geom geom.Geometry, matches []mapping.Match) error {
 	for _, match := range matches {
 		row := match.MemberRow(&rel, &m, &geom)
-		if err := pg.txRouter.Insert(match.Table.Name, row); err != nil {
+		if err := pg.insert(match.Table.Name, row); err != nil {
 			return err
 		}
 	}
@@ -533,6 +816,7 @@ func (pg *PostGIS) Delete(id int64, matches []mapping.Match) error {
 				return errors.Wrapf(err, "deleting %d from %q", id, generalizedTable.Name)
 			}
 		}
+		pg.recordCoastlines(id, matches)
 	}
 	return nil
 }
@@ -566,6 +850,7 @@ func (pg *PostGIS) sortedGeneralizedTables() []string {
 func (pg *PostGIS) EnableGeneralizeUpdates() {
 	pg.updateGeneralizedTables = true
 	pg.updatedIDs = make(map[string][]int64)
+	pg.coastlineIDs = make(map[string][]int64)
 }
 
 func (pg *PostGIS) Begin() error {
@@ -576,6 +861,7 @@ func (pg *PostGIS) Begin() error {
 
 func (pg *PostGIS) BeginBulk() error {
 	var err error
+	pg.bulkImport = true
 	pg.txRouter, err = newTxRouter(pg, true)
 	return err
 }
@@ -585,7 +871,14 @@ func (pg *PostGIS) Abort() error {
 }
 
 func (pg *PostGIS) End() error {
-	return pg.txRouter.End()
+	if err := pg.txRouter.End(); err != nil {
+		return err
+	}
+	if pg.bulkImport {
+		// release the connections reserved for the COPY of each table
+		pg.Pool.apply(pg.Db)
+	}
+	return nil
 }
 
 func (pg *PostGIS) Close() error {
@@ -597,6 +890,7 @@ func New(conf database.Config, m *config.Mapping) (database.DB, error) {
 
 	db.Tables = make(map[string]*TableSpec)
 	db.GeneralizedTables = make(map[string]*GeneralizedTableSpec)
+	db.LandPolygons = make(map[string]*LandPolygonSpec)
 
 	db.Config = conf
 
@@ -625,20 +919,78 @@ func New(conf database.Config, m *config.Mapping) (database.DB, error) {
 
 	params = disableDefaultSsl(params)
 	params, db.Prefix = stripPrefixFromConnectionParams(params)
-
-	for name, table := range m.Tables {
-		db.Tables[name], err = NewTableSpec(db, table)
-		if err != nil {
-			return nil, errors.Wrapf(err, "creating table spec for %q", name)
+	params, db.Tablespace = stripParamFromConnectionParams(params, "tablespace")
+	params, db.IndexTablespace = stripParamFromConnectionParams(params, "index_tablespace")
+	params, db.Pool, err = parsePoolConfig(params)
+	if err != nil {
+		return nil, err
+	}
+	params, db.Retry, err = parseRetryConfig(params)
+	if err != nil {
+		return nil, err
+	}
+	var strategy string
+	params, strategy = stripParamFromConnectionParams(params, "update_strategy")
+	switch strategy {
+	case "", "delete_insert":
+	case "upsert":
+		db.UpsertUpdates = true
+	default:
+		return nil, errors.Errorf("unknown update_strategy %q", strategy)
+	}
+	params, strategy = stripParamFromConnectionParams(params, "deploy_strategy")
+	switch strategy {
+	case "", "rotate":
+	case "views":
+		db.DeployViews = true
+		if conf.PartialImport {
+			// older versions of the other tables would be removed
+			return nil, errors.New("deploy_strategy=views does not support imports of single tables")
+		}
+	default:
+		return nil, errors.Errorf("unknown deploy_strategy %q", strategy)
+	}
+	var citus string
+	params, citus = stripParamFromConnectionParams(params, "citus")
+	if citus != "" {
+		if db.Citus, err = strconv.ParseBool(citus); err != nil {
+			return nil, errors.Wrap(err, "parsing citus")
 		}
 	}
-	for name, table := range m.GeneralizedTables {
-		db.GeneralizedTables[name] = NewGeneralizedTableSpec(db, table)
+	var quarantine string
+	params, quarantine = stripParamFromConnectionParams(params, "quarantine")
+	if quarantine != "" {
+		if db.Quarantine, err = strconv.ParseBool(quarantine); err != nil {
+			return nil, errors.Wrap(err, "parsing quarantine")
+		}
+	}
+	var recordChanges string
+	params, recordChanges = stripParamFromConnectionParams(params, "record_changes")
+	if recordChanges != "" {
+		if db.RecordChanges, err = strconv.ParseBool(recordChanges); err != nil {
+			return nil, errors.Wrap(err, "parsing record_changes")
+		}
+	}
+	var tableChanges, retention string
+	params, tableChanges = stripParamFromConnectionParams(params, "table_changes")
+	if tableChanges != "" {
+		if db.TableChanges, err = strconv.ParseBool(tableChanges); err != nil {
+			return nil, errors.Wrap(err, "parsing table_changes")
+		}
 	}
-	if err := db.prepareGeneralizedTableSources(); err != nil {
-		return nil, errors.Wrap(err, "preparing generalized table sources")
+	params, retention = stripParamFromConnectionParams(params, "table_changes_retention")
+	if retention != "" {
+		if db.TableChangesRetention, err = time.ParseDuration(retention); err != nil {
+			return nil, errors.Wrap(err, "parsing table_changes_retention")
+		}
+	}
+	if err := checkGrants(conf.Grants); err != nil {
+		return nil, errors.Wrap(err, "grants")
+	}
+
+	if err := db.prepareTables(m); err != nil {
+		return nil, err
 	}
-	db.prepareGeneralizations()
 
 	db.Params = params
 	err = db.Open()
@@ -648,6 +1000,57 @@ func New(conf database.Config, m *config.Mapping) (database.DB, error) {
 	return db, nil
 }
 
+// prepareTables creates the specs of all tables and generalized tables.
+func (pg *PostGIS) prepareTables(m *config.Mapping) error {
+	var err error
+	for name, table := range m.Tables {
+		pg.Tables[name], err = NewTableSpec(pg, table)
+		if err != nil {
+			return errors.Wrapf(err, "creating table spec for %q", name)
+		}
+		if mapping.TableType(table.Type) == mapping.GeometryTable && !m.SingleIDSpace {
+			// nodes and ways can have the same id
+			pg.Tables[name].nonUniqueIDs = true
+		}
+	}
+	for name, table := range m.GeneralizedTables {
+		pg.GeneralizedTables[name], err = NewGeneralizedTableSpec(pg, table)
+		if err != nil {
+			return errors.Wrapf(err, "creating generalized table spec for %q", name)
+		}
+		pg.GeneralizedTables[name].PolygonOrientation = m.PolygonOrientation
+	}
+	if err := pg.prepareGeneralizedTableSources(); err != nil {
+		return errors.Wrap(err, "preparing generalized table sources")
+	}
+	for name, table := range pg.GeneralizedTables {
+		if table.Source.Geography {
+			return errors.Errorf("generalized table %q can't use geography table %q as source", name, table.Source.Name)
+		}
+		if err := checkIndexColumns(table.Indexes, table.Source.Columns); err != nil {
+			return errors.Wrapf(err, "indexes of generalized table %q", name)
+		}
+	}
+	pg.prepareGeneralizations()
+
+	for name, table := range m.LandPolygons {
+		spec, err := NewLandPolygonSpec(pg, table)
+		if err != nil {
+			return errors.Wrapf(err, "land polygons %q", name)
+		}
+		source, ok := pg.Tables[spec.SourceName]
+		if !ok {
+			return errors.Errorf("missing source %q for land polygons %q", spec.SourceName, name)
+		}
+		if source.Geography {
+			return errors.Errorf("land polygons %q can't use geography table %q as source", name, source.Name)
+		}
+		spec.Source = source
+		pg.LandPolygons[name] = spec
+	}
+	return nil
+}
+
 // prepareGeneralizedTableSources checks if all generalized table have an
 // existing source and sets .Source to the original source (works even
 // when source is allready generalized).
@@ -675,6 +1078,15 @@ func (pg *PostGIS) prepareGeneralizedTableSources() error {
 			}
 		}
 	}
+
+	for name, table := range pg.GeneralizedTables {
+		if table.SimplifyAlgorithm != geom.Topology {
+			continue
+		}
+		if id, geometry := table.topologyColumns(); id == "" || geometry == nil {
+			return errors.Errorf("simplify_algorithm topology requires an id and a geometry column for generalized table %q", name)
+		}
+	}
 	return nil
 }
 
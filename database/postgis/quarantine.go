@@ -0,0 +1,88 @@
+package postgis
+
+import (
+	"encoding/json"
+	"fmt"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/pkg/errors"
+)
+
+// Elements with invalid geometries are stored in a quarantine table for
+// each table (e.g. osm_buildings_invalid) in the import schema, if the
+// quarantine option is enabled. The tables are created with the first
+// invalid element and they are removed at the start of each import.
+
+// quarantineTable returns the name of the quarantine table of a table.
+func quarantineTable(spec *TableSpec) string {
+	return spec.FullName + "_invalid"
+}
+
+// quarantineCreateSQL returns the statement to create the quarantine
+// table of spec.
+func quarantineCreateSQL(spec *TableSpec) string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s"."%s" (
+    osm_id BIGINT,
+    error TEXT,
+    tags JSONB,
+    geometry geometry(Geometry, %d)
+)`, spec.Schema, quarantineTable(spec), spec.Srid)
+}
+
+func quarantineInsertSQL(spec *TableSpec) string {
+	return fmt.Sprintf(`INSERT INTO "%s"."%s" (osm_id, error, tags, geometry) VALUES ($1, $2, $3, $4::Geometry)`,
+		spec.Schema, quarantineTable(spec))
+}
+
+// InsertInvalid stores the element in the quarantine tables of all
+// matched tables.
+func (pg *PostGIS) InsertInvalid(elem osm.Element, reason error, wkb []byte, matches []mapping.Match) error {
+	if !pg.Quarantine {
+		return nil
+	}
+	tags, err := json.Marshal(elem.Tags)
+	if err != nil {
+		return err
+	}
+	var geom interface{}
+	if len(wkb) > 0 {
+		geom = string(wkb)
+	}
+
+	seen := make(map[string]bool)
+	for _, match := range matches {
+		spec, ok := pg.Tables[match.Table.Name]
+		if !ok || seen[spec.Name] {
+			continue
+		}
+		seen[spec.Name] = true
+		if err := pg.createQuarantineTable(spec); err != nil {
+			return err
+		}
+		sql := quarantineInsertSQL(spec)
+		if _, err := pg.Db.Exec(sql, elem.ID, reason.Error(), string(tags), geom); err != nil {
+			return errors.Wrapf(&SQLError{sql, err}, "quarantine of %d", elem.ID)
+		}
+	}
+	return nil
+}
+
+// createQuarantineTable creates the quarantine table of spec, once for
+// each import or diff import.
+func (pg *PostGIS) createQuarantineTable(spec *TableSpec) error {
+	pg.quarantineMu.Lock()
+	defer pg.quarantineMu.Unlock()
+	if pg.quarantineTables[spec.Name] {
+		return nil
+	}
+	sql := quarantineCreateSQL(spec)
+	if _, err := pg.Db.Exec(sql); err != nil {
+		return &SQLError{sql, err}
+	}
+	if pg.quarantineTables == nil {
+		pg.quarantineTables = make(map[string]bool)
+	}
+	pg.quarantineTables[spec.Name] = true
+	return nil
+}
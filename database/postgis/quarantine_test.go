@@ -0,0 +1,26 @@
+package postgis
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuarantineSQL(t *testing.T) {
+	spec, err := testTableSpec(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql := quarantineCreateSQL(spec)
+	for _, part := range []string{
+		`CREATE TABLE IF NOT EXISTS "import"."osm_roads_invalid" (`,
+		`tags JSONB`,
+		`geometry geometry(Geometry, 3857)`,
+	} {
+		if !strings.Contains(sql, part) {
+			t.Errorf("missing %q in %s", part, sql)
+		}
+	}
+	if sql := quarantineInsertSQL(spec); sql != `INSERT INTO "import"."osm_roads_invalid" (osm_id, error, tags, geometry) VALUES ($1, $2, $3, $4::Geometry)` {
+		t.Errorf("unexpected sql %s", sql)
+	}
+}
@@ -0,0 +1,74 @@
+package postgis
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// The rows of an import are positioned by the serial `id` of each table.
+// The sequence is only advanced by inserts, all rows after the last value
+// of the sequence at CommitWrite were inserted after the commit.
+
+// CommitWrite commits all inserted rows and begins new transactions for
+// the following rows. Returns the last serial id of each table, or nil if
+// a table has no serial id.
+func (pg *PostGIS) CommitWrite() (map[string]int64, error) {
+	bulk := pg.bulkImport
+	if err := pg.End(); err != nil {
+		return nil, err
+	}
+	positions, err := pg.rowPositions()
+	if err != nil {
+		return nil, err
+	}
+	pg.appendBulk = true
+	if bulk {
+		err = pg.BeginBulk()
+	} else {
+		err = pg.Begin()
+	}
+	return positions, err
+}
+
+// ResumeWrite removes all rows that were inserted after the positions of
+// an interrupted import and begins a bulk import that appends to the
+// existing tables.
+func (pg *PostGIS) ResumeWrite(positions map[string]int64) error {
+	for name, spec := range pg.Tables {
+		pos, ok := positions[name]
+		if !ok {
+			return errors.Errorf("no position of table %s, import again without -resume", spec.FullName)
+		}
+		sql := fmt.Sprintf(`DELETE FROM "%s"."%s" WHERE id > %d`, spec.Schema, spec.FullName, pos)
+		if _, err := pg.Db.Exec(sql); err != nil {
+			return &SQLError{sql, err}
+		}
+	}
+	pg.appendBulk = true
+	return pg.BeginBulk()
+}
+
+// rowPositions returns the last value of the serial id of each table, or
+// nil if a table has no serial id.
+func (pg *PostGIS) rowPositions() (map[string]int64, error) {
+	positions := make(map[string]int64, len(pg.Tables))
+	for name, spec := range pg.Tables {
+		if !spec.hasSerialID() {
+			return nil, nil
+		}
+		var seq string
+		sql := fmt.Sprintf(`SELECT pg_get_serial_sequence('"%s"."%s"', 'id')`, spec.Schema, spec.FullName)
+		if err := pg.Db.QueryRow(sql).Scan(&seq); err != nil {
+			return nil, &SQLError{sql, err}
+		}
+		var pos int64
+		// last_value is the start value till the first row is inserted
+		sql = fmt.Sprintf(`SELECT CASE WHEN is_called THEN last_value ELSE 0 END FROM %s`, seq)
+		if err := pg.Db.QueryRow(sql).Scan(&pos); err != nil {
+			return nil, &SQLError{sql, err}
+		}
+		positions[name] = pos
+	}
+	return positions, nil
+}
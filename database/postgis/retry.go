@@ -0,0 +1,131 @@
+package postgis
+
+import (
+	"database/sql/driver"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/lib/pq"
+	"github.com/omniscale/imposm3/log"
+	"github.com/pkg/errors"
+)
+
+// retryWait is the wait time before the first retry, it is doubled for
+// each retry.
+var retryWait = time.Second
+
+// RetryConfig configures the retries after transient database errors,
+// like connection resets during a failover of the database server.
+type RetryConfig struct {
+	// Retries is the number of retries, 0 disables retries.
+	Retries int
+	// MaxWait is the maximum wait time between two retries.
+	MaxWait time.Duration
+}
+
+// parseRetryConfig removes the retries and retry_max_wait params from
+// params.
+func parseRetryConfig(params string) (string, RetryConfig, error) {
+	conf := RetryConfig{Retries: 5, MaxWait: time.Minute}
+
+	var retries, maxWait string
+	params, retries = stripParamFromConnectionParams(params, "retries")
+	params, maxWait = stripParamFromConnectionParams(params, "retry_max_wait")
+
+	var err error
+	if retries != "" {
+		if conf.Retries, err = strconv.Atoi(retries); err != nil || conf.Retries < 0 {
+			return "", conf, errors.Errorf("parsing retries: invalid number %q", retries)
+		}
+	}
+	if maxWait != "" {
+		if conf.MaxWait, err = time.ParseDuration(maxWait); err != nil {
+			return "", conf, errors.Wrap(err, "parsing retry_max_wait")
+		}
+	}
+	return params, conf, nil
+}
+
+// retry calls f again as long as it fails with transient errors, until
+// the number of retries is reached. err is the error of the first attempt.
+// Returns the error of the last attempt.
+func (c RetryConfig) retry(what string, err error, f func() error) error {
+	wait := retryWait
+	for i := 1; err != nil && i <= c.Retries && isTransient(err); i++ {
+		log.Printf("[warn] %s: %s, retrying in %s (%d of %d)", what, err, wait, i, c.Retries)
+		time.Sleep(wait)
+		wait *= 2
+		if c.MaxWait > 0 && wait > c.MaxWait {
+			wait = c.MaxWait
+		}
+		err = f()
+	}
+	return err
+}
+
+// transientCodes are the SQLSTATE codes of errors that are caused by
+// concurrent transactions or by a restart or failover of the server.
+var transientCodes = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"53300": true, // too_many_connections
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+}
+
+// commitError is the error of a COMMIT that failed after it was sent to
+// the server. The transaction is not repeated, as it is unknown whether it
+// was committed.
+type commitError struct {
+	err error
+}
+
+func (e *commitError) Error() string {
+	return "COMMIT failed: " + e.err.Error()
+}
+
+// isTransient returns whether err is caused by a lost connection, a
+// failover or a serialization failure, and whether the failed operation
+// can succeed if it is repeated.
+func isTransient(err error) bool {
+	for err != nil {
+		switch e := err.(type) {
+		case *pgconn.PgError:
+			return isTransientCode(e.Code)
+		case *pq.Error:
+			// errors of the lib/pq driver, with driver=pq
+			return isTransientCode(string(e.Code))
+		case *commitError:
+			return false
+		case *SQLError:
+			err = e.originalError
+			continue
+		case net.Error:
+			return true
+		}
+		switch err {
+		case io.EOF, io.ErrUnexpectedEOF, driver.ErrBadConn, syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.EPIPE:
+			return true
+		}
+		if u, ok := err.(interface{ Unwrap() error }); ok {
+			err = u.Unwrap()
+		} else if c, ok := err.(interface{ Cause() error }); ok {
+			err = c.Cause()
+		} else {
+			return false
+		}
+	}
+	return false
+}
+
+// isTransientCode returns whether the SQLSTATE code is in the
+// connection_exception class or in transientCodes.
+func isTransientCode(code string) bool {
+	return strings.HasPrefix(code, "08") || transientCodes[code]
+}
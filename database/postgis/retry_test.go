@@ -0,0 +1,115 @@
+package postgis
+
+import (
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+func TestParseRetryConfig(t *testing.T) {
+	params, conf, err := parseRetryConfig("host=localhost retries=3 retry_max_wait=10s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params != "host=localhost" {
+		t.Errorf("unexpected params %q", params)
+	}
+	if conf != (RetryConfig{Retries: 3, MaxWait: 10 * time.Second}) {
+		t.Errorf("unexpected config %#v", conf)
+	}
+
+	_, conf, err = parseRetryConfig("host=localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf != (RetryConfig{Retries: 5, MaxWait: time.Minute}) {
+		t.Errorf("unexpected default config %#v", conf)
+	}
+
+	for _, params := range []string{
+		"retries=x",
+		"retries=-1",
+		"retry_max_wait=10",
+	} {
+		if _, _, err := parseRetryConfig(params); err == nil {
+			t.Errorf("expected error for %q", params)
+		}
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		transient bool
+	}{
+		{&pgconn.PgError{Code: "40001"}, true},
+		{&pgconn.PgError{Code: "57P01"}, true},
+		{&pgconn.PgError{Code: "08006"}, true},
+		{&SQLError{"COPY", &pgconn.PgError{Code: "08003"}}, true},
+		{&pq.Error{Code: "08006"}, true},
+		{&pq.Error{Code: "40001"}, true},
+		{&SQLError{"INSERT", &pq.Error{Code: "40P01"}}, true},
+		{errors.Wrap(io.ErrUnexpectedEOF, "reading"), true},
+		{syscall.ECONNRESET, true},
+		{&pgconn.PgError{Code: "23505"}, false},
+		{&SQLError{"COPY", &pgconn.PgError{Code: "22P02"}}, false},
+		{&pq.Error{Code: "23505"}, false},
+		{&commitError{io.EOF}, false},
+		{errors.New("invalid geometry"), false},
+	} {
+		if isTransient(tc.err) != tc.transient {
+			t.Errorf("unexpected isTransient for %v, expected %v", tc.err, tc.transient)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	defer func(wait time.Duration) { retryWait = wait }(retryWait)
+	retryWait = time.Millisecond
+
+	conf := RetryConfig{Retries: 3, MaxWait: time.Millisecond}
+	calls := 0
+	err := conf.retry("test", io.EOF, func() error {
+		calls++
+		if calls < 2 {
+			return io.EOF
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("unexpected result %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = conf.retry("test", io.EOF, func() error {
+		calls++
+		return io.EOF
+	})
+	if err != io.EOF || calls != 3 {
+		t.Errorf("unexpected result %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	permanent := &pgconn.PgError{Code: "23505"}
+	err = conf.retry("test", io.EOF, func() error {
+		calls++
+		return permanent
+	})
+	if err != permanent || calls != 1 {
+		t.Errorf("unexpected result %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = RetryConfig{}.retry("test", io.EOF, func() error {
+		calls++
+		return nil
+	})
+	if err != io.EOF || calls != 0 {
+		t.Errorf("unexpected result %v after %d calls", err, calls)
+	}
+}
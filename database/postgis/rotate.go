@@ -1,21 +1,128 @@
 package postgis
 
 import (
+	"database/sql"
 	"fmt"
+	"sort"
 
 	"github.com/omniscale/imposm3/log"
 )
 
-func (pg *PostGIS) rotate(source, dest, backup string) error {
-	defer log.Step("Rotating tables")()
+// deployTable is a table with its import, production and backup schema.
+type deployTable struct {
+	Name       string // with prefix
+	Import     string
+	Production string
+	Backup     string
+}
 
-	if err := pg.createSchema(dest); err != nil {
-		return err
+// deployTables returns all tables with their schemas, sorted by name.
+func (pg *PostGIS) deployTables() []deployTable {
+	var tables []deployTable
+	for _, spec := range pg.Tables {
+		tables = append(tables, deployTable{spec.FullName, spec.Schema, spec.ProductionSchema, spec.BackupSchema})
+	}
+	for _, spec := range pg.GeneralizedTables {
+		tables = append(tables, deployTable{spec.FullName, spec.Schema, spec.ProductionSchema, spec.BackupSchema})
+	}
+	for _, spec := range pg.LandPolygons {
+		for _, table := range spec.tables() {
+			tables = append(tables, deployTable{table, spec.Schema, spec.ProductionSchema, spec.BackupSchema})
+		}
 	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	return tables
+}
+
+// importSchemas returns the distinct import schemas of all tables.
+func (pg *PostGIS) importSchemas() []string {
+	var schemas []string
+	seen := make(map[string]bool)
+	for _, t := range pg.deployTables() {
+		if !seen[t.Import] {
+			seen[t.Import] = true
+			schemas = append(schemas, t.Import)
+		}
+	}
+	return schemas
+}
 
-	if err := pg.createSchema(backup); err != nil {
+// backupGenerations returns the number of backups to keep.
+func (pg *PostGIS) backupGenerations() int {
+	if pg.Config.BackupGenerations < 1 {
+		return 1
+	}
+	return pg.Config.BackupGenerations
+}
+
+// backupSchema returns the schema of the n-th backup generation. The first
+// generation is stored in the backup schema, older generations in backup_1,
+// backup_2, etc.
+func backupSchema(backup string, n int) string {
+	if n == 0 {
+		return backup
+	}
+	return fmt.Sprintf("%s_%d", backup, n)
+}
+
+// shiftBackups moves each backup generation of a table to the next older
+// generation. The oldest generation is removed.
+func shiftBackups(tx *sql.Tx, backup, tableName string, generations int) error {
+	if err := dropTableIfExists(tx, backupSchema(backup, generations-1), tableName); err != nil {
 		return err
 	}
+	for n := generations - 2; n >= 0; n-- {
+		exists, err := tableExists(tx, backupSchema(backup, n), tableName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		dest := backupSchema(backup, n+1)
+		sql := fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS "%s"`, dest)
+		if _, err := tx.Exec(sql); err != nil {
+			return &SQLError{sql, err}
+		}
+		if err := moveTable(tx, backupSchema(backup, n), tableName, dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unshiftBackups moves each older backup generation of a table to the next
+// newer generation, after the first generation was reverted.
+func unshiftBackups(tx *sql.Tx, backup, tableName string, generations int) error {
+	for n := 1; n < generations; n++ {
+		exists, err := tableExists(tx, backupSchema(backup, n), tableName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			break
+		}
+		if err := moveTable(tx, backupSchema(backup, n), tableName, backupSchema(backup, n-1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotate moves all tables from import to production and from production to
+// backup. With revert, tables are moved from backup to production and from
+// production to import.
+func (pg *PostGIS) rotate(revert bool) error {
+	defer log.Step("Rotating tables")()
+
+	tables := pg.deployTables()
+	for _, t := range tables {
+		for _, schema := range []string{t.Import, t.Production, t.Backup} {
+			if err := pg.createSchema(schema); err != nil {
+				return err
+			}
+		}
+	}
 
 	tx, err := pg.Db.Begin()
 	if err != nil {
@@ -23,8 +130,13 @@ func (pg *PostGIS) rotate(source, dest, backup string) error {
 	}
 	defer rollbackIfTx(&tx)
 
-	for _, tableName := range pg.tableNames() {
-		tableName = pg.Prefix + tableName
+	generations := pg.backupGenerations()
+	for _, t := range tables {
+		tableName := t.Name
+		source, dest, backup := t.Import, t.Production, t.Backup
+		if revert {
+			source, backup = t.Backup, t.Import
+		}
 
 		log.Printf("[info] Rotating %s from %s -> %s -> %s", tableName, source, dest, backup)
 
@@ -49,23 +161,29 @@ func (pg *PostGIS) rotate(source, dest, backup string) error {
 		if destExists {
 			log.Printf("[info] backup of %s, to %s", tableName, backup)
 			if backupExists {
-				err = dropTableIfExists(tx, backup, tableName)
+				if revert {
+					err = dropTableIfExists(tx, backup, tableName)
+				} else {
+					err = shiftBackups(tx, backup, tableName, generations)
+				}
 				if err != nil {
 					return err
 				}
 			}
-			sql := fmt.Sprintf(`ALTER TABLE "%s"."%s" SET SCHEMA "%s"`, dest, tableName, backup)
-			_, err = tx.Exec(sql)
-			if err != nil {
+			if err := moveTable(tx, dest, tableName, backup); err != nil {
 				return err
 			}
 		}
 
-		sql := fmt.Sprintf(`ALTER TABLE "%s"."%s" SET SCHEMA "%s"`, source, tableName, dest)
-		_, err = tx.Exec(sql)
-		if err != nil {
+		if err := moveTable(tx, source, tableName, dest); err != nil {
 			return err
 		}
+
+		if revert {
+			if err := unshiftBackups(tx, t.Backup, tableName, generations); err != nil {
+				return err
+			}
+		}
 	}
 
 	err = tx.Commit()
@@ -76,37 +194,71 @@ func (pg *PostGIS) rotate(source, dest, backup string) error {
 	return nil
 }
 
+// moveTable moves table and all its partitions from schema source to dest.
+func moveTable(tx *sql.Tx, source, tableName, dest string) error {
+	partitions, err := tablePartitions(tx, source, tableName)
+	if err != nil {
+		return err
+	}
+	for _, name := range append(partitions, tableName) {
+		sql := fmt.Sprintf(`ALTER TABLE "%s"."%s" SET SCHEMA "%s"`, source, name, dest)
+		if _, err := tx.Exec(sql); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (pg *PostGIS) Deploy() error {
-	return pg.rotate(pg.Config.ImportSchema, pg.Config.ProductionSchema, pg.Config.BackupSchema)
+	var err error
+	if pg.DeployViews {
+		err = pg.deployViews()
+	} else {
+		err = pg.rotate(false)
+	}
+	if err != nil {
+		return err
+	}
+	return pg.grant(true)
 }
 
 func (pg *PostGIS) RevertDeploy() error {
-	return pg.rotate(pg.Config.BackupSchema, pg.Config.ProductionSchema, pg.Config.ImportSchema)
+	var err error
+	if pg.DeployViews {
+		err = pg.revertDeployViews()
+	} else {
+		err = pg.rotate(true)
+	}
+	if err != nil {
+		return err
+	}
+	return pg.grant(true)
 }
 
 func (pg *PostGIS) RemoveBackup() error {
+	if pg.DeployViews {
+		return pg.removeBackupViews()
+	}
 	tx, err := pg.Db.Begin()
 	if err != nil {
 		return err
 	}
 	defer rollbackIfTx(&tx)
 
-	backup := pg.Config.BackupSchema
-
-	for _, tableName := range pg.tableNames() {
-		tableName = pg.Prefix + tableName
-
-		backupExists, err := tableExists(tx, backup, tableName)
-		if err != nil {
-			return err
-		}
-		if backupExists {
-			log.Printf("[info] removing backup of %s from %s", tableName, backup)
-			err = dropTableIfExists(tx, backup, tableName)
+	for _, t := range pg.deployTables() {
+		for n := 0; n < pg.backupGenerations(); n++ {
+			backup := backupSchema(t.Backup, n)
+			backupExists, err := tableExists(tx, backup, t.Name)
 			if err != nil {
 				return err
 			}
-
+			if backupExists {
+				log.Printf("[info] removing backup of %s from %s", t.Name, backup)
+				err = dropTableIfExists(tx, backup, t.Name)
+				if err != nil {
+					return err
+				}
+			}
 		}
 	}
 
@@ -127,5 +279,8 @@ func (pg *PostGIS) tableNames() []string {
 	for name := range pg.GeneralizedTables {
 		names = append(names, name)
 	}
+	for name := range pg.LandPolygons {
+		names = append(names, name, name+"_errors", name+"_water")
+	}
 	return names
 }
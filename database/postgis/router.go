@@ -2,7 +2,10 @@ package postgis
 
 import (
 	"database/sql"
+	"fmt"
 
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/priority"
 	"github.com/pkg/errors"
 )
 
@@ -10,6 +13,8 @@ import (
 type TxRouter struct {
 	Tables map[string]TableTx
 	tx     *sql.Tx
+	// workers update tables in parallel, with their own transactions
+	workers []*txWorker
 }
 
 func newTxRouter(pg *PostGIS, bulkImport bool) (*TxRouter, error) {
@@ -18,6 +23,12 @@ func newTxRouter(pg *PostGIS, bulkImport bool) (*TxRouter, error) {
 	}
 
 	if bulkImport {
+		// each bulkTableTx uses its own connection, as COPY needs the
+		// connection of pgconn and not a connection of the pool
+		purpose := fmt.Sprintf("bulk import of %d tables", len(pg.Tables))
+		if err := pg.Pool.reserve(pg.Db, len(pg.Tables), purpose); err != nil {
+			return nil, err
+		}
 		for tableName, table := range pg.Tables {
 			tt := NewBulkTableTx(pg, table)
 			err := tt.Begin(nil)
@@ -28,16 +39,54 @@ func newTxRouter(pg *PostGIS, bulkImport bool) (*TxRouter, error) {
 		}
 	} else {
 		tx, err := pg.Db.Begin()
+		err = pg.Retry.retry("begin postgis transaction", err, func() (err error) {
+			tx, err = pg.Db.Begin()
+			return err
+		})
 		if err != nil {
 			return nil, errors.Wrap(err, "begin postgis transaction")
 		}
 		txr.tx = tx
+		var workerOf map[string]*txWorker
+		if pg.Config.DiffWorkers > 1 {
+			workerOf, err = txr.startWorkers(pg)
+			if err != nil {
+				txr.Abort()
+				return nil, err
+			}
+		}
 		for tableName, table := range pg.Tables {
+			tableTx := tx
+			worker := workerOf[tableName]
+			if worker != nil {
+				tableTx = worker.tx
+			}
 			tt := NewSynchronousTableTx(pg, table.FullName, table)
-			err := tt.Begin(tx)
+			if pg.UpsertUpdates && table.upsertable() {
+				ok, err := hasUniqueIDIndex(tx, table.Schema, table)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					tt = newUpsertTableTx(pg, table)
+				} else {
+					log.Printf("[warn] missing unique OSM id index on %s, table is updated without upserts", table.FullName)
+				}
+			}
+			if pg.TableChanges {
+				if idCol := table.idColumn(); idCol >= 0 {
+					trackChanges(tt, idCol)
+				} else {
+					log.Printf("[warn] table %s has no OSM id column, changes are not recorded", table.FullName)
+				}
+			}
+			err := tt.Begin(tableTx)
 			if err != nil {
 				return nil, errors.Wrapf(err, "begin postgis transaction for table %s", table.FullName)
 			}
+			if worker != nil {
+				tt = &asyncTableTx{TableTx: tt, worker: worker}
+			}
 			txr.Tables[tableName] = tt
 		}
 		for tableName, table := range pg.GeneralizedTables {
@@ -53,11 +102,47 @@ func newTxRouter(pg *PostGIS, bulkImport bool) (*TxRouter, error) {
 	return &txr, nil
 }
 
+// flushDeletes executes all deferred deletes of upsert tables.
+func (txr *TxRouter) flushDeletes() error {
+	for _, tt := range txr.Tables {
+		switch tt := tt.(type) {
+		case *upsertTableTx:
+			if err := tt.flushDeletes(); err != nil {
+				return err
+			}
+		case *asyncTableTx:
+			if utt, ok := tt.TableTx.(*upsertTableTx); ok {
+				// after all queued inserts of the table
+				if err := tt.worker.do(utt.flushDeletes); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
 func (txr *TxRouter) End() error {
 	if txr.tx != nil {
+		if err := txr.flushDeletes(); err != nil {
+			return err
+		}
+		for _, w := range txr.workers {
+			if err := w.wait(); err != nil {
+				return err
+			}
+		}
+		if err := txr.writeChanges(); err != nil {
+			return err
+		}
 		for _, tt := range txr.Tables {
 			tt.End()
 		}
+		for _, w := range txr.workers {
+			if err := w.tx.Commit(); err != nil {
+				return err
+			}
+		}
 		return txr.tx.Commit()
 	}
 
@@ -71,6 +156,10 @@ func (txr *TxRouter) End() error {
 
 func (txr *TxRouter) Abort() error {
 	if txr.tx != nil {
+		for _, w := range txr.workers {
+			w.wait()
+			w.tx.Rollback()
+		}
 		for _, tt := range txr.Tables {
 			tt.End()
 		}
@@ -87,6 +176,7 @@ func (txr *TxRouter) Insert(table string, row []interface{}) error {
 	if !ok {
 		return errors.New("Insert into unknown table " + table)
 	}
+	priority.WaitRows(1)
 	return tt.Insert(row)
 }
 
@@ -95,5 +185,6 @@ func (txr *TxRouter) Delete(table string, id int64) error {
 	if !ok {
 		return errors.New("Delete from unknown table " + table)
 	}
+	priority.WaitRows(1)
 	return tt.Delete(id)
 }
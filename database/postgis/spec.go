@@ -1,27 +1,64 @@
 package postgis
 
 import (
+	"encoding/hex"
 	"fmt"
 	"strings"
 
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/geom/ewkb"
 	"github.com/omniscale/imposm3/mapping"
 	"github.com/omniscale/imposm3/mapping/config"
+	"github.com/omniscale/imposm3/proj"
 	"github.com/pkg/errors"
 )
 
 type ColumnSpec struct {
-	Name      string
-	FieldType mapping.ColumnType
-	Type      ColumnType
+	Name        string
+	FieldType   mapping.ColumnType
+	Type        ColumnType
+	Description string
 }
 type TableSpec struct {
-	Name            string
-	FullName        string
-	Schema          string
-	Columns         []ColumnSpec
-	GeometryType    string
-	Srid            int
-	Generalizations []*GeneralizedTableSpec
+	Name     string
+	FullName string
+	// Schema is the schema for the import, ProductionSchema and
+	// BackupSchema are used by Deploy.
+	Schema           string
+	ProductionSchema string
+	BackupSchema     string
+	Columns          []ColumnSpec
+	GeometryType     string
+	Srid             int
+	Generalizations  []*GeneralizedTableSpec
+	Partition        *PartitionSpec
+	Tablespace       string
+	IndexTablespace  string
+	Hooks            config.TableHooks
+	GeometryIndex    string
+	Indexes          []IndexSpec
+	Cluster          string
+	Unlogged         bool
+	Description      string
+	// Geography is set for tables that store their geometries as geography.
+	Geography bool
+	// geographyProj is the projection of the geometries of geography tables,
+	// nil if they are already in EPSG:4326.
+	geographyProj proj.Projection
+	// Elevation is set for tables with 3D geometries.
+	Elevation bool
+	// Tiles limits the zoom levels of the table in tile server configs.
+	Tiles *config.TableTiles
+	// nonUniqueIDs is set for tables with multiple rows for the same OSM id.
+	nonUniqueIDs bool
+}
+
+// PartitionSpec describes a table that is created as a partitioned table
+// with Partitions hash partitions on Key.
+type PartitionSpec struct {
+	Key        string
+	Columns    []string // columns used by Key
+	Partitions int
 }
 
 type GeneralizedTableSpec struct {
@@ -31,10 +68,32 @@ type GeneralizedTableSpec struct {
 	SourceName        string
 	Source            *TableSpec
 	SourceGeneralized *GeneralizedTableSpec
+	ProductionSchema  string
+	BackupSchema      string
 	Tolerance         float64
 	Where             string
 	created           bool
 	Generalizations   []*GeneralizedTableSpec
+	Tablespace        string
+	IndexTablespace   string
+	Hooks             config.TableHooks
+	GeometryIndex     string
+	Indexes           []IndexSpec
+	Cluster           string
+	Unlogged          bool
+	Description       string
+	// PolygonOrientation forces the orientation of the generalized
+	// polygons (rfc7946 or postgis), see config.Mapping.
+	PolygonOrientation string
+	// SimplifyAlgorithm is douglas-peucker (default) or visvalingam.
+	SimplifyAlgorithm string
+}
+
+// IndexSpec describes an additional index of a table.
+type IndexSpec struct {
+	Name    string
+	Method  string
+	Columns []string
 }
 
 func (col *ColumnSpec) AsSQL() string {
@@ -62,6 +121,15 @@ func (spec *TableSpec) CreateTableSQL() string {
 
 	for _, col := range spec.Columns {
 		if col.Type.Name() == "GEOMETRY" {
+			if spec.Partition != nil {
+				// The partition key can reference the geometry, so we can't
+				// add it later with AddGeometryColumn.
+				colType := fmt.Sprintf("geometry(%s, %d)", spec.geometryTypeSQL(), spec.Srid)
+				if spec.Geography {
+					colType = fmt.Sprintf("geography(%s, 4326)", spec.geometryTypeSQL())
+				}
+				cols = append(cols, fmt.Sprintf(`"%s" %s`, col.Name, colType))
+			}
 			continue
 		}
 		cols = append(cols, col.AsSQL())
@@ -69,20 +137,160 @@ func (spec *TableSpec) CreateTableSQL() string {
 
 	// Make composite PRIMARY KEY of serial `id` and OSM ID. But only if the
 	// user did not provide a custom `id` colum which might not be unique.
-	if pkCols != nil && !foundIDCol {
-		cols = append(cols, `PRIMARY KEY ("`+strings.Join(pkCols, `", "`)+`")`)
+	if pkCols != nil && !foundIDCol && spec.hasPrimaryKey() {
+		pk := `PRIMARY KEY ("` + strings.Join(pkCols, `", "`) + `")`
+		if spec.IndexTablespace != "" {
+			pk += " USING INDEX" + tablespaceSQL(spec.IndexTablespace)
+		}
+		cols = append(cols, pk)
 	}
 	columnSQL := strings.Join(cols, ",\n")
+	partitionSQL := ""
+	unlogged := spec.Unlogged
+	if spec.Partition != nil {
+		partitionSQL = " PARTITION BY HASH (" + spec.Partition.Key + ")"
+		// partitioned tables can't be unlogged, only their partitions
+		unlogged = false
+	}
 	return fmt.Sprintf(`
-        CREATE TABLE IF NOT EXISTS "%s"."%s" (
+        CREATE%s TABLE IF NOT EXISTS "%s"."%s" (
             %s
-        );`,
+        )%s%s;`,
+		unloggedSQL(unlogged),
 		spec.Schema,
 		spec.FullName,
 		columnSQL,
+		partitionSQL,
+		tablespaceSQL(spec.Tablespace),
 	)
 }
 
+// hasSerialID returns whether the table has the serial `id` column, that
+// is only created if no `id` column is configured.
+func (spec *TableSpec) hasSerialID() bool {
+	for _, cs := range spec.Columns {
+		if cs.Name == "id" {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPrimaryKey returns whether the table has a composite PRIMARY KEY of
+// serial `id` and OSM ID. The PRIMARY KEY of partitioned tables needs to
+// include all columns of the partition key.
+func (spec *TableSpec) hasPrimaryKey() bool {
+	pkCols := map[string]bool{"id": true}
+	for _, cs := range spec.Columns {
+		if cs.Name == "id" {
+			return false
+		}
+		if cs.FieldType.Name == "id" {
+			pkCols[cs.Name] = true
+		}
+	}
+	if spec.Partition != nil {
+		if len(spec.Partition.Columns) == 0 {
+			return false
+		}
+		for _, c := range spec.Partition.Columns {
+			if !pkCols[c] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// geometryTypeSQL returns the geometry type of the table, with a Z suffix
+// for tables with elevation.
+func (spec *TableSpec) geometryTypeSQL() string {
+	geomType, dims := spec.geometryTypeDims()
+	if dims == 3 {
+		geomType += "Z"
+	}
+	return geomType
+}
+
+// geometryTypeDims returns the 2D geometry type and the number of
+// dimensions of the table.
+func (spec *TableSpec) geometryTypeDims() (string, int) {
+	geomType := strings.ToUpper(spec.GeometryType)
+	if geomType == "POLYGON" {
+		geomType = "GEOMETRY" // for multipolygon support
+	}
+	if spec.Elevation {
+		return geomType, 3
+	}
+	return geomType, 2
+}
+
+// geographyRow transforms the geometries of the row to EPSG:4326, for
+// geography tables of imports with a different SRID. The geometries are
+// transformed before the insert, as COPY can't transform them.
+func (spec *TableSpec) geographyRow(row []interface{}) error {
+	if spec.geographyProj == nil {
+		return nil
+	}
+	for i, col := range spec.Columns {
+		if col.Type.Name() != "GEOMETRY" {
+			continue
+		}
+		wkb, ok := row[i].(string)
+		if !ok || wkb == "" {
+			continue
+		}
+		g, err := ewkb.DecodeHex([]byte(wkb))
+		if err != nil {
+			return errors.Wrap(err, "decoding geometry for geography")
+		}
+		toWgs(g, spec.geographyProj)
+		g.SRID = 4326
+		row[i] = hex.EncodeToString(g.EWKB())
+	}
+	return nil
+}
+
+// toWgs transforms all coordinates of g from the projection p to
+// EPSG:4326.
+func toWgs(g *ewkb.Geometry, p proj.Projection) {
+	for i, c := range g.Coords {
+		g.Coords[i].X, g.Coords[i].Y = p.Inverse(c.X, c.Y)
+	}
+	for _, r := range g.Rings {
+		for i, c := range r {
+			r[i].X, r[i].Y = p.Inverse(c.X, c.Y)
+		}
+	}
+	for i := range g.Geoms {
+		toWgs(&g.Geoms[i], p)
+	}
+}
+
+// PartitionName returns the name of the n-th partition.
+func (spec *TableSpec) PartitionName(n int) string {
+	return fmt.Sprintf("%s_p%d", spec.FullName, n)
+}
+
+// CreatePartitionsSQL returns the statements to create all partitions.
+func (spec *TableSpec) CreatePartitionsSQL() []string {
+	if spec.Partition == nil {
+		return nil
+	}
+	var stmts []string
+	for i := 0; i < spec.Partition.Partitions; i++ {
+		stmts = append(stmts, fmt.Sprintf(
+			`CREATE%s TABLE "%s"."%s" PARTITION OF "%s"."%s" FOR VALUES WITH (MODULUS %d, REMAINDER %d)%s`,
+			unloggedSQL(spec.Unlogged),
+			spec.Schema, spec.PartitionName(i),
+			spec.Schema, spec.FullName,
+			spec.Partition.Partitions, i,
+			tablespaceSQL(spec.Tablespace),
+		))
+	}
+	return stmts
+}
+
 func (spec *TableSpec) InsertSQL() string {
 	var cols []string
 	var vars []string
@@ -102,6 +310,34 @@ func (spec *TableSpec) InsertSQL() string {
 	)
 }
 
+// commentSQL returns the COMMENT statements for a table and its columns.
+// Tables and columns without description are skipped.
+func commentSQL(schema, table, description string, columns []ColumnSpec) []string {
+	var stmts []string
+	if description != "" {
+		stmts = append(stmts, fmt.Sprintf(`COMMENT ON TABLE "%s"."%s" IS %s`,
+			schema, table, quoteLiteral(description)))
+	}
+	for _, col := range columns {
+		if col.Description != "" {
+			stmts = append(stmts, fmt.Sprintf(`COMMENT ON COLUMN "%s"."%s"."%s" IS %s`,
+				schema, table, col.Name, quoteLiteral(col.Description)))
+		}
+	}
+	return stmts
+}
+
+// CommentSQL returns the COMMENT statements for the table and its columns.
+func (spec *TableSpec) CommentSQL() []string {
+	return commentSQL(spec.Schema, spec.FullName, spec.Description, spec.Columns)
+}
+
+// CommentSQL returns the COMMENT statements for the table and its columns.
+// Columns are described like the columns of the source table.
+func (spec *GeneralizedTableSpec) CommentSQL() []string {
+	return commentSQL(spec.Schema, spec.FullName, spec.Description, spec.Source.Columns)
+}
+
 func (spec *TableSpec) CopySQL() string {
 	var cols []string
 	for _, col := range spec.Columns {
@@ -109,13 +345,23 @@ func (spec *TableSpec) CopySQL() string {
 	}
 	columns := strings.Join(cols, ", ")
 
-	return fmt.Sprintf(`COPY "%s"."%s" (%s) FROM STDIN`,
+	return fmt.Sprintf(`COPY "%s"."%s" (%s) FROM STDIN (FORMAT binary)`,
 		spec.Schema,
 		spec.FullName,
 		columns,
 	)
 }
 
+// CopyTextSQL returns the COPY statement for rows in the text format.
+func (spec *TableSpec) CopyTextSQL() string {
+	var cols []string
+	for _, col := range spec.Columns {
+		cols = append(cols, "\""+col.Name+"\"")
+	}
+	return fmt.Sprintf(`COPY "%s"."%s" (%s) FROM stdin`,
+		spec.Schema, spec.FullName, strings.Join(cols, ", "))
+}
+
 func (spec *TableSpec) DeleteSQL() string {
 	var idColumnName string
 	for _, col := range spec.Columns {
@@ -136,20 +382,52 @@ func (spec *TableSpec) DeleteSQL() string {
 	)
 }
 
+// clipsIntoParts returns whether the geometries of the table are clipped to
+// the -limitto geometry and can be inserted in multiple rows with the same
+// OSM id. Nodes and tables that are limited by the centroid are not
+// clipped.
+func clipsIntoParts(pg *PostGIS, t *config.Table) bool {
+	return pg.Config.ClipLimitTo && t.LimitTo != "centroid" && mapping.TableType(t.Type) != mapping.PointTable
+}
+
 func NewTableSpec(pg *PostGIS, t *config.Table) (*TableSpec, error) {
 	var geomType string
-	if mapping.TableType(t.Type) == mapping.RelationMemberTable {
+	if mapping.TableType(t.Type) == mapping.RelationMemberTable || t.MergeLines ||
+		mapping.TableType(t.Type) == mapping.LineStringTable && t.Antimeridian == geom.AntimeridianSplit {
 		geomType = "geometry"
+	} else if t.LabelPoint {
+		geomType = "point"
 	} else {
 		geomType = string(t.Type)
 	}
 
 	spec := TableSpec{
-		Name:         t.Name,
-		FullName:     pg.Prefix + t.Name,
-		Schema:       pg.Config.ImportSchema,
-		GeometryType: geomType,
-		Srid:         pg.Config.Srid,
+		Name:            t.Name,
+		FullName:        pg.Prefix + t.Name,
+		GeometryType:    geomType,
+		Srid:            pg.Config.Srid,
+		Tablespace:      pg.Tablespace,
+		IndexTablespace: pg.IndexTablespace,
+		Hooks:           t.TableHooks,
+		Unlogged:        pg.Config.Unlogged,
+		Description:     t.Description,
+		Geography:       t.Geography,
+		Elevation:       t.Elevation,
+		Tiles:           t.Tiles,
+		nonUniqueIDs:    mapping.TableType(t.Type) == mapping.RelationMemberTable || t.Subdivide > 0 || clipsIntoParts(pg, t),
+	}
+	spec.Schema, spec.ProductionSchema, spec.BackupSchema = pg.tableSchemas(t.Schemas)
+	if spec.Geography && spec.Srid != 4326 {
+		spec.geographyProj = proj.Lookup(spec.Srid)
+		if spec.geographyProj == nil {
+			return nil, errors.Errorf("geography table with unsupported srid %d", spec.Srid)
+		}
+	}
+	if t.Tablespace != "" {
+		spec.Tablespace = t.Tablespace
+	}
+	if t.IndexTablespace != "" {
+		spec.IndexTablespace = t.IndexTablespace
 	}
 	for _, column := range t.Columns {
 		columnType, err := mapping.MakeColumnType(column)
@@ -160,22 +438,245 @@ func NewTableSpec(pg *PostGIS, t *config.Table) (*TableSpec, error) {
 		if !ok {
 			return nil, errors.Errorf("unhandled column type %v, using string type", columnType)
 		}
-		col := ColumnSpec{column.Name, *columnType, pgType}
+		col := ColumnSpec{column.Name, *columnType, pgType, column.Description}
 		spec.Columns = append(spec.Columns, col)
 	}
+	var err error
+	spec.GeometryIndex, spec.Indexes, err = newIndexSpecs(spec.FullName, t.GeometryIndex, t.Indexes)
+	if err != nil {
+		return nil, errors.Wrap(err, "indexes")
+	}
+	if err := checkIndexColumns(spec.Indexes, spec.Columns); err != nil {
+		return nil, errors.Wrap(err, "indexes")
+	}
+	spec.Cluster = t.Cluster
+	if err := checkCluster(spec.Cluster, spec.GeometryIndex); err != nil {
+		return nil, err
+	}
+	if spec.Cluster == "geometry" && t.PartitionBy != nil {
+		return nil, errors.New("partitioned tables can't be clustered on geometry, use cluster: geohash")
+	}
+	if t.PartitionBy != nil {
+		spec.Partition, err = newPartitionSpec(&spec, t.PartitionBy)
+		if err != nil {
+			return nil, errors.Wrap(err, "partition_by")
+		}
+		if spec.Geography && t.PartitionBy.Method == "grid" {
+			return nil, errors.New("geography tables can't be partitioned by grid")
+		}
+	}
 	return &spec, nil
 }
 
-func NewGeneralizedTableSpec(pg *PostGIS, t *config.GeneralizedTable) *GeneralizedTableSpec {
+func newPartitionSpec(spec *TableSpec, p *config.TablePartition) (*PartitionSpec, error) {
+	if p.Partitions < 2 {
+		return nil, errors.Errorf("partitions needs to be 2 or larger, got %d", p.Partitions)
+	}
+	switch p.Method {
+	case "hash", "":
+		colName := p.Column
+		if colName == "" {
+			for _, col := range spec.Columns {
+				if col.FieldType.Name == "id" {
+					colName = col.Name
+					break
+				}
+			}
+		}
+		for _, col := range spec.Columns {
+			if col.Name == colName && col.Type.Name() != "GEOMETRY" {
+				return &PartitionSpec{
+					Key:        `"` + colName + `"`,
+					Columns:    []string{colName},
+					Partitions: p.Partitions,
+				}, nil
+			}
+		}
+		return nil, errors.Errorf("missing column %q for hash partitioning", colName)
+	case "grid":
+		if p.GridSize <= 0 {
+			return nil, errors.New("grid partitioning requires grid_size")
+		}
+		for _, col := range spec.Columns {
+			if col.Type.Name() == "GEOMETRY" {
+				return &PartitionSpec{
+					Key: fmt.Sprintf(`(floor(ST_XMin("%[1]s") / %[2]v)), (floor(ST_YMin("%[1]s") / %[2]v))`,
+						col.Name, p.GridSize),
+					Partitions: p.Partitions,
+				}, nil
+			}
+		}
+		return nil, errors.New("grid partitioning requires a geometry column")
+	default:
+		return nil, errors.Errorf("unknown partitioning method %q", p.Method)
+	}
+}
+
+func NewGeneralizedTableSpec(pg *PostGIS, t *config.GeneralizedTable) (*GeneralizedTableSpec, error) {
 	spec := GeneralizedTableSpec{
-		Name:       t.Name,
-		FullName:   pg.Prefix + t.Name,
-		Schema:     pg.Config.ImportSchema,
-		Tolerance:  t.Tolerance,
-		Where:      t.SQLFilter,
-		SourceName: t.SourceTableName,
-	}
-	return &spec
+		Name:              t.Name,
+		FullName:          pg.Prefix + t.Name,
+		Tolerance:         t.Tolerance,
+		Where:             t.SQLFilter,
+		SourceName:        t.SourceTableName,
+		SimplifyAlgorithm: t.SimplifyAlgorithm,
+		Tablespace:        pg.Tablespace,
+		IndexTablespace:   pg.IndexTablespace,
+		Hooks:             t.TableHooks,
+		Unlogged:          pg.Config.Unlogged,
+		Description:       t.Description,
+	}
+	spec.Schema, spec.ProductionSchema, spec.BackupSchema = pg.tableSchemas(t.Schemas)
+	if t.Tablespace != "" {
+		spec.Tablespace = t.Tablespace
+	}
+	if t.IndexTablespace != "" {
+		spec.IndexTablespace = t.IndexTablespace
+	}
+	var err error
+	spec.GeometryIndex, spec.Indexes, err = newIndexSpecs(spec.FullName, t.GeometryIndex, t.Indexes)
+	if err != nil {
+		return nil, errors.Wrap(err, "indexes")
+	}
+	spec.Cluster = t.Cluster
+	if err := checkCluster(spec.Cluster, spec.GeometryIndex); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// checkCluster checks the cluster option. Only GIST indices can be used
+// for clustering.
+func checkCluster(cluster, geometryIndex string) error {
+	switch cluster {
+	case "", "geohash":
+		return nil
+	case "geometry":
+		if geometryIndex != "gist" {
+			return errors.Errorf("can't cluster on %s geometry index, use cluster: geohash", geometryIndex)
+		}
+		return nil
+	}
+	return errors.Errorf("unsupported cluster method %q", cluster)
+}
+
+// tableSchemas returns the import, production and backup schema of a
+// table.
+func (pg *PostGIS) tableSchemas(s *config.TableSchemas) (string, string, string) {
+	imp, prod, backup := pg.Config.ImportSchema, pg.Config.ProductionSchema, pg.Config.BackupSchema
+	if s == nil {
+		return imp, prod, backup
+	}
+	// diff imports are applied to the production schema
+	diff := imp == prod
+	if s.Import != "" {
+		imp = s.Import
+	}
+	if s.Production != "" {
+		prod = s.Production
+	}
+	if s.Backup != "" {
+		backup = s.Backup
+	}
+	if diff {
+		imp = prod
+	}
+	return imp, prod, backup
+}
+
+var indexMethods = map[string]bool{
+	"btree":  true,
+	"hash":   true,
+	"gist":   true,
+	"spgist": true,
+	"gin":    true,
+	"brin":   true,
+}
+
+// newIndexSpecs returns the method of the geometry index (gist by default)
+// and the specs of all additional indices.
+func newIndexSpecs(tableName, geometryIndex string, indexes []config.TableIndex) (string, []IndexSpec, error) {
+	switch geometryIndex {
+	case "":
+		geometryIndex = "gist"
+	case "gist", "spgist", "brin":
+	default:
+		return "", nil, errors.Errorf("unsupported geometry_index method %q", geometryIndex)
+	}
+
+	var specs []IndexSpec
+	names := make(map[string]bool)
+	for _, idx := range indexes {
+		if len(idx.Columns) == 0 {
+			return "", nil, errors.New("missing columns for index")
+		}
+		method := strings.ToLower(idx.Method)
+		if method == "" {
+			method = "btree"
+		}
+		if !indexMethods[method] {
+			return "", nil, errors.Errorf("unsupported index method %q", idx.Method)
+		}
+		name := fmt.Sprintf("%s_%s_%s", tableName, strings.Join(idx.Columns, "_"), method)
+		if names[name] {
+			return "", nil, errors.Errorf("duplicate %s index on %v", method, idx.Columns)
+		}
+		names[name] = true
+		specs = append(specs, IndexSpec{Name: name, Method: method, Columns: idx.Columns})
+	}
+	return geometryIndex, specs, nil
+}
+
+// checkIndexColumns checks that all indexed columns are columns of the
+// table.
+func checkIndexColumns(indexes []IndexSpec, columns []ColumnSpec) error {
+	for _, idx := range indexes {
+	cols:
+		for _, name := range idx.Columns {
+			for _, col := range columns {
+				if col.Name == name {
+					continue cols
+				}
+			}
+			return errors.Errorf("unknown column %q for index %s", name, idx.Name)
+		}
+	}
+	return nil
+}
+
+// CreateSQL returns the CREATE INDEX statement for the index on table. The
+// index is only created if it does not exist.
+func (idx *IndexSpec) CreateSQL(schema, table, tablespace string) string {
+	cols := make([]string, len(idx.Columns))
+	for i, col := range idx.Columns {
+		cols[i] = `"` + col + `"`
+	}
+	return fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s" ON "%s"."%s" USING %s (%s)%s`,
+		idx.Name, schema, table, strings.ToUpper(idx.Method), strings.Join(cols, ", "), tablespaceSQL(tablespace))
+}
+
+// CreateTableSQL returns the CREATE TABLE AS statement that generalizes
+// the source table.
+func (spec *GeneralizedTableSpec) CreateTableSQL() string {
+	var where string
+	if spec.Where != "" {
+		where = " WHERE " + spec.Where
+	}
+	var cols []string
+	for _, col := range spec.Source.Columns {
+		cols = append(cols, col.Type.GeneralizeSQL(&col, spec))
+	}
+	columnSQL := strings.Join(cols, ",\n")
+
+	var sourceSchema, sourceTable string
+	if spec.SourceGeneralized != nil {
+		sourceSchema, sourceTable = spec.SourceGeneralized.Schema, spec.SourceGeneralized.FullName
+	} else {
+		sourceSchema, sourceTable = spec.Source.Schema, spec.Source.FullName
+	}
+	return fmt.Sprintf(`CREATE%s TABLE "%s"."%s"%s AS (SELECT %s FROM "%s"."%s"%s)`,
+		unloggedSQL(spec.Unlogged), spec.Schema, spec.FullName, tablespaceSQL(spec.Tablespace),
+		columnSQL, sourceSchema, sourceTable, where)
 }
 
 func (spec *GeneralizedTableSpec) DeleteSQL() string {
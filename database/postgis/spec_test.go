@@ -0,0 +1,539 @@
+package postgis
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/geom/ewkb"
+	"github.com/omniscale/imposm3/mapping/config"
+	"gopkg.in/yaml.v2"
+)
+
+func testTableSpec(partition *config.TablePartition) (*TableSpec, error) {
+	pg := &PostGIS{
+		Prefix: "osm_",
+		Config: database.Config{ImportSchema: "import", Srid: 3857},
+	}
+	return NewTableSpec(pg, &config.Table{
+		Name: "roads",
+		Type: "linestring",
+		Columns: []*config.Column{
+			{Name: "osm_id", Type: "id"},
+			{Name: "geometry", Type: "geometry"},
+			{Name: "name", Type: "string", Key: "name"},
+		},
+		PartitionBy: partition,
+	})
+}
+
+func TestPartitionHash(t *testing.T) {
+	spec, err := testTableSpec(&config.TablePartition{Method: "hash", Partitions: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql := spec.CreateTableSQL()
+	for _, part := range []string{
+		`"geometry" geometry(LINESTRING, 3857)`,
+		`PRIMARY KEY ("osm_id", "id")`,
+		`) PARTITION BY HASH ("osm_id");`,
+	} {
+		if !strings.Contains(sql, part) {
+			t.Errorf("missing %q in %s", part, sql)
+		}
+	}
+
+	stmts := spec.CreatePartitionsSQL()
+	if len(stmts) != 4 {
+		t.Fatalf("unexpected partitions %v", stmts)
+	}
+	if stmts[3] != `CREATE TABLE "import"."osm_roads_p3" PARTITION OF "import"."osm_roads" FOR VALUES WITH (MODULUS 4, REMAINDER 3)` {
+		t.Errorf("unexpected partition %s", stmts[3])
+	}
+
+	// primary key needs to include the partition column
+	spec, err = testTableSpec(&config.TablePartition{Column: "name", Partitions: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.hasPrimaryKey() || strings.Contains(spec.CreateTableSQL(), "PRIMARY KEY") {
+		t.Errorf("unexpected primary key %s", spec.CreateTableSQL())
+	}
+}
+
+func TestPartitionGrid(t *testing.T) {
+	spec, err := testTableSpec(&config.TablePartition{Method: "grid", Partitions: 8, GridSize: 50000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql := spec.CreateTableSQL()
+	if !strings.Contains(sql, `PARTITION BY HASH ((floor(ST_XMin("geometry") / 50000)), (floor(ST_YMin("geometry") / 50000)))`) {
+		t.Errorf("unexpected partition key in %s", sql)
+	}
+	if spec.hasPrimaryKey() {
+		t.Error("grid partitioned table should not have a primary key")
+	}
+}
+
+func TestPartitionErrors(t *testing.T) {
+	for _, p := range []*config.TablePartition{
+		{Method: "hash", Partitions: 1},
+		{Method: "hash", Column: "unknown", Partitions: 4},
+		{Method: "hash", Column: "geometry", Partitions: 4},
+		{Method: "grid", Partitions: 4},
+		{Method: "range", Partitions: 4},
+	} {
+		if _, err := testTableSpec(p); err == nil {
+			t.Errorf("expected error for %#v", p)
+		}
+	}
+}
+
+func TestNoPartition(t *testing.T) {
+	spec, err := testTableSpec(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql := spec.CreateTableSQL()
+	if strings.Contains(sql, "geometry") || strings.Contains(sql, "PARTITION") {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+	if !spec.hasPrimaryKey() || spec.CreatePartitionsSQL() != nil {
+		t.Error("unexpected partition spec")
+	}
+	if !spec.hasSerialID() {
+		t.Error("expected serial id")
+	}
+}
+
+func TestTablespace(t *testing.T) {
+	pg := &PostGIS{
+		Prefix:          "osm_",
+		Config:          database.Config{ImportSchema: "import", Srid: 3857},
+		Tablespace:      "bulk",
+		IndexTablespace: "fast",
+	}
+	spec, err := NewTableSpec(pg, &config.Table{
+		Name:    "roads",
+		Type:    "linestring",
+		Columns: []*config.Column{{Name: "osm_id", Type: "id"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql := spec.CreateTableSQL()
+	if !strings.Contains(sql, `PRIMARY KEY ("osm_id", "id") USING INDEX TABLESPACE "fast"`) ||
+		!strings.HasSuffix(sql, `) TABLESPACE "bulk";`) {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+
+	spec, err = NewTableSpec(pg, &config.Table{
+		Name:       "roads",
+		Type:       "linestring",
+		Columns:    []*config.Column{{Name: "osm_id", Type: "id"}},
+		Tablespace: "other",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Tablespace != "other" || spec.IndexTablespace != "fast" {
+		t.Errorf("unexpected tablespaces %q %q", spec.Tablespace, spec.IndexTablespace)
+	}
+
+	params, ts := stripParamFromConnectionParams("host=localhost tablespace=bulk dbname=osm", "tablespace")
+	if params != "host=localhost dbname=osm" || ts != "bulk" {
+		t.Errorf("unexpected params %q %q", params, ts)
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	pg := &PostGIS{
+		Prefix:          "osm_",
+		Config:          database.Config{ImportSchema: "import", Srid: 3857},
+		IndexTablespace: "fast",
+	}
+	table := &config.Table{
+		Name: "roads",
+		Type: "linestring",
+		Columns: []*config.Column{
+			{Name: "osm_id", Type: "id"},
+			{Name: "geometry", Type: "geometry"},
+			{Name: "type", Type: "mapping_value"},
+		},
+		GeometryIndex: "spgist",
+		Indexes: []config.TableIndex{
+			{Columns: []string{"type"}},
+			{Columns: []string{"geometry"}, Method: "BRIN"},
+		},
+	}
+	spec, err := NewTableSpec(pg, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.GeometryIndex != "spgist" || len(spec.Indexes) != 2 {
+		t.Fatalf("unexpected indexes %q %v", spec.GeometryIndex, spec.Indexes)
+	}
+	if sql := spec.Indexes[0].CreateSQL(spec.Schema, spec.FullName, spec.IndexTablespace); sql != `CREATE INDEX IF NOT EXISTS "osm_roads_type_btree" ON "import"."osm_roads" USING BTREE ("type") TABLESPACE "fast"` {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+	if sql := spec.Indexes[1].CreateSQL(spec.Schema, spec.FullName, ""); sql != `CREATE INDEX IF NOT EXISTS "osm_roads_geometry_brin" ON "import"."osm_roads" USING BRIN ("geometry")` {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+
+	table.GeometryIndex = ""
+	table.Indexes = nil
+	spec, err = NewTableSpec(pg, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.GeometryIndex != "gist" || len(spec.Indexes) != 0 {
+		t.Errorf("unexpected indexes %q %v", spec.GeometryIndex, spec.Indexes)
+	}
+
+	for _, tc := range []struct {
+		geometryIndex string
+		indexes       []config.TableIndex
+	}{
+		{"rtree", nil},
+		{"", []config.TableIndex{{Columns: []string{"type"}, Method: "bitmap"}}},
+		{"", []config.TableIndex{{Method: "btree"}}},
+		{"", []config.TableIndex{{Columns: []string{"unknown"}}}},
+		{"", []config.TableIndex{{Columns: []string{"type"}}, {Columns: []string{"type"}, Method: "btree"}}},
+	} {
+		table.GeometryIndex = tc.geometryIndex
+		table.Indexes = tc.indexes
+		if _, err := NewTableSpec(pg, table); err == nil {
+			t.Errorf("expected error for %q %v", tc.geometryIndex, tc.indexes)
+		}
+	}
+}
+
+func TestCluster(t *testing.T) {
+	spec, err := testTableSpec(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Cluster != "" {
+		t.Errorf("unexpected cluster %q", spec.Cluster)
+	}
+
+	for _, tc := range []struct {
+		cluster       string
+		geometryIndex string
+		partition     *config.TablePartition
+		ok            bool
+	}{
+		{"geometry", "", nil, true},
+		{"geohash", "brin", nil, true},
+		{"geometry", "spgist", nil, false},
+		{"geometry", "", &config.TablePartition{Partitions: 4}, false},
+		{"geohash", "", &config.TablePartition{Partitions: 4}, true},
+		{"hilbert", "", nil, false},
+	} {
+		pg := &PostGIS{Config: database.Config{ImportSchema: "import", Srid: 3857}}
+		_, err := NewTableSpec(pg, &config.Table{
+			Name: "roads",
+			Type: "linestring",
+			Columns: []*config.Column{
+				{Name: "osm_id", Type: "id"},
+				{Name: "geometry", Type: "geometry"},
+			},
+			GeometryIndex: tc.geometryIndex,
+			Cluster:       tc.cluster,
+			PartitionBy:   tc.partition,
+		})
+		if (err == nil) != tc.ok {
+			t.Errorf("unexpected result for %#v: %v", tc, err)
+		}
+	}
+}
+
+func TestUnlogged(t *testing.T) {
+	pg := &PostGIS{
+		Prefix: "osm_",
+		Config: database.Config{ImportSchema: "import", Srid: 3857, Unlogged: true},
+	}
+	table := &config.Table{
+		Name:    "roads",
+		Type:    "linestring",
+		Columns: []*config.Column{{Name: "osm_id", Type: "id"}},
+	}
+	spec, err := NewTableSpec(pg, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql := spec.CreateTableSQL(); !strings.Contains(sql, `CREATE UNLOGGED TABLE IF NOT EXISTS "import"."osm_roads"`) {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+
+	table.PartitionBy = &config.TablePartition{Partitions: 2}
+	spec, err = NewTableSpec(pg, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql := spec.CreateTableSQL(); !strings.Contains(sql, `CREATE TABLE IF NOT EXISTS "import"."osm_roads"`) {
+		t.Errorf("partitioned table should not be unlogged %s", sql)
+	}
+	if stmts := spec.CreatePartitionsSQL(); !strings.HasPrefix(stmts[0], `CREATE UNLOGGED TABLE "import"."osm_roads_p0" PARTITION OF`) {
+		t.Errorf("unexpected SQL %s", stmts[0])
+	}
+}
+
+func TestTableSchemas(t *testing.T) {
+	pg := &PostGIS{
+		Prefix: "osm_",
+		Config: database.Config{ImportSchema: "import", ProductionSchema: "public", BackupSchema: "backup"},
+	}
+	table := &config.Table{
+		Name:    "roads",
+		Type:    "linestring",
+		Columns: []*config.Column{{Name: "osm_id", Type: "id"}},
+	}
+	spec, err := NewTableSpec(pg, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Schema != "import" || spec.ProductionSchema != "public" || spec.BackupSchema != "backup" {
+		t.Errorf("unexpected schemas %q %q %q", spec.Schema, spec.ProductionSchema, spec.BackupSchema)
+	}
+
+	table.Schemas = &config.TableSchemas{Import: "tenant_import", Production: "tenant"}
+	spec, err = NewTableSpec(pg, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Schema != "tenant_import" || spec.ProductionSchema != "tenant" || spec.BackupSchema != "backup" {
+		t.Errorf("unexpected schemas %q %q %q", spec.Schema, spec.ProductionSchema, spec.BackupSchema)
+	}
+	if sql := spec.CreateTableSQL(); !strings.Contains(sql, `"tenant_import"."osm_roads"`) {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+
+	// diff imports are applied to the production schema of the table
+	pg.Config.ImportSchema = "public"
+	spec, err = NewTableSpec(pg, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Schema != "tenant" || spec.ProductionSchema != "tenant" {
+		t.Errorf("unexpected schemas %q %q", spec.Schema, spec.ProductionSchema)
+	}
+}
+
+func TestBackupSchema(t *testing.T) {
+	if s := backupSchema("backup", 0); s != "backup" {
+		t.Error(s)
+	}
+	if s := backupSchema("backup", 2); s != "backup_2" {
+		t.Error(s)
+	}
+}
+
+func TestCommentSQL(t *testing.T) {
+	pg := &PostGIS{
+		Prefix: "osm_",
+		Config: database.Config{ImportSchema: "import", Srid: 3857},
+	}
+	spec, err := NewTableSpec(pg, &config.Table{
+		Name:        "roads",
+		Type:        "linestring",
+		Description: "All roads, incl. tracks",
+		Columns: []*config.Column{
+			{Name: "osm_id", Type: "id"},
+			{Name: "name", Type: "string", Key: "name", Description: "The road's name"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts := spec.CommentSQL()
+	if len(stmts) != 2 ||
+		stmts[0] != `COMMENT ON TABLE "import"."osm_roads" IS 'All roads, incl. tracks'` ||
+		stmts[1] != `COMMENT ON COLUMN "import"."osm_roads"."name" IS 'The road''s name'` {
+		t.Errorf("unexpected SQL %v", stmts)
+	}
+}
+
+type recordingExecer struct {
+	queries []string
+}
+
+func (r *recordingExecer) Exec(query string, args ...interface{}) (sql.Result, error) {
+	r.queries = append(r.queries, query)
+	return nil, nil
+}
+
+func TestExecHook(t *testing.T) {
+	db := &recordingExecer{}
+	if err := execHook(db, "after_import", " ", "import", "osm_roads"); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.queries) != 0 {
+		t.Fatalf("empty hook executed %v", db.queries)
+	}
+	err := execHook(db, "after_import", `GRANT SELECT ON "{{schema}}"."{{table}}" TO web`, "import", "osm_roads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.queries) != 1 || db.queries[0] != `GRANT SELECT ON "import"."osm_roads" TO web` {
+		t.Errorf("unexpected queries %v", db.queries)
+	}
+}
+
+func TestTableHooksMapping(t *testing.T) {
+	m := config.Mapping{}
+	err := yaml.Unmarshal([]byte(`
+tables:
+  roads:
+    type: linestring
+    after_create: ALTER TABLE {{table}} ADD COLUMN foo INT
+    after_import: ANALYZE {{table}}
+`), &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hooks := m.Tables["roads"].TableHooks
+	if hooks.BeforeCreate != "" || hooks.AfterCreate != "ALTER TABLE {{table}} ADD COLUMN foo INT" || hooks.AfterImport != "ANALYZE {{table}}" {
+		t.Errorf("unexpected hooks %#v", hooks)
+	}
+}
+
+func TestGeography(t *testing.T) {
+	for _, tc := range []struct {
+		srid int
+		x, y float64
+	}{
+		{3857, 1113194.9079327357, 6800125.454397307},
+		{4326, 10, 52},
+	} {
+		pg := &PostGIS{
+			Prefix: "osm_",
+			Config: database.Config{ImportSchema: "import", Srid: tc.srid},
+		}
+		spec, err := NewTableSpec(pg, &config.Table{
+			Name: "roads",
+			Type: "linestring",
+			Columns: []*config.Column{
+				{Name: "osm_id", Type: "id"},
+				{Name: "geometry", Type: "geometry"},
+				{Name: "name", Type: "string", Key: "name"},
+			},
+			Geography: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// geometries are inserted in EPSG:4326, without ST_Transform
+		if sql := spec.InsertSQL(); sql != `INSERT INTO "import"."osm_roads" ("osm_id", "geometry", "name") VALUES ($1, $2::Geometry::Geography, $3)` {
+			t.Errorf("unexpected SQL %s", sql)
+		}
+		if sql := addGeometryColumnSQL(spec.FullName, *spec); sql != `ALTER TABLE "import"."osm_roads" ADD COLUMN "geometry" geography(LINESTRING, 4326);` {
+			t.Errorf("unexpected SQL %s", sql)
+		}
+
+		g := ewkb.Geometry{Type: ewkb.LineString, SRID: tc.srid, Coords: []ewkb.Coord{{X: tc.x, Y: tc.y}, {X: tc.x, Y: tc.y}}}
+		row := []interface{}{int64(1), hex.EncodeToString(g.EWKB()), "name"}
+		if err := spec.geographyRow(row); err != nil {
+			t.Fatal(err)
+		}
+		wgs, err := ewkb.DecodeHex([]byte(row[1].(string)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := wgs.Coords[1]; wgs.SRID != 4326 || math.Abs(c.X-10) > 1e-8 || math.Abs(c.Y-52) > 1e-8 {
+			t.Errorf("unexpected geography %v", wgs)
+		}
+		if row[2] != "name" {
+			t.Errorf("unexpected row %v", row)
+		}
+		if !columnTypeMatches(spec.Columns[1], "geography(LineString,4326)", true) {
+			t.Error("geography column does not match")
+		}
+		if columnTypeMatches(spec.Columns[1], "geometry(LineString,3857)", true) {
+			t.Error("geometry column matches geography table")
+		}
+	}
+
+	pg := &PostGIS{Config: database.Config{ImportSchema: "import", Srid: 3857}}
+	_, err := NewTableSpec(pg, &config.Table{
+		Name: "roads",
+		Type: "linestring",
+		Columns: []*config.Column{
+			{Name: "osm_id", Type: "id"},
+			{Name: "geometry", Type: "geometry"},
+		},
+		Geography:   true,
+		PartitionBy: &config.TablePartition{Method: "grid", GridSize: 10000, Partitions: 4},
+	})
+	if err == nil {
+		t.Error("expected error for grid partitioned geography table")
+	}
+}
+
+func TestElevation(t *testing.T) {
+	pg := &PostGIS{
+		Prefix: "osm_",
+		Config: database.Config{ImportSchema: "import", Srid: 3857},
+	}
+	spec, err := NewTableSpec(pg, &config.Table{
+		Name: "roads",
+		Type: "linestring",
+		Columns: []*config.Column{
+			{Name: "osm_id", Type: "id"},
+			{Name: "geometry", Type: "geometry"},
+		},
+		Elevation:   true,
+		PartitionBy: &config.TablePartition{Partitions: 4},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql := spec.CreateTableSQL(); !strings.Contains(sql, `"geometry" geometry(LINESTRINGZ, 3857)`) {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+	if geomType, dims := spec.geometryTypeDims(); geomType != "LINESTRING" || dims != 3 {
+		t.Errorf("unexpected geometry type %s %d", geomType, dims)
+	}
+}
+
+func TestMemberPointColumn(t *testing.T) {
+	pg := &PostGIS{
+		Prefix: "osm_",
+		Config: database.Config{ImportSchema: "import", Srid: 3857},
+	}
+	spec, err := NewTableSpec(pg, &config.Table{
+		Name: "admin",
+		Type: "polygon",
+		Columns: []*config.Column{
+			{Name: "osm_id", Type: "id"},
+			{Name: "geometry", Type: "geometry"},
+			{Name: "admin_centre", Type: "member_point", Args: map[string]interface{}{"role": "admin_centre"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql := spec.CreateTableSQL(); !strings.Contains(sql, `"admin_centre" GEOMETRY(POINT)`) {
+		t.Errorf("missing point column in %s", sql)
+	}
+	if sql := addGeometryColumnSQL(spec.FullName, *spec); !strings.Contains(sql, "'geometry'") {
+		t.Errorf("unexpected geometry column %s", sql)
+	}
+	if !columnTypeMatches(spec.Columns[2], "geometry(Point)", false) {
+		t.Error("point column type does not match")
+	}
+}
+
+func TestSimplifySQL(t *testing.T) {
+	spec := &GeneralizedTableSpec{Tolerance: 10}
+	if sql := simplifySQL("geometry", spec); sql != `ST_SimplifyPreserveTopology("geometry", 10.000000)` {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+	spec.SimplifyAlgorithm = "visvalingam"
+	if sql := simplifySQL("geometry", spec); sql != `ST_SimplifyVW("geometry", 100.000000)` {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+}
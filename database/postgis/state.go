@@ -0,0 +1,112 @@
+package postgis
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/omniscale/go-osm/state"
+	"github.com/omniscale/imposm3/database"
+	"github.com/pkg/errors"
+)
+
+// The state of each diff import is stored in the imposm_state table of the
+// production schema, if the diff_state option is database or both. Each
+// import adds a row with the statistics of the import. The last row with a
+// timestamp is the state of the last imported diff. The table is not part
+// of the mapping and it is not rotated by deployments.
+
+const stateTable = "imposm_state"
+
+func stateCreateSQL(schema, table string) string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s"."%s" (
+    id BIGSERIAL PRIMARY KEY,
+    sequence INTEGER,
+    timestamp TIMESTAMPTZ,
+    replication_url TEXT,
+    created BIGINT NOT NULL DEFAULT 0,
+    modified BIGINT NOT NULL DEFAULT 0,
+    deleted BIGINT NOT NULL DEFAULT 0,
+    duration DOUBLE PRECISION,
+    imported_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`, schema, table)
+}
+
+func stateInsertSQL(schema, table string) string {
+	return fmt.Sprintf(`INSERT INTO "%s"."%s" (sequence, timestamp, replication_url, created, modified, deleted, duration)
+VALUES ($1, $2, $3, $4, $5, $6, $7)`, schema, table)
+}
+
+func stateSelectSQL(schema, table string) string {
+	return fmt.Sprintf(`SELECT sequence, timestamp, replication_url FROM "%s"."%s"
+WHERE timestamp IS NOT NULL ORDER BY id DESC LIMIT 1`, schema, table)
+}
+
+// stateValues returns the values for stateInsertSQL. The state values are
+// nil for diffs without state.
+func stateValues(s *state.DiffState, stats database.DiffStats) []interface{} {
+	values := []interface{}{nil, nil, nil}
+	if s != nil {
+		if s.Sequence != 0 {
+			values[0] = s.Sequence
+		}
+		if !s.Time.IsZero() {
+			values[1] = s.Time
+		}
+		if s.URL != "" {
+			values[2] = s.URL
+		}
+	}
+	return append(values, stats.Created, stats.Modified, stats.Deleted, stats.Duration.Seconds())
+}
+
+// LastState returns the state of the last imported diff from the state
+// table, or nil.
+func (pg *PostGIS) LastState() (*state.DiffState, error) {
+	schema := pg.Config.ProductionSchema
+	tx, err := pg.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	exists, err := tableExists(tx, schema, stateTable)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	var seq sql.NullInt64
+	var url sql.NullString
+	s := &state.DiffState{}
+	query := stateSelectSQL(schema, stateTable)
+	err = tx.QueryRow(query).Scan(&seq, &s.Time, &url)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &SQLError{query, err}
+	}
+	s.Sequence = int(seq.Int64)
+	s.URL = url.String
+	return s, nil
+}
+
+// WriteState inserts the state and the statistics of a diff import into
+// the state table, within the transaction of the diff import.
+func (pg *PostGIS) WriteState(s *state.DiffState, stats database.DiffStats) error {
+	schema := pg.Config.ProductionSchema
+
+	exec := pg.Db.Exec
+	if pg.txRouter != nil && pg.txRouter.tx != nil {
+		exec = pg.txRouter.tx.Exec
+	}
+
+	sql := stateCreateSQL(schema, stateTable)
+	if _, err := exec(sql); err != nil {
+		return &SQLError{sql, err}
+	}
+	sql = stateInsertSQL(schema, stateTable)
+	if _, err := exec(sql, stateValues(s, stats)...); err != nil {
+		return errors.Wrap(&SQLError{sql, err}, "writing diff state")
+	}
+	return nil
+}
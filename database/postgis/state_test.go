@@ -0,0 +1,28 @@
+package postgis
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/omniscale/go-osm/state"
+	"github.com/omniscale/imposm3/database"
+)
+
+func TestStateValues(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	stats := database.DiffStats{Created: 3, Modified: 2, Deleted: 1, Duration: 1500 * time.Millisecond}
+
+	values := stateValues(&state.DiffState{Time: ts, Sequence: 42, URL: "https://planet.osm.org/replication/minute"}, stats)
+	expected := []interface{}{42, ts, "https://planet.osm.org/replication/minute", int64(3), int64(2), int64(1), 1.5}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("unexpected values %v, expected %v", values, expected)
+	}
+
+	// diffs from stdin have no state
+	values = stateValues(nil, stats)
+	expected = []interface{}{nil, nil, nil, int64(3), int64(2), int64(1), 1.5}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("unexpected values %v, expected %v", values, expected)
+	}
+}
@@ -0,0 +1,185 @@
+package postgis
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The changed OSM ids of each table are stored in a <table>_changes table
+// (e.g. osm_roads_changes) of the production schema, if the table_changes
+// option is enabled. Each diff import adds one row for each changed id
+// with the action (insert, update or delete) and the sequence of the diff.
+// The rows are inserted in the transaction of the table. Rows older than
+// table_changes_retention are removed after each diff import. Generalized
+// tables are not recorded.
+
+const (
+	changeDeleted = 1 << iota
+	changeInserted
+	changeUpdated
+)
+
+// tableChanges collects the changed OSM ids of a table during a diff
+// import.
+type tableChanges struct {
+	idCol int
+	mu    sync.Mutex
+	ids   map[int64]int
+}
+
+func newTableChanges(idCol int) *tableChanges {
+	return &tableChanges{idCol: idCol, ids: make(map[int64]int)}
+}
+
+func (c *tableChanges) add(id int64, change int) {
+	c.mu.Lock()
+	c.ids[id] |= change
+	c.mu.Unlock()
+}
+
+// rowID returns the OSM id of an inserted row.
+func (c *tableChanges) rowID(row []interface{}) (int64, bool) {
+	id, ok := row[c.idCol].(int64)
+	return id, ok
+}
+
+// actions returns the sorted ids and the action of each id. Rows that were
+// deleted and inserted again are updated.
+func (c *tableChanges) actions() ([]int64, []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids := make([]int64, 0, len(c.ids))
+	for id := range c.ids {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	actions := make([]string, len(ids))
+	for i, id := range ids {
+		change := c.ids[id]
+		switch {
+		case change&changeUpdated != 0 || change == changeDeleted|changeInserted:
+			actions[i] = "update"
+		case change&changeInserted != 0:
+			actions[i] = "insert"
+		default:
+			actions[i] = "delete"
+		}
+	}
+	c.ids = make(map[int64]int)
+	return ids, actions
+}
+
+func tableChangesCreateSQL(schema, table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s"."%s" (
+    id BIGSERIAL PRIMARY KEY,
+    osm_id BIGINT NOT NULL,
+    action TEXT NOT NULL,
+    sequence INTEGER,
+    imported_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`, schema, table),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%[2]s_sequence" ON "%[1]s"."%[2]s" (sequence)`, schema, table),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%[2]s_imported_at" ON "%[1]s"."%[2]s" (imported_at)`, schema, table),
+	}
+}
+
+func tableChangesInsertSQL(schema, table string) string {
+	return fmt.Sprintf(`INSERT INTO "%s"."%s" (osm_id, action, sequence) VALUES ($1, $2, $3)`, schema, table)
+}
+
+func tableChangesRetentionSQL(schema, table string, retention time.Duration) string {
+	return fmt.Sprintf(`DELETE FROM "%s"."%s" WHERE imported_at < now() - interval '%d seconds'`,
+		schema, table, int64(retention.Seconds()))
+}
+
+// writeChanges inserts the changed ids into the changes table of the
+// table and removes rows after the retention time. Needs to be called
+// before the transaction is committed.
+func (tt *syncTableTx) writeChanges() error {
+	if tt.changes == nil {
+		return nil
+	}
+	schema := tt.Pg.Config.ProductionSchema
+	table := tt.Table + "_changes"
+	for _, sql := range tableChangesCreateSQL(schema, table) {
+		if _, err := tt.Tx.Exec(sql); err != nil {
+			return &SQLError{sql, err}
+		}
+	}
+
+	var seq interface{}
+	if tt.Pg.diffSequence != 0 {
+		seq = tt.Pg.diffSequence
+	}
+	ids, actions := tt.changes.actions()
+	if len(ids) > 0 {
+		insertSQL := tableChangesInsertSQL(schema, table)
+		stmt, err := tt.Tx.Prepare(insertSQL)
+		if err != nil {
+			return &SQLError{insertSQL, err}
+		}
+		defer stmt.Close()
+		for i, id := range ids {
+			if _, err := stmt.Exec(id, actions[i], seq); err != nil {
+				return errors.Wrapf(&SQLError{insertSQL, err}, "recording change of %d in %s", id, table)
+			}
+		}
+	}
+
+	if tt.Pg.TableChangesRetention > 0 {
+		sql := tableChangesRetentionSQL(schema, table, tt.Pg.TableChangesRetention)
+		if _, err := tt.Tx.Exec(sql); err != nil {
+			return &SQLError{sql, err}
+		}
+	}
+	return nil
+}
+
+// deleted records the deleted id, if rows were deleted.
+func (c *tableChanges) deleted(id int64, res sql.Result) {
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		c.add(id, changeDeleted)
+	}
+}
+
+// SetDiffSequence sets the sequence of the imported diff for the changes
+// tables, 0 if unknown.
+func (pg *PostGIS) SetDiffSequence(seq int) {
+	pg.diffSequence = seq
+}
+
+// trackChanges enables the changes table of tt.
+func trackChanges(tt TableTx, idCol int) {
+	switch tt := tt.(type) {
+	case *syncTableTx:
+		tt.changes = newTableChanges(idCol)
+	case *upsertTableTx:
+		tt.changes = newTableChanges(idCol)
+	}
+}
+
+// writeChanges writes the changes tables of all tables. All workers need
+// to be finished.
+func (txr *TxRouter) writeChanges() error {
+	for _, tt := range txr.Tables {
+		if att, ok := tt.(*asyncTableTx); ok {
+			tt = att.TableTx
+		}
+		var err error
+		switch tt := tt.(type) {
+		case *syncTableTx:
+			err = tt.writeChanges()
+		case *upsertTableTx:
+			err = tt.writeChanges()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package postgis
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTableChangesActions(t *testing.T) {
+	c := newTableChanges(0)
+	c.add(3, changeDeleted)
+	c.add(3, changeInserted)
+	c.add(1, changeInserted)
+	c.add(2, changeDeleted)
+	c.add(4, changeUpdated)
+	c.add(5, changeInserted)
+	c.add(5, changeInserted)
+
+	ids, actions := c.actions()
+	if !reflect.DeepEqual(ids, []int64{1, 2, 3, 4, 5}) {
+		t.Errorf("unexpected ids %v", ids)
+	}
+	if !reflect.DeepEqual(actions, []string{"insert", "delete", "update", "update", "insert"}) {
+		t.Errorf("unexpected actions %v", actions)
+	}
+	if ids, _ := c.actions(); len(ids) != 0 {
+		t.Errorf("changes not reset after actions: %v", ids)
+	}
+
+	if id, ok := c.rowID([]interface{}{int64(42), "name"}); !ok || id != 42 {
+		t.Errorf("unexpected row id %d", id)
+	}
+}
+
+func TestTableChangesRetentionSQL(t *testing.T) {
+	expected := `DELETE FROM "public"."osm_roads_changes" WHERE imported_at < now() - interval '604800 seconds'`
+	if sql := tableChangesRetentionSQL("public", "osm_roads_changes", 7*24*time.Hour); sql != expected {
+		t.Errorf("unexpected SQL\n%s\n%s", sql, expected)
+	}
+}
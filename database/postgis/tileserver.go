@@ -0,0 +1,251 @@
+package postgis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/omniscale/imposm3/log"
+	"github.com/pkg/errors"
+)
+
+// maxTileZoom is the max zoom of all layers, unless limited by the tiles
+// option of the table.
+const maxTileZoom = 22
+
+// tileLayer is a table (or generalized table) of a tile server layer.
+type tileLayer struct {
+	Name           string // name of the layer, the same for all generalizations
+	Source         string // unique name of the source table (without prefix)
+	Schema         string
+	Table          string
+	GeometryColumn string
+	GeometryType   string
+	IDColumn       string
+	Srid           int
+	Columns        []ColumnSpec // all columns, except geometry and ID
+	MinZoom        int
+	MaxZoom        int
+}
+
+// toleranceZoom returns the highest zoom level where the pixel size is
+// larger then the tolerance of a generalized table (256 pixel tiles).
+func toleranceZoom(tolerance float64, srid int) int {
+	pixelSize := 2 * 6378137 * math.Pi / 256 // at zoom 0
+	if srid == 4326 {
+		pixelSize = 360.0 / 256
+	}
+	if tolerance <= 0 {
+		return maxTileZoom
+	}
+	return int(math.Floor(math.Log2(pixelSize / tolerance)))
+}
+
+// tileLayers returns the layers of all tables with geometries, in the
+// production or import schema. Generalized tables are added as layers of
+// their source tables, with zoom ranges derived from their tolerance.
+func (pg *PostGIS) tileLayers(production bool) []tileLayer {
+	names := make([]string, 0, len(pg.Tables))
+	for name := range pg.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var layers []tileLayer
+	for _, name := range names {
+		spec := pg.Tables[name]
+		geomCol, idCol := "", "id"
+		var cols []ColumnSpec
+		for _, col := range spec.Columns {
+			if col.Type.Name() == "GEOMETRY" {
+				geomCol = col.Name
+			} else if col.FieldType.Name == "id" {
+				idCol = col.Name
+			} else {
+				cols = append(cols, col)
+			}
+		}
+		if geomCol == "" {
+			continue
+		}
+		if spec.Geography {
+			log.Printf("[warn] geography table %s not supported by tile servers, skipping", spec.Name)
+			continue
+		}
+		geomType, _ := spec.geometryTypeDims()
+
+		minZoom, maxZoom := 0, maxTileZoom
+		if spec.Tiles != nil {
+			if spec.Tiles.MinZoom != nil {
+				minZoom = *spec.Tiles.MinZoom
+			}
+			if spec.Tiles.MaxZoom != nil {
+				maxZoom = *spec.Tiles.MaxZoom
+			}
+		}
+
+		layer := func(schema, productionSchema, tableName, source string, from, to int) {
+			if from < minZoom {
+				from = minZoom
+			}
+			if to > maxZoom {
+				to = maxZoom
+			}
+			if from > to {
+				return
+			}
+			if production {
+				schema = productionSchema
+			}
+			layers = append(layers, tileLayer{
+				Name:           spec.Name,
+				Source:         source,
+				Schema:         schema,
+				Table:          tableName,
+				GeometryColumn: geomCol,
+				GeometryType:   geomType,
+				IDColumn:       idCol,
+				Srid:           spec.Srid,
+				Columns:        cols,
+				MinZoom:        from,
+				MaxZoom:        to,
+			})
+		}
+
+		// generalized tables of this table, from the lowest to the highest
+		// tolerance
+		var gens []*GeneralizedTableSpec
+		for _, gen := range pg.GeneralizedTables {
+			if gen.Source == spec {
+				gens = append(gens, gen)
+			}
+		}
+		sort.Slice(gens, func(i, j int) bool {
+			if gens[i].Tolerance == gens[j].Tolerance {
+				return gens[i].Name < gens[j].Name
+			}
+			return gens[i].Tolerance < gens[j].Tolerance
+		})
+
+		to := maxTileZoom
+		from := 0
+		if len(gens) > 0 {
+			from = toleranceZoom(gens[0].Tolerance, spec.Srid) + 1
+		}
+		layer(spec.Schema, spec.ProductionSchema, spec.FullName, spec.Name, from, to)
+		for i, gen := range gens {
+			to = toleranceZoom(gen.Tolerance, spec.Srid)
+			from = 0
+			if i+1 < len(gens) {
+				from = toleranceZoom(gens[i+1].Tolerance, spec.Srid) + 1
+			}
+			layer(gen.Schema, gen.ProductionSchema, gen.FullName, gen.Name, from, to)
+		}
+	}
+	return layers
+}
+
+// tegolaGeometryType returns the geometry type for Tegola. Polygon tables
+// contain polygons and multipolygons, Tegola detects the type in this case.
+func tegolaGeometryType(geomType string) string {
+	switch geomType {
+	case "POINT":
+		return "Point"
+	case "LINESTRING":
+		return "LineString"
+	}
+	return ""
+}
+
+// writeTegolaConfig writes a Tegola config with a single map. All
+// generalized tables of a table are added as the same map layer.
+func writeTegolaConfig(w io.Writer, layers []tileLayer, srid int) {
+	fmt.Fprintf(w, "# generated by imposm\n\n")
+	fmt.Fprintf(w, "[[providers]]\nname = \"osm\"\ntype = \"postgis\"\nuri = \"${DATABASE_URL}\"\nsrid = %d\n", srid)
+	for _, l := range layers {
+		fmt.Fprintf(w, "\n[[providers.layers]]\n")
+		fmt.Fprintf(w, "name = %q\n", l.Source)
+		fmt.Fprintf(w, "tablename = %q\n", l.Schema+"."+l.Table)
+		fmt.Fprintf(w, "geometry_fieldname = %q\n", l.GeometryColumn)
+		if t := tegolaGeometryType(l.GeometryType); t != "" {
+			fmt.Fprintf(w, "geometry_type = %q\n", t)
+		}
+		fmt.Fprintf(w, "id_fieldname = %q\n", l.IDColumn)
+		fmt.Fprintf(w, "srid = %d\n", l.Srid)
+		if len(l.Columns) > 0 {
+			var fields []string
+			for _, col := range l.Columns {
+				fields = append(fields, fmt.Sprintf("%q", col.Name))
+			}
+			fmt.Fprintf(w, "fields = [%s]\n", strings.Join(fields, ", "))
+		}
+	}
+
+	fmt.Fprintf(w, "\n[[maps]]\nname = \"osm\"\n")
+	for _, l := range layers {
+		fmt.Fprintf(w, "\n[[maps.layers]]\n")
+		fmt.Fprintf(w, "name = %q\n", l.Name)
+		fmt.Fprintf(w, "provider_layer = %q\n", "osm."+l.Source)
+		fmt.Fprintf(w, "min_zoom = %d\nmax_zoom = %d\n", l.MinZoom, l.MaxZoom)
+	}
+}
+
+// writeMartinConfig writes a Martin config with a table source for each
+// table and generalized table.
+func writeMartinConfig(w io.Writer, layers []tileLayer) {
+	fmt.Fprintf(w, "# generated by imposm\n")
+	fmt.Fprintf(w, "postgres:\n  connection_string: ${DATABASE_URL}\n  auto_publish: false\n  tables:\n")
+	for _, l := range layers {
+		fmt.Fprintf(w, "    %s:\n", l.Source)
+		fmt.Fprintf(w, "      schema: %s\n", l.Schema)
+		fmt.Fprintf(w, "      table: %s\n", l.Table)
+		fmt.Fprintf(w, "      srid: %d\n", l.Srid)
+		fmt.Fprintf(w, "      geometry_column: %s\n", l.GeometryColumn)
+		fmt.Fprintf(w, "      geometry_type: %s\n", l.GeometryType)
+		fmt.Fprintf(w, "      id_column: %s\n", l.IDColumn)
+		fmt.Fprintf(w, "      minzoom: %d\n", l.MinZoom)
+		fmt.Fprintf(w, "      maxzoom: %d\n", l.MaxZoom)
+		fmt.Fprintf(w, "      properties:\n")
+		for _, col := range l.Columns {
+			fmt.Fprintf(w, "        %s: %s\n", col.Name, strings.ToLower(col.Type.Name()))
+		}
+	}
+}
+
+// WriteTileServerConfig writes the configuration of a tile server with all
+// tables into the file of dest (tegola:/path/config.toml or
+// martin:/path/config.yaml). The tables are referenced in the production
+// schema, or in the import schema if production is false.
+func (pg *PostGIS) WriteTileServerConfig(dest string, production bool) error {
+	parts := strings.SplitN(dest, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return errors.Errorf("tile server config %q needs to be format:filename", dest)
+	}
+	format, filename := parts[0], parts[1]
+	if format != "tegola" && format != "martin" {
+		return errors.Errorf("unknown tile server %q, expected tegola or martin", format)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrapf(err, "creating %s", filename)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	layers := pg.tileLayers(production)
+	if format == "tegola" {
+		writeTegolaConfig(w, layers, pg.Config.Srid)
+	} else {
+		writeMartinConfig(w, layers)
+	}
+	if err := w.Flush(); err != nil {
+		return errors.Wrapf(err, "writing %s", filename)
+	}
+	log.Printf("[info] Wrote %s config with %d layers to %s", format, len(layers), filename)
+	return f.Close()
+}
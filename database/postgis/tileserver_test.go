@@ -0,0 +1,82 @@
+package postgis
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/omniscale/imposm3/mapping/config"
+)
+
+func TestToleranceZoom(t *testing.T) {
+	for _, tc := range []struct {
+		tolerance float64
+		srid      int
+		zoom      int
+	}{
+		{50, 3857, 11},
+		{200, 3857, 9},
+		{0.0001, 4326, 13},
+		{0, 3857, maxTileZoom},
+	} {
+		if z := toleranceZoom(tc.tolerance, tc.srid); z != tc.zoom {
+			t.Errorf("unexpected zoom %d for %v in %d, expected %d", z, tc.tolerance, tc.srid, tc.zoom)
+		}
+	}
+}
+
+func TestTileLayers(t *testing.T) {
+	spec, err := testTableSpec(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec.ProductionSchema = "public"
+	minZoom := 6
+	spec.Tiles = &config.TableTiles{MinZoom: &minZoom}
+	pg := &PostGIS{
+		Tables: map[string]*TableSpec{"roads": spec},
+		GeneralizedTables: map[string]*GeneralizedTableSpec{
+			"roads_gen1": {Name: "roads_gen1", FullName: "osm_roads_gen1", Schema: "import", ProductionSchema: "public", Tolerance: 50, Source: spec},
+			"roads_gen0": {Name: "roads_gen0", FullName: "osm_roads_gen0", Schema: "import", ProductionSchema: "public", Tolerance: 200, Source: spec},
+		},
+	}
+
+	layers := pg.tileLayers(true)
+	if len(layers) != 3 {
+		t.Fatalf("unexpected layers %v", layers)
+	}
+	for i, exp := range []struct {
+		source   string
+		from, to int
+	}{
+		{"roads", 12, maxTileZoom},
+		{"roads_gen1", 10, 11},
+		{"roads_gen0", 6, 9},
+	} {
+		l := layers[i]
+		if l.Name != "roads" || l.Source != exp.source || l.MinZoom != exp.from || l.MaxZoom != exp.to ||
+			l.Schema != "public" || l.IDColumn != "osm_id" || l.GeometryColumn != "geometry" || len(l.Columns) != 1 {
+			t.Errorf("unexpected layer %+v", l)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	writeTegolaConfig(buf, layers, 3857)
+	for _, part := range []string{
+		"uri = \"${DATABASE_URL}\"",
+		"name = \"roads_gen1\"\ntablename = \"public.osm_roads_gen1\"\ngeometry_fieldname = \"geometry\"\ngeometry_type = \"LineString\"\nid_fieldname = \"osm_id\"\nsrid = 3857\nfields = [\"name\"]\n",
+		"name = \"roads\"\nprovider_layer = \"osm.roads_gen0\"\nmin_zoom = 6\nmax_zoom = 9\n",
+	} {
+		if !strings.Contains(buf.String(), part) {
+			t.Errorf("missing %q in %s", part, buf.String())
+		}
+	}
+
+	buf.Reset()
+	writeMartinConfig(buf, layers)
+	part := "    roads:\n      schema: public\n      table: osm_roads\n      srid: 3857\n      geometry_column: geometry\n" +
+		"      geometry_type: LINESTRING\n      id_column: osm_id\n      minzoom: 12\n      maxzoom: 22\n      properties:\n        name: varchar\n"
+	if !strings.Contains(buf.String(), part) {
+		t.Errorf("missing %q in %s", part, buf.String())
+	}
+}
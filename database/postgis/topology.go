@@ -0,0 +1,122 @@
+package postgis
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/geom/ewkb"
+	"github.com/pkg/errors"
+)
+
+// topologyColumns returns the OSM id and the geometry column of the
+// generalized table, or an empty id and nil if the table has no such
+// column.
+func (spec *GeneralizedTableSpec) topologyColumns() (id string, geometry *ColumnSpec) {
+	for i, col := range spec.Source.Columns {
+		if col.Type.Name() == "GEOMETRY" && geometry == nil {
+			geometry = &spec.Source.Columns[i]
+		} else if col.FieldType.Name == "id" {
+			id = col.Name
+		}
+	}
+	return id, geometry
+}
+
+// TopologySelectSQL returns the query of the OSM id and geometry of all
+// rows of the generalized table.
+func (spec *GeneralizedTableSpec) TopologySelectSQL() string {
+	idCol, geomCol := spec.topologyColumns()
+	return fmt.Sprintf(`SELECT "%s", "%s"::geometry, true FROM "%s"."%s"`,
+		idCol, geomCol.Name, spec.Schema, spec.FullName)
+}
+
+// TopologyUpdateSelectSQL returns the query of the source rows that need to
+// be simplified again after the rows with the OSM ids in $1 have changed.
+// These are the changed rows and all rows that intersect them (their
+// neighbours). The third column is false for the neighbours of the
+// neighbours. They are required to find the shared borders, but they are
+// not changed.
+func (spec *GeneralizedTableSpec) TopologyUpdateSelectSQL() string {
+	idCol, geomCol := spec.topologyColumns()
+	where := "true"
+	if spec.Where != "" {
+		where = "(" + spec.Where + ")"
+	}
+	return fmt.Sprintf(`WITH changed AS (SELECT "%[2]s"::geometry AS geom FROM "%[3]s"."%[4]s" WHERE %[5]s AND "%[1]s" = ANY($1::bigint[])),
+neighbours AS (SELECT "%[1]s" AS id, "%[2]s"::geometry AS geom FROM "%[3]s"."%[4]s" AS source WHERE %[5]s AND EXISTS (SELECT 1 FROM changed WHERE ST_Intersects(source."%[2]s"::geometry, changed.geom)))
+SELECT "%[1]s", "%[2]s"::geometry, "%[1]s" IN (SELECT id FROM neighbours) FROM "%[3]s"."%[4]s" AS source WHERE %[5]s AND EXISTS (SELECT 1 FROM neighbours WHERE ST_Intersects(source."%[2]s"::geometry, neighbours.geom))`,
+		idCol, geomCol.Name, spec.Source.Schema, spec.Source.FullName, where)
+}
+
+// TopologyUpdateSQL returns the statement that sets the simplified
+// geometry $2 of the rows with the OSM id $1.
+func (spec *GeneralizedTableSpec) TopologyUpdateSQL() string {
+	idCol, geomCol := spec.topologyColumns()
+	value := "$2::geometry"
+	if _, ok := geomCol.Type.(*validatedGeometryType); ok {
+		value = "ST_Buffer(" + value + ", 0)"
+	}
+	return fmt.Sprintf(`UPDATE "%s"."%s" SET "%s" = %s WHERE "%s" = $1`,
+		spec.Schema, spec.FullName, geomCol.Name, orientedSQL(value, spec.PolygonOrientation), idCol)
+}
+
+// simplifyTopology simplifies the rows of the query with
+// geom.SimplifyTopology and updates the generalized table. The query
+// returns the OSM id, the geometry and whether the row should be updated.
+func (pg *PostGIS) simplifyTopology(tx *sql.Tx, spec *GeneralizedTableSpec, query string, args ...interface{}) error {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return &SQLError{query, err}
+	}
+	var ids []int64
+	var geoms []*ewkb.Geometry
+	var update []bool
+	for rows.Next() {
+		var id int64
+		var wkb string
+		var u bool
+		if err := rows.Scan(&id, &wkb, &u); err != nil {
+			rows.Close()
+			return err
+		}
+		g, err := ewkb.DecodeHex([]byte(wkb))
+		if err != nil {
+			rows.Close()
+			return errors.Wrapf(err, "decoding geometry %d", id)
+		}
+		ids = append(ids, id)
+		geoms = append(geoms, g)
+		update = append(update, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return errors.Wrapf(err, "querying %s", spec.FullName)
+	}
+
+	geom.SimplifyTopology(geoms, spec.Tolerance)
+
+	sql := spec.TopologyUpdateSQL()
+	stmt, err := tx.Prepare(sql)
+	if err != nil {
+		return &SQLError{sql, err}
+	}
+	defer stmt.Close()
+	for i, g := range geoms {
+		if !update[i] {
+			continue
+		}
+		if _, err := stmt.Exec(ids[i], hex.EncodeToString(g.EWKB())); err != nil {
+			return &SQLError{sql, err}
+		}
+	}
+	return nil
+}
+
+// updateTopology simplifies the changed rows of a topology table and their
+// neighbours again.
+func (pg *PostGIS) updateTopology(tx *sql.Tx, spec *GeneralizedTableSpec, ids []int64) error {
+	return pg.simplifyTopology(tx, spec, spec.TopologyUpdateSelectSQL(), pq.Array(ids))
+}
@@ -0,0 +1,54 @@
+package postgis
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/mapping/config"
+)
+
+func TestTopologySQL(t *testing.T) {
+	pg := &PostGIS{
+		Prefix: "osm_",
+		Config: database.Config{ImportSchema: "import", Srid: 3857},
+	}
+	source, err := NewTableSpec(pg, &config.Table{
+		Name: "admin",
+		Type: "polygon",
+		Columns: []*config.Column{
+			{Name: "osm_id", Type: "id"},
+			{Name: "geometry", Type: "validated_geometry"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := NewGeneralizedTableSpec(pg, &config.GeneralizedTable{
+		Name:              "admin_gen",
+		SourceTableName:   "admin",
+		Tolerance:         100,
+		SimplifyAlgorithm: "topology",
+		SQLFilter:         "admin_level <= 4",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec.Source = source
+
+	// geometries are simplified after the insert
+	if sql := simplifySQL("geometry", spec); sql != `"geometry"` {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+	if sql := spec.TopologySelectSQL(); sql != `SELECT "osm_id", "geometry"::geometry, true FROM "import"."osm_admin_gen"` {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+	if sql := spec.TopologyUpdateSQL(); sql != `UPDATE "import"."osm_admin_gen" SET "geometry" = ST_Buffer($2::geometry, 0) WHERE "osm_id" = $1` {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+	sql := spec.TopologyUpdateSelectSQL()
+	if !strings.HasPrefix(sql, `WITH changed AS (SELECT "geometry"::geometry AS geom FROM "import"."osm_admin" WHERE (admin_level <= 4) AND "osm_id" = ANY($1::bigint[]))`) ||
+		!strings.Contains(sql, `SELECT "osm_id", "geometry"::geometry, "osm_id" IN (SELECT id FROM neighbours) FROM "import"."osm_admin" AS source WHERE (admin_level <= 4) AND EXISTS`) {
+		t.Errorf("unexpected SQL %s", sql)
+	}
+}
@@ -1,11 +1,18 @@
 package postgis
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"io"
 	"sync"
+	"time"
 
+	"github.com/jackc/pgconn"
 	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/memory"
+	"github.com/omniscale/imposm3/stats"
+	"github.com/pkg/errors"
 )
 
 type TableTx interface {
@@ -17,15 +24,35 @@ type TableTx interface {
 	Rollback()
 }
 
+// copyBatchRows is the number of rows that are inserted with a single COPY
+// by bulkTableTx, if retries are enabled.
+const copyBatchRows = 10000
+
+// bulkTableTx inserts all rows with a binary COPY. Each table uses a
+// separate connection. With retries, the rows are committed in batches and
+// the rows of the current batch are kept, so that the batch can be repeated
+// with a new connection after transient errors. All rows are inserted in a
+// single transaction otherwise. The connections are not part of the pool of
+// PostGIS.Db, they are reserved from pool_max_conns by the TxRouter and Begin
+// ignores the passed transaction.
 type bulkTableTx struct {
-	Pg         *PostGIS
-	Tx         *sql.Tx
-	Table      string
-	Spec       *TableSpec
-	InsertStmt *sql.Stmt
-	InsertSQL  string
-	wg         *sync.WaitGroup
-	rows       chan []interface{}
+	Pg        *PostGIS
+	Table     string
+	Spec      *TableSpec
+	InsertSQL string
+	wg        *sync.WaitGroup
+	rows      chan []interface{}
+
+	conn     *pgconn.PgConn
+	pw       *io.PipeWriter
+	enc      *copyEncoder
+	copyDone chan error
+	// batch contains all rows of the current COPY, if batchRows is set
+	batch     [][]interface{}
+	batchRows int
+	// committed is set after the first batch was committed, the table is
+	// only truncated with the first batch
+	committed bool
 }
 
 func NewBulkTableTx(pg *PostGIS, spec *TableSpec) TableTx {
@@ -34,7 +61,7 @@ func NewBulkTableTx(pg *PostGIS, spec *TableSpec) TableTx {
 		Table: spec.FullName,
 		Spec:  spec,
 		wg:    &sync.WaitGroup{},
-		rows:  make(chan []interface{}, 64),
+		rows:  make(chan []interface{}, memory.DatabaseBuffer()),
 	}
 	tt.wg.Add(1)
 	go tt.loop()
@@ -42,48 +69,154 @@ func NewBulkTableTx(pg *PostGIS, spec *TableSpec) TableTx {
 }
 
 func (tt *bulkTableTx) Begin(tx *sql.Tx) error {
-	var err error
-	if tx == nil {
-		tx, err = tt.Pg.Db.Begin()
-		if err != nil {
-			return err
-		}
+	tt.InsertSQL = tt.Spec.CopySQL()
+	if tt.Pg.Retry.Retries > 0 {
+		tt.batchRows = copyBatchRows
 	}
-	tt.Tx = tx
+	err := tt.connect()
+	return tt.Pg.Retry.retry(fmt.Sprintf("bulk insert into %q", tt.Table), err, tt.repeatBatch)
+}
 
-	_, err = tx.Exec(fmt.Sprintf(`TRUNCATE TABLE "%s"."%s" RESTART IDENTITY`, tt.Pg.Config.ImportSchema, tt.Table))
+// connect opens the connection and starts the first batch.
+func (tt *bulkTableTx) connect() error {
+	var err error
+	tt.conn, err = pgconn.Connect(context.Background(), tt.Pg.Params)
 	if err != nil {
-		return err
+		return errors.Wrapf(err, "connecting for bulk insert into %q", tt.Table)
 	}
+	return tt.beginBatch()
+}
 
-	tt.InsertSQL = tt.Spec.CopySQL()
+// beginBatch starts a transaction with a new COPY. The table is truncated
+// in the first transaction, unless the rows are appended to a resumed
+// import.
+func (tt *bulkTableTx) beginBatch() error {
+	var err error
+	ctx := context.Background()
+	beginSQL := "BEGIN"
+	if !tt.committed && !tt.Pg.appendBulk {
+		beginSQL = fmt.Sprintf(`BEGIN; TRUNCATE TABLE "%s"."%s" RESTART IDENTITY`, tt.Spec.Schema, tt.Table)
+	}
+	if _, err := tt.conn.Exec(ctx, beginSQL).ReadAll(); err != nil {
+		return &SQLError{beginSQL, err}
+	}
 
-	stmt, err := tt.Tx.Prepare(tt.InsertSQL)
+	pr, pw := io.Pipe()
+	tt.pw = pw
+	tt.enc, err = newCopyEncoder(pw, tt.Spec)
 	if err != nil {
-		return &SQLError{tt.InsertSQL, err}
+		return err
 	}
-	tt.InsertStmt = stmt
+	tt.copyDone = make(chan error, 1)
+	go func() {
+		_, err := tt.conn.CopyFrom(ctx, pr, tt.InsertSQL)
+		// send the result before writes are unblocked, so that
+		// copyError returns the error of a failed COPY
+		tt.copyDone <- err
+		pr.CloseWithError(errors.New("COPY aborted"))
+	}()
 
 	return nil
 }
 
 func (tt *bulkTableTx) Insert(row []interface{}) error {
 	tt.rows <- row
+	stats.AddRows(tt.Table, 1)
 	return nil
 }
 
 func (tt *bulkTableTx) loop() {
 	for row := range tt.rows {
-		_, err := tt.InsertStmt.Exec(row...)
+		if tt.batchRows > 0 {
+			tt.batch = append(tt.batch, row)
+		}
+		err := tt.encode(row)
+		if err == nil && tt.batchRows > 0 && len(tt.batch) >= tt.batchRows {
+			if err = tt.endBatch(); err == nil {
+				err = tt.beginBatch()
+			}
+		}
 		if err != nil {
-			// InsertStmt uses COPY so the error may not be related to this row.
+			err = tt.Pg.Retry.retry(fmt.Sprintf("bulk insert into %q", tt.Table), err, tt.repeatBatch)
+		}
+		if err != nil {
+			// The error may not be related to this row.
 			// Abort the import as the whole transaction is lost anyway.
-			log.Fatalf("[fatal] bulk insert into %q: %s", tt.Table, &SQLError{tt.InsertSQL, err})
+			log.Fatalf("[fatal] bulk insert into %q: %s", tt.Table, err)
 		}
 	}
 	tt.wg.Done()
 }
 
+func (tt *bulkTableTx) encode(row []interface{}) error {
+	err := tt.enc.Encode(row)
+	if err != nil {
+		if copyErr := tt.copyError(); copyErr != nil {
+			err = copyErr
+		}
+		return &SQLError{tt.InsertSQL, err}
+	}
+	return nil
+}
+
+// endBatch completes the COPY and commits the current batch.
+func (tt *bulkTableTx) endBatch() error {
+	pw := tt.pw
+	tt.pw = nil
+	if err := tt.enc.Close(); err != nil {
+		pw.CloseWithError(err)
+		<-tt.copyDone
+		return err
+	}
+	pw.Close()
+	start := time.Now()
+	defer func() { stats.ObserveDBWrite("commit", time.Since(start)) }()
+	if err := <-tt.copyDone; err != nil {
+		return &SQLError{tt.InsertSQL, err}
+	}
+	if _, err := tt.conn.Exec(context.Background(), "COMMIT").ReadAll(); err != nil {
+		if pgconn.SafeToRetry(err) {
+			return err
+		}
+		return &commitError{err}
+	}
+	tt.committed = true
+	tt.batch = tt.batch[:0]
+	return nil
+}
+
+// repeatBatch inserts all rows of the current batch with a new connection.
+// The batch is committed if it is full.
+func (tt *bulkTableTx) repeatBatch() error {
+	tt.Rollback()
+	if err := tt.connect(); err != nil {
+		return err
+	}
+	for _, row := range tt.batch {
+		if err := tt.encode(row); err != nil {
+			return err
+		}
+	}
+	if tt.batchRows > 0 && len(tt.batch) >= tt.batchRows {
+		if err := tt.endBatch(); err != nil {
+			return err
+		}
+		return tt.beginBatch()
+	}
+	return nil
+}
+
+// copyError returns the error of a failed COPY.
+func (tt *bulkTableTx) copyError() error {
+	select {
+	case err := <-tt.copyDone:
+		tt.copyDone <- err
+		return err
+	default:
+		return nil
+	}
+}
+
 func (tt *bulkTableTx) Delete(id int64) error {
 	panic("unable to delete in bulkImport mode")
 }
@@ -95,22 +228,36 @@ func (tt *bulkTableTx) End() {
 
 func (tt *bulkTableTx) Commit() error {
 	tt.End()
-	if tt.InsertStmt != nil {
-		_, err := tt.InsertStmt.Exec()
-		if err != nil {
+	if tt.conn == nil {
+		return nil
+	}
+	err := tt.endBatch()
+	err = tt.Pg.Retry.retry(fmt.Sprintf("bulk insert into %q", tt.Table), err, func() error {
+		if err := tt.repeatBatch(); err != nil {
 			return err
 		}
-	}
-	err := tt.Tx.Commit()
+		return tt.endBatch()
+	})
 	if err != nil {
 		return err
 	}
-	tt.Tx = nil
-	return nil
+	err = tt.conn.Close(context.Background())
+	tt.conn = nil
+	return err
 }
 
 func (tt *bulkTableTx) Rollback() {
-	rollbackIfTx(&tt.Tx)
+	if tt.conn == nil {
+		return
+	}
+	if tt.pw != nil {
+		// CopyFrom sends CopyFail, the transaction is rolled back on close
+		tt.pw.CloseWithError(errors.New("rollback"))
+		<-tt.copyDone
+		tt.pw = nil
+	}
+	tt.conn.Close(context.Background())
+	tt.conn = nil
 }
 
 type syncTableTx struct {
@@ -122,6 +269,8 @@ type syncTableTx struct {
 	DeleteStmt *sql.Stmt
 	InsertSQL  string
 	DeleteSQL  string
+	// changes are the changed ids for the changes table, or nil
+	changes *tableChanges
 }
 
 type tableSpec interface {
@@ -167,18 +316,56 @@ func (tt *syncTableTx) Begin(tx *sql.Tx) error {
 }
 
 func (tt *syncTableTx) Insert(row []interface{}) error {
-	_, err := tt.InsertStmt.Exec(row...)
+	start := time.Now()
+	err := tt.savepoint(func() error {
+		_, err := tt.InsertStmt.Exec(row...)
+		return err
+	})
+	stats.ObserveDBWrite("insert", time.Since(start))
 	if err != nil {
 		return &SQLInsertError{SQLError{tt.InsertSQL, err}, row}
 	}
+	stats.AddRows(tt.Table, 1)
+	if tt.changes != nil {
+		if id, ok := tt.changes.rowID(row); ok {
+			tt.changes.add(id, changeInserted)
+		}
+	}
 	return nil
 }
 
+// savepoint calls f within a savepoint, if Savepoints is enabled. A failed
+// statement of f then only rolls back to the savepoint and the
+// transaction can continue.
+func (tt *syncTableTx) savepoint(f func() error) error {
+	if !tt.Pg.Config.Savepoints {
+		return f()
+	}
+	tt.Pg.savepointMu.Lock()
+	defer tt.Pg.savepointMu.Unlock()
+	if _, err := tt.Tx.Exec("SAVEPOINT imposm_insert"); err != nil {
+		return err
+	}
+	if err := f(); err != nil {
+		if _, rerr := tt.Tx.Exec("ROLLBACK TO SAVEPOINT imposm_insert"); rerr != nil {
+			return errors.Wrapf(rerr, "rollback after %v", err)
+		}
+		return err
+	}
+	_, err := tt.Tx.Exec("RELEASE SAVEPOINT imposm_insert")
+	return err
+}
+
 func (tt *syncTableTx) Delete(id int64) error {
-	_, err := tt.DeleteStmt.Exec(id)
+	start := time.Now()
+	res, err := tt.DeleteStmt.Exec(id)
+	stats.ObserveDBWrite("delete", time.Since(start))
 	if err != nil {
 		return &SQLInsertError{SQLError{tt.DeleteSQL, err}, id}
 	}
+	if tt.changes != nil {
+		tt.changes.deleted(id, res)
+	}
 	return nil
 }
 
@@ -186,7 +373,9 @@ func (tt *syncTableTx) End() {
 }
 
 func (tt *syncTableTx) Commit() error {
+	start := time.Now()
 	err := tt.Tx.Commit()
+	stats.ObserveDBWrite("commit", time.Since(start))
 	if err != nil {
 		return err
 	}
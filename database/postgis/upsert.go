@@ -0,0 +1,202 @@
+package postgis
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"github.com/omniscale/imposm3/log"
+)
+
+// upsertTableTx applies updates with INSERT ... ON CONFLICT instead of
+// DELETE and INSERT. Deletes are deferred till flushDeletes. Rows that are
+// inserted again in the meantime are updated in place and keep their
+// serial id. Requires an unique index on the OSM id column.
+type upsertTableTx struct {
+	syncTableTx
+	spec       *TableSpec
+	idCol      int
+	UpsertStmt *sql.Stmt
+	UpsertSQL  string
+	// ReturningStmt is UpsertSQL with RETURNING, for the changes table
+	ReturningStmt *sql.Stmt
+
+	mu      sync.Mutex
+	pending map[int64]struct{}
+}
+
+func newUpsertTableTx(pg *PostGIS, spec *TableSpec) *upsertTableTx {
+	return &upsertTableTx{
+		syncTableTx: syncTableTx{
+			Pg:    pg,
+			Table: spec.FullName,
+			Spec:  spec,
+		},
+		spec:    spec,
+		idCol:   spec.idColumn(),
+		pending: make(map[int64]struct{}),
+	}
+}
+
+func (tt *upsertTableTx) Begin(tx *sql.Tx) error {
+	if err := tt.syncTableTx.Begin(tx); err != nil {
+		return err
+	}
+	tt.UpsertSQL = tt.spec.UpsertSQL()
+	stmt, err := tt.Tx.Prepare(tt.UpsertSQL)
+	if err != nil {
+		return &SQLError{tt.UpsertSQL, err}
+	}
+	tt.UpsertStmt = stmt
+	if tt.changes != nil {
+		// xmax is 0 for new rows, to distinguish inserts and updates
+		// in the changes table
+		returningSQL := tt.UpsertSQL + " RETURNING (xmax = 0)"
+		if tt.ReturningStmt, err = tt.Tx.Prepare(returningSQL); err != nil {
+			return &SQLError{returningSQL, err}
+		}
+	}
+	return nil
+}
+
+func (tt *upsertTableTx) Insert(row []interface{}) error {
+	if id, ok := row[tt.idCol].(int64); ok {
+		tt.mu.Lock()
+		delete(tt.pending, id)
+		tt.mu.Unlock()
+	}
+	if tt.changes != nil {
+		return tt.insertReturning(row)
+	}
+	err := tt.savepoint(func() error {
+		_, err := tt.UpsertStmt.Exec(row...)
+		return err
+	})
+	if err != nil {
+		return &SQLInsertError{SQLError{tt.UpsertSQL, err}, row}
+	}
+	return nil
+}
+
+// insertReturning upserts the row and records whether the row was
+// inserted or updated.
+func (tt *upsertTableTx) insertReturning(row []interface{}) error {
+	inserted := false
+	err := tt.savepoint(func() error {
+		err := tt.ReturningStmt.QueryRow(row...).Scan(&inserted)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return &SQLInsertError{SQLError{tt.UpsertSQL, err}, row}
+	}
+	if id, ok := tt.changes.rowID(row); ok {
+		if inserted {
+			tt.changes.add(id, changeInserted)
+		} else {
+			// existing row, or not changed with DO NOTHING
+			tt.changes.add(id, changeUpdated)
+		}
+	}
+	return nil
+}
+
+func (tt *upsertTableTx) Delete(id int64) error {
+	tt.mu.Lock()
+	tt.pending[id] = struct{}{}
+	tt.mu.Unlock()
+	return nil
+}
+
+// flushDeletes deletes all rows that were deleted and not inserted again.
+func (tt *upsertTableTx) flushDeletes() error {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	for id := range tt.pending {
+		if err := tt.syncTableTx.Delete(id); err != nil {
+			return err
+		}
+	}
+	tt.pending = make(map[int64]struct{})
+	return nil
+}
+
+// UpsertSQL returns an INSERT statement that updates all columns of an
+// existing row with the same OSM id.
+func (spec *TableSpec) UpsertSQL() string {
+	idCol := spec.Columns[spec.idColumn()].Name
+	set := ""
+	for _, col := range spec.Columns {
+		if col.Name == idCol {
+			continue
+		}
+		if set != "" {
+			set += ", "
+		}
+		set += fmt.Sprintf(`"%s" = EXCLUDED."%s"`, col.Name, col.Name)
+	}
+	if set == "" {
+		return spec.InsertSQL() + fmt.Sprintf(` ON CONFLICT ("%s") DO NOTHING`, idCol)
+	}
+	return spec.InsertSQL() + fmt.Sprintf(` ON CONFLICT ("%s") DO UPDATE SET %s`, idCol, set)
+}
+
+// idColumn returns the index of the OSM id column, or -1.
+func (spec *TableSpec) idColumn() int {
+	for i, col := range spec.Columns {
+		if col.FieldType.Name == "id" {
+			return i
+		}
+	}
+	return -1
+}
+
+// upsertable returns whether the table can be updated with upserts. Each
+// OSM id needs to be unique in the table. This is not the case for relation
+// member tables or for geometry tables without use_single_id_space. Unique
+// indices of partitioned tables need to include the partition key.
+func (spec *TableSpec) upsertable() bool {
+	idx := spec.idColumn()
+	if idx < 0 || spec.nonUniqueIDs {
+		return false
+	}
+	if spec.Partition != nil {
+		if len(spec.Partition.Columns) != 1 || spec.Partition.Columns[0] != spec.Columns[idx].Name {
+			return false
+		}
+	}
+	return true
+}
+
+// createUniqueIDIndex creates the unique index on the OSM id required for
+// upsert updates.
+func createUniqueIDIndex(pg *PostGIS, spec *TableSpec) error {
+	if !spec.upsertable() {
+		log.Printf("[warn] OSM ids of %s are not unique, table is updated without upserts", spec.FullName)
+		return nil
+	}
+	col := spec.Columns[spec.idColumn()].Name
+	sql := fmt.Sprintf(`CREATE UNIQUE INDEX "%s_%s_uniq" ON "%s"."%s" USING BTREE ("%s")%s`,
+		spec.FullName, col, spec.Schema, spec.FullName, col, tablespaceSQL(spec.IndexTablespace))
+	step := log.Step(fmt.Sprintf("Creating unique OSM id index on %s", spec.FullName))
+	_, err := pg.Db.Exec(sql)
+	step()
+	if err != nil {
+		return &SQLError{sql, err}
+	}
+	return nil
+}
+
+// hasUniqueIDIndex checks whether the table has an unique index on the OSM
+// id column, e.g. tables that were imported without upserts.
+func hasUniqueIDIndex(tx *sql.Tx, schema string, spec *TableSpec) (bool, error) {
+	var exists bool
+	sql := fmt.Sprintf(`SELECT EXISTS(SELECT 1 FROM pg_index i JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = i.indkey[0] WHERE i.indrelid = '"%s"."%s"'::regclass AND i.indisunique AND i.indnatts = 1 AND a.attname = '%s')`,
+		schema, spec.FullName, spec.Columns[spec.idColumn()].Name)
+	if err := tx.QueryRow(sql).Scan(&exists); err != nil {
+		return false, &SQLError{sql, err}
+	}
+	return exists, nil
+}
@@ -0,0 +1,111 @@
+package postgis
+
+import (
+	"testing"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/mapping/config"
+)
+
+func TestUpsertSQL(t *testing.T) {
+	spec, err := testTableSpec(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !spec.upsertable() {
+		t.Error("table should be upsertable")
+	}
+	expected := `INSERT INTO "import"."osm_roads" ("osm_id", "geometry", "name") VALUES ($1, $2::Geometry, $3)` +
+		` ON CONFLICT ("osm_id") DO UPDATE SET "geometry" = EXCLUDED."geometry", "name" = EXCLUDED."name"`
+	if sql := spec.UpsertSQL(); sql != expected {
+		t.Errorf("unexpected SQL\n%s\n%s", sql, expected)
+	}
+
+	spec, err = testTableSpec(&config.TablePartition{Method: "grid", Partitions: 4, GridSize: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.upsertable() {
+		t.Error("grid partitioned table should not be upsertable")
+	}
+}
+
+func TestUpsertTableTxDeferredDeletes(t *testing.T) {
+	spec, err := testTableSpec(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tt := newUpsertTableTx(&PostGIS{}, spec)
+	for _, id := range []int64{1, 2, 1} {
+		if err := tt.Delete(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(tt.pending) != 2 {
+		t.Errorf("unexpected pending deletes %v", tt.pending)
+	}
+}
+
+func TestUpsertableClippedTables(t *testing.T) {
+	// ways that cross the -limitto border are clipped into multiple parts,
+	// e.g. a road that leaves and enters the area again is inserted as
+	// two rows with the same OSM id
+	pg := &PostGIS{
+		Prefix: "osm_",
+		Config: database.Config{ImportSchema: "import", Srid: 3857, ClipLimitTo: true},
+	}
+	columns := []*config.Column{
+		{Name: "osm_id", Type: "id"},
+		{Name: "geometry", Type: "geometry"},
+	}
+	for _, tc := range []struct {
+		table      config.Table
+		upsertable bool
+	}{
+		{config.Table{Name: "roads", Type: "linestring"}, false},
+		{config.Table{Name: "landuse", Type: "polygon"}, false},
+		{config.Table{Name: "buildings", Type: "polygon", LimitTo: "centroid"}, true},
+		{config.Table{Name: "pois", Type: "point"}, true},
+	} {
+		tc.table.Columns = columns
+		spec, err := NewTableSpec(pg, &tc.table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if spec.upsertable() != tc.upsertable {
+			t.Errorf("%s: upsertable %v, expected %v", tc.table.Name, spec.upsertable(), tc.upsertable)
+		}
+	}
+
+	pg.Config.ClipLimitTo = false
+	spec, err := NewTableSpec(pg, &config.Table{Name: "roads", Type: "linestring", Columns: columns})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !spec.upsertable() {
+		t.Error("roads without -limitto should be upsertable")
+	}
+}
+
+func TestUpsertableSubdividedTables(t *testing.T) {
+	// subdivided polygons are inserted in multiple rows with the same OSM id
+	pg := &PostGIS{
+		Prefix: "osm_",
+		Config: database.Config{ImportSchema: "import", Srid: 3857},
+	}
+	spec, err := NewTableSpec(pg, &config.Table{
+		Name:      "landuse",
+		Type:      "polygon",
+		Subdivide: 255,
+		Columns: []*config.Column{
+			{Name: "osm_id", Type: "id"},
+			{Name: "geometry", Type: "geometry"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.upsertable() {
+		t.Error("subdivided table should not be upsertable")
+	}
+}
@@ -4,10 +4,13 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/omniscale/imposm3/log"
+	"github.com/pkg/errors"
 )
 
 // disableDefaultSsl adds sslmode=disable to params
@@ -62,6 +65,137 @@ func stripPrefixFromConnectionParams(params string) (string, string) {
 	return params, prefix
 }
 
+// stripParamFromConnectionParams removes the param name from params and
+// returns the value.
+func stripParamFromConnectionParams(params, name string) (string, string) {
+	parts := strings.Fields(params)
+	for i, p := range parts {
+		if strings.HasPrefix(p, name+"=") {
+			parts = append(parts[:i], parts[i+1:]...)
+			return strings.Join(parts, " "), strings.TrimPrefix(p, name+"=")
+		}
+	}
+	return params, ""
+}
+
+// PoolConfig configures the database driver and the connection pool.
+type PoolConfig struct {
+	Driver          string // pgx or postgres (lib/pq)
+	MaxConns        int
+	MaxIdleConns    int
+	MaxConnLifetime time.Duration
+}
+
+// parsePoolConfig removes the driver and pool_* params from params.
+func parsePoolConfig(params string) (string, PoolConfig, error) {
+	conf := PoolConfig{Driver: "pgx", MaxIdleConns: -1}
+
+	var driver, maxConns, maxIdleConns, maxConnLifetime string
+	params, driver = stripParamFromConnectionParams(params, "driver")
+	params, maxConns = stripParamFromConnectionParams(params, "pool_max_conns")
+	params, maxIdleConns = stripParamFromConnectionParams(params, "pool_max_idle_conns")
+	params, maxConnLifetime = stripParamFromConnectionParams(params, "pool_max_conn_lifetime")
+
+	switch driver {
+	case "", "pgx":
+	case "pq":
+		conf.Driver = "postgres"
+	default:
+		return "", conf, errors.Errorf("unknown driver %q, expected pgx or pq", driver)
+	}
+
+	var err error
+	if maxConns != "" {
+		if conf.MaxConns, err = strconv.Atoi(maxConns); err != nil {
+			return "", conf, errors.Wrap(err, "parsing pool_max_conns")
+		}
+	}
+	if maxIdleConns != "" {
+		if conf.MaxIdleConns, err = strconv.Atoi(maxIdleConns); err != nil {
+			return "", conf, errors.Wrap(err, "parsing pool_max_idle_conns")
+		}
+	}
+	if maxConnLifetime != "" {
+		if conf.MaxConnLifetime, err = time.ParseDuration(maxConnLifetime); err != nil {
+			return "", conf, errors.Wrap(err, "parsing pool_max_conn_lifetime")
+		}
+	}
+	return params, conf, nil
+}
+
+// reserve limits the pool of db to leave conns of MaxConns for connections
+// outside of the pool, e.g. for the COPY of each table of a bulk import.
+// apply removes the reservation.
+func (c PoolConfig) reserve(db *sql.DB, conns int, purpose string) error {
+	if c.MaxConns <= 0 {
+		return nil
+	}
+	if conns >= c.MaxConns {
+		return errors.Errorf("%s needs more than pool_max_conns=%d connections", purpose, c.MaxConns)
+	}
+	db.SetMaxOpenConns(c.MaxConns - conns)
+	return nil
+}
+
+func (c PoolConfig) apply(db *sql.DB) {
+	if c.MaxConns > 0 {
+		db.SetMaxOpenConns(c.MaxConns)
+	}
+	if c.MaxIdleConns >= 0 {
+		db.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	if c.MaxConnLifetime > 0 {
+		db.SetConnMaxLifetime(c.MaxConnLifetime)
+	}
+}
+
+// tablespaceSQL returns the TABLESPACE clause for tablespace, or an empty
+// string for the default tablespace.
+func tablespaceSQL(tablespace string) string {
+	if tablespace == "" {
+		return ""
+	}
+	return ` TABLESPACE "` + tablespace + `"`
+}
+
+// quoteLiteral returns s as quoted SQL string literal.
+func quoteLiteral(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func unloggedSQL(unlogged bool) string {
+	if unlogged {
+		return " UNLOGGED"
+	}
+	return ""
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// execHook executes the SQL statements of a table hook. {{schema}} and
+// {{table}} are replaced with the schema and the name of the table.
+// hookSQL returns the SQL of a hook with the schema and table
+// placeholders replaced. Returns an empty string for empty hooks.
+func hookSQL(hook, schema, table string) string {
+	if strings.TrimSpace(hook) == "" {
+		return ""
+	}
+	return strings.NewReplacer("{{schema}}", schema, "{{table}}", table).Replace(hook)
+}
+
+func execHook(db execer, name, hook, schema, table string) error {
+	sql := hookSQL(hook, schema, table)
+	if sql == "" {
+		return nil
+	}
+	if _, err := db.Exec(sql); err != nil {
+		return errors.Wrapf(&SQLError{sql, err}, "%s hook of %s", name, table)
+	}
+	return nil
+}
+
 func tableExists(tx *sql.Tx, schema, table string) (bool, error) {
 	var exists bool
 	sql := fmt.Sprintf(`SELECT EXISTS(SELECT * FROM information_schema.tables WHERE table_name='%s' AND table_schema='%s')`,
@@ -74,6 +208,27 @@ func tableExists(tx *sql.Tx, schema, table string) (bool, error) {
 	return exists, nil
 }
 
+// tablePartitions returns the names of all partitions of a partitioned
+// table. Returns nil for regular tables.
+func tablePartitions(tx *sql.Tx, schema, table string) ([]string, error) {
+	sql := fmt.Sprintf(`SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid WHERE i.inhparent = '"%s"."%s"'::regclass ORDER BY c.relname`,
+		schema, table)
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, &SQLError{sql, err}
+	}
+	defer rows.Close()
+	var partitions []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, name)
+	}
+	return partitions, rows.Err()
+}
+
 func dropTableIfExists(tx *sql.Tx, schema, table string) error {
 	exists, err := tableExists(tx, schema, table)
 	if err != nil {
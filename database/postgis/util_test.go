@@ -0,0 +1,66 @@
+package postgis
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestParsePoolConfig(t *testing.T) {
+	params, conf, err := parsePoolConfig("host=localhost pool_max_conns=4 sslmode=verify-full pool_max_conn_lifetime=5m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params != "host=localhost sslmode=verify-full" {
+		t.Errorf("unexpected params %q", params)
+	}
+	if conf != (PoolConfig{Driver: "pgx", MaxConns: 4, MaxIdleConns: -1, MaxConnLifetime: 5 * time.Minute}) {
+		t.Errorf("unexpected config %#v", conf)
+	}
+
+	_, conf, err = parsePoolConfig("host=localhost driver=pq pool_max_idle_conns=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Driver != "postgres" || conf.MaxIdleConns != 0 {
+		t.Errorf("unexpected config %#v", conf)
+	}
+
+	for _, params := range []string{
+		"driver=foo",
+		"pool_max_conns=x",
+		"pool_max_conn_lifetime=10",
+	} {
+		if _, _, err := parsePoolConfig(params); err == nil {
+			t.Errorf("expected error for %q", params)
+		}
+	}
+}
+
+func TestPoolConfigReserve(t *testing.T) {
+	db, err := sql.Open("pgx", "host=localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	conf := PoolConfig{MaxConns: 10, MaxIdleConns: -1}
+	conf.apply(db)
+	if err := conf.reserve(db, 4, "bulk import of 4 tables"); err != nil {
+		t.Fatal(err)
+	}
+	if n := db.Stats().MaxOpenConnections; n != 6 {
+		t.Errorf("unexpected max open connections %d", n)
+	}
+	conf.apply(db)
+	if n := db.Stats().MaxOpenConnections; n != 10 {
+		t.Errorf("unexpected max open connections %d", n)
+	}
+
+	if err := conf.reserve(db, 10, "bulk import of 10 tables"); err == nil {
+		t.Error("expected error for 10 tables")
+	}
+	if err := (PoolConfig{}).reserve(db, 100, "bulk import of 100 tables"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,408 @@
+package postgis
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/omniscale/imposm3/log"
+	"github.com/pkg/errors"
+)
+
+// The views deploy strategy keeps all production tables in versioned
+// schemas (production schema with a timestamp suffix, e.g.
+// public_20190102150405). The production schema only contains views of
+// these tables. Deploy moves the import tables into a new versioned schema
+// and replaces all views within a single transaction, so that connected
+// clients don't see missing tables. The previous versions are kept as
+// backups.
+
+const versionLayout = "20060102150405"
+
+// legacyVersion is the version of production tables that were deployed
+// without views.
+const legacyVersion = "00000000000000"
+
+// versionSchema returns the name of the versioned schema.
+func versionSchema(production, version string) string {
+	return production + "_" + version
+}
+
+// isVersionSchema returns whether schema is a versioned schema of the
+// production schema.
+func isVersionSchema(production, schema string) bool {
+	if !strings.HasPrefix(schema, production+"_") {
+		return false
+	}
+	version := schema[len(production)+1:]
+	if len(version) != len(versionLayout) {
+		return false
+	}
+	for _, c := range version {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// versionSchemas returns all versioned schemas, oldest first.
+func versionSchemas(tx *sql.Tx, production string) ([]string, error) {
+	sql := fmt.Sprintf(`SELECT schema_name FROM information_schema.schemata WHERE schema_name LIKE '%s\_%%'`, production)
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, &SQLError{sql, err}
+	}
+	defer rows.Close()
+	var schemas []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if isVersionSchema(production, name) {
+			schemas = append(schemas, name)
+		}
+	}
+	sort.Strings(schemas)
+	return schemas, rows.Err()
+}
+
+// viewColumn is the name and the type of a column of a table or view.
+type viewColumn struct {
+	name, typ string
+}
+
+// relationColumns returns the columns of a table or view, in order.
+func relationColumns(tx *sql.Tx, schema, name string) ([]viewColumn, error) {
+	sql := fmt.Sprintf(`SELECT a.attname, format_type(a.atttypid, a.atttypmod) FROM pg_attribute a
+		JOIN pg_class c ON c.oid = a.attrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = '%s' AND c.relname = '%s' AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY a.attnum`, schema, name)
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, &SQLError{sql, err}
+	}
+	defer rows.Close()
+	var cols []viewColumn
+	for rows.Next() {
+		var c viewColumn
+		if err := rows.Scan(&c.name, &c.typ); err != nil {
+			return nil, err
+		}
+		cols = append(cols, c)
+	}
+	return cols, rows.Err()
+}
+
+// compatibleColumns returns whether a view with the columns view can be
+// replaced with CREATE OR REPLACE VIEW by a view with the columns table.
+// PostgreSQL requires the same columns in the same order, new columns can
+// only be added at the end.
+func compatibleColumns(view, table []viewColumn) bool {
+	if len(table) < len(view) {
+		return false
+	}
+	for i := range view {
+		if view[i] != table[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// replaceViewSQL returns the statements to replace the view of a table
+// with a view of the table in schema source. The view is dropped first if
+// it can not be replaced in place.
+func replaceViewSQL(production, source, tableName string, compatible bool) []string {
+	create := fmt.Sprintf(`CREATE OR REPLACE VIEW "%s"."%s" AS SELECT * FROM "%s"."%s"`, production, tableName, source, tableName)
+	if compatible {
+		return []string{create}
+	}
+	return []string{
+		fmt.Sprintf(`DROP VIEW IF EXISTS "%s"."%s"`, production, tableName),
+		create,
+	}
+}
+
+// replaceView replaces the view of a table with a view of the table in
+// schema source. Replacing the view in place keeps the dependent objects
+// and privileges of the view; the privileges are applied again for views
+// that need to be recreated, both within the transaction of the deploy.
+func (pg *PostGIS) replaceView(tx *sql.Tx, production, source, tableName string) error {
+	view, err := relationColumns(tx, production, tableName)
+	if err != nil {
+		return err
+	}
+	table, err := relationColumns(tx, source, tableName)
+	if err != nil {
+		return err
+	}
+	compatible := compatibleColumns(view, table)
+	if !compatible {
+		log.Printf("[info] recreating view %s.%s, columns changed", production, tableName)
+	}
+	for _, sql := range replaceViewSQL(production, source, tableName, compatible) {
+		if _, err := tx.Exec(sql); err != nil {
+			return &SQLError{sql, err}
+		}
+	}
+	return pg.grantTable(tx, production, tableName)
+}
+
+// isTable returns whether tableName is a table (and not a view).
+func isTable(tx *sql.Tx, schema, tableName string) (bool, error) {
+	var exists bool
+	sql := fmt.Sprintf(`SELECT EXISTS(SELECT 1 FROM information_schema.tables WHERE table_schema = '%s' AND table_name = '%s' AND table_type = 'BASE TABLE')`,
+		schema, tableName)
+	if err := tx.QueryRow(sql).Scan(&exists); err != nil {
+		return false, &SQLError{sql, err}
+	}
+	return exists, nil
+}
+
+// dropVersionSchema drops all tables of a versioned schema. The schema
+// itself is only dropped if it does not contain other relations.
+func (pg *PostGIS) dropVersionSchema(tx *sql.Tx, schema string) error {
+	for _, tableName := range pg.tableNames() {
+		if err := dropTableIfExists(tx, schema, pg.Prefix+tableName); err != nil {
+			return err
+		}
+	}
+	var n int
+	sql := fmt.Sprintf(`SELECT count(*) FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace WHERE n.nspname = '%s'`, schema)
+	if err := tx.QueryRow(sql).Scan(&n); err != nil {
+		return &SQLError{sql, err}
+	}
+	if n > 0 {
+		log.Printf("[warn] keeping %s, schema contains %d other relations", schema, n)
+		return nil
+	}
+	log.Printf("[info] removing %s", schema)
+	sql = fmt.Sprintf(`DROP SCHEMA "%s"`, schema)
+	if _, err := tx.Exec(sql); err != nil {
+		return &SQLError{sql, err}
+	}
+	return nil
+}
+
+// productionSchemas returns the distinct production schemas of all tables.
+func (pg *PostGIS) productionSchemas() []string {
+	var schemas []string
+	seen := make(map[string]bool)
+	for _, t := range pg.deployTables() {
+		if !seen[t.Production] {
+			seen[t.Production] = true
+			schemas = append(schemas, t.Production)
+		}
+	}
+	return schemas
+}
+
+// deployViews moves all import tables into a new versioned schema and
+// replaces the views in the production schema. Keeps the previous versions
+// as backup and removes all older versions.
+func (pg *PostGIS) deployViews() error {
+	defer log.Step("Deploying tables as views")()
+
+	for _, production := range pg.productionSchemas() {
+		if err := pg.createSchema(production); err != nil {
+			return err
+		}
+	}
+
+	tx, err := pg.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollbackIfTx(&tx)
+
+	version := time.Now().UTC().Format(versionLayout)
+	for _, production := range pg.productionSchemas() {
+		dest := versionSchema(production, version)
+		versions, err := versionSchemas(tx, production)
+		if err != nil {
+			return err
+		}
+		for _, v := range versions {
+			if v == dest {
+				return errors.Errorf("schema %s already exists", dest)
+			}
+		}
+		sql := fmt.Sprintf(`CREATE SCHEMA "%s"`, dest)
+		if _, err := tx.Exec(sql); err != nil {
+			return &SQLError{sql, err}
+		}
+	}
+
+	for _, t := range pg.deployTables() {
+		tableName := t.Name
+		dest := versionSchema(t.Production, version)
+
+		sourceExists, err := tableExists(tx, t.Import, tableName)
+		if err != nil {
+			return err
+		}
+		if !sourceExists {
+			log.Printf("[warn] skipping deploy of %s, table does not exists in %s", tableName, t.Import)
+			continue
+		}
+
+		// tables from deployments without views are kept as backup
+		legacy, err := isTable(tx, t.Production, tableName)
+		if err != nil {
+			return err
+		}
+		if legacy {
+			legacySchema := versionSchema(t.Production, legacyVersion)
+			sql := fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS "%s"`, legacySchema)
+			if _, err := tx.Exec(sql); err != nil {
+				return &SQLError{sql, err}
+			}
+			log.Printf("[info] backup of %s, to %s", tableName, legacySchema)
+			if err := dropTableIfExists(tx, legacySchema, tableName); err != nil {
+				return err
+			}
+			if err := moveTable(tx, t.Production, tableName, legacySchema); err != nil {
+				return err
+			}
+		}
+
+		log.Printf("[info] Deploying %s from %s -> %s", tableName, t.Import, dest)
+		if err := moveTable(tx, t.Import, tableName, dest); err != nil {
+			return err
+		}
+		if err := pg.replaceView(tx, t.Production, dest, tableName); err != nil {
+			return err
+		}
+	}
+
+	// keep current version and all backup generations
+	keep := 1 + pg.backupGenerations()
+	for _, production := range pg.productionSchemas() {
+		versions, err := versionSchemas(tx, production)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(versions)-keep; i++ {
+			if err := pg.dropVersionSchema(tx, versions[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	tx = nil // set nil to prevent rollback
+	return nil
+}
+
+// revertDeployViews replaces all views with views of the previous version
+// and moves the tables of the current version back into the import schema.
+func (pg *PostGIS) revertDeployViews() error {
+	defer log.Step("Reverting deploy of views")()
+
+	for _, schema := range pg.importSchemas() {
+		if err := pg.createSchema(schema); err != nil {
+			return err
+		}
+	}
+
+	tx, err := pg.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollbackIfTx(&tx)
+
+	// current and previous version of each production schema
+	current := make(map[string]string)
+	backup := make(map[string]string)
+	for _, production := range pg.productionSchemas() {
+		versions, err := versionSchemas(tx, production)
+		if err != nil {
+			return err
+		}
+		if len(versions) < 2 {
+			return errors.Errorf("no backup of %s to revert to", production)
+		}
+		current[production], backup[production] = versions[len(versions)-1], versions[len(versions)-2]
+	}
+
+	for _, t := range pg.deployTables() {
+		tableName := t.Name
+		backup := backup[t.Production]
+		current := current[t.Production]
+
+		backupExists, err := tableExists(tx, backup, tableName)
+		if err != nil {
+			return err
+		}
+		if backupExists {
+			log.Printf("[info] Reverting %s to %s", tableName, backup)
+			if err := pg.replaceView(tx, t.Production, backup, tableName); err != nil {
+				return err
+			}
+		} else {
+			log.Printf("[warn] removing view of %s, table does not exists in %s", tableName, backup)
+			sql := fmt.Sprintf(`DROP VIEW IF EXISTS "%s"."%s"`, t.Production, tableName)
+			if _, err := tx.Exec(sql); err != nil {
+				return &SQLError{sql, err}
+			}
+		}
+
+		currentExists, err := tableExists(tx, current, tableName)
+		if err != nil {
+			return err
+		}
+		if currentExists {
+			if err := dropTableIfExists(tx, t.Import, tableName); err != nil {
+				return err
+			}
+			if err := moveTable(tx, current, tableName, t.Import); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, schema := range current {
+		if err := pg.dropVersionSchema(tx, schema); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	tx = nil // set nil to prevent rollback
+	return nil
+}
+
+// removeBackupViews removes all versions, except the current version.
+func (pg *PostGIS) removeBackupViews() error {
+	tx, err := pg.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollbackIfTx(&tx)
+
+	for _, production := range pg.productionSchemas() {
+		versions, err := versionSchemas(tx, production)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(versions)-1; i++ {
+			if err := pg.dropVersionSchema(tx, versions[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	tx = nil // set nil to prevent rollback
+	return nil
+}
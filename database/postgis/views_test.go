@@ -0,0 +1,60 @@
+package postgis
+
+import "testing"
+
+func TestIsVersionSchema(t *testing.T) {
+	for _, tc := range []struct {
+		schema  string
+		version bool
+	}{
+		{"public_20190102150405", true},
+		{"public_00000000000000", true},
+		{"public", false},
+		{"public_2019010215040", false},
+		{"public_2019010215040x", false},
+		{"public_backup", false},
+		{"import_20190102150405", false},
+	} {
+		if v := isVersionSchema("public", tc.schema); v != tc.version {
+			t.Errorf("unexpected result %v for %s", v, tc.schema)
+		}
+	}
+	if s := versionSchema("public", legacyVersion); !isVersionSchema("public", s) {
+		t.Errorf("legacy schema %s not a version schema", s)
+	}
+}
+
+func TestReplaceViewSQL(t *testing.T) {
+	stmts := replaceViewSQL("public", "public_20190102150405", "osm_roads", false)
+	if len(stmts) != 2 ||
+		stmts[0] != `DROP VIEW IF EXISTS "public"."osm_roads"` ||
+		stmts[1] != `CREATE OR REPLACE VIEW "public"."osm_roads" AS SELECT * FROM "public_20190102150405"."osm_roads"` {
+		t.Errorf("unexpected SQL %v", stmts)
+	}
+	stmts = replaceViewSQL("public", "public_20190102150405", "osm_roads", true)
+	if len(stmts) != 1 ||
+		stmts[0] != `CREATE OR REPLACE VIEW "public"."osm_roads" AS SELECT * FROM "public_20190102150405"."osm_roads"` {
+		t.Errorf("unexpected SQL %v", stmts)
+	}
+}
+
+func TestCompatibleColumns(t *testing.T) {
+	id := viewColumn{"id", "integer"}
+	name := viewColumn{"name", "character varying"}
+	geom := viewColumn{"geometry", "geometry(LineString,3857)"}
+	for _, tc := range []struct {
+		view, table []viewColumn
+		compatible  bool
+	}{
+		{nil, []viewColumn{id, name}, true},
+		{[]viewColumn{id, name}, []viewColumn{id, name}, true},
+		{[]viewColumn{id, name}, []viewColumn{id, name, geom}, true},
+		{[]viewColumn{id, name, geom}, []viewColumn{id, name}, false},
+		{[]viewColumn{id, name}, []viewColumn{id, geom, name}, false},
+		{[]viewColumn{id, name}, []viewColumn{id, {"name", "text"}}, false},
+	} {
+		if c := compatibleColumns(tc.view, tc.table); c != tc.compatible {
+			t.Errorf("unexpected result %v for %v -> %v", c, tc.view, tc.table)
+		}
+	}
+}
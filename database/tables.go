@@ -0,0 +1,81 @@
+package database
+
+import (
+	"sort"
+
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/mapping/config"
+	"github.com/pkg/errors"
+)
+
+// ColumnSpec describes a single column of a mapped table.
+type ColumnSpec struct {
+	Name      string
+	FieldType mapping.ColumnType
+}
+
+// IsGeometry returns true for geometry and validated_geometry columns.
+func (c *ColumnSpec) IsGeometry() bool {
+	return c.FieldType.GoType == "geometry" || c.FieldType.GoType == "validated_geometry"
+}
+
+// TableSpec describes a mapped table. The columns are in the same order as
+// the values returned by mapping.Match.Row. It is meant for databases that
+// do not have their own table specification (e.g. file outputs).
+type TableSpec struct {
+	Name         string
+	GeometryType string
+	Srid         int
+	Columns      []ColumnSpec
+}
+
+// GeometryColumn returns the index of the (first) geometry column or -1.
+func (spec *TableSpec) GeometryColumn() int {
+	for i := range spec.Columns {
+		if spec.Columns[i].IsGeometry() {
+			return i
+		}
+	}
+	return -1
+}
+
+func NewTableSpec(t *config.Table, srid int) (*TableSpec, error) {
+	var geomType string
+	if mapping.TableType(t.Type) == mapping.RelationMemberTable || t.MergeLines ||
+		mapping.TableType(t.Type) == mapping.LineStringTable && t.Antimeridian == geom.AntimeridianSplit {
+		geomType = "geometry"
+	} else if t.LabelPoint {
+		geomType = "point"
+	} else {
+		geomType = string(t.Type)
+	}
+	spec := TableSpec{
+		Name:         t.Name,
+		GeometryType: geomType,
+		Srid:         srid,
+	}
+	for _, column := range t.Columns {
+		columnType, err := mapping.MakeColumnType(column)
+		if err != nil {
+			return nil, err
+		}
+		spec.Columns = append(spec.Columns, ColumnSpec{column.Name, *columnType})
+	}
+	return &spec, nil
+}
+
+// NewTableSpecs returns the TableSpecs for all tables of the mapping,
+// sorted by name.
+func NewTableSpecs(m *config.Mapping, srid int) ([]*TableSpec, error) {
+	var specs []*TableSpec
+	for name, table := range m.Tables {
+		spec, err := NewTableSpec(table, srid)
+		if err != nil {
+			return nil, errors.Wrapf(err, "creating table spec for %q", name)
+		}
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs, nil
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"reflect"
+	"strconv"
+)
+
+// AsInt64 converts a column value (as returned by mapping.Match.Row) to
+// int64. Booleans are converted to 0/1. Returns false if the value is not
+// numeric.
+func AsInt64(v interface{}) (int64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		if rv.Bool() {
+			return 1, true
+		}
+		return 0, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return int64(rv.Float()), true
+	case reflect.String:
+		n, err := strconv.ParseInt(rv.String(), 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// AsFloat64 converts a column value to float64. Returns false if the value
+// is not numeric.
+func AsFloat64(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.String:
+		n, err := strconv.ParseFloat(rv.String(), 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// ParseHstore parses the tags of hstore_tags columns ("key"=>"value", ...).
+func ParseHstore(s string) map[string]string {
+	tags := make(map[string]string)
+	var key string
+	var haveKey bool
+	for i := 0; i < len(s); i++ {
+		if s[i] != '"' {
+			continue
+		}
+		var str []byte
+		for i++; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+			}
+			str = append(str, s[i])
+		}
+		if !haveKey {
+			key = string(str)
+		} else {
+			tags[key] = string(str)
+		}
+		haveKey = !haveKey
+	}
+	return tags
+}
@@ -0,0 +1,21 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHstore(t *testing.T) {
+	for _, tc := range []struct {
+		hstore   string
+		expected map[string]string
+	}{
+		{``, map[string]string{}},
+		{`"a"=>"b"`, map[string]string{"a": "b"}},
+		{`"a"=>"b", "c\\d"=>"e\"f"`, map[string]string{"a": "b", `c\d`: `e"f`}},
+	} {
+		if tags := ParseHstore(tc.hstore); !reflect.DeepEqual(tags, tc.expected) {
+			t.Errorf("unexpected tags %v for %s", tags, tc.hstore)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package expire
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Buffer is a buffer around changed geometries. It is either in pixels of
+// 256x256 tiles at the max zoom level, or in meters.
+type Buffer struct {
+	Value  float64
+	Meters bool
+}
+
+// ParseBuffer parses buffers like 16px or 50m. Values without unit are in
+// pixels. An empty string is no buffer.
+func ParseBuffer(s string) (Buffer, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Buffer{}, nil
+	}
+	b := Buffer{}
+	value := s
+	if strings.HasSuffix(s, "px") {
+		value = s[:len(s)-2]
+	} else if strings.HasSuffix(s, "m") {
+		value = s[:len(s)-1]
+		b.Meters = true
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || v < 0 {
+		return Buffer{}, errors.Errorf("invalid expire tiles buffer %q, use e.g. 16px or 50m", s)
+	}
+	b.Value = v
+	return b, nil
+}
+
+func (b *Buffer) String() string {
+	if b.Value == 0 {
+		return ""
+	}
+	if b.Meters {
+		return strconv.FormatFloat(b.Value, 'f', -1, 64) + "m"
+	}
+	return strconv.FormatFloat(b.Value, 'f', -1, 64) + "px"
+}
+
+// Set parses the buffer, for -expiretiles-buffer.
+func (b *Buffer) Set(s string) error {
+	var err error
+	*b, err = ParseBuffer(s)
+	return err
+}
+
+// UnmarshalJSON decodes buffers from strings like "16px" or "50m", or from
+// numbers in pixels.
+func (b *Buffer) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var v float64
+		if err := json.Unmarshal(data, &v); err != nil {
+			return errors.New("expiretiles_buffer needs to be a string like 16px or 50m")
+		}
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return b.Set(s)
+}
+
+// tiles returns the buffer as fraction of a tile at zoom. Meters are
+// converted at the latitude lat.
+func (b Buffer) tiles(lat float64, zoom int) float64 {
+	if b.Value == 0 {
+		return 0
+	}
+	if !b.Meters {
+		return b.Value / 256
+	}
+	// meters on the ground are larger in web mercator, except at the equator
+	scale := 1 / math.Cos(lat*math.Pi/180)
+	return b.Value * scale / (mercRes[zoom] * 256)
+}
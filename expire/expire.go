@@ -13,10 +13,10 @@ type Expireor interface {
 func ExpireProjectedNodes(expireor Expireor, nodes []osm.Node, srid int, closed bool) {
 	if srid == 4326 {
 		expireor.ExpireNodes(nodes, closed)
-	} else if srid == 3857 {
+	} else if p := proj.Lookup(srid); p != nil {
 		nds := make([]osm.Node, len(nodes))
 		for i, nd := range nodes {
-			nds[i].Long, nds[i].Lat = proj.MercToWgs(nd.Long, nd.Lat)
+			nds[i].Long, nds[i].Lat = p.Inverse(nd.Long, nd.Lat)
 		}
 		expireor.ExpireNodes(nds, closed)
 	} else {
@@ -27,8 +27,8 @@ func ExpireProjectedNodes(expireor Expireor, nodes []osm.Node, srid int, closed
 func ExpireProjectedNode(expireor Expireor, node osm.Node, srid int) {
 	if srid == 4326 {
 		expireor.Expire(node.Long, node.Lat)
-	} else if srid == 3857 {
-		long, lat := proj.MercToWgs(node.Long, node.Lat)
+	} else if p := proj.Lookup(srid); p != nil {
+		long, lat := p.Inverse(node.Long, node.Lat)
 		expireor.Expire(long, lat)
 	} else {
 		panic("unsupported srid")
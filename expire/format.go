@@ -0,0 +1,255 @@
+package expire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/omniscale/imposm3/proj"
+	"github.com/pkg/errors"
+)
+
+// Tile is an expired tile in the XYZ tile scheme (origin top left).
+type Tile struct {
+	X uint32
+	Y uint32
+}
+
+// ZoomTiles are the expired tiles of one zoom level, sorted by Y and X.
+type ZoomTiles struct {
+	Zoom  int
+	Tiles []Tile
+}
+
+// Format writes the expired tiles into a file.
+type Format interface {
+	// Ext returns the file extension, e.g. ".tiles".
+	Ext() string
+	// Write writes the tiles of all zoom levels. The zoom levels are
+	// sorted from low to high.
+	Write(w io.Writer, zooms []ZoomTiles) error
+}
+
+var formats = map[string]Format{}
+
+// RegisterFormat registers a format for -expiretiles-formats.
+func RegisterFormat(name string, f Format) {
+	formats[name] = f
+}
+
+func init() {
+	RegisterFormat("tiles", tilesFormat{})
+	RegisterFormat("geojson", geojsonFormat{})
+	RegisterFormat("quadkey", quadkeyFormat{})
+	RegisterFormat("bitmap", bitmapFormat{})
+	RegisterFormat("polygons", polygonsFormat{})
+}
+
+// CheckFormats returns an error if a format is not registered.
+func CheckFormats(names []string) error {
+	_, err := lookupFormats(names)
+	return err
+}
+
+func lookupFormats(names []string) ([]Format, error) {
+	var result []Format
+	for _, name := range names {
+		f, ok := formats[name]
+		if !ok {
+			known := make([]string, 0, len(formats))
+			for n := range formats {
+				known = append(known, n)
+			}
+			sort.Strings(known)
+			return nil, errors.Errorf("unknown expire tiles format %q, use %s", name, strings.Join(known, ", "))
+		}
+		result = append(result, f)
+	}
+	return result, nil
+}
+
+func sortTiles(tiles []Tile) {
+	sort.Slice(tiles, func(i, j int) bool {
+		if tiles[i].Y != tiles[j].Y {
+			return tiles[i].Y < tiles[j].Y
+		}
+		return tiles[i].X < tiles[j].X
+	})
+}
+
+// tilesFormat writes one z/x/y tile per line.
+type tilesFormat struct{}
+
+func (tilesFormat) Ext() string { return ".tiles" }
+
+func (tilesFormat) Write(w io.Writer, zooms []ZoomTiles) error {
+	bw := bufio.NewWriter(w)
+	for _, z := range zooms {
+		for _, t := range z.Tiles {
+			if _, err := fmt.Fprintf(bw, "%d/%d/%d\n", z.Zoom, t.X, t.Y); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// quadkeyFormat writes one quadkey (as used by Bing Maps) per line.
+type quadkeyFormat struct{}
+
+func (quadkeyFormat) Ext() string { return ".quadkeys" }
+
+func (quadkeyFormat) Write(w io.Writer, zooms []ZoomTiles) error {
+	bw := bufio.NewWriter(w)
+	for _, z := range zooms {
+		for _, t := range z.Tiles {
+			if _, err := bw.WriteString(quadkey(z.Zoom, t) + "\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+func quadkey(zoom int, t Tile) string {
+	key := make([]byte, zoom)
+	for i := zoom; i > 0; i-- {
+		digit := byte('0')
+		mask := uint32(1) << uint(i-1)
+		if t.X&mask != 0 {
+			digit++
+		}
+		if t.Y&mask != 0 {
+			digit += 2
+		}
+		key[zoom-i] = digit
+	}
+	return string(key)
+}
+
+// geojsonFormat writes a FeatureCollection with a polygon (EPSG:4326) for
+// each tile. The properties contain z, x and y.
+type geojsonFormat struct{}
+
+func (geojsonFormat) Ext() string { return ".geojson" }
+
+type geojsonFeature struct {
+	Type       string          `json:"type"`
+	Properties map[string]int  `json:"properties"`
+	Geometry   geojsonGeometry `json:"geometry"`
+}
+
+type geojsonGeometry struct {
+	Type        string         `json:"type"`
+	Coordinates [][][2]float64 `json:"coordinates"`
+}
+
+func (geojsonFormat) Write(w io.Writer, zooms []ZoomTiles) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(`{"type":"FeatureCollection","features":[`); err != nil {
+		return err
+	}
+	first := true
+	for _, z := range zooms {
+		for _, t := range z.Tiles {
+			if !first {
+				bw.WriteString(",\n")
+			} else {
+				bw.WriteString("\n")
+				first = false
+			}
+			minx, miny, maxx, maxy := tileBounds(z.Zoom, t)
+			b, err := json.Marshal(geojsonFeature{
+				Type:       "Feature",
+				Properties: map[string]int{"z": z.Zoom, "x": int(t.X), "y": int(t.Y)},
+				Geometry: geojsonGeometry{
+					Type: "Polygon",
+					Coordinates: [][][2]float64{{
+						{minx, miny}, {maxx, miny}, {maxx, maxy}, {minx, maxy}, {minx, miny},
+					}},
+				},
+			})
+			if err != nil {
+				return err
+			}
+			if _, err := bw.Write(b); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := bw.WriteString("\n]}\n"); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// tileBounds returns the bounds of a tile in EPSG:4326.
+func tileBounds(zoom int, t Tile) (minx, miny, maxx, maxy float64) {
+	size := mercRes[zoom] * 256
+	minx, maxy = proj.MercToWgs(mercBbox[0]+float64(t.X)*size, mercBbox[3]-float64(t.Y)*size)
+	maxx, miny = proj.MercToWgs(mercBbox[0]+float64(t.X+1)*size, mercBbox[3]-float64(t.Y+1)*size)
+	return minx, miny, maxx, maxy
+}
+
+// bitmapFormat writes a bitmap of the expired tiles for each zoom level.
+// Each bitmap starts with a header of the zoom (uint8) and the minx, miny,
+// width and height (uint32, big endian) of the extent of all expired tiles
+// at this zoom level. The header is followed by width*height bits (rows
+// from top to bottom, most significant bit first), padded to full bytes.
+type bitmapFormat struct{}
+
+func (bitmapFormat) Ext() string { return ".bitmap" }
+
+func (bitmapFormat) Write(w io.Writer, zooms []ZoomTiles) error {
+	bw := bufio.NewWriter(w)
+	for _, z := range zooms {
+		tiles := z.Tiles
+		minx, miny := uint32(math.MaxUint32), uint32(math.MaxUint32)
+		var maxx, maxy uint32
+		for _, t := range tiles {
+			x, y := t.X, t.Y
+			if x < minx {
+				minx = x
+			}
+			if y < miny {
+				miny = y
+			}
+			if x > maxx {
+				maxx = x
+			}
+			if y > maxy {
+				maxy = y
+			}
+		}
+		var width, height uint32
+		if len(tiles) > 0 {
+			width, height = maxx-minx+1, maxy-miny+1
+		} else {
+			minx, miny = 0, 0
+		}
+		bits := make([]byte, (uint64(width)*uint64(height)+7)/8)
+		for _, t := range tiles {
+			i := uint64(t.Y-miny)*uint64(width) + uint64(t.X-minx)
+			bits[i/8] |= 0x80 >> (i % 8)
+		}
+
+		header := make([]byte, 17)
+		header[0] = uint8(z.Zoom)
+		binary.BigEndian.PutUint32(header[1:], minx)
+		binary.BigEndian.PutUint32(header[5:], miny)
+		binary.BigEndian.PutUint32(header[9:], width)
+		binary.BigEndian.PutUint32(header[13:], height)
+		if _, err := bw.Write(header); err != nil {
+			return err
+		}
+		if _, err := bw.Write(bits); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,121 @@
+package expire
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestQuadkey(t *testing.T) {
+	for _, test := range []struct {
+		zoom     int
+		tile     Tile
+		expected string
+	}{
+		{0, Tile{0, 0}, ""},
+		{1, Tile{1, 0}, "1"},
+		{3, Tile{3, 5}, "213"},
+	} {
+		if k := quadkey(test.zoom, test.tile); k != test.expected {
+			t.Errorf("unexpected quadkey %q for %v, expected %q", k, test.tile, test.expected)
+		}
+	}
+}
+
+func TestTilesFormats(t *testing.T) {
+	tiles := []Tile{{3, 5}, {1, 0}}
+	sortTiles(tiles)
+
+	buf := bytes.Buffer{}
+	if err := (tilesFormat{}).Write(&buf, []ZoomTiles{{3, tiles}}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "3/1/0\n3/3/5\n" {
+		t.Errorf("unexpected tiles %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := (quadkeyFormat{}).Write(&buf, []ZoomTiles{{3, tiles}}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "001\n213\n" {
+		t.Errorf("unexpected quadkeys %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := (geojsonFormat{}).Write(&buf, []ZoomTiles{{1, []Tile{{1, 0}}}}); err != nil {
+		t.Fatal(err)
+	}
+	var fc struct {
+		Features []geojsonFeature
+	}
+	if err := json.Unmarshal(buf.Bytes(), &fc); err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if len(fc.Features) != 1 || fc.Features[0].Properties["x"] != 1 {
+		t.Fatalf("unexpected features %v", fc.Features)
+	}
+	ring := fc.Features[0].Geometry.Coordinates[0]
+	if ring[0][0] != 0 || ring[1][0] != 180 || ring[0][1] != 0 || ring[2][1] < 85.05 {
+		t.Errorf("unexpected polygon %v", ring)
+	}
+}
+
+func TestBitmapFormat(t *testing.T) {
+	tl := NewTileList(2, "")
+	tl.SetMinZoom(0)
+	tl.tiles[Tile{1, 1}] = struct{}{}
+	tl.tiles[Tile{3, 2}] = struct{}{}
+
+	buf := bytes.Buffer{}
+	if err := (bitmapFormat{}).Write(&buf, tl.zoomTiles()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		// zoom 0: 1x1 tile at 0/0
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 0x80,
+		// zoom 1: 2x2 tiles at 0/0, 0/0 and 1/1 are expired
+		1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 2, 0x90,
+		// zoom 2: 3x2 tiles at 1/1, 1/1 and 3/2 are expired
+		2, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 3, 0, 0, 0, 2, 0x84,
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("unexpected bitmap\n%v\nexpected\n%v", buf.Bytes(), expected)
+	}
+}
+
+func TestTileListFlushFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imposm3-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tl := NewTileList(14, dir)
+	if err := tl.SetFormats([]string{"unknown"}); err == nil {
+		t.Error("expected error for unknown format")
+	}
+	if err := tl.SetFormats([]string{"tiles", "quadkey", "bitmap"}); err != nil {
+		t.Fatal(err)
+	}
+	tl.Expire(8.30, 53.26)
+	if err := tl.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exts []string
+	for _, f := range files {
+		exts = append(exts, filepath.Ext(f))
+	}
+	sort.Strings(exts)
+	if len(exts) != 3 || exts[0] != ".bitmap" || exts[1] != ".quadkeys" || exts[2] != ".tiles" {
+		t.Errorf("unexpected files %v", files)
+	}
+}
@@ -0,0 +1,255 @@
+package expire
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	"github.com/omniscale/imposm3/proj"
+)
+
+// polygonsFormat writes the expired area as dissolved polygons, for
+// consumers that do not use tiles. The polygons are the outlines of all
+// connected expired tiles at the max zoom level, including holes. It
+// writes a FeatureCollection with a Polygon (EPSG:4326) for each area. The
+// properties contain the zoom and the number of tiles.
+type polygonsFormat struct{}
+
+func (polygonsFormat) Ext() string { return ".polygons.geojson" }
+
+type polygonFeature struct {
+	Type       string          `json:"type"`
+	Properties map[string]int  `json:"properties"`
+	Geometry   geojsonGeometry `json:"geometry"`
+}
+
+func (polygonsFormat) Write(w io.Writer, zooms []ZoomTiles) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(`{"type":"FeatureCollection","features":[`); err != nil {
+		return err
+	}
+	if len(zooms) > 0 {
+		z := zooms[len(zooms)-1]
+		for i, area := range dissolveTiles(z.Tiles) {
+			if i > 0 {
+				bw.WriteString(",\n")
+			} else {
+				bw.WriteString("\n")
+			}
+			coords := make([][][2]float64, len(area.rings))
+			for j, ring := range area.rings {
+				coords[j] = ringCoords(z.Zoom, ring)
+			}
+			b, err := json.Marshal(polygonFeature{
+				Type:       "Feature",
+				Properties: map[string]int{"zoom": z.Zoom, "tiles": area.tiles},
+				Geometry:   geojsonGeometry{Type: "Polygon", Coordinates: coords},
+			})
+			if err != nil {
+				return err
+			}
+			if _, err := bw.Write(b); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := bw.WriteString("\n]}\n"); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ringCoords returns the EPSG:4326 coordinates of a ring in tile
+// coordinates. The ring is reversed, as the y axis of tiles points south.
+func ringCoords(zoom int, ring []vertex) [][2]float64 {
+	size := mercRes[zoom] * 256
+	coords := make([][2]float64, 0, len(ring)+1)
+	for i := len(ring) - 1; i >= 0; i-- {
+		long, lat := proj.MercToWgs(mercBbox[0]+float64(ring[i].x)*size, mercBbox[3]-float64(ring[i].y)*size)
+		coords = append(coords, [2]float64{long, lat})
+	}
+	return append(coords, coords[0])
+}
+
+// vertex is a tile corner.
+type vertex struct {
+	x, y int64
+}
+
+// edge is a directed edge of a tile at the border of an area. The area is
+// on the right side of the edge (with the y axis pointing south).
+type edge struct {
+	from, to vertex
+}
+
+// area is a polygon of connected tiles. The first ring is the exterior
+// ring, all other rings are holes.
+type area struct {
+	rings [][]vertex
+	tiles int
+}
+
+// dissolveTiles returns a polygon for each group of connected tiles. Tiles
+// are connected if they share an edge.
+func dissolveTiles(tiles []Tile) []area {
+	set := make(map[Tile]struct{}, len(tiles))
+	for _, t := range tiles {
+		set[t] = struct{}{}
+	}
+	has := func(x, y int64) bool {
+		if x < 0 || y < 0 {
+			return false
+		}
+		_, ok := set[Tile{uint32(x), uint32(y)}]
+		return ok
+	}
+
+	sorted := make([]Tile, len(tiles))
+	copy(sorted, tiles)
+	sortTiles(sorted)
+
+	var areas []area
+	visited := make(map[Tile]struct{}, len(tiles))
+	for _, start := range sorted {
+		if _, ok := visited[start]; ok {
+			continue
+		}
+		// collect the border edges of all connected tiles
+		outgoing := make(map[vertex][]edge)
+		numEdges := 0
+		addEdge := func(e edge) {
+			outgoing[e.from] = append(outgoing[e.from], e)
+			numEdges++
+		}
+		n := 0
+		queue := []Tile{start}
+		visited[start] = struct{}{}
+		for len(queue) > 0 {
+			t := queue[len(queue)-1]
+			queue = queue[:len(queue)-1]
+			n++
+			x, y := int64(t.X), int64(t.Y)
+			for _, nb := range [4]struct {
+				x, y     int64
+				from, to vertex
+			}{
+				{x, y - 1, vertex{x, y}, vertex{x + 1, y}},         // top
+				{x + 1, y, vertex{x + 1, y}, vertex{x + 1, y + 1}}, // right
+				{x, y + 1, vertex{x + 1, y + 1}, vertex{x, y + 1}}, // bottom
+				{x - 1, y, vertex{x, y + 1}, vertex{x, y}},         // left
+			} {
+				if !has(nb.x, nb.y) {
+					addEdge(edge{nb.from, nb.to})
+					continue
+				}
+				nt := Tile{uint32(nb.x), uint32(nb.y)}
+				if _, ok := visited[nt]; !ok {
+					visited[nt] = struct{}{}
+					queue = append(queue, nt)
+				}
+			}
+		}
+
+		a := area{tiles: n}
+		for numEdges > 0 {
+			ring := traceRing(outgoing, &numEdges)
+			if ringArea(ring) > 0 {
+				// exterior ring first
+				a.rings = append([][]vertex{ring}, a.rings...)
+			} else {
+				a.rings = append(a.rings, ring)
+			}
+		}
+		areas = append(areas, a)
+	}
+	return areas
+}
+
+// traceRing removes the edges of one ring from outgoing and returns the
+// corners of the ring, without collinear vertices. outgoing contains the
+// edges of a single area. Rings are separated where diagonal tiles of the
+// area touch, by turning away from the area. This results in holes that
+// touch the exterior ring at a single point, instead of self-touching
+// rings.
+func traceRing(outgoing map[vertex][]edge, numEdges *int) []vertex {
+	// deterministic start at the lowest vertex
+	var start vertex
+	first := true
+	for v := range outgoing {
+		if first || v.y < start.y || (v.y == start.y && v.x < start.x) {
+			start = v
+			first = false
+		}
+	}
+	takeEdge := func(v vertex, i int) edge {
+		edges := outgoing[v]
+		e := edges[i]
+		edges = append(edges[:i], edges[i+1:]...)
+		if len(edges) == 0 {
+			delete(outgoing, v)
+		} else {
+			outgoing[v] = edges
+		}
+		*numEdges--
+		return e
+	}
+
+	var ring []vertex
+	firstEdge := takeEdge(start, 0)
+	ring = append(ring, firstEdge.from)
+	e := firstEdge
+	for {
+		candidates := outgoing[e.to]
+		if e.to == start {
+			// the ring is closed, unless it continues with another edge
+			// of start
+			candidates = append([]edge{firstEdge}, candidates...)
+		}
+		best := 0
+		if len(candidates) > 1 {
+			dx, dy := e.to.x-e.from.x, e.to.y-e.from.y
+			bestCross := int64(2)
+			for i, c := range candidates {
+				cdx, cdy := c.to.x-c.from.x, c.to.y-c.from.y
+				if cross := dx*cdy - dy*cdx; cross < bestCross {
+					best, bestCross = i, cross
+				}
+			}
+		}
+		if e.to == start {
+			if best == 0 {
+				break
+			}
+			best--
+		}
+		next := takeEdge(e.to, best)
+		ring = append(ring, next.from)
+		e = next
+	}
+	return removeCollinear(ring)
+}
+
+// removeCollinear removes all vertices on a straight line between the
+// previous and next vertex.
+func removeCollinear(ring []vertex) []vertex {
+	result := make([]vertex, 0, len(ring))
+	for i, v := range ring {
+		prev := ring[(i+len(ring)-1)%len(ring)]
+		next := ring[(i+1)%len(ring)]
+		if (v.x-prev.x)*(next.y-v.y)-(v.y-prev.y)*(next.x-v.x) != 0 {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// ringArea returns the signed area of the ring in tiles. It is positive
+// for exterior rings and negative for holes.
+func ringArea(ring []vertex) int64 {
+	var a int64
+	for i, v := range ring {
+		next := ring[(i+1)%len(ring)]
+		a += v.x*next.y - next.x*v.y
+	}
+	return a / 2
+}
@@ -0,0 +1,86 @@
+package expire
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDissolveTiles(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		tiles []Tile
+		areas []area
+	}{
+		{"single", []Tile{{5, 5}}, []area{
+			{tiles: 1, rings: [][]vertex{{{5, 5}, {6, 5}, {6, 6}, {5, 6}}}},
+		}},
+		{"L-shape", []Tile{{0, 0}, {0, 1}, {1, 1}}, []area{
+			{tiles: 3, rings: [][]vertex{{{0, 0}, {1, 0}, {1, 1}, {2, 1}, {2, 2}, {0, 2}}}},
+		}},
+		{"diagonal", []Tile{{0, 0}, {1, 1}}, []area{
+			{tiles: 1, rings: [][]vertex{{{0, 0}, {1, 0}, {1, 1}, {0, 1}}}},
+			{tiles: 1, rings: [][]vertex{{{1, 1}, {2, 1}, {2, 2}, {1, 2}}}},
+		}},
+		{"hole", []Tile{
+			{0, 0}, {1, 0}, {2, 0},
+			{0, 1}, {2, 1},
+			{0, 2}, {1, 2}, {2, 2},
+		}, []area{
+			{tiles: 8, rings: [][]vertex{
+				{{0, 0}, {3, 0}, {3, 3}, {0, 3}},
+				{{1, 1}, {1, 2}, {2, 2}, {2, 1}},
+			}},
+		}},
+		{"hole touching exterior", []Tile{
+			{0, 0}, {1, 0}, {2, 0},
+			{0, 1}, {2, 1},
+			{0, 2}, {1, 2},
+		}, []area{
+			{tiles: 7, rings: [][]vertex{
+				{{0, 0}, {3, 0}, {3, 2}, {2, 2}, {2, 3}, {0, 3}},
+				{{1, 1}, {1, 2}, {2, 2}, {2, 1}},
+			}},
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			areas := dissolveTiles(test.tiles)
+			if !reflect.DeepEqual(areas, test.areas) {
+				t.Errorf("unexpected areas\n%v\nexpected\n%v", areas, test.areas)
+			}
+		})
+	}
+}
+
+func TestPolygonsFormat(t *testing.T) {
+	buf := bytes.Buffer{}
+	zooms := []ZoomTiles{
+		{0, []Tile{{0, 0}}},
+		{1, []Tile{{0, 0}, {1, 0}}},
+	}
+	if err := (polygonsFormat{}).Write(&buf, zooms); err != nil {
+		t.Fatal(err)
+	}
+	var fc struct {
+		Features []polygonFeature
+	}
+	if err := json.Unmarshal(buf.Bytes(), &fc); err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if len(fc.Features) != 1 || fc.Features[0].Properties["tiles"] != 2 || fc.Features[0].Properties["zoom"] != 1 {
+		t.Fatalf("unexpected features %v", fc.Features)
+	}
+	ring := fc.Features[0].Geometry.Coordinates[0]
+	if len(ring) != 5 || ring[0] != ring[4] {
+		t.Fatalf("unexpected ring %v", ring)
+	}
+	// exterior rings are counterclockwise
+	area := 0.0
+	for i := 0; i < len(ring)-1; i++ {
+		area += ring[i][0]*ring[i+1][1] - ring[i+1][0]*ring[i][1]
+	}
+	if area <= 0 {
+		t.Errorf("exterior ring is not counterclockwise %v", ring)
+	}
+}
@@ -0,0 +1,207 @@
+package expire
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const dayFormat = "20060102"
+
+// RotationConfig configures the rotation of the daily directories with the
+// expire tile files.
+type RotationConfig struct {
+	// Compress packs all files of a day into a single YYYYMMDD.tar.gz
+	// archive after the day is over, and removes the directory.
+	Compress bool `json:"compress"`
+	// MaxDays removes the files of all days before the last MaxDays days,
+	// including the current day.
+	MaxDays int `json:"max_days"`
+	// MaxSizeMB removes the files of the oldest days till the total size of
+	// the output directory is below this size. The files of the current day
+	// are never removed.
+	MaxSizeMB int `json:"max_size_mb"`
+}
+
+func (c RotationConfig) enabled() bool {
+	return c.Compress || c.MaxDays > 0 || c.MaxSizeMB > 0
+}
+
+// SetRotation enables the rotation of the output directory with each Flush.
+func (tl *TileList) SetRotation(conf RotationConfig) {
+	tl.rotation = conf
+}
+
+// dayEntry is the directory or archive with the files of a single day.
+type dayEntry struct {
+	day     time.Time
+	path    string
+	archive bool
+	size    int64
+}
+
+// rotate compresses and removes the days in dir according to conf. now is
+// the time of the current day.
+func rotate(dir string, conf RotationConfig, now time.Time) error {
+	today := now.UTC().Format(dayFormat)
+	entries, err := dayEntries(dir)
+	if err != nil {
+		return err
+	}
+
+	if conf.Compress {
+		for i, e := range entries {
+			if e.archive || e.day.Format(dayFormat) >= today {
+				continue
+			}
+			archive := e.path + ".tar.gz"
+			if err := compressDir(e.path, archive); err != nil {
+				return errors.Wrapf(err, "compressing %s", e.path)
+			}
+			fi, err := os.Stat(archive)
+			if err != nil {
+				return err
+			}
+			entries[i] = dayEntry{day: e.day, path: archive, archive: true, size: fi.Size()}
+		}
+	}
+
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+	maxSize := int64(conf.MaxSizeMB) * 1024 * 1024
+	var firstDay string
+	if conf.MaxDays > 0 {
+		firstDay = now.UTC().AddDate(0, 0, -(conf.MaxDays - 1)).Format(dayFormat)
+	}
+	// entries are sorted by day, oldest first
+	for _, e := range entries {
+		day := e.day.Format(dayFormat)
+		if day >= today {
+			break
+		}
+		if day >= firstDay && (maxSize == 0 || total <= maxSize) {
+			continue
+		}
+		if err := os.RemoveAll(e.path); err != nil {
+			return err
+		}
+		total -= e.size
+	}
+	return nil
+}
+
+// dayEntries returns all daily directories and archives in dir, sorted by
+// day.
+func dayEntries(dir string) ([]dayEntry, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entries []dayEntry
+	for _, fi := range fis {
+		name := fi.Name()
+		archive := !fi.IsDir()
+		if archive {
+			if !strings.HasSuffix(name, ".tar.gz") {
+				continue
+			}
+			name = strings.TrimSuffix(name, ".tar.gz")
+		}
+		day, err := time.Parse(dayFormat, name)
+		if err != nil {
+			continue
+		}
+		e := dayEntry{day: day, path: filepath.Join(dir, fi.Name()), archive: archive, size: fi.Size()}
+		if !archive {
+			if e.size, err = dirSize(e.path); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, e)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].day.Before(entries[j].day) })
+	return entries, nil
+}
+
+func dirSize(dir string) (int64, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, fi := range fis {
+		size += fi.Size()
+	}
+	return size, nil
+}
+
+// compressDir writes all files of dir into a tar.gz archive and removes
+// dir. Incomplete files (with ~ suffix) are skipped.
+func compressDir(dir, archive string) error {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(archive + "~")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive + "~")
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	base := filepath.Base(dir)
+	for _, fi := range fis {
+		if fi.IsDir() || strings.HasSuffix(fi.Name(), "~") {
+			continue
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = base + "/" + fi.Name()
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if err := copyFile(tw, filepath.Join(dir, fi.Name())); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(archive+"~", archive); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
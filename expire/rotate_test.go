@@ -0,0 +1,124 @@
+package expire
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeDayFiles(t *testing.T, dir string, files map[string]int) {
+	for name, size := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(strings.Repeat("x", size)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestRotate(t *testing.T) {
+	now := time.Date(2020, 3, 10, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name     string
+		conf     RotationConfig
+		expected []string
+	}{
+		{"compress", RotationConfig{Compress: true},
+			[]string{"20200307.tar.gz", "20200308.tar.gz", "20200309.tar.gz", "20200310", "other"}},
+		{"max days", RotationConfig{MaxDays: 2},
+			[]string{"20200309", "20200310", "other"}},
+		{"max size", RotationConfig{MaxSizeMB: 1},
+			[]string{"20200309", "20200310", "other"}},
+		{"max size today", RotationConfig{MaxSizeMB: 1, MaxDays: 1},
+			[]string{"20200310", "other"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "imposm_expire_rotate")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			writeDayFiles(t, dir, map[string]int{
+				"20200307/120000.000.tiles": 400 * 1024,
+				"20200308/120000.000.tiles": 400 * 1024,
+				"20200309/120000.000.tiles": 400 * 1024,
+				"20200309/120030.000.tiles": 100 * 1024,
+				"20200310/120000.000.tiles": 400 * 1024,
+				"other/file":                100,
+			})
+
+			if err := rotate(dir, tc.conf, now); err != nil {
+				t.Fatal(err)
+			}
+			if names := dirNames(t, dir); !reflect.DeepEqual(names, tc.expected) {
+				t.Errorf("unexpected files %v, expected %v", names, tc.expected)
+			}
+		})
+	}
+}
+
+func TestRotateCompressArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imposm_expire_rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeDayFiles(t, dir, map[string]int{
+		"20200309/120000.000.tiles":   10,
+		"20200309/120000.000.geojson": 20,
+		"20200309/120030.000.tiles~":  30,
+	})
+	if err := rotate(dir, RotationConfig{Compress: true}, time.Date(2020, 3, 10, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "20200309.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	files := make(map[string]int64)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		files[hdr.Name] = hdr.Size
+	}
+	expected := map[string]int64{
+		"20200309/120000.000.tiles":   10,
+		"20200309/120000.000.geojson": 20,
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("unexpected archive content %v", files)
+	}
+}
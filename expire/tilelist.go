@@ -1,8 +1,6 @@
 package expire
 
 import (
-	"fmt"
-	"io"
 	"math"
 	"os"
 	"path/filepath"
@@ -10,7 +8,9 @@ import (
 	"time"
 
 	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/log"
 	"github.com/omniscale/imposm3/proj"
+	"github.com/pkg/errors"
 )
 
 var mercBbox = [4]float64{
@@ -43,24 +43,64 @@ func tileCoord(long, lat float64, zoom int) (float64, float64) {
 
 type TileList struct {
 	mu    sync.Mutex
-	tiles map[tileKey]struct{}
+	tiles map[Tile]struct{}
 
-	zoom int
-	out  string
-}
+	zoom    int // max zoom, all tiles are collected at this zoom level
+	minZoom int
+	buffer  Buffer
+	out     string
+	formats []Format
+
+	webhook *webhook
+	unsent  []Tile // tiles of failed webhook requests
 
-type tileKey struct {
-	X uint32
-	Y uint32
+	rotation RotationConfig
 }
 
 func NewTileList(zoom int, out string) *TileList {
 	return &TileList{
-		tiles: make(map[tileKey]struct{}),
-		zoom:  zoom,
-		mu:    sync.Mutex{},
-		out:   out,
+		tiles:   make(map[Tile]struct{}),
+		zoom:    zoom,
+		minZoom: zoom,
+		mu:      sync.Mutex{},
+		out:     out,
+		formats: []Format{tilesFormat{}},
+	}
+}
+
+// SetFormats sets the registered formats that are written by Flush.
+// Defaults to tiles.
+func (tl *TileList) SetFormats(names []string) error {
+	formats, err := lookupFormats(names)
+	if err != nil {
+		return err
 	}
+	if len(formats) > 0 {
+		tl.formats = formats
+	}
+	return nil
+}
+
+// SetMinZoom sets the lowest zoom level of the expired tiles. The parent
+// tiles of all expired tiles are expired down to this zoom level. Defaults
+// to the zoom of the TileList.
+func (tl *TileList) SetMinZoom(zoom int) {
+	if zoom < 0 || zoom > tl.zoom {
+		zoom = tl.zoom
+	}
+	tl.minZoom = zoom
+}
+
+// SetBuffer sets a buffer around all changed geometries.
+func (tl *TileList) SetBuffer(b Buffer) {
+	tl.buffer = b
+}
+
+// SetWebhook enables the webhook that receives the expired tiles with each
+// Flush. Tiles are only written to files if the TileList has an output
+// directory.
+func (tl *TileList) SetWebhook(conf WebhookConfig) {
+	tl.webhook = newWebhook(conf)
 }
 
 func (tl *TileList) Expire(long, lat float64) {
@@ -84,19 +124,30 @@ func (tl *TileList) ExpireNodes(nodes []osm.Node, closed bool) {
 	}
 }
 
-// expire a single point. Point is padded by 0.2 tiles to expire nearby tiles
-// for nodes at a tile border.
+// expire a single point. Point is padded by 0.2 tiles (and the buffer) to
+// expire nearby tiles for nodes at a tile border.
 func (tl *TileList) addCoord(long, lat float64) {
 	// fraction of a tile that is added as a padding around a single node
 	const tilePadding = 0.2
+	pad := tilePadding + tl.buffer.tiles(lat, tl.zoom)
 	tl.mu.Lock()
 	tileX, tileY := tileCoord(long, lat, tl.zoom)
-	for x := uint32(tileX - tilePadding); x <= uint32(tileX+tilePadding); x++ {
-		for y := uint32(tileY - tilePadding); y <= uint32(tileY+tilePadding); y++ {
-			tl.tiles[tileKey{x, y}] = struct{}{}
+	tl.addBox(tileX-pad, tileY-pad, tileX+pad, tileY+pad)
+	tl.mu.Unlock()
+}
+
+// addBox expires all tiles inside the box in tile coordinates. tl.mu needs
+// to be locked.
+func (tl *TileList) addBox(x1, y1, x2, y2 float64) {
+	maxTile := float64(uint32(1)<<uint(tl.zoom) - 1)
+	clamp := func(v float64) uint32 {
+		return uint32(math.Max(0, math.Min(maxTile, v)))
+	}
+	for x := clamp(x1); x <= clamp(x2); x++ {
+		for y := clamp(y1); y <= clamp(y2); y++ {
+			tl.tiles[Tile{x, y}] = struct{}{}
 		}
 	}
-	tl.mu.Unlock()
 }
 
 // expireLine expires all tiles that are intersected by the line segments
@@ -115,8 +166,10 @@ func (tl *TileList) expireLine(nodes []osm.Node) {
 		}
 		x1, y1 := tileCoord(nodes[i].Long, nodes[i].Lat, tl.zoom)
 		x2, y2 := tileCoord(nodes[i+1].Long, nodes[i+1].Lat, tl.zoom)
-		if int(x1) == int(x2) && int(y1) == int(y2) {
-			tl.tiles[tileKey{X: uint32(x1), Y: uint32(y1)}] = struct{}{}
+		if buf := tl.buffer.tiles(nodes[i].Lat, tl.zoom); buf > 0 {
+			tl.addBufferedSegment(x1, y1, x2, y2, buf)
+		} else if int(x1) == int(x2) && int(y1) == int(y2) {
+			tl.tiles[Tile{X: uint32(x1), Y: uint32(y1)}] = struct{}{}
 		} else {
 			for _, tk := range bresenham(x1, y1, x2, y2) {
 				tl.tiles[tk] = struct{}{}
@@ -125,55 +178,136 @@ func (tl *TileList) expireLine(nodes []osm.Node) {
 	}
 }
 
+// addBufferedSegment expires all tiles within buf tiles of the segment.
+// The buffer is covered by parallel lines with a distance of at most half
+// a tile and by a box around both ends. tl.mu needs to be locked.
+func (tl *TileList) addBufferedSegment(x1, y1, x2, y2, buf float64) {
+	tl.addBox(x1-buf, y1-buf, x1+buf, y1+buf)
+	tl.addBox(x2-buf, y2-buf, x2+buf, y2+buf)
+	length := math.Hypot(x2-x1, y2-y1)
+	if length == 0 {
+		return
+	}
+	// unit normal of the segment
+	nx, ny := (y1-y2)/length, (x2-x1)/length
+	// even number of steps to include the segment itself
+	steps := int(math.Ceil(buf * 4))
+	steps += steps % 2
+	// keep lines within the world, bresenham does not support negative
+	// tile coordinates
+	max := float64(uint32(1)<<uint(tl.zoom)) - 1e-9
+	clamp := func(v float64) float64 {
+		return math.Max(0, math.Min(max, v))
+	}
+	for i := 0; i <= steps; i++ {
+		d := -buf + 2*buf*float64(i)/float64(steps)
+		ox, oy := nx*d, ny*d
+		for _, tk := range bresenham(clamp(x1+ox), clamp(y1+oy), clamp(x2+ox), clamp(y2+oy)) {
+			tl.tiles[tk] = struct{}{}
+		}
+	}
+}
+
 // expireBox expires all tiles inside the bbox
 func (tl *TileList) expireBox(b bbox) {
 	tl.mu.Lock()
 	defer tl.mu.Unlock()
 	x1, y1 := tileCoord(b.minx, b.maxy, tl.zoom)
 	x2, y2 := tileCoord(b.maxx, b.miny, tl.zoom)
-	for x := uint32(x1); x <= uint32(x2); x++ {
-		for y := uint32(y1); y <= uint32(y2); y++ {
-			tl.tiles[tileKey{x, y}] = struct{}{}
-		}
-	}
+	buf := tl.buffer.tiles(b.maxy, tl.zoom)
+	tl.addBox(x1-buf, y1-buf, x2+buf, y2+buf)
 }
 
-func (tl *TileList) writeTiles(w io.Writer) error {
-	for tileKey := range tl.tiles {
-		_, err := fmt.Fprintf(w, "%d/%d/%d\n", tl.zoom, tileKey.X, tileKey.Y)
-		if err != nil {
+func (tl *TileList) Flush() error {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	if len(tl.tiles) == 0 && len(tl.unsent) == 0 {
+		return nil
+	}
+
+	if tl.out != "" && len(tl.tiles) > 0 {
+		if err := tl.writeFiles(tl.zoomTiles()); err != nil {
 			return err
 		}
+		if tl.rotation.enabled() {
+			// the tiles are written, rotate again with the next Flush
+			if err := rotate(tl.out, tl.rotation, time.Now()); err != nil {
+				log.Println("[warn] Rotating expire tiles:", err)
+			}
+		}
+	}
+
+	if tl.webhook != nil {
+		// send again the tiles of previous failed requests
+		for _, tile := range tl.unsent {
+			tl.tiles[tile] = struct{}{}
+		}
+		zooms := tl.zoomTiles()
+		tl.tiles = make(map[Tile]struct{})
+		tl.unsent = nil
+		if err := tl.webhook.send(zooms); err != nil {
+			tl.unsent = zooms[len(zooms)-1].Tiles
+			return errors.Wrapf(err, "sending %d expired tiles to webhook", len(tl.unsent))
+		}
+		return nil
 	}
+	tl.tiles = make(map[Tile]struct{})
 	return nil
 }
 
-func (tl *TileList) Flush() error {
-	tl.mu.Lock()
-	defer tl.mu.Unlock()
-	if len(tl.tiles) == 0 {
-		return nil
+// zoomTiles returns the expired tiles of all zoom levels from minZoom to
+// zoom.
+func (tl *TileList) zoomTiles() []ZoomTiles {
+	var zooms []ZoomTiles
+	for z := tl.minZoom; z <= tl.zoom; z++ {
+		shift := uint(tl.zoom - z)
+		tiles := make([]Tile, 0, len(tl.tiles))
+		seen := make(map[Tile]struct{}, len(tl.tiles))
+		for tile := range tl.tiles {
+			parent := Tile{tile.X >> shift, tile.Y >> shift}
+			if _, ok := seen[parent]; ok {
+				continue
+			}
+			seen[parent] = struct{}{}
+			tiles = append(tiles, parent)
+		}
+		sortTiles(tiles)
+		zooms = append(zooms, ZoomTiles{Zoom: z, Tiles: tiles})
 	}
+	return zooms
+}
 
+// writeFiles writes the tiles in all formats into a new file in the output
+// directory.
+func (tl *TileList) writeFiles(zooms []ZoomTiles) error {
 	now := time.Now().UTC()
-	dir := filepath.Join(tl.out, now.Format("20060102"))
+	dir := filepath.Join(tl.out, now.Format(dayFormat))
 	err := os.MkdirAll(dir, 0775)
 	if err != nil {
 		return err
 	}
-	fileName := filepath.Join(dir, now.Format("150405.000")+".tiles~")
-	f, err := os.Create(fileName)
+	base := filepath.Join(dir, now.Format("150405.000"))
+	for _, format := range tl.formats {
+		if err := writeFormat(base+format.Ext(), format, zooms); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFormat writes the tiles to fileName~ and atomically moves the file
+// to fileName.
+func writeFormat(fileName string, format Format, zooms []ZoomTiles) error {
+	f, err := os.Create(fileName + "~")
 	if err != nil {
 		return err
 	}
-	err = tl.writeTiles(f)
+	err = format.Write(f, zooms)
 	f.Close()
 	if err != nil {
 		return err
 	}
-	tl.tiles = make(map[tileKey]struct{})
-	// wrote to .tiles~ and now atomically move file to .tiles
-	return os.Rename(fileName, fileName[0:len(fileName)-1])
+	return os.Rename(fileName+"~", fileName)
 }
 
 type bbox struct {
@@ -214,8 +348,8 @@ func numBboxTiles(b bbox, zoom int) int {
 	return int(math.Abs((x2 - x1 + 1) * (y2 - y1 + 1)))
 }
 
-func bresenham(x1, y1, x2, y2 float64) []tileKey {
-	tiles := make([]tileKey, 0, 4)
+func bresenham(x1, y1, x2, y2 float64) []Tile {
+	tiles := make([]Tile, 0, 4)
 	steep := false
 	dx := math.Abs(x2 - x1)
 	sx := -1.0
@@ -238,9 +372,9 @@ func bresenham(x1, y1, x2, y2 float64) []tileKey {
 	e := 2*dy - dx
 	for i := 0.0; i < dx; i++ {
 		if steep {
-			tiles = append(tiles, tileKey{X: uint32(y1), Y: uint32(x1)})
+			tiles = append(tiles, Tile{X: uint32(y1), Y: uint32(x1)})
 		} else {
-			tiles = append(tiles, tileKey{X: uint32(x1), Y: uint32(y1)})
+			tiles = append(tiles, Tile{X: uint32(x1), Y: uint32(y1)})
 		}
 		for e >= 0 {
 			y1 += sy
@@ -249,6 +383,6 @@ func bresenham(x1, y1, x2, y2 float64) []tileKey {
 		x1 += sx
 		e += 2 * dy
 	}
-	tiles = append(tiles, tileKey{X: uint32(x2), Y: uint32(y2)})
+	tiles = append(tiles, Tile{X: uint32(x2), Y: uint32(y2)})
 	return tiles
 }
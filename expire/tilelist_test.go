@@ -81,3 +81,54 @@ func TestTileList_ExpireNodes(t *testing.T) {
 		}
 	}
 }
+
+func TestTileList_Buffer(t *testing.T) {
+	for _, test := range []struct {
+		buffer   string
+		nodes    []osm.Node
+		expected int
+	}{
+		// 2x2 tiles with 0.2 padding
+		{"", []osm.Node{{Long: 0, Lat: 0}}, 4},
+		// 0.2 + 0.5 tiles in each direction, 2x3 tiles
+		{"128px", []osm.Node{{Long: 8.30, Lat: 53.26}}, 6},
+		// ~1.5 tiles at z14 in 53°
+		{"1500m", []osm.Node{{Long: 8.30, Lat: 53.26}}, 9},
+		// line with 5 tiles, buffer adds one column on each side and one
+		// row at the north end
+		{"200px", []osm.Node{
+			{Long: 8.30, Lat: 53.25},
+			{Long: 8.30, Lat: 53.30},
+		}, 18},
+		// world edge, 3x3 tiles instead of 5x5
+		{"512px", []osm.Node{{Long: -180, Lat: 85.05}}, 9},
+	} {
+		b, err := ParseBuffer(test.buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tl := NewTileList(14, "")
+		tl.SetBuffer(b)
+		tl.ExpireNodes(test.nodes, false)
+		if len(tl.tiles) != test.expected {
+			t.Errorf("expected %d tiles for %s, got %d", test.expected, test.buffer, len(tl.tiles))
+		}
+	}
+
+	if _, err := ParseBuffer("10km"); err == nil {
+		t.Error("expected error for unknown unit")
+	}
+}
+
+func TestTileList_MinZoom(t *testing.T) {
+	tl := NewTileList(14, "")
+	tl.SetMinZoom(12)
+	tl.ExpireNodes([]osm.Node{{Long: 8.30, Lat: 53.25}, {Long: 8.30, Lat: 53.30}}, false)
+	zooms := tl.zoomTiles()
+	if len(zooms) != 3 || zooms[0].Zoom != 12 || zooms[2].Zoom != 14 {
+		t.Fatalf("unexpected zooms %v", zooms)
+	}
+	if len(zooms[2].Tiles) != 5 || len(zooms[0].Tiles) != 2 {
+		t.Errorf("unexpected tiles %v", zooms)
+	}
+}
@@ -0,0 +1,121 @@
+package expire
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/omniscale/imposm3/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultWebhookBatchSize = 10000
+	defaultWebhookRetries   = 3
+)
+
+// WebhookConfig configures the webhook that receives the expired tiles
+// with each Flush of a TileList.
+type WebhookConfig struct {
+	URL string `json:"url"`
+	// BatchSize is the maximum number of tiles of each request.
+	BatchSize int `json:"batch_size"`
+	// Retries is the number of retries of failed requests.
+	Retries int `json:"retries"`
+	// Headers are added to each request, e.g. for authentication.
+	Headers map[string]string `json:"headers"`
+}
+
+// webhookRequest is the JSON body of each request.
+type webhookRequest struct {
+	Zoom  int      `json:"zoom"`
+	Tiles []string `json:"tiles"`
+}
+
+type webhook struct {
+	conf    WebhookConfig
+	client  *http.Client
+	minWait time.Duration
+}
+
+func newWebhook(conf WebhookConfig) *webhook {
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = defaultWebhookBatchSize
+	}
+	if conf.Retries < 0 {
+		conf.Retries = 0
+	} else if conf.Retries == 0 {
+		conf.Retries = defaultWebhookRetries
+	}
+	return &webhook{
+		conf:    conf,
+		client:  &http.Client{Timeout: time.Minute},
+		minWait: time.Second,
+	}
+}
+
+// send posts the tiles of each zoom level in batches.
+func (wh *webhook) send(zooms []ZoomTiles) error {
+	for _, z := range zooms {
+		tiles := z.Tiles
+		for start := 0; start < len(tiles); start += wh.conf.BatchSize {
+			end := start + wh.conf.BatchSize
+			if end > len(tiles) {
+				end = len(tiles)
+			}
+			req := webhookRequest{Zoom: z.Zoom, Tiles: make([]string, 0, end-start)}
+			for _, t := range tiles[start:end] {
+				req.Tiles = append(req.Tiles, fmt.Sprintf("%d/%d/%d", z.Zoom, t.X, t.Y))
+			}
+			body, err := json.Marshal(req)
+			if err != nil {
+				return err
+			}
+			if err := wh.postRetry(body); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// postRetry posts the body and retries with an exponential backoff.
+func (wh *webhook) postRetry(body []byte) error {
+	wait := wh.minWait
+	var err error
+	for i := 0; ; i++ {
+		err = wh.post(body)
+		if err == nil || i == wh.conf.Retries {
+			return err
+		}
+		log.Printf("[warn] Expire tiles webhook: %s, retrying in %s", err, wait)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func (wh *webhook) post(body []byte) error {
+	req, err := http.NewRequest("POST", wh.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "imposm3")
+	for k, v := range wh.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("posting to %s: %s", wh.conf.URL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package expire
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTileListWebhook(t *testing.T) {
+	var mu sync.Mutex
+	var requests []webhookRequest
+	fail := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("missing header in %v", r.Header)
+		}
+		if fail > 0 {
+			fail--
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var req webhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		requests = append(requests, req)
+	}))
+	defer srv.Close()
+
+	tl := NewTileList(14, "")
+	tl.SetWebhook(WebhookConfig{
+		URL:       srv.URL,
+		BatchSize: 2,
+		Retries:   1,
+		Headers:   map[string]string{"Authorization": "Bearer secret"},
+	})
+	tl.webhook.minWait = time.Millisecond
+
+	// 4 tiles at 0/0
+	tl.Expire(0, 0)
+	// one failed request is retried
+	fail = 1
+	if err := tl.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 || len(requests[0].Tiles) != 2 || requests[0].Zoom != 14 ||
+		requests[0].Tiles[0] != "14/8191/8191" {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+
+	// tiles are kept if all retries failed
+	requests = nil
+	tl.Expire(8.30, 53.26)
+	fail = 2
+	if err := tl.Flush(); err == nil {
+		t.Fatal("expected error")
+	}
+	if len(requests) != 0 || len(tl.unsent) != 1 {
+		t.Fatalf("unexpected requests %v or unsent tiles %v", requests, tl.unsent)
+	}
+	tl.Expire(0, 0)
+	if err := tl.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 3 || len(tl.unsent) != 0 {
+		t.Errorf("unexpected requests %v", requests)
+	}
+}
@@ -0,0 +1,193 @@
+package geom
+
+import (
+	"encoding/hex"
+	"errors"
+	"math"
+
+	"github.com/omniscale/imposm3/geom/ewkb"
+	"github.com/omniscale/imposm3/geom/geos"
+)
+
+// Antimeridian modes.
+const (
+	AntimeridianSplit = "split"
+	AntimeridianShift = "shift"
+)
+
+// Antimeridian returns the hex encoded EWKB geometry with all lines and
+// rings that cross the antimeridian shifted or split. Shifted lines and
+// rings continue beyond 180° (e.g. to 181° instead of -179°). Split
+// geometries are returned as MultiLineString or MultiPolygon with parts on
+// both sides of the antimeridian. Only EPSG:4326 and EPSG:3857 geometries
+// are changed. Rings that enclose a pole are not changed.
+func Antimeridian(wkb []byte, mode string) ([]byte, error) {
+	g, err := ewkb.DecodeHex(wkb)
+	if err != nil {
+		return nil, err
+	}
+	var world float64
+	switch g.SRID {
+	case 4326:
+		world = 360
+	case 3857:
+		world = 2 * metersPerDegree * 180
+	default:
+		return wkb, nil
+	}
+	changed, ok := unwrapGeometry(g, world)
+	if !changed || !ok {
+		return wkb, nil
+	}
+	if mode == AntimeridianSplit {
+		g, err = splitAtAntimeridian(g, world)
+		if err != nil {
+			return nil, err
+		}
+	}
+	b := g.EWKB()
+	result := make([]byte, hex.EncodedLen(len(b)))
+	hex.Encode(result, b)
+	return result, nil
+}
+
+// unwrapGeometry shifts the coordinates of all lines and rings that cross
+// the antimeridian, so that they are continuous. Returns false for ok if a
+// ring is not closed after the shift (it encloses a pole).
+func unwrapGeometry(g *ewkb.Geometry, world float64) (changed, ok bool) {
+	switch g.Type {
+	case ewkb.LineString:
+		changed = unwrapCoords(g.Coords, world)
+	case ewkb.Polygon:
+		for i, r := range g.Rings {
+			if unwrapCoords(r, world) {
+				changed = true
+				if r[0] != r[len(r)-1] {
+					return changed, false
+				}
+			}
+			if i > 0 && changed {
+				// move hole to the side of the exterior ring
+				minx, _, maxx, _ := (&ewkb.Geometry{Coords: g.Rings[0]}).Bounds()
+				shiftCoords(r, math.Round(((minx+maxx)/2-r[0].X)/world)*world)
+			}
+		}
+	}
+	for i := range g.Geoms {
+		c, ok := unwrapGeometry(&g.Geoms[i], world)
+		if !ok {
+			return c, false
+		}
+		changed = changed || c
+	}
+	return changed, true
+}
+
+// unwrapCoords shifts all coordinates after a jump of more than half of
+// the world. Shifted coordinates are moved east of the antimeridian.
+// Returns false if no coordinate was shifted.
+func unwrapCoords(coords []ewkb.Coord, world float64) bool {
+	changed := false
+	offset := 0.0
+	minx := math.Inf(1)
+	for i := range coords {
+		coords[i].X += offset
+		if i > 0 {
+			if d := coords[i].X - coords[i-1].X; d > world/2 {
+				offset -= world
+				coords[i].X -= world
+				changed = true
+			} else if d < -world/2 {
+				offset += world
+				coords[i].X += world
+				changed = true
+			}
+		}
+		minx = math.Min(minx, coords[i].X)
+	}
+	if changed && minx < -world/2 {
+		shiftCoords(coords, world)
+	}
+	return changed
+}
+
+func shiftCoords(coords []ewkb.Coord, dx float64) {
+	if dx == 0 {
+		return
+	}
+	for i := range coords {
+		coords[i].X += dx
+	}
+}
+
+// splitAtAntimeridian clips the unwrapped geometry into parts for each
+// world and moves these parts back to -180°/180°. Returns a
+// MultiLineString for linear and a MultiPolygon for polygonal geometries.
+func splitAtAntimeridian(g *ewkb.Geometry, world float64) (*ewkb.Geometry, error) {
+	gg := geos.NewGeos()
+	defer gg.Finish()
+
+	geom := gg.FromWkb(g.WKB())
+	if geom == nil {
+		return nil, errors.New("unable to split geometry at antimeridian")
+	}
+	defer gg.Destroy(geom)
+
+	result := &ewkb.Geometry{Type: ewkb.MultiLineString, SRID: g.SRID}
+	partType := ewkb.LineString
+	if isPolygonal(g) {
+		result.Type, partType = ewkb.MultiPolygon, ewkb.Polygon
+	}
+
+	minx, miny, maxx, maxy := g.Bounds()
+	half := world / 2
+	for k := math.Floor((minx + half) / world); k*world-half < maxx; k++ {
+		box := gg.BoundsPolygon(geos.Bounds{
+			MinX: k*world - half, MinY: miny - 1,
+			MaxX: k*world + half, MaxY: maxy + 1,
+		})
+		if box == nil {
+			return nil, errors.New("unable to create antimeridian bounds")
+		}
+		part := gg.Intersection(geom, box)
+		gg.Destroy(box)
+		if part == nil {
+			return nil, errors.New("unable to split geometry at antimeridian")
+		}
+		wkb := gg.AsWkb(part)
+		gg.Destroy(part)
+		p, err := ewkb.Decode(wkb)
+		if err != nil {
+			return nil, err
+		}
+		collectParts(p, partType, -k*world, &result.Geoms)
+	}
+	return result, nil
+}
+
+func isPolygonal(g *ewkb.Geometry) bool {
+	if g.Type == ewkb.Polygon {
+		return true
+	}
+	for i := range g.Geoms {
+		if isPolygonal(&g.Geoms[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectParts appends all members of type typ of g, shifted by dx.
+func collectParts(g *ewkb.Geometry, typ ewkb.Type, dx float64, parts *[]ewkb.Geometry) {
+	if g.Type == typ {
+		shiftCoords(g.Coords, dx)
+		for _, r := range g.Rings {
+			shiftCoords(r, dx)
+		}
+		*parts = append(*parts, ewkb.Geometry{Type: typ, Coords: g.Coords, Rings: g.Rings})
+		return
+	}
+	for i := range g.Geoms {
+		collectParts(&g.Geoms[i], typ, dx, parts)
+	}
+}
@@ -0,0 +1,80 @@
+package geom
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/omniscale/imposm3/geom/ewkb"
+)
+
+func TestUnwrapCoords(t *testing.T) {
+	for _, tc := range []struct {
+		coords   []ewkb.Coord
+		expected []ewkb.Coord
+		changed  bool
+	}{
+		{coords(170, 0, 175, 0), coords(170, 0, 175, 0), false},
+		{coords(170, 0, -170, 0), coords(170, 0, 190, 0), true},
+		// shifted east of the antimeridian
+		{coords(-170, 0, 170, 0), coords(190, 0, 170, 0), true},
+		{coords(179, -1, -179, -1, -179, 1, 179, 1, 179, -1), coords(179, -1, 181, -1, 181, 1, 179, 1, 179, -1), true},
+	} {
+		changed := unwrapCoords(tc.coords, 360)
+		if changed != tc.changed {
+			t.Errorf("unexpected changed %v for %v", changed, tc.expected)
+		}
+		for i, c := range tc.expected {
+			if tc.coords[i] != c {
+				t.Errorf("unexpected coord %d %v, expected %v", i, tc.coords[i], c)
+			}
+		}
+	}
+}
+
+func TestUnwrapGeometry(t *testing.T) {
+	g := ewkb.Geometry{Type: ewkb.Polygon, SRID: 4326, Rings: [][]ewkb.Coord{
+		coords(178, -2, -178, -2, -178, 2, 178, 2, 178, -2),
+		coords(-179.5, -1, -179, -1, -179, 1, -179.5, -1),
+	}}
+	changed, ok := unwrapGeometry(&g, 360)
+	if !changed || !ok {
+		t.Fatalf("unexpected result %v %v", changed, ok)
+	}
+	// hole moved to the exterior
+	for i, c := range coords(180.5, -1, 181, -1, 181, 1, 180.5, -1) {
+		if g.Rings[1][i] != c {
+			t.Errorf("unexpected hole coord %d %v, expected %v", i, g.Rings[1][i], c)
+		}
+	}
+
+	// ring around the pole
+	g = ewkb.Geometry{Type: ewkb.Polygon, SRID: 4326, Rings: [][]ewkb.Coord{
+		coords(0, 80, 120, 80, -120, 80, 0, 80),
+	}}
+	if _, ok := unwrapGeometry(&g, 360); ok {
+		t.Error("expected polar ring to fail")
+	}
+}
+
+func TestAntimeridianShift(t *testing.T) {
+	g := ewkb.Geometry{Type: ewkb.LineString, SRID: 4326, Coords: coords(179, 0, -179, 0)}
+	wkb := []byte(hex.EncodeToString(g.EWKB()))
+	result, err := Antimeridian(wkb, AntimeridianShift)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ewkb.DecodeHex(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Coords[1].X != 181 {
+		t.Errorf("unexpected shifted line %v", s.Coords)
+	}
+
+	// other projections are not changed
+	g.SRID = 25832
+	wkb = []byte(hex.EncodeToString(g.EWKB()))
+	if result, _ := Antimeridian(wkb, AntimeridianShift); string(result) != string(wkb) {
+		t.Error("expected unchanged geometry")
+	}
+}
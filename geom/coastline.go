@@ -0,0 +1,516 @@
+package geom
+
+import (
+	"math"
+	"sort"
+
+	"github.com/omniscale/imposm3/geom/ewkb"
+)
+
+// CoastlineWay is a coastline (natural=coastline) with the land on the
+// left side.
+type CoastlineWay struct {
+	ID     int64
+	Coords []ewkb.Coord
+}
+
+// CoastlineRing is a chain of coastline ways. Rings that are not closed
+// are errors in the coastline (missing or wrongly oriented ways), or they
+// are cut at the border of an extract.
+type CoastlineRing struct {
+	IDs    []int64
+	Coords []ewkb.Coord
+	Closed bool
+}
+
+// LandPolygon is a polygon with the closed coastline rings as exterior and
+// holes. IDs are the ways of all rings.
+type LandPolygon struct {
+	IDs   []int64
+	Rings [][]ewkb.Coord
+}
+
+// AssembleCoastlines joins the ways to rings. Ways are only joined in
+// their direction, where the last coordinate of a way is the first
+// coordinate of the next way.
+func AssembleCoastlines(ways []CoastlineWay) []CoastlineRing {
+	byStart := make(map[ewkb.Coord][]int, len(ways))
+	byEnd := make(map[ewkb.Coord][]int, len(ways))
+	for i, w := range ways {
+		if len(w.Coords) < 2 {
+			continue
+		}
+		byStart[w.Coords[0]] = append(byStart[w.Coords[0]], i)
+		byEnd[w.Coords[len(w.Coords)-1]] = append(byEnd[w.Coords[len(w.Coords)-1]], i)
+	}
+	used := make([]bool, len(ways))
+	// next returns the next unused way from candidates
+	next := func(candidates []int) int {
+		for _, i := range candidates {
+			if !used[i] {
+				used[i] = true
+				return i
+			}
+		}
+		return -1
+	}
+
+	var rings []CoastlineRing
+	for i, w := range ways {
+		if used[i] || len(w.Coords) < 2 {
+			continue
+		}
+		used[i] = true
+		r := CoastlineRing{IDs: []int64{w.ID}, Coords: append([]ewkb.Coord(nil), w.Coords...)}
+		for r.Coords[0] != r.Coords[len(r.Coords)-1] {
+			j := next(byStart[r.Coords[len(r.Coords)-1]])
+			if j < 0 {
+				break
+			}
+			r.IDs = append(r.IDs, ways[j].ID)
+			r.Coords = append(r.Coords, ways[j].Coords[1:]...)
+		}
+		for r.Coords[0] != r.Coords[len(r.Coords)-1] {
+			j := next(byEnd[r.Coords[0]])
+			if j < 0 {
+				break
+			}
+			prev := ways[j].Coords
+			r.IDs = append([]int64{ways[j].ID}, r.IDs...)
+			r.Coords = append(append([]ewkb.Coord(nil), prev[:len(prev)-1]...), r.Coords...)
+		}
+		r.Closed = len(r.Coords) >= 4 && r.Coords[0] == r.Coords[len(r.Coords)-1]
+		rings = append(rings, r)
+	}
+	return rings
+}
+
+// Land polygons are built for each box of a grid (see TileGrid). The
+// coastlines that intersect a box are clipped to the box and joined to
+// chains. Chains that cross the border of the box are closed along the
+// border, counter-clockwise from the end of a chain to the start of the
+// next chain, so that the land stays on the left side. Closed rings inside
+// of the box are land (counter-clockwise, e.g. islands) or water
+// (clockwise, e.g. lagoons). Each box is built independently, so that
+// updates only need the coastlines of the changed boxes.
+
+// BoxLand contains the land polygons of a box.
+type BoxLand struct {
+	Box      [4]float64
+	Polygons []LandPolygon
+	// Errors are the chains that end inside of the box, and the clockwise
+	// rings outside of all land.
+	Errors []CoastlineRing
+	// Unknown is set if no coastline crosses the border of the box and if
+	// the box has no closed rings. The box is either land or water, see
+	// SetBackground.
+	Unknown bool
+	// background is the land of the border if no coastline crosses it
+	background bool
+	// ends are the positions of all chains on the border
+	ends []borderEnd
+}
+
+// borderEnd is the start (entry) or end (exit) of a chain on the border,
+// at the position t along the border (counter-clockwise from the lower
+// left corner).
+type borderEnd struct {
+	t    float64
+	exit bool
+}
+
+// LandInBox builds the land polygons of the coastlines inside of box. ways
+// are all coastlines that intersect the box. Chains that end inside of the
+// box are connected to the nearest border of extent, if the box is at the
+// border of extent, e.g. coastlines that are cut at the border of an
+// extract. All other chains that end inside of the box are errors.
+func LandInBox(ways []CoastlineWay, box, extent [4]float64) BoxLand {
+	var pieces []CoastlineWay
+	for _, w := range ways {
+		for _, c := range clipLine(w.Coords, box) {
+			pieces = append(pieces, CoastlineWay{ID: w.ID, Coords: c})
+		}
+	}
+	bl := BoxLand{Box: box}
+	var islands, lagoons, chains []CoastlineRing
+	for _, r := range AssembleCoastlines(pieces) {
+		if r.Closed {
+			if coordsArea(r.Coords) > 0 {
+				islands = append(islands, r)
+			} else {
+				lagoons = append(lagoons, r)
+			}
+			continue
+		}
+		if !closeToBorder(&r, box, extent) {
+			bl.Errors = append(bl.Errors, r)
+			continue
+		}
+		chains = append(chains, r)
+	}
+
+	var exteriors []CoastlineRing
+	if len(chains) > 0 {
+		exteriors = bl.closeChains(chains)
+	} else {
+		switch {
+		case len(lagoons) > 0 && !insideAny(lagoons, islands):
+			bl.background = true
+		case len(islands) > 0:
+			bl.background = false
+		default:
+			bl.Unknown = true
+			return bl
+		}
+		if bl.background {
+			exteriors = append(exteriors, CoastlineRing{Coords: boxRing(box), Closed: true})
+		}
+	}
+	exteriors = append(exteriors, islands...)
+	bl.Polygons, bl.Errors = withHoles(exteriors, lagoons, bl.Errors)
+	return bl
+}
+
+// SetBackground sets the land of a box with Unknown land.
+func (bl *BoxLand) SetBackground(land bool) {
+	if !bl.Unknown {
+		return
+	}
+	bl.Unknown = false
+	bl.background = land
+	if land {
+		bl.Polygons = []LandPolygon{{Rings: [][]ewkb.Coord{boxRing(bl.Box)}}}
+	}
+}
+
+// Sides of a box, in counter-clockwise order.
+const (
+	SideBottom = iota
+	SideRight
+	SideTop
+	SideLeft
+)
+
+// SideLand returns whether the center of the side of the box is land.
+func (bl *BoxLand) SideLand(side int) bool {
+	if len(bl.ends) == 0 {
+		return bl.background
+	}
+	w, h := bl.Box[2]-bl.Box[0], bl.Box[3]-bl.Box[1]
+	t := [4]float64{w / 2, w + h/2, w + h + w/2, 2*w + h + h/2}[side]
+	perimeter := 2 * (w + h)
+	// the land continues counter-clockwise after the exit of a chain till
+	// the next entry
+	last, dist := borderEnd{}, math.Inf(1)
+	for _, e := range bl.ends {
+		d := t - e.t
+		if d < 0 {
+			d += perimeter
+		}
+		if d < dist {
+			last, dist = e, d
+		}
+	}
+	return last.exit
+}
+
+// closeChains joins the chains to rings along the border of the box.
+func (bl *BoxLand) closeChains(chains []CoastlineRing) []CoastlineRing {
+	starts := make([]float64, len(chains))
+	ends := make([]float64, len(chains))
+	for i, c := range chains {
+		starts[i] = borderPosition(c.Coords[0], bl.Box)
+		ends[i] = borderPosition(c.Coords[len(c.Coords)-1], bl.Box)
+		bl.ends = append(bl.ends, borderEnd{t: starts[i]}, borderEnd{t: ends[i], exit: true})
+	}
+	w, h := bl.Box[2]-bl.Box[0], bl.Box[3]-bl.Box[1]
+	perimeter := 2 * (w + h)
+
+	var rings []CoastlineRing
+	used := make([]bool, len(chains))
+	for i := range chains {
+		if used[i] {
+			continue
+		}
+		r := CoastlineRing{Closed: true}
+		for cur := i; ; {
+			used[cur] = true
+			r.IDs = append(r.IDs, chains[cur].IDs...)
+			r.Coords = append(r.Coords, chains[cur].Coords...)
+			// next entry counter-clockwise along the border
+			next, dist := i, math.Inf(1)
+			for j := range chains {
+				if used[j] && j != i {
+					continue
+				}
+				d := starts[j] - ends[cur]
+				if d < 0 {
+					d += perimeter
+				}
+				if d < dist {
+					next, dist = j, d
+				}
+			}
+			r.Coords = append(r.Coords, boxCorners(bl.Box, ends[cur], dist)...)
+			if next == i {
+				break
+			}
+			cur = next
+		}
+		r.Coords = append(r.Coords, r.Coords[0])
+		rings = append(rings, r)
+	}
+	return rings
+}
+
+// withHoles returns the polygons of the exteriors with the lagoons as holes
+// of the smallest exterior they are inside of. Lagoons outside of all
+// exteriors are added to errors.
+func withHoles(exteriors, lagoons, errors []CoastlineRing) ([]LandPolygon, []CoastlineRing) {
+	polygons := make([]LandPolygon, len(exteriors))
+	areas := make([]float64, len(exteriors))
+	for i, e := range exteriors {
+		polygons[i] = LandPolygon{
+			IDs:   append([]int64(nil), e.IDs...),
+			Rings: [][]ewkb.Coord{e.Coords},
+		}
+		areas[i] = math.Abs(coordsArea(e.Coords))
+	}
+	for _, l := range lagoons {
+		p := -1
+		for i, e := range exteriors {
+			if (p < 0 || areas[i] < areas[p]) && coordInRing(l.Coords[0], e.Coords) {
+				p = i
+			}
+		}
+		if p < 0 {
+			errors = append(errors, l)
+			continue
+		}
+		polygons[p].IDs = append(polygons[p].IDs, l.IDs...)
+		polygons[p].Rings = append(polygons[p].Rings, l.Coords)
+	}
+	return polygons, errors
+}
+
+// insideAny returns whether all rings are inside of any of the others.
+func insideAny(rings, others []CoastlineRing) bool {
+	for _, r := range rings {
+		inside := false
+		for _, o := range others {
+			if coordInRing(r.Coords[0], o.Coords) {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			return false
+		}
+	}
+	return true
+}
+
+// closeToBorder connects the ends of the chain that are inside of the box
+// to the nearest side of the box that is on the border of extent. Returns
+// false if an end can't be connected.
+func closeToBorder(r *CoastlineRing, box, extent [4]float64) bool {
+	first, ok := nearestBorder(r.Coords[0], box, extent)
+	if !ok {
+		return false
+	}
+	last, ok := nearestBorder(r.Coords[len(r.Coords)-1], box, extent)
+	if !ok {
+		return false
+	}
+	if first != r.Coords[0] {
+		r.Coords = append([]ewkb.Coord{first}, r.Coords...)
+	}
+	if last != r.Coords[len(r.Coords)-1] {
+		r.Coords = append(r.Coords, last)
+	}
+	return true
+}
+
+// nearestBorder returns p if it is on the border of the box, or the
+// nearest coordinate on the sides of the box that are on the border of
+// extent.
+func nearestBorder(p ewkb.Coord, box, extent [4]float64) (ewkb.Coord, bool) {
+	if onBorder(p, box) {
+		return p, true
+	}
+	var nearest ewkb.Coord
+	dist := math.Inf(1)
+	for _, c := range []struct {
+		side bool
+		d    float64
+		p    ewkb.Coord
+	}{
+		{box[0] == extent[0], p.X - box[0], ewkb.Coord{X: box[0], Y: p.Y}},
+		{box[1] == extent[1], p.Y - box[1], ewkb.Coord{X: p.X, Y: box[1]}},
+		{box[2] == extent[2], box[2] - p.X, ewkb.Coord{X: box[2], Y: p.Y}},
+		{box[3] == extent[3], box[3] - p.Y, ewkb.Coord{X: p.X, Y: box[3]}},
+	} {
+		if c.side && c.d < dist {
+			nearest, dist = c.p, c.d
+		}
+	}
+	return nearest, !math.IsInf(dist, 1)
+}
+
+func onBorder(p ewkb.Coord, box [4]float64) bool {
+	return p.X == box[0] || p.Y == box[1] || p.X == box[2] || p.Y == box[3]
+}
+
+// borderPosition returns the position of p on the border of the box,
+// counter-clockwise from the lower left corner.
+func borderPosition(p ewkb.Coord, box [4]float64) float64 {
+	w, h := box[2]-box[0], box[3]-box[1]
+	switch {
+	case p.Y == box[1]:
+		return p.X - box[0]
+	case p.X == box[2]:
+		return w + p.Y - box[1]
+	case p.Y == box[3]:
+		return w + h + box[2] - p.X
+	default:
+		return 2*w + h + box[3] - p.Y
+	}
+}
+
+// boxCorners returns the corners of the box that are passed when going
+// counter-clockwise from the position t along dist.
+func boxCorners(box [4]float64, t, dist float64) []ewkb.Coord {
+	w, h := box[2]-box[0], box[3]-box[1]
+	perimeter := 2 * (w + h)
+	ring := boxRing(box)
+	type corner struct {
+		d float64
+		c ewkb.Coord
+	}
+	var corners []corner
+	for i, ct := range []float64{0, w, w + h, 2*w + h} {
+		d := ct - t
+		if d <= 0 {
+			d += perimeter
+		}
+		if d < dist {
+			corners = append(corners, corner{d, ring[i]})
+		}
+	}
+	sort.Slice(corners, func(i, j int) bool { return corners[i].d < corners[j].d })
+	coords := make([]ewkb.Coord, len(corners))
+	for i, c := range corners {
+		coords[i] = c.c
+	}
+	return coords
+}
+
+// boxRing returns the counter-clockwise ring of the box.
+func boxRing(box [4]float64) []ewkb.Coord {
+	return []ewkb.Coord{
+		{X: box[0], Y: box[1]}, {X: box[2], Y: box[1]},
+		{X: box[2], Y: box[3]}, {X: box[0], Y: box[3]},
+		{X: box[0], Y: box[1]},
+	}
+}
+
+// clipLine returns the parts of the line inside of the box. Coordinates
+// where the line crosses the border are exactly on the border.
+func clipLine(coords []ewkb.Coord, box [4]float64) [][]ewkb.Coord {
+	var parts [][]ewkb.Coord
+	var part []ewkb.Coord
+	flush := func() {
+		if len(part) > 1 {
+			parts = append(parts, part)
+		}
+		part = nil
+	}
+	for i := 0; i+1 < len(coords); i++ {
+		a, b, ok := clipSegment(coords[i], coords[i+1], box)
+		if !ok {
+			flush()
+			continue
+		}
+		if len(part) > 0 && part[len(part)-1] != a {
+			flush()
+		}
+		if len(part) == 0 {
+			part = append(part, a)
+		}
+		if b != part[len(part)-1] {
+			part = append(part, b)
+		}
+	}
+	flush()
+	return parts
+}
+
+// clipSegment clips the segment to the box (Liang-Barsky). Returns false
+// if the segment is outside of the box.
+func clipSegment(p0, p1 ewkb.Coord, box [4]float64) (ewkb.Coord, ewkb.Coord, bool) {
+	dx, dy := p1.X-p0.X, p1.Y-p0.Y
+	t0, t1 := 0.0, 1.0
+	// sides of the box where the segment enters and exits
+	e0, e1 := -1, -1
+	for side, pq := range [4][2]float64{
+		{-dx, p0.X - box[0]}, {-dy, p0.Y - box[1]},
+		{dx, box[2] - p0.X}, {dy, box[3] - p0.Y},
+	} {
+		p, q := pq[0], pq[1]
+		if p == 0 {
+			if q < 0 {
+				return ewkb.Coord{}, ewkb.Coord{}, false
+			}
+			continue
+		}
+		r := q / p
+		if p < 0 {
+			if r > t1 {
+				return ewkb.Coord{}, ewkb.Coord{}, false
+			}
+			if r > t0 {
+				t0, e0 = r, side
+			}
+		} else {
+			if r < t0 {
+				return ewkb.Coord{}, ewkb.Coord{}, false
+			}
+			if r < t1 {
+				t1, e1 = r, side
+			}
+		}
+	}
+	at := func(t float64, side int, p ewkb.Coord) ewkb.Coord {
+		if side < 0 {
+			return p
+		}
+		c := ewkb.Coord{
+			X: math.Min(math.Max(p0.X+t*dx, box[0]), box[2]),
+			Y: math.Min(math.Max(p0.Y+t*dy, box[1]), box[3]),
+		}
+		switch side {
+		case 0:
+			c.X = box[0]
+		case 1:
+			c.Y = box[1]
+		case 2:
+			c.X = box[2]
+		case 3:
+			c.Y = box[3]
+		}
+		return c
+	}
+	return at(t0, e0, p0), at(t1, e1, p1), true
+}
+
+// coordInRing returns true if p is inside of the ring (even-odd rule).
+func coordInRing(p ewkb.Coord, ring []ewkb.Coord) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Y > p.Y) != (b.Y > p.Y) && p.X < (b.X-a.X)*(p.Y-a.Y)/(b.Y-a.Y)+a.X {
+			inside = !inside
+		}
+	}
+	return inside
+}
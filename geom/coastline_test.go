@@ -0,0 +1,142 @@
+package geom
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/omniscale/imposm3/geom/ewkb"
+)
+
+func TestAssembleCoastlines(t *testing.T) {
+	rings := AssembleCoastlines([]CoastlineWay{
+		{ID: 2, Coords: coords(10, 0, 10, 10, 0, 10)},
+		{ID: 1, Coords: coords(0, 0, 10, 0)},
+		{ID: 3, Coords: coords(0, 10, 0, 0)},
+		// wrong direction
+		{ID: 4, Coords: coords(20, 0, 30, 0)},
+		{ID: 5, Coords: coords(20, 10, 30, 0)},
+	})
+	if len(rings) != 3 {
+		t.Fatalf("unexpected rings %v", rings)
+	}
+	if r := rings[0]; !r.Closed || !reflect.DeepEqual(r.IDs, []int64{2, 3, 1}) || len(r.Coords) != 5 {
+		t.Errorf("unexpected ring %v", r)
+	}
+	if rings[0].Coords[0] != rings[0].Coords[4] {
+		t.Errorf("ring not closed %v", rings[0].Coords)
+	}
+	for _, r := range rings[1:] {
+		if r.Closed || len(r.IDs) != 1 {
+			t.Errorf("unexpected ring %v", r)
+		}
+	}
+}
+
+func TestClipLine(t *testing.T) {
+	parts := clipLine(coords(-10, 50, 50, 50, 50, 150, 60, 150, 60, 50, 110, 50), [4]float64{0, 0, 100, 100})
+	expected := [][]ewkb.Coord{
+		coords(0, 50, 50, 50, 50, 100),
+		coords(60, 100, 60, 50, 100, 50),
+	}
+	if !reflect.DeepEqual(parts, expected) {
+		t.Errorf("unexpected parts %v", parts)
+	}
+	if parts := clipLine(coords(-10, -10, -10, 200), [4]float64{0, 0, 100, 100}); len(parts) != 0 {
+		t.Errorf("unexpected parts %v", parts)
+	}
+}
+
+func TestLandInBox(t *testing.T) {
+	box := [4]float64{0, 0, 100, 100}
+	world := [4]float64{-1000, -1000, 1000, 1000}
+	for _, tc := range []struct {
+		name    string
+		ways    []CoastlineWay
+		extent  [4]float64
+		area    float64
+		rings   int
+		errors  int
+		unknown bool
+		sides   [4]bool
+	}{
+		{
+			name: "crossing",
+			// land in the south
+			ways:   []CoastlineWay{{ID: 1, Coords: coords(150, 60, -50, 60)}},
+			extent: world,
+			area:   6000, rings: 1,
+			sides: [4]bool{true, true, false, true},
+		},
+		{
+			name: "crossing corner",
+			ways: []CoastlineWay{
+				// land in the north east
+				{ID: 1, Coords: coords(40, 150, 40, 40)},
+				{ID: 2, Coords: coords(40, 40, 150, 40)},
+			},
+			extent: world,
+			area:   3600, rings: 1,
+			sides: [4]bool{false, true, true, false},
+		},
+		{
+			name:   "island",
+			ways:   []CoastlineWay{{ID: 1, Coords: coords(10, 10, 20, 10, 20, 20, 10, 10)}},
+			extent: world,
+			area:   50, rings: 1,
+		},
+		{
+			name:   "lagoon",
+			ways:   []CoastlineWay{{ID: 1, Coords: coords(10, 10, 20, 20, 20, 10, 10, 10)}},
+			extent: world,
+			area:   10000 - 50, rings: 2,
+			sides: [4]bool{true, true, true, true},
+		},
+		{
+			name:    "empty",
+			extent:  world,
+			unknown: true,
+		},
+		{
+			name: "cut at extent",
+			// ends inside of the box, connected to the right side
+			ways:   []CoastlineWay{{ID: 1, Coords: coords(80, 60, 0, 60)}},
+			extent: box,
+			area:   6000, rings: 1,
+			sides: [4]bool{true, true, false, true},
+		},
+		{
+			name:    "cut inside extent",
+			ways:    []CoastlineWay{{ID: 1, Coords: coords(80, 60, 0, 60)}},
+			extent:  world,
+			errors:  1,
+			unknown: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bl := LandInBox(tc.ways, box, tc.extent)
+			if bl.Unknown != tc.unknown || len(bl.Errors) != tc.errors {
+				t.Fatalf("unexpected land %+v", bl)
+			}
+			area := 0.0
+			rings := 0
+			for _, p := range bl.Polygons {
+				for _, r := range p.Rings {
+					area += coordsArea(r)
+					rings++
+				}
+			}
+			if math.Abs(area-tc.area) > 1e-9 || rings != tc.rings {
+				t.Errorf("unexpected area %f or rings %d: %v", area, rings, bl.Polygons)
+			}
+			if tc.unknown {
+				return
+			}
+			for side, land := range tc.sides {
+				if bl.SideLand(side) != land {
+					t.Errorf("unexpected land of side %d", side)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package geom
+
+import (
+	"encoding/hex"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/omniscale/imposm3/geom/ewkb"
+)
+
+// ParseElevation parses the value of an ele tag in meters. Values in feet
+// (e.g. 1200 ft) are converted to meters. Returns false for missing or
+// invalid values.
+func ParseElevation(v string) (float64, bool) {
+	v = strings.TrimSpace(v)
+	scale := 1.0
+	if strings.HasSuffix(v, "ft") {
+		v = strings.TrimSuffix(v, "ft")
+		scale = 0.3048
+	} else if strings.HasSuffix(v, "m") {
+		v = strings.TrimSuffix(v, "m")
+	}
+	v = strings.Replace(strings.TrimSpace(v), ",", ".", 1)
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f * scale, true
+}
+
+// WithElevation returns the hex encoded EWKB geometry with Z coordinates.
+// ele returns the elevation for each coordinate.
+func WithElevation(wkb []byte, ele func(x, y float64) float64) ([]byte, error) {
+	g, err := ewkb.DecodeHex(wkb)
+	if err != nil {
+		return nil, err
+	}
+	g.SetZ(func(c ewkb.Coord) float64 {
+		return ele(c.X, c.Y)
+	})
+	b := g.EWKB()
+	result := make([]byte, hex.EncodedLen(len(b)))
+	hex.Encode(result, b)
+	return result, nil
+}
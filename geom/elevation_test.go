@@ -0,0 +1,41 @@
+package geom
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseElevation(t *testing.T) {
+	for _, tc := range []struct {
+		v   string
+		ele float64
+		ok  bool
+	}{
+		{"1234", 1234, true},
+		{"1234.5", 1234.5, true},
+		{"1234,5", 1234.5, true},
+		{"-12", -12, true},
+		{"1234 m", 1234, true},
+		{"1234m", 1234, true},
+		{"1000 ft", 304.8, true},
+		{"", 0, false},
+		{"high", 0, false},
+		{"NaN", 0, false},
+	} {
+		ele, ok := ParseElevation(tc.v)
+		if ok != tc.ok || ele != tc.ele {
+			t.Errorf("unexpected elevation for %q: %v %v", tc.v, ele, ok)
+		}
+	}
+}
+
+func TestWithElevation(t *testing.T) {
+	wkb, err := WithElevation([]byte("0101000020E6100000000000000000F03F0000000000000040"),
+		func(x, y float64) float64 { return 3 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.ToUpper(string(wkb)); s != "01010000A0E6100000000000000000F03F00000000000000400000000000000840" {
+		t.Errorf("unexpected EWKB %s", s)
+	}
+}
@@ -0,0 +1,188 @@
+package ewkb
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// WKB returns the geometry as little endian ISO WKB, without SRID.
+// Z coordinates are encoded with the ISO type offset (e.g. 1001 for Point Z).
+func (g *Geometry) WKB() []byte {
+	return g.appendWKB(make([]byte, 0, g.wkbSize()))
+}
+
+// EWKB returns the geometry as little endian EWKB, with SRID if set.
+// Z coordinates are encoded with the EWKB Z flag.
+func (g *Geometry) EWKB() []byte {
+	size := g.wkbSize()
+	if g.SRID != 0 {
+		size += 4
+	}
+	return g.appendEWKB(make([]byte, 0, size), true)
+}
+
+// SetZ sets the Z coordinate of all coordinates to the result of f.
+func (g *Geometry) SetZ(f func(Coord) float64) {
+	g.HasZ = true
+	for i := range g.Coords {
+		g.Coords[i].Z = f(g.Coords[i])
+	}
+	for _, r := range g.Rings {
+		for i := range r {
+			r[i].Z = f(r[i])
+		}
+	}
+	for i := range g.Geoms {
+		g.Geoms[i].SetZ(f)
+	}
+}
+
+// OrientPolygons reverses the rings of all polygons, so that the exterior
+// rings are counter-clockwise and the interior rings clockwise, or the
+// other way around if exteriorCCW is false. Returns true if any ring was
+// reversed.
+func (g *Geometry) OrientPolygons(exteriorCCW bool) bool {
+	changed := false
+	if g.Type == Polygon {
+		for i, r := range g.Rings {
+			ccw := ringArea(r) > 0
+			if ccw != (exteriorCCW == (i == 0)) {
+				reverseCoords(r)
+				changed = true
+			}
+		}
+	}
+	for i := range g.Geoms {
+		if g.Geoms[i].OrientPolygons(exteriorCCW) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// ringArea returns the signed area of the ring, positive for
+// counter-clockwise rings.
+func ringArea(coords []Coord) float64 {
+	area := 0.0
+	for i := 0; i < len(coords)-1; i++ {
+		area += coords[i].X*coords[i+1].Y - coords[i+1].X*coords[i].Y
+	}
+	return area / 2
+}
+
+func reverseCoords(coords []Coord) {
+	for i, j := 0, len(coords)-1; i < j; i, j = i+1, j-1 {
+		coords[i], coords[j] = coords[j], coords[i]
+	}
+}
+
+func (g *Geometry) wkbSize() int {
+	coordSize := 16
+	if g.HasZ {
+		coordSize = 24
+	}
+	size := 5
+	switch g.Type {
+	case Point:
+		size += coordSize
+	case LineString:
+		size += 4 + len(g.Coords)*coordSize
+	case Polygon:
+		size += 4
+		for _, r := range g.Rings {
+			size += 4 + len(r)*coordSize
+		}
+	default:
+		size += 4
+		for i := range g.Geoms {
+			size += g.Geoms[i].wkbSize()
+		}
+	}
+	return size
+}
+
+func (g *Geometry) appendWKB(buf []byte) []byte {
+	typ := uint32(g.Type)
+	if g.HasZ {
+		typ += 1000
+	}
+	buf = append(buf, 1)
+	buf = appendUint32(buf, typ)
+	return g.appendBody(buf, func(buf []byte, g *Geometry) []byte {
+		return g.appendWKB(buf)
+	})
+}
+
+func (g *Geometry) appendEWKB(buf []byte, withSRID bool) []byte {
+	typ := uint32(g.Type)
+	if g.HasZ {
+		typ |= zFlag
+	}
+	withSRID = withSRID && g.SRID != 0
+	if withSRID {
+		typ |= sridFlag
+	}
+	buf = append(buf, 1)
+	buf = appendUint32(buf, typ)
+	if withSRID {
+		buf = appendUint32(buf, uint32(g.SRID))
+	}
+	return g.appendBody(buf, func(buf []byte, g *Geometry) []byte {
+		return g.appendEWKB(buf, false)
+	})
+}
+
+// appendBody appends the coordinates of the geometry, members of
+// multi geometries are appended with member.
+func (g *Geometry) appendBody(buf []byte, member func([]byte, *Geometry) []byte) []byte {
+	switch g.Type {
+	case Point:
+		if len(g.Coords) == 0 {
+			buf = appendCoord(buf, Coord{math.NaN(), math.NaN(), math.NaN()}, g.HasZ)
+		} else {
+			buf = appendCoord(buf, g.Coords[0], g.HasZ)
+		}
+	case LineString:
+		buf = appendCoords(buf, g.Coords, g.HasZ)
+	case Polygon:
+		buf = appendUint32(buf, uint32(len(g.Rings)))
+		for _, r := range g.Rings {
+			buf = appendCoords(buf, r, g.HasZ)
+		}
+	default:
+		buf = appendUint32(buf, uint32(len(g.Geoms)))
+		for i := range g.Geoms {
+			buf = member(buf, &g.Geoms[i])
+		}
+	}
+	return buf
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	var tmp [4]byte
+	binary.LittleEndian.PutUint32(tmp[:], v)
+	return append(buf, tmp[:]...)
+}
+
+func appendFloat64(buf []byte, v float64) []byte {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(v))
+	return append(buf, tmp[:]...)
+}
+
+func appendCoord(buf []byte, c Coord, hasZ bool) []byte {
+	buf = appendFloat64(buf, c.X)
+	buf = appendFloat64(buf, c.Y)
+	if hasZ {
+		buf = appendFloat64(buf, c.Z)
+	}
+	return buf
+}
+
+func appendCoords(buf []byte, coords []Coord, hasZ bool) []byte {
+	buf = appendUint32(buf, uint32(len(coords)))
+	for _, c := range coords {
+		buf = appendCoord(buf, c, hasZ)
+	}
+	return buf
+}
@@ -0,0 +1,294 @@
+/*
+Package ewkb decodes (E)WKB geometries without GEOS.
+
+It is used by database backends that need to access the coordinates of
+the hex encoded EWKB geometries that are passed to the Inserter.
+*/
+package ewkb
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+)
+
+type Type uint32
+
+const (
+	Unknown            Type = 0
+	Point              Type = 1
+	LineString         Type = 2
+	Polygon            Type = 3
+	MultiPoint         Type = 4
+	MultiLineString    Type = 5
+	MultiPolygon       Type = 6
+	GeometryCollection Type = 7
+)
+
+func (t Type) String() string {
+	switch t {
+	case Point:
+		return "Point"
+	case LineString:
+		return "LineString"
+	case Polygon:
+		return "Polygon"
+	case MultiPoint:
+		return "MultiPoint"
+	case MultiLineString:
+		return "MultiLineString"
+	case MultiPolygon:
+		return "MultiPolygon"
+	case GeometryCollection:
+		return "GeometryCollection"
+	}
+	return "Unknown"
+}
+
+const (
+	zFlag    = 0x80000000
+	mFlag    = 0x40000000
+	sridFlag = 0x20000000
+)
+
+var ErrTruncated = errors.New("truncated WKB")
+
+type Coord struct {
+	X, Y, Z float64
+}
+
+// Geometry is a decoded geometry.
+//
+// Coords contains the coordinates of Point and LineString geometries.
+// Rings contains the coordinates of each Polygon ring, starting with the
+// exterior ring. Geoms contains the members of Multi* geometries and
+// GeometryCollections.
+type Geometry struct {
+	Type   Type
+	SRID   int
+	HasZ   bool
+	Coords []Coord
+	Rings  [][]Coord
+	Geoms  []Geometry
+}
+
+// Bounds returns the bounding box of all coordinates.
+// Returns +Inf/-Inf for empty geometries.
+func (g *Geometry) Bounds() (minx, miny, maxx, maxy float64) {
+	minx, miny = math.Inf(1), math.Inf(1)
+	maxx, maxy = math.Inf(-1), math.Inf(-1)
+	g.EachCoord(func(c Coord) {
+		minx = math.Min(minx, c.X)
+		miny = math.Min(miny, c.Y)
+		maxx = math.Max(maxx, c.X)
+		maxy = math.Max(maxy, c.Y)
+	})
+	return
+}
+
+// EachCoord calls f for all coordinates of the geometry.
+func (g *Geometry) EachCoord(f func(Coord)) {
+	for _, c := range g.Coords {
+		f(c)
+	}
+	for _, r := range g.Rings {
+		for _, c := range r {
+			f(c)
+		}
+	}
+	for i := range g.Geoms {
+		g.Geoms[i].EachCoord(f)
+	}
+}
+
+// IsEmpty returns true if the geometry has no coordinates.
+func (g *Geometry) IsEmpty() bool {
+	empty := true
+	g.EachCoord(func(Coord) { empty = false })
+	return empty
+}
+
+// DecodeHex decodes a hex encoded (E)WKB geometry.
+func DecodeHex(buf []byte) (*Geometry, error) {
+	b := make([]byte, hex.DecodedLen(len(buf)))
+	if _, err := hex.Decode(b, buf); err != nil {
+		return nil, err
+	}
+	return Decode(b)
+}
+
+// Decode decodes a (E)WKB geometry.
+func Decode(buf []byte) (*Geometry, error) {
+	d := decoder{buf: buf}
+	g := &Geometry{}
+	if err := d.geometry(g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+type decoder struct {
+	buf   []byte
+	pos   int
+	order binary.ByteOrder
+	hasZ  bool
+	hasM  bool
+}
+
+func (d *decoder) byteOrder() error {
+	if d.pos >= len(d.buf) {
+		return ErrTruncated
+	}
+	switch d.buf[d.pos] {
+	case 0:
+		d.order = binary.BigEndian
+	case 1:
+		d.order = binary.LittleEndian
+	default:
+		return fmt.Errorf("invalid WKB byte order %d", d.buf[d.pos])
+	}
+	d.pos++
+	return nil
+}
+
+func (d *decoder) uint32() (uint32, error) {
+	if d.pos+4 > len(d.buf) {
+		return 0, ErrTruncated
+	}
+	v := d.order.Uint32(d.buf[d.pos:])
+	d.pos += 4
+	return v, nil
+}
+
+func (d *decoder) float64() (float64, error) {
+	if d.pos+8 > len(d.buf) {
+		return 0, ErrTruncated
+	}
+	v := math.Float64frombits(d.order.Uint64(d.buf[d.pos:]))
+	d.pos += 8
+	return v, nil
+}
+
+func (d *decoder) coord() (Coord, error) {
+	var c Coord
+	var err error
+	if c.X, err = d.float64(); err != nil {
+		return c, err
+	}
+	if c.Y, err = d.float64(); err != nil {
+		return c, err
+	}
+	if d.hasZ {
+		if c.Z, err = d.float64(); err != nil {
+			return c, err
+		}
+	}
+	if d.hasM {
+		if _, err = d.float64(); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+func (d *decoder) coords() ([]Coord, error) {
+	n, err := d.uint32()
+	if err != nil {
+		return nil, err
+	}
+	// each coord requires at least 16 bytes, check before allocating
+	if int(n) > (len(d.buf)-d.pos)/16 {
+		return nil, ErrTruncated
+	}
+	coords := make([]Coord, n)
+	for i := range coords {
+		if coords[i], err = d.coord(); err != nil {
+			return nil, err
+		}
+	}
+	return coords, nil
+}
+
+func (d *decoder) geometry(g *Geometry) error {
+	if err := d.byteOrder(); err != nil {
+		return err
+	}
+	typ, err := d.uint32()
+	if err != nil {
+		return err
+	}
+	if typ&sridFlag != 0 {
+		srid, err := d.uint32()
+		if err != nil {
+			return err
+		}
+		g.SRID = int(srid)
+	}
+	d.hasZ = typ&zFlag != 0
+	d.hasM = typ&mFlag != 0
+	typ &^= zFlag | mFlag | sridFlag
+
+	// ISO WKB encodes dimensions as 1000/2000/3000 offsets
+	switch typ / 1000 {
+	case 1:
+		d.hasZ = true
+	case 2:
+		d.hasM = true
+	case 3:
+		d.hasZ, d.hasM = true, true
+	}
+	typ %= 1000
+
+	g.Type = Type(typ)
+	g.HasZ = d.hasZ
+
+	switch g.Type {
+	case Point:
+		c, err := d.coord()
+		if err != nil {
+			return err
+		}
+		// empty points are encoded as NaN coordinates
+		if !math.IsNaN(c.X) || !math.IsNaN(c.Y) {
+			g.Coords = []Coord{c}
+		}
+	case LineString:
+		if g.Coords, err = d.coords(); err != nil {
+			return err
+		}
+	case Polygon:
+		n, err := d.uint32()
+		if err != nil {
+			return err
+		}
+		if int(n) > (len(d.buf)-d.pos)/4 {
+			return ErrTruncated
+		}
+		g.Rings = make([][]Coord, n)
+		for i := range g.Rings {
+			if g.Rings[i], err = d.coords(); err != nil {
+				return err
+			}
+		}
+	case MultiPoint, MultiLineString, MultiPolygon, GeometryCollection:
+		n, err := d.uint32()
+		if err != nil {
+			return err
+		}
+		// each member requires at least 5 bytes
+		if int(n) > (len(d.buf)-d.pos)/5 {
+			return ErrTruncated
+		}
+		g.Geoms = make([]Geometry, n)
+		for i := range g.Geoms {
+			if err := d.geometry(&g.Geoms[i]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported WKB geometry type %d", typ)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package ewkb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeHexPoint(t *testing.T) {
+	for _, tc := range []struct {
+		hex  string
+		srid int
+		z    bool
+		c    Coord
+	}{
+		{"0101000000000000000000F03F0000000000000040", 0, false, Coord{1, 2, 0}},
+		{"0101000020E6100000000000000000F03F0000000000000040", 4326, false, Coord{1, 2, 0}},
+		{"00000000013FF00000000000004000000000000000", 0, false, Coord{1, 2, 0}},
+		{"0101000080000000000000F03F00000000000000400000000000000840", 0, true, Coord{1, 2, 3}},
+		{"01E9030000000000000000F03F00000000000000400000000000000840", 0, true, Coord{1, 2, 3}},
+	} {
+		g, err := DecodeHex([]byte(tc.hex))
+		if err != nil {
+			t.Fatal(tc.hex, err)
+		}
+		if g.Type != Point || g.SRID != tc.srid || g.HasZ != tc.z {
+			t.Errorf("%s: unexpected geometry %#v", tc.hex, g)
+		}
+		if len(g.Coords) != 1 || g.Coords[0] != tc.c {
+			t.Errorf("%s: unexpected coords %v", tc.hex, g.Coords)
+		}
+	}
+}
+
+type wkbBuf struct {
+	bytes.Buffer
+}
+
+func (b *wkbBuf) header(typ uint32) {
+	b.WriteByte(1)
+	binary.Write(b, binary.LittleEndian, typ)
+}
+
+func (b *wkbBuf) coords(coords ...float64) {
+	binary.Write(b, binary.LittleEndian, uint32(len(coords)/2))
+	binary.Write(b, binary.LittleEndian, coords)
+}
+
+func TestDecodeMultiPolygon(t *testing.T) {
+	b := &wkbBuf{}
+	b.header(uint32(MultiPolygon) | sridFlag)
+	binary.Write(b, binary.LittleEndian, uint32(3857))
+	binary.Write(b, binary.LittleEndian, uint32(2))
+
+	b.header(uint32(Polygon))
+	binary.Write(b, binary.LittleEndian, uint32(2))
+	b.coords(0, 0, 10, 0, 10, 10, 0, 0)
+	b.coords(1, 1, 2, 1, 2, 2, 1, 1)
+
+	b.header(uint32(Polygon))
+	binary.Write(b, binary.LittleEndian, uint32(1))
+	b.coords(20, 20, 30, 20, 30, -5, 20, 20)
+
+	g, err := Decode(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Type != MultiPolygon || g.SRID != 3857 || len(g.Geoms) != 2 {
+		t.Fatalf("unexpected geometry %#v", g)
+	}
+	if len(g.Geoms[0].Rings) != 2 || len(g.Geoms[1].Rings) != 1 {
+		t.Errorf("unexpected rings %#v", g.Geoms)
+	}
+	if !reflect.DeepEqual(g.Geoms[0].Rings[1][1], Coord{2, 1, 0}) {
+		t.Errorf("unexpected coord %v", g.Geoms[0].Rings[1][1])
+	}
+
+	minx, miny, maxx, maxy := g.Bounds()
+	if minx != 0 || miny != -5 || maxx != 30 || maxy != 20 {
+		t.Errorf("unexpected bounds %v %v %v %v", minx, miny, maxx, maxy)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, hex := range []string{
+		"",
+		"02",
+		"0101000000000000000000F03F",
+		"0102000000FFFFFFFF",
+		"0109000000",
+		"zz",
+	} {
+		if _, err := DecodeHex([]byte(hex)); err == nil {
+			t.Errorf("expected error for %q", hex)
+		}
+	}
+}
+
+func TestWKB(t *testing.T) {
+	for _, tc := range []struct {
+		ewkb string
+		wkb  string
+	}{
+		{
+			"0101000020E6100000000000000000F03F0000000000000040",
+			"0101000000000000000000F03F0000000000000040",
+		},
+		{
+			"01010000A0E6100000000000000000F03F00000000000000400000000000000840",
+			"01E9030000000000000000F03F00000000000000400000000000000840",
+		},
+		{
+			"0102000020110F000002000000000000000000F03F000000000000004000000000000008400000000000001040",
+			"010200000002000000000000000000F03F000000000000004000000000000008400000000000001040",
+		},
+	} {
+		g, err := DecodeHex([]byte(tc.ewkb))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wkb := strings.ToUpper(hex.EncodeToString(g.WKB())); wkb != tc.wkb {
+			t.Errorf("unexpected WKB\n%s\n%s", wkb, tc.wkb)
+		}
+	}
+
+	b := &wkbBuf{}
+	b.header(uint32(MultiPolygon))
+	binary.Write(b, binary.LittleEndian, uint32(1))
+	b.header(uint32(Polygon))
+	binary.Write(b, binary.LittleEndian, uint32(2))
+	b.coords(0, 0, 10, 0, 10, 10, 0, 0)
+	b.coords(1, 1, 2, 1, 2, 2, 1, 1)
+	g, err := Decode(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(g.WKB(), b.Bytes()) {
+		t.Errorf("unexpected WKB for multipolygon %x", g.WKB())
+	}
+}
+
+func TestEWKB(t *testing.T) {
+	for _, ewkb := range []string{
+		"0101000020E6100000000000000000F03F0000000000000040",
+		"01010000A0E6100000000000000000F03F00000000000000400000000000000840",
+		"0102000020110F000002000000000000000000F03F000000000000004000000000000008400000000000001040",
+	} {
+		g, err := DecodeHex([]byte(ewkb))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := strings.ToUpper(hex.EncodeToString(g.EWKB())); b != ewkb {
+			t.Errorf("unexpected EWKB\n%s\n%s", b, ewkb)
+		}
+	}
+}
+
+func TestSetZ(t *testing.T) {
+	g, err := DecodeHex([]byte("0101000020E6100000000000000000F03F0000000000000040"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.SetZ(func(c Coord) float64 { return c.X + c.Y })
+	if b := strings.ToUpper(hex.EncodeToString(g.EWKB())); b != "01010000A0E6100000000000000000F03F00000000000000400000000000000840" {
+		t.Errorf("unexpected EWKB %s", b)
+	}
+
+	b := &wkbBuf{}
+	b.header(uint32(MultiLineString))
+	binary.Write(b, binary.LittleEndian, uint32(1))
+	b.header(uint32(LineString))
+	b.coords(0, 0, 10, 5)
+	g, err = Decode(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.SetZ(func(c Coord) float64 { return c.X })
+	if !g.Geoms[0].HasZ || g.Geoms[0].Coords[1].Z != 10 {
+		t.Errorf("unexpected geometry %#v", g)
+	}
+}
+
+func TestOrientPolygons(t *testing.T) {
+	shell := func() []Coord {
+		return []Coord{{X: 0, Y: 0}, {X: 0, Y: 10}, {X: 10, Y: 10}, {X: 10, Y: 0}, {X: 0, Y: 0}}
+	}
+	hole := func() []Coord { return []Coord{{X: 2, Y: 2}, {X: 4, Y: 2}, {X: 4, Y: 4}, {X: 2, Y: 2}} }
+	g := Geometry{Type: MultiPolygon, Geoms: []Geometry{
+		{Type: Polygon, Rings: [][]Coord{shell(), hole()}},
+	}}
+
+	// clockwise shell and counter-clockwise hole
+	if g.OrientPolygons(false) {
+		t.Error("polygon already oriented")
+	}
+	if !g.OrientPolygons(true) {
+		t.Error("polygon not oriented")
+	}
+	rings := g.Geoms[0].Rings
+	if ringArea(rings[0]) <= 0 || ringArea(rings[1]) >= 0 {
+		t.Errorf("unexpected orientation %v", rings)
+	}
+	if rings[0][1] != (Coord{X: 10, Y: 0}) || rings[1][1] != (Coord{X: 4, Y: 4}) {
+		t.Errorf("unexpected rings %v", rings)
+	}
+
+	if (&Geometry{Type: LineString, Coords: shell()}).OrientPolygons(true) {
+		t.Error("linestring oriented")
+	}
+}
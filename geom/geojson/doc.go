@@ -1,4 +1,5 @@
 /*
-Package geojson creates GEOS geometries from GeoJSON files.
+Package geojson creates GEOS geometries from GeoJSON files and encodes
+decoded EWKB geometries as GeoJSON.
 */
 package geojson
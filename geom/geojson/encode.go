@@ -0,0 +1,79 @@
+package geojson
+
+import (
+	"strconv"
+
+	"github.com/omniscale/imposm3/geom/ewkb"
+)
+
+// AppendGeometry appends the GeoJSON geometry object of g to buf.
+func AppendGeometry(buf []byte, g *ewkb.Geometry) []byte {
+	buf = append(buf, `{"type":"`...)
+	buf = append(buf, g.Type.String()...)
+	if g.Type == ewkb.GeometryCollection {
+		buf = append(buf, `","geometries":[`...)
+		for i := range g.Geoms {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = AppendGeometry(buf, &g.Geoms[i])
+		}
+		return append(buf, "]}"...)
+	}
+	buf = append(buf, `","coordinates":`...)
+	buf = appendCoordinates(buf, g)
+	return append(buf, '}')
+}
+
+func appendCoordinates(buf []byte, g *ewkb.Geometry) []byte {
+	switch g.Type {
+	case ewkb.Point:
+		if len(g.Coords) == 0 {
+			return append(buf, "[]"...)
+		}
+		return appendCoord(buf, g.Coords[0], g.HasZ)
+	case ewkb.LineString:
+		return appendCoords(buf, g.Coords, g.HasZ)
+	case ewkb.Polygon:
+		buf = append(buf, '[')
+		for i, r := range g.Rings {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendCoords(buf, r, g.HasZ)
+		}
+		return append(buf, ']')
+	default: // Multi*
+		buf = append(buf, '[')
+		for i := range g.Geoms {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendCoordinates(buf, &g.Geoms[i])
+		}
+		return append(buf, ']')
+	}
+}
+
+func appendCoords(buf []byte, coords []ewkb.Coord, hasZ bool) []byte {
+	buf = append(buf, '[')
+	for i, c := range coords {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendCoord(buf, c, hasZ)
+	}
+	return append(buf, ']')
+}
+
+func appendCoord(buf []byte, c ewkb.Coord, hasZ bool) []byte {
+	buf = append(buf, '[')
+	buf = strconv.AppendFloat(buf, c.X, 'f', -1, 64)
+	buf = append(buf, ',')
+	buf = strconv.AppendFloat(buf, c.Y, 'f', -1, 64)
+	if hasZ {
+		buf = append(buf, ',')
+		buf = strconv.AppendFloat(buf, c.Z, 'f', -1, 64)
+	}
+	return append(buf, ']')
+}
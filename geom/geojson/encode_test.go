@@ -0,0 +1,51 @@
+package geojson
+
+import (
+	"testing"
+
+	"github.com/omniscale/imposm3/geom/ewkb"
+)
+
+func TestAppendGeometry(t *testing.T) {
+	square := []ewkb.Coord{{X: 0, Y: 0}, {X: 10, Y: 0}, {X: 10, Y: 10}, {X: 0, Y: 0}}
+	for _, tc := range []struct {
+		geom     ewkb.Geometry
+		expected string
+	}{
+		{
+			ewkb.Geometry{Type: ewkb.Point, Coords: []ewkb.Coord{{X: 1.5, Y: -2}}},
+			`{"type":"Point","coordinates":[1.5,-2]}`,
+		},
+		{
+			ewkb.Geometry{Type: ewkb.Point, HasZ: true, Coords: []ewkb.Coord{{X: 1, Y: 2, Z: 3}}},
+			`{"type":"Point","coordinates":[1,2,3]}`,
+		},
+		{
+			ewkb.Geometry{Type: ewkb.LineString, Coords: []ewkb.Coord{{X: 1, Y: 2}, {X: 1234567.125, Y: 0.0001}}},
+			`{"type":"LineString","coordinates":[[1,2],[1234567.125,0.0001]]}`,
+		},
+		{
+			ewkb.Geometry{Type: ewkb.MultiPolygon, Geoms: []ewkb.Geometry{
+				{Type: ewkb.Polygon, Rings: [][]ewkb.Coord{square, square}},
+				{Type: ewkb.Polygon, Rings: [][]ewkb.Coord{square}},
+			}},
+			`{"type":"MultiPolygon","coordinates":[` +
+				`[[[0,0],[10,0],[10,10],[0,0]],[[0,0],[10,0],[10,10],[0,0]]],` +
+				`[[[0,0],[10,0],[10,10],[0,0]]]]}`,
+		},
+		{
+			ewkb.Geometry{Type: ewkb.GeometryCollection, Geoms: []ewkb.Geometry{
+				{Type: ewkb.Point, Coords: []ewkb.Coord{{X: 1, Y: 2}}},
+				{Type: ewkb.MultiPoint, Geoms: []ewkb.Geometry{
+					{Type: ewkb.Point, Coords: []ewkb.Coord{{X: 3, Y: 4}}},
+				}},
+			}},
+			`{"type":"GeometryCollection","geometries":[` +
+				`{"type":"Point","coordinates":[1,2]},{"type":"MultiPoint","coordinates":[[3,4]]}]}`,
+		},
+	} {
+		if got := string(AppendGeometry(nil, &tc.geom)); got != tc.expected {
+			t.Errorf("unexpected GeoJSON\n%s\n%s", got, tc.expected)
+		}
+	}
+}
@@ -16,6 +16,21 @@ type GeometryError struct {
 type Geometry struct {
 	Geom *geos.Geom
 	Wkb  []byte
+	// WkbZ is the hex encoded EWKB with Z coordinates from the elevation of
+	// the nodes. It is only set for elements of tables with elevation.
+	WkbZ []byte
+	// LimitTo contains the GeoJSON properties of the -limitto polygon of
+	// the geometry. It is only set if the polygons have properties.
+	LimitTo map[string]string
+	// Repaired is set for polygons that were invalid.
+	Repaired bool
+	// Route is the position of a way member along its route relation. It
+	// is only set for members of route relations.
+	Route *RoutePosition
+	// MemberPoints contains the hex encoded EWKB points of the node members
+	// of a relation by role (e.g. admin_centre or label). Only the first
+	// node of each role is included.
+	MemberPoints map[string][]byte
 }
 
 func (e *GeometryError) Error() string {
@@ -108,6 +123,23 @@ func Polygon(g *geos.Geos, nodes []osm.Node) (*geos.Geom, error) {
 		return nil, ErrorNoRing
 	}
 
+	ring, err := linearRing(g, nodes)
+	if err != nil {
+		return nil, err
+	}
+	// ring inherited by Polygon, no destroy
+
+	geom := g.Polygon(ring, nil)
+	if geom == nil {
+		g.Destroy(ring)
+		return nil, errors.New("unable to create polygon")
+	}
+	g.DestroyLater(geom)
+	return geom, nil
+}
+
+// linearRing creates a LinearRing from closed nodes.
+func linearRing(g *geos.Geos, nodes []osm.Node) (*geos.Geom, error) {
 	coordSeq, err := g.CreateCoordSeq(uint32(len(nodes)), 2)
 	if err != nil {
 		return nil, err
@@ -125,15 +157,7 @@ func Polygon(g *geos.Geos, nodes []osm.Node) (*geos.Geom, error) {
 		// coordSeq gets Destroy by GEOS
 		return nil, err
 	}
-	// ring inherited by Polygon, no destroy
-
-	geom := g.Polygon(ring, nil)
-	if geom == nil {
-		g.Destroy(ring)
-		return nil, errors.New("unable to create polygon")
-	}
-	g.DestroyLater(geom)
-	return geom, nil
+	return ring, nil
 }
 
 func AsGeomElement(g *geos.Geos, geom *geos.Geom) (Geometry, error) {
@@ -42,6 +42,16 @@ func (g *Geos) Buffer(geom *Geom, size float64) *Geom {
 	return &Geom{buffered}
 }
 
+// Centroid returns the center of mass of the geometry. The centroid of
+// lines and polygons can be outside of the geometry.
+func (g *Geos) Centroid(geom *Geom) *Geom {
+	centroid := C.GEOSGetCentroid_r(g.v, geom.v)
+	if centroid == nil {
+		return nil
+	}
+	return &Geom{centroid}
+}
+
 func (g *Geos) SimplifyPreserveTopology(geom *Geom, tolerance float64) *Geom {
 	simplified := C.GEOSTopologyPreserveSimplify_r(g.v, geom.v, C.double(tolerance))
 	if simplified == nil {
@@ -0,0 +1,62 @@
+package geom
+
+import (
+	"encoding/hex"
+	"math"
+
+	"github.com/omniscale/imposm3/geom/ewkb"
+	"github.com/omniscale/imposm3/proj"
+)
+
+// WithoutSmallHoles returns the hex encoded EWKB geometry without the holes
+// of polygons with an area below minArea. minArea is in square meters, it
+// is converted to the units of EPSG:4326 and EPSG:3857 at the center of
+// the geometry.
+func WithoutSmallHoles(wkb []byte, minArea float64) ([]byte, error) {
+	g, err := ewkb.DecodeHex(wkb)
+	if err != nil {
+		return nil, err
+	}
+	minx, miny, maxx, maxy := g.Bounds()
+	if math.IsInf(minx, 0) {
+		return wkb, nil
+	}
+	switch g.SRID {
+	case 4326:
+		minArea /= metersPerDegree * metersPerDegree * math.Cos((miny+maxy)/2*math.Pi/180)
+	case 3857:
+		_, lat := proj.MercToWgs((minx+maxx)/2, (miny+maxy)/2)
+		cos := math.Cos(lat * math.Pi / 180)
+		minArea /= cos * cos
+	}
+	if !removeSmallHoles(g, minArea) {
+		return wkb, nil
+	}
+	b := g.EWKB()
+	result := make([]byte, hex.EncodedLen(len(b)))
+	hex.Encode(result, b)
+	return result, nil
+}
+
+// removeSmallHoles removes all holes below minArea. Returns true if any
+// hole was removed.
+func removeSmallHoles(g *ewkb.Geometry, minArea float64) bool {
+	changed := false
+	if g.Type == ewkb.Polygon && len(g.Rings) > 1 {
+		rings := g.Rings[:1]
+		for _, r := range g.Rings[1:] {
+			if math.Abs(coordsArea(r)) < minArea {
+				changed = true
+				continue
+			}
+			rings = append(rings, r)
+		}
+		g.Rings = rings
+	}
+	for i := range g.Geoms {
+		if removeSmallHoles(&g.Geoms[i], minArea) {
+			changed = true
+		}
+	}
+	return changed
+}
@@ -0,0 +1,47 @@
+package geom
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/omniscale/imposm3/geom/ewkb"
+)
+
+func TestWithoutSmallHoles(t *testing.T) {
+	g := ewkb.Geometry{Type: ewkb.MultiPolygon, SRID: 3857, Geoms: []ewkb.Geometry{
+		{Type: ewkb.Polygon, Rings: [][]ewkb.Coord{
+			coords(0, 0, 100, 0, 100, 100, 0, 100, 0, 0),
+			coords(10, 10, 12, 10, 12, 12, 10, 12, 10, 10),
+			coords(50, 50, 60, 50, 60, 60, 50, 60, 50, 50),
+		}},
+	}}
+	wkb := []byte(hex.EncodeToString(g.EWKB()))
+
+	result, err := WithoutSmallHoles(wkb, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ewkb.DecodeHex(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// hole with area of 4 removed
+	if rings := s.Geoms[0].Rings; len(rings) != 2 || rings[1][0].X != 50 {
+		t.Errorf("unexpected polygon %v", rings)
+	}
+
+	// unchanged without small holes
+	if result, _ := WithoutSmallHoles(wkb, 1); string(result) != string(wkb) {
+		t.Error("expected unchanged geometry")
+	}
+
+	// 4326 area is converted to square degrees
+	g.SRID = 4326
+	result, err = WithoutSmallHoles([]byte(hex.EncodeToString(g.EWKB())), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := ewkb.DecodeHex(result); len(s.Geoms[0].Rings) != 3 {
+		t.Errorf("unexpected polygon %v", s.Geoms[0].Rings)
+	}
+}
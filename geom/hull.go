@@ -0,0 +1,123 @@
+package geom
+
+import (
+	"encoding/hex"
+	"math"
+	"sort"
+
+	"github.com/omniscale/imposm3/geom/ewkb"
+)
+
+// ConvexHull returns the hex encoded EWKB polygon of the convex hull of the
+// hex encoded EWKB geometry. Returns nil for empty geometries and for
+// geometries with all coordinates on a line.
+func ConvexHull(wkb []byte) ([]byte, error) {
+	g, err := ewkb.DecodeHex(wkb)
+	if err != nil {
+		return nil, err
+	}
+	hull := convexHull(g)
+	if hull == nil {
+		return nil, nil
+	}
+	return encodePolygon(g.SRID, hull), nil
+}
+
+// MinimumRotatedRectangle returns the hex encoded EWKB polygon of the
+// rectangle with the smallest area that contains the hex encoded EWKB
+// geometry. The rectangle is not aligned to the axes, it is rotated
+// to one of the edges of the convex hull. Returns nil for empty geometries
+// and for geometries with all coordinates on a line.
+func MinimumRotatedRectangle(wkb []byte) ([]byte, error) {
+	g, err := ewkb.DecodeHex(wkb)
+	if err != nil {
+		return nil, err
+	}
+	hull := convexHull(g)
+	if hull == nil {
+		return nil, nil
+	}
+	return encodePolygon(g.SRID, minimumRectangle(hull)), nil
+}
+
+func encodePolygon(srid int, ring []ewkb.Coord) []byte {
+	b := (&ewkb.Geometry{Type: ewkb.Polygon, SRID: srid, Rings: [][]ewkb.Coord{ring}}).EWKB()
+	result := make([]byte, hex.EncodedLen(len(b)))
+	hex.Encode(result, b)
+	return result
+}
+
+// convexHull returns the closed, counterclockwise ring of the convex hull
+// of all coordinates (monotone chain algorithm). Returns nil if the hull has
+// no area.
+func convexHull(g *ewkb.Geometry) []ewkb.Coord {
+	var coords []ewkb.Coord
+	g.EachCoord(func(c ewkb.Coord) {
+		coords = append(coords, ewkb.Coord{X: c.X, Y: c.Y})
+	})
+	sort.Slice(coords, func(i, j int) bool { return lessCoord(coords[i], coords[j]) })
+
+	cross := func(o, a, b ewkb.Coord) float64 {
+		return (a.X-o.X)*(b.Y-o.Y) - (a.Y-o.Y)*(b.X-o.X)
+	}
+	hull := make([]ewkb.Coord, 0, len(coords)+1)
+	// lower hull
+	for _, c := range coords {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], c) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, c)
+	}
+	// upper hull
+	lower := len(hull) + 1
+	for i := len(coords) - 2; i >= 0; i-- {
+		c := coords[i]
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], c) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, c)
+	}
+	// the last coordinate is the first coordinate
+	if len(hull) < 4 {
+		return nil
+	}
+	return hull
+}
+
+// minimumRectangle returns the closed, counterclockwise ring of the
+// rectangle with the smallest area that contains the convex hull. One side
+// of this rectangle is on an edge of the hull (rotating calipers).
+func minimumRectangle(hull []ewkb.Coord) []ewkb.Coord {
+	minArea := math.Inf(1)
+	var rect []ewkb.Coord
+	for i := 0; i < len(hull)-1; i++ {
+		a, b := hull[i], hull[i+1]
+		l := math.Hypot(b.X-a.X, b.Y-a.Y)
+		if l == 0 {
+			continue
+		}
+		// unit vectors along and perpendicular to the edge
+		ux, uy := (b.X-a.X)/l, (b.Y-a.Y)/l
+		vx, vy := -uy, ux
+		minU, maxU := math.Inf(1), math.Inf(-1)
+		minV, maxV := math.Inf(1), math.Inf(-1)
+		for _, c := range hull {
+			u := (c.X-a.X)*ux + (c.Y-a.Y)*uy
+			v := (c.X-a.X)*vx + (c.Y-a.Y)*vy
+			minU, maxU = math.Min(minU, u), math.Max(maxU, u)
+			minV, maxV = math.Min(minV, v), math.Max(maxV, v)
+		}
+		if area := (maxU - minU) * (maxV - minV); area < minArea {
+			minArea = area
+			corner := func(u, v float64) ewkb.Coord {
+				return ewkb.Coord{X: a.X + u*ux + v*vx, Y: a.Y + u*uy + v*vy}
+			}
+			rect = []ewkb.Coord{
+				corner(minU, minV), corner(maxU, minV),
+				corner(maxU, maxV), corner(minU, maxV),
+				corner(minU, minV),
+			}
+		}
+	}
+	return rect
+}
@@ -0,0 +1,74 @@
+package geom
+
+import (
+	"encoding/hex"
+	"math"
+	"testing"
+
+	"github.com/omniscale/imposm3/geom/ewkb"
+)
+
+func TestConvexHull(t *testing.T) {
+	g := ewkb.Geometry{Type: ewkb.MultiPoint, SRID: 3857, Geoms: []ewkb.Geometry{
+		{Type: ewkb.Point, Coords: coords(0, 0)},
+		{Type: ewkb.Point, Coords: coords(4, 0)},
+		{Type: ewkb.Point, Coords: coords(2, 1)},
+		{Type: ewkb.Point, Coords: coords(4, 4)},
+		{Type: ewkb.Point, Coords: coords(0, 4)},
+		{Type: ewkb.Point, Coords: coords(2, 4)},
+	}}
+	wkb, err := ConvexHull([]byte(hex.EncodeToString(g.EWKB())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hull, err := ewkb.DecodeHex(wkb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hull.Type != ewkb.Polygon || hull.SRID != 3857 || len(hull.Rings[0]) != 5 {
+		t.Fatalf("unexpected hull %v", hull)
+	}
+	if a := coordsArea(hull.Rings[0]); a != 16 {
+		t.Errorf("unexpected area %v of counterclockwise hull %v", a, hull.Rings[0])
+	}
+
+	// no hull for lines
+	line := ewkb.Geometry{Type: ewkb.LineString, SRID: 3857, Coords: coords(0, 0, 1, 1, 3, 3)}
+	if wkb, err := ConvexHull([]byte(hex.EncodeToString(line.EWKB()))); err != nil || wkb != nil {
+		t.Errorf("unexpected hull %v %v", wkb, err)
+	}
+}
+
+func TestMinimumRotatedRectangle(t *testing.T) {
+	// rectangle of 4x1, rotated by 45°
+	s := math.Sqrt2 / 2
+	g := ewkb.Geometry{Type: ewkb.Polygon, SRID: 3857, Rings: [][]ewkb.Coord{
+		coords(0, 0, 4*s, 4*s, 4*s-s, 4*s+s, -s, s, 0.5*s, 1.5*s, 0, 0),
+	}}
+	wkb, err := MinimumRotatedRectangle([]byte(hex.EncodeToString(g.EWKB())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rect, err := ewkb.DecodeHex(wkb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rect.Rings[0]
+	if len(r) != 5 || r[0] != r[4] {
+		t.Fatalf("unexpected rectangle %v", r)
+	}
+	if a := coordsArea(r); math.Abs(a-4) > 1e-9 {
+		t.Errorf("unexpected area %v of %v", a, r)
+	}
+	for _, c := range coords(0, 0, 4*s, 4*s, 3*s, 5*s, -s, s) {
+		found := false
+		for _, rc := range r {
+			if math.Abs(rc.X-c.X) < 1e-9 && math.Abs(rc.Y-c.Y) < 1e-9 {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("missing corner %v in %v", c, r)
+		}
+	}
+}
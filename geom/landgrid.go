@@ -0,0 +1,131 @@
+package geom
+
+import (
+	"math"
+
+	"github.com/omniscale/imposm3/geom/ewkb"
+)
+
+// TileGrid is a grid of square tiles with Size, limited to Extent. Tile
+// 0,0 starts at the origin of the coordinate system.
+type TileGrid struct {
+	Size   float64
+	Extent [4]float64
+}
+
+// Tile is the position of a tile in a TileGrid.
+type Tile struct {
+	X, Y int
+}
+
+// Box returns the bounding box of the tile, limited to the extent of the
+// grid.
+func (g TileGrid) Box(t Tile) [4]float64 {
+	return [4]float64{
+		math.Max(float64(t.X)*g.Size, g.Extent[0]),
+		math.Max(float64(t.Y)*g.Size, g.Extent[1]),
+		math.Min(float64(t.X+1)*g.Size, g.Extent[2]),
+		math.Min(float64(t.Y+1)*g.Size, g.Extent[3]),
+	}
+}
+
+// Range returns the first and last tile of the grid.
+func (g TileGrid) Range() (Tile, Tile) {
+	return Tile{
+		X: int(math.Floor(g.Extent[0] / g.Size)),
+		Y: int(math.Floor(g.Extent[1] / g.Size)),
+	}, Tile{
+		X: int(math.Ceil(g.Extent[2]/g.Size)) - 1,
+		Y: int(math.Ceil(g.Extent[3]/g.Size)) - 1,
+	}
+}
+
+// Contains returns whether the tile is part of the grid.
+func (g TileGrid) Contains(t Tile) bool {
+	lo, hi := g.Range()
+	return t.X >= lo.X && t.X <= hi.X && t.Y >= lo.Y && t.Y <= hi.Y
+}
+
+// TilesOf returns all tiles of the grid that the line intersects, including
+// tiles that only touch the line at their border.
+func (g TileGrid) TilesOf(coords []ewkb.Coord) []Tile {
+	lo, hi := g.Range()
+	seen := make(map[Tile]struct{})
+	var tiles []Tile
+	for i := 0; i+1 < len(coords); i++ {
+		a, b := coords[i], coords[i+1]
+		// the bbox of the segment is sufficient, as tiles that the segment
+		// does not intersect get no land from LandInBox
+		x0 := maxInt(int(math.Ceil(math.Min(a.X, b.X)/g.Size))-1, lo.X)
+		y0 := maxInt(int(math.Ceil(math.Min(a.Y, b.Y)/g.Size))-1, lo.Y)
+		x1 := minInt(int(math.Floor(math.Max(a.X, b.X)/g.Size)), hi.X)
+		y1 := minInt(int(math.Floor(math.Max(a.Y, b.Y)/g.Size)), hi.Y)
+		for x := x0; x <= x1; x++ {
+			for y := y0; y <= y1; y++ {
+				t := Tile{x, y}
+				if _, ok := seen[t]; !ok {
+					seen[t] = struct{}{}
+					tiles = append(tiles, t)
+				}
+			}
+		}
+	}
+	return tiles
+}
+
+// FillTiles sets the background of all tiles with Unknown land, starting
+// from the sides of their neighbours. Tiles that are not connected to any
+// known tile are land, e.g. if the grid contains no coastlines at all.
+func FillTiles(tiles map[Tile]*BoxLand) {
+	var queue []Tile
+	for t, bl := range tiles {
+		if !bl.Unknown {
+			queue = append(queue, t)
+		}
+	}
+	for len(queue) > 0 {
+		t := queue[0]
+		queue = queue[1:]
+		for _, n := range Neighbours(t) {
+			nbl, ok := tiles[n.Tile]
+			if !ok || !nbl.Unknown {
+				continue
+			}
+			nbl.SetBackground(tiles[t].SideLand(n.Side))
+			queue = append(queue, n.Tile)
+		}
+	}
+	for _, bl := range tiles {
+		bl.SetBackground(true)
+	}
+}
+
+// Neighbour is a neighbour tile, with the side of the tile that it touches.
+type Neighbour struct {
+	Tile Tile
+	Side int
+}
+
+// Neighbours returns the four neighbours of the tile.
+func Neighbours(t Tile) []Neighbour {
+	return []Neighbour{
+		{Tile{t.X, t.Y - 1}, SideBottom},
+		{Tile{t.X + 1, t.Y}, SideRight},
+		{Tile{t.X, t.Y + 1}, SideTop},
+		{Tile{t.X - 1, t.Y}, SideLeft},
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,78 @@
+package geom
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTileGrid(t *testing.T) {
+	g := TileGrid{Size: 10, Extent: [4]float64{-15, 0, 30, 5}}
+	lo, hi := g.Range()
+	if lo != (Tile{-2, 0}) || hi != (Tile{2, 0}) {
+		t.Errorf("unexpected range %v %v", lo, hi)
+	}
+	if box := g.Box(Tile{-2, 0}); box != [4]float64{-15, 0, -10, 5} {
+		t.Errorf("unexpected box %v", box)
+	}
+	if box := g.Box(Tile{1, 0}); box != [4]float64{10, 0, 20, 5} {
+		t.Errorf("unexpected box %v", box)
+	}
+	if g.Contains(Tile{3, 0}) || !g.Contains(Tile{-2, 0}) {
+		t.Error("unexpected contains")
+	}
+
+	tiles := g.TilesOf(coords(-12, 2, 10, 2, 100, 100))
+	expected := []Tile{{-2, 0}, {-1, 0}, {0, 0}, {1, 0}, {2, 0}}
+	if !reflect.DeepEqual(tiles, expected) {
+		t.Errorf("unexpected tiles %v", tiles)
+	}
+}
+
+func TestFillTiles(t *testing.T) {
+	g := TileGrid{Size: 100, Extent: [4]float64{0, 0, 300, 200}}
+	for _, tc := range []struct {
+		name string
+		ways []CoastlineWay
+		land map[Tile]bool
+	}{
+		{
+			name: "island",
+			ways: []CoastlineWay{{ID: 1, Coords: coords(10, 10, 20, 10, 20, 20, 10, 10)}},
+			land: map[Tile]bool{{1, 0}: false, {2, 0}: false, {0, 1}: false, {2, 1}: false},
+		},
+		{
+			name: "lagoon",
+			ways: []CoastlineWay{{ID: 1, Coords: coords(10, 10, 20, 20, 20, 10, 10, 10)}},
+			land: map[Tile]bool{{1, 0}: true, {2, 0}: true, {0, 1}: true, {2, 1}: true},
+		},
+		{
+			name: "crossing",
+			// land in the south east, cut at the extent
+			ways: []CoastlineWay{{ID: 1, Coords: coords(300, 40, 150, 40, 150, 0)}},
+			land: map[Tile]bool{{0, 0}: false, {2, 0}: true, {0, 1}: false, {2, 1}: false},
+		},
+		{
+			name: "no coastlines",
+			land: map[Tile]bool{{0, 0}: true, {2, 1}: true},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tiles := make(map[Tile]*BoxLand)
+			lo, hi := g.Range()
+			for x := lo.X; x <= hi.X; x++ {
+				for y := lo.Y; y <= hi.Y; y++ {
+					tile := Tile{x, y}
+					bl := LandInBox(tc.ways, g.Box(tile), g.Extent)
+					tiles[tile] = &bl
+				}
+			}
+			FillTiles(tiles)
+			for tile, land := range tc.land {
+				bl := tiles[tile]
+				if bl.Unknown || (len(bl.Polygons) > 0) != land {
+					t.Errorf("unexpected land of %v: %+v", tile, bl)
+				}
+			}
+		})
+	}
+}
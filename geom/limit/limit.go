@@ -4,6 +4,7 @@ import (
 	"errors"
 	"math"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 
@@ -126,6 +127,18 @@ type Limiter struct {
 	// for quick contains checks
 	bufferedPrep   *geos.PreparedGeom
 	bufferedPrepMu *sync.Mutex
+
+	// all polygons with properties, for Properties
+	features     []limitFeature
+	featureIndex *geos.Index
+}
+
+// limitFeature is a single limitto polygon with the properties of its GeoJSON
+// feature.
+type limitFeature struct {
+	sync.Mutex
+	prep       *geos.PreparedGeom
+	properties map[string]string
 }
 
 func NewFromGeoJSON(source string, buffer float64, targetSRID int) (*Limiter, error) {
@@ -144,9 +157,11 @@ func NewFromGeoJSON(source string, buffer float64, targetSRID int) (*Limiter, er
 	defer g.Finish()
 
 	index := g.CreateIndex()
+	featureIndex := g.CreateIndex()
 
 	var bufferedPolygons []*geos.Geom
 	var polygons []*geos.Geom
+	var limitFeatures []limitFeature
 
 	withBuffer := false
 	if buffer != 0.0 {
@@ -184,6 +199,17 @@ func NewFromGeoJSON(source string, buffer float64, targetSRID int) (*Limiter, er
 
 		polygons = append(polygons, geom)
 
+		if len(feature.Properties) > 0 {
+			// geom gets destroyed in UnionPolygons
+			clone := g.Clone(geom)
+			prep := g.Prepare(clone)
+			if prep == nil {
+				return nil, errors.New("unable to prepare limitto polygon")
+			}
+			g.IndexAdd(featureIndex, clone)
+			limitFeatures = append(limitFeatures, limitFeature{prep: prep, properties: feature.Properties})
+		}
+
 		minGridWidth := 50000.0
 		if targetSRID == 4326 {
 			minGridWidth = 0.5
@@ -227,7 +253,17 @@ func NewFromGeoJSON(source string, buffer float64, targetSRID int) (*Limiter, er
 		return nil, errors.New("unable to prepare limitto polygons")
 	}
 
-	return &Limiter{index, union, geomPrep, &sync.Mutex{}, bufferedBbox, bufferedPrep, &sync.Mutex{}}, nil
+	return &Limiter{
+		index:          index,
+		geom:           union,
+		geomPrep:       geomPrep,
+		geomPrepMu:     &sync.Mutex{},
+		bufferedBbox:   bufferedBbox,
+		bufferedPrep:   bufferedPrep,
+		bufferedPrepMu: &sync.Mutex{},
+		features:       limitFeatures,
+		featureIndex:   featureIndex,
+	}, nil
 }
 
 func filterGeometryByType(g *geos.Geos, geom *geos.Geom, targetType string) []*geos.Geom {
@@ -316,6 +352,59 @@ func (l *Limiter) Clip(geom *geos.Geom) ([]*geos.Geom, error) {
 	return mergeGeometries(g, intersections, geomType), nil
 }
 
+// Properties returns the GeoJSON properties of the limitto polygon of a
+// (clipped) geometry. It returns the first polygon (in the order of the
+// GeoJSON file) that contains geom, or that intersects geom if geom is in
+// multiple polygons. Returns nil if geom is outside of all polygons with
+// properties.
+func (l *Limiter) Properties(geom *geos.Geom) map[string]string {
+	if len(l.features) == 0 {
+		return nil
+	}
+	g := geos.NewGeos()
+	defer g.Finish()
+
+	hits := g.IndexQuery(l.featureIndex, geom)
+	sort.Ints(hits)
+	for _, i := range hits {
+		f := &l.features[i]
+		f.Lock()
+		contains := g.PreparedContains(f.prep, geom)
+		f.Unlock()
+		if contains {
+			return f.properties
+		}
+	}
+	for _, i := range hits {
+		f := &l.features[i]
+		f.Lock()
+		intersects := g.PreparedIntersects(f.prep, geom)
+		f.Unlock()
+		if intersects {
+			return f.properties
+		}
+	}
+	return nil
+}
+
+// ContainsCentroid returns true if the centroid of geom is inside of the
+// LimitTo geometry. Used for tables that keep whole geometries instead of
+// clipping them.
+func (l *Limiter) ContainsCentroid(geom *geos.Geom) bool {
+	g := geos.NewGeos()
+	defer g.Finish()
+
+	centroid := g.Centroid(geom)
+	if centroid == nil {
+		return false
+	}
+	defer g.Destroy(centroid)
+
+	l.geomPrepMu.Lock()
+	defer l.geomPrepMu.Unlock()
+	return g.PreparedContains(l.geomPrep, centroid)
+}
+
 // IntersectsBuffer returns true if the point (EPSG:4326) intersects the buffered
 // LimitTo geometry.
 func (l *Limiter) IntersectsBuffer(g *geos.Geos, x, y float64) bool {
@@ -477,12 +566,13 @@ func geosPolygon(g *geos.Geos, polygon geojson.Polygon) (*geos.Geom, error) {
 }
 
 func transformPolygon(p geojson.Polygon, targetSRID int) {
-	if targetSRID != 3857 {
-		panic("transformation to non-4326/3856 not implemented")
+	projection := proj.Lookup(targetSRID)
+	if projection == nil {
+		panic("transformation to unsupported srid")
 	}
 	for _, ls := range p {
 		for i := range ls {
-			ls[i].Long, ls[i].Lat = proj.WgsToMerc(ls[i].Long, ls[i].Lat)
+			ls[i].Long, ls[i].Lat = projection.Forward(ls[i].Long, ls[i].Lat)
 		}
 	}
 }
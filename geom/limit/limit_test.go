@@ -391,3 +391,41 @@ func BenchmarkClipper(b *testing.B) {
 		}
 	}
 }
+
+func TestClipperMultipleAreas(t *testing.T) {
+	g := geos.NewGeos()
+	defer g.Finish()
+	limiter, err := NewFromGeoJSON("./areas.geojson", 0.0, 4326)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		wkt      string
+		parts    int
+		property string
+	}{
+		{"POINT(2 2)", 1, "west"},
+		{"POINT(5 5)", 0, ""}, // inside hole
+		{"POINT(15 5)", 1, "east"},
+		{"POINT(35 5)", 1, "east"},
+		{"POINT(25 5)", 0, ""},
+		{"LINESTRING(2 2, 15 2)", 1, "west"}, // crosses both areas
+		{"LINESTRING(12 2, 15 2)", 1, "east"},
+		{"LINESTRING(15 2, 35 2)", 2, "east"},
+	} {
+		parts, err := limiter.Clip(g.FromWkt(tc.wkt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(parts) != tc.parts {
+			t.Errorf("unexpected number of parts %d for %s", len(parts), tc.wkt)
+			continue
+		}
+		for _, p := range parts {
+			if name := limiter.Properties(p)["name"]; name != tc.property {
+				t.Errorf("unexpected property %q for %s", name, tc.wkt)
+			}
+		}
+	}
+}
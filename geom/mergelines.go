@@ -0,0 +1,134 @@
+package geom
+
+import (
+	"strings"
+
+	osm "github.com/omniscale/go-osm"
+)
+
+// RouteLines returns the nodes of all way members of a route relation
+// that are part of the route itself. Platforms and stops are skipped.
+// Ways with a forward or backward role are returned in the direction of
+// the route and are marked as oneway.
+func RouteLines(members []osm.Member) (lines [][]osm.Node, oneway []bool) {
+	for _, w := range routeWays(members) {
+		lines = append(lines, w.nodes)
+		oneway = append(oneway, w.oneway)
+	}
+	return lines, oneway
+}
+
+// routeWay is a way member that is part of the route.
+type routeWay struct {
+	member int
+	nodes  []osm.Node
+	oneway bool
+}
+
+func routeWays(members []osm.Member) []routeWay {
+	var ways []routeWay
+	for i, m := range members {
+		if m.Way == nil || len(m.Way.Nodes) < 2 {
+			continue
+		}
+		if strings.HasPrefix(m.Role, "platform") || strings.HasPrefix(m.Role, "stop") {
+			continue
+		}
+		w := routeWay{member: i, nodes: m.Way.Nodes}
+		switch m.Role {
+		case "forward":
+			w.oneway = true
+		case "backward":
+			w.nodes = reversedNodes(w.nodes)
+			w.oneway = true
+		}
+		ways = append(ways, w)
+	}
+	return ways
+}
+
+// mergedLine is a line of one or more merged lines. It is fixed if it
+// contains a oneway line and can't be reversed.
+type mergedLine struct {
+	nodes []osm.Node
+	fixed bool
+}
+
+func (l *mergedLine) first() osm.Node { return l.nodes[0] }
+func (l *mergedLine) last() osm.Node  { return l.nodes[len(l.nodes)-1] }
+
+func (l *mergedLine) reverse() {
+	l.nodes = reversedNodes(l.nodes)
+}
+
+// join appends other to l if they share an end node, reversing l or other
+// if they are not fixed. Returns false if the lines are not connected.
+func (l *mergedLine) join(other *mergedLine) bool {
+	switch {
+	case nodesEqual(l.last(), other.first()):
+	case !other.fixed && nodesEqual(l.last(), other.last()):
+		other.reverse()
+	case !l.fixed && nodesEqual(l.first(), other.first()):
+		l.reverse()
+	case !l.fixed && !other.fixed && nodesEqual(l.first(), other.last()):
+		l.reverse()
+		other.reverse()
+	default:
+		return false
+	}
+	l.nodes = append(l.nodes, other.nodes[1:]...)
+	l.fixed = l.fixed || other.fixed
+	return true
+}
+
+// MergeLines merges lines into the fewest continuous lines. Lines are
+// joined at shared end nodes, first in the order of lines (e.g. in the
+// order of the route members) and then in any order. Lines that are
+// marked as oneway are never reversed. The lines are not modified.
+func MergeLines(lines [][]osm.Node, oneway []bool) [][]osm.Node {
+	var merged []*mergedLine
+	var current *mergedLine
+	for i, nodes := range lines {
+		if len(nodes) < 2 {
+			continue
+		}
+		line := &mergedLine{
+			nodes: append([]osm.Node(nil), nodes...),
+			fixed: i < len(oneway) && oneway[i],
+		}
+		if current != nil && current.join(line) {
+			continue
+		}
+		current = line
+		merged = append(merged, current)
+	}
+
+	// join remaining fragments, e.g. from unordered members
+	for joined := true; joined; {
+		joined = false
+		for i := 0; i < len(merged) && !joined; i++ {
+			for j := 0; j < len(merged); j++ {
+				if i == j || !merged[i].join(merged[j]) {
+					continue
+				}
+				merged = append(merged[:j], merged[j+1:]...)
+				joined = true
+				break
+			}
+		}
+	}
+
+	result := make([][]osm.Node, len(merged))
+	for i, l := range merged {
+		result[i] = l.nodes
+	}
+	return result
+}
+
+func reversedNodes(nodes []osm.Node) []osm.Node {
+	result := make([]osm.Node, len(nodes))
+	for i, nd := range nodes {
+		result[len(nodes)-1-i] = nd
+	}
+	return result
+}
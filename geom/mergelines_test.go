@@ -0,0 +1,83 @@
+package geom
+
+import (
+	"reflect"
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+)
+
+// lineNodes returns nodes at x/0 for each x.
+func lineNodes(xs ...float64) []osm.Node {
+	nodes := make([]osm.Node, len(xs))
+	for i, x := range xs {
+		nodes[i] = osm.Node{Long: x}
+	}
+	return nodes
+}
+
+func lineXs(lines [][]osm.Node) [][]float64 {
+	var result [][]float64
+	for _, l := range lines {
+		var xs []float64
+		for _, nd := range l {
+			xs = append(xs, nd.Long)
+		}
+		result = append(result, xs)
+	}
+	return result
+}
+
+func TestMergeLines(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		lines    [][]osm.Node
+		oneway   []bool
+		expected [][]float64
+	}{
+		{"ordered", [][]osm.Node{lineNodes(0, 1), lineNodes(1, 2), lineNodes(2, 3)}, nil,
+			[][]float64{{0, 1, 2, 3}}},
+		{"reversed", [][]osm.Node{lineNodes(1, 0), lineNodes(1, 2), lineNodes(3, 2)}, nil,
+			[][]float64{{0, 1, 2, 3}}},
+		{"unordered", [][]osm.Node{lineNodes(2, 3), lineNodes(0, 1), lineNodes(1, 2)}, nil,
+			[][]float64{{3, 2, 1, 0}}},
+		{"gap", [][]osm.Node{lineNodes(0, 1), lineNodes(2, 3)}, nil,
+			[][]float64{{0, 1}, {2, 3}}},
+		{"oneway", [][]osm.Node{lineNodes(0, 1), lineNodes(2, 1)}, []bool{false, true},
+			[][]float64{{2, 1, 0}}},
+		{"oneways in opposite directions", [][]osm.Node{lineNodes(0, 1), lineNodes(2, 1)}, []bool{true, true},
+			[][]float64{{0, 1}, {2, 1}}},
+		{"oneway reversed first", [][]osm.Node{lineNodes(1, 0), lineNodes(1, 2)}, []bool{false, true},
+			[][]float64{{0, 1, 2}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			merged := MergeLines(tc.lines, tc.oneway)
+			if xs := lineXs(merged); !reflect.DeepEqual(xs, tc.expected) {
+				t.Errorf("unexpected lines %v, expected %v", xs, tc.expected)
+			}
+		})
+	}
+}
+
+func TestRouteLines(t *testing.T) {
+	way := func(role string, xs ...float64) osm.Member {
+		return osm.Member{Type: osm.WayMember, Role: role, Way: &osm.Way{Nodes: lineNodes(xs...)}}
+	}
+	members := []osm.Member{
+		{Type: osm.NodeMember, Role: "stop"},
+		way("platform", 5, 6),
+		way("", 0, 1),
+		way("forward", 1, 2),
+		way("backward", 3, 2),
+	}
+	lines, oneway := RouteLines(members)
+	if xs := lineXs(lines); !reflect.DeepEqual(xs, [][]float64{{0, 1}, {1, 2}, {2, 3}}) {
+		t.Errorf("unexpected lines %v", xs)
+	}
+	if !reflect.DeepEqual(oneway, []bool{false, true, true}) {
+		t.Errorf("unexpected oneway %v", oneway)
+	}
+	if xs := lineXs(MergeLines(lines, oneway)); !reflect.DeepEqual(xs, [][]float64{{0, 1, 2, 3}}) {
+		t.Errorf("unexpected merged lines %v", xs)
+	}
+}
@@ -2,7 +2,10 @@ package geom
 
 import (
 	"errors"
+	"math"
+	"runtime"
 	"sort"
+	"sync"
 
 	osm "github.com/omniscale/go-osm"
 	"github.com/omniscale/imposm3/geom/geos"
@@ -12,17 +15,19 @@ type PreparedRelation struct {
 	rings []*ring
 	rel   *osm.Relation
 	srid  int
+	// repaired is set if rings were invalid
+	repaired bool
 }
 
 // PrepareRelation is the first step in building a (multi-)polygon of a Relation.
 // It builds rings from all ways and returns an error if there are unclosed rings.
 func PrepareRelation(rel *osm.Relation, srid int, maxRingGap float64) (PreparedRelation, error) {
-	rings, err := buildRings(rel, maxRingGap)
+	rings, repaired, err := buildRings(rel, maxRingGap)
 	if err != nil {
 		return PreparedRelation{}, err
 	}
 
-	return PreparedRelation{rings, rel, srid}, nil
+	return PreparedRelation{rings, rel, srid, repaired}, nil
 }
 
 // Build creates the (multi)polygon Geometry of the Relation.
@@ -31,7 +36,7 @@ func (prep *PreparedRelation) Build() (Geometry, error) {
 	g.SetHandleSrid(prep.srid)
 	defer g.Finish()
 
-	geom, err := buildRelGeometry(g, prep.rel, prep.rings)
+	geom, repaired, err := buildRelGeometry(g, prep.rel, prep.rings)
 	if err != nil {
 		return Geometry{}, err
 	}
@@ -40,7 +45,7 @@ func (prep *PreparedRelation) Build() (Geometry, error) {
 	if wkb == nil {
 		return Geometry{}, errors.New("unable to create WKB for relation")
 	}
-	return Geometry{Geom: geom, Wkb: wkb}, nil
+	return Geometry{Geom: geom, Wkb: wkb, Repaired: repaired || prep.repaired}, nil
 }
 
 func destroyRings(g *geos.Geos, rings []*ring) {
@@ -52,7 +57,7 @@ func destroyRings(g *geos.Geos, rings []*ring) {
 	}
 }
 
-func buildRings(rel *osm.Relation, maxRingGap float64) ([]*ring, error) {
+func buildRings(rel *osm.Relation, maxRingGap float64) ([]*ring, bool, error) {
 	var rings []*ring
 	var incompleteRings []*ring
 	var completeRings []*ring
@@ -81,7 +86,7 @@ func buildRings(rel *osm.Relation, maxRingGap float64) ([]*ring, error) {
 		if r.isClosed() {
 			r.geom, err = Polygon(g, r.nodes)
 			if err != nil {
-				return nil, err
+				return nil, false, err
 			}
 			completeRings = append(completeRings, r)
 		} else {
@@ -98,23 +103,75 @@ func buildRings(rel *osm.Relation, maxRingGap float64) ([]*ring, error) {
 		}
 		ring.geom, err = Polygon(g, ring.nodes)
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
 		completeRings = append(completeRings, ring)
 	}
 
 	if len(completeRings) == 0 {
 		err = ErrorNoRing // for defer
-		return nil, err
+		return nil, false, err
 	}
 
+	// split self-intersecting rings, buffer(0) of the multipolygon would
+	// remove parts of them
+	var repaired bool
+	completeRings, repaired = repairRings(g, completeRings)
+
 	// sort by area (large to small)
 	for _, r := range completeRings {
 		r.area = r.geom.Area()
 	}
 	sort.Sort(sortableRingsDesc(completeRings))
 
-	return completeRings, nil
+	return completeRings, repaired, nil
+}
+
+// repairRings replaces invalid rings with the rings of RepairRing. Returns
+// true if any ring was invalid.
+func repairRings(g *geos.Geos, rings []*ring) ([]*ring, bool) {
+	var result []*ring
+	repaired := false
+	for _, r := range rings {
+		if g.NumCoordinates(r.geom) <= 5 || g.IsValid(r.geom) {
+			result = append(result, r)
+			continue
+		}
+		repaired = true
+		var parts [][]osm.Node
+		if len(r.nodes) <= maxRepairNodes {
+			parts = RepairRing(r.nodes)
+		}
+		if len(parts) == 0 {
+			// repaired by GEOS with the multipolygon
+			result = append(result, r)
+			continue
+		}
+		var partRings []*ring
+		for _, nodes := range parts {
+			geom, err := Polygon(g, nodes)
+			if err != nil {
+				destroyRings(g, partRings)
+				partRings = nil
+				break
+			}
+			partRings = append(partRings, &ring{
+				ways:        r.ways,
+				nodes:       nodes,
+				geom:        geom,
+				holes:       make(map[*ring]bool),
+				containedBy: -1,
+			})
+		}
+		if partRings == nil {
+			result = append(result, r)
+			continue
+		}
+		g.Destroy(r.geom)
+		r.geom = nil
+		result = append(result, partRings...)
+	}
+	return result, repaired
 }
 
 type sortableRingsDesc []*ring
@@ -125,32 +182,31 @@ func (r sortableRingsDesc) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
 
 // buildRelGeometry builds the geometry of rel by creating a multipolygon of all rings.
 // rings need to be sorted by area (large to small).
-func buildRelGeometry(g *geos.Geos, rel *osm.Relation, rings []*ring) (*geos.Geom, error) {
+// Returns true if the multipolygon was invalid and repaired by GEOS.
+func buildRelGeometry(g *geos.Geos, rel *osm.Relation, rings []*ring) (*geos.Geom, bool, error) {
 	totalRings := len(rings)
+	contained, err := ringContainment(g, rings)
+	if err != nil {
+		return nil, false, err
+	}
 	shells := map[*ring]bool{rings[0]: true}
 	for i := 0; i < totalRings; i++ {
-		testGeom := g.Prepare(rings[i].geom)
-		if testGeom == nil {
-			return nil, errors.New("Error while preparing geometry")
-		}
-		for j := i + 1; j < totalRings; j++ {
-			if g.PreparedContains(testGeom, rings[j].geom) {
-				if rings[j].containedBy != -1 {
-					// j is inside a larger ring, remove that relationship
-					// e.g. j is hole inside a hole (i)
-					delete(rings[rings[j].containedBy].holes, rings[j])
-					delete(shells, rings[j])
-				}
-				// remember parent
-				rings[j].containedBy = i
-				// add ring as hole or shell
-				if ringIsHole(rings, j) {
-					rings[i].holes[rings[j]] = true
-					rings[i].outer = false
-				} else {
-					shells[rings[j]] = true
-					rings[i].outer = true
-				}
+		for _, j := range contained[i] {
+			if rings[j].containedBy != -1 {
+				// j is inside a larger ring, remove that relationship
+				// e.g. j is hole inside a hole (i)
+				delete(rings[rings[j].containedBy].holes, rings[j])
+				delete(shells, rings[j])
+			}
+			// remember parent
+			rings[j].containedBy = i
+			// add ring as hole or shell
+			if ringIsHole(rings, j) {
+				rings[i].holes[rings[j]] = true
+				rings[i].outer = false
+			} else {
+				shells[rings[j]] = true
+				rings[i].outer = true
 			}
 		}
 		if rings[i].containedBy == -1 {
@@ -158,7 +214,6 @@ func buildRelGeometry(g *geos.Geos, rel *osm.Relation, rings []*ring) (*geos.Geo
 			shells[rings[i]] = true
 			rings[i].outer = true
 		}
-		g.PreparedDestroy(testGeom)
 	}
 
 	var polygons []*geos.Geom
@@ -168,18 +223,18 @@ func buildRelGeometry(g *geos.Geos, rel *osm.Relation, rings []*ring) (*geos.Geo
 			ring := g.Clone(g.ExteriorRing(hole.geom))
 			g.Destroy(hole.geom)
 			if ring == nil {
-				return nil, errors.New("unable to get exterior ring")
+				return nil, false, errors.New("unable to get exterior ring")
 			}
 			interiors = append(interiors, ring)
 		}
 		exterior := g.Clone(g.ExteriorRing(shell.geom))
 		g.Destroy(shell.geom)
 		if exterior == nil {
-			return nil, errors.New("unable to get exterior ring")
+			return nil, false, errors.New("unable to get exterior ring")
 		}
 		polygon := g.Polygon(exterior, interiors)
 		if polygon == nil {
-			return nil, errors.New("unable to build polygon")
+			return nil, false, errors.New("unable to build polygon")
 		}
 		polygons = append(polygons, polygon)
 	}
@@ -190,13 +245,16 @@ func buildRelGeometry(g *geos.Geos, rel *osm.Relation, rings []*ring) (*geos.Geo
 	} else {
 		result = g.MultiPolygon(polygons)
 		if result == nil {
-			return nil, errors.New("unable to build mulipolygon")
+			return nil, false, errors.New("unable to build mulipolygon")
 		}
 	}
-	var err error
-	result, err = g.MakeValid(result)
-	if err != nil {
-		return nil, err
+	repaired := !g.IsValid(result)
+	if repaired {
+		var err error
+		result, err = g.MakeValid(result)
+		if err != nil {
+			return nil, false, err
+		}
 	}
 
 	g.DestroyLater(result)
@@ -218,7 +276,122 @@ func buildRelGeometry(g *geos.Geos, rel *osm.Relation, rings []*ring) (*geos.Geo
 		}
 	}
 
-	return result, nil
+	return result, repaired, nil
+}
+
+// parallelRings is the number of rings from which the containment of the
+// rings is tested in parallel.
+const parallelRings = 256
+
+// ringContainment returns the indices of all (smaller) rings that are
+// contained in each ring, in ascending order. rings need to be sorted by
+// area (large to small). Relations with many rings are tested in parallel,
+// with a separate GEOS handle for each worker.
+func ringContainment(g *geos.Geos, rings []*ring) ([][]int, error) {
+	candidates := containmentCandidates(rings)
+	contained := make([][]int, len(rings))
+
+	test := func(g *geos.Geos, i int) error {
+		if len(candidates[i]) == 0 {
+			return nil
+		}
+		testGeom := g.Prepare(rings[i].geom)
+		if testGeom == nil {
+			return errors.New("Error while preparing geometry")
+		}
+		for _, j := range candidates[i] {
+			if g.PreparedContains(testGeom, rings[j].geom) {
+				contained[i] = append(contained[i], j)
+			}
+		}
+		g.PreparedDestroy(testGeom)
+		return nil
+	}
+
+	if len(rings) < parallelRings {
+		for i := range rings {
+			if err := test(g, i); err != nil {
+				return nil, err
+			}
+		}
+		return contained, nil
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	next := make(chan int)
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g := geos.NewGeos()
+			defer g.Finish()
+			for i := range next {
+				if err := test(g, i); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range rings {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return contained, nil
+}
+
+// ringBounds is the bounding box of a ring.
+type ringBounds struct {
+	minx, miny, maxx, maxy float64
+}
+
+func (b ringBounds) contains(o ringBounds) bool {
+	return o.minx >= b.minx && o.maxx <= b.maxx && o.miny >= b.miny && o.maxy <= b.maxy
+}
+
+// containmentCandidates returns the indices of all rings (j > i) with a
+// bounding box inside the bounding box of ring i, in ascending order. The
+// rings are indexed by their minx, to only compare rings that overlap
+// in x.
+func containmentCandidates(rings []*ring) [][]int {
+	bounds := make([]ringBounds, len(rings))
+	for i, r := range rings {
+		b := ringBounds{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+		for _, nd := range r.nodes {
+			b.minx, b.miny = math.Min(b.minx, nd.Long), math.Min(b.miny, nd.Lat)
+			b.maxx, b.maxy = math.Max(b.maxx, nd.Long), math.Max(b.maxy, nd.Lat)
+		}
+		bounds[i] = b
+	}
+	byMinx := make([]int, len(rings))
+	for i := range byMinx {
+		byMinx[i] = i
+	}
+	sort.Slice(byMinx, func(a, b int) bool { return bounds[byMinx[a]].minx < bounds[byMinx[b]].minx })
+
+	candidates := make([][]int, len(rings))
+	for i, b := range bounds {
+		start := sort.Search(len(byMinx), func(k int) bool { return bounds[byMinx[k]].minx >= b.minx })
+		for _, j := range byMinx[start:] {
+			if bounds[j].minx > b.maxx {
+				break
+			}
+			if j > i && b.contains(bounds[j]) {
+				candidates[i] = append(candidates[i], j)
+			}
+		}
+		sort.Ints(candidates[i])
+	}
+	return candidates
 }
 
 // ringIsHole returns true if rings[idx] is a hole, False if it is a
@@ -659,3 +659,30 @@ func TestClosedAndOpenRing(t *testing.T) {
 		t.Fatal("geometry not valid", g.AsWkt(geom.Geom))
 	}
 }
+
+func TestContainmentCandidates(t *testing.T) {
+	square := func(x, y, size float64) *ring {
+		return &ring{nodes: ringNodes(x, y, x+size, y, x+size, y+size, x, y+size, x, y)}
+	}
+	rings := []*ring{
+		square(0, 0, 100),
+		square(200, 0, 50),
+		square(10, 10, 20),
+		square(210, 10, 20),
+		square(90, 90, 20), // overlaps 0, but not inside
+		square(15, 15, 5),
+	}
+	candidates := containmentCandidates(rings)
+	expected := [][]int{{2, 5}, {3}, {5}, nil, nil, nil}
+	for i := range expected {
+		if len(candidates[i]) != len(expected[i]) {
+			t.Errorf("unexpected candidates for %d: %v", i, candidates[i])
+			continue
+		}
+		for k := range expected[i] {
+			if candidates[i][k] != expected[i][k] {
+				t.Errorf("unexpected candidates for %d: %v", i, candidates[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package geom
+
+import (
+	"container/heap"
+	"encoding/hex"
+	"errors"
+	"math"
+
+	"github.com/omniscale/imposm3/geom/ewkb"
+)
+
+// labelPrecision is the precision of the label point, relative to the
+// size of the polygon.
+const labelPrecision = 0.001
+
+// maxLabelCells limits the number of cells that are checked for a single
+// polygon.
+const maxLabelCells = 10000
+
+// LabelPoint returns the hex encoded EWKB point with the pole of
+// inaccessibility of the polygon (the point inside of the polygon with the
+// largest distance to its boundary). It uses the largest polygon of
+// multipolygons. The point is always inside of the polygon, unlike the
+// centroid of concave polygons.
+func LabelPoint(wkb []byte) ([]byte, error) {
+	g, err := ewkb.DecodeHex(wkb)
+	if err != nil {
+		return nil, err
+	}
+	rings := largestPolygon(g)
+	if rings == nil {
+		return nil, errors.New("no polygon for label point")
+	}
+	p := ewkb.Geometry{
+		Type:   ewkb.Point,
+		SRID:   g.SRID,
+		Coords: []ewkb.Coord{polylabel(rings)},
+	}
+	b := p.EWKB()
+	result := make([]byte, hex.EncodedLen(len(b)))
+	hex.Encode(result, b)
+	return result, nil
+}
+
+// largestPolygon returns the rings of the polygon with the largest area.
+func largestPolygon(g *ewkb.Geometry) [][]ewkb.Coord {
+	if g.Type == ewkb.Polygon {
+		if len(g.Rings) == 0 || len(g.Rings[0]) < 4 {
+			return nil
+		}
+		return g.Rings
+	}
+	var largest [][]ewkb.Coord
+	largestArea := 0.0
+	for i := range g.Geoms {
+		rings := largestPolygon(&g.Geoms[i])
+		if rings == nil {
+			continue
+		}
+		area := math.Abs(coordsArea(rings[0]))
+		for _, hole := range rings[1:] {
+			area -= math.Abs(coordsArea(hole))
+		}
+		if largest == nil || area > largestArea {
+			largest, largestArea = rings, area
+		}
+	}
+	return largest
+}
+
+func coordsArea(coords []ewkb.Coord) float64 {
+	area := 0.0
+	for i := 0; i < len(coords)-1; i++ {
+		area += coords[i].X*coords[i+1].Y - coords[i+1].X*coords[i].Y
+	}
+	return area / 2
+}
+
+// labelCell is a square cell of the polylabel search.
+type labelCell struct {
+	x, y float64 // center
+	h    float64 // half of the size
+	d    float64 // distance from center to the polygon
+	max  float64 // max distance of any point in the cell to the polygon
+}
+
+func newLabelCell(x, y, h float64, rings [][]ewkb.Coord) *labelCell {
+	d := polygonDistance(x, y, rings)
+	return &labelCell{x: x, y: y, h: h, d: d, max: d + h*math.Sqrt2}
+}
+
+// labelCells is a priority queue of cells, ordered by the max distance.
+type labelCells []*labelCell
+
+func (c labelCells) Len() int            { return len(c) }
+func (c labelCells) Less(i, j int) bool  { return c[i].max > c[j].max }
+func (c labelCells) Swap(i, j int)       { c[i], c[j] = c[j], c[i] }
+func (c *labelCells) Push(x interface{}) { *c = append(*c, x.(*labelCell)) }
+func (c *labelCells) Pop() interface{} {
+	old := *c
+	cell := old[len(old)-1]
+	*c = old[:len(old)-1]
+	return cell
+}
+
+// polylabel returns the pole of inaccessibility of the polygon. It
+// subdivides the bounding box into cells and only refines cells that can
+// contain a better point than the best point so far, see
+// https://github.com/mapbox/polylabel
+func polylabel(rings [][]ewkb.Coord) ewkb.Coord {
+	minx, miny := math.Inf(1), math.Inf(1)
+	maxx, maxy := math.Inf(-1), math.Inf(-1)
+	for _, c := range rings[0] {
+		minx, miny = math.Min(minx, c.X), math.Min(miny, c.Y)
+		maxx, maxy = math.Max(maxx, c.X), math.Max(maxy, c.Y)
+	}
+	width, height := maxx-minx, maxy-miny
+	cellSize := math.Min(width, height)
+	if cellSize == 0 {
+		return ewkb.Coord{X: minx, Y: miny}
+	}
+	precision := math.Max(width, height) * labelPrecision
+
+	cells := &labelCells{}
+	h := cellSize / 2
+	for x := minx; x < maxx; x += cellSize {
+		for y := miny; y < maxy; y += cellSize {
+			heap.Push(cells, newLabelCell(x+h, y+h, h, rings))
+		}
+	}
+
+	best := centroidCell(rings)
+	if c := newLabelCell(minx+width/2, miny+height/2, 0, rings); c.d > best.d {
+		best = c
+	}
+
+	for n := 0; cells.Len() > 0 && n < maxLabelCells; n++ {
+		cell := heap.Pop(cells).(*labelCell)
+		if cell.d > best.d {
+			best = cell
+		}
+		if cell.max-best.d <= precision {
+			continue
+		}
+		h := cell.h / 2
+		heap.Push(cells, newLabelCell(cell.x-h, cell.y-h, h, rings))
+		heap.Push(cells, newLabelCell(cell.x+h, cell.y-h, h, rings))
+		heap.Push(cells, newLabelCell(cell.x-h, cell.y+h, h, rings))
+		heap.Push(cells, newLabelCell(cell.x+h, cell.y+h, h, rings))
+	}
+	return ewkb.Coord{X: best.x, Y: best.y}
+}
+
+// centroidCell returns the cell at the centroid of the exterior ring.
+func centroidCell(rings [][]ewkb.Coord) *labelCell {
+	ring := rings[0]
+	var x, y, area float64
+	for i := 0; i < len(ring)-1; i++ {
+		a, b := ring[i], ring[i+1]
+		f := a.X*b.Y - b.X*a.Y
+		x += (a.X + b.X) * f
+		y += (a.Y + b.Y) * f
+		area += f * 3
+	}
+	if area == 0 {
+		return newLabelCell(ring[0].X, ring[0].Y, 0, rings)
+	}
+	return newLabelCell(x/area, y/area, 0, rings)
+}
+
+// polygonDistance returns the distance from x/y to the boundary of the
+// polygon, negative if x/y is outside.
+func polygonDistance(x, y float64, rings [][]ewkb.Coord) float64 {
+	inside := false
+	minDist := math.Inf(1)
+	for _, ring := range rings {
+		for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+			a, b := ring[i], ring[j]
+			if (a.Y > y) != (b.Y > y) && x < (b.X-a.X)*(y-a.Y)/(b.Y-a.Y)+a.X {
+				inside = !inside
+			}
+			minDist = math.Min(minDist, segmentDistance(x, y, a, b))
+		}
+	}
+	if inside {
+		return minDist
+	}
+	return -minDist
+}
+
+// segmentDistance returns the distance from x/y to the segment a-b.
+func segmentDistance(x, y float64, a, b ewkb.Coord) float64 {
+	px, py := a.X, a.Y
+	dx, dy := b.X-px, b.Y-py
+	if dx != 0 || dy != 0 {
+		t := ((x-px)*dx + (y-py)*dy) / (dx*dx + dy*dy)
+		if t > 1 {
+			px, py = b.X, b.Y
+		} else if t > 0 {
+			px += dx * t
+			py += dy * t
+		}
+	}
+	return math.Hypot(x-px, y-py)
+}
@@ -0,0 +1,63 @@
+package geom
+
+import (
+	"encoding/hex"
+	"math"
+	"testing"
+
+	"github.com/omniscale/imposm3/geom/ewkb"
+)
+
+func coords(xy ...float64) []ewkb.Coord {
+	result := make([]ewkb.Coord, len(xy)/2)
+	for i := range result {
+		result[i] = ewkb.Coord{X: xy[i*2], Y: xy[i*2+1]}
+	}
+	return result
+}
+
+func TestPolylabel(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		rings [][]ewkb.Coord
+		dist  float64
+	}{
+		{"square", [][]ewkb.Coord{coords(0, 0, 10, 0, 10, 10, 0, 10, 0, 0)}, 5},
+		// centroid of the U shape is outside of the polygon, the best
+		// points are at the bottom corners
+		{"u shape", [][]ewkb.Coord{coords(0, 0, 30, 0, 30, 30, 20, 30, 20, 10, 10, 10, 10, 30, 0, 30, 0, 0)},
+			20 / (2 + math.Sqrt2)},
+		{"hole", [][]ewkb.Coord{coords(0, 0, 40, 0, 40, 20, 0, 20, 0, 0), coords(5, 5, 15, 5, 15, 15, 5, 15, 5, 5)}, 10},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := polylabel(tc.rings)
+			d := polygonDistance(c.X, c.Y, tc.rings)
+			if math.Abs(d-tc.dist) > 0.1 {
+				t.Errorf("unexpected label point %v with distance %f, expected %f", c, d, tc.dist)
+			}
+		})
+	}
+}
+
+func TestLabelPoint(t *testing.T) {
+	g := ewkb.Geometry{Type: ewkb.MultiPolygon, SRID: 3857, Geoms: []ewkb.Geometry{
+		{Type: ewkb.Polygon, Rings: [][]ewkb.Coord{coords(0, 0, 10, 0, 10, 10, 0, 10, 0, 0)}},
+		{Type: ewkb.Polygon, Rings: [][]ewkb.Coord{coords(100, 0, 120, 0, 120, 20, 100, 20, 100, 0)}},
+	}}
+	wkb, err := LabelPoint([]byte(hex.EncodeToString(g.EWKB())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ewkb.DecodeHex(wkb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Type != ewkb.Point || p.SRID != 3857 || p.Coords[0] != (ewkb.Coord{X: 110, Y: 10}) {
+		t.Errorf("unexpected label point %#v", p)
+	}
+
+	line := ewkb.Geometry{Type: ewkb.LineString, Coords: coords(0, 0, 10, 0)}
+	if _, err := LabelPoint([]byte(hex.EncodeToString(line.EWKB()))); err == nil {
+		t.Error("expected error for linestring")
+	}
+}
@@ -0,0 +1,370 @@
+package geom
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/geom/geos"
+)
+
+// maxRepairNodes is the maximum number of nodes of rings that are repaired
+// by RepairRing. Larger rings are only repaired by GEOS.
+const maxRepairNodes = 20000
+
+// RepairedPolygon creates a valid polygon from the nodes of a closed way.
+// Invalid polygons are repaired with RepairRing and the repaired rings
+// form a polygon or multipolygon. GEOS repairs (buffer(0)) the geometry
+// only if it is still invalid. Returns true if the polygon was repaired.
+func RepairedPolygon(g *geos.Geos, nodes []osm.Node) (*geos.Geom, bool, error) {
+	geom, err := Polygon(g, nodes)
+	if err != nil {
+		rings := RepairRing(nodes)
+		if len(rings) == 0 {
+			return nil, false, err
+		}
+		geom, err = ringsPolygon(g, rings)
+		return geom, true, err
+	}
+	if g.NumCoordinates(geom) <= 5 || g.IsValid(geom) {
+		// only check for valididty for non-simple geometries
+		return geom, false, nil
+	}
+	if len(nodes) <= maxRepairNodes {
+		if rings := RepairRing(nodes); len(rings) > 0 {
+			repaired, err := ringsPolygon(g, rings)
+			if err == nil && g.IsValid(repaired) {
+				return repaired, true, nil
+			}
+		}
+	}
+	geom, err = g.MakeValid(geom)
+	if err != nil {
+		return nil, false, err
+	}
+	g.DestroyLater(geom)
+	return geom, true, nil
+}
+
+// ringsPolygon creates a polygon or multipolygon from rings without
+// self-intersections. Rings inside of an odd number of other rings are
+// holes.
+func ringsPolygon(g *geos.Geos, rings [][]osm.Node) (*geos.Geom, error) {
+	shells, holes := nestRings(rings)
+	var polygons []*geos.Geom
+	for i, shell := range shells {
+		exterior, err := linearRing(g, shell)
+		if err != nil {
+			destroyAll(g, polygons)
+			return nil, err
+		}
+		var interiors []*geos.Geom
+		for _, hole := range holes[i] {
+			interior, err := linearRing(g, hole)
+			if err != nil {
+				destroyAll(g, interiors)
+				g.Destroy(exterior)
+				destroyAll(g, polygons)
+				return nil, err
+			}
+			interiors = append(interiors, interior)
+		}
+		polygon := g.Polygon(exterior, interiors)
+		if polygon == nil {
+			destroyAll(g, polygons)
+			return nil, errors.New("unable to build polygon")
+		}
+		polygons = append(polygons, polygon)
+	}
+	if len(polygons) == 1 {
+		g.DestroyLater(polygons[0])
+		return polygons[0], nil
+	}
+	result := g.MultiPolygon(polygons)
+	if result == nil {
+		destroyAll(g, polygons)
+		return nil, errors.New("unable to build multipolygon")
+	}
+	g.DestroyLater(result)
+	return result, nil
+}
+
+func destroyAll(g *geos.Geos, geoms []*geos.Geom) {
+	for _, geom := range geoms {
+		g.Destroy(geom)
+	}
+}
+
+// nestRings returns all rings that are shells and the holes of each shell.
+// It sorts rings by area, large to small.
+func nestRings(rings [][]osm.Node) ([][]osm.Node, [][][]osm.Node) {
+	sort.SliceStable(rings, func(i, j int) bool {
+		return math.Abs(ringArea(rings[i])) > math.Abs(ringArea(rings[j]))
+	})
+	// parent is the index of the smallest ring that contains the ring
+	parent := make([]int, len(rings))
+	depth := make([]int, len(rings))
+	shellIdx := make([]int, len(rings))
+	var shells [][]osm.Node
+	var holes [][][]osm.Node
+	for i := range rings {
+		parent[i] = -1
+		for j := i - 1; j >= 0; j-- {
+			if ringInRing(rings[i], rings[j]) {
+				parent[i] = j
+				depth[i] = depth[j] + 1
+				break
+			}
+		}
+		if depth[i]%2 == 0 {
+			shellIdx[i] = len(shells)
+			shells = append(shells, rings[i])
+			holes = append(holes, nil)
+		} else {
+			holes[shellIdx[parent[i]]] = append(holes[shellIdx[parent[i]]], rings[i])
+		}
+	}
+	return shells, holes
+}
+
+// ringInRing returns true if ring a is inside of ring b. The rings can
+// touch, but they must not cross each other.
+func ringInRing(a, b []osm.Node) bool {
+	for _, nd := range a[:len(a)-1] {
+		onBoundary := false
+		for i := 0; i < len(b)-1; i++ {
+			if pointOnSegment(nd, b[i], b[i+1]) {
+				onBoundary = true
+				break
+			}
+		}
+		if !onBoundary {
+			return pointInRing(nd, b)
+		}
+	}
+	// all nodes are on the boundary, check the middle of the first segment
+	mid := osm.Node{Long: (a[0].Long + a[1].Long) / 2, Lat: (a[0].Lat + a[1].Lat) / 2}
+	return pointInRing(mid, b)
+}
+
+// pointInRing returns true if the node is inside of the closed ring.
+func pointInRing(nd osm.Node, ring []osm.Node) bool {
+	inside := false
+	for i, j := 0, len(ring)-2; i < len(ring)-1; j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Lat > nd.Lat) != (b.Lat > nd.Lat) &&
+			nd.Long < (b.Long-a.Long)*(nd.Lat-a.Lat)/(b.Lat-a.Lat)+a.Long {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// ringArea returns the signed area of the closed ring, positive for
+// counter-clockwise rings.
+func ringArea(ring []osm.Node) float64 {
+	area := 0.0
+	for i := 0; i < len(ring)-1; i++ {
+		area += ring[i].Long*ring[i+1].Lat - ring[i+1].Long*ring[i].Lat
+	}
+	return area / 2
+}
+
+// RepairRing returns valid rings for the nodes of a ring. It closes the
+// ring, removes repeated nodes and spikes, and splits the ring at each
+// self-intersection. Rings without an area are removed. The returned rings
+// are closed and have no self-intersections, but they can touch each other.
+// Returns nil if the ring could not be repaired.
+func RepairRing(nodes []osm.Node) [][]osm.Node {
+	pts := make([]osm.Node, 0, len(nodes))
+	for _, nd := range nodes {
+		if len(pts) == 0 || !nodesEqual(pts[len(pts)-1], nd) {
+			pts = append(pts, nd)
+		}
+	}
+	// open ring, the segment from the last to the first node is implicit
+	for len(pts) > 1 && nodesEqual(pts[0], pts[len(pts)-1]) {
+		pts = pts[:len(pts)-1]
+	}
+	r := ringRepair{splits: 2*len(pts) + 16}
+	r.repair(pts)
+	if r.splits < 0 {
+		return nil
+	}
+	return r.rings
+}
+
+type ringRepair struct {
+	rings [][]osm.Node
+	// splits limits the number of splits, as each split can add two nodes
+	splits int
+}
+
+// repair adds the valid rings of the open ring pts.
+func (r *ringRepair) repair(pts []osm.Node) {
+	pts = removeSpikes(pts)
+	if len(pts) < 3 || r.splits < 0 {
+		return
+	}
+	if a, b, pts, ok := selfIntersection(pts); ok {
+		r.splits--
+		first := append([]osm.Node(nil), pts[a:b]...)
+		second := append(append([]osm.Node(nil), pts[b:]...), pts[:a]...)
+		r.repair(first)
+		r.repair(second)
+		return
+	}
+	ring := append(pts, pts[0])
+	if !degenerateRing(ring) {
+		r.rings = append(r.rings, ring)
+	}
+}
+
+// removeSpikes removes nodes where the ring goes back to the previous node
+// (A-B-A).
+func removeSpikes(pts []osm.Node) []osm.Node {
+	for removed := true; removed && len(pts) >= 3; {
+		removed = false
+		for i := 0; i < len(pts) && len(pts) >= 3; i++ {
+			prev := pts[(i+len(pts)-1)%len(pts)]
+			next := pts[(i+1)%len(pts)]
+			if nodesEqual(prev, next) {
+				// remove pts[i] and next
+				pts = removeIndices(pts, i, (i+1)%len(pts))
+				removed = true
+			}
+		}
+	}
+	return pts
+}
+
+func removeIndices(pts []osm.Node, i, j int) []osm.Node {
+	result := make([]osm.Node, 0, len(pts)-2)
+	for k, nd := range pts {
+		if k != i && k != j {
+			result = append(result, nd)
+		}
+	}
+	return result
+}
+
+// selfIntersection finds the first intersection of two non-adjacent
+// segments of the open ring. It returns the ring with the intersection
+// node inserted into both segments, and the indices a < b of this node,
+// so that pts[a:b] and pts[b:]+pts[:a] are the two parts of the ring.
+func selfIntersection(pts []osm.Node) (int, int, []osm.Node, bool) {
+	n := len(pts)
+	for i := 0; i < n; i++ {
+		p1, p2 := pts[i], pts[(i+1)%n]
+		for j := i + 2; j < n; j++ {
+			if i == 0 && j == n-1 {
+				// adjacent by the closing segment
+				continue
+			}
+			p3, p4 := pts[j], pts[(j+1)%n]
+			p, ok := segmentIntersection(p1, p2, p3, p4)
+			if !ok {
+				continue
+			}
+			var a, b int
+			pts, a, j = insertNode(pts, i, p, j)
+			pts, b, _ = insertNode(pts, j, p, -1)
+			if a > b {
+				a, b = b, a
+			}
+			if a == b {
+				// only with nearly identical nodes
+				return 0, 0, pts, false
+			}
+			return a, b, pts, true
+		}
+	}
+	return 0, 0, pts, false
+}
+
+// insertNode inserts p into the segment starting at pts[i], if it is not
+// one of the end nodes. Returns the ring, the index of p and the index
+// other, shifted if p was inserted before.
+func insertNode(pts []osm.Node, i int, p osm.Node, other int) ([]osm.Node, int, int) {
+	next := (i + 1) % len(pts)
+	if nodesEqual(pts[i], p) {
+		return pts, i, other
+	}
+	if nodesEqual(pts[next], p) {
+		return pts, next, other
+	}
+	result := make([]osm.Node, 0, len(pts)+1)
+	result = append(result, pts[:i+1]...)
+	result = append(result, p)
+	result = append(result, pts[i+1:]...)
+	if other > i {
+		other++
+	}
+	return result, i + 1, other
+}
+
+func cross(ox, oy, ax, ay, bx, by float64) float64 {
+	return (ax-ox)*(by-oy) - (ay-oy)*(bx-ox)
+}
+
+func pointOnSegment(p, a, b osm.Node) bool {
+	if nodesEqual(p, a) || nodesEqual(p, b) {
+		return true
+	}
+	if cross(a.Long, a.Lat, b.Long, b.Lat, p.Long, p.Lat) != 0 {
+		return false
+	}
+	return math.Min(a.Long, b.Long) <= p.Long && p.Long <= math.Max(a.Long, b.Long) &&
+		math.Min(a.Lat, b.Lat) <= p.Lat && p.Lat <= math.Max(a.Lat, b.Lat)
+}
+
+// segmentIntersection returns a common node of the segments p1-p2 and
+// p3-p4. For overlapping segments it returns an end node that is on the
+// other segment.
+func segmentIntersection(p1, p2, p3, p4 osm.Node) (osm.Node, bool) {
+	d := (p2.Long-p1.Long)*(p4.Lat-p3.Lat) - (p2.Lat-p1.Lat)*(p4.Long-p3.Long)
+	if d == 0 {
+		if cross(p1.Long, p1.Lat, p2.Long, p2.Lat, p3.Long, p3.Lat) != 0 {
+			// parallel
+			return osm.Node{}, false
+		}
+		for _, c := range []struct{ p, a, b osm.Node }{{p3, p1, p2}, {p4, p1, p2}, {p1, p3, p4}, {p2, p3, p4}} {
+			if pointOnSegment(c.p, c.a, c.b) {
+				return osm.Node{Long: c.p.Long, Lat: c.p.Lat}, true
+			}
+		}
+		return osm.Node{}, false
+	}
+	t := ((p3.Long-p1.Long)*(p4.Lat-p3.Lat) - (p3.Lat-p1.Lat)*(p4.Long-p3.Long)) / d
+	u := ((p3.Long-p1.Long)*(p2.Lat-p1.Lat) - (p3.Lat-p1.Lat)*(p2.Long-p1.Long)) / d
+	if t < 0 || t > 1 || u < 0 || u > 1 {
+		return osm.Node{}, false
+	}
+	switch {
+	case t == 0:
+		return osm.Node{Long: p1.Long, Lat: p1.Lat}, true
+	case t == 1:
+		return osm.Node{Long: p2.Long, Lat: p2.Lat}, true
+	case u == 0:
+		return osm.Node{Long: p3.Long, Lat: p3.Lat}, true
+	case u == 1:
+		return osm.Node{Long: p4.Long, Lat: p4.Lat}, true
+	}
+	return osm.Node{Long: p1.Long + t*(p2.Long-p1.Long), Lat: p1.Lat + t*(p2.Lat-p1.Lat)}, true
+}
+
+// degenerateRing returns true for closed rings without an area.
+func degenerateRing(ring []osm.Node) bool {
+	if len(ring) < 4 {
+		return true
+	}
+	minx, miny := math.Inf(1), math.Inf(1)
+	maxx, maxy := math.Inf(-1), math.Inf(-1)
+	for _, nd := range ring {
+		minx, maxx = math.Min(minx, nd.Long), math.Max(maxx, nd.Long)
+		miny, maxy = math.Min(miny, nd.Lat), math.Max(maxy, nd.Lat)
+	}
+	// relative to the bbox, for collinear nodes with rounding errors
+	return math.Abs(ringArea(ring)) <= 1e-9*(maxx-minx)*(maxy-miny)
+}
@@ -0,0 +1,105 @@
+package geom
+
+import (
+	"math"
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/geom/geos"
+)
+
+func ringNodes(coords ...float64) []osm.Node {
+	nodes := make([]osm.Node, len(coords)/2)
+	for i := range nodes {
+		nodes[i] = osm.Node{Long: coords[i*2], Lat: coords[i*2+1]}
+	}
+	return nodes
+}
+
+func TestRepairRing(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		nodes []osm.Node
+		areas []float64
+	}{
+		{"valid", ringNodes(0, 0, 10, 0, 10, 10, 0, 10, 0, 0), []float64{100}},
+		{"unclosed", ringNodes(0, 0, 10, 0, 10, 10, 0, 10), []float64{100}},
+		{"repeated nodes", ringNodes(0, 0, 10, 0, 10, 0, 10, 10, 0, 10, 0, 10, 0, 0), []float64{100}},
+		{"spike", ringNodes(0, 0, 10, 0, 20, 0, 10, 0, 10, 10, 0, 10, 0, 0), []float64{100}},
+		{"bowtie", ringNodes(0, 0, 10, 10, 10, 0, 0, 10, 0, 0), []float64{25, 25}},
+		{"figure eight at node", ringNodes(0, 0, 10, 0, 10, 10, 20, 10, 20, 20, 10, 20, 10, 10, 0, 10, 0, 0), []float64{100, 100}},
+		{"inverted hole", ringNodes(0, 0, 10, 0, 10, 10, 5, 10, 6, 6, 4, 6, 5, 10, 0, 10, 0, 0), []float64{100, 4}},
+		{"collinear", ringNodes(0, 0, 10, 0, 20, 0, 0, 0), nil},
+		{"too few nodes", ringNodes(0, 0, 10, 0, 0, 0), nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rings := RepairRing(tc.nodes)
+			if len(rings) != len(tc.areas) {
+				t.Fatalf("expected %d rings, got %v", len(tc.areas), rings)
+			}
+			shells, _ := nestRings(rings)
+			for i, r := range rings {
+				if !nodesEqual(r[0], r[len(r)-1]) {
+					t.Errorf("ring %d not closed %v", i, r)
+				}
+				if _, _, _, ok := selfIntersection(r[:len(r)-1]); ok {
+					t.Errorf("ring %d has self-intersections %v", i, r)
+				}
+				if a := math.Abs(ringArea(r)); math.Abs(a-tc.areas[i]) > 1e-9 {
+					t.Errorf("ring %d has area %f, expected %f", i, a, tc.areas[i])
+				}
+			}
+			if tc.name == "inverted hole" && len(shells) != 1 {
+				t.Errorf("expected one shell with hole, got %v", shells)
+			}
+			if tc.name == "bowtie" && len(shells) != 2 {
+				t.Errorf("expected two shells, got %v", shells)
+			}
+		})
+	}
+}
+
+func TestSegmentIntersection(t *testing.T) {
+	n := func(x, y float64) osm.Node { return osm.Node{Long: x, Lat: y} }
+	for _, tc := range []struct {
+		p1, p2, p3, p4 osm.Node
+		expected       osm.Node
+		ok             bool
+	}{
+		{n(0, 0), n(10, 10), n(0, 10), n(10, 0), n(5, 5), true},
+		{n(0, 0), n(10, 0), n(0, 1), n(10, 1), osm.Node{}, false},
+		{n(0, 0), n(10, 0), n(5, 0), n(5, 5), n(5, 0), true},
+		{n(0, 0), n(10, 0), n(5, 0), n(20, 0), n(5, 0), true},
+		{n(0, 0), n(10, 0), n(11, -1), n(11, 1), osm.Node{}, false},
+	} {
+		p, ok := segmentIntersection(tc.p1, tc.p2, tc.p3, tc.p4)
+		if ok != tc.ok || (ok && !nodesEqual(p, tc.expected)) {
+			t.Errorf("unexpected intersection of %v-%v %v-%v: %v %v", tc.p1, tc.p2, tc.p3, tc.p4, p, ok)
+		}
+	}
+}
+
+func TestRepairedPolygon(t *testing.T) {
+	g := geos.NewGeos()
+	defer g.Finish()
+
+	geom, repaired, err := RepairedPolygon(g, ringNodes(0, 0, 10, 0, 10, 10, 0, 10, 0, 0))
+	if err != nil || repaired {
+		t.Fatal("valid polygon repaired", err)
+	}
+
+	// buffer(0) would only keep one half of the bowtie
+	geom, repaired, err = RepairedPolygon(g, ringNodes(0, 0, 5, 5, 10, 10, 10, 0, 0, 10, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !repaired {
+		t.Error("bowtie not repaired")
+	}
+	if !g.IsValid(geom) {
+		t.Error("bowtie not valid")
+	}
+	if g.Type(geom) != "MultiPolygon" || geom.Area() != 50 {
+		t.Errorf("unexpected geometry %s with area %f", g.Type(geom), geom.Area())
+	}
+}
@@ -0,0 +1,220 @@
+package geom
+
+import (
+	"math"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/proj"
+)
+
+// RoutePosition is the position of a way member along the route of its
+// relation.
+type RoutePosition struct {
+	// Index is the position of the way along the route, starting with 0.
+	Index int
+	// Offset is the distance from the start of the route to the start of
+	// the way in meters.
+	Offset float64
+}
+
+// RoutePositions orders the way members of a route relation along the
+// route and returns the position of each member. Members that are not part
+// of the route (nodes, platforms, etc.) have no position.
+//
+// Ways are ordered by the members, but ways that are connected to the end
+// of the route so far are preferred, to support unordered members. Ways
+// that are not connected are added after a gap; the length of the gap is
+// included in the offsets. Ways are reversed if needed, except oneway
+// (forward/backward) ways. Closed ways (roundabouts) are entered and left
+// at any node and only the traveled part in the direction of the way is
+// included in the offsets. The nodes need to be in the srid projection.
+func RoutePositions(members []osm.Member, srid int) []*RoutePosition {
+	positions := make([]*RoutePosition, len(members))
+	ways := routeWays(members)
+	if len(ways) == 0 {
+		return positions
+	}
+
+	r := routeBuilder{ways: ways, used: make([]bool, len(ways)), srid: srid, prev: -1}
+	for index := range ways {
+		i, entry, reversed := r.next()
+		w := &r.ways[i]
+		r.used[i] = true
+		if reversed {
+			w.nodes = reversedNodes(w.nodes)
+		}
+		positions[w.member] = &RoutePosition{Index: index, Offset: r.offset}
+		if isClosed(w.nodes) {
+			r.entry = entry
+		} else {
+			r.offset += r.length(w.nodes)
+		}
+		r.prev = i
+	}
+	return positions
+}
+
+// routeBuilder contains the state of RoutePositions.
+type routeBuilder struct {
+	ways   []routeWay
+	used   []bool
+	srid   int
+	offset float64
+	// prev is the last added way and entry the node where a closed way
+	// was entered
+	prev  int
+	entry int
+}
+
+// next returns the next way, the node where it is entered (for closed
+// ways) and whether it needs to be reversed. The offset is updated with the
+// traveled part of a previous closed way and with the gap to the next way.
+func (r *routeBuilder) next() (int, int, bool) {
+	if r.prev == -1 {
+		return 0, 0, r.reverseFirst()
+	}
+	// prefer the next member, then any connected way
+	for n := 1; n <= len(r.ways); n++ {
+		i := (r.prev + n) % len(r.ways)
+		if r.used[i] {
+			continue
+		}
+		if exit, entry, reversed, ok := r.connect(i); ok {
+			r.offset += r.traveled(exit)
+			return i, entry, reversed
+		}
+	}
+
+	// gap to the next unused way, from the end of the previous way
+	i := r.prev
+	for r.used[i] {
+		i = (i + 1) % len(r.ways)
+	}
+	prev := r.ways[r.prev].nodes
+	end := prev[len(prev)-1]
+	if isClosed(prev) {
+		end = prev[r.entry]
+	}
+	nodes := r.ways[i].nodes
+	if isClosed(nodes) {
+		entry := 0
+		for j := range nodes {
+			if r.distance(end, nodes[j]) < r.distance(end, nodes[entry]) {
+				entry = j
+			}
+		}
+		r.offset += r.distance(end, nodes[entry])
+		return i, entry, false
+	}
+	first, last := r.distance(end, nodes[0]), r.distance(end, nodes[len(nodes)-1])
+	if !r.ways[i].oneway && last < first {
+		r.offset += last
+		return i, 0, true
+	}
+	r.offset += first
+	return i, 0, false
+}
+
+// reverseFirst returns true if the first way needs to be reversed, as the
+// second way is connected to its first node.
+func (r *routeBuilder) reverseFirst() bool {
+	w := r.ways[0]
+	if w.oneway || len(r.ways) < 2 || isClosed(w.nodes) {
+		return false
+	}
+	next := r.ways[1].nodes
+	first, last := w.nodes[0], w.nodes[len(w.nodes)-1]
+	connected := func(nd osm.Node) bool {
+		if isClosed(next) {
+			for _, n := range next {
+				if nodesEqual(n, nd) {
+					return true
+				}
+			}
+			return false
+		}
+		return nodesEqual(next[0], nd) || nodesEqual(next[len(next)-1], nd)
+	}
+	return !connected(last) && connected(first)
+}
+
+// connect checks if way i is connected to the end of the previous way.
+// It returns the node where the previous closed way is left, the node where
+// way i is entered if it is closed, and whether way i needs to be
+// reversed.
+func (r *routeBuilder) connect(i int) (exit, entry int, reversed, ok bool) {
+	prev := r.ways[r.prev].nodes
+	ends := []int{len(prev) - 1}
+	if isClosed(prev) {
+		// a closed way can be left at any node
+		ends = ends[:0]
+		for j := 0; j < len(prev)-1; j++ {
+			ends = append(ends, (r.entry+j)%(len(prev)-1))
+		}
+	}
+	w := r.ways[i]
+	for _, e := range ends {
+		end := prev[e]
+		if isClosed(w.nodes) {
+			for j, nd := range w.nodes {
+				if nodesEqual(nd, end) {
+					return e, j, false, true
+				}
+			}
+			continue
+		}
+		if nodesEqual(w.nodes[0], end) {
+			return e, 0, false, true
+		}
+		if !w.oneway && nodesEqual(w.nodes[len(w.nodes)-1], end) {
+			return e, 0, true, true
+		}
+	}
+	return 0, 0, false, false
+}
+
+// traveled returns the length of the previous closed way from the entry to
+// the exit node, in the direction of the way.
+func (r *routeBuilder) traveled(exit int) float64 {
+	nodes := r.ways[r.prev].nodes
+	if !isClosed(nodes) {
+		return 0
+	}
+	length := 0.0
+	n := len(nodes) - 1
+	for j := r.entry; j%n != exit%n; j++ {
+		length += r.distance(nodes[j%n], nodes[(j+1)%n])
+	}
+	return length
+}
+
+func (r *routeBuilder) length(nodes []osm.Node) float64 {
+	length := 0.0
+	for i := 1; i < len(nodes); i++ {
+		length += r.distance(nodes[i-1], nodes[i])
+	}
+	return length
+}
+
+// distance returns the distance in meters. It uses the great-circle
+// distance for EPSG:4326 and EPSG:3857 and the distance in the units of the
+// projection otherwise.
+func (r *routeBuilder) distance(a, b osm.Node) float64 {
+	switch r.srid {
+	case 3857:
+		a.Long, a.Lat = proj.MercToWgs(a.Long, a.Lat)
+		b.Long, b.Lat = proj.MercToWgs(b.Long, b.Lat)
+	case 4326:
+	default:
+		return math.Hypot(a.Long-b.Long, a.Lat-b.Lat)
+	}
+	const earthRadius = 6371008.8
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dlat, dlong := lat2-lat1, (b.Long-a.Long)*math.Pi/180
+	h := math.Sin(dlat/2)*math.Sin(dlat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dlong/2)*math.Sin(dlong/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+func isClosed(nodes []osm.Node) bool {
+	return len(nodes) > 3 && nodesEqual(nodes[0], nodes[len(nodes)-1])
+}
@@ -0,0 +1,70 @@
+package geom
+
+import (
+	"math"
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+)
+
+func TestRoutePositions(t *testing.T) {
+	nd := func(x, y float64) osm.Node { return osm.Node{Long: x, Lat: y} }
+	way := func(role string, nodes ...osm.Node) osm.Member {
+		return osm.Member{Type: osm.WayMember, Role: role, Way: &osm.Way{Nodes: nodes}}
+	}
+	stop := osm.Member{Type: osm.NodeMember, Role: "stop"}
+	roundabout := []osm.Node{nd(20, 0), nd(22, 2), nd(20, 4), nd(18, 2), nd(20, 0)}
+
+	type pos struct {
+		index  int
+		offset float64
+	}
+	for _, tc := range []struct {
+		name     string
+		members  []osm.Member
+		expected []*pos
+	}{
+		{"ordered",
+			[]osm.Member{stop, way("", nd(0, 0), nd(10, 0)), way("", nd(10, 0), nd(20, 0))},
+			[]*pos{nil, {0, 0}, {1, 10}}},
+		{"first reversed",
+			[]osm.Member{way("", nd(10, 0), nd(0, 0)), way("", nd(10, 0), nd(20, 0))},
+			[]*pos{{0, 0}, {1, 10}}},
+		{"unordered",
+			[]osm.Member{way("", nd(0, 0), nd(10, 0)), way("", nd(20, 0), nd(30, 0)), way("", nd(10, 0), nd(20, 0))},
+			[]*pos{{0, 0}, {2, 20}, {1, 10}}},
+		{"gap",
+			[]osm.Member{way("", nd(0, 0), nd(10, 0)), way("", nd(25, 0), nd(15, 0))},
+			[]*pos{{0, 0}, {1, 15}}},
+		{"backward",
+			[]osm.Member{way("", nd(0, 0), nd(10, 0)), way("backward", nd(20, 0), nd(10, 0)), way("", nd(20, 0), nd(30, 0))},
+			[]*pos{{0, 0}, {1, 10}, {2, 20}}},
+		{"roundabout",
+			[]osm.Member{way("", nd(20, -10), nd(20, 0)), way("", roundabout...), way("", nd(20, 4), nd(20, 14))},
+			[]*pos{{0, 0}, {1, 10}, {2, 10 + 2*math.Sqrt(8)}}},
+		{"platform",
+			[]osm.Member{way("platform", nd(0, 1), nd(10, 1)), way("", nd(0, 0), nd(10, 0))},
+			[]*pos{nil, {0, 0}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			positions := RoutePositions(tc.members, 0)
+			for i, p := range positions {
+				e := tc.expected[i]
+				if (p == nil) != (e == nil) || (p != nil && (p.Index != e.index || math.Abs(p.Offset-e.offset) > 1e-9)) {
+					t.Errorf("unexpected position of member %d: %v, expected %v", i, p, e)
+				}
+			}
+		})
+	}
+}
+
+func TestRouteDistance(t *testing.T) {
+	r := routeBuilder{srid: 4326}
+	if d := r.distance(osm.Node{Long: 0, Lat: 0}, osm.Node{Long: 1, Lat: 0}); math.Abs(d-111195) > 1 {
+		t.Errorf("unexpected distance %f", d)
+	}
+	r.srid = 3857
+	if d := r.distance(osm.Node{Long: 0, Lat: 0}, osm.Node{Long: 111319.49, Lat: 0}); math.Abs(d-111195) > 1 {
+		t.Errorf("unexpected distance %f", d)
+	}
+}
@@ -0,0 +1,219 @@
+package geom
+
+import (
+	"container/heap"
+	"encoding/hex"
+	"math"
+
+	"github.com/omniscale/imposm3/geom/ewkb"
+	"github.com/omniscale/imposm3/proj"
+)
+
+// metersPerDegree is the length of a degree at the equator.
+const metersPerDegree = 6378137 * math.Pi / 180
+
+// Simplification algorithms of Simplified.
+const (
+	DouglasPeucker = "douglas-peucker"
+	Visvalingam    = "visvalingam"
+)
+
+// Simplified returns the hex encoded EWKB geometry simplified with the
+// Douglas-Peucker or Visvalingam-Whyatt algorithm. The tolerance is in
+// meters, it is converted to the units of EPSG:4326 and EPSG:3857 at the
+// center of the geometry. Visvalingam-Whyatt removes all coordinates with
+// an effective area below the square of the tolerance. Rings of polygons
+// keep at least four coordinates and holes that collapse are removed. Like
+// ST_Simplify, the simplified polygons can be invalid.
+func Simplified(wkb []byte, tolerance float64, algorithm string) ([]byte, error) {
+	g, err := ewkb.DecodeHex(wkb)
+	if err != nil {
+		return nil, err
+	}
+	minx, miny, maxx, maxy := g.Bounds()
+	if math.IsInf(minx, 0) {
+		return wkb, nil
+	}
+	switch g.SRID {
+	case 4326:
+		tolerance /= metersPerDegree
+	case 3857:
+		_, lat := proj.MercToWgs((minx+maxx)/2, (miny+maxy)/2)
+		tolerance /= math.Cos(lat * math.Pi / 180)
+	}
+	simplify := func(coords []ewkb.Coord) []ewkb.Coord {
+		return simplifyCoords(coords, tolerance)
+	}
+	if algorithm == Visvalingam {
+		simplify = func(coords []ewkb.Coord) []ewkb.Coord {
+			return simplifyCoordsVW(coords, tolerance*tolerance)
+		}
+	}
+	if !simplifyGeometry(g, simplify) {
+		return wkb, nil
+	}
+	b := g.EWKB()
+	result := make([]byte, hex.EncodedLen(len(b)))
+	hex.Encode(result, b)
+	return result, nil
+}
+
+// simplifyGeometry simplifies all lines and rings of g. Returns true if
+// any coordinate was removed.
+func simplifyGeometry(g *ewkb.Geometry, simplify func([]ewkb.Coord) []ewkb.Coord) bool {
+	changed := false
+	switch g.Type {
+	case ewkb.LineString:
+		coords := simplify(g.Coords)
+		changed = len(coords) != len(g.Coords)
+		g.Coords = coords
+	case ewkb.Polygon:
+		rings := g.Rings[:0]
+		for i, r := range g.Rings {
+			simplified := simplify(r)
+			if len(simplified) < 4 {
+				if i > 0 {
+					// remove collapsed hole
+					changed = true
+					continue
+				}
+				simplified = r
+			}
+			if len(simplified) != len(r) {
+				changed = true
+			}
+			rings = append(rings, simplified)
+		}
+		g.Rings = rings
+	}
+	for i := range g.Geoms {
+		if simplifyGeometry(&g.Geoms[i], simplify) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// simplifyCoords returns the coordinates that are kept by the
+// Douglas-Peucker algorithm. The first and last coordinates are always
+// kept.
+func simplifyCoords(coords []ewkb.Coord, tolerance float64) []ewkb.Coord {
+	if len(coords) < 3 {
+		return coords
+	}
+	keep := make([]bool, len(coords))
+	keep[0], keep[len(coords)-1] = true, true
+	// iterative, to support lines with many coordinates
+	stack := [][2]int{{0, len(coords) - 1}}
+	for len(stack) > 0 {
+		first, last := stack[len(stack)-1][0], stack[len(stack)-1][1]
+		stack = stack[:len(stack)-1]
+		maxDist, index := 0.0, 0
+		for i := first + 1; i < last; i++ {
+			d := segmentDistance(coords[i].X, coords[i].Y, coords[first], coords[last])
+			if d > maxDist {
+				maxDist, index = d, i
+			}
+		}
+		if maxDist > tolerance {
+			keep[index] = true
+			stack = append(stack, [2]int{first, index}, [2]int{index, last})
+		}
+	}
+	result := make([]ewkb.Coord, 0, len(coords))
+	for i, c := range coords {
+		if keep[i] {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// vwPoint is a coordinate of the Visvalingam-Whyatt algorithm, linked to
+// its remaining neighbours.
+type vwPoint struct {
+	i          int
+	area       float64
+	prev, next *vwPoint
+	index      int // in vwHeap
+}
+
+func (p *vwPoint) updateArea(coords []ewkb.Coord) {
+	a, b, c := coords[p.prev.i], coords[p.i], coords[p.next.i]
+	p.area = math.Abs((a.X*(b.Y-c.Y) + b.X*(c.Y-a.Y) + c.X*(a.Y-b.Y)) / 2)
+}
+
+// vwHeap is a priority queue of points, ordered by area.
+type vwHeap []*vwPoint
+
+func (h vwHeap) Len() int           { return len(h) }
+func (h vwHeap) Less(i, j int) bool { return h[i].area < h[j].area }
+func (h vwHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *vwHeap) Push(x interface{}) {
+	p := x.(*vwPoint)
+	p.index = len(*h)
+	*h = append(*h, p)
+}
+func (h *vwHeap) Pop() interface{} {
+	old := *h
+	p := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return p
+}
+
+// simplifyCoordsVW returns the coordinates that are kept by the
+// Visvalingam-Whyatt algorithm. It removes the coordinate with the smallest
+// triangle area with its neighbours, till all areas are above minArea. The
+// first and last coordinates are always kept.
+func simplifyCoordsVW(coords []ewkb.Coord, minArea float64) []ewkb.Coord {
+	if len(coords) < 3 {
+		return coords
+	}
+	points := make([]vwPoint, len(coords))
+	for i := range points {
+		points[i].i = i
+		if i > 0 {
+			points[i].prev = &points[i-1]
+		}
+		if i < len(points)-1 {
+			points[i].next = &points[i+1]
+		}
+	}
+	h := make(vwHeap, 0, len(points)-2)
+	for i := 1; i < len(points)-1; i++ {
+		points[i].updateArea(coords)
+		heap.Push(&h, &points[i])
+	}
+
+	removed := make([]bool, len(coords))
+	maxArea := 0.0
+	for h.Len() > 0 {
+		p := heap.Pop(&h).(*vwPoint)
+		// the effective area never decreases, so that points are not
+		// removed before their removed neighbours
+		area := math.Max(p.area, maxArea)
+		if area >= minArea {
+			break
+		}
+		maxArea = area
+		removed[p.i] = true
+		p.prev.next, p.next.prev = p.next, p.prev
+		for _, n := range []*vwPoint{p.prev, p.next} {
+			if n.prev != nil && n.next != nil {
+				n.updateArea(coords)
+				heap.Fix(&h, n.index)
+			}
+		}
+	}
+
+	result := make([]ewkb.Coord, 0, len(coords))
+	for i, c := range coords {
+		if !removed[i] {
+			result = append(result, c)
+		}
+	}
+	return result
+}
@@ -0,0 +1,94 @@
+package geom
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/omniscale/imposm3/geom/ewkb"
+)
+
+func TestSimplifyCoords(t *testing.T) {
+	line := coords(0, 0, 1, 0.1, 2, -0.1, 3, 5, 4, 6, 5, 7.05, 6, 8)
+	if s := simplifyCoords(line, 0.5); len(s) != 4 || s[1] != line[2] || s[2] != line[3] {
+		t.Errorf("unexpected simplified line %v", s)
+	}
+	if s := simplifyCoords(line, 0.01); len(s) != len(line) {
+		t.Errorf("unexpected simplified line %v", s)
+	}
+	if s := simplifyCoords(line, 100); len(s) != 2 {
+		t.Errorf("unexpected simplified line %v", s)
+	}
+}
+
+func TestSimplifyCoordsVW(t *testing.T) {
+	line := coords(0, 0, 1, 0.1, 2, 0, 3, 2, 4, 0)
+	if s := simplifyCoordsVW(line, 0.5); len(s) != 4 || s[1] != line[2] {
+		t.Errorf("unexpected simplified line %v", s)
+	}
+	// 2/0 has an area of 2 after 1/0.1 was removed
+	if s := simplifyCoordsVW(line, 3); len(s) != 3 || s[1] != line[3] {
+		t.Errorf("unexpected simplified line %v", s)
+	}
+	if s := simplifyCoordsVW(line, 0.01); len(s) != len(line) {
+		t.Errorf("unexpected simplified line %v", s)
+	}
+	if s := simplifyCoordsVW(line, 100); len(s) != 2 {
+		t.Errorf("unexpected simplified line %v", s)
+	}
+}
+
+func TestSimplified(t *testing.T) {
+	g := ewkb.Geometry{Type: ewkb.Polygon, SRID: 3857, Rings: [][]ewkb.Coord{
+		coords(0, 0, 50, 1, 100, 0, 100, 100, 0, 100, 0, 0),
+		coords(10, 10, 12, 10, 12, 12, 10, 10),
+	}}
+	wkb := []byte(hex.EncodeToString(g.EWKB()))
+
+	simplified, err := Simplified(wkb, 5, DouglasPeucker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ewkb.DecodeHex(simplified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// exterior without 50/1, collapsed hole removed
+	if len(s.Rings) != 1 || len(s.Rings[0]) != 5 {
+		t.Errorf("unexpected simplified polygon %v", s.Rings)
+	}
+
+	// 4326 tolerance is converted to degrees
+	g.SRID = 4326
+	simplified, err = Simplified([]byte(hex.EncodeToString(g.EWKB())), 5, DouglasPeucker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := ewkb.DecodeHex(simplified); len(s.Rings) != 2 || len(s.Rings[0]) != 6 {
+		t.Errorf("unexpected simplified polygon %v", s.Rings)
+	}
+
+	// exterior ring is kept if it would collapse
+	simplified, err = Simplified(wkb, 1000, DouglasPeucker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := ewkb.DecodeHex(simplified); len(s.Rings) != 1 || len(s.Rings[0]) != 6 {
+		t.Errorf("unexpected simplified polygon %v", s.Rings)
+	}
+
+	// 50/1 has an area of 50, below the square of the tolerance
+	simplified, err = Simplified(wkb, 8, Visvalingam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := ewkb.DecodeHex(simplified); len(s.Rings) != 1 || len(s.Rings[0]) != 5 {
+		t.Errorf("unexpected simplified polygon %v", s.Rings)
+	}
+	simplified, err = Simplified(wkb, 7, Visvalingam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := ewkb.DecodeHex(simplified); len(s.Rings) != 1 || len(s.Rings[0]) != 6 {
+		t.Errorf("unexpected simplified polygon %v", s.Rings)
+	}
+}
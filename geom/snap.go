@@ -0,0 +1,72 @@
+package geom
+
+import (
+	"encoding/hex"
+	"math"
+
+	"github.com/omniscale/imposm3/geom/ewkb"
+)
+
+// SnappedToGrid returns the hex encoded EWKB geometry with all coordinates
+// rounded to a multiple of gridSize (in the units of the projection), like
+// ST_SnapToGrid. Consecutive coordinates that snap to the same grid point
+// are removed. Holes that collapse are removed, collapsed lines and
+// exterior rings keep all snapped coordinates. Polygons can become invalid.
+func SnappedToGrid(wkb []byte, gridSize float64) ([]byte, error) {
+	g, err := ewkb.DecodeHex(wkb)
+	if err != nil {
+		return nil, err
+	}
+	snapGeometry(g, gridSize)
+	b := g.EWKB()
+	result := make([]byte, hex.EncodedLen(len(b)))
+	hex.Encode(result, b)
+	return result, nil
+}
+
+func snapGeometry(g *ewkb.Geometry, gridSize float64) {
+	switch g.Type {
+	case ewkb.Point:
+		snapCoords(g.Coords, gridSize)
+	case ewkb.LineString:
+		snapCoords(g.Coords, gridSize)
+		if coords := uniqueCoords(g.Coords); len(coords) >= 2 {
+			g.Coords = coords
+		}
+	case ewkb.Polygon:
+		rings := g.Rings[:0]
+		for i, r := range g.Rings {
+			snapCoords(r, gridSize)
+			if unique := uniqueCoords(r); len(unique) >= 4 {
+				r = unique
+			} else if i > 0 {
+				// remove collapsed hole
+				continue
+			}
+			rings = append(rings, r)
+		}
+		g.Rings = rings
+	}
+	for i := range g.Geoms {
+		snapGeometry(&g.Geoms[i], gridSize)
+	}
+}
+
+func snapCoords(coords []ewkb.Coord, gridSize float64) {
+	for i := range coords {
+		coords[i].X = math.Round(coords[i].X/gridSize) * gridSize
+		coords[i].Y = math.Round(coords[i].Y/gridSize) * gridSize
+	}
+}
+
+// uniqueCoords returns coords without consecutive duplicates.
+func uniqueCoords(coords []ewkb.Coord) []ewkb.Coord {
+	result := make([]ewkb.Coord, 0, len(coords))
+	for i, c := range coords {
+		if i > 0 && c.X == coords[i-1].X && c.Y == coords[i-1].Y {
+			continue
+		}
+		result = append(result, c)
+	}
+	return result
+}
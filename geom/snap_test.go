@@ -0,0 +1,45 @@
+package geom
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/omniscale/imposm3/geom/ewkb"
+)
+
+func TestSnappedToGrid(t *testing.T) {
+	g := ewkb.Geometry{Type: ewkb.MultiPolygon, SRID: 3857, Geoms: []ewkb.Geometry{
+		{Type: ewkb.Polygon, Rings: [][]ewkb.Coord{
+			coords(0.2, 0.1, 10.4, 0.3, 10.45, 0.2, 9.6, 10.2, 0.2, 0.1),
+			coords(2, 2, 2.2, 2.1, 2.1, 2.3, 2, 2),
+		}},
+	}}
+	snapped, err := SnappedToGrid([]byte(hex.EncodeToString(g.EWKB())), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ewkb.DecodeHex(snapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// duplicate 10/0 and collapsed hole removed
+	rings := s.Geoms[0].Rings
+	if len(rings) != 1 || len(rings[0]) != 4 {
+		t.Fatalf("unexpected snapped polygon %v", rings)
+	}
+	for i, c := range coords(0, 0, 10, 0, 10, 10, 0, 0) {
+		if rings[0][i] != c {
+			t.Errorf("unexpected coord %d %v, expected %v", i, rings[0][i], c)
+		}
+	}
+
+	// collapsed line keeps all coords
+	g = ewkb.Geometry{Type: ewkb.LineString, SRID: 3857, Coords: coords(0.1, 0.1, 0.2, 0.2)}
+	snapped, err = SnappedToGrid([]byte(hex.EncodeToString(g.EWKB())), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := ewkb.DecodeHex(snapped); len(s.Coords) != 2 || s.Coords[0] != s.Coords[1] {
+		t.Errorf("unexpected snapped line %v", s.Coords)
+	}
+}
@@ -0,0 +1,70 @@
+package geom
+
+import (
+	"github.com/omniscale/imposm3/geom/geos"
+)
+
+// maxSubdivideDepth limits the recursion of Subdivide, for geometries that
+// can not be split (e.g. with many duplicate coordinates).
+const maxSubdivideDepth = 50
+
+// Subdivide splits the (multi)polygon into polygons with at most
+// maxVertices coordinates, like ST_Subdivide. The polygon is split at the
+// center of the longer side of its bounds, until all parts are small
+// enough. Returns geom if it is already small enough. The parts are
+// destroyed by the garbage collector.
+func Subdivide(g *geos.Geos, geom *geos.Geom, maxVertices int) []*geos.Geom {
+	return subdivide(g, geom, maxVertices, 0)
+}
+
+func subdivide(g *geos.Geos, geom *geos.Geom, maxVertices, depth int) []*geos.Geom {
+	if int(g.NumCoordinates(geom)) <= maxVertices || depth >= maxSubdivideDepth {
+		return []*geos.Geom{geom}
+	}
+	b := geom.Bounds()
+	first, second := b, b
+	if b.MaxX-b.MinX >= b.MaxY-b.MinY {
+		first.MaxX = (b.MinX + b.MaxX) / 2
+		second.MinX = first.MaxX
+	} else {
+		first.MaxY = (b.MinY + b.MaxY) / 2
+		second.MinY = first.MaxY
+	}
+
+	var parts []*geos.Geom
+	for _, bounds := range []geos.Bounds{first, second} {
+		box := g.BoundsPolygon(bounds)
+		if box == nil {
+			return []*geos.Geom{geom}
+		}
+		part := g.Intersection(geom, box)
+		g.Destroy(box)
+		if part == nil {
+			return []*geos.Geom{geom}
+		}
+		g.DestroyLater(part)
+		for _, p := range polygons(g, part) {
+			parts = append(parts, subdivide(g, p, maxVertices, depth+1)...)
+		}
+	}
+	return parts
+}
+
+// polygons returns all non-empty polygons of geom.
+func polygons(g *geos.Geos, geom *geos.Geom) []*geos.Geom {
+	if g.IsEmpty(geom) {
+		return nil
+	}
+	if g.Type(geom) == "Polygon" {
+		return []*geos.Geom{geom}
+	}
+	var result []*geos.Geom
+	for _, part := range g.Geoms(geom) {
+		if g.Type(part) == "Polygon" && !g.IsEmpty(part) {
+			p := g.Clone(part)
+			g.DestroyLater(p)
+			result = append(result, p)
+		}
+	}
+	return result
+}
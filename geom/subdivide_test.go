@@ -0,0 +1,45 @@
+package geom
+
+import (
+	"math"
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/geom/geos"
+)
+
+func TestSubdivide(t *testing.T) {
+	var nodes []osm.Node
+	for i := 0; i <= 64; i++ {
+		a := float64(i%64) / 64 * 2 * math.Pi
+		nodes = append(nodes, osm.Node{Long: 10 * math.Cos(a), Lat: 10 * math.Sin(a)})
+	}
+	g := geos.NewGeos()
+	defer g.Finish()
+	geom, err := Polygon(g, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := Subdivide(g, geom, 16)
+	if len(parts) < 4 {
+		t.Fatalf("expected at least 4 parts, got %d", len(parts))
+	}
+	area := 0.0
+	for _, p := range parts {
+		if n := g.NumCoordinates(p); n > 16 {
+			t.Errorf("part with %d coordinates", n)
+		}
+		if typ := g.Type(p); typ != "Polygon" {
+			t.Errorf("unexpected part type %s", typ)
+		}
+		area += p.Area()
+	}
+	if math.Abs(area-geom.Area()) > 1e-6 {
+		t.Errorf("area of parts %f differs from %f", area, geom.Area())
+	}
+
+	if parts := Subdivide(g, geom, 100); len(parts) != 1 || parts[0] != geom {
+		t.Errorf("expected unchanged polygon, got %d parts", len(parts))
+	}
+}
@@ -0,0 +1,222 @@
+package geom
+
+import (
+	"github.com/omniscale/imposm3/geom/ewkb"
+)
+
+// Topology is the simplification algorithm of SimplifyTopology.
+const Topology = "topology"
+
+// SimplifyTopology simplifies all lines and polygons of geoms with the
+// Douglas-Peucker algorithm, so that the borders of adjacent polygons are
+// simplified the same way. The simplified polygons do not overlap or leave
+// gaps between them if the borders share their coordinates, like polygons
+// that are built from the same OSM ways.
+//
+// Lines and rings are split into arcs at all coordinates where they join or
+// leave another line or ring. Each arc is simplified on its own with the
+// tolerance (in the units of the geometries) and both ends are kept. Arcs
+// keep at least one of their inner coordinates, so that rings do not
+// collapse. Like ST_Simplify, the simplified polygons can be invalid. The
+// geometries are modified in place.
+func SimplifyTopology(geoms []*ewkb.Geometry, tolerance float64) {
+	var lines, rings []*[]ewkb.Coord
+	for _, g := range geoms {
+		collectPaths(g, &lines, &rings)
+	}
+	for _, p := range lines {
+		*p = uniqueCoords(*p)
+	}
+	for _, p := range rings {
+		*p = uniqueCoords(*p)
+	}
+
+	junctions := findJunctions(lines, rings)
+
+	for _, p := range lines {
+		coords := *p
+		if len(coords) < 3 {
+			continue
+		}
+		*p = simplifyArcs(coords, junctions, tolerance)
+	}
+	for _, p := range rings {
+		ring := *p
+		if len(ring) < 4 || ring[0] != ring[len(ring)-1] {
+			continue
+		}
+		ring = ring[:len(ring)-1]
+		start := -1
+		for i, c := range ring {
+			if junctions[c] {
+				start = i
+				break
+			}
+		}
+		if start < 0 {
+			// rings without junctions start at their smallest coordinate,
+			// so that equal rings (e.g. an enclave and the hole of the
+			// surrounding polygon) are simplified the same way
+			start = 0
+			for i, c := range ring {
+				if lessCoord(c, ring[start]) {
+					start = i
+				}
+			}
+		}
+		rotated := make([]ewkb.Coord, 0, len(ring)+1)
+		rotated = append(rotated, ring[start:]...)
+		rotated = append(rotated, ring[:start]...)
+		rotated = append(rotated, ring[start])
+		*p = simplifyArcs(rotated, junctions, tolerance)
+	}
+}
+
+// collectPaths appends the coordinates of all lines and all rings of g.
+func collectPaths(g *ewkb.Geometry, lines, rings *[]*[]ewkb.Coord) {
+	switch g.Type {
+	case ewkb.LineString:
+		*lines = append(*lines, &g.Coords)
+	case ewkb.Polygon:
+		for i := range g.Rings {
+			*rings = append(*rings, &g.Rings[i])
+		}
+	}
+	for i := range g.Geoms {
+		collectPaths(&g.Geoms[i], lines, rings)
+	}
+}
+
+// findJunctions returns all coordinates where lines and rings join or
+// leave each other: coordinates that have different neighbours in the
+// lines and rings they are part of, and the ends of all lines.
+func findJunctions(lines, rings []*[]ewkb.Coord) map[ewkb.Coord]bool {
+	type neighbours struct {
+		a, b ewkb.Coord
+	}
+	seen := make(map[ewkb.Coord]neighbours)
+	junctions := make(map[ewkb.Coord]bool)
+	add := func(c, prev, next ewkb.Coord) {
+		if lessCoord(next, prev) {
+			prev, next = next, prev
+		}
+		n, ok := seen[c]
+		if !ok {
+			seen[c] = neighbours{prev, next}
+		} else if n.a != prev || n.b != next {
+			junctions[c] = true
+		}
+	}
+
+	for _, p := range lines {
+		coords := *p
+		if len(coords) == 0 {
+			continue
+		}
+		junctions[coords[0]] = true
+		junctions[coords[len(coords)-1]] = true
+		for i := 1; i < len(coords)-1; i++ {
+			add(coords[i], coords[i-1], coords[i+1])
+		}
+	}
+	for _, p := range rings {
+		ring := *p
+		if len(ring) < 4 {
+			continue
+		}
+		n := len(ring) - 1 // without the closing coordinate
+		for i := 0; i < n; i++ {
+			prev := ring[(i+n-1)%n]
+			add(ring[i], prev, ring[i+1])
+		}
+	}
+	return junctions
+}
+
+// simplifyArcs splits coords at the junctions (the first and last
+// coordinates are always ends of an arc) and returns the simplified arcs.
+func simplifyArcs(coords []ewkb.Coord, junctions map[ewkb.Coord]bool, tolerance float64) []ewkb.Coord {
+	result := make([]ewkb.Coord, 0, len(coords))
+	result = append(result, coords[0])
+	start := 0
+	for i := 1; i < len(coords); i++ {
+		if i < len(coords)-1 && !junctions[coords[i]] {
+			continue
+		}
+		arc := simplifyArc(coords[start:i+1], tolerance)
+		result = append(result, arc[1:]...)
+		start = i
+	}
+	return result
+}
+
+// simplifyArc returns the simplified arc. The arc is simplified in the
+// same direction, regardless of the direction in which a line or ring
+// references it.
+func simplifyArc(arc []ewkb.Coord, tolerance float64) []ewkb.Coord {
+	n := len(arc)
+	if n < 3 {
+		return arc
+	}
+	closed := arc[0] == arc[n-1]
+	reversed := false
+	if lessCoord(arc[n-1], arc[0]) || closed && lessCoord(arc[n-2], arc[1]) {
+		arc = reverseCoords(arc)
+		reversed = true
+	}
+
+	var result []ewkb.Coord
+	if closed {
+		if n < 4 {
+			return arc
+		}
+		// split closed arcs at the farthest coordinate, to keep a ring
+		far := farthestCoord(arc)
+		result = append(simplifyArcCoords(arc[:far+1], tolerance),
+			simplifyArcCoords(arc[far:], tolerance)[1:]...)
+	} else {
+		result = simplifyArcCoords(arc, tolerance)
+	}
+	if reversed {
+		result = reverseCoords(result)
+	}
+	return result
+}
+
+// simplifyArcCoords simplifies coords with Douglas-Peucker, but keeps the
+// farthest coordinate if all inner coordinates would be removed.
+func simplifyArcCoords(coords []ewkb.Coord, tolerance float64) []ewkb.Coord {
+	result := simplifyCoords(coords, tolerance)
+	if len(result) == 2 && len(coords) > 2 {
+		result = []ewkb.Coord{coords[0], coords[farthestCoord(coords)], coords[len(coords)-1]}
+	}
+	return result
+}
+
+// farthestCoord returns the index of the inner coordinate with the largest
+// distance to the segment between the first and last coordinate.
+func farthestCoord(coords []ewkb.Coord) int {
+	first, last := coords[0], coords[len(coords)-1]
+	maxDist, index := -1.0, 1
+	for i := 1; i < len(coords)-1; i++ {
+		if d := segmentDistance(coords[i].X, coords[i].Y, first, last); d > maxDist {
+			maxDist, index = d, i
+		}
+	}
+	return index
+}
+
+func lessCoord(a, b ewkb.Coord) bool {
+	if a.X != b.X {
+		return a.X < b.X
+	}
+	return a.Y < b.Y
+}
+
+func reverseCoords(coords []ewkb.Coord) []ewkb.Coord {
+	result := make([]ewkb.Coord, len(coords))
+	for i, c := range coords {
+		result[len(coords)-1-i] = c
+	}
+	return result
+}
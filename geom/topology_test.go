@@ -0,0 +1,55 @@
+package geom
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/omniscale/imposm3/geom/ewkb"
+)
+
+func TestSimplifyTopology(t *testing.T) {
+	// adjacent polygons with a shared border at x=10
+	a := &ewkb.Geometry{Type: ewkb.Polygon, Rings: [][]ewkb.Coord{
+		coords(0, 0, 10, 0, 10.1, 2, 9.9, 4, 10.2, 6, 10, 10, 0, 10, 0, 0),
+	}}
+	b := &ewkb.Geometry{Type: ewkb.Polygon, Rings: [][]ewkb.Coord{
+		coords(10, 0, 20, 0, 20, 10, 10, 10, 10.2, 6, 9.9, 4, 10.1, 2, 10, 0),
+	}}
+	SimplifyTopology([]*ewkb.Geometry{a, b}, 1)
+
+	// the shared border keeps its farthest coordinate in both polygons
+	if expected := coords(10, 0, 10.2, 6, 10, 10, 0, 10, 0, 0, 10, 0); !reflect.DeepEqual(a.Rings[0], expected) {
+		t.Errorf("unexpected ring %v, expected %v", a.Rings[0], expected)
+	}
+	if expected := coords(10, 0, 20, 0, 20, 10, 10, 10, 10.2, 6, 10, 0); !reflect.DeepEqual(b.Rings[0], expected) {
+		t.Errorf("unexpected ring %v, expected %v", b.Rings[0], expected)
+	}
+}
+
+func TestSimplifyTopologyEnclave(t *testing.T) {
+	// hole and enclave with different start and direction
+	hole := coords(5, 5, 5, 6, 5.1, 7, 5, 8, 8, 8, 8, 5, 5, 5)
+	enclave := coords(8, 8, 5, 8, 5.1, 7, 5, 6, 5, 5, 8, 5, 8, 8)
+	a := &ewkb.Geometry{Type: ewkb.Polygon, Rings: [][]ewkb.Coord{
+		coords(0, 0, 20, 0, 20, 20, 0, 20, 0, 0),
+		hole,
+	}}
+	b := &ewkb.Geometry{Type: ewkb.MultiPolygon, Geoms: []ewkb.Geometry{
+		{Type: ewkb.Polygon, Rings: [][]ewkb.Coord{enclave}},
+	}}
+	SimplifyTopology([]*ewkb.Geometry{a, b}, 1)
+
+	h, e := a.Rings[1], b.Geoms[0].Rings[0]
+	if len(h) != len(e) || len(h) < 4 {
+		t.Fatalf("unexpected rings %v %v", h, e)
+	}
+	for i := range h {
+		// same ring in the other direction
+		if h[i] != e[len(e)-1-i] {
+			t.Fatalf("hole %v differs from enclave %v", h, e)
+		}
+	}
+	if len(h) == len(hole) {
+		t.Errorf("hole not simplified %v", h)
+	}
+}
@@ -6,14 +6,34 @@ import (
 	"encoding/hex"
 
 	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/geom/ewkb"
 )
 
 const (
 	wkbSridFlag       = 0x20000000
+	wkbPointType      = 1
 	wkbLineStringType = 2
 	wkbPolygonType    = 3
 )
 
+func NodeAsEWKBHexPoint(node osm.Node, srid int) []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, uint8(1)) // little endian
+	if srid != 0 {
+		binary.Write(buf, binary.LittleEndian, uint32(wkbPointType|wkbSridFlag))
+		binary.Write(buf, binary.LittleEndian, uint32(srid))
+	} else {
+		binary.Write(buf, binary.LittleEndian, uint32(wkbPointType))
+	}
+	binary.Write(buf, binary.LittleEndian, node.Long)
+	binary.Write(buf, binary.LittleEndian, node.Lat)
+
+	src := buf.Bytes()
+	dst := make([]byte, hex.EncodedLen(len(src)))
+	hex.Encode(dst, src)
+	return dst
+}
+
 func NodesAsEWKBHexLineString(nodes []osm.Node, srid int) ([]byte, error) {
 	nodes = unduplicateNodes(nodes)
 	if len(nodes) < 2 {
@@ -63,3 +83,20 @@ func NodesAsEWKBHexPolygon(nodes []osm.Node, srid int) ([]byte, error) {
 	hex.Encode(dst, src)
 	return dst, nil
 }
+
+// OrientedPolygons returns the hex encoded EWKB geometry with the ring
+// orientation of ewkb.OrientPolygons. Returns wkb if all rings are already
+// oriented.
+func OrientedPolygons(wkb []byte, exteriorCCW bool) ([]byte, error) {
+	g, err := ewkb.DecodeHex(wkb)
+	if err != nil {
+		return nil, err
+	}
+	if !g.OrientPolygons(exteriorCCW) {
+		return wkb, nil
+	}
+	b := g.EWKB()
+	result := make([]byte, hex.EncodedLen(len(b)))
+	hex.Encode(result, b)
+	return result, nil
+}
@@ -0,0 +1,325 @@
+package import_
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/config"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/reader"
+)
+
+// maxAnalyzedValues is the number of different values that are counted for
+// each key. Keys like name have a different value for almost every
+// element, the elements with all other values are only counted for the key.
+const maxAnalyzedValues = 1000
+
+const (
+	analyzedNode = iota
+	analyzedWay
+	analyzedRelation
+)
+
+// keyStats are the statistics of a tag key.
+type keyStats struct {
+	key string
+	// elements is the number of nodes, ways and relations with the key
+	elements [3]int64
+	// matched is the number of elements where the key matched a mapping
+	matched int64
+	// used is true if the tag filter keeps the key, e.g. for columns
+	used   bool
+	values map[string]*valueStats
+}
+
+func (s *keyStats) count() int64 {
+	return s.elements[analyzedNode] + s.elements[analyzedWay] + s.elements[analyzedRelation]
+}
+
+// valueStats are the statistics of a tag value.
+type valueStats struct {
+	value  string
+	count  int64
+	tables map[string]*tableHits
+}
+
+// tableHits is the number of elements that were inserted into, or rejected
+// from a table by a mapping of the tag.
+type tableHits struct {
+	inserted int64
+	rejected int64
+}
+
+// tagAnalysis counts the keys and values of elements and the tables of
+// the mapping they match.
+type tagAnalysis struct {
+	m        *mapping.Mapping
+	filters  [3]mapping.TagFilterer
+	elements [3]int64
+	keys     map[string]*keyStats
+}
+
+func newTagAnalysis(m *mapping.Mapping) *tagAnalysis {
+	return &tagAnalysis{
+		m:       m,
+		filters: [3]mapping.TagFilterer{m.NodeTagFilter(), m.WayTagFilter(), m.RelationTagFilter()},
+		keys:    make(map[string]*keyStats),
+	}
+}
+
+// Analyze reads all elements of a file and reports the frequency of their
+// tag keys and values, the tables of the mapping they match and the
+// frequent tags without a mapping.
+func Analyze(opts config.Analyze) {
+	tagmapping, err := mapping.FromFile(opts.Base.MappingFile)
+	if err != nil {
+		log.Fatal("[error] reading mapping file: ", err)
+	}
+
+	a := newTagAnalysis(tagmapping)
+	log.Printf("[info] analyzing %s", opts.Read)
+	err = reader.ReadElements(context.Background(), opts.Read, reader.Elements{
+		Nodes: func(nodes []osm.Node) error {
+			for i := range nodes {
+				a.addNode(&nodes[i])
+			}
+			return nil
+		},
+		Ways: func(ways []osm.Way) error {
+			for i := range ways {
+				a.addWay(&ways[i])
+			}
+			return nil
+		},
+		Relations: func(rels []osm.Relation) error {
+			for i := range rels {
+				a.addRelation(&rels[i])
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		log.Fatal("[error] analyzing file: ", err)
+	}
+	writeTagAnalysis(os.Stdout, a, opts.Limit, opts.Values, opts.MinCount)
+}
+
+func (a *tagAnalysis) addNode(n *osm.Node) {
+	if len(n.Tags) == 0 {
+		return
+	}
+	tags := a.filter(analyzedNode, n.Tags)
+	filtered := *n
+	filtered.Tags = tags
+	a.add(analyzedNode, n.Tags, tags, a.m.ExplainNode(&filtered))
+}
+
+func (a *tagAnalysis) addWay(w *osm.Way) {
+	if len(w.Tags) == 0 {
+		return
+	}
+	tags := a.filter(analyzedWay, w.Tags)
+	filtered := *w
+	filtered.Tags = tags
+	a.add(analyzedWay, w.Tags, tags, a.m.ExplainWay(&filtered))
+}
+
+func (a *tagAnalysis) addRelation(r *osm.Relation) {
+	if len(r.Tags) == 0 {
+		return
+	}
+	tags := a.filter(analyzedRelation, r.Tags)
+	filtered := *r
+	filtered.Tags = tags
+	a.add(analyzedRelation, r.Tags, tags, a.m.ExplainRelation(&filtered))
+}
+
+// filter returns a copy of the tags, filtered like the tags of an import.
+func (a *tagAnalysis) filter(kind int, tags osm.Tags) osm.Tags {
+	filtered := make(osm.Tags, len(tags))
+	for k, v := range tags {
+		filtered[k] = v
+	}
+	a.filters[kind].Filter(&filtered)
+	return filtered
+}
+
+func (a *tagAnalysis) add(kind int, tags, filtered osm.Tags, explanations []mapping.Explanation) {
+	a.elements[kind]++
+	for k, v := range tags {
+		ks, ok := a.keys[k]
+		if !ok {
+			ks = &keyStats{key: k, values: make(map[string]*valueStats)}
+			a.keys[k] = ks
+		}
+		ks.elements[kind]++
+		if _, ok := filtered[k]; ok {
+			ks.used = true
+		}
+		vs, ok := ks.values[v]
+		if !ok {
+			if len(ks.values) >= maxAnalyzedValues {
+				continue
+			}
+			vs = &valueStats{value: v, tables: make(map[string]*tableHits)}
+			ks.values[v] = vs
+		}
+		vs.count++
+	}
+
+	matched := make(map[string]bool)
+	for _, e := range explanations {
+		ks := a.keys[e.Key]
+		if ks == nil {
+			continue
+		}
+		if !matched[e.Key] {
+			matched[e.Key] = true
+			ks.matched++
+		}
+		vs := ks.values[e.Value]
+		if vs == nil {
+			continue
+		}
+		table := fmt.Sprintf("%s (%s)", e.Table.Name, e.Type)
+		hits, ok := vs.tables[table]
+		if !ok {
+			hits = &tableHits{}
+			vs.tables[table] = hits
+		}
+		if e.Rejected == "" {
+			hits.inserted++
+		} else {
+			hits.rejected++
+		}
+	}
+}
+
+// status returns whether the key matched a mapping, is used by the mapping
+// (e.g. as a column or filter), or is unmapped.
+func (s *keyStats) status() string {
+	if s.matched > 0 {
+		return "mapped"
+	}
+	if s.used {
+		return "used"
+	}
+	return "unmapped"
+}
+
+// sortedValues returns the values, sorted by count and value.
+func (s *keyStats) sortedValues() []*valueStats {
+	values := make([]*valueStats, 0, len(s.values))
+	for _, vs := range s.values {
+		values = append(values, vs)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].count != values[j].count {
+			return values[i].count > values[j].count
+		}
+		return values[i].value < values[j].value
+	})
+	return values
+}
+
+// writeTagAnalysis writes the limit most frequent keys with the values of
+// the mapped keys, and all unmapped keys and values of at least minCount
+// elements. A limit or values of 0 writes all keys or values.
+func writeTagAnalysis(w io.Writer, a *tagAnalysis, limit, values int, minCount int64) {
+	fmt.Fprintf(w, "%d nodes, %d ways, %d relations with tags\n",
+		a.elements[analyzedNode], a.elements[analyzedWay], a.elements[analyzedRelation])
+
+	keys := make([]*keyStats, 0, len(a.keys))
+	for _, ks := range a.keys {
+		keys = append(keys, ks)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].count() != keys[j].count() {
+			return keys[i].count() > keys[j].count()
+		}
+		return keys[i].key < keys[j].key
+	})
+
+	for i, ks := range keys {
+		if limit > 0 && i >= limit {
+			fmt.Fprintf(w, "... %d more keys\n", len(keys)-limit)
+			break
+		}
+		fmt.Fprintf(w, "%s: %d elements (%d nodes, %d ways, %d relations), %s\n",
+			ks.key, ks.count(), ks.elements[analyzedNode], ks.elements[analyzedWay], ks.elements[analyzedRelation], ks.status())
+		if ks.matched == 0 {
+			continue
+		}
+		listed := int64(0)
+		for j, vs := range ks.sortedValues() {
+			if values > 0 && j >= values {
+				break
+			}
+			listed += vs.count
+			fmt.Fprintf(w, "\t%s: %d elements, %s\n", vs.value, vs.count, vs.tablesDesc())
+		}
+		if other := ks.count() - listed; other > 0 {
+			fmt.Fprintf(w, "\t... %d elements with other values\n", other)
+		}
+	}
+
+	type unmappedTag struct {
+		tag   string
+		count int64
+	}
+	var unmapped []unmappedTag
+	for _, ks := range keys {
+		if ks.count() < minCount {
+			break
+		}
+		switch {
+		case ks.matched > 0:
+			for _, vs := range ks.sortedValues() {
+				if vs.count < minCount {
+					break
+				}
+				if len(vs.tables) == 0 {
+					unmapped = append(unmapped, unmappedTag{ks.key + "=" + vs.value, vs.count})
+				}
+			}
+		case !ks.used:
+			unmapped = append(unmapped, unmappedTag{ks.key + "=*", ks.count()})
+		}
+	}
+	if len(unmapped) == 0 {
+		return
+	}
+	sort.SliceStable(unmapped, func(i, j int) bool { return unmapped[i].count > unmapped[j].count })
+	fmt.Fprintf(w, "unmapped tags of at least %d elements:\n", minCount)
+	for _, u := range unmapped {
+		fmt.Fprintf(w, "\t%s: %d elements\n", u.tag, u.count)
+	}
+}
+
+// tablesDesc returns the tables of the value with the number of inserted
+// and rejected elements, or unmapped.
+func (vs *valueStats) tablesDesc() string {
+	if len(vs.tables) == 0 {
+		return "unmapped"
+	}
+	tables := make([]string, 0, len(vs.tables))
+	for t := range vs.tables {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	for i, t := range tables {
+		hits := vs.tables[t]
+		if hits.rejected > 0 {
+			tables[i] = fmt.Sprintf("%s: %d inserted, %d rejected", t, hits.inserted, hits.rejected)
+		} else {
+			tables[i] = fmt.Sprintf("%s: %d inserted", t, hits.inserted)
+		}
+	}
+	return strings.Join(tables, "; ")
+}
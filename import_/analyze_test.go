@@ -0,0 +1,79 @@
+package import_
+
+import (
+	"bytes"
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/mapping"
+)
+
+func TestTagAnalysis(t *testing.T) {
+	m, err := mapping.New([]byte(`
+tags:
+  include: [access]
+tables:
+  roads:
+    type: linestring
+    mapping:
+      highway: [residential, service]
+    columns:
+      - name: name
+        key: name
+        type: string
+    filters:
+      reject:
+        access: [private]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := newTagAnalysis(m)
+	ways := []osm.Tags{
+		{"highway": "residential", "name": "Main Street"},
+		{"highway": "residential", "surface": "asphalt"},
+		{"highway": "service", "access": "private"},
+		{"highway": "proposed", "surface": "asphalt"},
+	}
+	for i, tags := range ways {
+		a.addWay(&osm.Way{Element: osm.Element{ID: int64(i + 1), Tags: tags}, Refs: []int64{1, 2}})
+	}
+	a.addNode(&osm.Node{Element: osm.Element{ID: 1, Tags: osm.Tags{"surface": "gravel"}}})
+	a.addNode(&osm.Node{Element: osm.Element{ID: 2}})
+
+	buf := &bytes.Buffer{}
+	writeTagAnalysis(buf, a, 3, 2, 2)
+	expected := `1 nodes, 4 ways, 0 relations with tags
+highway: 4 elements (0 nodes, 4 ways, 0 relations), mapped
+	residential: 2 elements, roads (linestring): 2 inserted
+	proposed: 1 elements, unmapped
+	... 1 elements with other values
+surface: 3 elements (1 nodes, 2 ways, 0 relations), unmapped
+access: 1 elements (0 nodes, 1 ways, 0 relations), used
+... 1 more keys
+unmapped tags of at least 2 elements:
+	surface=*: 3 elements
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected report:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	writeTagAnalysis(buf, a, 0, 0, 1)
+	expected = `1 nodes, 4 ways, 0 relations with tags
+highway: 4 elements (0 nodes, 4 ways, 0 relations), mapped
+	residential: 2 elements, roads (linestring): 2 inserted
+	proposed: 1 elements, unmapped
+	service: 1 elements, roads (linestring): 0 inserted, 1 rejected
+surface: 3 elements (1 nodes, 2 ways, 0 relations), unmapped
+access: 1 elements (0 nodes, 1 ways, 0 relations), used
+name: 1 elements (0 nodes, 1 ways, 0 relations), used
+unmapped tags of at least 1 elements:
+	surface=*: 3 elements
+	highway=proposed: 1 elements
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected report:\n%s", buf.String())
+	}
+}
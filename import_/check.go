@@ -0,0 +1,86 @@
+package import_
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/omniscale/imposm3/config"
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/pkg/errors"
+)
+
+// CheckGeometries reports the invalid, empty and degenerate geometries of
+// all tables. Exits with 1 if any geometry has an issue.
+func CheckGeometries(opts config.CheckGeometries) {
+	baseOpts := opts.Base
+	if baseOpts.Quiet {
+		log.SetMinLevel(log.LInfo)
+	}
+	tagmapping, err := mapping.FromFile(baseOpts.MappingFile)
+	if err != nil {
+		log.Fatal("[error] reading mapping file: ", err)
+	}
+	conf := database.Config{
+		Srid:             baseOpts.Srid,
+		ImportSchema:     baseOpts.Schemas.Import,
+		ProductionSchema: baseOpts.Schemas.Production,
+		BackupSchema:     baseOpts.Schemas.Backup,
+	}
+	db, err := database.OpenAll(conf, baseOpts.Connections, &tagmapping.Conf)
+	if err != nil {
+		log.Fatal("[error] opening database: ", err)
+	}
+	issues, err := checkGeometries(db, os.Stdout, !opts.ImportSchema, opts.Limit, opts.MinSize)
+	db.Close()
+	if err != nil {
+		log.Fatal("[error] checking geometries: ", err)
+	}
+	if issues > 0 {
+		os.Exit(1)
+	}
+}
+
+// checkGeometries writes the geometry reports of db to w and returns the
+// number of geometries with issues.
+func checkGeometries(db database.DB, w io.Writer, production bool, limit int, minSize float64) (int64, error) {
+	checker, ok := db.(database.GeometryChecker)
+	if !ok {
+		return 0, errors.New("database does not support geometry checks")
+	}
+	reports, err := checker.CheckGeometries(production, limit, minSize)
+	if err != nil {
+		return 0, err
+	}
+	return writeGeometryReports(w, reports), nil
+}
+
+// writeGeometryReports writes the number of issues of each table and the
+// reported features. Returns the number of geometries with issues.
+func writeGeometryReports(w io.Writer, reports []database.GeometryReport) int64 {
+	var total int64
+	for _, r := range reports {
+		issues := r.Invalid + r.Empty + r.Degenerate
+		total += issues
+		if issues == 0 {
+			fmt.Fprintf(w, "%s: %d rows, ok\n", r.Table, r.Rows)
+			continue
+		}
+		fmt.Fprintf(w, "%s: %d rows, %d invalid, %d empty, %d degenerate\n",
+			r.Table, r.Rows, r.Invalid, r.Empty, r.Degenerate)
+		for _, issue := range r.Issues {
+			if issue.Reason != "" {
+				fmt.Fprintf(w, "\t%d %s: %s\n", issue.ID, issue.Issue, issue.Reason)
+			} else {
+				fmt.Fprintf(w, "\t%d %s\n", issue.ID, issue.Issue)
+			}
+		}
+		if n := int64(len(r.Issues)); n < issues {
+			fmt.Fprintf(w, "\t... %d more\n", issues-n)
+		}
+	}
+	fmt.Fprintf(w, "%d geometries with issues in %d tables\n", total, len(reports))
+	return total
+}
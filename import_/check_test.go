@@ -0,0 +1,32 @@
+package import_
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/omniscale/imposm3/database"
+)
+
+func TestWriteGeometryReports(t *testing.T) {
+	buf := &bytes.Buffer{}
+	issues := writeGeometryReports(buf, []database.GeometryReport{
+		{Table: "import.osm_roads", Rows: 100},
+		{Table: "import.osm_landuse", Rows: 50, Invalid: 2, Empty: 1, Issues: []database.GeometryIssue{
+			{ID: 12, Issue: "invalid", Reason: "Self-intersection[1 2]"},
+			{ID: -34, Issue: "empty"},
+		}},
+	})
+	if issues != 3 {
+		t.Errorf("unexpected issues %d", issues)
+	}
+	expected := `import.osm_roads: 100 rows, ok
+import.osm_landuse: 50 rows, 2 invalid, 1 empty, 0 degenerate
+	12 invalid: Self-intersection[1 2]
+	-34 empty
+	... 1 more
+3 geometries with issues in 2 tables
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected report:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,238 @@
+package import_
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/reader"
+	"github.com/pkg/errors"
+)
+
+const checkpointFilename = "imposm_checkpoint.json"
+
+// Phases of an import that are recorded in the checkpoint.
+const (
+	phaseRead       = "read"
+	phaseWrite      = "write"
+	phaseGeneralize = "generalize"
+	phaseOptimize   = "optimize"
+	phaseFinish     = "finish"
+	phaseDeploy     = "deploy"
+)
+
+// checkpoint records the completed phases of an import in the cache
+// directory, so that an interrupted import can be resumed with -resume.
+// Phases are recorded after they completed. The read and write phases also
+// record their position, an interrupted read continues after the last
+// completely read blocks and an interrupted write after the last committed
+// rows. All other phases are repeated from the start.
+type checkpoint struct {
+	// Inputs identifies the PBF files that were read into the cache.
+	Inputs []checkpointInput `json:"inputs,omitempty"`
+	Phases []string          `json:"phases"`
+	Read   *readPosition     `json:"read,omitempty"`
+	Write  *writePosition    `json:"write,omitempty"`
+
+	filename string
+}
+
+// readPosition is the position of an interrupted read phase. All input
+// files before Input and all blocks of Input before Offset were read.
+type readPosition struct {
+	Input  int   `json:"input"`
+	Offset int64 `json:"offset"`
+}
+
+// The elements are written in this order.
+const (
+	writeRelations = "relations"
+	writeWays      = "ways"
+	writeNodes     = "nodes"
+)
+
+var writeOrder = []string{writeRelations, writeWays, writeNodes}
+
+// writePosition is the position of an interrupted write phase. All
+// elements of the types before Elements and all elements of Elements up to
+// ID were written. Tables contains the position of the last committed row
+// of each table.
+type writePosition struct {
+	Elements string           `json:"elements"`
+	ID       int64            `json:"id"`
+	Tables   map[string]int64 `json:"tables"`
+}
+
+// written returns whether all elements of the type were written.
+func (p *writePosition) written(elements string) bool {
+	if p == nil {
+		return false
+	}
+	for _, e := range writeOrder {
+		if e == p.Elements {
+			return false
+		}
+		if e == elements {
+			return true
+		}
+	}
+	return false
+}
+
+// lastID returns the id of the last written element of the type, or 0.
+func (p *writePosition) lastID(elements string) int64 {
+	if p == nil || p.Elements != elements {
+		return 0
+	}
+	return p.ID
+}
+
+type checkpointInput struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modtime"`
+}
+
+func statInputs(filenames []string) ([]checkpointInput, error) {
+	var inputs []checkpointInput
+	for _, filename := range filenames {
+		size, modTime, err := reader.StatInput(filename)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, checkpointInput{filename, size, modTime})
+	}
+	return inputs, nil
+}
+
+// loadCheckpoint reads the checkpoint from the cache directory. Returns an
+// empty checkpoint if the file does not exist.
+func loadCheckpoint(cacheDir string) (*checkpoint, error) {
+	cp := &checkpoint{filename: filepath.Join(cacheDir, checkpointFilename)}
+	b, err := ioutil.ReadFile(cp.filename)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading checkpoint")
+	}
+	if err := json.Unmarshal(b, cp); err != nil {
+		return nil, errors.Wrapf(err, "parsing checkpoint %s", cp.filename)
+	}
+	return cp, nil
+}
+
+// done returns whether the phase was completed.
+func (cp *checkpoint) done(phase string) bool {
+	for _, p := range cp.Phases {
+		if p == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// sameInput returns whether the checkpoint was created for the PBF files.
+// The files are compared by name, size and modification time. Data from
+// stdin is never the same.
+func (cp *checkpoint) sameInput(filenames []string) (bool, error) {
+	inputs, err := statInputs(filenames)
+	if err != nil {
+		return false, err
+	}
+	if len(inputs) != len(cp.Inputs) {
+		return false, nil
+	}
+	for i, in := range inputs {
+		other := cp.Inputs[i]
+		if in.Name == reader.Stdin || in.Name != other.Name || in.Size != other.Size || !in.ModTime.Equal(other.ModTime) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// reset removes all completed phases and sets the input files.
+func (cp *checkpoint) reset(filenames []string) error {
+	inputs, err := statInputs(filenames)
+	if err != nil {
+		return err
+	}
+	cp.Phases = nil
+	cp.Read = nil
+	cp.Write = nil
+	cp.Inputs = inputs
+	return cp.save()
+}
+
+// complete records the phase as completed.
+func (cp *checkpoint) complete(phase string) error {
+	if !cp.done(phase) {
+		cp.Phases = append(cp.Phases, phase)
+	}
+	switch phase {
+	case phaseRead:
+		cp.Read = nil
+	case phaseWrite:
+		cp.Write = nil
+	}
+	return cp.save()
+}
+
+// readTo records the position of the read phase.
+func (cp *checkpoint) readTo(input int, offset int64) error {
+	cp.Read = &readPosition{Input: input, Offset: offset}
+	return cp.save()
+}
+
+// writeTo records the position of the write phase.
+func (cp *checkpoint) writeTo(elements string, id int64, tables map[string]int64) error {
+	cp.Write = &writePosition{Elements: elements, ID: id, Tables: tables}
+	return cp.save()
+}
+
+// resumeSkipper returns a function that returns whether the phase was
+// completed by the import that is resumed. Only the reading is skipped and
+// recorded for dry runs, the cache can be used by the following import.
+// The write phase and all following phases are repeated if the database
+// can not be resumed after the write phase.
+func resumeSkipper(cp *checkpoint, resume, partial, dryRun, resumableWrite bool) func(string) bool {
+	return func(phase string) bool {
+		if partial || dryRun && phase != phaseRead {
+			return false
+		}
+		if !resumableWrite && phase != phaseRead {
+			return false
+		}
+		return resume && cp.done(phase)
+	}
+}
+
+// resumableWrite returns whether the import into db can be resumed after
+// the write phase. Imports without database are always resumable.
+func resumableWrite(db database.DB) bool {
+	if db == nil {
+		return true
+	}
+	r, ok := db.(database.Resumer)
+	return ok && r.ResumableWrite()
+}
+
+// save writes the checkpoint atomically.
+func (cp *checkpoint) save() error {
+	b, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cp.filename), 0755); err != nil {
+		return errors.Wrap(err, "creating cache directory")
+	}
+	tmp := cp.filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return errors.Wrap(err, "writing checkpoint")
+	}
+	return errors.Wrap(os.Rename(tmp, cp.filename), "writing checkpoint")
+}
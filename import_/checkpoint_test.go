@@ -0,0 +1,224 @@
+package import_
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/database"
+	_ "github.com/omniscale/imposm3/database/flatgeobuf"
+	"github.com/omniscale/imposm3/mapping/config"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imposm_checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "input.osm.pbf")
+	if err := ioutil.WriteFile(input, []byte("pbf"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cp, err := loadCheckpoint(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.done(phaseRead) {
+		t.Error("empty checkpoint with completed phase")
+	}
+	if err := cp.reset([]string{input}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.complete(phaseRead); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.complete(phaseWrite); err != nil {
+		t.Fatal(err)
+	}
+
+	cp, err = loadCheckpoint(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cp.done(phaseRead) || !cp.done(phaseWrite) || cp.done(phaseGeneralize) {
+		t.Errorf("unexpected phases %v", cp.Phases)
+	}
+	if same, err := cp.sameInput([]string{input}); err != nil || !same {
+		t.Errorf("input not the same %v %v", same, err)
+	}
+	if same, _ := cp.sameInput([]string{input, input}); same {
+		t.Error("additional input is the same")
+	}
+	if err := cp.reset([]string{"-"}); err != nil {
+		t.Fatal(err)
+	}
+	if same, _ := cp.sameInput([]string{"-"}); same {
+		t.Error("stdin is the same")
+	}
+	if err := cp.reset([]string{input}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(input, []byte("changed pbf"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if same, _ := cp.sameInput([]string{input}); same {
+		t.Error("changed input is the same")
+	}
+
+	if err := cp.reset(nil); err != nil {
+		t.Fatal(err)
+	}
+	cp, err = loadCheckpoint(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cp.Phases) != 0 || len(cp.Inputs) != 0 {
+		t.Errorf("checkpoint not reset %v", cp)
+	}
+}
+
+func TestResumeSkipper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imposm_checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cp, err := loadCheckpoint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, phase := range []string{phaseRead, phaseWrite, phaseGeneralize} {
+		if err := cp.complete(phase); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// FlatGeobuf keeps the rows in spool files till Finish
+	db, err := database.Open(database.Config{
+		ConnectionParams: "flatgeobuf:" + filepath.Join(dir, "fgb"),
+		Srid:             3857,
+	}, &config.Mapping{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if resumableWrite(db) {
+		t.Fatal("flatgeobuf is resumable after the write phase")
+	}
+
+	skip := resumeSkipper(cp, true, false, false, resumableWrite(db))
+	if !skip(phaseRead) {
+		t.Error("read not skipped")
+	}
+	if skip(phaseWrite) || skip(phaseGeneralize) || skip(phaseFinish) {
+		t.Error("phases after read skipped for flatgeobuf")
+	}
+
+	skip = resumeSkipper(cp, true, false, false, resumableWrite(nil))
+	if !skip(phaseRead) || !skip(phaseWrite) || !skip(phaseGeneralize) || skip(phaseFinish) {
+		t.Error("unexpected skipped phases")
+	}
+	skip = resumeSkipper(cp, false, false, false, true)
+	if skip(phaseRead) {
+		t.Error("read skipped without resume")
+	}
+}
+
+func TestCheckpointPositions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imposm_checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cp, err := loadCheckpoint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.readTo(1, 4096); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.writeTo(writeWays, 42, map[string]int64{"roads": 100}); err != nil {
+		t.Fatal(err)
+	}
+	cp, err = loadCheckpoint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Read == nil || *cp.Read != (readPosition{Input: 1, Offset: 4096}) {
+		t.Errorf("unexpected read position %v", cp.Read)
+	}
+	w := cp.Write
+	if w == nil || w.Elements != writeWays || w.ID != 42 || w.Tables["roads"] != 100 {
+		t.Fatalf("unexpected write position %v", w)
+	}
+	if !w.written(writeRelations) || w.written(writeWays) || w.written(writeNodes) {
+		t.Error("unexpected written elements")
+	}
+	if w.lastID(writeWays) != 42 || w.lastID(writeNodes) != 0 {
+		t.Error("unexpected last ids")
+	}
+	var none *writePosition
+	if none.written(writeRelations) || none.lastID(writeWays) != 0 {
+		t.Error("elements written without position")
+	}
+
+	if err := cp.complete(phaseRead); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.complete(phaseWrite); err != nil {
+		t.Fatal(err)
+	}
+	if cp.Read != nil || cp.Write != nil {
+		t.Error("positions of completed phases not removed")
+	}
+}
+
+func TestWaySegments(t *testing.T) {
+	ways := make(chan *osm.Way)
+	go func() {
+		for i := int64(1); i <= 7; i++ {
+			ways <- &osm.Way{Element: osm.Element{ID: i}}
+		}
+		close(ways)
+	}()
+	var ids [][]int64
+	var last []int64
+	for seg := range waySegments(ways, 2, 2) {
+		var segIDs []int64
+		for w := range seg.ways {
+			segIDs = append(segIDs, w.ID)
+		}
+		ids = append(ids, segIDs)
+		last = append(last, seg.last)
+	}
+	if !reflect.DeepEqual(ids, [][]int64{{3, 4}, {5, 6}, {7}}) || !reflect.DeepEqual(last, []int64{4, 6, 7}) {
+		t.Errorf("unexpected segments %v %v", ids, last)
+	}
+
+	nodes := make(chan *osm.Node, 3)
+	for i := int64(1); i <= 3; i++ {
+		nodes <- &osm.Node{Element: osm.Element{ID: i}}
+	}
+	close(nodes)
+	n := 0
+	for seg := range nodeSegments(nodes, 0, 0) {
+		for range seg.nodes {
+		}
+		if seg.last != 3 {
+			t.Errorf("unexpected last node %d", seg.last)
+		}
+		n++
+	}
+	if n != 1 {
+		t.Errorf("expected single segment, got %d", n)
+	}
+}
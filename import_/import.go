@@ -6,11 +6,24 @@ package import_
 import (
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	osm "github.com/omniscale/go-osm"
 	"github.com/omniscale/go-osm/state"
 	"github.com/omniscale/imposm3/cache"
 	"github.com/omniscale/imposm3/config"
 	"github.com/omniscale/imposm3/database"
+	_ "github.com/omniscale/imposm3/database/dryrun"
+	_ "github.com/omniscale/imposm3/database/duckdb"
+	_ "github.com/omniscale/imposm3/database/elasticsearch"
+	_ "github.com/omniscale/imposm3/database/flatgeobuf"
+	_ "github.com/omniscale/imposm3/database/geojsonseq"
+	_ "github.com/omniscale/imposm3/database/geoparquet"
+	_ "github.com/omniscale/imposm3/database/kafka"
+	_ "github.com/omniscale/imposm3/database/mbtiles"
+	_ "github.com/omniscale/imposm3/database/mysql"
+	_ "github.com/omniscale/imposm3/database/osmpbf"
 	_ "github.com/omniscale/imposm3/database/postgis"
 	"github.com/omniscale/imposm3/geom/limit"
 	"github.com/omniscale/imposm3/log"
@@ -19,6 +32,7 @@ import (
 	"github.com/omniscale/imposm3/stats"
 	"github.com/omniscale/imposm3/update"
 	"github.com/omniscale/imposm3/writer"
+	"github.com/pkg/errors"
 )
 
 func Import(importOpts config.Import) {
@@ -32,6 +46,31 @@ func Import(importOpts config.Import) {
 		log.Fatal("-revertdeploy not compatible with -deployproduction/-removebackup")
 	}
 
+	if importOpts.DryRun {
+		if importOpts.Diff || importOpts.Optimize || importOpts.DeployProduction || importOpts.RevertDeploy || importOpts.RemoveBackup || importOpts.TileServerConfig != "" || importOpts.CheckGeometries {
+			log.Fatal("-dry-run not compatible with -diff/-optimize/-deployproduction/-revertdeploy/-removebackup/-tileserver-config/-check-geometries")
+		}
+		// write into the dryrun database, that only counts the rows
+		importOpts.Write = true
+		baseOpts.Connections = config.Connections{"dryrun:"}
+	}
+
+	if importOpts.CheckGeometries && !importOpts.Write {
+		log.Fatal("-check-geometries requires -write")
+	}
+
+	if importOpts.LowDisk {
+		if importOpts.Read == "" || !importOpts.Write {
+			log.Fatal("-low-disk requires -read and -write")
+		}
+		if len(importOpts.ReadFiles) > 1 || importOpts.Read == reader.Stdin {
+			log.Fatal("-low-disk requires a single -read file, not stdin")
+		}
+		if importOpts.Diff || importOpts.Appendcache {
+			log.Fatal("-low-disk not compatible with -diff/-appendcache")
+		}
+	}
+
 	var geometryLimiter *limit.Limiter
 	if (importOpts.Write || importOpts.Read != "") && baseOpts.LimitTo != "" {
 		var err error
@@ -47,34 +86,130 @@ func Import(importOpts config.Import) {
 		step()
 	}
 
+	if len(importOpts.OnlyTables) > 0 {
+		// the cache would only contain the tags of these tables
+		if importOpts.Read != "" || importOpts.Diff || importOpts.Resume {
+			log.Fatal("-only-tables not compatible with -read/-diff/-resume, tables are written from the existing cache")
+		}
+		if !importOpts.Write && !importOpts.DeployProduction && !importOpts.RevertDeploy && !importOpts.RemoveBackup {
+			log.Fatal("-only-tables requires -write/-deployproduction/-revertdeploy/-removebackup")
+		}
+	}
+
 	tagmapping, err := mapping.FromFile(baseOpts.MappingFile)
 	if err != nil {
 		log.Fatal("[error] reading mapping file: ", err)
 	}
+	if len(importOpts.OnlyTables) > 0 {
+		if err := tagmapping.SelectTables(importOpts.OnlyTables); err != nil {
+			log.Fatal("[error] -only-tables: ", err)
+		}
+		log.Printf("[info] only importing %s", strings.Join(importOpts.OnlyTables, ", "))
+	}
 
 	var db database.DB
 
-	if importOpts.Write || importOpts.DeployProduction || importOpts.RevertDeploy || importOpts.RemoveBackup || importOpts.Optimize {
-		if baseOpts.Connection == "" {
+	if importOpts.Write || importOpts.DeployProduction || importOpts.RevertDeploy || importOpts.RemoveBackup || importOpts.Optimize || importOpts.TileServerConfig != "" {
+		if len(baseOpts.Connections) == 0 {
 			log.Fatal("[error] missing connection option in configuration")
 		}
 		conf := database.Config{
-			ConnectionParams: baseOpts.Connection,
-			Srid:             baseOpts.Srid,
-			ImportSchema:     baseOpts.Schemas.Import,
-			ProductionSchema: baseOpts.Schemas.Production,
-			BackupSchema:     baseOpts.Schemas.Backup,
+			Srid:              baseOpts.Srid,
+			ImportSchema:      baseOpts.Schemas.Import,
+			ProductionSchema:  baseOpts.Schemas.Production,
+			BackupSchema:      baseOpts.Schemas.Backup,
+			BackupGenerations: baseOpts.Schemas.BackupGenerations,
+			Unlogged:          importOpts.Unlogged || importOpts.KeepUnlogged,
+			KeepUnlogged:      importOpts.KeepUnlogged,
+			IndexJobs:         importOpts.IndexJobs,
+			Grants:            baseOpts.Grants,
+			PartialImport:     len(importOpts.OnlyTables) > 0,
+			ClipLimitTo:       baseOpts.LimitTo != "",
 		}
-		db, err = database.Open(conf, &tagmapping.Conf)
+		db, err = database.OpenAll(conf, baseOpts.Connections, &tagmapping.Conf)
 		if err != nil {
 			log.Fatal("[error] opening database: ", err)
 		}
 		defer db.Close()
 	}
 
+	if len(importOpts.ReadFiles) > 1 {
+		for _, filename := range importOpts.ReadFiles {
+			if filename == reader.Stdin {
+				log.Fatal("-read - can't be combined with other -read files")
+			}
+		}
+	}
+	// Read files from the oldest to the newest extract, so that the newest
+	// version of elements that are in multiple files is cached.
+	readFiles, err := sortByTimestamp(importOpts.ReadFiles)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cp, err := loadCheckpoint(baseOpts.CacheDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the checkpoint of the last full import is kept for imports of single
+	// tables
+	partial := len(importOpts.OnlyTables) > 0
+	if !importOpts.Resume && !partial {
+		err = cp.reset(readFiles)
+	} else if importOpts.Read != "" {
+		if same, _ := cp.sameInput(readFiles); !same {
+			log.Printf("[info] checkpoint is not for %s, resuming from the start", strings.Join(readFiles, ", "))
+			err = cp.reset(readFiles)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	resumable := resumableWrite(db)
+	if importOpts.Resume && importOpts.Write && !resumable && cp.done(phaseWrite) {
+		log.Printf("[info] output keeps the data till the import is finished, writing again")
+	}
+	skip := resumeSkipper(cp, importOpts.Resume, partial, importOpts.DryRun, resumable)
+	complete := func(phase string) {
+		if partial || importOpts.DryRun && phase != phaseRead {
+			return
+		}
+		if err := cp.complete(phase); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	intr := handleInterrupt()
+	defer intr.stop()
+	// exitInterrupted exits after the import was stopped before the phase
+	// was completed. The checkpoint contains all phases that were completed
+	// before.
+	exitInterrupted := func(phase string) {
+		if db != nil {
+			db.Close()
+		}
+		log.Printf("[warn] import stopped before completing the %s phase, continue with -resume", phase)
+		os.Exit(ExitInterrupted)
+	}
+	checkInterrupt := func(phase string) {
+		if intr.stopped() {
+			exitInterrupted(phase)
+		}
+	}
+
 	osmCache := cache.NewOSMCache(baseOpts.CacheDir)
 
-	if importOpts.Read != "" && osmCache.Exists() {
+	readCompleted := importOpts.Read != "" && skip(phaseRead)
+	if readCompleted {
+		log.Printf("[info] skipping reading of %s, completed before", strings.Join(readFiles, ", "))
+	}
+	// position of an interrupted read, the existing cache is kept
+	var readFrom *readPosition
+	if importOpts.Read != "" && !readCompleted && importOpts.Resume && !partial && cp.Read != nil && osmCache.Exists() {
+		readFrom = cp.Read
+	}
+
+	if importOpts.Read != "" && !readCompleted && readFrom == nil && osmCache.Exists() {
 		if importOpts.Overwritecache {
 			log.Printf("[info] removing existing cache %s", baseOpts.CacheDir)
 			err := osmCache.Remove()
@@ -86,198 +221,438 @@ func Import(importOpts config.Import) {
 		}
 	}
 
+	// filters of the read phase, also for the ways of -low-disk imports,
+	// which are read again for the write phase
+	readLimiter := geometryLimiter
+	if baseOpts.LimitToCacheBuffer == 0.0 {
+		readLimiter = nil
+	}
+
+	var bbox *reader.BBox
+	if importOpts.BBox != "" {
+		bbox, err = reader.ParseBBox(importOpts.BBox)
+		if err != nil {
+			log.Fatal("[fatal] ", err)
+		}
+	}
+
 	step := log.Step("Imposm")
+	report := stats.NewReport()
 
 	var elementCounts *stats.ElementCounts
 
-	if importOpts.Read != "" {
+	if importOpts.Read != "" && !readCompleted {
 		step := log.Step("Reading OSM data")
+		readFinished := report.Phase("read")
 		err = osmCache.Open()
 		if err != nil {
 			log.Fatal("[error] opening cache files: ", err)
 		}
-		progress := stats.NewStatsReporter()
-
-		if !importOpts.Appendcache {
-			// enable optimization if we don't append to existing cache
-			osmCache.Coords.SetLinearImport(true)
+		if len(readFiles) > 1 || importOpts.Appendcache {
+			// compare the versions of elements in multiple files
+			if err := osmCache.OpenVersions(); err != nil {
+				log.Fatal("[error] opening versions cache: ", err)
+			}
 		}
+		progress := stats.NewStatsReporter()
 
-		readLimiter := geometryLimiter
-		if baseOpts.LimitToCacheBuffer == 0.0 {
-			readLimiter = nil
-		}
+		// timestamp of the oldest file
+		var timestamp time.Time
+		for i, filename := range readFiles {
+			var offset int64
+			if readFrom != nil {
+				if i < readFrom.Input {
+					log.Printf("[info] skipping reading of %s, completed before", filename)
+					continue
+				}
+				if i == readFrom.Input && readFrom.Offset > 0 {
+					offset = readFrom.Offset
+					log.Printf("[info] continue reading %s at offset %d", filename, offset)
+				}
+			}
+			// Enable optimization if we don't append to existing cache.
+			// Elements of the following files replace elements with the
+			// same ID, this is only supported without the optimization.
+			// The cache of a continued file already contains the elements
+			// of the last blocks.
+			osmCache.Coords.SetLinearImport(i == 0 && !importOpts.Appendcache && offset == 0)
+			if len(readFiles) > 1 {
+				log.Printf("[info] Reading %s (%d of %d)", filename, i+1, len(readFiles))
+			}
+			var pos *reader.ReadPosition
+			if !partial {
+				input := i
+				pos = &reader.ReadPosition{
+					Offset: offset,
+					Read: func(offset int64) error {
+						return cp.readTo(input, offset)
+					},
+				}
+			}
 
-		err := reader.ReadPbf(importOpts.Read,
-			osmCache,
-			progress,
-			tagmapping,
-			readLimiter,
-		)
-		if err != nil {
-			log.Fatal(err)
+			t, err := reader.ReadPbf(intr.ctx, filename,
+				osmCache,
+				progress,
+				tagmapping,
+				readLimiter,
+				bbox,
+				importOpts.LowDisk,
+				importOpts.Diff,
+				pos,
+			)
+			if err != nil {
+				if intr.stopped() {
+					// the cache is continued after the last completely
+					// read blocks, it needs to be closed to keep the
+					// cached elements
+					osmCache.Close()
+					exitInterrupted(phaseRead)
+				}
+				log.Fatal(err)
+			}
+			if i == 0 {
+				timestamp = t
+			}
+			if !partial {
+				if err := cp.readTo(i+1, 0); err != nil {
+					log.Fatal(err)
+				}
+			}
 		}
 
 		osmCache.Coords.SetLinearImport(false)
 		elementCounts = progress.Stop()
 		osmCache.Close()
+		readFinished(elementCounts)
 		step()
 		if importOpts.Diff {
-			diffstate, err := estimateFromPBF(importOpts.Read, baseOpts.DiffStateBefore, baseOpts.ReplicationURL, baseOpts.ReplicationInterval)
+			// the oldest file, diffs need to include all changes after the
+			// oldest extract
+			var diffstate *state.DiffState
+			var err error
+			if !timestamp.IsZero() {
+				diffstate, err = estimateFromTimestamp(timestamp, baseOpts.DiffStateBefore, baseOpts.ReplicationURL, baseOpts.ReplicationInterval)
+			} else if readFiles[0] != reader.Stdin {
+				diffstate, err = estimateFromPBF(readFiles[0], baseOpts.DiffStateBefore, baseOpts.ReplicationURL, baseOpts.ReplicationInterval)
+			} else {
+				err = errors.New("no replication timestamp in PBF from stdin")
+			}
 			if err != nil {
 				log.Println("[error] parsing diff state form PBF", err)
 			} else if diffstate != nil {
-				os.MkdirAll(baseOpts.DiffDir, 0755)
-				err := state.WriteFile(filepath.Join(baseOpts.DiffDir, update.LastStateFilename), diffstate)
-				if err != nil {
-					log.Println("[error] writing last.state.txt: ", err)
+				inFile := baseOpts.DiffStateInFile()
+				if baseOpts.DiffStateInDatabase() {
+					if store, ok := database.StateStoreOf(db); ok {
+						if err := store.WriteState(diffstate, database.DiffStats{}); err != nil {
+							log.Println("[error] writing diff state into database: ", err)
+						}
+					} else {
+						log.Println("[warn] unable to store diff state in the database (requires -write and PostGIS), writing last.state.txt")
+						inFile = true
+					}
+				}
+				if inFile {
+					os.MkdirAll(baseOpts.DiffDir, 0755)
+					err := state.WriteFile(filepath.Join(baseOpts.DiffDir, update.LastStateFilename), diffstate)
+					if err != nil {
+						log.Println("[error] writing last.state.txt: ", err)
+					}
 				}
 			}
 		}
+		complete(phaseRead)
 	}
 
 	if importOpts.Write {
 		importFinished := log.Step("Importing OSM data")
-		writeFinished := log.Step("Writing OSM data")
-		progress := stats.NewStatsReporterWithEstimate(elementCounts)
-
-		err = db.Init()
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		bulkDb, ok := db.(database.BulkBeginner)
-		if ok {
-			err = bulkDb.BeginBulk()
+		if skip(phaseWrite) {
+			log.Printf("[info] skipping writing, completed before")
 		} else {
-			err = db.Begin()
-		}
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		var diffCache *cache.DiffCache
-		if importOpts.Diff {
-			diffCache = cache.NewDiffCache(baseOpts.CacheDir)
-			if err = diffCache.Remove(); err != nil {
+			checkInterrupt(phaseWrite)
+			writeFinished := log.Step("Writing OSM data")
+			writeReported := report.Phase("write")
+			progress := stats.NewStatsReporterWithEstimate(elementCounts)
+
+			// the rows are committed after each segment of elements, if
+			// the database can continue after the committed rows
+			writeResumer, _ := db.(database.WriteResumer)
+			if partial || importOpts.DryRun {
+				writeResumer = nil
+			}
+			segmentSize := 0
+			if writeResumer != nil {
+				segmentSize = writeSegmentSize
+			}
+			var writeFrom *writePosition
+			if importOpts.Resume && writeResumer != nil && cp.Write != nil {
+				writeFrom = cp.Write
+				log.Printf("[info] continue writing %s after id %d", writeFrom.Elements, writeFrom.ID)
+				err = writeResumer.ResumeWrite(writeFrom.Tables)
+			} else {
+				err = db.Init()
+				if err != nil {
+					log.Fatal(err)
+				}
+				bulkDb, ok := db.(database.BulkBeginner)
+				if ok {
+					err = bulkDb.BeginBulk()
+				} else {
+					err = db.Begin()
+				}
+			}
+			if err != nil {
 				log.Fatal(err)
 			}
-			if err = diffCache.Open(); err != nil {
+			// commitWrite commits all rows and records that all elements
+			// before id of the element type were written
+			commitWrite := func(elements string, id int64) {
+				if writeResumer == nil {
+					return
+				}
+				tables, err := writeResumer.CommitWrite()
+				if err != nil {
+					log.Fatal(err)
+				}
+				if tables == nil {
+					// rows of some tables can't be positioned
+					return
+				}
+				if err := cp.writeTo(elements, id, tables); err != nil {
+					log.Fatal(err)
+				}
+			}
+
+			var diffCache *cache.DiffCache
+			if importOpts.Diff {
+				diffCache = cache.NewDiffCache(baseOpts.CacheDir)
+				// the cache of a continued import contains the elements
+				// that were written before
+				if writeFrom == nil {
+					if err = diffCache.Remove(); err != nil {
+						log.Fatal(err)
+					}
+				}
+				if err = diffCache.Open(); err != nil {
+					log.Fatal(err)
+				}
+			}
+
+			err = osmCache.Open()
+			if err != nil {
 				log.Fatal(err)
 			}
-		}
+			if diffCache != nil {
+				diffCache.Coords.SetLinearImport(true)
+				diffCache.Ways.SetLinearImport(true)
+			}
+			osmCache.Coords.SetReadOnly(true)
+
+			// abortWrite rolls back all inserts after the import was
+			// stopped. The import is resumed after the last committed rows,
+			// or the tables are created again.
+			abortWrite := func() {
+				progress.Stop()
+				osmCache.Close()
+				if diffCache != nil {
+					diffCache.Close()
+				}
+				if err := db.Abort(); err != nil {
+					log.Println("[error] aborting import: ", err)
+				}
+				exitInterrupted(phaseWrite)
+			}
 
-		err = osmCache.Open()
-		if err != nil {
-			log.Fatal(err)
-		}
-		if diffCache != nil {
-			diffCache.Coords.SetLinearImport(true)
-			diffCache.Ways.SetLinearImport(true)
-		}
-		osmCache.Coords.SetReadOnly(true)
+			if !writeFrom.written(writeRelations) {
+				relations := writer.HeavyRelationsFirst(intr.ctx, osmCache.Relations)
+				relWriter := writer.NewRelationWriter(osmCache, diffCache,
+					tagmapping.Conf.SingleIDSpace,
+					relations,
+					db, progress,
+					tagmapping.PolygonMatcher,
+					tagmapping.RelationMatcher,
+					tagmapping.RelationMemberMatcher,
+					baseOpts.Srid,
+				)
+				relWriter.SetLimiter(geometryLimiter)
+				relWriter.EnableConcurrent()
+				relWriter.Start()
+				relWriter.Wait() // blocks till the Relations.Iter() finishes
+			}
+			osmCache.Relations.Close()
+			if intr.stopped() {
+				abortWrite()
+			}
+			if !writeFrom.written(writeRelations) {
+				commitWrite(writeWays, 0)
+			}
 
-		relations := osmCache.Relations.Iter()
-		relWriter := writer.NewRelationWriter(osmCache, diffCache,
-			tagmapping.Conf.SingleIDSpace,
-			relations,
-			db, progress,
-			tagmapping.PolygonMatcher,
-			tagmapping.RelationMatcher,
-			tagmapping.RelationMemberMatcher,
-			baseOpts.Srid,
-		)
-		relWriter.SetLimiter(geometryLimiter)
-		relWriter.EnableConcurrent()
-		relWriter.Start()
-		relWriter.Wait() // blocks till the Relations.Iter() finishes
-		osmCache.Relations.Close()
-
-		ways := osmCache.Ways.Iter()
-		wayWriter := writer.NewWayWriter(osmCache, diffCache,
-			tagmapping.Conf.SingleIDSpace,
-			ways, db,
-			progress,
-			tagmapping.PolygonMatcher,
-			tagmapping.LineStringMatcher,
-			baseOpts.Srid,
-		)
-		wayWriter.SetLimiter(geometryLimiter)
-		wayWriter.EnableConcurrent()
-		wayWriter.Start()
-		wayWriter.Wait() // blocks till the Ways.Iter() finishes
-		osmCache.Ways.Close()
-
-		nodes := osmCache.Nodes.Iter()
-		nodeWriter := writer.NewNodeWriter(osmCache, nodes, db,
-			progress,
-			tagmapping.PointMatcher,
-			baseOpts.Srid,
-		)
-		nodeWriter.SetLimiter(geometryLimiter)
-		nodeWriter.EnableConcurrent()
-		nodeWriter.Start()
-		nodeWriter.Wait() // blocks till the Nodes.Iter() finishes
-		osmCache.Close()
+			if !writeFrom.written(writeWays) {
+				var ways chan *osm.Way
+				waitWays := func() error { return nil }
+				if importOpts.LowDisk {
+					// only ways of relations are cached
+					ways, waitWays = reader.StreamWays(intr.ctx, readFiles[0], tagmapping, osmCache, readLimiter, bbox)
+				} else {
+					ways = osmCache.Ways.IterContext(intr.ctx)
+				}
+				for seg := range waySegments(ways, writeFrom.lastID(writeWays), segmentSize) {
+					wayWriter := writer.NewWayWriter(osmCache, diffCache,
+						tagmapping.Conf.SingleIDSpace,
+						seg.ways, db,
+						progress,
+						tagmapping.PolygonMatcher,
+						tagmapping.LineStringMatcher,
+						baseOpts.Srid,
+					)
+					wayWriter.SetLimiter(geometryLimiter)
+					wayWriter.EnableConcurrent()
+					wayWriter.Start()
+					wayWriter.Wait() // blocks till all ways of the segment are written
+					if intr.stopped() {
+						break
+					}
+					commitWrite(writeWays, seg.last)
+				}
+				if err := waitWays(); err != nil && !intr.stopped() {
+					log.Fatal(err)
+				}
+			}
+			osmCache.Ways.Close()
+			if intr.stopped() {
+				abortWrite()
+			}
+			if !writeFrom.written(writeWays) {
+				commitWrite(writeNodes, 0)
+			}
 
-		err = db.End()
-		if err != nil {
-			log.Fatal(err)
-		}
+			nodes := osmCache.Nodes.IterContext(intr.ctx)
+			for seg := range nodeSegments(nodes, writeFrom.lastID(writeNodes), segmentSize) {
+				nodeWriter := writer.NewNodeWriter(osmCache, seg.nodes, db,
+					progress,
+					tagmapping.PointMatcher,
+					baseOpts.Srid,
+				)
+				nodeWriter.SetLimiter(geometryLimiter)
+				nodeWriter.EnableConcurrent()
+				nodeWriter.Start()
+				nodeWriter.Wait() // blocks till all nodes of the segment are written
+				if intr.stopped() {
+					break
+				}
+				commitWrite(writeNodes, seg.last)
+			}
+			if intr.stopped() {
+				abortWrite()
+			}
+			osmCache.Close()
 
-		progress.Stop()
+			err = db.End()
+			if err != nil {
+				log.Fatal(err)
+			}
 
-		if importOpts.Diff {
-			diffCache.Close()
-		}
+			writeReported(progress.Stop())
 
-		writeFinished()
+			if importOpts.Diff {
+				diffCache.Close()
+				// diff imports check for incompatible mapping changes
+				if err := mapping.WriteFingerprint(baseOpts.CacheDir, tagmapping.Fingerprint()); err != nil {
+					log.Fatal("[error] writing mapping fingerprint: ", err)
+				}
+			} else if len(importOpts.OnlyTables) > 0 {
+				// keep the fingerprint of later diff imports in sync with
+				// the re-imported tables
+				if err := updateFingerprint(baseOpts.CacheDir, tagmapping); err != nil {
+					log.Fatal("[error] updating mapping fingerprint: ", err)
+				}
+			}
 
-		if db, ok := db.(database.Generalizer); ok {
-			if err := db.Generalize(); err != nil {
-				log.Fatal(err)
+			writeFinished()
+			complete(phaseWrite)
+		}
+
+		if !skip(phaseGeneralize) {
+			checkInterrupt(phaseGeneralize)
+			if db, ok := db.(database.Generalizer); ok {
+				finished := report.Phase("generalize")
+				if err := db.Generalize(); err != nil {
+					log.Fatal(err)
+				}
+				finished(nil)
+			} else {
+				log.Fatal("database not generalizeable")
 			}
-		} else {
-			log.Fatal("database not generalizeable")
+			complete(phaseGeneralize)
 		}
 
 		// Optimize before creating indices.
-		if importOpts.Optimize {
+		if importOpts.Optimize && !skip(phaseOptimize) {
+			checkInterrupt(phaseOptimize)
 			if db, ok := db.(database.Optimizer); ok {
+				finished := report.Phase("optimize")
 				if err := db.Optimize(); err != nil {
 					log.Fatal(err)
 				}
+				finished(nil)
 			} else {
 				log.Fatal("database not optimizable")
 			}
+			complete(phaseOptimize)
 		}
 
 		// Create indices in finisher.
-		if db, ok := db.(database.Finisher); ok {
-			if err := db.Finish(); err != nil {
-				log.Fatal(err)
+		if !skip(phaseFinish) {
+			checkInterrupt(phaseFinish)
+			if db, ok := db.(database.Finisher); ok {
+				finished := report.Phase("index")
+				if err := db.Finish(); err != nil {
+					log.Fatal(err)
+				}
+				finished(nil)
+			} else {
+				log.Fatal("database not finishable")
 			}
-		} else {
-			log.Fatal("database not finishable")
+			complete(phaseFinish)
 		}
 		importFinished()
 	}
 
-	if importOpts.Optimize && !importOpts.Write { // Optimize already called in Write.
+	if importOpts.Optimize && !importOpts.Write && !skip(phaseOptimize) { // Optimize already called in Write.
+		checkInterrupt(phaseOptimize)
 		if db, ok := db.(database.Optimizer); ok {
+			finished := report.Phase("optimize")
 			if err := db.Optimize(); err != nil {
 				log.Fatal(err)
 			}
+			finished(nil)
 		} else {
 			log.Fatal("database not optimizable")
 		}
+		complete(phaseOptimize)
+	}
+
+	if importOpts.CheckGeometries {
+		// before the deploy, the tables are in the import schema
+		issues, err := checkGeometries(db, os.Stdout, false, 10, 0.01)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if issues > 0 {
+			log.Printf("[warn] %d geometries with issues", issues)
+		}
 	}
 
 	if importOpts.DeployProduction {
-		if db, ok := db.(database.Deployer); ok {
+		if skip(phaseDeploy) {
+			log.Printf("[info] skipping deploy, completed before")
+		} else if db, ok := db.(database.Deployer); ok {
+			checkInterrupt(phaseDeploy)
+			finished := report.Phase("deploy")
 			if err := db.Deploy(); err != nil {
 				log.Fatal(err)
 			}
+			finished(nil)
+			complete(phaseDeploy)
 		} else {
 			log.Fatal("database not deployable")
 		}
@@ -303,6 +678,43 @@ func Import(importOpts config.Import) {
 		}
 	}
 
+	if importOpts.TileServerConfig != "" {
+		if db, ok := db.(database.TileServerConfigWriter); ok {
+			// tables are in the import schema after -write without deploy
+			production := importOpts.DeployProduction || !importOpts.Write
+			if err := db.WriteTileServerConfig(importOpts.TileServerConfig, production); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			log.Fatal("database does not support tile server configs")
+		}
+	}
+
 	step()
 
+	report.Print()
+	if importOpts.Report != "" {
+		if err := report.WriteJSON(importOpts.Report); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// updateFingerprint replaces the mapping fingerprint of the tables of the
+// partial tagmapping (-only-tables). Caches without fingerprint (imports
+// without -diff) remain without fingerprint.
+func updateFingerprint(cacheDir string, tagmapping *mapping.Mapping) error {
+	stored, err := mapping.ReadFingerprint(cacheDir)
+	if err != nil || stored == nil {
+		return err
+	}
+	var names []string
+	for name := range tagmapping.Conf.Tables {
+		names = append(names, name)
+	}
+	for name := range tagmapping.Conf.GeneralizedTables {
+		names = append(names, name)
+	}
+	stored.ReplaceTables(tagmapping.Fingerprint(), names)
+	return mapping.WriteFingerprint(cacheDir, stored)
 }
@@ -0,0 +1,57 @@
+package import_
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/omniscale/imposm3/log"
+)
+
+// ExitInterrupted is the exit code of an import that was stopped with
+// SIGTERM or SIGINT. The import can be continued with -resume.
+const ExitInterrupted = 3
+
+// interrupt stops an import gracefully on SIGTERM or SIGINT. The context is
+// cancelled with the first signal: the reader and the writers finish their
+// current batch and the import exits with ExitInterrupted after the caches
+// are closed. A second signal exits immediately.
+type interrupt struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	sigc   chan os.Signal
+}
+
+func handleInterrupt() *interrupt {
+	ctx, cancel := context.WithCancel(context.Background())
+	i := &interrupt{ctx: ctx, cancel: cancel, sigc: make(chan os.Signal, 2)}
+	signal.Notify(i.sigc, syscall.SIGTERM, syscall.SIGINT)
+	go i.wait()
+	return i
+}
+
+func (i *interrupt) wait() {
+	sig, ok := <-i.sigc
+	if !ok {
+		return
+	}
+	log.Printf("[warn] received %s, stopping after the current batch (repeat to exit immediately)", sig)
+	i.cancel()
+	if _, ok := <-i.sigc; ok {
+		log.Println("[warn] exiting immediately, cache and database are incomplete")
+		os.Exit(ExitInterrupted)
+	}
+}
+
+// stopped returns whether the import should stop.
+func (i *interrupt) stopped() bool {
+	return i.ctx.Err() != nil
+}
+
+// stop restores the default signal handling.
+func (i *interrupt) stop() {
+	signal.Stop(i.sigc)
+	close(i.sigc)
+	i.cancel()
+}
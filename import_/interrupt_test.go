@@ -0,0 +1,27 @@
+package import_
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInterrupt(t *testing.T) {
+	intr := handleInterrupt()
+	defer intr.stop()
+	if intr.stopped() {
+		t.Fatal("stopped before signal")
+	}
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-intr.ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not cancelled after SIGTERM")
+	}
+	if !intr.stopped() {
+		t.Error("not stopped after SIGTERM")
+	}
+}
@@ -0,0 +1,234 @@
+package import_
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/cache"
+	"github.com/omniscale/imposm3/config"
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/element"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+)
+
+// queriedElement is an element from the cache with the explanations of
+// the mapping.
+type queriedElement struct {
+	kind         string
+	id           int64
+	tags         osm.Tags
+	issues       []string
+	explanations []mapping.Explanation
+	// dbID is the id of the element in the tables (see element.RelIDOffset)
+	dbID int64
+}
+
+// Query shows the tags of a node, way or relation from the cache, the
+// tables of the mapping it matches (or the reason why it is not inserted)
+// and the rows of these tables in the database, if a connection is
+// configured.
+func Query(opts config.Query) {
+	baseOpts := opts.Base
+	tagmapping, err := mapping.FromFile(baseOpts.MappingFile)
+	if err != nil {
+		log.Fatal("[error] reading mapping file: ", err)
+	}
+
+	osmCache := cache.NewOSMCache(baseOpts.CacheDir)
+	if err := osmCache.Open(); err != nil {
+		log.Fatal("[error] opening cache files: ", err)
+	}
+	elem, err := queryElement(osmCache, tagmapping, opts)
+	osmCache.Close()
+	if err != nil {
+		log.Fatal("[error] querying cache: ", err)
+	}
+	writeQueriedElement(os.Stdout, elem)
+
+	if len(baseOpts.Connections) == 0 || elem.tags == nil {
+		return
+	}
+	conf := database.Config{
+		Srid:             baseOpts.Srid,
+		ImportSchema:     baseOpts.Schemas.Import,
+		ProductionSchema: baseOpts.Schemas.Production,
+		BackupSchema:     baseOpts.Schemas.Backup,
+	}
+	db, err := database.OpenAll(conf, baseOpts.Connections, &tagmapping.Conf)
+	if err != nil {
+		log.Fatal("[error] opening database: ", err)
+	}
+	defer db.Close()
+	finder, ok := db.(database.RowFinder)
+	if !ok {
+		log.Fatal("[error] database does not support queries of rows")
+	}
+	rows, err := finder.FindRows(queryTables(tagmapping, elem.explanations), elem.dbID, !opts.ImportSchema)
+	if err != nil {
+		log.Fatal("[error] querying database: ", err)
+	}
+	writeTableRows(os.Stdout, elem.dbID, rows)
+}
+
+// queryElement reads the element from the cache and explains the mapping.
+// The tags are nil if the element is not in the cache.
+func queryElement(osmCache *cache.OSMCache, m *mapping.Mapping, opts config.Query) (*queriedElement, error) {
+	singleIDSpace := m.Conf.SingleIDSpace
+	switch {
+	case opts.Node != 0:
+		elem := &queriedElement{kind: "node", id: opts.Node, dbID: opts.Node}
+		n, err := osmCache.Nodes.GetNode(opts.Node)
+		if err == cache.NotFound {
+			if _, err := osmCache.Coords.GetCoord(opts.Node); err == nil {
+				elem.tags = osm.Tags{}
+				elem.issues = append(elem.issues, "node has no tags")
+				return elem, nil
+			} else if err != cache.NotFound {
+				return nil, err
+			}
+			return elem, nil
+		} else if err != nil {
+			return nil, err
+		}
+		elem.tags = n.Tags
+		elem.explanations = m.ExplainNode(n)
+		return elem, nil
+	case opts.Way != 0:
+		elem := &queriedElement{kind: "way", id: opts.Way, dbID: opts.Way}
+		if singleIDSpace {
+			elem.dbID = -opts.Way
+		}
+		w, err := osmCache.Ways.GetWay(opts.Way)
+		if err == cache.NotFound {
+			return elem, nil
+		} else if err != nil {
+			return nil, err
+		}
+		elem.tags = w.Tags
+		if err := osmCache.Coords.FillWay(w); err == cache.NotFound {
+			var missing []string
+			for _, ref := range w.Refs {
+				if _, err := osmCache.Coords.GetCoord(ref); err == cache.NotFound {
+					missing = append(missing, fmt.Sprint(ref))
+				}
+			}
+			elem.issues = append(elem.issues, "missing nodes: "+strings.Join(missing, ", "))
+		} else if err != nil {
+			return nil, err
+		}
+		if len(w.Refs) < 2 {
+			elem.issues = append(elem.issues, "way has less than two nodes")
+		}
+		elem.explanations = m.ExplainWay(w)
+		return elem, nil
+	default:
+		elem := &queriedElement{kind: "relation", id: opts.Relation, dbID: -opts.Relation}
+		if singleIDSpace {
+			elem.dbID = element.RelIDOffset - opts.Relation
+		}
+		r, err := osmCache.Relations.GetRelation(opts.Relation)
+		if err == cache.NotFound {
+			return elem, nil
+		} else if err != nil {
+			return nil, err
+		}
+		elem.tags = r.Tags
+		var missing []string
+		for _, member := range r.Members {
+			if member.Type != osm.WayMember {
+				continue
+			}
+			if _, err := osmCache.Ways.GetWay(member.ID); err == cache.NotFound {
+				missing = append(missing, fmt.Sprint(member.ID))
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		if len(missing) > 0 {
+			elem.issues = append(elem.issues, "missing member ways: "+strings.Join(missing, ", "))
+		}
+		elem.explanations = m.ExplainRelation(r)
+		return elem, nil
+	}
+}
+
+// queryTables returns the names of all tables with a mapping that matches
+// the element, even if a filter rejected the element, and of all
+// generalized tables of these tables.
+func queryTables(m *mapping.Mapping, explanations []mapping.Explanation) []string {
+	tables := make(map[string]bool)
+	for _, e := range explanations {
+		tables[e.Table.Name] = true
+	}
+	// generalized tables can be based on other generalized tables
+	for added := true; added; {
+		added = false
+		for name, t := range m.Conf.GeneralizedTables {
+			if !tables[name] && tables[t.SourceTableName] {
+				tables[name] = true
+				added = true
+			}
+		}
+	}
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeQueriedElement writes the tags, issues and explanations of the
+// element.
+func writeQueriedElement(w io.Writer, elem *queriedElement) {
+	if elem.tags == nil {
+		fmt.Fprintf(w, "%s %d: not found in cache\n", elem.kind, elem.id)
+		return
+	}
+	fmt.Fprintf(w, "%s %d\n", elem.kind, elem.id)
+	keys := make([]string, 0, len(elem.tags))
+	for k := range elem.tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "\t%s=%s\n", k, elem.tags[k])
+	}
+	for _, issue := range elem.issues {
+		fmt.Fprintf(w, "warning: %s\n", issue)
+	}
+	if len(elem.explanations) == 0 {
+		fmt.Fprintln(w, "no table matches the tags")
+		return
+	}
+	for _, e := range elem.explanations {
+		if e.Rejected != "" {
+			fmt.Fprintf(w, "%s (%s): %s=%s, not inserted: %s\n", e.Table.Name, e.Type, e.Key, e.Value, e.Rejected)
+		} else {
+			fmt.Fprintf(w, "%s (%s): %s=%s, inserted\n", e.Table.Name, e.Type, e.Key, e.Value)
+		}
+	}
+}
+
+// writeTableRows writes the rows with the id of all tables.
+func writeTableRows(w io.Writer, id int64, tables []database.TableRows) {
+	if len(tables) == 0 {
+		fmt.Fprintln(w, "no tables to query in database")
+		return
+	}
+	for _, t := range tables {
+		if len(t.Rows) == 0 {
+			fmt.Fprintf(w, "%s: no rows with id %d\n", t.Table, id)
+			continue
+		}
+		fmt.Fprintf(w, "%s:\n", t.Table)
+		for _, row := range t.Rows {
+			fmt.Fprintf(w, "\t%s\n", row)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package import_
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/mapping"
+)
+
+func TestQueryElement(t *testing.T) {
+	m, err := mapping.New([]byte(`
+tables:
+  roads:
+    type: linestring
+    mapping:
+      highway: [__any__]
+    filters:
+      reject:
+        access: [private]
+  landuse:
+    type: polygon
+    mapping:
+      landuse: [__any__]
+generalized_tables:
+  roads_gen1:
+    source: roads
+    tolerance: 50
+  roads_gen0:
+    source: roads_gen1
+    tolerance: 200
+  landuse_gen0:
+    source: landuse
+    tolerance: 200
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := osm.Way{Element: osm.Element{Tags: osm.Tags{"highway": "service", "access": "private"}}, Refs: []int64{1, 2}}
+	elem := &queriedElement{kind: "way", id: 4242, tags: w.Tags, explanations: m.ExplainWay(&w)}
+
+	tables := queryTables(m, elem.explanations)
+	if !reflect.DeepEqual(tables, []string{"roads", "roads_gen0", "roads_gen1"}) {
+		t.Errorf("unexpected tables %v", tables)
+	}
+
+	buf := &bytes.Buffer{}
+	writeQueriedElement(buf, elem)
+	expected := `way 4242
+	access=private
+	highway=service
+roads (linestring): highway=service, not inserted: reject: access=[private]
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	writeQueriedElement(buf, &queriedElement{kind: "relation", id: 12})
+	if buf.String() != "relation 12: not found in cache\n" {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestWriteTableRows(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writeTableRows(buf, 4242, []database.TableRows{
+		{Table: "public.osm_roads", Rows: []string{`{"osm_id":4242,"type":"service","geometry":"LINESTRING"}`}},
+		{Table: "public.osm_roads_gen1"},
+	})
+	expected := `public.osm_roads:
+	{"osm_id":4242,"type":"service","geometry":"LINESTRING"}
+public.osm_roads_gen1: no rows with id 4242
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,89 @@
+package import_
+
+import (
+	osm "github.com/omniscale/go-osm"
+)
+
+// writeSegmentSize is the number of ways or nodes that are written before
+// the rows are committed, if the write phase can be resumed.
+var writeSegmentSize = 10000000
+
+// waySegment contains the ways that are written before the rows are
+// committed.
+type waySegment struct {
+	ways chan *osm.Way
+	// last is the id of the last way, set before ways is closed
+	last int64
+}
+
+// waySegments skips all ways up to the id after and sends the following
+// ways in segments of n ways, or in a single segment if n is 0. The ways
+// need to be sorted by id.
+func waySegments(ways <-chan *osm.Way, after int64, n int) chan *waySegment {
+	segments := make(chan *waySegment)
+	go func() {
+		defer close(segments)
+		var seg *waySegment
+		count := 0
+		for w := range ways {
+			if w.ID <= after {
+				continue
+			}
+			if seg == nil {
+				seg = &waySegment{ways: make(chan *osm.Way, 1024)}
+				segments <- seg
+			}
+			seg.ways <- w
+			seg.last = w.ID
+			count++
+			if n > 0 && count == n {
+				close(seg.ways)
+				seg, count = nil, 0
+			}
+		}
+		if seg != nil {
+			close(seg.ways)
+		}
+	}()
+	return segments
+}
+
+// nodeSegment contains the nodes that are written before the rows are
+// committed.
+type nodeSegment struct {
+	nodes chan *osm.Node
+	// last is the id of the last node, set before nodes is closed
+	last int64
+}
+
+// nodeSegments skips all nodes up to the id after and sends the following
+// nodes in segments of n nodes, or in a single segment if n is 0. The
+// nodes need to be sorted by id.
+func nodeSegments(nodes <-chan *osm.Node, after int64, n int) chan *nodeSegment {
+	segments := make(chan *nodeSegment)
+	go func() {
+		defer close(segments)
+		var seg *nodeSegment
+		count := 0
+		for nd := range nodes {
+			if nd.ID <= after {
+				continue
+			}
+			if seg == nil {
+				seg = &nodeSegment{nodes: make(chan *osm.Node, 1024)}
+				segments <- seg
+			}
+			seg.nodes <- nd
+			seg.last = nd.ID
+			count++
+			if n > 0 && count == n {
+				close(seg.nodes)
+				seg, count = nil, 0
+			}
+		}
+		if seg != nil {
+			close(seg.nodes)
+		}
+	}()
+	return segments
+}
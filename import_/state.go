@@ -4,35 +4,62 @@ import (
 	"fmt"
 	"math"
 	"net/http"
-	"os"
+	"sort"
 	"time"
 
-	"github.com/omniscale/go-osm/parser/pbf"
 	"github.com/omniscale/go-osm/state"
+	"github.com/omniscale/imposm3/reader"
 	"github.com/pkg/errors"
 )
 
-func estimateFromPBF(filename string, before time.Duration, replicationURL string, replicationInterval time.Duration) (*state.DiffState, error) {
-	f, err := os.Open(filename)
+// pbfTimestamp returns the replication timestamp from the header of the
+// PBF or XML file, or the modification time of the file if the header has
+// no timestamp.
+func pbfTimestamp(filename string) (time.Time, error) {
+	t, err := reader.ReadTimestamp(filename)
+	if err == nil && !t.IsZero() {
+		return t, nil
+	}
+	_, modTime, err := reader.StatInput(filename)
 	if err != nil {
-		return nil, errors.Wrap(err, "opening PBF file")
+		return time.Time{}, errors.Wrapf(err, "reading mod time from %q", filename)
 	}
-	defer f.Close()
-
-	pbfparser := pbf.New(f, pbf.Config{})
-	header, err := pbfparser.Header()
+	return modTime, nil
+}
 
-	var timestamp time.Time
-	if err == nil && header.Time.Unix() > 0 {
-		timestamp = header.Time
-	} else {
-		fstat, err := os.Stat(filename)
+// sortByTimestamp returns the PBF files sorted by their timestamp, from
+// the oldest to the newest file. Single outdated elements of newer files
+// are skipped with the versions cache, see reader.keepNewerNodes.
+func sortByTimestamp(filenames []string) ([]string, error) {
+	if len(filenames) <= 1 {
+		return filenames, nil
+	}
+	timestamps := make(map[string]time.Time, len(filenames))
+	for _, filename := range filenames {
+		t, err := pbfTimestamp(filename)
 		if err != nil {
-			return nil, errors.Wrapf(err, "reading mod time from %q", filename)
+			return nil, err
 		}
-		timestamp = fstat.ModTime()
+		timestamps[filename] = t
+	}
+	sorted := append([]string(nil), filenames...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return timestamps[sorted[i]].Before(timestamps[sorted[j]])
+	})
+	return sorted, nil
+}
+
+func estimateFromPBF(filename string, before time.Duration, replicationURL string, replicationInterval time.Duration) (*state.DiffState, error) {
+	timestamp, err := pbfTimestamp(filename)
+	if err != nil {
+		return nil, err
 	}
+	return estimateFromTimestamp(timestamp, before, replicationURL, replicationInterval)
+}
 
+// estimateFromTimestamp returns the diff state for data with the
+// replication timestamp.
+func estimateFromTimestamp(timestamp time.Time, before time.Duration, replicationURL string, replicationInterval time.Duration) (*state.DiffState, error) {
 	if replicationURL == "" {
 		replicationURL = "https://planet.openstreetmap.org/replication/minute/"
 	}
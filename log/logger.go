@@ -7,6 +7,7 @@ import (
 	"log"
 	"math"
 	"os"
+	"sync"
 	"time"
 )
 
@@ -123,10 +124,57 @@ func Fatalf(format string, v ...interface{}) {
 	DefaultLogger.Fatalf(format, v...)
 }
 
+var steps struct {
+	sync.Mutex
+	names    []string
+	observer func(name string, d time.Duration)
+}
+
+// ObserveSteps calls f with the name and duration of each finished step.
+func ObserveSteps(f func(name string, d time.Duration)) {
+	steps.Lock()
+	steps.observer = f
+	steps.Unlock()
+}
+
 func Step(name string) func() {
 	start := time.Now()
 	Println("[step] Starting:", name)
+	steps.Lock()
+	steps.names = append(steps.names, name)
+	steps.Unlock()
 	return func() {
-		Printf("[step] Finished: %s in %s", name, time.Since(start))
+		steps.Lock()
+		for i := len(steps.names) - 1; i >= 0; i-- {
+			if steps.names[i] == name {
+				steps.names = append(steps.names[:i], steps.names[i+1:]...)
+				break
+			}
+		}
+		observer := steps.observer
+		steps.Unlock()
+		d := time.Since(start)
+		if observer != nil {
+			observer(name, d)
+		}
+		Printf("[step] Finished: %s in %s", name, d)
 	}
 }
+
+// CurrentStep returns the name of the last started step that is not
+// finished. Returns an empty string if no step is running.
+func CurrentStep() string {
+	steps.Lock()
+	defer steps.Unlock()
+	if len(steps.names) == 0 {
+		return ""
+	}
+	return steps.names[len(steps.names)-1]
+}
+
+// RunningSteps returns the names of all steps that are not finished.
+func RunningSteps() []string {
+	steps.Lock()
+	defer steps.Unlock()
+	return append([]string(nil), steps.names...)
+}
@@ -30,6 +30,8 @@ func init() {
 		"member_role":          {"member_role", "string", nil, nil, RelationMemberRole, true},
 		"member_type":          {"member_type", "int8", nil, nil, RelationMemberType, true},
 		"member_index":         {"member_index", "int32", nil, nil, RelationMemberIndex, true},
+		"member_route_index":   {"member_route_index", "int32", RouteIndex, nil, nil, false},
+		"member_route_offset":  {"member_route_offset", "float32", RouteOffset, nil, nil, false},
 		"geometry":             {"geometry", "geometry", Geometry, nil, nil, false},
 		"validated_geometry":   {"validated_geometry", "validated_geometry", Geometry, nil, nil, false},
 		"hstore_tags":          {"hstore_tags", "hstore_string", nil, MakeHStoreString, nil, false},
@@ -44,6 +46,10 @@ func init() {
 		"categorize_int":             {Name: "categorize_int", GoType: "int32", MakeFunc: MakeCategorizeInt},
 		"geojson_intersects":         {Name: "geojson_intersects", GoType: "bool", MakeFunc: MakeIntersectsField},
 		"geojson_intersects_feature": {Name: "geojson_intersects_feature", GoType: "string", MakeFunc: MakeIntersectsFeatureField},
+		"limitto_property":           {Name: "limitto_property", GoType: "string", MakeFunc: MakeLimitToProperty},
+		"member_point":               {Name: "member_point", GoType: "point_geometry", MakeFunc: MakeMemberPoint},
+		"convex_hull":                {Name: "convex_hull", GoType: "polygon_geometry", Func: ConvexHull},
+		"minimum_rotated_rectangle":  {Name: "minimum_rotated_rectangle", GoType: "polygon_geometry", Func: MinimumRotatedRectangle},
 	}
 }
 
@@ -123,6 +129,24 @@ func RelationMemberIndex(rel *osm.Relation, member *osm.Member, match Match) int
 	return -1
 }
 
+// RouteIndex returns the position of a relation member along the route,
+// see geom.RoutePositions.
+func RouteIndex(val string, elem *osm.Element, g *geom.Geometry, match Match) interface{} {
+	if g.Route == nil {
+		return nil
+	}
+	return g.Route.Index
+}
+
+// RouteOffset returns the distance in meters from the start of the route
+// to the start of a relation member.
+func RouteOffset(val string, elem *osm.Element, g *geom.Geometry, match Match) interface{} {
+	if g.Route == nil {
+		return nil
+	}
+	return float32(g.Route.Offset)
+}
+
 func Direction(val string, elem *osm.Element, geom *geom.Geometry, match Match) interface{} {
 	if val == "1" || val == "yes" || val == "true" {
 		return 1
@@ -137,6 +161,131 @@ func Geometry(val string, elem *osm.Element, geom *geom.Geometry, match Match) i
 	return string(geom.Wkb)
 }
 
+// GeometryZ returns the geometry with Z coordinates for tables with
+// elevation. Geometries without elevation get a Z coordinate of 0.
+func GeometryZ(val string, elem *osm.Element, g *geom.Geometry, match Match) interface{} {
+	if g.WkbZ != nil {
+		return string(g.WkbZ)
+	}
+	if len(g.Wkb) == 0 {
+		return string(g.Wkb)
+	}
+	wkb, err := geom.WithElevation(g.Wkb, func(x, y float64) float64 { return 0 })
+	if err != nil {
+		log.Println("[warn]: ", err)
+		return nil
+	}
+	return string(wkb)
+}
+
+// OrientedGeometry returns the geometry of makeValue with oriented polygon
+// rings, see geom.OrientedPolygons.
+func OrientedGeometry(makeValue MakeValue, exteriorCCW bool) MakeValue {
+	return func(val string, elem *osm.Element, g *geom.Geometry, match Match) interface{} {
+		v := makeValue(val, elem, g, match)
+		wkb, ok := v.(string)
+		if !ok || wkb == "" {
+			return v
+		}
+		oriented, err := geom.OrientedPolygons([]byte(wkb), exteriorCCW)
+		if err != nil {
+			log.Println("[warn]: ", err)
+			return v
+		}
+		return string(oriented)
+	}
+}
+
+// WithoutSmallHolesGeometry returns the geometry of makeValue without holes
+// below minArea in square meters, see geom.WithoutSmallHoles.
+func WithoutSmallHolesGeometry(makeValue MakeValue, minArea float64) MakeValue {
+	return func(val string, elem *osm.Element, g *geom.Geometry, match Match) interface{} {
+		v := makeValue(val, elem, g, match)
+		wkb, ok := v.(string)
+		if !ok || wkb == "" {
+			return v
+		}
+		result, err := geom.WithoutSmallHoles([]byte(wkb), minArea)
+		if err != nil {
+			log.Println("[warn]: ", err)
+			return v
+		}
+		return string(result)
+	}
+}
+
+// SimplifiedGeometry returns the geometry of makeValue simplified with the
+// tolerance in meters and algorithm, see geom.Simplified.
+func SimplifiedGeometry(makeValue MakeValue, tolerance float64, algorithm string) MakeValue {
+	return func(val string, elem *osm.Element, g *geom.Geometry, match Match) interface{} {
+		v := makeValue(val, elem, g, match)
+		wkb, ok := v.(string)
+		if !ok || wkb == "" {
+			return v
+		}
+		simplified, err := geom.Simplified([]byte(wkb), tolerance, algorithm)
+		if err != nil {
+			log.Println("[warn]: ", err)
+			return v
+		}
+		return string(simplified)
+	}
+}
+
+// SnappedGeometry returns the geometry of makeValue with all coordinates
+// snapped to a grid of gridSize, see geom.SnappedToGrid.
+func SnappedGeometry(makeValue MakeValue, gridSize float64) MakeValue {
+	return func(val string, elem *osm.Element, g *geom.Geometry, match Match) interface{} {
+		v := makeValue(val, elem, g, match)
+		wkb, ok := v.(string)
+		if !ok || wkb == "" {
+			return v
+		}
+		snapped, err := geom.SnappedToGrid([]byte(wkb), gridSize)
+		if err != nil {
+			log.Println("[warn]: ", err)
+			return v
+		}
+		return string(snapped)
+	}
+}
+
+// AntimeridianGeometry returns the geometry of makeValue split or shifted
+// at the antimeridian, see geom.Antimeridian.
+func AntimeridianGeometry(makeValue MakeValue, mode string) MakeValue {
+	return func(val string, elem *osm.Element, g *geom.Geometry, match Match) interface{} {
+		v := makeValue(val, elem, g, match)
+		wkb, ok := v.(string)
+		if !ok || wkb == "" {
+			return v
+		}
+		result, err := geom.Antimeridian([]byte(wkb), mode)
+		if err != nil {
+			log.Println("[warn]: ", err)
+			return v
+		}
+		return string(result)
+	}
+}
+
+// LabelPointGeometry returns the label point of the polygon of makeValue,
+// see geom.LabelPoint.
+func LabelPointGeometry(makeValue MakeValue) MakeValue {
+	return func(val string, elem *osm.Element, g *geom.Geometry, match Match) interface{} {
+		v := makeValue(val, elem, g, match)
+		wkb, ok := v.(string)
+		if !ok || wkb == "" {
+			return v
+		}
+		point, err := geom.LabelPoint([]byte(wkb))
+		if err != nil {
+			log.Println("[warn]: ", err)
+			return nil
+		}
+		return string(point)
+	}
+}
+
 func MakePseudoArea(columnName string, columnType ColumnType, column config.Column) (MakeValue, error) {
 	log.Println("[warn] pseudoarea type is deprecated and will be removed. See area and webmerc_area type.")
 	return Area, nil
@@ -173,6 +322,39 @@ func WebmercArea(val string, elem *osm.Element, geom *geom.Geometry, match Match
 	return float32(area)
 }
 
+// ConvexHull returns the convex hull of the geometry, see geom.ConvexHull.
+func ConvexHull(val string, elem *osm.Element, g *geom.Geometry, match Match) interface{} {
+	if g == nil || len(g.Wkb) == 0 {
+		return nil
+	}
+	wkb, err := geom.ConvexHull(g.Wkb)
+	if err != nil {
+		log.Println("[warn]: ", err)
+		return nil
+	}
+	if wkb == nil {
+		return nil
+	}
+	return string(wkb)
+}
+
+// MinimumRotatedRectangle returns the rotated rectangle with the smallest
+// area that contains the geometry, see geom.MinimumRotatedRectangle.
+func MinimumRotatedRectangle(val string, elem *osm.Element, g *geom.Geometry, match Match) interface{} {
+	if g == nil || len(g.Wkb) == 0 {
+		return nil
+	}
+	wkb, err := geom.MinimumRotatedRectangle(g.Wkb)
+	if err != nil {
+		log.Println("[warn]: ", err)
+		return nil
+	}
+	if wkb == nil {
+		return nil
+	}
+	return string(wkb)
+}
+
 var hstoreReplacer = strings.NewReplacer("\\", "\\\\", "\"", "\\\"")
 
 func MakeHStoreString(columnName string, columnType ColumnType, column config.Column) (MakeValue, error) {
@@ -418,3 +600,53 @@ func MakeSuffixReplace(columnName string, columnType ColumnType, column config.C
 
 	return suffixReplace, nil
 }
+
+// MakeLimitToProperty returns the property of the -limitto polygon of the
+// geometry, e.g. to find out which of multiple areas matched.
+func MakeLimitToProperty(columnName string, columnType ColumnType, column config.Column) (MakeValue, error) {
+	_property, ok := column.Args["property"]
+	if !ok {
+		return nil, errors.New("missing property in args for limitto_property")
+	}
+	property, ok := _property.(string)
+	if !ok {
+		return nil, errors.New("property in args for limitto_property not a string")
+	}
+
+	limitToProperty := func(val string, elem *osm.Element, geom *geom.Geometry, match Match) interface{} {
+		if geom == nil {
+			return nil
+		}
+		if v, ok := geom.LimitTo[property]; ok {
+			return v
+		}
+		return nil
+	}
+
+	return limitToProperty, nil
+}
+
+// MakeMemberPoint returns the point of the node member with the role of
+// the column args, e.g. the admin_centre of a boundary relation.
+func MakeMemberPoint(columnName string, columnType ColumnType, column config.Column) (MakeValue, error) {
+	_role, ok := column.Args["role"]
+	if !ok {
+		return nil, errors.New("missing role in args for member_point")
+	}
+	role, ok := _role.(string)
+	if !ok || role == "" {
+		return nil, errors.New("role in args for member_point not a string")
+	}
+
+	memberPoint := func(val string, elem *osm.Element, geom *geom.Geometry, match Match) interface{} {
+		if geom == nil {
+			return nil
+		}
+		if wkb, ok := geom.MemberPoints[role]; ok {
+			return string(wkb)
+		}
+		return nil
+	}
+
+	return memberPoint, nil
+}
@@ -1,10 +1,12 @@
 package mapping
 
 import (
+	"encoding/hex"
 	"testing"
 
 	osm "github.com/omniscale/go-osm"
 	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/geom/ewkb"
 	"github.com/omniscale/imposm3/geom/geos"
 	"github.com/omniscale/imposm3/mapping/config"
 )
@@ -345,3 +347,122 @@ func TestHstoreString(t *testing.T) {
 	}
 
 }
+
+func TestGeometryZ(t *testing.T) {
+	match := Match{}
+	g := &geom.Geometry{Wkb: []byte("0101000020E6100000000000000000F03F0000000000000040")}
+	if v := GeometryZ("", nil, g, match); v != "01010000a0e6100000000000000000f03f00000000000000400000000000000000" {
+		t.Errorf("unexpected geometry %v", v)
+	}
+	g.WkbZ = []byte("01010000A0E6100000000000000000F03F00000000000000400000000000000840")
+	if v := GeometryZ("", nil, g, match); v != string(g.WkbZ) {
+		t.Errorf("unexpected geometry %v", v)
+	}
+
+	rb, err := makeRowBuilder(&config.Table{
+		Columns:   []*config.Column{{Name: "geometry", Type: "geometry"}},
+		Elevation: true,
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row := rb.MakeRow(&osm.Element{}, g, match); row[0] != string(g.WkbZ) {
+		t.Errorf("unexpected row %v", row)
+	}
+}
+
+func TestOrientedGeometry(t *testing.T) {
+	cw := ewkb.Geometry{Type: ewkb.Polygon, SRID: 4326, Rings: [][]ewkb.Coord{
+		{{X: 0, Y: 0}, {X: 0, Y: 10}, {X: 10, Y: 10}, {X: 10, Y: 0}, {X: 0, Y: 0}},
+	}}
+	ccw := ewkb.Geometry{Type: ewkb.Polygon, SRID: 4326, Rings: [][]ewkb.Coord{
+		{{X: 0, Y: 0}, {X: 10, Y: 0}, {X: 10, Y: 10}, {X: 0, Y: 10}, {X: 0, Y: 0}},
+	}}
+	cwHex := hex.EncodeToString(cw.EWKB())
+	ccwHex := hex.EncodeToString(ccw.EWKB())
+
+	rb, err := makeRowBuilder(&config.Table{
+		Type:    "polygon",
+		Columns: []*config.Column{{Name: "geometry", Type: "geometry"}},
+	}, "rfc7946")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row := rb.MakeRow(&osm.Element{}, &geom.Geometry{Wkb: []byte(cwHex)}, Match{}); row[0] != ccwHex {
+		t.Errorf("unexpected row %v", row)
+	}
+	if row := rb.MakeRow(&osm.Element{}, &geom.Geometry{Wkb: []byte(ccwHex)}, Match{}); row[0] != ccwHex {
+		t.Errorf("unexpected row %v", row)
+	}
+
+	rb, err = makeRowBuilder(&config.Table{
+		Type:    "polygon",
+		Columns: []*config.Column{{Name: "geometry", Type: "geometry"}},
+	}, "postgis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row := rb.MakeRow(&osm.Element{}, &geom.Geometry{Wkb: []byte(ccwHex)}, Match{}); row[0] != cwHex {
+		t.Errorf("unexpected row %v", row)
+	}
+}
+
+func TestLabelPointGeometry(t *testing.T) {
+	poly := ewkb.Geometry{Type: ewkb.Polygon, SRID: 3857, Rings: [][]ewkb.Coord{
+		{{X: 0, Y: 0}, {X: 10, Y: 0}, {X: 10, Y: 10}, {X: 0, Y: 10}, {X: 0, Y: 0}},
+	}}
+	point := ewkb.Geometry{Type: ewkb.Point, SRID: 3857, Coords: []ewkb.Coord{{X: 5, Y: 5}}}
+
+	rb, err := makeRowBuilder(&config.Table{
+		Type:       "polygon",
+		LabelPoint: true,
+		Columns:    []*config.Column{{Name: "geometry", Type: "geometry"}},
+	}, "rfc7946")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := rb.MakeRow(&osm.Element{}, &geom.Geometry{Wkb: []byte(hex.EncodeToString(poly.EWKB()))}, Match{})
+	if row[0] != hex.EncodeToString(point.EWKB()) {
+		t.Errorf("unexpected row %v", row)
+	}
+}
+
+func TestMemberPoint(t *testing.T) {
+	if _, err := MakeMemberPoint("admin_centre", ColumnType{}, config.Column{}); err == nil {
+		t.Error("expected error for missing role")
+	}
+	memberPoint, err := MakeMemberPoint("admin_centre", ColumnType{}, config.Column{Args: map[string]interface{}{"role": "admin_centre"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &geom.Geometry{MemberPoints: map[string][]byte{"admin_centre": []byte("0101000000")}}
+	if v := memberPoint("", nil, g, Match{}); v != "0101000000" {
+		t.Errorf("unexpected value %v", v)
+	}
+	if v := memberPoint("", nil, &geom.Geometry{}, Match{}); v != nil {
+		t.Errorf("unexpected value %v", v)
+	}
+}
+
+func TestLimitToProperty(t *testing.T) {
+	if _, err := MakeLimitToProperty("area", ColumnType{}, config.Column{Name: "area", Type: "limitto_property"}); err == nil {
+		t.Error("expected error for missing property")
+	}
+	column, err := MakeLimitToProperty("area", ColumnType{}, config.Column{
+		Name: "area",
+		Type: "limitto_property",
+		Args: map[string]interface{}{"property": "name"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := column("", nil, &geom.Geometry{LimitTo: map[string]string{"name": "north"}}, Match{}); v != "north" {
+		t.Errorf("unexpected value %v", v)
+	}
+	if v := column("", nil, &geom.Geometry{LimitTo: map[string]string{"id": "1"}}, Match{}); v != nil {
+		t.Errorf("unexpected value %v", v)
+	}
+	if v := column("", nil, &geom.Geometry{}, Match{}); v != nil {
+		t.Errorf("unexpected value %v", v)
+	}
+}
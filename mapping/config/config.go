@@ -9,25 +9,32 @@ import (
 type Mapping struct {
 	Tables            Tables            `yaml:"tables"`
 	GeneralizedTables GeneralizedTables `yaml:"generalized_tables"`
+	LandPolygons      LandPolygonTables `yaml:"land_polygons"`
 	Tags              Tags              `yaml:"tags"`
 	Areas             Areas             `yaml:"areas"`
 	// SingleIDSpace mangles the overlapping node/way/relation IDs
 	// to be unique (nodes positive, ways negative, relations negative -1e17)
 	SingleIDSpace bool `yaml:"use_single_id_space"`
+	// PolygonOrientation orients the rings of all polygons: rfc7946
+	// (counter-clockwise exterior rings) or postgis (clockwise exterior
+	// rings, like ST_ForceRHR). Rings are not oriented if empty.
+	PolygonOrientation string `yaml:"polygon_orientation"`
 }
 
 type Column struct {
-	Name       string                 `yaml:"name"`
-	Key        Key                    `yaml:"key"`
-	Keys       []Key                  `yaml:"keys"`
-	Type       string                 `yaml:"type"`
-	Args       map[string]interface{} `yaml:"args"`
-	FromMember bool                   `yaml:"from_member"`
+	Name        string                 `yaml:"name"`
+	Key         Key                    `yaml:"key"`
+	Keys        []Key                  `yaml:"keys"`
+	Type        string                 `yaml:"type"`
+	Args        map[string]interface{} `yaml:"args"`
+	FromMember  bool                   `yaml:"from_member"`
+	Description string                 `yaml:"description"`
 }
 
 type Tables map[string]*Table
 type Table struct {
 	Name          string
+	Description   string                `yaml:"description"`
 	Type          string                `yaml:"type"`
 	Mapping       KeyValues             `yaml:"mapping"`
 	Mappings      map[string]SubMapping `yaml:"mappings"`
@@ -36,14 +43,138 @@ type Table struct {
 	OldFields     []*Column             `yaml:"fields"`
 	Filters       *Filters              `yaml:"filters"`
 	RelationTypes []string              `yaml:"relation_types"`
+	Tiles         *TableTiles           `yaml:"tiles"`
+	PartitionBy   *TablePartition       `yaml:"partition_by"`
+	// Tablespace and IndexTablespace are the PostGIS tablespaces for the
+	// table and for its indices.
+	Tablespace      string `yaml:"tablespace"`
+	IndexTablespace string `yaml:"index_tablespace"`
+	// GeometryIndex is the method of the geometry index in PostGIS (gist,
+	// spgist or brin).
+	GeometryIndex string       `yaml:"geometry_index"`
+	Indexes       []TableIndex `yaml:"indexes"`
+	// Cluster orders the PostGIS table after the import, either on the
+	// geometry index (geometry) or on the GeoHash of each geometry (geohash).
+	Cluster string `yaml:"cluster"`
+	// Elevation stores 3D geometries with the elevation (ele tag) of the
+	// nodes as Z coordinate.
+	Elevation bool `yaml:"elevation"`
+	// LimitTo is how geometries are limited to the -limitto polygons:
+	// clip (default) clips geometries at the polygons, centroid keeps whole
+	// geometries with a centroid inside of the polygons.
+	LimitTo string `yaml:"limitto"`
+	// Geography stores the geometries as PostGIS geography (in EPSG:4326)
+	// instead of geometry.
+	Geography bool `yaml:"geography"`
+	// Schemas overwrite the PostGIS schemas of this table.
+	Schemas    *TableSchemas `yaml:"schemas"`
+	TableHooks `yaml:",inline"`
+	// Static tables are only filled by the import and are not updated by
+	// diff imports.
+	Static bool `yaml:"static"`
+	// Validity is how invalid polygons are handled: repair (default)
+	// inserts repaired polygons, strict rejects them.
+	Validity string `yaml:"validity"`
+	// LabelPoint stores the pole of inaccessibility of each polygon as
+	// point, instead of the polygon.
+	LabelPoint bool `yaml:"label_point"`
+	// MergeLines stores the way members of relation tables as merged
+	// linestrings.
+	MergeLines bool `yaml:"merge_lines"`
+	// SimplifyTolerance simplifies all geometries of the table before they
+	// are inserted (in meters).
+	SimplifyTolerance float64 `yaml:"simplify_tolerance"`
+	// SimplifyAlgorithm is the algorithm for SimplifyTolerance:
+	// douglas-peucker (default) or visvalingam.
+	SimplifyAlgorithm string `yaml:"simplify_algorithm"`
+	// SnapToGrid rounds all coordinates of the geometries to a grid of this
+	// size (in the units of the projection).
+	SnapToGrid float64 `yaml:"snap_to_grid"`
+	// Antimeridian changes lines and polygons that cross the 180°
+	// meridian: split (into parts on both sides) or shift (to continue
+	// beyond 180°).
+	Antimeridian string `yaml:"antimeridian"`
+	// Subdivide splits polygons with more vertices into multiple rows with
+	// at most this number of vertices.
+	Subdivide int `yaml:"subdivide"`
+	// MinHoleArea removes all holes of polygons below this area (in square
+	// meters).
+	MinHoleArea float64 `yaml:"min_hole_area"`
+}
+
+// TableSchemas are the import, production and backup schemas of a table.
+type TableSchemas struct {
+	Import     string `yaml:"import"`
+	Production string `yaml:"production"`
+	Backup     string `yaml:"backup"`
+}
+
+// TableIndex is an additional index that PostGIS creates after the import.
+type TableIndex struct {
+	Columns []string `yaml:"columns"`
+	Method  string   `yaml:"method"`
+}
+
+// TableHooks are SQL statements that PostGIS executes before and after
+// creating the table and after the import (after all indices are created).
+type TableHooks struct {
+	BeforeCreate string `yaml:"before_create"`
+	AfterCreate  string `yaml:"after_create"`
+	AfterImport  string `yaml:"after_import"`
+}
+
+// TableTiles configures the zoom levels of a table for vector tile outputs.
+type TableTiles struct {
+	MinZoom *int `yaml:"min_zoom"`
+	MaxZoom *int `yaml:"max_zoom"`
+}
+
+// TablePartition configures the declarative partitioning of a table in
+// PostGIS. Method is either hash (on Column) or grid (hash on the grid cell
+// of the geometry).
+type TablePartition struct {
+	Method     string  `yaml:"method"`
+	Column     string  `yaml:"column"`
+	Partitions int     `yaml:"partitions"`
+	GridSize   float64 `yaml:"grid_size"`
 }
 
 type GeneralizedTables map[string]*GeneralizedTable
 type GeneralizedTable struct {
 	Name            string
-	SourceTableName string  `yaml:"source"`
-	Tolerance       float64 `yaml:"tolerance"`
-	SQLFilter       string  `yaml:"sql_filter"`
+	Description     string        `yaml:"description"`
+	SourceTableName string        `yaml:"source"`
+	Tolerance       float64       `yaml:"tolerance"`
+	SQLFilter       string        `yaml:"sql_filter"`
+	Tablespace      string        `yaml:"tablespace"`
+	IndexTablespace string        `yaml:"index_tablespace"`
+	GeometryIndex   string        `yaml:"geometry_index"`
+	Indexes         []TableIndex  `yaml:"indexes"`
+	Cluster         string        `yaml:"cluster"`
+	Schemas         *TableSchemas `yaml:"schemas"`
+	TableHooks      `yaml:",inline"`
+
+	// SimplifyAlgorithm is the algorithm for the Tolerance:
+	// douglas-peucker (default), visvalingam or topology. topology
+	// simplifies shared borders of adjacent polygons the same way.
+	SimplifyAlgorithm string `yaml:"simplify_algorithm"`
+}
+
+type LandPolygonTables map[string]*LandPolygonTable
+
+// LandPolygonTable is a table with the land polygons that are built from
+// the coastlines (natural=coastline) of a linestring table. The polygons
+// are split into tiles of TileSize (in the units of the SRID). BBox is the
+// extent (in EPSG:4326) of the imported extract.
+type LandPolygonTable struct {
+	Name            string
+	Description     string        `yaml:"description"`
+	SourceTableName string        `yaml:"source"`
+	TileSize        float64       `yaml:"tile_size"`
+	BBox            []float64     `yaml:"bbox"`
+	Tablespace      string        `yaml:"tablespace"`
+	IndexTablespace string        `yaml:"index_tablespace"`
+	Schemas         *TableSchemas `yaml:"schemas"`
 }
 
 type Filters struct {
@@ -0,0 +1,83 @@
+package mapping
+
+import (
+	"sort"
+
+	osm "github.com/omniscale/go-osm"
+)
+
+// Explanation is a table with a mapping that matches the tags of an
+// element. Rejected is empty if the element is inserted into the table,
+// otherwise it describes the filter or the rule that rejected the element.
+// Type is the geometry type of the match (point, linestring, polygon,
+// relation or relation_member), also for tables with type:geometry.
+type Explanation struct {
+	Match
+	Type     TableType
+	Rejected string
+}
+
+// ExplainNode returns all point tables that match the tags of the node.
+func (m *Mapping) ExplainNode(node *osm.Node) []Explanation {
+	var result []Explanation
+	explain(m.PointMatcher, PointTable, node.Tags, false, false, "", &result)
+	return sortExplanations(result)
+}
+
+// ExplainWay returns all linestring and polygon tables that match the tags
+// of the way. Like the matchers, closed ways with area=yes are rejected
+// from linestring tables and closed ways with area=no from polygon tables.
+func (m *Mapping) ExplainWay(way *osm.Way) []Explanation {
+	var result []Explanation
+	closed := way.IsClosed()
+
+	rejected := ""
+	if closed && way.Tags["area"] == "yes" {
+		rejected = "area=yes"
+	}
+	explain(m.LineStringMatcher, LineStringTable, way.Tags, closed, false, rejected, &result)
+
+	rejected = ""
+	if !closed {
+		rejected = "way is not closed"
+	} else if way.Tags["area"] == "no" {
+		rejected = "area=no"
+	}
+	explain(m.PolygonMatcher, PolygonTable, way.Tags, closed, false, rejected, &result)
+	return sortExplanations(result)
+}
+
+// ExplainRelation returns all polygon, relation and relation_member tables
+// that match the tags of the relation.
+func (m *Mapping) ExplainRelation(rel *osm.Relation) []Explanation {
+	var result []Explanation
+	explain(m.PolygonMatcher, PolygonTable, rel.Tags, true, true, "", &result)
+	explain(m.RelationMatcher, RelationTable, rel.Tags, true, true, "", &result)
+	explain(m.RelationMemberMatcher, RelationMemberTable, rel.Tags, true, true, "", &result)
+	return sortExplanations(result)
+}
+
+// explain appends the explanations of all tables of the matcher. rejected
+// is used for all tables that are not rejected by a filter.
+func explain(matcher interface{}, tableType TableType, tags osm.Tags, closed, relation bool, rejected string, result *[]Explanation) {
+	tm, ok := matcher.(*tagMatcher)
+	if !ok {
+		return
+	}
+	tm.matchTables(tags, closed, relation, func(match Match, filter string) {
+		if filter == "" {
+			filter = rejected
+		}
+		*result = append(*result, Explanation{Match: match, Type: tableType, Rejected: filter})
+	})
+}
+
+func sortExplanations(e []Explanation) []Explanation {
+	sort.Slice(e, func(i, j int) bool {
+		if e[i].Table.Name != e[j].Table.Name {
+			return e[i].Table.Name < e[j].Table.Name
+		}
+		return e[i].Type < e[j].Type
+	})
+	return e
+}
@@ -0,0 +1,59 @@
+package mapping
+
+import (
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+)
+
+func TestExplain(t *testing.T) {
+	m, err := New([]byte(`
+areas:
+  linear_tags: [highway]
+tables:
+  roads:
+    type: linestring
+    mapping:
+      highway: [__any__]
+    filters:
+      reject:
+        access: [private]
+  areas:
+    type: polygon
+    mapping:
+      highway: [pedestrian]
+      landuse: [__any__]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := osm.Way{Element: osm.Element{Tags: osm.Tags{"highway": "pedestrian", "access": "private"}}, Refs: []int64{1, 2, 3, 1}}
+	exp := m.ExplainWay(&w)
+	if len(exp) != 2 {
+		t.Fatalf("unexpected explanations %v", exp)
+	}
+	if exp[0].Table.Name != "areas" || exp[0].Type != PolygonTable || exp[0].Rejected != "areas: linear_tags" {
+		t.Errorf("unexpected explanation %v", exp[0])
+	}
+	if exp[1].Table.Name != "roads" || exp[1].Type != LineStringTable || exp[1].Rejected != "reject: access=[private]" {
+		t.Errorf("unexpected explanation %v", exp[1])
+	}
+
+	w = osm.Way{Element: osm.Element{Tags: osm.Tags{"landuse": "forest"}}, Refs: []int64{1, 2, 3}}
+	if exp := m.ExplainWay(&w); len(exp) != 1 || exp[0].Rejected != "way is not closed" {
+		t.Errorf("unexpected explanations %v", exp)
+	}
+	w.Refs = []int64{1, 2, 3, 1}
+	if exp := m.ExplainWay(&w); len(exp) != 1 || exp[0].Rejected != "" || exp[0].Key != "landuse" {
+		t.Errorf("unexpected explanations %v", exp)
+	}
+
+	r := osm.Relation{Element: osm.Element{Tags: osm.Tags{"landuse": "forest", "type": "site"}}}
+	if exp := m.ExplainRelation(&r); len(exp) != 1 || exp[0].Rejected != "relation type: multipolygon or boundary" {
+		t.Errorf("unexpected explanations %v", exp)
+	}
+	if exp := m.ExplainNode(&osm.Node{Element: osm.Element{Tags: osm.Tags{"landuse": "forest"}}}); len(exp) != 0 {
+		t.Errorf("unexpected explanations %v", exp)
+	}
+}
@@ -0,0 +1,231 @@
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/omniscale/imposm3/mapping/config"
+)
+
+// FingerprintFilename is the file of the fingerprint in the cache directory.
+const FingerprintFilename = "mapping_fingerprint.json"
+
+// Fingerprint contains all options of a mapping that define the content of
+// the tables, as canonical JSON value for each option (e.g.
+// tables.roads.columns.name). Descriptions, indices, hooks and other
+// options that do not change the imported rows are not included.
+type Fingerprint map[string]string
+
+// Fingerprint returns the fingerprint of the mapping.
+func (m *Mapping) Fingerprint() Fingerprint {
+	f := make(Fingerprint)
+	f.add("use_single_id_space", m.Conf.SingleIDSpace)
+	f.add("tags", m.Conf.Tags)
+	f.add("areas", m.Conf.Areas)
+	f.add("polygon_orientation", m.Conf.PolygonOrientation)
+
+	for name, t := range m.Conf.Tables {
+		prefix := "tables." + name + "."
+		f.add(prefix+"type", t.Type)
+		f.add(prefix+"mapping", orderedKeyValues(t.Mapping))
+		for sub, m := range t.Mappings {
+			f.add(prefix+"mappings."+sub, orderedKeyValues(m.Mapping))
+		}
+		f.add(prefix+"type_mappings.points", orderedKeyValues(t.TypeMappings.Points))
+		f.add(prefix+"type_mappings.linestrings", orderedKeyValues(t.TypeMappings.LineStrings))
+		f.add(prefix+"type_mappings.polygons", orderedKeyValues(t.TypeMappings.Polygons))
+		for _, c := range t.Columns {
+			f.add(prefix+"columns."+c.Name, struct {
+				Key        config.Key             `json:"key,omitempty"`
+				Keys       []config.Key           `json:"keys,omitempty"`
+				Type       string                 `json:"type"`
+				Args       map[string]interface{} `json:"args,omitempty"`
+				FromMember bool                   `json:"from_member,omitempty"`
+			}{c.Key, c.Keys, c.Type, c.Args, c.FromMember})
+		}
+		if t.Filters != nil {
+			f.add(prefix+"filters", struct {
+				ExcludeTags   *[][]string           `json:"exclude_tags,omitempty"`
+				Reject        map[string][]string   `json:"reject,omitempty"`
+				Require       map[string][]string   `json:"require,omitempty"`
+				RejectRegexp  config.KeyRegexpValue `json:"reject_regexp,omitempty"`
+				RequireRegexp config.KeyRegexpValue `json:"require_regexp,omitempty"`
+			}{
+				t.Filters.ExcludeTags,
+				orderedKeyValues(t.Filters.Reject),
+				orderedKeyValues(t.Filters.Require),
+				t.Filters.RejectRegexp,
+				t.Filters.RequireRegexp,
+			})
+		}
+		f.add(prefix+"relation_types", t.RelationTypes)
+		f.add(prefix+"partition_by", t.PartitionBy)
+		f.add(prefix+"elevation", t.Elevation)
+		f.add(prefix+"limitto", t.LimitTo)
+		f.add(prefix+"geography", t.Geography)
+		f.add(prefix+"validity", t.Validity)
+		f.add(prefix+"label_point", t.LabelPoint)
+		f.add(prefix+"merge_lines", t.MergeLines)
+		f.add(prefix+"simplify_tolerance", t.SimplifyTolerance)
+		f.add(prefix+"simplify_algorithm", t.SimplifyAlgorithm)
+		f.add(prefix+"snap_to_grid", t.SnapToGrid)
+		f.add(prefix+"antimeridian", t.Antimeridian)
+		f.add(prefix+"subdivide", t.Subdivide)
+		f.add(prefix+"min_hole_area", t.MinHoleArea)
+	}
+
+	for name, t := range m.Conf.GeneralizedTables {
+		prefix := "generalized_tables." + name + "."
+		f.add(prefix+"source", t.SourceTableName)
+		f.add(prefix+"tolerance", t.Tolerance)
+		f.add(prefix+"sql_filter", t.SQLFilter)
+		f.add(prefix+"simplify_algorithm", t.SimplifyAlgorithm)
+	}
+
+	for name, t := range m.Conf.LandPolygons {
+		prefix := "land_polygons." + name + "."
+		f.add(prefix+"source", t.SourceTableName)
+		f.add(prefix+"tile_size", t.TileSize)
+		f.add(prefix+"bbox", t.BBox)
+	}
+	return f
+}
+
+// add stores the JSON value of v. Empty values are not stored, to keep
+// the fingerprint stable if new options are added.
+func (f Fingerprint) add(path string, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		// only for unsupported column args, compare the Go value instead
+		b = []byte(fmt.Sprintf("%q", fmt.Sprint(v)))
+	}
+	switch s := string(b); s {
+	case "null", "false", `""`, "0", "[]", "{}":
+	default:
+		f[path] = s
+	}
+}
+
+// orderedKeyValues returns the values of each key in the order of the
+// mapping.
+func orderedKeyValues(kv config.KeyValues) map[string][]string {
+	if len(kv) == 0 {
+		return nil
+	}
+	result := make(map[string][]string, len(kv))
+	for k, vals := range kv {
+		vals = append([]config.OrderedValue(nil), vals...)
+		sort.Slice(vals, func(i, j int) bool { return vals[i].Order < vals[j].Order })
+		for _, v := range vals {
+			result[string(k)] = append(result[string(k)], string(v.Value))
+		}
+	}
+	return result
+}
+
+// FingerprintChange is a single difference between two fingerprints.
+type FingerprintChange struct {
+	Path string
+	// Old and New are the JSON values, empty if the option was added or
+	// removed.
+	Old, New string
+}
+
+// Compatible returns true for changes that are supported by diff imports.
+// These are new columns, which are added to the existing tables.
+func (c FingerprintChange) Compatible() bool {
+	parts := strings.Split(c.Path, ".")
+	return c.Old == "" && len(parts) == 4 && parts[0] == "tables" && parts[2] == "columns"
+}
+
+func (c FingerprintChange) String() string {
+	switch {
+	case c.Old == "":
+		return fmt.Sprintf("%s: added %s", c.Path, c.New)
+	case c.New == "":
+		return fmt.Sprintf("%s: removed %s", c.Path, c.Old)
+	}
+	return fmt.Sprintf("%s: changed from %s to %s", c.Path, c.Old, c.New)
+}
+
+// Changes returns all differences from f to the newer fingerprint,
+// sorted by path.
+func (f Fingerprint) Changes(newer Fingerprint) []FingerprintChange {
+	var changes []FingerprintChange
+	for path, old := range f {
+		if v := newer[path]; v != old {
+			changes = append(changes, FingerprintChange{Path: path, Old: old, New: v})
+		}
+	}
+	for path, v := range newer {
+		if _, ok := f[path]; !ok {
+			changes = append(changes, FingerprintChange{Path: path, New: v})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// ReplaceTables replaces the options of the tables and generalized tables
+// names with the options from newer, e.g. after these tables were imported
+// again with a changed mapping. All other options remain unchanged.
+func (f Fingerprint) ReplaceTables(newer Fingerprint, names []string) {
+	var prefixes []string
+	for _, name := range names {
+		prefixes = append(prefixes, "tables."+name+".", "generalized_tables."+name+".")
+	}
+	hasPrefix := func(path string) bool {
+		for _, p := range prefixes {
+			if strings.HasPrefix(path, p) {
+				return true
+			}
+		}
+		return false
+	}
+	for path := range f {
+		if hasPrefix(path) {
+			delete(f, path)
+		}
+	}
+	for path, v := range newer {
+		if hasPrefix(path) {
+			f[path] = v
+		}
+	}
+}
+
+// ReadFingerprint reads the fingerprint from the cache directory. It
+// returns nil if the directory does not contain a fingerprint.
+func ReadFingerprint(cacheDir string) (Fingerprint, error) {
+	b, err := ioutil.ReadFile(filepath.Join(cacheDir, FingerprintFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f := make(Fingerprint)
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// WriteFingerprint writes the fingerprint to the cache directory.
+func WriteFingerprint(cacheDir string, f Fingerprint) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(cacheDir, FingerprintFilename)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
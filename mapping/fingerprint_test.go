@@ -0,0 +1,193 @@
+package mapping
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+const fingerprintMapping = `
+tables:
+  roads:
+    type: linestring
+    description: all roads
+    columns:
+    - name: osm_id
+      type: id
+    - name: type
+      type: mapping_value
+    mapping:
+      highway: [primary, secondary]
+    indexes:
+    - columns: [type]
+`
+
+func TestFingerprintChanges(t *testing.T) {
+	old, err := New([]byte(fingerprintMapping))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		mapping  string
+		expected []string
+		compat   bool
+	}{
+		{"unchanged", fingerprintMapping, nil, true},
+		{"description and indexes", `
+tables:
+  roads:
+    type: linestring
+    description: only major roads
+    columns:
+    - name: osm_id
+      type: id
+    - name: type
+      type: mapping_value
+    mapping:
+      highway: [primary, secondary]
+`, nil, true},
+		{"new column", `
+tables:
+  roads:
+    type: linestring
+    columns:
+    - name: osm_id
+      type: id
+    - name: name
+      key: name
+      type: string
+    - name: type
+      type: mapping_value
+    mapping:
+      highway: [primary, secondary]
+`, []string{`tables.roads.columns.name: added {"key":"name","type":"string"}`}, true},
+		{"changed mapping and type", `
+tables:
+  roads:
+    type: polygon
+    columns:
+    - name: osm_id
+      type: id
+    - name: type
+      type: mapping_value
+    mapping:
+      highway: [secondary, primary]
+`, []string{
+			`tables.roads.mapping: changed from {"highway":["primary","secondary"]} to {"highway":["secondary","primary"]}`,
+			`tables.roads.type: changed from "linestring" to "polygon"`,
+		}, false},
+		{"removed table", `
+tables:
+  streets:
+    type: linestring
+    mapping:
+      highway: [primary]
+`, []string{
+			`tables.roads.columns.osm_id: removed {"type":"id"}`,
+			`tables.roads.columns.type: removed {"type":"mapping_value"}`,
+			`tables.roads.mapping: removed {"highway":["primary","secondary"]}`,
+			`tables.roads.type: removed "linestring"`,
+			`tables.streets.mapping: added {"highway":["primary"]}`,
+			`tables.streets.type: added "linestring"`,
+		}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := New([]byte(tc.mapping))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var changes []string
+			compat := true
+			for _, c := range old.Fingerprint().Changes(m.Fingerprint()) {
+				changes = append(changes, c.String())
+				compat = compat && c.Compatible()
+			}
+			if !reflect.DeepEqual(changes, tc.expected) {
+				t.Errorf("unexpected changes\n%q\n%q", changes, tc.expected)
+			}
+			if compat != tc.compat {
+				t.Errorf("expected compatible %v, got %v", tc.compat, compat)
+			}
+		})
+	}
+}
+
+func TestFingerprintFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imposm_fingerprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if f, err := ReadFingerprint(dir); err != nil || f != nil {
+		t.Fatal("expected no fingerprint", f, err)
+	}
+	m, err := New([]byte(fingerprintMapping))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFingerprint(dir, m.Fingerprint()); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ReadFingerprint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(f, m.Fingerprint()) {
+		t.Errorf("unexpected fingerprint %v", f)
+	}
+}
+
+func TestFingerprintReplaceTables(t *testing.T) {
+	mapping := func(roads, buildings string) string {
+		return `
+tables:
+  roads:
+    type: linestring
+    columns:
+    - name: osm_id
+      type: id` + roads + `
+    mapping:
+      highway: [__any__]
+  buildings:
+    type: polygon
+    columns:
+    - name: osm_id
+      type: id` + buildings + `
+    mapping:
+      building: [__any__]
+`
+	}
+	old, err := New([]byte(mapping("", "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := New([]byte(mapping(`
+    - name: name
+      type: string
+      key: name`, `
+    - name: height
+      type: string
+      key: height`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SelectTables([]string{"roads"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// buildings were not imported again and keep the old fingerprint
+	f := old.Fingerprint()
+	f.ReplaceTables(m.Fingerprint(), []string{"roads"})
+	var changes []string
+	for _, c := range old.Fingerprint().Changes(f) {
+		changes = append(changes, c.String())
+	}
+	expected := []string{`tables.roads.columns.name: added {"key":"name","type":"string"}`}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("unexpected changes\n%q\n%q", changes, expected)
+	}
+}
@@ -1,10 +1,14 @@
 package mapping
 
 import (
+	"fmt"
 	"io/ioutil"
 	"regexp"
+	"sort"
+	"strings"
 
 	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/geom"
 	"github.com/omniscale/imposm3/log"
 	"github.com/omniscale/imposm3/mapping/config"
 
@@ -48,6 +52,19 @@ func (tt TagTableMapping) asTagMap() tagMap {
 type DestTable struct {
 	Name       string
 	SubMapping string
+	// Elevation is set for tables with 3D geometries.
+	Elevation bool
+	// LimitToCentroid is set for tables that keep whole geometries with a
+	// centroid inside of the -limitto polygons, instead of clipping them.
+	LimitToCentroid bool
+	// StrictValidity is set for tables that reject repaired polygons.
+	StrictValidity bool
+	// MergeLines is set for relation tables with the merged way members as
+	// geometry.
+	MergeLines bool
+	// Subdivide is the maximum number of vertices of polygons, larger
+	// polygons are inserted in multiple parts.
+	Subdivide int
 }
 
 type TableType string
@@ -88,6 +105,8 @@ type Mapping struct {
 	PolygonMatcher        RelWayMatcher
 	RelationMatcher       RelationMatcher
 	RelationMemberMatcher RelationMatcher
+	// skipStatic excludes static tables from the matchers.
+	skipStatic bool
 }
 
 func FromFile(filename string) (*Mapping, error) {
@@ -133,14 +152,158 @@ func (m *Mapping) prepare() error {
 				return errors.Errorf("table with type:geometry requires type_mapping for table %s", name)
 			}
 		}
+		switch t.LimitTo {
+		case "", "clip", "centroid":
+		default:
+			return errors.Errorf("unknown limitto %q for table %s, expected clip or centroid", t.LimitTo, name)
+		}
+		switch t.Validity {
+		case "", "repair", "strict":
+		default:
+			return errors.Errorf("unknown validity %q for table %s, expected repair or strict", t.Validity, name)
+		}
+		if t.SimplifyTolerance < 0 {
+			return errors.Errorf("negative simplify_tolerance for table %s", name)
+		}
+		if err := checkSimplifyAlgorithm(t.SimplifyAlgorithm); err != nil {
+			return errors.Wrapf(err, "table %s", name)
+		}
+		if t.MinHoleArea < 0 {
+			return errors.Errorf("negative min_hole_area for table %s", name)
+		}
+		if t.SnapToGrid < 0 {
+			return errors.Errorf("negative snap_to_grid for table %s", name)
+		}
+		switch t.Antimeridian {
+		case "", geom.AntimeridianShift:
+		case geom.AntimeridianSplit:
+			if t.Elevation {
+				return errors.Errorf("antimeridian split does not support elevation for table %s", name)
+			}
+		default:
+			return errors.Errorf("unknown antimeridian %q for table %s, expected split or shift", t.Antimeridian, name)
+		}
+		if t.Subdivide != 0 {
+			if TableType(t.Type) != PolygonTable && TableType(t.Type) != GeometryTable {
+				return errors.Errorf("subdivide requires type:polygon or type:geometry for table %s", name)
+			}
+			if t.Subdivide < 5 {
+				return errors.Errorf("subdivide requires at least 5 vertices for table %s", name)
+			}
+			if t.Elevation || t.LabelPoint {
+				return errors.Errorf("subdivide does not support elevation or label_point for table %s", name)
+			}
+		}
+		if t.MergeLines && TableType(t.Type) != RelationTable {
+			return errors.Errorf("merge_lines requires type:relation for table %s", name)
+		}
+		if t.LabelPoint {
+			if TableType(t.Type) != PolygonTable {
+				return errors.Errorf("label_point requires type:polygon for table %s", name)
+			}
+			if t.Elevation {
+				return errors.Errorf("label_point does not support elevation for table %s", name)
+			}
+		}
 	}
 
 	for name, t := range m.Conf.GeneralizedTables {
 		t.Name = name
+		if t.SimplifyAlgorithm != geom.Topology {
+			if err := checkSimplifyAlgorithm(t.SimplifyAlgorithm); err != nil {
+				return errors.Wrapf(err, "generalized table %s", name)
+			}
+		}
+	}
+
+	for name, t := range m.Conf.LandPolygons {
+		t.Name = name
+		if _, ok := m.Conf.Tables[name]; ok {
+			return errors.Errorf("land polygons %s conflicts with table %s", name, name)
+		}
+		if _, ok := m.Conf.GeneralizedTables[name]; ok {
+			return errors.Errorf("land polygons %s conflicts with generalized table %s", name, name)
+		}
+		source, ok := m.Conf.Tables[t.SourceTableName]
+		if !ok {
+			return errors.Errorf("missing source %q for land polygons %s", t.SourceTableName, name)
+		}
+		if TableType(source.Type) != LineStringTable {
+			return errors.Errorf("land polygons %s require a linestring table as source", name)
+		}
+	}
+
+	switch m.Conf.PolygonOrientation {
+	case "", "rfc7946", "postgis":
+	default:
+		return errors.Errorf("unknown polygon_orientation %q, expected rfc7946 or postgis", m.Conf.PolygonOrientation)
 	}
 	return nil
 }
 
+func checkSimplifyAlgorithm(algorithm string) error {
+	switch algorithm {
+	case "", geom.DouglasPeucker, geom.Visvalingam:
+		return nil
+	}
+	return errors.Errorf("unknown simplify_algorithm %q, expected %s or %s", algorithm, geom.DouglasPeucker, geom.Visvalingam)
+}
+
+// SelectTables removes all tables that are not in names from the mapping,
+// for imports of single tables. Generalized tables are kept if their
+// source table is kept. Returns an error for unknown tables and for
+// generalized tables without their source table.
+func (m *Mapping) SelectTables(names []string) error {
+	selected := make(map[string]bool)
+	for _, name := range names {
+		_, isTable := m.Conf.Tables[name]
+		_, isGeneralized := m.Conf.GeneralizedTables[name]
+		if !isTable && !isGeneralized {
+			return errors.Errorf("unknown table %q", name)
+		}
+		selected[name] = true
+	}
+	for name := range m.Conf.Tables {
+		if !selected[name] {
+			delete(m.Conf.Tables, name)
+		}
+	}
+	// generalized tables can be generalized from other generalized tables
+	for changed := true; changed; {
+		changed = false
+		for name, t := range m.Conf.GeneralizedTables {
+			if !selected[name] && selected[t.SourceTableName] {
+				selected[name] = true
+				changed = true
+			}
+		}
+	}
+	for name, t := range m.Conf.GeneralizedTables {
+		if !selected[name] {
+			delete(m.Conf.GeneralizedTables, name)
+		} else if !selected[t.SourceTableName] {
+			return errors.Errorf("generalized table %q requires its source table %q", name, t.SourceTableName)
+		}
+	}
+	return m.createMatcher()
+}
+
+// SkipStaticTables excludes all tables with static: true from the
+// matchers, for diff imports. The tables remain in the mapping, but no
+// elements are inserted into or deleted from them. Returns the names of
+// the static tables.
+func (m *Mapping) SkipStaticTables() ([]string, error) {
+	var names []string
+	for name, t := range m.Conf.Tables {
+		if t.Static {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	m.skipStatic = true
+	return names, m.createMatcher()
+}
+
 func (m *Mapping) createMatcher() error {
 	var err error
 	m.PointMatcher, err = m.pointMatcher()
@@ -171,19 +334,32 @@ func (m *Mapping) mappings(tableType TableType, mappings TagTableMapping) {
 		if TableType(t.Type) != GeometryTable && TableType(t.Type) != tableType {
 			continue
 		}
-		mappings.addFromMapping(t.Mapping, DestTable{Name: name})
+		if m.skipStatic && t.Static {
+			continue
+		}
+		dest := DestTable{
+			Name:            name,
+			Elevation:       t.Elevation,
+			LimitToCentroid: t.LimitTo == "centroid",
+			StrictValidity:  t.Validity == "strict",
+			MergeLines:      t.MergeLines,
+			Subdivide:       t.Subdivide,
+		}
+		mappings.addFromMapping(t.Mapping, dest)
 
 		for subMappingName, subMapping := range t.Mappings {
-			mappings.addFromMapping(subMapping.Mapping, DestTable{Name: name, SubMapping: subMappingName})
+			subDest := dest
+			subDest.SubMapping = subMappingName
+			mappings.addFromMapping(subMapping.Mapping, subDest)
 		}
 
 		switch tableType {
 		case PointTable:
-			mappings.addFromMapping(t.TypeMappings.Points, DestTable{Name: name})
+			mappings.addFromMapping(t.TypeMappings.Points, dest)
 		case LineStringTable:
-			mappings.addFromMapping(t.TypeMappings.LineStrings, DestTable{Name: name})
+			mappings.addFromMapping(t.TypeMappings.LineStrings, dest)
 		case PolygonTable:
-			mappings.addFromMapping(t.TypeMappings.Polygons, DestTable{Name: name})
+			mappings.addFromMapping(t.TypeMappings.Polygons, dest)
 		}
 	}
 }
@@ -193,7 +369,7 @@ func (m *Mapping) tables(tableType TableType) (map[string]*rowBuilder, error) {
 	result := make(map[string]*rowBuilder)
 	for name, t := range m.Conf.Tables {
 		if TableType(t.Type) == tableType || TableType(t.Type) == GeometryTable {
-			result[name], err = makeRowBuilder(t)
+			result[name], err = makeRowBuilder(t, m.Conf.PolygonOrientation)
 			if err != nil {
 				return nil, errors.Wrapf(err, "creating row builder for %s", name)
 			}
@@ -203,7 +379,7 @@ func (m *Mapping) tables(tableType TableType) (map[string]*rowBuilder, error) {
 	return result, nil
 }
 
-func makeRowBuilder(tbl *config.Table) (*rowBuilder, error) {
+func makeRowBuilder(tbl *config.Table, orientation string) (*rowBuilder, error) {
 	result := rowBuilder{}
 
 	for _, mappingColumn := range tbl.Columns {
@@ -215,6 +391,27 @@ func makeRowBuilder(tbl *config.Table) (*rowBuilder, error) {
 			return nil, errors.Wrapf(err, "creating column %s", mappingColumn.Name)
 		}
 		column.colType = *columnType
+		isGeometry := column.colType.GoType == "geometry" || column.colType.GoType == "validated_geometry"
+		if tbl.Elevation && isGeometry {
+			column.colType.Func = GeometryZ
+		}
+		if tbl.Antimeridian != "" && isGeometry {
+			column.colType.Func = AntimeridianGeometry(column.colType.Func, tbl.Antimeridian)
+		}
+		if tbl.MinHoleArea > 0 && isGeometry {
+			column.colType.Func = WithoutSmallHolesGeometry(column.colType.Func, tbl.MinHoleArea)
+		}
+		if tbl.SimplifyTolerance > 0 && isGeometry {
+			column.colType.Func = SimplifiedGeometry(column.colType.Func, tbl.SimplifyTolerance, tbl.SimplifyAlgorithm)
+		}
+		if tbl.LabelPoint && isGeometry {
+			column.colType.Func = LabelPointGeometry(column.colType.Func)
+		} else if orientation != "" && isGeometry && tbl.Type != string(PointTable) && tbl.Type != string(LineStringTable) {
+			column.colType.Func = OrientedGeometry(column.colType.Func, orientation == "rfc7946")
+		}
+		if tbl.SnapToGrid > 0 && isGeometry {
+			column.colType.Func = SnappedGeometry(column.colType.Func, tbl.SnapToGrid)
+		}
 		result.columns = append(result.columns, column)
 	}
 	return &result, nil
@@ -274,7 +471,18 @@ func (m *Mapping) extraTags(tableType TableType, tags map[Key]bool) {
 
 type elementFilter func(tags osm.Tags, key Key, closed bool) bool
 
-type tableElementFilters map[string][]elementFilter
+// tableFilter is an elementFilter with a description of the filter, for
+// the explanations of rejected elements.
+type tableFilter struct {
+	accept elementFilter
+	desc   string
+}
+
+type tableElementFilters map[string][]tableFilter
+
+func (f tableElementFilters) add(table, desc string, filter elementFilter) {
+	f[table] = append(f[table], tableFilter{accept: filter, desc: desc})
+}
 
 func (m *Mapping) addTypedFilters(tableType TableType, filters tableElementFilters) {
 	var areaTags map[Key]struct{}
@@ -310,7 +518,7 @@ func (m *Mapping) addTypedFilters(tableType TableType, filters tableElementFilte
 				}
 				return true
 			}
-			filters[name] = append(filters[name], f)
+			filters.add(name, "areas: area_tags", f)
 		}
 		if TableType(t.Type) == PolygonTable && linearTags != nil {
 			f := func(tags osm.Tags, key Key, closed bool) bool {
@@ -324,7 +532,7 @@ func (m *Mapping) addTypedFilters(tableType TableType, filters tableElementFilte
 				}
 				return true
 			}
-			filters[name] = append(filters[name], f)
+			filters.add(name, "areas: linear_tags", f)
 		}
 	}
 }
@@ -343,7 +551,7 @@ func (m *Mapping) addRelationFilters(tableType TableType, filters tableElementFi
 				}
 				return false
 			}
-			filters[name] = append(filters[name], f)
+			filters.add(name, fmt.Sprintf("relation_types: %v", relTypes), f)
 		} else {
 			if TableType(t.Type) == PolygonTable {
 				// standard multipolygon handling (boundary and land_area are for backwards compatibility)
@@ -355,7 +563,7 @@ func (m *Mapping) addRelationFilters(tableType TableType, filters tableElementFi
 					}
 					return false
 				}
-				filters[name] = append(filters[name], f)
+				filters.add(name, "relation type: multipolygon or boundary", f)
 			}
 		}
 	}
@@ -377,38 +585,51 @@ func (m *Mapping) addFilters(filters tableElementFilters) {
 						Order: 1,
 					},
 				}
-				filters[name] = append(filters[name], makeFiltersFunction(name, false, true, keyname, vararr))
-
+				filters.add(name, fmt.Sprintf("exclude_tags: %s=%s", keyname, filterKeyVal[1]),
+					makeFiltersFunction(name, false, true, keyname, vararr))
 			}
 		}
 
 		if t.Filters.Require != nil {
 			for keyname, vararr := range t.Filters.Require {
-				filters[name] = append(filters[name], makeFiltersFunction(name, true, false, string(keyname), vararr))
+				filters.add(name, "require: "+filterDesc(keyname, vararr),
+					makeFiltersFunction(name, true, false, string(keyname), vararr))
 			}
 		}
 
 		if t.Filters.Reject != nil {
 			for keyname, vararr := range t.Filters.Reject {
-				filters[name] = append(filters[name], makeFiltersFunction(name, false, true, string(keyname), vararr))
+				filters.add(name, "reject: "+filterDesc(keyname, vararr),
+					makeFiltersFunction(name, false, true, string(keyname), vararr))
 			}
 		}
 
 		if t.Filters.RequireRegexp != nil {
 			for keyname, regexp := range t.Filters.RequireRegexp {
-				filters[name] = append(filters[name], makeRegexpFiltersFunction(name, true, false, string(keyname), regexp))
+				filters.add(name, fmt.Sprintf("require_regexp: %s=~%s", keyname, regexp),
+					makeRegexpFiltersFunction(name, true, false, string(keyname), regexp))
 			}
 		}
 
 		if t.Filters.RejectRegexp != nil {
 			for keyname, regexp := range t.Filters.RejectRegexp {
-				filters[name] = append(filters[name], makeRegexpFiltersFunction(name, false, true, string(keyname), regexp))
+				filters.add(name, fmt.Sprintf("reject_regexp: %s=~%s", keyname, regexp),
+					makeRegexpFiltersFunction(name, false, true, string(keyname), regexp))
 			}
 		}
 
 	}
 }
 
+// filterDesc returns the key and values of a require or reject filter.
+func filterDesc(key config.Key, values []config.OrderedValue) string {
+	vals := make([]string, len(values))
+	for i, v := range values {
+		vals[i] = string(v.Value)
+	}
+	return fmt.Sprintf("%s=[%s]", key, strings.Join(vals, ","))
+}
+
 func findValueInOrderedValue(v config.Value, list []config.OrderedValue) bool {
 	for _, item := range list {
 		if item.Value == v {
@@ -164,6 +164,18 @@ type orderedMatch struct {
 }
 
 func (tm *tagMatcher) match(tags osm.Tags, closed bool, relation bool) []Match {
+	var matches []Match
+	tm.matchTables(tags, closed, relation, func(m Match, rejected string) {
+		if rejected == "" {
+			matches = append(matches, m)
+		}
+	})
+	return matches
+}
+
+// matchTables calls f for each table that matches the tags, with the
+// description of the filter that rejected the tags, or an empty string.
+func (tm *tagMatcher) matchTables(tags osm.Tags, closed bool, relation bool, f func(m Match, rejected string)) {
 	tables := make(map[DestTable]orderedMatch)
 
 	addTables := func(k, v string, tbls []orderedDestTable) {
@@ -201,35 +213,24 @@ func (tm *tagMatcher) match(tags osm.Tags, closed bool, relation bool) []Match {
 			}
 		}
 	}
-	var matches []Match
 	for t, match := range tables {
-		filters, ok := tm.filters[t.Name]
-		filteredOut := false
-		if ok {
-			for _, filter := range filters {
-				if !filter(tags, Key(match.Key), closed) {
-					filteredOut = true
-					break
-				}
+		rejected := ""
+		for _, filter := range tm.filters[t.Name] {
+			if !filter.accept(tags, Key(match.Key), closed) {
+				rejected = filter.desc
+				break
 			}
 		}
-		if relation && !filteredOut {
-			filters, ok := tm.relFilters[t.Name]
-			if ok {
-				for _, filter := range filters {
-					if !filter(tags, Key(match.Key), closed) {
-						filteredOut = true
-						break
-					}
+		if relation && rejected == "" {
+			for _, filter := range tm.relFilters[t.Name] {
+				if !filter.accept(tags, Key(match.Key), closed) {
+					rejected = filter.desc
+					break
 				}
 			}
 		}
-
-		if !filteredOut {
-			matches = append(matches, match.Match)
-		}
+		f(match.Match, rejected)
 	}
-	return matches
 }
 
 type valueBuilder struct {
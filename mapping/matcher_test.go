@@ -20,3 +20,138 @@ func BenchmarkTagMatch(b *testing.B) {
 		}
 	}
 }
+
+func TestMatchLimitToCentroid(t *testing.T) {
+	m, err := New([]byte(`
+tables:
+  roads:
+    type: linestring
+    mapping:
+      highway: [__any__]
+  admin:
+    type: polygon
+    limitto: centroid
+    mappings:
+      admin:
+        mapping:
+          boundary: [administrative]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := osm.Way{}
+	w.Tags = osm.Tags{"highway": "primary"}
+	if m := m.LineStringMatcher.MatchWay(&w); len(m) != 1 || m[0].Table.LimitToCentroid {
+		t.Errorf("unexpected matches %v", m)
+	}
+	w.Tags = osm.Tags{"boundary": "administrative"}
+	w.Refs = []int64{1, 2, 3, 1}
+	if m := m.PolygonMatcher.MatchWay(&w); len(m) != 1 || !m[0].Table.LimitToCentroid || m[0].Table.SubMapping != "admin" {
+		t.Errorf("unexpected matches %v", m)
+	}
+
+	_, err = New([]byte(`
+tables:
+  admin:
+    type: polygon
+    limitto: within
+    mapping:
+      boundary: [administrative]
+`))
+	if err == nil {
+		t.Error("expected error for unknown limitto")
+	}
+}
+
+func TestSelectTables(t *testing.T) {
+	m, err := New([]byte(`
+tables:
+  roads:
+    type: linestring
+    mapping:
+      highway: [__any__]
+  buildings:
+    type: polygon
+    mapping:
+      building: [__any__]
+generalized_tables:
+  roads_gen1:
+    source: roads
+    tolerance: 10
+  roads_gen0:
+    source: roads_gen1
+    tolerance: 50
+  buildings_gen0:
+    source: buildings
+    tolerance: 50
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SelectTables([]string{"roads"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Conf.Tables) != 1 || m.Conf.Tables["roads"] == nil {
+		t.Errorf("unexpected tables %v", m.Conf.Tables)
+	}
+	if len(m.Conf.GeneralizedTables) != 2 || m.Conf.GeneralizedTables["roads_gen0"] == nil || m.Conf.GeneralizedTables["roads_gen1"] == nil {
+		t.Errorf("unexpected generalized tables %v", m.Conf.GeneralizedTables)
+	}
+
+	w := osm.Way{Element: osm.Element{Tags: osm.Tags{"highway": "primary", "building": "yes"}}}
+	if matches := m.LineStringMatcher.MatchWay(&w); len(matches) != 1 || matches[0].Table.Name != "roads" {
+		t.Errorf("unexpected line string matches %v", matches)
+	}
+	if matches := m.PolygonMatcher.MatchWay(&w); len(matches) != 0 {
+		t.Errorf("unexpected polygon matches %v", matches)
+	}
+
+	for _, names := range [][]string{{"unknown"}, {"landusages_gen0"}} {
+		m, err := FromFile("test_mapping.yml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.SelectTables(names); err == nil {
+			t.Errorf("expected error for %v", names)
+		}
+	}
+}
+
+func TestMatchSkipStaticTables(t *testing.T) {
+	m, err := New([]byte(`
+tables:
+  roads:
+    type: linestring
+    mapping:
+      highway: [__any__]
+  old_roads:
+    type: linestring
+    static: true
+    mapping:
+      highway: [__any__]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := osm.Way{}
+	w.Tags = osm.Tags{"highway": "primary"}
+	if m := m.LineStringMatcher.MatchWay(&w); len(m) != 2 {
+		t.Errorf("unexpected matches %v", m)
+	}
+
+	static, err := m.SkipStaticTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(static) != 1 || static[0] != "old_roads" {
+		t.Errorf("unexpected static tables %v", static)
+	}
+	if m := m.LineStringMatcher.MatchWay(&w); len(m) != 1 || m[0].Table.Name != "roads" {
+		t.Errorf("unexpected matches %v", m)
+	}
+	if _, ok := m.Conf.Tables["old_roads"]; !ok {
+		t.Error("static table removed from mapping")
+	}
+}
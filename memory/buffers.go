@@ -0,0 +1,56 @@
+package memory
+
+import "sync/atomic"
+
+// Buffers are the sizes of the channels between the stages of an import,
+// from the buffers section of the config file. Zero values use the
+// defaults, which are reduced with a memory budget. Larger buffers help if
+// a stage has high latencies, e.g. a PostgreSQL server in a remote network.
+type Buffers struct {
+	// Read is the number of element batches between the parser and the
+	// workers that cache the elements (default 4).
+	Read int `json:"read,omitempty"`
+	// Cache is the number of elements between the cache and the workers
+	// that build the geometries (default 1024 for ways, unbuffered for
+	// nodes and relations).
+	Cache int `json:"cache,omitempty"`
+	// Database is the number of rows for each table between the workers
+	// and the database writer (default 64).
+	Database int `json:"database,omitempty"`
+}
+
+var buffers atomic.Value // Buffers
+
+// SetBuffers sets the buffer sizes.
+func SetBuffers(b Buffers) {
+	buffers.Store(b)
+}
+
+func currentBuffers() Buffers {
+	b, _ := buffers.Load().(Buffers)
+	return b
+}
+
+func bufferSize(configured, def int) int {
+	if configured > 0 {
+		return configured
+	}
+	return Scale(def)
+}
+
+// ReadBuffer returns the size of the channels between the parser and the
+// cache workers.
+func ReadBuffer() int {
+	return bufferSize(currentBuffers().Read, 4)
+}
+
+// CacheBuffer returns the size of the channels of cache iterators, def is
+// the default of the iterator.
+func CacheBuffer(def int) int {
+	return bufferSize(currentBuffers().Cache, def)
+}
+
+// DatabaseBuffer returns the size of the row channel of each table.
+func DatabaseBuffer() int {
+	return bufferSize(currentBuffers().Database, 64)
+}
@@ -0,0 +1,159 @@
+// Package memory limits the memory usage of imposm to a budget.
+//
+// The budget is used to reduce the buffer sizes, cache sizes and the number
+// of concurrent workers, which are made for machines with 16GB or more. The
+// resident memory is monitored while the budget is set and workers back off
+// when it approaches the budget, so that the Go runtime can release memory.
+package memory
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/omniscale/imposm3/log"
+)
+
+const (
+	// referenceMB is the memory the default buffer sizes are made for.
+	referenceMB = 16 * 1024
+	// workerMB is the memory that is reserved for each worker.
+	workerMB = 512
+
+	// Workers back off while the resident memory is above highWater and
+	// they continue if it is below lowWater (fractions of the budget).
+	highWater = 0.9
+	lowWater  = 0.8
+	// maxBackoff is the max time a worker waits in Backoff, so that
+	// imports continue if the memory is not released.
+	maxBackoff = 30 * time.Second
+)
+
+var (
+	budgetMB     int64
+	pressure     int32
+	startMonitor sync.Once
+)
+
+// SetBudget sets the memory budget in MB and starts to monitor the
+// resident memory. mb <= 0 removes the budget.
+func SetBudget(mb int) {
+	if mb <= 0 {
+		atomic.StoreInt64(&budgetMB, 0)
+		return
+	}
+	atomic.StoreInt64(&budgetMB, int64(mb))
+	startMonitor.Do(func() { go monitor() })
+	// collect garbage earlier to keep the heap small
+	debug.SetGCPercent(50)
+	log.Printf("[info] Limiting memory to %dMB, using %d workers", mb, Workers(runtime.NumCPU()))
+}
+
+// Limited returns whether a memory budget is set.
+func Limited() bool {
+	return atomic.LoadInt64(&budgetMB) > 0
+}
+
+// Scale returns n (a buffer size or capacity) reduced by the ratio of the
+// budget to the memory the defaults are made for. Returns n if no budget is
+// set and at least 1.
+func Scale(n int) int {
+	mb := atomic.LoadInt64(&budgetMB)
+	if mb <= 0 || mb >= referenceMB || n <= 0 {
+		return n
+	}
+	scaled := int(int64(n) * mb / referenceMB)
+	if scaled < 1 {
+		return 1
+	}
+	return scaled
+}
+
+// Workers returns the number of workers, up to n, that fit into half of
+// the budget. The other half is left for the caches and buffers. Returns n
+// if no budget is set and at least 1.
+func Workers(n int) int {
+	mb := atomic.LoadInt64(&budgetMB)
+	if mb <= 0 {
+		return n
+	}
+	workers := int(mb / 2 / workerMB)
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		return n
+	}
+	return workers
+}
+
+// Backoff blocks while the resident memory is close to the budget, but no
+// longer than maxBackoff. Workers should call Backoff before they process
+// the next element or batch of elements.
+func Backoff() {
+	if atomic.LoadInt32(&pressure) == 0 {
+		return
+	}
+	deadline := time.Now().Add(maxBackoff)
+	for atomic.LoadInt32(&pressure) != 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func monitor() {
+	for ; ; time.Sleep(time.Second) {
+		mb := atomic.LoadInt64(&budgetMB)
+		if mb <= 0 {
+			atomic.StoreInt32(&pressure, 0)
+			continue
+		}
+		rss, err := residentMemory()
+		if err != nil {
+			log.Println("[warn] Unable to monitor memory: ", err)
+			return
+		}
+		limit := mb * 1024 * 1024
+		if float64(rss) > highWater*float64(limit) {
+			if atomic.SwapInt32(&pressure, 1) == 0 {
+				log.Printf("[warn] Memory usage of %dMB is close to -max-memory, backing off", rss/1024/1024)
+			}
+			debug.FreeOSMemory()
+		} else if float64(rss) < lowWater*float64(limit) {
+			atomic.StoreInt32(&pressure, 0)
+		}
+	}
+}
+
+// residentMemory returns the resident memory of the process in bytes. Falls
+// back to the memory obtained by the Go runtime if /proc is not available.
+func residentMemory() (int64, error) {
+	b, err := ioutil.ReadFile("/proc/self/statm")
+	if os.IsNotExist(err) {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		return int64(stats.Sys), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return parseStatm(b, os.Getpagesize())
+}
+
+// parseStatm returns the resident memory of /proc/self/statm in bytes.
+func parseStatm(b []byte, pageSize int) (int64, error) {
+	fields := bytes.Fields(b)
+	if len(fields) < 2 {
+		return 0, strconv.ErrSyntax
+	}
+	pages, err := strconv.ParseInt(string(fields[1]), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return pages * int64(pageSize), nil
+}
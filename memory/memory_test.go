@@ -0,0 +1,85 @@
+package memory
+
+import "testing"
+
+func TestScale(t *testing.T) {
+	defer SetBudget(0)
+	if n := Scale(64); n != 64 {
+		t.Errorf("unexpected scale without budget %d", n)
+	}
+	SetBudget(4096)
+	for _, tc := range []struct {
+		n, expected int
+	}{
+		{64, 16},
+		{8096, 2024},
+		{2, 1},
+		{0, 0},
+	} {
+		if n := Scale(tc.n); n != tc.expected {
+			t.Errorf("Scale(%d) = %d, expected %d", tc.n, n, tc.expected)
+		}
+	}
+	SetBudget(32 * 1024)
+	if n := Scale(64); n != 64 {
+		t.Errorf("unexpected scale for large budget %d", n)
+	}
+}
+
+func TestWorkers(t *testing.T) {
+	defer SetBudget(0)
+	if n := Workers(16); n != 16 {
+		t.Errorf("unexpected workers without budget %d", n)
+	}
+	SetBudget(4096)
+	if n := Workers(16); n != 4 {
+		t.Errorf("unexpected workers %d", n)
+	}
+	if n := Workers(2); n != 2 {
+		t.Errorf("unexpected workers %d", n)
+	}
+	SetBudget(512)
+	if n := Workers(16); n != 1 {
+		t.Errorf("unexpected workers %d", n)
+	}
+}
+
+func TestParseStatm(t *testing.T) {
+	rss, err := parseStatm([]byte("12345 2048 300 10 0 500 0\n"), 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rss != 2048*4096 {
+		t.Errorf("unexpected rss %d", rss)
+	}
+	if _, err := parseStatm([]byte(""), 4096); err == nil {
+		t.Error("expected error for empty statm")
+	}
+}
+
+func TestBuffers(t *testing.T) {
+	defer SetBudget(0)
+	defer SetBuffers(Buffers{})
+	if n := ReadBuffer(); n != 4 {
+		t.Errorf("unexpected default read buffer %d", n)
+	}
+	if n := CacheBuffer(0); n != 0 {
+		t.Errorf("unexpected default cache buffer %d", n)
+	}
+	SetBudget(4096)
+	if n := DatabaseBuffer(); n != 16 {
+		t.Errorf("unexpected scaled database buffer %d", n)
+	}
+
+	// configured sizes are not scaled
+	SetBuffers(Buffers{Read: 16, Cache: 4096, Database: 1024})
+	if n := ReadBuffer(); n != 16 {
+		t.Errorf("unexpected read buffer %d", n)
+	}
+	if n := CacheBuffer(1024); n != 4096 {
+		t.Errorf("unexpected cache buffer %d", n)
+	}
+	if n := DatabaseBuffer(); n != 1024 {
+		t.Errorf("unexpected database buffer %d", n)
+	}
+}
@@ -0,0 +1,347 @@
+package notify
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// amqpPublisher publishes summaries with AMQP 0-9-1. The channel is in
+// confirm mode, so Publish returns after the broker acknowledged the
+// message. Messages are persistent.
+type amqpPublisher struct {
+	addr       string
+	user       string
+	pass       string
+	vhost      string
+	exchange   string
+	routingKey string
+}
+
+const (
+	amqpFrameMethod    = 1
+	amqpFrameHeader    = 2
+	amqpFrameBody      = 3
+	amqpFrameHeartbeat = 8
+	amqpFrameEnd       = 0xce
+
+	amqpDefaultFrameMax = 131072
+)
+
+// amqpMethod identifies a method by class and method id.
+type amqpMethod struct {
+	class, method uint16
+}
+
+var (
+	connectionStart   = amqpMethod{10, 10}
+	connectionStartOk = amqpMethod{10, 11}
+	connectionTune    = amqpMethod{10, 30}
+	connectionTuneOk  = amqpMethod{10, 31}
+	connectionOpen    = amqpMethod{10, 40}
+	connectionOpenOk  = amqpMethod{10, 41}
+	connectionClose   = amqpMethod{10, 50}
+	connectionCloseOk = amqpMethod{10, 51}
+	channelOpen       = amqpMethod{20, 10}
+	channelOpenOk     = amqpMethod{20, 11}
+	channelClose      = amqpMethod{20, 40}
+	basicPublish      = amqpMethod{60, 40}
+	basicAck          = amqpMethod{60, 80}
+	basicNack         = amqpMethod{60, 120}
+	confirmSelect     = amqpMethod{85, 10}
+	confirmSelectOk   = amqpMethod{85, 11}
+)
+
+var amqpProtocolHeader = []byte("AMQP\x00\x00\x09\x01")
+
+// amqpEncoder encodes the arguments of methods and content headers.
+type amqpEncoder struct {
+	bytes.Buffer
+}
+
+func (e *amqpEncoder) octet(v uint8) { e.WriteByte(v) }
+
+func (e *amqpEncoder) short(v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	e.Write(b[:])
+}
+
+func (e *amqpEncoder) long(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.Write(b[:])
+}
+
+func (e *amqpEncoder) longlong(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	e.Write(b[:])
+}
+
+func (e *amqpEncoder) shortstr(s string) {
+	e.octet(uint8(len(s)))
+	e.WriteString(s)
+}
+
+func (e *amqpEncoder) longstr(s string) {
+	e.long(uint32(len(s)))
+	e.WriteString(s)
+}
+
+// table encodes a field table with string values.
+func (e *amqpEncoder) table(fields map[string]string) {
+	t := amqpEncoder{}
+	for k, v := range fields {
+		t.shortstr(k)
+		t.octet('S')
+		t.longstr(v)
+	}
+	e.long(uint32(t.Len()))
+	e.Write(t.Bytes())
+}
+
+// amqpDecoder decodes the arguments of methods. Reads past the end return
+// zero values.
+type amqpDecoder struct {
+	buf []byte
+}
+
+func (d *amqpDecoder) next(n int) []byte {
+	if n > len(d.buf) {
+		d.buf = nil
+		return make([]byte, n)
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *amqpDecoder) short() uint16 { return binary.BigEndian.Uint16(d.next(2)) }
+func (d *amqpDecoder) long() uint32  { return binary.BigEndian.Uint32(d.next(4)) }
+
+func (d *amqpDecoder) shortstr() string {
+	return string(d.next(int(d.next(1)[0])))
+}
+
+// amqpConn reads and writes frames.
+type amqpConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *amqpConn) writeFrame(typ uint8, channel uint16, payload []byte) error {
+	f := amqpEncoder{}
+	f.octet(typ)
+	f.short(channel)
+	f.long(uint32(len(payload)))
+	f.Write(payload)
+	f.octet(amqpFrameEnd)
+	_, err := c.conn.Write(f.Bytes())
+	return err
+}
+
+func (c *amqpConn) writeMethod(channel uint16, m amqpMethod, args func(*amqpEncoder)) error {
+	e := amqpEncoder{}
+	e.short(m.class)
+	e.short(m.method)
+	if args != nil {
+		args(&e)
+	}
+	return c.writeFrame(amqpFrameMethod, channel, e.Bytes())
+}
+
+func (c *amqpConn) readFrame() (uint8, uint16, []byte, error) {
+	var hdr [7]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(hdr[3:])+1)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	if payload[len(payload)-1] != amqpFrameEnd {
+		return 0, 0, nil, errors.New("invalid frame end")
+	}
+	return hdr[0], binary.BigEndian.Uint16(hdr[1:]), payload[:len(payload)-1], nil
+}
+
+// readMethod reads the next method. Heartbeats are skipped.
+func (c *amqpConn) readMethod() (amqpMethod, *amqpDecoder, error) {
+	for {
+		typ, _, payload, err := c.readFrame()
+		if err != nil {
+			return amqpMethod{}, nil, err
+		}
+		if typ == amqpFrameHeartbeat {
+			continue
+		}
+		if typ != amqpFrameMethod || len(payload) < 4 {
+			return amqpMethod{}, nil, errors.Errorf("unexpected frame type %d", typ)
+		}
+		d := &amqpDecoder{buf: payload}
+		m := amqpMethod{d.short(), d.short()}
+		return m, d, nil
+	}
+}
+
+// expect reads the next method and returns an error if it is not
+// expected. Returns the reason if the broker closed the channel or the
+// connection.
+func (c *amqpConn) expect(expected amqpMethod) (*amqpDecoder, error) {
+	m, d, err := c.readMethod()
+	if err != nil {
+		return nil, err
+	}
+	if m == expected {
+		return d, nil
+	}
+	if m == connectionClose || m == channelClose {
+		code := d.short()
+		return nil, errors.Errorf("closed by broker: %d %s", code, d.shortstr())
+	}
+	return nil, errors.Errorf("unexpected method %d.%d, expected %d.%d", m.class, m.method, expected.class, expected.method)
+}
+
+func (p *amqpPublisher) Publish(s Summary) error {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", p.addr, timeout)
+	if err != nil {
+		return errors.Wrapf(err, "connecting to amqp broker %s", p.addr)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	c := &amqpConn{conn: conn, r: bufio.NewReader(conn)}
+	if err := p.publish(c, body); err != nil {
+		return errors.Wrapf(err, "publishing to amqp broker %s", p.addr)
+	}
+	return nil
+}
+
+func (p *amqpPublisher) publish(c *amqpConn, body []byte) error {
+	if _, err := c.conn.Write(amqpProtocolHeader); err != nil {
+		return err
+	}
+	if _, err := c.expect(connectionStart); err != nil {
+		return err
+	}
+	err := c.writeMethod(0, connectionStartOk, func(e *amqpEncoder) {
+		e.table(map[string]string{"product": "imposm3"})
+		e.shortstr("PLAIN")
+		e.longstr("\x00" + p.user + "\x00" + p.pass)
+		e.shortstr("en_US")
+	})
+	if err != nil {
+		return err
+	}
+	d, err := c.expect(connectionTune)
+	if err != nil {
+		return err
+	}
+	channelMax, frameMax := d.short(), d.long()
+	if frameMax == 0 {
+		frameMax = amqpDefaultFrameMax
+	}
+	err = c.writeMethod(0, connectionTuneOk, func(e *amqpEncoder) {
+		e.short(channelMax)
+		e.long(frameMax)
+		e.short(0) // no heartbeats
+	})
+	if err != nil {
+		return err
+	}
+	err = c.writeMethod(0, connectionOpen, func(e *amqpEncoder) {
+		e.shortstr(p.vhost)
+		e.shortstr("")
+		e.octet(0)
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := c.expect(connectionOpenOk); err != nil {
+		return err
+	}
+
+	const channel = 1
+	if err := c.writeMethod(channel, channelOpen, func(e *amqpEncoder) { e.shortstr("") }); err != nil {
+		return err
+	}
+	if _, err := c.expect(channelOpenOk); err != nil {
+		return err
+	}
+	if err := c.writeMethod(channel, confirmSelect, func(e *amqpEncoder) { e.octet(0) }); err != nil {
+		return err
+	}
+	if _, err := c.expect(confirmSelectOk); err != nil {
+		return err
+	}
+
+	err = c.writeMethod(channel, basicPublish, func(e *amqpEncoder) {
+		e.short(0)
+		e.shortstr(p.exchange)
+		e.shortstr(p.routingKey)
+		e.octet(0) // not mandatory, not immediate
+	})
+	if err != nil {
+		return err
+	}
+	hdr := amqpEncoder{}
+	hdr.short(basicPublish.class)
+	hdr.short(0)
+	hdr.longlong(uint64(len(body)))
+	hdr.short(0x8000 | 0x1000) // content-type, delivery-mode
+	hdr.shortstr("application/json")
+	hdr.octet(2) // persistent
+	if err := c.writeFrame(amqpFrameHeader, channel, hdr.Bytes()); err != nil {
+		return err
+	}
+	maxBody := int(frameMax) - 8
+	for len(body) > 0 {
+		n := len(body)
+		if n > maxBody {
+			n = maxBody
+		}
+		if err := c.writeFrame(amqpFrameBody, channel, body[:n]); err != nil {
+			return err
+		}
+		body = body[n:]
+	}
+
+	m, d, err := c.readMethod()
+	if err != nil {
+		return err
+	}
+	switch m {
+	case basicAck:
+	case basicNack:
+		return errors.New("message was rejected by broker")
+	case connectionClose, channelClose:
+		code := d.short()
+		return errors.Errorf("closed by broker: %d %s", code, d.shortstr())
+	default:
+		return errors.Errorf("unexpected method %d.%d, expected ack", m.class, m.method)
+	}
+
+	err = c.writeMethod(0, connectionClose, func(e *amqpEncoder) {
+		e.short(200)
+		e.shortstr("")
+		e.short(0)
+		e.short(0)
+	})
+	if err != nil {
+		return err
+	}
+	// the message is confirmed, errors of the close are not important
+	c.expect(connectionCloseOk)
+	return nil
+}
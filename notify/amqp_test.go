@@ -0,0 +1,168 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+type amqpMessage struct {
+	vhost, exchange, routingKey string
+	body                        string
+}
+
+// fakeAMQP accepts a single connection and sends the published message to
+// msgs. It closes the channel instead of sending an ack, if reject is set.
+// The frame max is small, to receive bodies in multiple frames.
+func fakeAMQP(t *testing.T, reject bool) (string, chan amqpMessage) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := make(chan amqpMessage, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		c := &amqpConn{conn: conn, r: bufio.NewReader(conn)}
+		hdr := make([]byte, 8)
+		if _, err := io.ReadFull(c.r, hdr); err != nil || string(hdr) != string(amqpProtocolHeader) {
+			t.Errorf("unexpected protocol header %q", hdr)
+			return
+		}
+		expect := func(m amqpMethod) *amqpDecoder {
+			d, err := c.expect(m)
+			if err != nil {
+				t.Error(err)
+			}
+			return d
+		}
+		c.writeMethod(0, connectionStart, func(e *amqpEncoder) {
+			e.octet(0)
+			e.octet(9)
+			e.table(nil)
+			e.longstr("PLAIN")
+			e.longstr("en_US")
+		})
+		d := expect(connectionStartOk)
+		if d == nil {
+			return
+		}
+		d.next(int(d.long())) // client properties
+		if mech := d.shortstr(); mech != "PLAIN" {
+			t.Errorf("unexpected mechanism %s", mech)
+		}
+		if auth := string(d.next(int(d.long()))); auth != "\x00guest\x00guest" {
+			t.Errorf("unexpected auth %q", auth)
+		}
+		c.writeMethod(0, connectionTune, func(e *amqpEncoder) {
+			e.short(2047)
+			e.long(64)
+			e.short(60)
+		})
+		if d = expect(connectionTuneOk); d == nil {
+			return
+		}
+		msg := amqpMessage{}
+		if d = expect(connectionOpen); d == nil {
+			return
+		}
+		msg.vhost = d.shortstr()
+		c.writeMethod(0, connectionOpenOk, func(e *amqpEncoder) { e.shortstr("") })
+		if expect(channelOpen) == nil {
+			return
+		}
+		c.writeMethod(1, channelOpenOk, func(e *amqpEncoder) { e.longstr("") })
+		if expect(confirmSelect) == nil {
+			return
+		}
+		c.writeMethod(1, confirmSelectOk, nil)
+		if d = expect(basicPublish); d == nil {
+			return
+		}
+		d.short()
+		msg.exchange = d.shortstr()
+		msg.routingKey = d.shortstr()
+
+		typ, _, payload, err := c.readFrame()
+		if err != nil || typ != amqpFrameHeader {
+			t.Errorf("expected content header, got %d %v", typ, err)
+			return
+		}
+		d = &amqpDecoder{buf: payload}
+		d.short()
+		d.short()
+		size := int(d.long())<<32 | int(d.long())
+		for len(msg.body) < size {
+			typ, _, payload, err := c.readFrame()
+			if err != nil || typ != amqpFrameBody {
+				t.Errorf("expected body, got %d %v", typ, err)
+				return
+			}
+			if len(payload) > 64-8 {
+				t.Errorf("body frame larger than frame max")
+			}
+			msg.body += string(payload)
+		}
+		if reject {
+			c.writeMethod(1, channelClose, func(e *amqpEncoder) {
+				e.short(404)
+				e.shortstr("NOT_FOUND - no exchange")
+				e.short(60)
+				e.short(40)
+			})
+			return
+		}
+		c.writeMethod(1, basicAck, func(e *amqpEncoder) {
+			e.long(0)
+			e.long(1)
+			e.octet(0)
+		})
+		msgs <- msg
+		if expect(connectionClose) != nil {
+			c.writeMethod(0, connectionCloseOk, nil)
+		}
+	}()
+	return l.Addr().String(), msgs
+}
+
+func TestAMQPPublish(t *testing.T) {
+	addr, msgs := fakeAMQP(t, false)
+	p, err := New("amqp://" + addr + "/osm?exchange=amq.topic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := map[string]TableCounts{"osm_roads": {Inserts: 12, Deletes: 3}}
+	if err := p.Publish(Summary{Sequence: 42, Tables: tables}); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-msgs
+	if msg.vhost != "osm" || msg.exchange != "amq.topic" || msg.routingKey != "imposm.diffs" {
+		t.Errorf("unexpected message %v", msg)
+	}
+	var s Summary
+	if err := json.Unmarshal([]byte(msg.body), &s); err != nil {
+		t.Fatal(err, msg.body)
+	}
+	if s.Sequence != 42 || s.Tables["osm_roads"] != tables["osm_roads"] {
+		t.Errorf("unexpected summary %v", s)
+	}
+}
+
+func TestAMQPPublishError(t *testing.T) {
+	addr, _ := fakeAMQP(t, true)
+	p, err := New("amqp://" + addr + "?exchange=missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Publish(Summary{})
+	if err == nil || !strings.Contains(err.Error(), "404 NOT_FOUND") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
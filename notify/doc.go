@@ -0,0 +1,13 @@
+/*
+Package notify publishes a summary of each diff import to a message broker,
+so that dependent services can refresh their data without polling the
+database.
+
+The summary is a JSON message with the sequence of the diff, the number of
+created, modified and deleted elements, the inserted and deleted rows of
+each table and the bounding box of all changes. It is published to a NATS
+subject or an AMQP 0-9-1 exchange (e.g. RabbitMQ). The package contains
+minimal clients for both protocols. Each summary is published with a new
+connection, as diff imports run at most once a minute.
+*/
+package notify
@@ -0,0 +1,86 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// natsPublisher publishes summaries with the text protocol of NATS. The
+// PING after the PUB is answered with a PONG after the server processed
+// the message, or with an -ERR.
+type natsPublisher struct {
+	addr    string
+	subject string
+	user    string
+	pass    string
+	token   string
+}
+
+type natsConnect struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Name     string `json:"name"`
+	Lang     string `json:"lang"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+}
+
+func (p *natsPublisher) Publish(s Summary) error {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	connect, err := json.Marshal(natsConnect{
+		Name: "imposm3", Lang: "go",
+		User: p.user, Pass: p.pass, Token: p.token,
+	})
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", p.addr, timeout)
+	if err != nil {
+		return errors.Wrapf(err, "connecting to nats server %s", p.addr)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	r := bufio.NewReader(conn)
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return errors.Wrapf(err, "reading INFO from nats server %s", p.addr)
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return errors.Errorf("unexpected greeting from nats server %s: %q", p.addr, strings.TrimSpace(line))
+	}
+
+	msg := fmt.Sprintf("CONNECT %s\r\nPUB %s %d\r\n%s\r\nPING\r\n", connect, p.subject, len(payload), payload)
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		return errors.Wrapf(err, "publishing to nats server %s", p.addr)
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return errors.Wrapf(err, "waiting for PONG from nats server %s", p.addr)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := conn.Write([]byte("PONG\r\n")); err != nil {
+				return errors.Wrapf(err, "sending PONG to nats server %s", p.addr)
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.Errorf("nats server %s: %s", p.addr, strings.TrimSpace(line[4:]))
+		}
+		// ignore +OK and INFO updates
+	}
+}
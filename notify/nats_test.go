@@ -0,0 +1,85 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeNATS accepts a single connection and sends the published payload
+// to msgs. It answers with err instead of PONG, if set.
+func fakeNATS(t *testing.T, err string) (string, chan string) {
+	l, lerr := net.Listen("tcp", "127.0.0.1:0")
+	if lerr != nil {
+		t.Fatal(lerr)
+	}
+	msgs := make(chan string, 1)
+	go func() {
+		defer l.Close()
+		conn, aerr := l.Accept()
+		if aerr != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {\"server_id\":\"fake\"}\r\n"))
+		r := bufio.NewReader(conn)
+		for {
+			line, rerr := r.ReadString('\n')
+			if rerr != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch fields[0] {
+			case "PUB":
+				if fields[1] != "imposm.test" {
+					t.Errorf("unexpected subject %s", fields[1])
+				}
+				n, _ := strconv.Atoi(fields[2])
+				payload := make([]byte, n+2)
+				io.ReadFull(r, payload)
+				msgs <- string(payload[:n])
+			case "PING":
+				if err != "" {
+					conn.Write([]byte("-ERR '" + err + "'\r\n"))
+				} else {
+					conn.Write([]byte("PONG\r\n"))
+				}
+			}
+		}
+	}()
+	return l.Addr().String(), msgs
+}
+
+func TestNATSPublish(t *testing.T) {
+	addr, msgs := fakeNATS(t, "")
+	p, err := New("nats://" + addr + "?subject=imposm.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Publish(Summary{Sequence: 42, Created: 3}); err != nil {
+		t.Fatal(err)
+	}
+	var s Summary
+	if err := json.Unmarshal([]byte(<-msgs), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Sequence != 42 || s.Created != 3 {
+		t.Errorf("unexpected summary %v", s)
+	}
+}
+
+func TestNATSPublishError(t *testing.T) {
+	addr, _ := fakeNATS(t, "Permissions Violation")
+	p, err := New("nats://" + addr + "?subject=imposm.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Publish(Summary{})
+	if err == nil || !strings.Contains(err.Error(), "Permissions Violation") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
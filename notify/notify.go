@@ -0,0 +1,177 @@
+package notify
+
+import (
+	"math"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultSubject = "imposm.diffs"
+	timeout        = 30 * time.Second
+)
+
+// Summary is the message that is published after each diff import.
+type Summary struct {
+	// Sequence is the sequence of the last imported diff, 0 if unknown.
+	Sequence  int        `json:"sequence,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Created   int64      `json:"created"`
+	Modified  int64      `json:"modified"`
+	Deleted   int64      `json:"deleted"`
+	// Tables are the inserted and deleted rows of each changed table.
+	// Updated rows are deleted and inserted.
+	Tables map[string]TableCounts `json:"tables"`
+	// BBox is the extent of all changes as minx, miny, maxx, maxy in
+	// EPSG:4326, nil if nothing changed.
+	BBox *[4]float64 `json:"bbox"`
+}
+
+// TableCounts are the changed rows of a table.
+type TableCounts struct {
+	Inserts int64 `json:"inserts"`
+	Deletes int64 `json:"deletes"`
+}
+
+// Publisher publishes summaries to a message broker.
+type Publisher interface {
+	Publish(Summary) error
+}
+
+// New returns the publisher for a nats:// or amqp:// URL. It does not
+// connect to the broker.
+//
+//	nats://[user:password@]host[:4222][?subject=imposm.diffs]
+//	amqp://[user:password@]host[:5672][/vhost][?exchange=&routing_key=imposm.diffs]
+func New(rawurl string) (Publisher, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing notify URL %q", rawurl)
+	}
+	if u.Host == "" {
+		return nil, errors.Errorf("missing host in notify URL %q", rawurl)
+	}
+	q := u.Query()
+	switch u.Scheme {
+	case "nats":
+		p := &natsPublisher{
+			addr:    hostPort(u, "4222"),
+			subject: q.Get("subject"),
+		}
+		if p.subject == "" {
+			p.subject = defaultSubject
+		}
+		if u.User != nil {
+			if pass, ok := u.User.Password(); ok {
+				p.user, p.pass = u.User.Username(), pass
+			} else {
+				p.token = u.User.Username()
+			}
+		}
+		return p, nil
+	case "amqp":
+		p := &amqpPublisher{
+			addr:       hostPort(u, "5672"),
+			user:       "guest",
+			pass:       "guest",
+			vhost:      "/",
+			exchange:   q.Get("exchange"),
+			routingKey: q.Get("routing_key"),
+		}
+		if u.User != nil {
+			p.user = u.User.Username()
+			p.pass, _ = u.User.Password()
+		}
+		if len(u.Path) > 1 {
+			p.vhost = u.Path[1:]
+		}
+		if p.routingKey == "" {
+			p.routingKey = defaultSubject
+		}
+		return p, nil
+	default:
+		return nil, errors.Errorf("unsupported notify URL %q, expected nats:// or amqp://", rawurl)
+	}
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
+
+// Changes collects the changed rows and the extent of a diff import. It
+// implements expire.Expireor to collect the extent.
+type Changes struct {
+	mu     sync.Mutex
+	tables map[string]TableCounts
+	bbox   [4]float64
+}
+
+// NewChanges returns an empty collection of changes.
+func NewChanges() *Changes {
+	c := &Changes{tables: make(map[string]TableCounts)}
+	c.bbox = [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	return c
+}
+
+// Inserted counts an inserted row of table.
+func (c *Changes) Inserted(table string) {
+	c.mu.Lock()
+	tc := c.tables[table]
+	tc.Inserts++
+	c.tables[table] = tc
+	c.mu.Unlock()
+}
+
+// Deleted counts a deleted row of table.
+func (c *Changes) Deleted(table string) {
+	c.mu.Lock()
+	tc := c.tables[table]
+	tc.Deletes++
+	c.tables[table] = tc
+	c.mu.Unlock()
+}
+
+func (c *Changes) Expire(long, lat float64) {
+	c.mu.Lock()
+	c.extend(long, lat)
+	c.mu.Unlock()
+}
+
+func (c *Changes) ExpireNodes(nodes []osm.Node, closed bool) {
+	c.mu.Lock()
+	for _, nd := range nodes {
+		c.extend(nd.Long, nd.Lat)
+	}
+	c.mu.Unlock()
+}
+
+func (c *Changes) extend(long, lat float64) {
+	c.bbox[0] = math.Min(c.bbox[0], long)
+	c.bbox[1] = math.Min(c.bbox[1], lat)
+	c.bbox[2] = math.Max(c.bbox[2], long)
+	c.bbox[3] = math.Max(c.bbox[3], lat)
+}
+
+// Summary returns a summary with the changed tables and the extent of all
+// changes.
+func (c *Changes) Summary() Summary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := Summary{Tables: make(map[string]TableCounts, len(c.tables))}
+	for table, tc := range c.tables {
+		s.Tables[table] = tc
+	}
+	if c.bbox[0] <= c.bbox[2] {
+		bbox := c.bbox
+		s.BBox = &bbox
+	}
+	return s
+}
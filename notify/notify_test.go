@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"reflect"
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+)
+
+func TestNew(t *testing.T) {
+	for _, test := range []struct {
+		url       string
+		publisher Publisher
+	}{
+		{"nats://localhost", &natsPublisher{addr: "localhost:4222", subject: "imposm.diffs"}},
+		{"nats://u:p@localhost:4333?subject=osm", &natsPublisher{addr: "localhost:4333", subject: "osm", user: "u", pass: "p"}},
+		{"nats://secret@localhost", &natsPublisher{addr: "localhost:4222", subject: "imposm.diffs", token: "secret"}},
+		{"amqp://localhost", &amqpPublisher{addr: "localhost:5672", user: "guest", pass: "guest", vhost: "/", routingKey: "imposm.diffs"}},
+		{"amqp://u:p@localhost/osm?exchange=amq.topic&routing_key=diffs",
+			&amqpPublisher{addr: "localhost:5672", user: "u", pass: "p", vhost: "osm", exchange: "amq.topic", routingKey: "diffs"}},
+		{"amqp://localhost/%2f", &amqpPublisher{addr: "localhost:5672", user: "guest", pass: "guest", vhost: "/", routingKey: "imposm.diffs"}},
+	} {
+		t.Run(test.url, func(t *testing.T) {
+			p, err := New(test.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p, test.publisher) {
+				t.Errorf("unexpected publisher %#v, expected %#v", p, test.publisher)
+			}
+		})
+	}
+
+	for _, url := range []string{"http://localhost", "nats://", "localhost:4222"} {
+		if _, err := New(url); err == nil {
+			t.Errorf("expected error for %q", url)
+		}
+	}
+}
+
+func TestChanges(t *testing.T) {
+	c := NewChanges()
+	s := c.Summary()
+	if s.BBox != nil || len(s.Tables) != 0 {
+		t.Fatalf("unexpected summary without changes %v", s)
+	}
+
+	c.Inserted("osm_roads")
+	c.Inserted("osm_roads")
+	c.Deleted("osm_roads")
+	c.Deleted("osm_buildings")
+	c.Expire(8.2, 53.1)
+	c.ExpireNodes([]osm.Node{{Long: 8.5, Lat: 53.0}, {Long: 8.1, Lat: 53.4}}, false)
+
+	s = c.Summary()
+	if s.BBox == nil || *s.BBox != [4]float64{8.1, 53.0, 8.5, 53.4} {
+		t.Errorf("unexpected bbox %v", s.BBox)
+	}
+	expected := map[string]TableCounts{
+		"osm_roads":     {Inserts: 2, Deletes: 1},
+		"osm_buildings": {Deletes: 1},
+	}
+	if !reflect.DeepEqual(s.Tables, expected) {
+		t.Errorf("unexpected tables %v", s.Tables)
+	}
+}
@@ -0,0 +1,114 @@
+// Package priority throttles imposm with -low-priority, so that updates can
+// run on database servers that are shared with other services, like tile
+// renderers.
+//
+// Imposm lowers GOMAXPROCS and its nice value, and it limits the rows that
+// are written to the database and the bytes that are written to the cache
+// with token buckets.
+package priority
+
+import (
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/omniscale/imposm3/log"
+)
+
+// Rates are the limits of -low-priority, from the low_priority section of
+// the config file. Zero values use the defaults.
+type Rates struct {
+	// Rows is the number of rows that are inserted into or deleted from the
+	// database per second (default 2000).
+	Rows int `json:"rows,omitempty"`
+	// CacheMB is the number of MB that are written to the cache per second
+	// (default 20).
+	CacheMB int `json:"cache_mb,omitempty"`
+	// CPUs is the number of CPUs that are used (default a quarter of all
+	// CPUs).
+	CPUs int `json:"cpus,omitempty"`
+}
+
+const (
+	defaultRows    = 2000
+	defaultCacheMB = 20
+	// nice is the nice value of the process.
+	nice = 10
+)
+
+var rows, cache *bucket
+
+// SetLowPriority enables the limits. Needs to be called before the import
+// or update starts.
+func SetLowPriority(r Rates) {
+	if r.Rows <= 0 {
+		r.Rows = defaultRows
+	}
+	if r.CacheMB <= 0 {
+		r.CacheMB = defaultCacheMB
+	}
+	if r.CPUs <= 0 {
+		r.CPUs = runtime.NumCPU() / 4
+		if r.CPUs < 1 {
+			r.CPUs = 1
+		}
+	}
+	runtime.GOMAXPROCS(r.CPUs)
+	if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, nice); err != nil {
+		log.Printf("[warn] Unable to set nice value: %v", err)
+	}
+	rows = newBucket(float64(r.Rows))
+	cache = newBucket(float64(r.CacheMB) * 1024 * 1024)
+	log.Printf("[info] Low priority: using %d CPUs, limiting to %d rows/s and %dMB/s for the cache", r.CPUs, r.Rows, r.CacheMB)
+}
+
+// WaitRows blocks till n rows can be written to the database.
+func WaitRows(n int) {
+	rows.wait(n)
+}
+
+// WaitCache blocks till n bytes can be written to the cache.
+func WaitCache(n int) {
+	cache.wait(n)
+}
+
+// bucket is a token bucket that is refilled with rate tokens per second,
+// up to the tokens of one second. Callers can take more tokens than
+// available and they wait till the bucket is refilled, so that large
+// batches are also limited.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+	sleep  func(time.Duration)
+}
+
+func newBucket(rate float64) *bucket {
+	return &bucket{rate: rate, tokens: rate, last: time.Now(), sleep: time.Sleep}
+}
+
+// wait takes n tokens from the bucket and blocks till the bucket has no
+// debt. A nil bucket does not block.
+func (b *bucket) wait(n int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	var d time.Duration
+	if b.tokens < 0 {
+		d = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+	if d > 0 {
+		b.sleep(d)
+	}
+}
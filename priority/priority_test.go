@@ -0,0 +1,37 @@
+package priority
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	var slept []time.Duration
+	b := newBucket(100)
+	b.sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	// full bucket
+	b.wait(100)
+	if len(slept) != 0 {
+		t.Fatal("unexpected wait", slept)
+	}
+
+	// empty bucket, needs to wait for 50 tokens
+	b.wait(50)
+	if len(slept) != 1 || slept[0] < 400*time.Millisecond || slept[0] > 500*time.Millisecond {
+		t.Fatal("unexpected wait", slept)
+	}
+
+	// the debt of the previous call adds to the wait
+	b.wait(100)
+	if len(slept) != 2 || slept[1] < 1400*time.Millisecond || slept[1] > 1500*time.Millisecond {
+		t.Fatal("unexpected wait", slept)
+	}
+}
+
+func TestNilBucket(t *testing.T) {
+	var b *bucket
+	b.wait(1000)
+	WaitRows(1000)
+	WaitCache(1000)
+}
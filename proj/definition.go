@@ -0,0 +1,308 @@
+package proj
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// definition is a coordinate reference system of a proj string.
+type definition struct {
+	// srid is the SRID of the projection, name is the name of an EPSG
+	// projection, or empty for other projections
+	srid int
+	name string
+	geog geographicCRS
+	// proj is longlat, merc, tmerc, lcc or laea
+	proj  string
+	ellps ellipsoid
+	// towgs84 is the transformation of the datum, or nil if the datum is
+	// used as WGS84
+	towgs84 *helmert
+
+	lon0, lat0, lat1, lat2 float64
+	k0, x0, y0             float64
+	// latTS is the latitude of true scale of merc, if set
+	latTS *float64
+	// area is the area of use in EPSG:4326 (min lon, min lat, max lon, max
+	// lat)
+	area [4]float64
+}
+
+// geographicCRS is the geographic coordinate reference system of a
+// definition. srid is 0 for unknown systems.
+type geographicCRS struct {
+	name, datum string
+	srid        int
+}
+
+var (
+	wgs84CRS   = geographicCRS{"WGS 84", "World Geodetic System 1984", 4326}
+	etrs89CRS  = geographicCRS{"ETRS89", "European Terrestrial Reference System 1989", 4258}
+	rgf93CRS   = geographicCRS{"RGF93 v1", "Reseau Geodesique Francais 1993 v1", 4171}
+	osgb36CRS  = geographicCRS{"OSGB36", "Ordnance Survey of Great Britain 1936", 4277}
+	unknownCRS = geographicCRS{"unknown", "unknown", 0}
+)
+
+// ellipsoids are the ellipsoids of +ellps=.
+var ellipsoids = map[string]ellipsoid{
+	"WGS84":  wgs84Ellipsoid,
+	"GRS80":  grs80,
+	"airy":   airy1830,
+	"bessel": {name: "Bessel 1841", a: 6377397.155, f: 1 / 299.1528128},
+	"clrk66": {name: "Clarke 1866", a: 6378206.4, f: 1 - 6356583.8/6378206.4},
+	"intl":   {name: "International 1924", a: 6378388, f: 1 / 297.0},
+	"krass":  {name: "Krassowsky 1940", a: 6378245, f: 1 / 298.3},
+}
+
+// datums are the datums of +datum=, with the transformation of PROJ.
+var datums = map[string]struct {
+	geog    geographicCRS
+	ellps   string
+	towgs84 *helmert
+}{
+	"WGS84":   {wgs84CRS, "WGS84", nil},
+	"NAD83":   {geographicCRS{"NAD83", "North American Datum 1983", 4269}, "GRS80", nil},
+	"OSGB36":  {osgb36CRS, "airy", &helmert{tx: 446.448, ty: -125.157, tz: 542.060, rx: 0.1502, ry: 0.2470, rz: 0.8421, s: -20.4894}},
+	"potsdam": {geographicCRS{"DHDN", "Deutsches Hauptdreiecksnetz", 4314}, "bessel", &helmert{tx: 598.1, ty: 73.7, tz: 418.2, rx: 0.202, ry: 0.045, rz: -2.455, s: 6.7}},
+}
+
+// parseDefinition parses a proj string. Supported are the projections
+// longlat, merc, tmerc, utm, lcc and laea (not in the polar aspects), the
+// ellipsoids and datums above or +a= with +rf=, +f= or +b=, and +towgs84=
+// with three or seven parameters. Only meters are supported as units.
+// Datums without +towgs84= are used as WGS84, like ETRS89 for GRS80.
+func parseDefinition(s string) (*definition, error) {
+	params := make(map[string]string)
+	for _, p := range strings.Fields(s) {
+		if !strings.HasPrefix(p, "+") {
+			return nil, fmt.Errorf("invalid parameter %q, expected +name=value", p)
+		}
+		kv := strings.SplitN(p[1:], "=", 2)
+		if len(kv) == 1 {
+			kv = append(kv, "")
+		}
+		params[kv[0]] = kv[1]
+	}
+
+	d := &definition{geog: unknownCRS, ellps: grs80, k0: 1}
+	var err error
+	num := func(name string, v *float64) {
+		s, ok := params[name]
+		delete(params, name)
+		if !ok || err != nil {
+			return
+		}
+		if *v, err = strconv.ParseFloat(s, 64); err != nil {
+			err = fmt.Errorf("invalid +%s=%s", name, s)
+		}
+	}
+
+	d.proj = params["proj"]
+	delete(params, "proj")
+	switch d.proj {
+	case "longlat", "latlong", "lonlat", "latlon":
+		d.proj = "longlat"
+	case "merc", "tmerc", "utm", "lcc", "laea":
+	case "":
+		return nil, fmt.Errorf("missing +proj=")
+	default:
+		return nil, fmt.Errorf("unsupported projection +proj=%s", d.proj)
+	}
+
+	if name, ok := params["datum"]; ok {
+		datum, ok := datums[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported datum +datum=%s", name)
+		}
+		d.geog, d.ellps, d.towgs84 = datum.geog, ellipsoids[datum.ellps], datum.towgs84
+		delete(params, "datum")
+	}
+	if name, ok := params["ellps"]; ok {
+		el, ok := ellipsoids[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported ellipsoid +ellps=%s", name)
+		}
+		d.ellps = el
+		delete(params, "ellps")
+	}
+	if _, ok := params["a"]; ok {
+		var rf, f, b float64
+		d.ellps = ellipsoid{name: "unknown"}
+		num("a", &d.ellps.a)
+		_, hasRf := params["rf"]
+		_, hasF := params["f"]
+		_, hasB := params["b"]
+		num("rf", &rf)
+		num("f", &f)
+		num("b", &b)
+		switch {
+		case hasRf && rf != 0:
+			d.ellps.f = 1 / rf
+		case hasF:
+			d.ellps.f = f
+		case hasB:
+			d.ellps.f = 1 - b/d.ellps.a
+		}
+	} else if _, ok := params["R"]; ok {
+		d.ellps = ellipsoid{name: "unknown"}
+		num("R", &d.ellps.a)
+	}
+	if s, ok := params["towgs84"]; ok {
+		delete(params, "towgs84")
+		if d.towgs84, err = parseToWGS84(s); err != nil {
+			return nil, err
+		}
+	}
+
+	num("lon_0", &d.lon0)
+	num("lat_0", &d.lat0)
+	num("lat_1", &d.lat1)
+	num("x_0", &d.x0)
+	num("y_0", &d.y0)
+	num("k", &d.k0)
+	num("k_0", &d.k0)
+	if _, ok := params["lat_2"]; ok {
+		num("lat_2", &d.lat2)
+	} else {
+		d.lat2 = d.lat1
+	}
+	if _, ok := params["lat_ts"]; ok {
+		d.latTS = new(float64)
+		num("lat_ts", d.latTS)
+	}
+	var zone float64
+	num("zone", &zone)
+	if err != nil {
+		return nil, err
+	}
+	_, south := params["south"]
+	delete(params, "south")
+
+	for name, v := range params {
+		switch {
+		case name == "units" && v == "m", name == "to_meter" && v == "1",
+			name == "nadgrids" && v == "@null", name == "pm" && (v == "0" || v == "greenwich"),
+			name == "no_defs", name == "type" && v == "crs", name == "wktext":
+		default:
+			if v != "" {
+				name += "=" + v
+			}
+			return nil, fmt.Errorf("unsupported parameter +%s", name)
+		}
+	}
+
+	if d.ellps.a <= 0 || d.ellps.f < 0 || d.ellps.f >= 1 {
+		return nil, fmt.Errorf("invalid ellipsoid")
+	}
+	switch d.proj {
+	case "utm":
+		if zone < 1 || zone > 60 || zone != float64(int(zone)) {
+			return nil, fmt.Errorf("invalid +zone=%v, expected 1-60", zone)
+		}
+		d.proj = "tmerc"
+		d.lon0, d.lat0 = zone*6-183, 0
+		d.k0, d.x0, d.y0 = 0.9996, 500000, 0
+		if south {
+			d.y0 = 10000000
+		}
+	case "lcc":
+		if d.lat1 == -d.lat2 {
+			return nil, fmt.Errorf("invalid standard parallels +lat_1=%v +lat_2=%v", d.lat1, d.lat2)
+		}
+		if d.k0 != 1 && (d.lat1 != d.lat2 || d.lat0 != d.lat1) {
+			return nil, fmt.Errorf("+k of lcc is only supported with +lat_0 = +lat_1 = +lat_2")
+		}
+	case "merc":
+		if d.latTS != nil {
+			if d.k0 != 1 {
+				return nil, fmt.Errorf("+k and +lat_ts are exclusive for merc")
+			}
+			d.k0 = ellipsoidScale(d.ellps, *d.latTS)
+		}
+	case "laea":
+		if d.lat0 == 90 || d.lat0 == -90 {
+			return nil, fmt.Errorf("polar aspect of laea is not supported")
+		}
+	}
+	d.area = d.areaOfUse(south)
+	return d, nil
+}
+
+// areaOfUse returns the area where the projection of the parameters is
+// used: the whole world for longlat and merc, the 6° wide zone around the
+// central meridian for tmerc (the hemisphere of south for UTM), 30° around
+// the center of laea and the standard parallels of lcc.
+func (d *definition) areaOfUse(south bool) [4]float64 {
+	clampLat := func(lat float64) float64 { return math.Max(-85, math.Min(85, lat)) }
+	switch d.proj {
+	case "tmerc":
+		if d.k0 == 0.9996 && d.lat0 == 0 && (d.y0 == 0 || d.y0 == 10000000) {
+			// UTM zone
+			if south || d.y0 == 10000000 {
+				return [4]float64{d.lon0 - 3, -80, d.lon0 + 3, 0}
+			}
+			return [4]float64{d.lon0 - 3, 0, d.lon0 + 3, 84}
+		}
+		return [4]float64{d.lon0 - 3, -80, d.lon0 + 3, 84}
+	case "laea":
+		return [4]float64{d.lon0 - 30, clampLat(d.lat0 - 30), d.lon0 + 30, clampLat(d.lat0 + 30)}
+	case "lcc":
+		lat1, lat2 := math.Min(d.lat1, d.lat2), math.Max(d.lat1, d.lat2)
+		return [4]float64{d.lon0 - 30, clampLat(lat1 - 15), d.lon0 + 30, clampLat(lat2 + 15)}
+	case "merc":
+		return [4]float64{-180, -80, 180, 84}
+	}
+	return [4]float64{-180, -90, 180, 90}
+}
+
+// parseToWGS84 parses the three or seven parameters of +towgs84=. Returns
+// nil if all parameters are 0.
+func parseToWGS84(s string) (*helmert, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 && len(parts) != 7 {
+		return nil, fmt.Errorf("invalid +towgs84=%s, expected three or seven values", s)
+	}
+	var v [7]float64
+	zero := true
+	for i, p := range parts {
+		var err error
+		if v[i], err = strconv.ParseFloat(p, 64); err != nil {
+			return nil, fmt.Errorf("invalid +towgs84=%s", s)
+		}
+		zero = zero && v[i] == 0
+	}
+	if zero {
+		return nil, nil
+	}
+	return &helmert{tx: v[0], ty: v[1], tz: v[2], rx: v[3], ry: v[4], rz: v[5], s: v[6]}, nil
+}
+
+// ellipsoidScale returns the scale factor of the ellipsoid at the latitude
+// of true scale.
+func ellipsoidScale(el ellipsoid, latTS float64) float64 {
+	es := el.e() * math.Sin(latTS*deg)
+	return math.Cos(latTS*deg) / math.Sqrt(1-es*es)
+}
+
+// projection returns the projection of the definition.
+func (d *definition) projection() Projection {
+	var p Projection
+	switch d.proj {
+	case "longlat":
+		p = wgs84{}
+	case "merc":
+		p = newMercator(d.ellps, d.lon0, d.k0, d.x0, d.y0)
+	case "tmerc":
+		p = newTransverseMercator(d.ellps, d.lon0, d.lat0, d.k0, d.x0, d.y0)
+	case "lcc":
+		p = newLambertConformalConic(d.ellps, d.lon0, d.lat0, d.lat1, d.lat2, d.k0, d.x0, d.y0)
+	case "laea":
+		p = newLambertAzimuthalEqualArea(d.ellps, d.lon0, d.lat0, d.x0, d.y0)
+	}
+	if d.towgs84 != nil {
+		return datumShift{Projection: p, ellipsoid: d.ellps, helmert: *d.towgs84}
+	}
+	return p
+}
@@ -0,0 +1,82 @@
+package proj
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseDefinition(t *testing.T) {
+	d, err := parseDefinition("+proj=utm +zone=33 +south +datum=WGS84 +units=m +no_defs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.proj != "tmerc" || d.lon0 != 15 || d.k0 != 0.9996 || d.y0 != 10000000 || d.ellps != wgs84Ellipsoid || d.towgs84 != nil {
+		t.Errorf("unexpected definition %#v", d)
+	}
+
+	d, err = parseDefinition("+proj=tmerc +lat_0=0 +lon_0=9 +k=1 +x_0=3500000 +y_0=0 +ellps=bessel +towgs84=598.1,73.7,418.2,0.202,0.045,-2.455,6.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.x0 != 3500000 || d.ellps.name != "Bessel 1841" || d.towgs84 == nil || d.towgs84.rz != -2.455 {
+		t.Errorf("unexpected definition %#v", d)
+	}
+
+	d, err = parseDefinition("+proj=merc +a=6378137 +b=6378137 +lat_ts=0 +lon_0=0 +x_0=0 +y_0=0 +k=1 +units=m +nadgrids=@null +wktext +no_defs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.ellps.f != 0 || d.k0 != 1 {
+		t.Errorf("unexpected definition %#v", d)
+	}
+
+	for _, def := range []string{
+		"",
+		"proj=utm",
+		"+proj=stere +lat_0=90",
+		"+proj=utm +zone=61",
+		"+proj=tmerc +ellps=foo",
+		"+proj=tmerc +datum=foo",
+		"+proj=tmerc +units=ft",
+		"+proj=tmerc +nadgrids=BETA2007.gsb",
+		"+proj=tmerc +lon_0=x",
+		"+proj=tmerc +towgs84=1,2",
+		"+proj=lcc +lat_1=10 +lat_2=20 +k=0.99",
+		"+proj=laea +lat_0=90",
+		"+proj=merc +lat_ts=10 +k=0.99",
+	} {
+		if _, err := parseDefinition(def); err == nil {
+			t.Errorf("expected error for %q", def)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	// DHDN / 3-degree Gauss-Kruger zone 3
+	if err := Register(31467, "+proj=tmerc +lat_0=0 +lon_0=9 +k=1 +x_0=3500000 +y_0=0 +datum=potsdam +units=m +no_defs"); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(projections, 31467)
+	defer delete(definitions, 31467)
+	if !Supported(31467) {
+		t.Fatal("31467 not supported after Register")
+	}
+	p := Lookup(31467)
+	// 9°E 50°N of WGS84 is about 75m east and 130m north on DHDN
+	// (5540279.54 without the datum shift)
+	x, y := p.Forward(9, 50)
+	if math.Abs(x-3500074.5) > 1 || math.Abs(y-5540407.1) > 1 {
+		t.Errorf("unexpected coordinate %v %v", x, y)
+	}
+	long, lat := p.Inverse(x, y)
+	if math.Abs(long-9) > 1e-7 || math.Abs(lat-50) > 1e-7 {
+		t.Errorf("unexpected round trip %v %v", long, lat)
+	}
+
+	if err := Register(1234, "+proj=stere"); err == nil {
+		t.Error("expected error for stere")
+	}
+	if Supported(1234) {
+		t.Error("1234 supported after failed Register")
+	}
+}
@@ -0,0 +1,470 @@
+package proj
+
+import (
+	"fmt"
+	"math"
+)
+
+// Projection transforms EPSG:4326 coordinates into a projected coordinate
+// system and back.
+type Projection interface {
+	Forward(long, lat float64) (x, y float64)
+	Inverse(x, y float64) (long, lat float64)
+}
+
+// Lookup returns the projection of the EPSG code, or nil if the projection
+// is not supported. Supported are EPSG:4326, EPSG:3857, the UTM zones of
+// WGS84 (32601-32660, 32701-32760) and ETRS89 (25828-25838), the French
+// Lambert-93 (2154), the British National Grid (27700), LAEA Europe (3035),
+// World Mercator (3395) and all projections added with Register.
+func Lookup(srid int) Projection {
+	return projections[srid]
+}
+
+// Supported returns true if Lookup returns a projection for srid.
+func Supported(srid int) bool {
+	return projections[srid] != nil
+}
+
+// Register adds the projection of a proj string (e.g. "+proj=tmerc
+// +lat_0=0 +lon_0=9 +k=1 +x_0=3500000 +y_0=0 +ellps=bessel
+// +towgs84=598.1,73.7,418.2,0.202,0.045,-2.455,6.7") for srid, or replaces
+// the built-in projection of srid. See parseDefinition for the supported
+// projections and parameters.
+func Register(srid int, def string) error {
+	d, err := parseDefinition(def)
+	if err != nil {
+		return fmt.Errorf("invalid projection of %d: %s", srid, err)
+	}
+	d.srid = srid
+	projections[srid] = d.projection()
+	definitions[srid] = d
+	return nil
+}
+
+var projections = map[int]Projection{
+	4326: wgs84{},
+	3857: webMercator{},
+}
+
+// definitions are the definitions of all projections except 4326 and
+// 3857, for the PROJJSON metadata.
+var definitions = map[int]*definition{}
+
+// builtin adds a projection of the EPSG code. area is the area of use
+// of the EPSG database (min lon, min lat, max lon, max lat), or empty for
+// the area of the projection parameters.
+func builtin(srid int, name string, geog geographicCRS, area [4]float64, def string) {
+	d, err := parseDefinition(def)
+	if err != nil {
+		panic(err)
+	}
+	d.srid, d.name, d.geog = srid, name, geog
+	if area != [4]float64{} {
+		d.area = area
+	}
+	projections[srid] = d.projection()
+	definitions[srid] = d
+}
+
+func init() {
+	// ETRS89 and RGF93 are used as WGS84
+	builtin(2154, "RGF93 v1 / Lambert-93", rgf93CRS, [4]float64{-9.86, 41.15, 10.38, 51.56},
+		"+proj=lcc +lat_0=46.5 +lon_0=3 +lat_1=49 +lat_2=44 +x_0=700000 +y_0=6600000 +ellps=GRS80 +units=m")
+	// British National Grid, on OSGB36 with a Helmert transformation
+	// (accurate to a few meters, without the OSTN15 grid)
+	builtin(27700, "OSGB36 / British National Grid", osgb36CRS, [4]float64{-9.01, 49.75, 2.01, 61.01},
+		"+proj=tmerc +lat_0=49 +lon_0=-2 +k=0.9996012717 +x_0=400000 +y_0=-100000 +datum=OSGB36 +units=m")
+	builtin(3035, "ETRS89-extended / LAEA Europe", etrs89CRS, [4]float64{-35.58, 24.6, 44.83, 84.73},
+		"+proj=laea +lat_0=52 +lon_0=10 +x_0=4321000 +y_0=3210000 +ellps=GRS80 +units=m")
+	builtin(3395, "WGS 84 / World Mercator", wgs84CRS, [4]float64{-180, -80, 180, 84},
+		"+proj=merc +lon_0=0 +k=1 +x_0=0 +y_0=0 +datum=WGS84 +units=m")
+	for zone := 1; zone <= 60; zone++ {
+		// the area of use of the UTM zones follows from the parameters
+		builtin(32600+zone, fmt.Sprintf("WGS 84 / UTM zone %dN", zone), wgs84CRS, [4]float64{},
+			fmt.Sprintf("+proj=utm +zone=%d +datum=WGS84 +units=m", zone))
+		builtin(32700+zone, fmt.Sprintf("WGS 84 / UTM zone %dS", zone), wgs84CRS, [4]float64{},
+			fmt.Sprintf("+proj=utm +zone=%d +south +datum=WGS84 +units=m", zone))
+		if zone >= 28 && zone <= 38 {
+			builtin(25800+zone, fmt.Sprintf("ETRS89 / UTM zone %dN", zone), etrs89CRS, [4]float64{},
+				fmt.Sprintf("+proj=utm +zone=%d +ellps=GRS80 +units=m", zone))
+		}
+	}
+}
+
+// Extent returns the bounds of srid for the area where the projection is
+// used, e.g. to partition data into a grid. The area is the area of use of
+// built-in EPSG projections, or the area that follows from the parameters
+// of proj strings (the zone of transverse Mercator projections, see
+// definition.areaOfUse). Returns false if srid is not supported.
+func Extent(srid int) ([4]float64, bool) {
+	switch srid {
+	case 4326:
+		return [4]float64{-180, -90, 180, 90}, true
+	case 3857:
+		const m = 20037508.342789244
+		return [4]float64{-m, -m, m, m}, true
+	}
+	d := definitions[srid]
+	if d == nil {
+		return [4]float64{}, false
+	}
+	return projectedBounds(projections[srid], d.area), true
+}
+
+// Bounds returns the bounds of the area (in EPSG:4326) in the projection of
+// srid, limited to the Extent of srid. Returns false if the projection is not
+// supported.
+func Bounds(srid int, area [4]float64) ([4]float64, bool) {
+	p := Lookup(srid)
+	extent, ok := Extent(srid)
+	if p == nil || !ok {
+		return [4]float64{}, false
+	}
+	b := projectedBounds(p, area)
+	return [4]float64{
+		math.Max(b[0], extent[0]), math.Max(b[1], extent[1]),
+		math.Min(b[2], extent[2]), math.Min(b[3], extent[3]),
+	}, true
+}
+
+// projectedBounds returns the bounds of the area (in EPSG:4326) in the
+// projection. The area is sampled with a grid, as the edges of the area
+// are curves in most projections.
+func projectedBounds(p Projection, area [4]float64) [4]float64 {
+	const steps = 32
+	b := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for i := 0; i <= steps; i++ {
+		for j := 0; j <= steps; j++ {
+			long := area[0] + (area[2]-area[0])*float64(i)/steps
+			lat := area[1] + (area[3]-area[1])*float64(j)/steps
+			x, y := p.Forward(long, lat)
+			if math.IsNaN(x) || math.IsNaN(y) || math.IsInf(x, 0) || math.IsInf(y, 0) {
+				continue
+			}
+			b[0], b[1] = math.Min(b[0], x), math.Min(b[1], y)
+			b[2], b[3] = math.Max(b[2], x), math.Max(b[3], y)
+		}
+	}
+	return b
+}
+
+type wgs84 struct{}
+
+func (wgs84) Forward(long, lat float64) (float64, float64) { return long, lat }
+func (wgs84) Inverse(x, y float64) (float64, float64)      { return x, y }
+
+type webMercator struct{}
+
+func (webMercator) Forward(long, lat float64) (float64, float64) { return WgsToMerc(long, lat) }
+func (webMercator) Inverse(x, y float64) (float64, float64)      { return MercToWgs(x, y) }
+
+// ellipsoid with the semi-major axis a and the flattening f.
+type ellipsoid struct {
+	name string
+	a, f float64
+}
+
+var (
+	wgs84Ellipsoid = ellipsoid{name: "WGS 84", a: 6378137, f: 1 / 298.257223563}
+	grs80          = ellipsoid{name: "GRS 1980", a: 6378137, f: 1 / 298.257222101}
+	airy1830       = ellipsoid{name: "Airy 1830", a: 6377563.396, f: 1 / 299.3249646}
+)
+
+// e returns the eccentricity.
+func (el ellipsoid) e() float64 {
+	return math.Sqrt(el.f * (2 - el.f))
+}
+
+const deg = math.Pi / 180
+
+// transverseMercator is the ellipsoidal transverse Mercator projection with
+// the Krüger series (to n³, accurate to millimeters within the UTM zones).
+type transverseMercator struct {
+	lon0, k0, x0, y0 float64
+	n, a             float64 // third flattening and rectifying radius
+	alpha, beta      [3]float64
+	delta            [3]float64
+	m0               float64 // meridian arc of the latitude of origin
+}
+
+func newTransverseMercator(el ellipsoid, lon0, lat0, k0, x0, y0 float64) *transverseMercator {
+	n := el.f / (2 - el.f)
+	n2, n3 := n*n, n*n*n
+	tm := &transverseMercator{
+		lon0: lon0 * deg, k0: k0, x0: x0, y0: y0,
+		n: n,
+		a: el.a / (1 + n) * (1 + n2/4 + n2*n2/64),
+		alpha: [3]float64{
+			n/2 - 2*n2/3 + 5*n3/16,
+			13*n2/48 - 3*n3/5,
+			61 * n3 / 240,
+		},
+		beta: [3]float64{
+			n/2 - 2*n2/3 + 37*n3/96,
+			n2/48 + n3/15,
+			17 * n3 / 480,
+		},
+		delta: [3]float64{
+			2*n - 2*n2/3 - 2*n3,
+			7*n2/3 - 8*n3/5,
+			56 * n3 / 15,
+		},
+	}
+	_, tm.m0 = tm.project(lon0*deg, lat0*deg)
+	return tm
+}
+
+// project returns the coordinates without scale and false origin.
+func (tm *transverseMercator) project(lon, lat float64) (x, y float64) {
+	c := 2 * math.Sqrt(tm.n) / (1 + tm.n)
+	t := math.Sinh(math.Atanh(math.Sin(lat)) - c*math.Atanh(c*math.Sin(lat)))
+	dlon := lon - tm.lon0
+	xi := math.Atan2(t, math.Cos(dlon))
+	eta := math.Atanh(math.Sin(dlon) / math.Sqrt(1+t*t))
+	x, y = eta, xi
+	for j, alpha := range tm.alpha {
+		k := 2 * float64(j+1)
+		x += alpha * math.Cos(k*xi) * math.Sinh(k*eta)
+		y += alpha * math.Sin(k*xi) * math.Cosh(k*eta)
+	}
+	return tm.a * x, tm.a * y
+}
+
+func (tm *transverseMercator) Forward(long, lat float64) (float64, float64) {
+	x, y := tm.project(long*deg, lat*deg)
+	return tm.x0 + tm.k0*x, tm.y0 + tm.k0*(y-tm.m0)
+}
+
+func (tm *transverseMercator) Inverse(x, y float64) (float64, float64) {
+	xi := ((y-tm.y0)/tm.k0 + tm.m0) / tm.a
+	eta := (x - tm.x0) / tm.k0 / tm.a
+	xi1, eta1 := xi, eta
+	for j, beta := range tm.beta {
+		k := 2 * float64(j+1)
+		xi1 -= beta * math.Sin(k*xi) * math.Cosh(k*eta)
+		eta1 -= beta * math.Cos(k*xi) * math.Sinh(k*eta)
+	}
+	chi := math.Asin(math.Sin(xi1) / math.Cosh(eta1))
+	lat := chi
+	for j, delta := range tm.delta {
+		lat += delta * math.Sin(2*float64(j+1)*chi)
+	}
+	lon := tm.lon0 + math.Atan2(math.Sinh(eta1), math.Cos(xi1))
+	return lon / deg, lat / deg
+}
+
+// lambertConformalConic is the Lambert conformal conic projection with two
+// standard parallels, or with one standard parallel if lat1 and lat2 are
+// equal.
+type lambertConformalConic struct {
+	e, lon0, x0, y0 float64
+	n, f, r0        float64
+}
+
+func newLambertConformalConic(el ellipsoid, lon0, lat0, lat1, lat2, k0, x0, y0 float64) *lambertConformalConic {
+	l := &lambertConformalConic{e: el.e(), lon0: lon0 * deg, x0: x0, y0: y0}
+	m1, m2 := l.m(lat1*deg), l.m(lat2*deg)
+	t0, t1, t2 := l.t(lat0*deg), l.t(lat1*deg), l.t(lat2*deg)
+	if lat1 == lat2 {
+		l.n = math.Sin(lat1 * deg)
+	} else {
+		l.n = (math.Log(m1) - math.Log(m2)) / (math.Log(t1) - math.Log(t2))
+	}
+	l.f = el.a * k0 * m1 / (l.n * math.Pow(t1, l.n))
+	l.r0 = l.f * math.Pow(t0, l.n)
+	return l
+}
+
+func (l *lambertConformalConic) m(lat float64) float64 {
+	es := l.e * math.Sin(lat)
+	return math.Cos(lat) / math.Sqrt(1-es*es)
+}
+
+func (l *lambertConformalConic) t(lat float64) float64 {
+	es := l.e * math.Sin(lat)
+	return math.Tan(math.Pi/4-lat/2) / math.Pow((1-es)/(1+es), l.e/2)
+}
+
+func (l *lambertConformalConic) Forward(long, lat float64) (float64, float64) {
+	r := l.f * math.Pow(l.t(lat*deg), l.n)
+	theta := l.n * (long*deg - l.lon0)
+	return l.x0 + r*math.Sin(theta), l.y0 + l.r0 - r*math.Cos(theta)
+}
+
+func (l *lambertConformalConic) Inverse(x, y float64) (float64, float64) {
+	dx, dy := x-l.x0, l.r0-(y-l.y0)
+	r := math.Copysign(math.Hypot(dx, dy), l.n)
+	t := math.Pow(r/l.f, 1/l.n)
+	theta := math.Atan2(dx, dy)
+	if l.n < 0 {
+		theta = math.Atan2(-dx, -dy)
+	}
+	return (theta/l.n + l.lon0) / deg, latitudeOfT(l.e, t) / deg
+}
+
+// mercator is the ellipsoidal Mercator projection.
+type mercator struct {
+	e, lon0, k0, x0, y0, a float64
+}
+
+func newMercator(el ellipsoid, lon0, k0, x0, y0 float64) *mercator {
+	return &mercator{e: el.e(), lon0: lon0 * deg, k0: k0, x0: x0, y0: y0, a: el.a}
+}
+
+func (m *mercator) Forward(long, lat float64) (float64, float64) {
+	es := m.e * math.Sin(lat*deg)
+	y := math.Log(math.Tan(math.Pi/4+lat*deg/2) * math.Pow((1-es)/(1+es), m.e/2))
+	return m.x0 + m.a*m.k0*(long*deg-m.lon0), m.y0 + m.a*m.k0*y
+}
+
+func (m *mercator) Inverse(x, y float64) (float64, float64) {
+	t := math.Exp((m.y0 - y) / (m.a * m.k0))
+	return ((x-m.x0)/(m.a*m.k0) + m.lon0) / deg, latitudeOfT(m.e, t) / deg
+}
+
+// latitudeOfT returns the latitude φ of t = tan(π/4 - φ/2) /
+// ((1-e sinφ)/(1+e sinφ))^(e/2) of the conformal projections.
+func latitudeOfT(e, t float64) float64 {
+	lat := math.Pi/2 - 2*math.Atan(t)
+	for i := 0; i < 15; i++ {
+		es := e * math.Sin(lat)
+		next := math.Pi/2 - 2*math.Atan(t*math.Pow((1-es)/(1+es), e/2))
+		if math.Abs(next-lat) < 1e-12 {
+			return next
+		}
+		lat = next
+	}
+	return lat
+}
+
+// lambertAzimuthalEqualArea is the ellipsoidal Lambert azimuthal equal area
+// projection in the oblique aspect (EPSG Guidance Note 7-2).
+type lambertAzimuthalEqualArea struct {
+	e, lon0, x0, y0 float64
+	qp, rq, d       float64
+	sinB0, cosB0    float64
+	c1, c2, c3      float64 // series of the inverse latitude
+}
+
+func newLambertAzimuthalEqualArea(el ellipsoid, lon0, lat0, x0, y0 float64) *lambertAzimuthalEqualArea {
+	e := el.e()
+	l := &lambertAzimuthalEqualArea{e: e, lon0: lon0 * deg, x0: x0, y0: y0}
+	l.qp = l.q(math.Pi / 2)
+	l.rq = el.a * math.Sqrt(l.qp/2)
+	b0 := math.Asin(l.q(lat0*deg) / l.qp)
+	l.sinB0, l.cosB0 = math.Sincos(b0)
+	sinLat0 := math.Sin(lat0 * deg)
+	l.d = el.a * math.Cos(lat0*deg) / math.Sqrt(1-e*e*sinLat0*sinLat0) / (l.rq * l.cosB0)
+	e2 := e * e
+	e4, e6 := e2*e2, e2*e2*e2
+	l.c1 = e2/3 + 31*e4/180 + 517*e6/5040
+	l.c2 = 23*e4/360 + 251*e6/3780
+	l.c3 = 761 * e6 / 45360
+	return l
+}
+
+func (l *lambertAzimuthalEqualArea) q(lat float64) float64 {
+	e := l.e
+	if e == 0 {
+		return 2 * math.Sin(lat)
+	}
+	es := e * math.Sin(lat)
+	return (1 - e*e) * (math.Sin(lat)/(1-es*es) - math.Log((1-es)/(1+es))/(2*e))
+}
+
+func (l *lambertAzimuthalEqualArea) Forward(long, lat float64) (float64, float64) {
+	sinB, cosB := math.Sincos(math.Asin(l.q(lat*deg) / l.qp))
+	sinL, cosL := math.Sincos(long*deg - l.lon0)
+	b := l.rq * math.Sqrt(2/(1+l.sinB0*sinB+l.cosB0*cosB*cosL))
+	return l.x0 + b*l.d*cosB*sinL, l.y0 + b/l.d*(l.cosB0*sinB-l.sinB0*cosB*cosL)
+}
+
+func (l *lambertAzimuthalEqualArea) Inverse(x, y float64) (float64, float64) {
+	dx, dy := x-l.x0, y-l.y0
+	rho := math.Hypot(dx/l.d, l.d*dy)
+	if rho == 0 {
+		return l.lon0 / deg, math.Asin(l.sinB0) / deg
+	}
+	sinC, cosC := math.Sincos(2 * math.Asin(rho/(2*l.rq)))
+	b := math.Asin(cosC*l.sinB0 + l.d*dy*sinC*l.cosB0/rho)
+	lon := l.lon0 + math.Atan2(dx*sinC, l.d*rho*l.cosB0*cosC-l.d*l.d*dy*l.sinB0*sinC)
+	lat := b + l.c1*math.Sin(2*b) + l.c2*math.Sin(4*b) + l.c3*math.Sin(6*b)
+	return lon / deg, lat / deg
+}
+
+// helmert is a seven parameter transformation from a local datum to WGS84
+// (position vector convention, rotations in arc seconds and scale in ppm).
+type helmert struct {
+	tx, ty, tz, rx, ry, rz, s float64
+}
+
+const arcsec = math.Pi / 180 / 3600
+
+// toWGS84 transforms geocentric coordinates of the local datum to WGS84.
+func (h helmert) toWGS84(x, y, z float64) (float64, float64, float64) {
+	rx, ry, rz := h.rx*arcsec, h.ry*arcsec, h.rz*arcsec
+	s := 1 + h.s*1e-6
+	return h.tx + s*(x-rz*y+ry*z),
+		h.ty + s*(rz*x+y-rx*z),
+		h.tz + s*(-ry*x+rx*y+z)
+}
+
+// fromWGS84 is the inverse of toWGS84. The transposed rotation matrix is
+// the inverse for the small rotations.
+func (h helmert) fromWGS84(x, y, z float64) (float64, float64, float64) {
+	rx, ry, rz := h.rx*arcsec, h.ry*arcsec, h.rz*arcsec
+	s := 1 + h.s*1e-6
+	x, y, z = (x-h.tx)/s, (y-h.ty)/s, (z-h.tz)/s
+	return x + rz*y - ry*z,
+		-rz*x + y + rx*z,
+		ry*x - rx*y + z
+}
+
+// datumShift is a projection on another datum than WGS84.
+type datumShift struct {
+	Projection
+	ellipsoid ellipsoid
+	helmert   helmert
+}
+
+func (d datumShift) Forward(long, lat float64) (float64, float64) {
+	x, y, z := geocentric(wgs84Ellipsoid, long, lat)
+	x, y, z = d.helmert.fromWGS84(x, y, z)
+	long, lat = geodetic(d.ellipsoid, x, y, z)
+	return d.Projection.Forward(long, lat)
+}
+
+func (d datumShift) Inverse(x, y float64) (float64, float64) {
+	long, lat := d.Projection.Inverse(x, y)
+	gx, gy, gz := geocentric(d.ellipsoid, long, lat)
+	gx, gy, gz = d.helmert.toWGS84(gx, gy, gz)
+	return geodetic(wgs84Ellipsoid, gx, gy, gz)
+}
+
+// geocentric returns the geocentric coordinates of long/lat at height 0.
+func geocentric(el ellipsoid, long, lat float64) (x, y, z float64) {
+	e2 := el.f * (2 - el.f)
+	sinLat, cosLat := math.Sincos(lat * deg)
+	sinLon, cosLon := math.Sincos(long * deg)
+	v := el.a / math.Sqrt(1-e2*sinLat*sinLat)
+	return v * cosLat * cosLon, v * cosLat * sinLon, v * (1 - e2) * sinLat
+}
+
+// geodetic returns long/lat of geocentric coordinates.
+func geodetic(el ellipsoid, x, y, z float64) (long, lat float64) {
+	e2 := el.f * (2 - el.f)
+	p := math.Hypot(x, y)
+	lat = math.Atan2(z, p*(1-e2))
+	for i := 0; i < 10; i++ {
+		sinLat := math.Sin(lat)
+		v := el.a / math.Sqrt(1-e2*sinLat*sinLat)
+		next := math.Atan2(z+e2*v*sinLat, p)
+		if math.Abs(next-lat) < 1e-12 {
+			lat = next
+			break
+		}
+		lat = next
+	}
+	return math.Atan2(y, x) / deg, lat / deg
+}
@@ -0,0 +1,151 @@
+package proj
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTransverseMercator(t *testing.T) {
+	p := Lookup(32632)
+	if x, y := p.Forward(9, 0); math.Abs(x-500000) > 1e-6 || math.Abs(y) > 1e-6 {
+		t.Errorf("unexpected origin %v %v", x, y)
+	}
+	// meridian arc of 50° scaled by 0.9996
+	if x, y := p.Forward(9, 50); math.Abs(x-500000) > 1e-6 || math.Abs(y-5538630.70) > 0.01 {
+		t.Errorf("unexpected coordinate %v %v", x, y)
+	}
+	if _, y := Lookup(32732).Forward(9, -50); math.Abs(y-(10000000-5538630.70)) > 0.01 {
+		t.Errorf("unexpected southern coordinate %v", y)
+	}
+
+	// example of the Ordnance Survey guide to coordinate systems
+	tm := newTransverseMercator(airy1830, -2, 49, 0.9996012717, 400000, -100000)
+	x, y := tm.Forward(1+43/60.0+4.5177/3600, 52+39/60.0+27.2531/3600)
+	if math.Abs(x-651409.903) > 0.01 || math.Abs(y-313177.270) > 0.01 {
+		t.Errorf("unexpected OSGB coordinate %v %v", x, y)
+	}
+}
+
+func TestLambertConformalConic(t *testing.T) {
+	p := Lookup(2154)
+	if x, y := p.Forward(3, 46.5); math.Abs(x-700000) > 1e-6 || math.Abs(y-6600000) > 1e-6 {
+		t.Errorf("unexpected origin %v %v", x, y)
+	}
+	// Paris is north-east of the origin
+	if x, y := p.Forward(2.35, 48.85); x > 700000 || y < 6800000 {
+		t.Errorf("unexpected coordinate %v %v", x, y)
+	}
+}
+
+func TestDatumShift(t *testing.T) {
+	// ETRS89 coordinate of the OSGB example above
+	x, y := Lookup(27700).Forward(1+42/60.0+57.8663/3600, 52+39/60.0+28.8282/3600)
+	if math.Abs(x-651409.903) > 5 || math.Abs(y-313177.270) > 5 {
+		t.Errorf("unexpected coordinate %v %v", x, y)
+	}
+}
+
+func TestProjectionsRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		srid      int
+		long, lat float64
+		delta     float64
+	}{
+		{4326, 8, 53, 1e-8},
+		{3857, 8, 53, 1e-8},
+		{32632, 10.5, 53.5, 1e-8},
+		{32633, 13.4, 52.5, 1e-8},
+		{32719, -70.6, -33.4, 1e-8},
+		{25832, 7, 51, 1e-8},
+		{2154, 2.35, 48.85, 1e-8},
+		{2154, -1.5, 43.5, 1e-8},
+		// the datum shift ignores the ellipsoidal heights (~1mm)
+		{27700, -3.2, 55.95, 1e-7},
+	} {
+		p := Lookup(tc.srid)
+		if p == nil {
+			t.Fatalf("unsupported %d", tc.srid)
+		}
+		long, lat := p.Inverse(p.Forward(tc.long, tc.lat))
+		if math.Abs(long-tc.long) > tc.delta || math.Abs(lat-tc.lat) > tc.delta {
+			t.Errorf("%d: unexpected round trip %v %v, expected %v %v", tc.srid, long, lat, tc.long, tc.lat)
+		}
+	}
+	if Supported(1234) || Supported(32661) || !Supported(25833) {
+		t.Error("unexpected supported projections")
+	}
+}
+
+// The examples of the EPSG Guidance Note 7-2.
+func TestEPSGExamples(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		def       string
+		long, lat float64
+		x, y      float64
+	}{
+		{"Mercator (variant A)", "+proj=merc +lon_0=110 +k=0.997 +x_0=3900000 +y_0=900000 +ellps=bessel",
+			120, -3, 5009726.58, 569150.82},
+		{"Mercator (variant B)", "+proj=merc +lat_ts=42 +lon_0=51 +x_0=0 +y_0=0 +ellps=krass",
+			53, 53, 165704.29, 5171848.07},
+		{"Lambert Conic Conformal (1SP)", "+proj=lcc +lat_1=18 +lat_0=18 +lon_0=-77 +k_0=1 +x_0=250000 +y_0=150000 +ellps=clrk66",
+			-(76 + 56/60.0 + 37.26/3600), 17 + 55/60.0 + 55.80/3600, 255966.58, 142493.51},
+		{"Lambert Azimuthal Equal Area", "+proj=laea +lat_0=52 +lon_0=10 +x_0=4321000 +y_0=3210000 +ellps=GRS80",
+			5, 50, 3962799.45, 2999718.85},
+	} {
+		d, err := parseDefinition(tc.def)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := d.projection()
+		x, y := p.Forward(tc.long, tc.lat)
+		if math.Abs(x-tc.x) > 0.01 || math.Abs(y-tc.y) > 0.01 {
+			t.Errorf("%s: unexpected coordinate %.2f %.2f, expected %.2f %.2f", tc.name, x, y, tc.x, tc.y)
+		}
+		long, lat := p.Inverse(x, y)
+		if math.Abs(long-tc.long) > 1e-8 || math.Abs(lat-tc.lat) > 1e-8 {
+			t.Errorf("%s: unexpected round trip %v %v", tc.name, long, lat)
+		}
+	}
+}
+
+func TestExtent(t *testing.T) {
+	for _, tc := range []struct {
+		srid     int
+		min, max [4]float64
+	}{
+		// zone 32N from 6°E to 12°E, equator to 84°N
+		{32632, [4]float64{160000, -1, 500000, 9300000}, [4]float64{500000, 0, 840000, 9400000}},
+		// southern zone with false northing
+		{32732, [4]float64{160000, 1000000, 500000, 9999999}, [4]float64{500000, 1200000, 840000, 10000001}},
+		{2154, [4]float64{-400000, 6000000, 700000, 6600000}, [4]float64{700000, 6600000, 1400000, 7300000}},
+	} {
+		ext, ok := Extent(tc.srid)
+		if !ok {
+			t.Fatalf("no extent for %d", tc.srid)
+		}
+		for i := range ext {
+			if ext[i] < tc.min[i] || ext[i] > tc.max[i] {
+				t.Errorf("%d: unexpected extent %v", tc.srid, ext)
+				break
+			}
+		}
+	}
+	if _, ok := Extent(1234); ok {
+		t.Error("unexpected extent for unsupported projection")
+	}
+}
+
+func TestBounds(t *testing.T) {
+	if b, ok := Bounds(4326, [4]float64{5, 50, 10, 55}); !ok || b != [4]float64{5, 50, 10, 55} {
+		t.Errorf("unexpected bounds %v", b)
+	}
+	// limited to the extent of web mercator
+	b, ok := Bounds(3857, [4]float64{-180, 0, 180, 90})
+	if !ok || b[0] != -20037508.342789244 || b[1] != 0 || b[3] != 20037508.342789244 {
+		t.Errorf("unexpected bounds %v", b)
+	}
+	if _, ok := Bounds(1234, [4]float64{0, 0, 1, 1}); ok {
+		t.Error("unexpected bounds for unsupported projection")
+	}
+}
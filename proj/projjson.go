@@ -0,0 +1,261 @@
+package proj
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The types of the PROJJSON encoding of coordinate reference systems
+// (https://proj.org/specifications/projjson.html).
+type (
+	projJSONID struct {
+		Authority string `json:"authority"`
+		Code      int    `json:"code"`
+	}
+	projJSONAxis struct {
+		Name         string `json:"name"`
+		Abbreviation string `json:"abbreviation"`
+		Direction    string `json:"direction"`
+		Unit         string `json:"unit"`
+	}
+	projJSONCS struct {
+		Subtype string         `json:"subtype"`
+		Axis    []projJSONAxis `json:"axis"`
+	}
+	projJSONEllipsoid struct {
+		Name              string   `json:"name"`
+		SemiMajorAxis     float64  `json:"semi_major_axis,omitempty"`
+		InverseFlattening float64  `json:"inverse_flattening,omitempty"`
+		Radius            *float64 `json:"radius,omitempty"`
+	}
+	projJSONDatum struct {
+		Type      string            `json:"type"`
+		Name      string            `json:"name"`
+		Ellipsoid projJSONEllipsoid `json:"ellipsoid"`
+	}
+	projJSONParameter struct {
+		Name  string      `json:"name"`
+		Value float64     `json:"value"`
+		Unit  string      `json:"unit"`
+		ID    *projJSONID `json:"id,omitempty"`
+	}
+	projJSONMethod struct {
+		Name string      `json:"name"`
+		ID   *projJSONID `json:"id,omitempty"`
+	}
+	projJSONOperation struct {
+		Name       string              `json:"name"`
+		Method     projJSONMethod      `json:"method"`
+		Parameters []projJSONParameter `json:"parameters"`
+	}
+	projJSONCRS struct {
+		Schema           string             `json:"$schema,omitempty"`
+		Type             string             `json:"type"`
+		Name             string             `json:"name,omitempty"`
+		BaseCRS          *projJSONCRS       `json:"base_crs,omitempty"`
+		Datum            *projJSONDatum     `json:"datum,omitempty"`
+		Conversion       *projJSONOperation `json:"conversion,omitempty"`
+		CoordinateSystem *projJSONCS        `json:"coordinate_system,omitempty"`
+		SourceCRS        *projJSONCRS       `json:"source_crs,omitempty"`
+		TargetCRS        *projJSONCRS       `json:"target_crs,omitempty"`
+		Transformation   *projJSONOperation `json:"transformation,omitempty"`
+		ID               *projJSONID        `json:"id,omitempty"`
+	}
+)
+
+const projJSONSchema = "https://proj.org/schemas/v0.5/projjson.schema.json"
+
+// ProjJSON returns the PROJJSON of the coordinate reference system of srid,
+// e.g. for the CRS metadata of GeoParquet. Projections with a datum
+// transformation that were added by Register are returned as BoundCRS to
+// WGS 84.
+func ProjJSON(srid int) (json.RawMessage, error) {
+	var crs *projJSONCRS
+	switch srid {
+	case 4326:
+		crs = geographicProjJSON(wgs84CRS, wgs84Ellipsoid)
+	case 3857:
+		return json.RawMessage(projJSON3857), nil
+	default:
+		d := definitions[srid]
+		if d == nil {
+			return nil, fmt.Errorf("unsupported srid %d", srid)
+		}
+		crs = d.projJSON()
+	}
+	crs.Schema = projJSONSchema
+	return json.Marshal(crs)
+}
+
+func epsgID(code int) *projJSONID {
+	if code == 0 {
+		return nil
+	}
+	return &projJSONID{Authority: "EPSG", Code: code}
+}
+
+func geographicProjJSON(geog geographicCRS, el ellipsoid) *projJSONCRS {
+	ellps := projJSONEllipsoid{Name: el.name}
+	if el.f == 0 {
+		ellps.Radius = &el.a
+	} else {
+		ellps.SemiMajorAxis, ellps.InverseFlattening = el.a, 1/el.f
+	}
+	return &projJSONCRS{
+		Type:  "GeographicCRS",
+		Name:  geog.name,
+		Datum: &projJSONDatum{Type: "GeodeticReferenceFrame", Name: geog.datum, Ellipsoid: ellps},
+		CoordinateSystem: &projJSONCS{Subtype: "ellipsoidal", Axis: []projJSONAxis{
+			{"Geodetic latitude", "Lat", "north", "degree"},
+			{"Geodetic longitude", "Lon", "east", "degree"},
+		}},
+		ID: epsgID(geog.srid),
+	}
+}
+
+func (d *definition) projJSON() *projJSONCRS {
+	crs := geographicProjJSON(d.geog, d.ellps)
+	if d.proj != "longlat" {
+		name := d.name
+		if name == "" {
+			name = "unknown"
+		}
+		crs = &projJSONCRS{
+			Type:       "ProjectedCRS",
+			Name:       name,
+			BaseCRS:    crs,
+			Conversion: d.conversion(),
+			CoordinateSystem: &projJSONCS{Subtype: "Cartesian", Axis: []projJSONAxis{
+				{"Easting", "E", "east", "metre"},
+				{"Northing", "N", "north", "metre"},
+			}},
+		}
+	}
+	if d.name != "" {
+		// the datum transformation of EPSG projections is part of the EPSG
+		// database
+		crs.ID = epsgID(d.srid)
+		return crs
+	}
+	if d.towgs84 == nil {
+		return crs
+	}
+	h := d.towgs84
+	return &projJSONCRS{
+		Type:      "BoundCRS",
+		SourceCRS: crs,
+		TargetCRS: geographicProjJSON(wgs84CRS, wgs84Ellipsoid),
+		Transformation: &projJSONOperation{
+			Name:   "Transformation from unknown to WGS84",
+			Method: projJSONMethod{"Position Vector transformation (geog2D domain)", epsgID(9606)},
+			Parameters: []projJSONParameter{
+				{"X-axis translation", h.tx, "metre", epsgID(8605)},
+				{"Y-axis translation", h.ty, "metre", epsgID(8606)},
+				{"Z-axis translation", h.tz, "metre", epsgID(8607)},
+				{"X-axis rotation", h.rx, "arc-second", epsgID(8608)},
+				{"Y-axis rotation", h.ry, "arc-second", epsgID(8609)},
+				{"Z-axis rotation", h.rz, "arc-second", epsgID(8610)},
+				{"Scale difference", h.s, "parts per million", epsgID(8611)},
+			},
+		},
+	}
+}
+
+// conversion returns the EPSG method and parameters of the projection.
+func (d *definition) conversion() *projJSONOperation {
+	degree := func(name string, v float64, code int) projJSONParameter {
+		return projJSONParameter{name, v, "degree", epsgID(code)}
+	}
+	metre := func(name string, v float64, code int) projJSONParameter {
+		return projJSONParameter{name, v, "metre", epsgID(code)}
+	}
+	scale := projJSONParameter{"Scale factor at natural origin", d.k0, "unity", epsgID(8805)}
+	falseOrigin := []projJSONParameter{metre("False easting", d.x0, 8806), metre("False northing", d.y0, 8807)}
+
+	switch d.proj {
+	case "tmerc":
+		return &projJSONOperation{
+			Name:   "unknown",
+			Method: projJSONMethod{"Transverse Mercator", epsgID(9807)},
+			Parameters: append([]projJSONParameter{
+				degree("Latitude of natural origin", d.lat0, 8801),
+				degree("Longitude of natural origin", d.lon0, 8802),
+				scale,
+			}, falseOrigin...),
+		}
+	case "merc":
+		if d.latTS != nil {
+			return &projJSONOperation{
+				Name:   "unknown",
+				Method: projJSONMethod{"Mercator (variant B)", epsgID(9805)},
+				Parameters: append([]projJSONParameter{
+					degree("Latitude of 1st standard parallel", *d.latTS, 8823),
+					degree("Longitude of natural origin", d.lon0, 8802),
+				}, falseOrigin...),
+			}
+		}
+		return &projJSONOperation{
+			Name:   "unknown",
+			Method: projJSONMethod{"Mercator (variant A)", epsgID(9804)},
+			Parameters: append([]projJSONParameter{
+				degree("Latitude of natural origin", 0, 8801),
+				degree("Longitude of natural origin", d.lon0, 8802),
+				scale,
+			}, falseOrigin...),
+		}
+	case "lcc":
+		if d.lat1 == d.lat2 && d.lat0 == d.lat1 {
+			return &projJSONOperation{
+				Name:   "unknown",
+				Method: projJSONMethod{"Lambert Conic Conformal (1SP)", epsgID(9801)},
+				Parameters: append([]projJSONParameter{
+					degree("Latitude of natural origin", d.lat0, 8801),
+					degree("Longitude of natural origin", d.lon0, 8802),
+					scale,
+				}, falseOrigin...),
+			}
+		}
+		return &projJSONOperation{
+			Name:   "unknown",
+			Method: projJSONMethod{"Lambert Conic Conformal (2SP)", epsgID(9802)},
+			Parameters: []projJSONParameter{
+				degree("Latitude of false origin", d.lat0, 8821),
+				degree("Longitude of false origin", d.lon0, 8822),
+				degree("Latitude of 1st standard parallel", d.lat1, 8823),
+				degree("Latitude of 2nd standard parallel", d.lat2, 8824),
+				metre("Easting at false origin", d.x0, 8826),
+				metre("Northing at false origin", d.y0, 8827),
+			},
+		}
+	case "laea":
+		return &projJSONOperation{
+			Name:   "unknown",
+			Method: projJSONMethod{"Lambert Azimuthal Equal Area", epsgID(9820)},
+			Parameters: append([]projJSONParameter{
+				degree("Latitude of natural origin", d.lat0, 8801),
+				degree("Longitude of natural origin", d.lon0, 8802),
+			}, falseOrigin...),
+		}
+	}
+	return nil
+}
+
+const projJSON3857 = `{"$schema":"https://proj.org/schemas/v0.5/projjson.schema.json",` +
+	`"type":"ProjectedCRS","name":"WGS 84 / Pseudo-Mercator",` +
+	`"base_crs":{"name":"WGS 84","datum":{"type":"GeodeticReferenceFrame","name":"World Geodetic System 1984",` +
+	`"ellipsoid":{"name":"WGS 84","semi_major_axis":6378137,"inverse_flattening":298.257223563}},` +
+	`"coordinate_system":{"subtype":"ellipsoidal","axis":[` +
+	`{"name":"Geodetic latitude","abbreviation":"Lat","direction":"north","unit":"degree"},` +
+	`{"name":"Geodetic longitude","abbreviation":"Lon","direction":"east","unit":"degree"}]},` +
+	`"id":{"authority":"EPSG","code":4326}},` +
+	`"conversion":{"name":"Popular Visualisation Pseudo-Mercator",` +
+	`"method":{"name":"Popular Visualisation Pseudo Mercator","id":{"authority":"EPSG","code":1024}},` +
+	`"parameters":[` +
+	`{"name":"Latitude of natural origin","value":0,"unit":"degree","id":{"authority":"EPSG","code":8801}},` +
+	`{"name":"Longitude of natural origin","value":0,"unit":"degree","id":{"authority":"EPSG","code":8802}},` +
+	`{"name":"False easting","value":0,"unit":"metre","id":{"authority":"EPSG","code":8806}},` +
+	`{"name":"False northing","value":0,"unit":"metre","id":{"authority":"EPSG","code":8807}}]},` +
+	`"coordinate_system":{"subtype":"Cartesian","axis":[` +
+	`{"name":"Easting","abbreviation":"X","direction":"east","unit":"metre"},` +
+	`{"name":"Northing","abbreviation":"Y","direction":"north","unit":"metre"}]},` +
+	`"id":{"authority":"EPSG","code":3857}}`
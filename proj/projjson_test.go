@@ -0,0 +1,64 @@
+package proj
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestProjJSON(t *testing.T) {
+	for _, tc := range []struct {
+		srid     int
+		contains []string
+	}{
+		{4326, []string{`"type":"GeographicCRS"`, `"code":4326`}},
+		{3857, []string{`"name":"WGS 84 / Pseudo-Mercator"`}},
+		{25832, []string{
+			`"type":"ProjectedCRS","name":"ETRS89 / UTM zone 32N"`,
+			`"method":{"name":"Transverse Mercator","id":{"authority":"EPSG","code":9807}}`,
+			`{"name":"Longitude of natural origin","value":9,"unit":"degree"`,
+			`"id":{"authority":"EPSG","code":4258}}`,
+			`"id":{"authority":"EPSG","code":25832}}`,
+		}},
+		{2154, []string{`"name":"Lambert Conic Conformal (2SP)"`, `"value":6600000`}},
+		{27700, []string{`"name":"OSGB36 / British National Grid"`, `"name":"Airy 1830"`}},
+		{3035, []string{`"name":"Lambert Azimuthal Equal Area"`}},
+	} {
+		crs, err := ProjJSON(tc.srid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !json.Valid(crs) || !strings.HasPrefix(string(crs), `{"$schema":"https://proj.org/schemas/v0.5/projjson.schema.json"`) {
+			t.Errorf("%d: invalid PROJJSON %s", tc.srid, crs)
+		}
+		for _, s := range tc.contains {
+			if !strings.Contains(string(crs), s) {
+				t.Errorf("%d: %s not in %s", tc.srid, s, crs)
+			}
+		}
+	}
+
+	if err := Register(31467, "+proj=tmerc +lat_0=0 +lon_0=9 +k=1 +x_0=3500000 +y_0=0 +datum=potsdam +units=m"); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(projections, 31467)
+	defer delete(definitions, 31467)
+	crs, err := ProjJSON(31467)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`"type":"BoundCRS","source_crs":{"type":"ProjectedCRS","name":"unknown"`,
+		`"name":"Deutsches Hauptdreiecksnetz"`,
+		`"name":"Position Vector transformation (geog2D domain)"`,
+		`{"name":"Z-axis rotation","value":-2.455,"unit":"arc-second"`,
+	} {
+		if !strings.Contains(string(crs), s) {
+			t.Errorf("%s not in %s", s, crs)
+		}
+	}
+
+	if _, err := ProjJSON(1234); err == nil {
+		t.Error("expected error for unsupported srid")
+	}
+}
@@ -0,0 +1,310 @@
+// Package adiff parses augmented diffs (adiff) of the Overpass API.
+//
+// Augmented diffs contain the new and the old state of each changed
+// element, including the metadata of both versions. See
+// https://wiki.openstreetmap.org/wiki/Overpass_API/Augmented_Diffs for the
+// format.
+package adiff
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/go-osm/parser/diff"
+	"github.com/pkg/errors"
+)
+
+// IsAugmentedDiff returns whether the start of an XML file is an augmented
+// diff, and not an osmChange file.
+func IsAugmentedDiff(start []byte) bool {
+	if bytes.Contains(start, []byte("<osmChange")) {
+		return false
+	}
+	return bytes.Contains(start, []byte("<osm ")) || bytes.Contains(start, []byte("<osm>"))
+}
+
+// key identifies an element by type and ID.
+type key struct {
+	typ osm.MemberType
+	id  int64
+}
+
+func diffKey(d osm.Diff) key {
+	switch {
+	case d.Node != nil:
+		return key{osm.NodeMember, d.Node.ID}
+	case d.Way != nil:
+		return key{osm.WayMember, d.Way.ID}
+	case d.Rel != nil:
+		return key{osm.RelationMember, d.Rel.ID}
+	}
+	return key{}
+}
+
+// Parser parses augmented diffs. It sends the new state of all elements to
+// the same channel as the parser for .osc files. The old state of modified
+// and deleted elements is available with Old.
+type Parser struct {
+	dec  *xml.Decoder
+	conf diff.Config
+
+	mu   sync.Mutex
+	olds map[key]osm.Element
+}
+
+// NewParser returns a parser for the augmented diff of r.
+func NewParser(r io.Reader, conf diff.Config) *Parser {
+	return &Parser{dec: xml.NewDecoder(r), conf: conf, olds: make(map[key]osm.Element)}
+}
+
+// Old returns the old state of an element that was received from the Diffs
+// channel. ok is false for created elements.
+func (p *Parser) Old(d osm.Diff) (elem osm.Element, ok bool) {
+	k := diffKey(d)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	elem, ok = p.olds[k]
+	delete(p.olds, k)
+	return elem, ok
+}
+
+// element is a node, way or relation while parsing.
+type element struct {
+	typ  osm.MemberType
+	node *osm.Node
+	way  *osm.Way
+	rel  *osm.Relation
+}
+
+func (e *element) elem() *osm.Element {
+	switch e.typ {
+	case osm.NodeMember:
+		return &e.node.Element
+	case osm.WayMember:
+		return &e.way.Element
+	default:
+		return &e.rel.Element
+	}
+}
+
+var memberTypes = map[string]osm.MemberType{
+	"node":     osm.NodeMember,
+	"way":      osm.WayMember,
+	"relation": osm.RelationMember,
+}
+
+// Parse parses all actions and sends them to the Diffs channel.
+func (p *Parser) Parse(ctx context.Context) error {
+	if !p.conf.KeepOpen {
+		defer func() {
+			if p.conf.Diffs != nil {
+				close(p.conf.Diffs)
+			}
+		}()
+	}
+
+	var action string
+	var old, cur *element
+	inOld, inMember := false, false
+
+	for {
+		tok, err := p.dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "parsing augmented diff")
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			switch tok.Name.Local {
+			case "action":
+				action = ""
+				old, cur = nil, nil
+				inOld = false
+				for _, attr := range tok.Attr {
+					if attr.Name.Local == "type" {
+						action = attr.Value
+					}
+				}
+			case "old":
+				inOld = true
+			case "new":
+				inOld = false
+			case "node", "way", "relation":
+				if action == "" {
+					continue
+				}
+				e := p.newElement(tok)
+				if inOld {
+					old = e
+				} else {
+					cur = e
+				}
+			case "nd":
+				e := cur
+				if inOld {
+					e = old
+				}
+				if e == nil || e.typ != osm.WayMember || inMember {
+					continue
+				}
+				for _, attr := range tok.Attr {
+					if attr.Name.Local == "ref" {
+						ref, _ := strconv.ParseInt(attr.Value, 10, 64)
+						e.way.Refs = append(e.way.Refs, ref)
+					}
+				}
+			case "member":
+				inMember = true
+				e := cur
+				if inOld {
+					e = old
+				}
+				if e == nil || e.typ != osm.RelationMember {
+					continue
+				}
+				member := osm.Member{}
+				valid := true
+				for _, attr := range tok.Attr {
+					switch attr.Name.Local {
+					case "type":
+						var ok bool
+						member.Type, ok = memberTypes[attr.Value]
+						valid = valid && ok
+					case "role":
+						member.Role = attr.Value
+					case "ref":
+						var err error
+						member.ID, err = strconv.ParseInt(attr.Value, 10, 64)
+						valid = valid && err == nil
+					}
+				}
+				if valid {
+					e.rel.Members = append(e.rel.Members, member)
+				}
+			case "tag":
+				e := cur
+				if inOld {
+					e = old
+				}
+				if e == nil {
+					continue
+				}
+				var k, v string
+				for _, attr := range tok.Attr {
+					if attr.Name.Local == "k" {
+						k = attr.Value
+					} else if attr.Name.Local == "v" {
+						v = attr.Value
+					}
+				}
+				elem := e.elem()
+				if elem.Tags == nil {
+					elem.Tags = make(osm.Tags)
+				}
+				elem.Tags[k] = v
+			}
+		case xml.EndElement:
+			switch tok.Name.Local {
+			case "member":
+				inMember = false
+			case "action":
+				d, ok := p.actionDiff(action, old, cur)
+				if !ok {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case p.conf.Diffs <- d:
+				}
+			}
+		}
+	}
+}
+
+// actionDiff returns the diff of an action and stores the old state.
+func (p *Parser) actionDiff(action string, old, cur *element) (osm.Diff, bool) {
+	d := osm.Diff{}
+	switch action {
+	case "create":
+		d.Create = true
+	case "modify":
+		d.Modify = true
+	case "delete":
+		d.Delete = true
+	default:
+		return d, false
+	}
+	e := cur
+	if e == nil {
+		// deletions without the new state (metadata of the deletion)
+		e = old
+	}
+	if e == nil {
+		return d, false
+	}
+	switch e.typ {
+	case osm.NodeMember:
+		d.Node = e.node
+	case osm.WayMember:
+		d.Way = e.way
+	default:
+		d.Rel = e.rel
+	}
+	if old != nil {
+		p.mu.Lock()
+		p.olds[diffKey(d)] = *old.elem()
+		p.mu.Unlock()
+	}
+	return d, true
+}
+
+func (p *Parser) newElement(start xml.StartElement) *element {
+	e := &element{typ: memberTypes[start.Name.Local]}
+	var elem osm.Element
+	var lat, long float64
+	meta := &osm.Metadata{}
+	for _, attr := range start.Attr {
+		switch attr.Name.Local {
+		case "id":
+			elem.ID, _ = strconv.ParseInt(attr.Value, 10, 64)
+		case "lat":
+			lat, _ = strconv.ParseFloat(attr.Value, 64)
+		case "lon":
+			long, _ = strconv.ParseFloat(attr.Value, 64)
+		case "version":
+			v, _ := strconv.ParseInt(attr.Value, 10, 32)
+			meta.Version = int32(v)
+		case "changeset":
+			meta.Changeset, _ = strconv.ParseInt(attr.Value, 10, 64)
+		case "uid":
+			uid, _ := strconv.ParseInt(attr.Value, 10, 32)
+			meta.UserID = int32(uid)
+		case "user":
+			meta.UserName = attr.Value
+		case "timestamp":
+			meta.Timestamp, _ = time.Parse(time.RFC3339, attr.Value)
+		}
+	}
+	if p.conf.IncludeMetadata {
+		elem.Metadata = meta
+	}
+	switch e.typ {
+	case osm.NodeMember:
+		e.node = &osm.Node{Element: elem, Lat: lat, Long: long}
+	case osm.WayMember:
+		e.way = &osm.Way{Element: elem}
+	default:
+		e.rel = &osm.Relation{Element: elem}
+	}
+	return e
+}
@@ -0,0 +1,124 @@
+package adiff
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/go-osm/parser/diff"
+)
+
+const testAdiff = `<?xml version="1.0" encoding="UTF-8"?>
+<osm version="0.6" generator="Overpass API">
+<meta osm_base="2020-01-01T10:01:02Z"/>
+<action type="create">
+  <node id="1" lat="53.5" lon="9.9" version="1" timestamp="2020-01-01T10:00:00Z" changeset="100" uid="42" user="mapper">
+    <tag k="amenity" v="cafe"/>
+  </node>
+</action>
+<action type="modify">
+  <old>
+    <way id="2" version="1" timestamp="2019-01-01T10:00:00Z" changeset="90" uid="43" user="other">
+      <nd ref="1" lat="53.5" lon="9.9"/>
+      <nd ref="3" lat="53.6" lon="9.9"/>
+      <tag k="highway" v="track"/>
+    </way>
+  </old>
+  <new>
+    <way id="2" version="2" timestamp="2020-01-01T10:00:00Z" changeset="100" uid="42" user="mapper">
+      <nd ref="1" lat="53.5" lon="9.9"/>
+      <nd ref="3" lat="53.6" lon="9.9"/>
+      <nd ref="4" lat="53.7" lon="9.9"/>
+      <tag k="highway" v="residential"/>
+    </way>
+  </new>
+</action>
+<action type="delete">
+  <old>
+    <relation id="5" version="3" timestamp="2019-01-01T10:00:00Z" changeset="80" uid="43" user="other">
+      <member type="way" ref="2" role="outer">
+        <nd lat="53.5" lon="9.9"/>
+      </member>
+      <tag k="type" v="multipolygon"/>
+    </relation>
+  </old>
+  <new>
+    <relation id="5" visible="false" version="4" timestamp="2020-01-01T10:00:00Z" changeset="100" uid="42" user="mapper"/>
+  </new>
+</action>
+</osm>
+`
+
+func TestIsAugmentedDiff(t *testing.T) {
+	if !IsAugmentedDiff([]byte(testAdiff)) {
+		t.Error("augmented diff not detected")
+	}
+	if IsAugmentedDiff([]byte(`<?xml version="1.0"?><osmChange version="0.6"><modify><node id="1"/></modify></osmChange>`)) {
+		t.Error("osmChange detected as augmented diff")
+	}
+}
+
+func TestParser(t *testing.T) {
+	diffs := make(chan osm.Diff)
+	p := NewParser(strings.NewReader(testAdiff), diff.Config{Diffs: diffs, IncludeMetadata: true})
+	done := make(chan error)
+	go func() {
+		done <- p.Parse(context.Background())
+	}()
+
+	var result []osm.Diff
+	for d := range diffs {
+		result = append(result, d)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 3 {
+		t.Fatalf("expected 3 diffs, got %d", len(result))
+	}
+
+	d := result[0]
+	if !d.Create || d.Node == nil || d.Node.ID != 1 || d.Node.Lat != 53.5 || d.Node.Tags["amenity"] != "cafe" {
+		t.Errorf("unexpected create %#v", d)
+	}
+	if d.Node.Metadata == nil || d.Node.Metadata.UserName != "mapper" || d.Node.Metadata.Changeset != 100 ||
+		!d.Node.Metadata.Timestamp.Equal(time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected metadata %#v", d.Node.Metadata)
+	}
+	if _, ok := p.Old(d); ok {
+		t.Error("unexpected old state of created node")
+	}
+
+	d = result[1]
+	if !d.Modify || d.Way == nil || len(d.Way.Refs) != 3 || d.Way.Tags["highway"] != "residential" {
+		t.Errorf("unexpected modify %#v", d)
+	}
+	old, ok := p.Old(d)
+	if !ok || len(old.Tags) != 1 || old.Tags["highway"] != "track" || old.Metadata.Version != 1 || old.Metadata.UserName != "other" {
+		t.Errorf("unexpected old state %#v", old)
+	}
+
+	d = result[2]
+	if !d.Delete || d.Rel == nil || d.Rel.ID != 5 || d.Rel.Metadata.Version != 4 {
+		t.Errorf("unexpected delete %#v", d)
+	}
+	old, ok = p.Old(d)
+	if !ok || old.Tags["type"] != "multipolygon" || old.Metadata.Changeset != 80 {
+		t.Errorf("unexpected old state %#v", old)
+	}
+}
+
+func TestParserWithoutMetadata(t *testing.T) {
+	diffs := make(chan osm.Diff, 3)
+	p := NewParser(strings.NewReader(testAdiff), diff.Config{Diffs: diffs})
+	if err := p.Parse(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for d := range diffs {
+		if d.Way != nil && d.Way.Metadata != nil {
+			t.Errorf("unexpected metadata %#v", d.Way.Metadata)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package reader
+
+import (
+	"context"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/go-osm/parser/pbf"
+	osmcache "github.com/omniscale/imposm3/cache"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/memory"
+	"github.com/pkg/errors"
+)
+
+// Elements outside of the -bbox are discarded while reading, before they
+// are cached:
+//
+// - nodes are only kept inside the bbox
+// - ways are kept if one of their nodes is inside the bbox
+// - relations are kept if one of their node or way members is kept
+//
+// Ways that cross the bbox need their nodes outside of the bbox. These
+// nodes are collected while reading the ways and they are read with a
+// second pass over the nodes of the file. The second pass is not possible
+// for stdin and crossing ways are incomplete in this case. Like -limitto,
+// this requires files where all nodes come before ways and all ways before
+// relations.
+
+// BBox is a bounding box in EPSG:4326.
+type BBox struct {
+	MinX, MinY, MaxX, MaxY float64
+}
+
+// ParseBBox parses a bbox in the format minlon,minlat,maxlon,maxlat.
+func ParseBBox(s string) (*BBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return nil, errors.Errorf("invalid bbox %q, expected minlon,minlat,maxlon,maxlat", s)
+	}
+	var v [4]float64
+	for i, p := range parts {
+		var err error
+		v[i], err = strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, errors.Errorf("invalid bbox %q, expected minlon,minlat,maxlon,maxlat", s)
+		}
+	}
+	bbox := &BBox{v[0], v[1], v[2], v[3]}
+	if bbox.MinX >= bbox.MaxX || bbox.MinY >= bbox.MaxY {
+		return nil, errors.Errorf("invalid bbox %q, min values need to be smaller than max values", s)
+	}
+	if bbox.MinX < -180 || bbox.MaxX > 180 || bbox.MinY < -90 || bbox.MaxY > 90 {
+		return nil, errors.Errorf("invalid bbox %q, expected coordinates in EPSG:4326", s)
+	}
+	return bbox, nil
+}
+
+// Contains returns whether the coordinate is inside the bbox.
+func (b *BBox) Contains(long, lat float64) bool {
+	return long >= b.MinX && long <= b.MaxX && lat >= b.MinY && lat <= b.MaxY
+}
+
+// idSet is a set of OSM IDs, stored as bitmaps for 64 consecutive IDs, as
+// IDs of nodes and ways in the same area are often close.
+type idSet struct {
+	mu   sync.RWMutex
+	bits map[int64]uint64
+}
+
+func newIDSet() *idSet {
+	return &idSet{bits: make(map[int64]uint64)}
+}
+
+func (s *idSet) add(ids ...int64) {
+	s.mu.Lock()
+	for _, id := range ids {
+		s.bits[id>>6] |= 1 << uint(id&63)
+	}
+	s.mu.Unlock()
+}
+
+// has returns whether id is in the set. It does not lock the set, callers
+// need to hold the read lock, as the set can be modified concurrently for
+// unsorted files.
+func (s *idSet) has(id int64) bool {
+	return s.bits[id>>6]&(1<<uint(id&63)) != 0
+}
+
+func (s *idSet) len() int {
+	n := 0
+	for _, b := range s.bits {
+		for ; b != 0; b &= b - 1 {
+			n++
+		}
+	}
+	return n
+}
+
+// bboxFilter marks all elements outside of the bbox as skipped.
+type bboxFilter struct {
+	bbox *BBox
+	// nodes inside the bbox
+	nodes *idSet
+	// kept ways
+	ways *idSet
+	// nodes outside the bbox that are referenced by kept ways
+	missing *idSet
+}
+
+func newBBoxFilter(bbox *BBox) *bboxFilter {
+	return &bboxFilter{
+		bbox:    bbox,
+		nodes:   newIDSet(),
+		ways:    newIDSet(),
+		missing: newIDSet(),
+	}
+}
+
+// filterCoords skips all coords outside of the bbox and records all other
+// coords as nodes inside the bbox.
+func (f *bboxFilter) filterCoords(nds []osm.Node) {
+	var inside []int64
+	for i := range nds {
+		if nds[i].ID == osmcache.SKIP {
+			continue
+		}
+		if f.bbox.Contains(nds[i].Long, nds[i].Lat) {
+			inside = append(inside, nds[i].ID)
+		} else {
+			nds[i].ID = osmcache.SKIP
+		}
+	}
+	f.nodes.add(inside...)
+}
+
+// filterNodes skips all nodes outside of the bbox.
+func (f *bboxFilter) filterNodes(nds []osm.Node) {
+	for i := range nds {
+		if !f.bbox.Contains(nds[i].Long, nds[i].Lat) {
+			nds[i].ID = osmcache.SKIP
+		}
+	}
+}
+
+// filterWays skips all ways without any node inside the bbox. Called after
+// all nodes were filtered.
+func (f *bboxFilter) filterWays(ws []osm.Way) {
+	var kept, missing []int64
+	f.nodes.mu.RLock()
+	for i := range ws {
+		if ws[i].ID == osmcache.SKIP {
+			continue
+		}
+		inside := false
+		for _, ref := range ws[i].Refs {
+			if f.nodes.has(ref) {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			ws[i].ID = osmcache.SKIP
+			continue
+		}
+		kept = append(kept, ws[i].ID)
+		for _, ref := range ws[i].Refs {
+			if !f.nodes.has(ref) {
+				missing = append(missing, ref)
+			}
+		}
+	}
+	f.nodes.mu.RUnlock()
+	f.ways.add(kept...)
+	f.missing.add(missing...)
+}
+
+// filterRelations skips all relations without any kept node or way
+// member. Called after all ways were filtered.
+func (f *bboxFilter) filterRelations(rels []osm.Relation) {
+	f.nodes.mu.RLock()
+	defer f.nodes.mu.RUnlock()
+	f.ways.mu.RLock()
+	defer f.ways.mu.RUnlock()
+	for i := range rels {
+		if rels[i].ID == osmcache.SKIP {
+			continue
+		}
+		kept := false
+		for _, m := range rels[i].Members {
+			if m.Type == osm.NodeMember && f.nodes.has(m.ID) ||
+				m.Type == osm.WayMember && f.ways.has(m.ID) {
+				kept = true
+				break
+			}
+		}
+		if !kept {
+			rels[i].ID = osmcache.SKIP
+		}
+	}
+}
+
+// readMissingCoords reads the file again and caches the coords of all nodes
+// outside of the bbox that are referenced by kept ways.
+func (f *bboxFilter) readMissingCoords(ctx context.Context, filename string, cache *osmcache.OSMCache) error {
+	if f.missing.len() == 0 {
+		return nil
+	}
+	if filename == Stdin {
+		log.Printf("[warn] unable to read %d nodes of ways crossing the bbox from stdin, ways are incomplete", f.missing.len())
+		return nil
+	}
+	defer log.Step("Reading nodes of ways crossing the bbox")()
+
+	fh, err := OpenInput(filename)
+	if err != nil {
+		return errors.Wrap(err, "opening input file")
+	}
+	defer fh.Close()
+
+	coords := make(chan []osm.Node, memory.ReadBuffer())
+	parser, err := newParser(fh, pbf.Config{Coords: coords, KeepOpen: true})
+	if err != nil {
+		return err
+	}
+
+	// coords are not ordered after the first pass
+	cache.Coords.SetLinearImport(false)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for nds := range coords {
+				n := 0
+				for _, nd := range nds {
+					if f.missing.has(nd.ID) {
+						nds[n] = nd
+						n++
+					}
+				}
+				if n > 0 {
+					if err := cache.Coords.PutCoords(nds[:n]); err != nil {
+						log.Printf("[error] caching coords: %v", err)
+					}
+				}
+			}
+		}()
+	}
+	err = parser.Parse(ctx)
+	close(coords)
+	wg.Wait()
+	if err != nil {
+		return errors.Wrapf(err, "parsing %s", filename)
+	}
+	return nil
+}
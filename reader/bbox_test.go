@@ -0,0 +1,89 @@
+package reader
+
+import (
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+	osmcache "github.com/omniscale/imposm3/cache"
+)
+
+func TestParseBBox(t *testing.T) {
+	bbox, err := ParseBBox("9.8, 53.4,10.3,53.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *bbox != (BBox{9.8, 53.4, 10.3, 53.7}) {
+		t.Error("unexpected bbox", bbox)
+	}
+	for _, s := range []string{"", "1,2,3", "a,2,3,4", "10,2,3,4", "-200,0,10,10"} {
+		if _, err := ParseBBox(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestIDSet(t *testing.T) {
+	s := newIDSet()
+	s.add(1, 63, 64, 1000000, -5)
+	for _, id := range []int64{1, 63, 64, 1000000, -5} {
+		if !s.has(id) {
+			t.Error("missing", id)
+		}
+	}
+	for _, id := range []int64{0, 2, 65, -4, -6} {
+		if s.has(id) {
+			t.Error("unexpected", id)
+		}
+	}
+	if s.len() != 5 {
+		t.Error("unexpected len", s.len())
+	}
+}
+
+func TestBBoxFilter(t *testing.T) {
+	f := newBBoxFilter(&BBox{0, 0, 10, 10})
+
+	coords := []osm.Node{
+		{Element: osm.Element{ID: 1}, Long: 5, Lat: 5},
+		{Element: osm.Element{ID: 2}, Long: 15, Lat: 5},
+		{Element: osm.Element{ID: 3}, Long: 15, Lat: 15},
+		{Element: osm.Element{ID: 4}, Long: 10, Lat: 0},
+	}
+	f.filterCoords(coords)
+	if coords[0].ID != 1 || coords[1].ID != osmcache.SKIP || coords[2].ID != osmcache.SKIP || coords[3].ID != 4 {
+		t.Errorf("unexpected coords %v", coords)
+	}
+
+	nodes := []osm.Node{
+		{Element: osm.Element{ID: 1}, Long: 5, Lat: 5},
+		{Element: osm.Element{ID: 2}, Long: 15, Lat: 5},
+	}
+	f.filterNodes(nodes)
+	if nodes[0].ID != 1 || nodes[1].ID != osmcache.SKIP {
+		t.Errorf("unexpected nodes %v", nodes)
+	}
+
+	ways := []osm.Way{
+		{Element: osm.Element{ID: 10}, Refs: []int64{1, 4}},
+		{Element: osm.Element{ID: 11}, Refs: []int64{2, 1}}, // crossing
+		{Element: osm.Element{ID: 12}, Refs: []int64{2, 3}},
+	}
+	f.filterWays(ways)
+	if ways[0].ID != 10 || ways[1].ID != 11 || ways[2].ID != osmcache.SKIP {
+		t.Errorf("unexpected ways %v", ways)
+	}
+	if f.missing.len() != 1 || !f.missing.has(2) {
+		t.Errorf("unexpected missing nodes %v", f.missing.bits)
+	}
+
+	rels := []osm.Relation{
+		{Element: osm.Element{ID: 20}, Members: []osm.Member{{ID: 12, Type: osm.WayMember}, {ID: 11, Type: osm.WayMember}}},
+		{Element: osm.Element{ID: 21}, Members: []osm.Member{{ID: 4, Type: osm.NodeMember}}},
+		{Element: osm.Element{ID: 22}, Members: []osm.Member{{ID: 12, Type: osm.WayMember}, {ID: 3, Type: osm.NodeMember}}},
+		{Element: osm.Element{ID: 23}, Members: []osm.Member{{ID: 20, Type: osm.RelationMember}}},
+	}
+	f.filterRelations(rels)
+	if rels[0].ID != 20 || rels[1].ID != 21 || rels[2].ID != osmcache.SKIP || rels[3].ID != osmcache.SKIP {
+		t.Errorf("unexpected relations %v", rels)
+	}
+}
@@ -0,0 +1,110 @@
+// Package changeset decodes OSM changeset files, as published by the
+// changeset replication (replication/changesets/) and the changesets
+// dumps of planet.openstreetmap.org.
+//
+// See https://wiki.openstreetmap.org/wiki/Planet.osm/changesets for the
+// format.
+package changeset
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"time"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/pkg/errors"
+)
+
+// Decoder reads changesets from an uncompressed changeset file.
+type Decoder struct {
+	dec *xml.Decoder
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{dec: xml.NewDecoder(r)}
+}
+
+// Next returns the next changeset. MaxExtent is minlon, minlat, maxlon,
+// maxlat and it is zero for changesets without changes. The discussion is
+// not decoded. Returns io.EOF after the last changeset.
+func (d *Decoder) Next() (*osm.Changeset, error) {
+	var cs *osm.Changeset
+	for {
+		tok, err := d.dec.Token()
+		if err == io.EOF {
+			if cs != nil {
+				return nil, errors.New("parsing changesets: unexpected EOF")
+			}
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing changesets")
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			switch tok.Name.Local {
+			case "changeset":
+				cs = decodeChangeset(tok.Attr)
+			case "tag":
+				if cs == nil {
+					continue
+				}
+				var k, v string
+				for _, attr := range tok.Attr {
+					if attr.Name.Local == "k" {
+						k = attr.Value
+					} else if attr.Name.Local == "v" {
+						v = attr.Value
+					}
+				}
+				if cs.Tags == nil {
+					cs.Tags = make(osm.Tags)
+				}
+				cs.Tags[k] = v
+			case "discussion":
+				if err := d.dec.Skip(); err != nil {
+					return nil, errors.Wrap(err, "parsing changesets")
+				}
+			}
+		case xml.EndElement:
+			if tok.Name.Local == "changeset" && cs != nil {
+				return cs, nil
+			}
+		}
+	}
+}
+
+func decodeChangeset(attrs []xml.Attr) *osm.Changeset {
+	cs := &osm.Changeset{}
+	for _, attr := range attrs {
+		switch attr.Name.Local {
+		case "id":
+			cs.ID, _ = strconv.ParseInt(attr.Value, 10, 64)
+		case "created_at":
+			cs.CreatedAt, _ = time.Parse(time.RFC3339, attr.Value)
+		case "closed_at":
+			cs.ClosedAt, _ = time.Parse(time.RFC3339, attr.Value)
+		case "open":
+			cs.Open = attr.Value == "true"
+		case "user":
+			cs.UserName = attr.Value
+		case "uid":
+			uid, _ := strconv.ParseInt(attr.Value, 10, 32)
+			cs.UserID = int32(uid)
+		case "num_changes":
+			n, _ := strconv.ParseInt(attr.Value, 10, 32)
+			cs.NumChanges = int32(n)
+		case "min_lon":
+			cs.MaxExtent[0], _ = strconv.ParseFloat(attr.Value, 64)
+		case "min_lat":
+			cs.MaxExtent[1], _ = strconv.ParseFloat(attr.Value, 64)
+		case "max_lon":
+			cs.MaxExtent[2], _ = strconv.ParseFloat(attr.Value, 64)
+		case "max_lat":
+			cs.MaxExtent[3], _ = strconv.ParseFloat(attr.Value, 64)
+		}
+	}
+	return cs
+}
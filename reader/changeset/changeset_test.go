@@ -0,0 +1,64 @@
+package changeset
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testChangesets = `<?xml version="1.0" encoding="UTF-8"?>
+<osm version="0.6" generator="replicate_changesets.rb">
+  <changeset id="1001" created_at="2020-01-01T10:00:00Z" closed_at="2020-01-01T10:05:00Z" open="false" num_changes="3" user="mapper" uid="42" min_lat="53.5" max_lat="53.6" min_lon="9.9" max_lon="10.1" comments_count="1">
+    <tag k="comment" v="Add cafes"/>
+    <tag k="created_by" v="JOSM"/>
+    <discussion>
+      <comment uid="43" user="other" date="2020-01-02T10:00:00Z">
+        <text>Thanks</text>
+      </comment>
+    </discussion>
+  </changeset>
+  <changeset id="1002" created_at="2020-01-01T11:00:00Z" open="true" num_changes="0" user="new" uid="44" comments_count="0"/>
+</osm>
+`
+
+func TestDecoder(t *testing.T) {
+	dec := NewDecoder(strings.NewReader(testChangesets))
+
+	cs, err := dec.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.ID != 1001 || cs.UserName != "mapper" || cs.UserID != 42 || cs.NumChanges != 3 || cs.Open {
+		t.Errorf("unexpected changeset %#v", cs)
+	}
+	if !cs.CreatedAt.Equal(time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)) ||
+		!cs.ClosedAt.Equal(time.Date(2020, 1, 1, 10, 5, 0, 0, time.UTC)) {
+		t.Errorf("unexpected times %v %v", cs.CreatedAt, cs.ClosedAt)
+	}
+	if cs.MaxExtent != [4]float64{9.9, 53.5, 10.1, 53.6} {
+		t.Errorf("unexpected extent %v", cs.MaxExtent)
+	}
+	if len(cs.Tags) != 2 || cs.Tags["comment"] != "Add cafes" {
+		t.Errorf("unexpected tags %v", cs.Tags)
+	}
+
+	cs, err = dec.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.ID != 1002 || !cs.Open || !cs.ClosedAt.IsZero() || cs.MaxExtent != [4]float64{} || cs.Tags != nil {
+		t.Errorf("unexpected changeset %#v", cs)
+	}
+
+	if _, err := dec.Next(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestDecoderTruncated(t *testing.T) {
+	dec := NewDecoder(strings.NewReader(`<osm><changeset id="1"><tag k="comment" v="foo"/>`))
+	if _, err := dec.Next(); err == nil || err == io.EOF {
+		t.Errorf("expected error, got %v", err)
+	}
+}
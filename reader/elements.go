@@ -0,0 +1,90 @@
+package reader
+
+import (
+	"context"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/go-osm/parser/pbf"
+	"github.com/pkg/errors"
+)
+
+// Elements are the functions of ReadElements. The functions are called
+// with each batch of parsed elements, from a single goroutine.
+type Elements struct {
+	Nodes     func([]osm.Node) error
+	Ways      func([]osm.Way) error
+	Relations func([]osm.Relation) error
+}
+
+// ReadElements parses all nodes, ways and relations of the file without
+// caching them. Nodes without tags can be included in the batches of
+// nodes. The tags are not filtered. Parsing stops with the first error
+// of a function.
+func ReadElements(ctx context.Context, filename string, elems Elements) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	fh, err := OpenInput(filename)
+	if err != nil {
+		return errors.Wrap(err, "opening input file")
+	}
+	defer fh.Close()
+
+	nodes := make(chan []osm.Node, 4)
+	ways := make(chan []osm.Way, 4)
+	relations := make(chan []osm.Relation, 4)
+	parser, err := newParser(fh, pbf.Config{
+		Nodes:     nodes,
+		Ways:      ways,
+		Relations: relations,
+		KeepOpen:  true,
+	})
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		// closed channels are set to nil, so that select skips them
+		n, w, r := nodes, ways, relations
+		for n != nil || w != nil || r != nil {
+			select {
+			case ns, ok := <-n:
+				if !ok {
+					n = nil
+				} else if err == nil && elems.Nodes != nil {
+					err = elems.Nodes(ns)
+				}
+			case ws, ok := <-w:
+				if !ok {
+					w = nil
+				} else if err == nil && elems.Ways != nil {
+					err = elems.Ways(ws)
+				}
+			case rs, ok := <-r:
+				if !ok {
+					r = nil
+				} else if err == nil && elems.Relations != nil {
+					err = elems.Relations(rs)
+				}
+			}
+			if err != nil {
+				cancel() // drain channels till the parser stops
+			}
+		}
+		done <- err
+	}()
+
+	err = parser.Parse(ctx)
+	close(nodes)
+	close(ways)
+	close(relations)
+	if werr := <-done; werr != nil {
+		err = werr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "parsing %s", filename)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package reader
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/pkg/errors"
+)
+
+func TestReadElements(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imposm_elements")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "test.osm")
+	if err := ioutil.WriteFile(filename, []byte(`<osm version="0.6">
+  <node id="1" lat="42" lon="10">
+    <tag k="amenity" v="cafe"/>
+  </node>
+  <node id="2" lat="43" lon="11"/>
+  <way id="10">
+    <nd ref="1"/>
+    <nd ref="2"/>
+    <tag k="highway" v="residential"/>
+  </way>
+  <relation id="20">
+    <member type="way" ref="10" role="outer"/>
+    <tag k="type" v="route"/>
+  </relation>
+</osm>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// batches of nodes, ways and relations are not ordered
+	tags := make(map[string]string)
+	add := func(t osm.Tags) {
+		for k, v := range t {
+			tags[k] = v
+		}
+	}
+	err = ReadElements(context.Background(), filename, Elements{
+		Nodes: func(nodes []osm.Node) error {
+			for _, n := range nodes {
+				add(n.Tags)
+			}
+			return nil
+		},
+		Ways: func(ways []osm.Way) error {
+			for _, w := range ways {
+				add(w.Tags)
+			}
+			return nil
+		},
+		Relations: func(rels []osm.Relation) error {
+			for _, r := range rels {
+				add(r.Tags)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 3 || tags["amenity"] != "cafe" || tags["highway"] != "residential" || tags["type"] != "route" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+
+	errStop := errors.New("stop")
+	err = ReadElements(context.Background(), filename, Elements{
+		Ways: func(ways []osm.Way) error { return errStop },
+	})
+	if errors.Cause(err) != errStop {
+		t.Errorf("unexpected error %v", err)
+	}
+}
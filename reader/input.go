@@ -0,0 +1,335 @@
+package reader
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/omniscale/imposm3/log"
+	"github.com/pkg/errors"
+)
+
+// Input files can be local files or remote files (http://, https:// and
+// s3:// URLs). Remote files are streamed while they are parsed, without
+// storing them on disk. Downloads are resumed with range requests after
+// network errors.
+//
+// s3:// URLs are requested from the virtual-hosted endpoint of the bucket,
+// or from AWS_ENDPOINT_URL with path-style requests (e.g. for MinIO).
+// Requests are signed if AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are
+// set.
+
+const maxRetries = 10
+
+// retryWait is the wait time before the first retry, it is doubled for
+// each retry.
+var retryWait = time.Second
+
+// Stdin is the name of the input that reads from stdin.
+const Stdin = "-"
+
+// isRemote returns whether name is an URL of a remote file.
+func isRemote(name string) bool {
+	return strings.HasPrefix(name, "http://") ||
+		strings.HasPrefix(name, "https://") ||
+		strings.HasPrefix(name, "s3://")
+}
+
+// OpenInput opens a local file, a remote file or stdin.
+func OpenInput(name string) (io.ReadCloser, error) {
+	if name == Stdin {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	if !isRemote(name) {
+		return os.Open(name)
+	}
+	req, err := newRemoteRequest(name)
+	if err != nil {
+		return nil, err
+	}
+	r := &remoteReader{req: req, size: -1}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// StatInput returns the size and the modification time of a local or
+// remote file. Returns zero values for stdin.
+func StatInput(name string) (int64, time.Time, error) {
+	if name == Stdin {
+		return 0, time.Time{}, nil
+	}
+	if !isRemote(name) {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		return fi.Size(), fi.ModTime(), nil
+	}
+	req, err := newRemoteRequest(name)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	resp, err := req.do("HEAD", nil)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, time.Time{}, errors.Errorf("requesting %s: %s", name, resp.Status)
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return resp.ContentLength, modTime, nil
+}
+
+// remoteRequest creates requests for a remote file.
+type remoteRequest struct {
+	name   string
+	url    string
+	signer *awsSigner
+}
+
+func newRemoteRequest(name string) (*remoteRequest, error) {
+	if !strings.HasPrefix(name, "s3://") {
+		return &remoteRequest{name: name, url: name}, nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(name, "s3://"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, errors.Errorf("invalid S3 URL %q, expected s3://bucket/key", name)
+	}
+	bucket, key := parts[0], parts[1]
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	u := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, region, awsURIEncode(key))
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		u = fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(endpoint, "/"), bucket, awsURIEncode(key))
+	}
+	req := &remoteRequest{name: name, url: u}
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		req.signer = &awsSigner{
+			accessKeyID:  id,
+			secretKey:    secret,
+			sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+			region:       region,
+		}
+	}
+	return req, nil
+}
+
+func (rr *remoteRequest) do(method string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, rr.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if rr.signer != nil {
+		rr.signer.sign(req, time.Now())
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// remoteReader reads the body of a remote file. The request is repeated
+// from the current offset after network errors.
+type remoteReader struct {
+	req    *remoteRequest
+	body   io.ReadCloser
+	offset int64
+	size   int64 // -1 if unknown
+	etag   string
+	// failures is the number of read errors since the last successful read
+	failures int
+}
+
+// open requests the file from the current offset.
+func (r *remoteReader) open() error {
+	wait := retryWait
+	for attempt := 0; ; attempt++ {
+		err := r.request()
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(permanentError); ok || attempt >= maxRetries {
+			return err
+		}
+		log.Printf("[warn] %s, retrying in %s", err, wait)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+type permanentError struct{ error }
+
+func (r *remoteReader) request() error {
+	header := http.Header{}
+	if r.offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+		if r.etag != "" {
+			header.Set("If-Range", r.etag)
+		}
+	}
+	resp, err := r.req.do("GET", header)
+	if err != nil {
+		return errors.Wrapf(err, "requesting %s", r.req.name)
+	}
+	if resp.StatusCode >= 500 {
+		resp.Body.Close()
+		return errors.Errorf("requesting %s: %s", r.req.name, resp.Status)
+	}
+	if r.offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return permanentError{errors.Errorf("unable to resume %s, file changed or range requests not supported", r.req.name)}
+		}
+		return permanentError{errors.Errorf("requesting %s: %s", r.req.name, resp.Status)}
+	}
+	if r.offset == 0 {
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return permanentError{errors.Errorf("requesting %s: %s", r.req.name, resp.Status)}
+		}
+		r.size = resp.ContentLength
+		r.etag = resp.Header.Get("ETag")
+	}
+	r.body = resp.Body
+	return nil
+}
+
+func (r *remoteReader) Read(p []byte) (int, error) {
+	if r.body == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if n > 0 {
+		r.failures = 0
+	}
+	if err == io.EOF && (r.size < 0 || r.offset >= r.size) {
+		return n, io.EOF
+	}
+	if err != nil {
+		r.body.Close()
+		r.body = nil
+		r.failures++
+		if r.failures > maxRetries {
+			return n, errors.Wrapf(err, "reading %s", r.req.name)
+		}
+		log.Printf("[warn] reading %s at %d: %s, resuming", r.req.name, r.offset, err)
+		if n == 0 {
+			return r.Read(p)
+		}
+	}
+	return n, nil
+}
+
+func (r *remoteReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	return r.body.Close()
+}
+
+// awsSigner signs S3 requests with AWS Signature Version 4.
+type awsSigner struct {
+	accessKeyID  string
+	secretKey    string
+	sessionToken string
+	region       string
+}
+
+// emptyPayloadHash is the SHA256 of the empty body of GET and HEAD requests.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func (s *awsSigner) sign(req *http.Request, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", emptyPayloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if k == "range" || strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(v[0])
+		}
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, k := range names {
+		canonicalHeaders += k + ":" + headers[k] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsURIEncode encodes all characters of an S3 key, except unreserved
+// characters and slashes.
+func awsURIEncode(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,189 @@
+package reader
+
+import (
+	"context"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/go-osm/parser/pbf"
+	osmcache "github.com/omniscale/imposm3/cache"
+	"github.com/omniscale/imposm3/geom/geos"
+	"github.com/omniscale/imposm3/geom/limit"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/pkg/errors"
+)
+
+// With -low-disk, ways are only cached if they are members of a relation.
+// All ways are kept in an idSet while reading, so that relations can be
+// checked for -limitto. The member ways are cached with a second pass over
+// the ways of the file, after all relations are cached. All other ways are
+// read again for -write with StreamWays. This requires a file (not stdin).
+
+// firstMemberIsKept is like OSMCache.FirstMemberIsCached, but it checks
+// way members in the set of kept ways, as ways are not cached.
+func firstMemberIsKept(cache *osmcache.OSMCache, ways *idSet, members []osm.Member) (bool, error) {
+	for _, m := range members {
+		if m.Type == osm.WayMember {
+			ways.mu.RLock()
+			kept := ways.has(m.ID)
+			ways.mu.RUnlock()
+			return kept, nil
+		} else if m.Type == osm.NodeMember {
+			return cache.FirstMemberIsCached([]osm.Member{m})
+		}
+	}
+	return true, nil
+}
+
+// readMemberWays caches all kept ways that are members of a cached
+// relation.
+func readMemberWays(ctx context.Context, filename string, cache *osmcache.OSMCache, tagmapping *mapping.Mapping, kept *idSet) error {
+	defer log.Step("Reading member ways of relations")()
+
+	members := newIDSet()
+	for rel := range cache.Relations.IterContext(ctx) {
+		var ids []int64
+		for _, m := range rel.Members {
+			if m.Type == osm.WayMember && kept.has(m.ID) {
+				ids = append(ids, m.ID)
+			}
+		}
+		members.add(ids...)
+	}
+	if members.len() == 0 {
+		return nil
+	}
+
+	return readWays(ctx, filename, tagmapping, func(ws []osm.Way) error {
+		n := 0
+		for _, w := range ws {
+			if members.has(w.ID) {
+				ws[n] = w
+				n++
+			}
+		}
+		if n == 0 {
+			return nil
+		}
+		return cache.Ways.PutWays(ws[:n])
+	})
+}
+
+// StreamWays reads all ways with tags from the file, for -write of imports
+// with -low-disk. The tags are filtered like the tags of cached ways and
+// ways outside of the limiter and the bbox are skipped, like ways that are
+// not cached by ReadPbf. The channel is closed after all ways are read and
+// wait returns the error of the parser. Reading stops early if ctx is
+// cancelled.
+func StreamWays(ctx context.Context, filename string, tagmapping *mapping.Mapping, cache *osmcache.OSMCache, limiter *limit.Limiter, bbox *BBox) (ways chan *osm.Way, wait func() error) {
+	ways = make(chan *osm.Way, 1024)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(ways)
+		g := geos.NewGeos()
+		defer g.Finish()
+		errc <- readWays(ctx, filename, tagmapping, func(ws []osm.Way) error {
+			for i := range ws {
+				if len(ws[i].Tags) == 0 {
+					continue
+				}
+				keep, err := keepWay(g, cache, limiter, bbox, &ws[i])
+				if err != nil {
+					return err
+				}
+				if keep {
+					ways <- &ws[i]
+				}
+			}
+			return nil
+		})
+	}()
+	return ways, func() error { return <-errc }
+}
+
+// keepWay returns whether the way is kept by the filters of ReadPbf: ways
+// need a node inside the bbox and the first node inside the buffer of the
+// limiter. Node locations are taken from the way, or from the cached coords.
+func keepWay(g *geos.Geos, cache *osmcache.OSMCache, limiter *limit.Limiter, bbox *BBox, w *osm.Way) (bool, error) {
+	if limiter == nil && bbox == nil {
+		return true, nil
+	}
+	if len(w.Refs) == 0 {
+		return false, nil
+	}
+	coord := func(i int) (*osm.Node, error) {
+		if len(w.Nodes) == len(w.Refs) {
+			return &w.Nodes[i], nil
+		}
+		nd, err := cache.Coords.GetCoord(w.Refs[i])
+		if err == osmcache.NotFound {
+			return nil, nil
+		}
+		return nd, err
+	}
+
+	if limiter != nil {
+		nd, err := coord(0)
+		if err != nil {
+			return false, errors.Wrapf(err, "checking first ref of way %d", w.ID)
+		}
+		if nd == nil || !limiter.IntersectsBuffer(g, nd.Long, nd.Lat) {
+			return false, nil
+		}
+	}
+	if bbox != nil {
+		for i := range w.Refs {
+			nd, err := coord(i)
+			if err != nil {
+				return false, errors.Wrapf(err, "checking refs of way %d", w.ID)
+			}
+			if nd != nil && bbox.Contains(nd.Long, nd.Lat) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// readWays parses all ways of the file and calls f with each batch, after
+// the tags are filtered.
+func readWays(ctx context.Context, filename string, tagmapping *mapping.Mapping, f func([]osm.Way) error) error {
+	fh, err := OpenInput(filename)
+	if err != nil {
+		return errors.Wrap(err, "opening input file")
+	}
+	defer fh.Close()
+
+	ways := make(chan []osm.Way, 4)
+	parser, err := newParser(fh, pbf.Config{Ways: ways, KeepOpen: true})
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		m := tagmapping.WayTagFilter()
+		for ws := range ways {
+			if err != nil {
+				continue // drain channel
+			}
+			for i := range ws {
+				m.Filter(&ws[i].Tags)
+			}
+			err = f(ws)
+		}
+		done <- err
+	}()
+
+	err = parser.Parse(ctx)
+	close(ways)
+	if werr := <-done; err == nil {
+		err = werr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "parsing %s", filename)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package reader
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/pkg/errors"
+)
+
+func TestStreamWays(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imposm_lowdisk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "test.osm")
+	if err := ioutil.WriteFile(filename, []byte(`<osm version="0.6">
+  <node id="1" lat="42" lon="10"/>
+  <node id="2" lat="43" lon="11"/>
+  <way id="10">
+    <nd ref="1"/>
+    <nd ref="2"/>
+    <tag k="highway" v="residential"/>
+    <tag k="note" v="not mapped"/>
+  </way>
+  <way id="11">
+    <nd ref="1"/>
+    <nd ref="2"/>
+    <tag k="note" v="not mapped"/>
+  </way>
+  <way id="12">
+    <nd ref="2"/>
+    <nd ref="1"/>
+  </way>
+</osm>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := mapping.New([]byte(`
+tables:
+  roads:
+    type: linestring
+    mapping:
+      highway: [__any__]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ways, wait := StreamWays(context.Background(), filename, m, nil, nil, nil)
+	var ids []int64
+	for w := range ways {
+		ids = append(ids, w.ID)
+		if w.ID == 10 && (len(w.Tags) != 1 || w.Tags["highway"] != "residential" || len(w.Refs) != 2) {
+			t.Errorf("unexpected way %v", w)
+		}
+	}
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != 10 {
+		t.Errorf("unexpected ways %v", ids)
+	}
+
+	ways, wait = StreamWays(context.Background(), filepath.Join(dir, "missing.osm"), m, nil, nil, nil)
+	for range ways {
+	}
+	if err := wait(); err == nil {
+		t.Error("expected error for missing file")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ways, wait = StreamWays(ctx, filename, m, nil, nil, nil)
+	for range ways {
+	}
+	if err := wait(); errors.Cause(err) != context.Canceled {
+		t.Errorf("expected canceled error, got %v", err)
+	}
+}
+
+func TestKeepWay(t *testing.T) {
+	bbox := &BBox{10, 40, 11, 41}
+	way := func(coords ...float64) *osm.Way {
+		w := &osm.Way{}
+		for i := 0; i < len(coords); i += 2 {
+			w.Refs = append(w.Refs, int64(i+1))
+			w.Nodes = append(w.Nodes, osm.Node{Long: coords[i], Lat: coords[i+1]})
+		}
+		return w
+	}
+	for _, tc := range []struct {
+		way  *osm.Way
+		bbox *BBox
+		keep bool
+	}{
+		{way(12, 42, 13, 43), nil, true},
+		{way(10.5, 40.5, 13, 43), bbox, true},
+		{way(12, 42, 10.5, 40.5), bbox, true},
+		{way(12, 42, 13, 43), bbox, false},
+		{way(), bbox, false},
+	} {
+		keep, err := keepWay(nil, nil, nil, tc.bbox, tc.way)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keep != tc.keep {
+			t.Errorf("unexpected result %v for %v", keep, tc.way.Nodes)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package reader
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/omniscale/go-osm/parser/pbf"
+	"github.com/omniscale/imposm3/reader/o5m"
+	"github.com/pkg/errors"
+)
+
+// o5mParser parses o5m files.
+type o5mParser struct {
+	dec  *o5m.Decoder
+	conf pbf.Config
+}
+
+func newO5MParser(r io.Reader, conf pbf.Config) *o5mParser {
+	return &o5mParser{dec: o5m.NewDecoder(r), conf: conf}
+}
+
+func (p *o5mParser) Timestamp() (time.Time, error) {
+	header, err := p.dec.Header()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if header.Change {
+		return time.Time{}, errors.New("o5c change files can only be imported with imposm diff")
+	}
+	return header.Time, nil
+}
+
+func (p *o5mParser) Parse(ctx context.Context) error {
+	b := newBatchSender(p.conf)
+	defer b.close()
+	if _, err := p.Timestamp(); err != nil {
+		return err
+	}
+	for {
+		elem, err := p.dec.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case elem.Deleted:
+		case elem.Node != nil:
+			b.node(*elem.Node)
+		case elem.Way != nil:
+			b.way(*elem.Way)
+		case elem.Rel != nil:
+			b.relation(*elem.Rel)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	b.flush()
+	return nil
+}
@@ -0,0 +1,64 @@
+package o5m
+
+import (
+	"context"
+	"io"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/go-osm/parser/diff"
+	"github.com/pkg/errors"
+)
+
+// ChangeParser parses o5c change files. It sends the elements to the same
+// channel as the parser for .osc files.
+//
+// o5c files do not distinguish between created and modified elements, all
+// elements that are not deleted are sent as modified.
+type ChangeParser struct {
+	dec  *Decoder
+	conf diff.Config
+}
+
+// NewChangeParser returns a parser for the o5c file of r.
+func NewChangeParser(r io.Reader, conf diff.Config) *ChangeParser {
+	return &ChangeParser{dec: NewDecoder(r), conf: conf}
+}
+
+// Parse parses all elements and sends them to the Diffs channel.
+func (p *ChangeParser) Parse(ctx context.Context) error {
+	if !p.conf.KeepOpen {
+		defer func() {
+			if p.conf.Diffs != nil {
+				close(p.conf.Diffs)
+			}
+		}()
+	}
+	header, err := p.dec.Header()
+	if err != nil {
+		return err
+	}
+	if !header.Change {
+		return errors.New("expected o5c change file, got o5m file")
+	}
+	for {
+		elem, err := p.dec.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		d := osm.Diff{
+			Node:   elem.Node,
+			Way:    elem.Way,
+			Rel:    elem.Rel,
+			Delete: elem.Deleted,
+			Modify: !elem.Deleted,
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case p.conf.Diffs <- d:
+		}
+	}
+}
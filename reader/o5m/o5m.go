@@ -0,0 +1,456 @@
+// Package o5m decodes o5m files and o5c change files, as written by
+// osmconvert, osmfilter and osmupdate.
+//
+// See https://wiki.openstreetmap.org/wiki/O5m for the format.
+package o5m
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"time"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/pkg/errors"
+)
+
+// Dataset types.
+const (
+	dsNode      = 0x10
+	dsWay       = 0x11
+	dsRelation  = 0x12
+	dsBBox      = 0xdb
+	dsTimestamp = 0xdc
+	dsHeader    = 0xe0
+	dsEnd       = 0xfe
+	dsReset     = 0xff
+)
+
+const (
+	// stringTableSize is the number of strings in the reference table.
+	stringTableSize = 15000
+	// maxStringSize is the max size of strings (including the zero bytes)
+	// that are stored in the reference table.
+	maxStringSize = 252
+	// maxDatasetSize protects against invalid length values.
+	maxDatasetSize = 256 * 1024 * 1024
+)
+
+// HasMagic returns whether the data starts with the header of an o5m or
+// o5c file.
+func HasMagic(data []byte) bool {
+	return bytes.HasPrefix(data, []byte{dsReset, dsHeader})
+}
+
+// Header is the header of an o5m or o5c file.
+type Header struct {
+	// Change is true for o5c change files.
+	Change bool
+	// Time is the timestamp of the file, or a zero time if the file has no
+	// timestamp.
+	Time time.Time
+}
+
+// Element is a decoded node, way or relation. Only one of Node, Way or Rel
+// is set.
+type Element struct {
+	Node *osm.Node
+	Way  *osm.Way
+	Rel  *osm.Relation
+	// Deleted is true for deleted elements of change files. Deleted
+	// elements only have an ID.
+	Deleted bool
+}
+
+// Decoder reads elements from an o5m or o5c file. Metadata (version,
+// timestamp, changeset and user) are skipped.
+type Decoder struct {
+	r      *bufio.Reader
+	buf    []byte
+	header *Header
+
+	// first dataset after the header
+	firstType byte
+	first     []byte
+
+	// state of the delta coding, reset with each reset dataset
+	id, timestamp, changeset int64
+	lon, lat                 int64
+	wayRef                   int64
+	memberRefs               [3]int64
+	strings                  [stringTableSize]string
+	stringPos                int
+}
+
+// NewDecoder returns a decoder for r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+func (d *Decoder) reset() {
+	d.id, d.timestamp, d.changeset = 0, 0, 0
+	d.lon, d.lat = 0, 0
+	d.wayRef = 0
+	d.memberRefs = [3]int64{}
+	for i := range d.strings {
+		d.strings[i] = ""
+	}
+	d.stringPos = 0
+}
+
+// readDataset returns the type and content of the next dataset. Returns
+// io.EOF at the end of the file.
+func (d *Decoder) readDataset() (byte, []byte, error) {
+	for {
+		typ, err := d.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		// datasets 0xf0-0xff have no content
+		if typ >= 0xf0 {
+			if typ == dsReset {
+				d.reset()
+			} else if typ == dsEnd {
+				return 0, nil, io.EOF
+			}
+			continue
+		}
+		size, err := binary.ReadUvarint(d.r)
+		if err != nil {
+			return 0, nil, errors.Wrap(noEOF(err), "reading dataset length")
+		}
+		if size > maxDatasetSize {
+			return 0, nil, errors.Errorf("dataset 0x%02x with invalid length %d", typ, size)
+		}
+		if cap(d.buf) < int(size) {
+			d.buf = make([]byte, size)
+		}
+		d.buf = d.buf[:size]
+		if _, err := io.ReadFull(d.r, d.buf); err != nil {
+			return 0, nil, errors.Wrapf(noEOF(err), "reading dataset 0x%02x", typ)
+		}
+		return typ, d.buf, nil
+	}
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Header reads the header of the file, including the file timestamp.
+func (d *Decoder) Header() (*Header, error) {
+	if d.header != nil {
+		return d.header, nil
+	}
+	typ, data, err := d.readDataset()
+	if err != nil {
+		return nil, errors.Wrap(noEOF(err), "reading o5m header")
+	}
+	if typ != dsHeader || (string(data) != "o5m2" && string(data) != "o5c2") {
+		return nil, errors.New("reading o5m header: not an o5m or o5c file")
+	}
+	header := &Header{Change: string(data) == "o5c2"}
+	for {
+		typ, data, err := d.readDataset()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if typ == dsTimestamp {
+			ds := dataset{data: data}
+			if ts := ds.varint(); ds.err == nil && ts > 0 {
+				header.Time = time.Unix(ts, 0).UTC()
+			}
+			continue
+		}
+		if typ == dsNode || typ == dsWay || typ == dsRelation {
+			d.firstType = typ
+			d.first = append([]byte(nil), data...)
+			break
+		}
+	}
+	d.header = header
+	return header, nil
+}
+
+// Next returns the next node, way or relation. Returns io.EOF at the end of
+// the file.
+func (d *Decoder) Next() (Element, error) {
+	if _, err := d.Header(); err != nil {
+		return Element{}, err
+	}
+	for {
+		var typ byte
+		var data []byte
+		if d.first != nil {
+			typ, data = d.firstType, d.first
+			d.first = nil
+		} else {
+			var err error
+			typ, data, err = d.readDataset()
+			if err != nil {
+				return Element{}, err
+			}
+		}
+		ds := &dataset{data: data}
+		var elem Element
+		switch typ {
+		case dsNode:
+			elem = d.decodeNode(ds)
+		case dsWay:
+			elem = d.decodeWay(ds)
+		case dsRelation:
+			elem = d.decodeRelation(ds)
+		default:
+			continue
+		}
+		if ds.err != nil {
+			return Element{}, errors.Wrapf(ds.err, "decoding dataset 0x%02x", typ)
+		}
+		return elem, nil
+	}
+}
+
+// decodeInfo decodes and skips the version, timestamp, changeset and user
+// of an element.
+func (d *Decoder) decodeInfo(ds *dataset) {
+	if version := ds.uvarint(); version == 0 {
+		return
+	}
+	d.timestamp += ds.varint()
+	if d.timestamp == 0 {
+		return
+	}
+	d.changeset += ds.varint()
+	d.decodeUser(ds)
+}
+
+func (d *Decoder) decodeNode(ds *dataset) Element {
+	d.id += ds.varint()
+	node := &osm.Node{Element: osm.Element{ID: d.id}}
+	d.decodeInfo(ds)
+	if ds.end() {
+		return Element{Node: node, Deleted: true}
+	}
+	d.lon += ds.varint()
+	d.lat += ds.varint()
+	node.Long = float64(d.lon) / 1e7
+	node.Lat = float64(d.lat) / 1e7
+	node.Tags = d.decodeTags(ds)
+	return Element{Node: node}
+}
+
+func (d *Decoder) decodeWay(ds *dataset) Element {
+	d.id += ds.varint()
+	way := &osm.Way{Element: osm.Element{ID: d.id}}
+	d.decodeInfo(ds)
+	if ds.end() {
+		return Element{Way: way, Deleted: true}
+	}
+	refs := ds.section()
+	for !refs.end() {
+		d.wayRef += refs.varint()
+		way.Refs = append(way.Refs, d.wayRef)
+	}
+	ds.setErr(refs.err)
+	way.Tags = d.decodeTags(ds)
+	return Element{Way: way}
+}
+
+func (d *Decoder) decodeRelation(ds *dataset) Element {
+	d.id += ds.varint()
+	rel := &osm.Relation{Element: osm.Element{ID: d.id}}
+	d.decodeInfo(ds)
+	if ds.end() {
+		return Element{Rel: rel, Deleted: true}
+	}
+	members := ds.section()
+	for !members.end() {
+		delta := members.varint()
+		s := d.decodeStrings(members, 1)
+		if len(s) != 1 || len(s[0]) == 0 || s[0][0] < '0' || s[0][0] > '2' {
+			members.setErr(errors.New("invalid member type"))
+			break
+		}
+		t := s[0][0] - '0'
+		d.memberRefs[t] += delta
+		rel.Members = append(rel.Members, osm.Member{
+			ID:   d.memberRefs[t],
+			Type: []osm.MemberType{osm.NodeMember, osm.WayMember, osm.RelationMember}[t],
+			Role: s[0][1:],
+		})
+	}
+	ds.setErr(members.err)
+	rel.Tags = d.decodeTags(ds)
+	return Element{Rel: rel}
+}
+
+func (d *Decoder) decodeTags(ds *dataset) osm.Tags {
+	var tags osm.Tags
+	for !ds.end() {
+		s := d.decodeStrings(ds, 2)
+		if len(s) != 2 {
+			break
+		}
+		if tags == nil {
+			tags = make(osm.Tags)
+		}
+		tags[s[0]] = s[1]
+	}
+	return tags
+}
+
+// decodeStrings decodes n zero terminated strings, either inline or as a
+// reference to the string table.
+func (d *Decoder) decodeStrings(ds *dataset, n int) []string {
+	raw, ok := d.stringRef(ds, func() []byte {
+		start := ds.pos
+		for i := 0; i < n; i++ {
+			idx := bytes.IndexByte(ds.data[ds.pos:], 0)
+			if idx < 0 {
+				ds.setErr(errors.New("unterminated string"))
+				return nil
+			}
+			ds.pos += idx + 1
+		}
+		return ds.data[start:ds.pos]
+	})
+	if !ok {
+		return nil
+	}
+	s := bytes.SplitN(raw, []byte{0}, n+1)
+	if len(s) <= n {
+		ds.setErr(errors.New("invalid string reference"))
+		return nil
+	}
+	result := make([]string, n)
+	for i := range result {
+		result[i] = string(s[i])
+	}
+	return result
+}
+
+// decodeUser decodes and skips the uid and user name. The uid is stored as
+// a varint, the user name is missing for uid 0.
+func (d *Decoder) decodeUser(ds *dataset) {
+	d.stringRef(ds, func() []byte {
+		start := ds.pos
+		ds.uvarint()
+		if ds.end() || ds.data[ds.pos] != 0 {
+			ds.setErr(errors.New("invalid user"))
+			return nil
+		}
+		ds.pos++
+		if uid, _ := binary.Uvarint(ds.data[start:]); uid == 0 {
+			return ds.data[start:ds.pos]
+		}
+		idx := bytes.IndexByte(ds.data[ds.pos:], 0)
+		if idx < 0 {
+			ds.setErr(errors.New("unterminated user"))
+			return nil
+		}
+		ds.pos += idx + 1
+		return ds.data[start:ds.pos]
+	})
+}
+
+// stringRef returns an inline string (decoded with inline) or a string from
+// the table. Inline strings are added to the table.
+func (d *Decoder) stringRef(ds *dataset, inline func() []byte) ([]byte, bool) {
+	if ds.end() {
+		ds.setErr(io.ErrUnexpectedEOF)
+		return nil, false
+	}
+	if ds.data[ds.pos] == 0 {
+		ds.pos++
+		raw := inline()
+		if ds.err != nil {
+			return nil, false
+		}
+		if len(raw) <= maxStringSize {
+			d.strings[d.stringPos] = string(raw)
+			d.stringPos = (d.stringPos + 1) % stringTableSize
+		}
+		return raw, true
+	}
+	ref := ds.uvarint()
+	if ds.err != nil || ref < 1 || ref > stringTableSize {
+		ds.setErr(errors.Errorf("invalid string reference %d", ref))
+		return nil, false
+	}
+	s := d.strings[(d.stringPos-int(ref)+stringTableSize)%stringTableSize]
+	if s == "" {
+		ds.setErr(errors.Errorf("invalid string reference %d", ref))
+		return nil, false
+	}
+	return []byte(s), true
+}
+
+// dataset decodes the values of a dataset. The first error is stored in err
+// and all following values are zero.
+type dataset struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (ds *dataset) end() bool {
+	return ds.err != nil || ds.pos >= len(ds.data)
+}
+
+func (ds *dataset) setErr(err error) {
+	if ds.err == nil && err != nil {
+		ds.err = err
+		ds.pos = len(ds.data)
+	}
+}
+
+func (ds *dataset) uvarint() uint64 {
+	if ds.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(ds.data[ds.pos:])
+	if n <= 0 {
+		ds.setErr(errors.New("invalid number"))
+		return 0
+	}
+	ds.pos += n
+	return v
+}
+
+// varint decodes a signed number, the lowest bit is the sign (zig-zag
+// encoding).
+func (ds *dataset) varint() int64 {
+	if ds.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(ds.data[ds.pos:])
+	if n <= 0 {
+		ds.setErr(errors.New("invalid number"))
+		return 0
+	}
+	ds.pos += n
+	return v
+}
+
+// section returns the following section with a length prefix (way refs
+// and relation members).
+func (ds *dataset) section() *dataset {
+	size := ds.uvarint()
+	if ds.err != nil {
+		return &dataset{err: ds.err}
+	}
+	if size > uint64(len(ds.data)-ds.pos) {
+		ds.setErr(errors.New("invalid section length"))
+		return &dataset{err: ds.err}
+	}
+	sec := &dataset{data: ds.data[ds.pos : ds.pos+int(size)]}
+	ds.pos += int(size)
+	return sec
+}
@@ -0,0 +1,172 @@
+package o5m
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/go-osm/parser/diff"
+)
+
+func uvarint(v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutUvarint(buf, v)]
+}
+
+func varint(v int64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutVarint(buf, v)]
+}
+
+func ds(typ byte, parts ...[]byte) []byte {
+	data := bytes.Join(parts, nil)
+	return append(append([]byte{typ}, uvarint(uint64(len(data)))...), data...)
+}
+
+// section returns the parts with a length prefix.
+func section(parts ...[]byte) []byte {
+	data := bytes.Join(parts, nil)
+	return append(uvarint(uint64(len(data))), data...)
+}
+
+func str(s string) []byte {
+	return []byte(s)
+}
+
+func testFile() []byte {
+	return bytes.Join([][]byte{
+		{dsReset},
+		ds(dsHeader, str("o5m2")),
+		ds(dsTimestamp, varint(1551441600)),
+		// node 5 with metadata and inline tag
+		ds(dsNode, varint(5),
+			uvarint(1), varint(100), varint(7), str("\x00"), uvarint(42), str("\x00bob\x00"),
+			varint(100000000), varint(420000000),
+			str("\x00amenity\x00cafe\x00")),
+		// node 6 without metadata, tag as reference
+		ds(dsNode, varint(1), uvarint(0),
+			varint(10000000), varint(0),
+			uvarint(1)),
+		// way 10
+		ds(dsWay, varint(4), uvarint(0),
+			section(varint(5), varint(1)),
+			str("\x00highway\x00residential\x00")),
+		{dsReset},
+		// relation 20
+		ds(dsRelation, varint(20), uvarint(0),
+			section(varint(10), str("\x001outer\x00"), varint(5), str("\x000\x00")),
+			str("\x00type\x00multipolygon\x00")),
+		{dsEnd},
+	}, nil)
+}
+
+func TestDecoder(t *testing.T) {
+	if !HasMagic(testFile()) {
+		t.Fatal("magic not detected")
+	}
+	d := NewDecoder(bytes.NewReader(testFile()))
+	header, err := d.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Change || !header.Time.Equal(time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected header %v", header)
+	}
+
+	var elems []Element
+	for {
+		elem, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		elems = append(elems, elem)
+	}
+	if len(elems) != 4 {
+		t.Fatalf("unexpected elements %v", elems)
+	}
+
+	n := elems[0].Node
+	if n == nil || n.ID != 5 || n.Long != 10 || n.Lat != 42 || n.Tags["amenity"] != "cafe" {
+		t.Errorf("unexpected node %v", elems[0])
+	}
+	n = elems[1].Node
+	if n == nil || n.ID != 6 || n.Long != 11 || n.Lat != 42 || n.Tags["amenity"] != "cafe" {
+		t.Errorf("unexpected node %v", elems[1])
+	}
+	w := elems[2].Way
+	if w == nil || w.ID != 10 || len(w.Refs) != 2 || w.Refs[0] != 5 || w.Refs[1] != 6 || w.Tags["highway"] != "residential" {
+		t.Errorf("unexpected way %v", elems[2])
+	}
+	r := elems[3].Rel
+	if r == nil || r.ID != 20 || len(r.Members) != 2 || r.Tags["type"] != "multipolygon" {
+		t.Fatalf("unexpected relation %v", elems[3])
+	}
+	if r.Members[0] != (osm.Member{ID: 10, Type: osm.WayMember, Role: "outer"}) ||
+		r.Members[1] != (osm.Member{ID: 5, Type: osm.NodeMember, Role: ""}) {
+		t.Errorf("unexpected members %v", r.Members)
+	}
+}
+
+func TestDecoderInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		{},
+		str("<osm>"),
+		append([]byte{dsReset}, ds(dsHeader, str("o5x2"))...),
+		// invalid string reference
+		bytes.Join([][]byte{{dsReset}, ds(dsHeader, str("o5m2")), ds(dsNode, varint(1), uvarint(0), varint(0), varint(0), uvarint(3))}, nil),
+		// truncated dataset
+		bytes.Join([][]byte{{dsReset}, ds(dsHeader, str("o5m2")), {dsNode, 10, 1}}, nil),
+	} {
+		d := NewDecoder(bytes.NewReader(data))
+		if _, err := d.Next(); err == nil || err == io.EOF {
+			t.Errorf("expected error for %v, got %v", data, err)
+		}
+	}
+}
+
+func TestChangeParser(t *testing.T) {
+	data := bytes.Join([][]byte{
+		{dsReset},
+		ds(dsHeader, str("o5c2")),
+		// deleted node 7
+		ds(dsNode, varint(7), uvarint(1), varint(0)),
+		// modified node 8
+		ds(dsNode, varint(1), uvarint(0), varint(10), varint(20)),
+	}, nil)
+
+	diffs := make(chan osm.Diff)
+	p := NewChangeParser(bytes.NewReader(data), diff.Config{Diffs: diffs})
+	done := make(chan error)
+	go func() { done <- p.Parse(context.Background()) }()
+	var result []osm.Diff
+	for d := range diffs {
+		result = append(result, d)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 2 {
+		t.Fatalf("unexpected diffs %v", result)
+	}
+	if !result[0].Delete || result[0].Modify || result[0].Node.ID != 7 {
+		t.Errorf("unexpected diff %v", result[0])
+	}
+	if result[1].Delete || !result[1].Modify || result[1].Node.ID != 8 || result[1].Node.Lat != 2e-6 {
+		t.Errorf("unexpected diff %v", result[1])
+	}
+}
+
+func TestChangeParserO5M(t *testing.T) {
+	diffs := make(chan osm.Diff)
+	p := NewChangeParser(bytes.NewReader(testFile()), diff.Config{Diffs: diffs})
+	if err := p.Parse(context.Background()); err == nil {
+		t.Error("expected error for o5m file")
+	}
+}
@@ -0,0 +1,70 @@
+package reader
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/omniscale/go-osm/parser/pbf"
+	"github.com/omniscale/imposm3/reader/osmpbf"
+)
+
+// osmpbfParser parses PBF files with node locations on ways. All other
+// PBF files are parsed by the faster parser of go-osm.
+type osmpbfParser struct {
+	dec  *osmpbf.Decoder
+	conf pbf.Config
+}
+
+func newOSMPBFParser(r io.Reader, conf pbf.Config) *osmpbfParser {
+	return &osmpbfParser{dec: osmpbf.NewDecoder(r), conf: conf}
+}
+
+func (p *osmpbfParser) Timestamp() (time.Time, error) {
+	header, err := p.dec.Header()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return header.Time, nil
+}
+
+// LocationsOnWays returns true, as the Nodes of all ways are set.
+func (p *osmpbfParser) LocationsOnWays() bool {
+	return true
+}
+
+func (p *osmpbfParser) Parse(ctx context.Context) error {
+	b := newBatchSender(p.conf)
+	defer b.close()
+	defer p.dec.Close()
+	for {
+		block, err := p.dec.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for _, nd := range block.Nodes {
+			b.node(nd)
+		}
+		for _, w := range block.Ways {
+			b.way(w)
+		}
+		for _, r := range block.Relations {
+			b.relation(r)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	b.flush()
+	return nil
+}
+
+// locationsOnWays returns whether the parser includes the node locations of
+// all ways.
+func locationsOnWays(p osmParser) bool {
+	lp, ok := p.(interface{ LocationsOnWays() bool })
+	return ok && lp.LocationsOnWays()
+}
@@ -0,0 +1,377 @@
+package osmpbf
+
+import (
+	"encoding/binary"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/pkg/errors"
+)
+
+// Wire types of the protobuf encoding.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// message iterates over the fields of an encoded protobuf message.
+type message struct {
+	data  []byte
+	pos   int
+	err   error
+	field int
+	wire  int
+}
+
+func (m *message) setErr(err error) {
+	if m.err == nil && err != nil {
+		m.err = err
+		m.pos = len(m.data)
+	}
+}
+
+// next advances to the next field. Returns false at the end of the message
+// or on errors.
+func (m *message) next() bool {
+	if m.err != nil || m.pos >= len(m.data) {
+		return false
+	}
+	key := m.varint()
+	m.field, m.wire = int(key>>3), int(key&7)
+	return m.err == nil
+}
+
+func (m *message) varint() uint64 {
+	if m.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(m.data[m.pos:])
+	if n <= 0 {
+		m.setErr(errors.New("invalid varint"))
+		return 0
+	}
+	m.pos += n
+	return v
+}
+
+// sint returns a signed number of a sint32/sint64 field (zig-zag encoding).
+func (m *message) sint() int64 {
+	v := m.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (m *message) bytes() []byte {
+	if m.wire != wireBytes {
+		m.setErr(errors.Errorf("unexpected wire type %d for field %d", m.wire, m.field))
+		return nil
+	}
+	size := m.varint()
+	if m.err != nil {
+		return nil
+	}
+	if size > uint64(len(m.data)-m.pos) {
+		m.setErr(errors.New("invalid length"))
+		return nil
+	}
+	b := m.data[m.pos : m.pos+int(size)]
+	m.pos += int(size)
+	return b
+}
+
+func (m *message) skip() {
+	switch m.wire {
+	case wireVarint:
+		m.varint()
+	case wireBytes:
+		m.bytes()
+	case wireFixed64, wireFixed32:
+		n := 8
+		if m.wire == wireFixed32 {
+			n = 4
+		}
+		if n > len(m.data)-m.pos {
+			m.setErr(errors.New("invalid length"))
+			return
+		}
+		m.pos += n
+	default:
+		m.setErr(errors.Errorf("unsupported wire type %d", m.wire))
+	}
+}
+
+// varints returns the values of a repeated number field, packed or not.
+func (m *message) varints(dst []uint64) []uint64 {
+	if m.wire == wireVarint {
+		return append(dst, m.varint())
+	}
+	packed := message{data: m.bytes()}
+	for packed.pos < len(packed.data) && packed.err == nil {
+		dst = append(dst, packed.varint())
+	}
+	m.setErr(packed.err)
+	return dst
+}
+
+// sints returns the values of a repeated sint64 field.
+func (m *message) sints(dst []int64) []int64 {
+	if m.wire == wireVarint {
+		return append(dst, m.sint())
+	}
+	packed := message{data: m.bytes()}
+	for packed.pos < len(packed.data) && packed.err == nil {
+		dst = append(dst, packed.sint())
+	}
+	m.setErr(packed.err)
+	return dst
+}
+
+// sintDeltas returns the values of a repeated, delta coded sint64 field.
+func (m *message) sintDeltas(dst []int64) []int64 {
+	start := len(dst)
+	dst = m.sints(dst)
+	for i := start + 1; i < len(dst); i++ {
+		dst[i] += dst[i-1]
+	}
+	return dst
+}
+
+// block is the state of a single PrimitiveBlock.
+type block struct {
+	strings     []string
+	granularity int64
+	latOffset   int64
+	lonOffset   int64
+}
+
+func (b *block) coord(offset, v int64) float64 {
+	return 1e-9 * float64(offset+b.granularity*v)
+}
+
+func (b *block) str(i uint64) string {
+	if i < uint64(len(b.strings)) {
+		return b.strings[i]
+	}
+	return ""
+}
+
+func (b *block) tags(keys, vals []uint64) osm.Tags {
+	if len(keys) == 0 {
+		return nil
+	}
+	tags := make(osm.Tags, len(keys))
+	for i, k := range keys {
+		if i < len(vals) {
+			tags[b.str(k)] = b.str(vals[i])
+		}
+	}
+	return tags
+}
+
+// decodeBlock decodes an uncompressed PrimitiveBlock.
+func decodeBlock(data []byte) (*Block, error) {
+	b := block{granularity: 100}
+	var groups [][]byte
+	m := message{data: data}
+	for m.next() {
+		switch m.field {
+		case 1:
+			st := message{data: m.bytes()}
+			for st.next() {
+				if st.field == 1 {
+					b.strings = append(b.strings, string(st.bytes()))
+				} else {
+					st.skip()
+				}
+			}
+			m.setErr(st.err)
+		case 2:
+			groups = append(groups, m.bytes())
+		case 17:
+			b.granularity = int64(m.varint())
+		case 19:
+			b.latOffset = int64(m.varint())
+		case 20:
+			b.lonOffset = int64(m.varint())
+		default:
+			m.skip()
+		}
+	}
+	if m.err != nil {
+		return nil, errors.Wrap(m.err, "decoding PBF block")
+	}
+
+	result := &Block{}
+	for _, g := range groups {
+		m := message{data: g}
+		for m.next() {
+			switch m.field {
+			case 1:
+				result.Nodes = append(result.Nodes, b.decodeNode(&m))
+			case 2:
+				result.Nodes = b.decodeDenseNodes(&m, result.Nodes)
+			case 3:
+				result.Ways = append(result.Ways, b.decodeWay(&m))
+			case 4:
+				result.Relations = append(result.Relations, b.decodeRelation(&m))
+			default:
+				m.skip()
+			}
+		}
+		if m.err != nil {
+			return nil, errors.Wrap(m.err, "decoding PBF block")
+		}
+	}
+	return result, nil
+}
+
+func (b *block) decodeNode(parent *message) osm.Node {
+	var nd osm.Node
+	var keys, vals []uint64
+	m := message{data: parent.bytes()}
+	for m.next() {
+		switch m.field {
+		case 1:
+			nd.ID = m.sint()
+		case 2:
+			keys = m.varints(keys)
+		case 3:
+			vals = m.varints(vals)
+		case 8:
+			nd.Lat = b.coord(b.latOffset, m.sint())
+		case 9:
+			nd.Long = b.coord(b.lonOffset, m.sint())
+		default:
+			m.skip()
+		}
+	}
+	parent.setErr(m.err)
+	nd.Tags = b.tags(keys, vals)
+	return nd
+}
+
+func (b *block) decodeDenseNodes(parent *message, nodes []osm.Node) []osm.Node {
+	var ids, lats, lons []int64
+	var keyVals []uint64
+	m := message{data: parent.bytes()}
+	for m.next() {
+		switch m.field {
+		case 1:
+			ids = m.sintDeltas(ids)
+		case 8:
+			lats = m.sintDeltas(lats)
+		case 9:
+			lons = m.sintDeltas(lons)
+		case 10:
+			keyVals = m.varints(keyVals)
+		default:
+			m.skip()
+		}
+	}
+	if m.err == nil && (len(lats) != len(ids) || len(lons) != len(ids)) {
+		m.setErr(errors.New("invalid dense nodes"))
+	}
+	parent.setErr(m.err)
+	if m.err != nil {
+		return nodes
+	}
+
+	// keyVals contains the keys and values of all nodes, each node is
+	// terminated by 0
+	kv := 0
+	for i, id := range ids {
+		nd := osm.Node{
+			Element: osm.Element{ID: id},
+			Lat:     b.coord(b.latOffset, lats[i]),
+			Long:    b.coord(b.lonOffset, lons[i]),
+		}
+		for kv+1 < len(keyVals) && keyVals[kv] != 0 {
+			if nd.Tags == nil {
+				nd.Tags = make(osm.Tags)
+			}
+			nd.Tags[b.str(keyVals[kv])] = b.str(keyVals[kv+1])
+			kv += 2
+		}
+		kv++ // skip terminating 0
+		nodes = append(nodes, nd)
+	}
+	return nodes
+}
+
+func (b *block) decodeWay(parent *message) osm.Way {
+	var w osm.Way
+	var keys, vals []uint64
+	var lats, lons []int64
+	m := message{data: parent.bytes()}
+	for m.next() {
+		switch m.field {
+		case 1:
+			w.ID = int64(m.varint())
+		case 2:
+			keys = m.varints(keys)
+		case 3:
+			vals = m.varints(vals)
+		case 8:
+			w.Refs = m.sintDeltas(w.Refs)
+		case 9:
+			lats = m.sintDeltas(lats)
+		case 10:
+			lons = m.sintDeltas(lons)
+		default:
+			m.skip()
+		}
+	}
+	parent.setErr(m.err)
+	w.Tags = b.tags(keys, vals)
+	if len(lats) > 0 && len(lats) == len(w.Refs) && len(lons) == len(w.Refs) {
+		w.Nodes = make([]osm.Node, len(w.Refs))
+		for i, ref := range w.Refs {
+			w.Nodes[i] = osm.Node{
+				Element: osm.Element{ID: ref},
+				Lat:     b.coord(b.latOffset, lats[i]),
+				Long:    b.coord(b.lonOffset, lons[i]),
+			}
+		}
+	}
+	return w
+}
+
+var memberTypes = []osm.MemberType{osm.NodeMember, osm.WayMember, osm.RelationMember}
+
+func (b *block) decodeRelation(parent *message) osm.Relation {
+	var r osm.Relation
+	var keys, vals, roles, types []uint64
+	var ids []int64
+	m := message{data: parent.bytes()}
+	for m.next() {
+		switch m.field {
+		case 1:
+			r.ID = int64(m.varint())
+		case 2:
+			keys = m.varints(keys)
+		case 3:
+			vals = m.varints(vals)
+		case 8:
+			roles = m.varints(roles)
+		case 9:
+			ids = m.sintDeltas(ids)
+		case 10:
+			types = m.varints(types)
+		default:
+			m.skip()
+		}
+	}
+	parent.setErr(m.err)
+	r.Tags = b.tags(keys, vals)
+	for i, id := range ids {
+		if i >= len(roles) || i >= len(types) || types[i] >= uint64(len(memberTypes)) {
+			break
+		}
+		r.Members = append(r.Members, osm.Member{
+			ID:   id,
+			Type: memberTypes[types[i]],
+			Role: b.str(roles[i]),
+		})
+	}
+	return r
+}
@@ -0,0 +1,367 @@
+// Package osmpbf decodes OSM PBF files, including the node locations of ways
+// from files with the LocationsOnWays feature, as written by
+// `osmium add-locations-to-ways`. The PBF parser of go-osm skips these
+// locations.
+//
+// See https://wiki.openstreetmap.org/wiki/PBF_Format for the format.
+package osmpbf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"runtime"
+	"time"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/pkg/errors"
+)
+
+// LocationsOnWays is the optional feature of files with node locations on
+// ways.
+const LocationsOnWays = "LocationsOnWays"
+
+var supportedFeatures = map[string]bool{
+	"OsmSchema-V0.6": true,
+	"DenseNodes":     true,
+}
+
+const (
+	// maxBlobHeaderSize and maxBlobSize are the limits of the format.
+	maxBlobHeaderSize = 64 * 1024
+	maxBlobSize       = 32 * 1024 * 1024
+)
+
+// Header is the header of a PBF file.
+type Header struct {
+	RequiredFeatures []string
+	OptionalFeatures []string
+	// Time is the replication timestamp of the file, or a zero time if the
+	// file has no timestamp.
+	Time time.Time
+}
+
+// LocationsOnWays returns whether the ways of the file include the
+// locations of their nodes.
+func (h *Header) LocationsOnWays() bool {
+	for _, f := range h.OptionalFeatures {
+		if f == LocationsOnWays {
+			return true
+		}
+	}
+	return false
+}
+
+// PeekHeader returns the header of the PBF file without consuming any data
+// of r. Returns an error if r does not start with a PBF header or if the
+// header is larger than the buffer of r.
+func PeekHeader(r *bufio.Reader) (*Header, error) {
+	b, err := r.Peek(4)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading PBF header")
+	}
+	size := int(binary.BigEndian.Uint32(b))
+	if size > maxBlobHeaderSize {
+		return nil, errors.New("reading PBF header: not a PBF file")
+	}
+	b, err = r.Peek(4 + size)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading PBF header")
+	}
+	typ, dataSize, err := decodeBlobHeader(b[4:])
+	if err != nil {
+		return nil, err
+	}
+	if typ != "OSMHeader" {
+		return nil, errors.Errorf("reading PBF header: expected OSMHeader, got %q", typ)
+	}
+	b, err = r.Peek(4 + size + dataSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading PBF header")
+	}
+	data, err := decodeBlob(b[4+size:])
+	if err != nil {
+		return nil, err
+	}
+	return decodeHeader(data)
+}
+
+// Block contains the elements of a single block of the file. Nodes include
+// all nodes, with or without tags.
+type Block struct {
+	Nodes     []osm.Node
+	Ways      []osm.Way
+	Relations []osm.Relation
+}
+
+type blockResult struct {
+	block *Block
+	err   error
+}
+
+// Decoder reads the blocks of a PBF file. Blocks are decompressed and
+// decoded concurrently, but they are returned in the order of the file.
+// Metadata (version, timestamp, changeset and user) are skipped.
+type Decoder struct {
+	r      io.Reader
+	header *Header
+	// results of all blocks that are decoded, in the order of the file
+	results chan chan blockResult
+	done    chan struct{}
+}
+
+// NewDecoder returns a decoder for r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r, done: make(chan struct{})}
+}
+
+// Header reads the header of the file.
+func (d *Decoder) Header() (*Header, error) {
+	if d.header != nil {
+		return d.header, nil
+	}
+	typ, data, err := d.readBlob()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading PBF header")
+	}
+	if typ != "OSMHeader" {
+		return nil, errors.Errorf("reading PBF header: expected OSMHeader, got %q", typ)
+	}
+	if data, err = decodeBlob(data); err != nil {
+		return nil, err
+	}
+	header, err := decodeHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	d.header = header
+	return header, nil
+}
+
+// Next returns the next block. Returns io.EOF at the end of the file.
+func (d *Decoder) Next() (*Block, error) {
+	if _, err := d.Header(); err != nil {
+		return nil, err
+	}
+	if d.results == nil {
+		d.results = make(chan chan blockResult, runtime.NumCPU())
+		go d.readBlocks()
+	}
+	res, ok := <-d.results
+	if !ok {
+		return nil, io.EOF
+	}
+	r := <-res
+	return r.block, r.err
+}
+
+// Close stops reading the file. Next must not be called after Close.
+func (d *Decoder) Close() {
+	close(d.done)
+}
+
+// readBlocks reads all blobs and decodes them in separate goroutines. The
+// number of blocks that are decoded at the same time is limited by the
+// buffer of results.
+func (d *Decoder) readBlocks() {
+	defer close(d.results)
+	for {
+		res := make(chan blockResult, 1)
+		typ, data, err := d.readBlob()
+		if err == io.EOF {
+			return
+		}
+		if err == nil && typ != "OSMData" {
+			continue
+		}
+		select {
+		case d.results <- res:
+		case <-d.done:
+			return
+		}
+		if err != nil {
+			res <- blockResult{err: errors.Wrap(err, "reading PBF block")}
+			return
+		}
+		go func() {
+			block, err := decodeBlob(data)
+			if err != nil {
+				res <- blockResult{err: err}
+				return
+			}
+			b, err := decodeBlock(block)
+			res <- blockResult{block: b, err: err}
+		}()
+	}
+}
+
+// readBlob returns the type and the raw blob of the next blob. Returns
+// io.EOF at the end of the file.
+func (d *Decoder) readBlob() (string, []byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(d.r, size[:]); err != nil {
+		return "", nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxBlobHeaderSize {
+		return "", nil, errors.Errorf("invalid blob header size %d", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return "", nil, noEOF(err)
+	}
+	typ, dataSize, err := decodeBlobHeader(buf)
+	if err != nil {
+		return "", nil, err
+	}
+	data := make([]byte, dataSize)
+	if _, err := io.ReadFull(d.r, data); err != nil {
+		return "", nil, noEOF(err)
+	}
+	return typ, data, nil
+}
+
+// BlobOffsets returns the offsets of all blobs of the PBF file, followed by
+// the offset after the last blob. Only the blob headers are read, the data of
+// the blobs is skipped. The first blob is the header of the file.
+func BlobOffsets(r io.ReadSeeker) ([]int64, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	var offsets []int64
+	var offset int64
+	var sizeBuf [4]byte
+	for offset < size {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(r, sizeBuf[:]); err != nil {
+			return nil, noEOF(err)
+		}
+		n := binary.BigEndian.Uint32(sizeBuf[:])
+		if n > maxBlobHeaderSize {
+			return nil, errors.Errorf("invalid blob header size %d at offset %d", n, offset)
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, noEOF(err)
+		}
+		typ, dataSize, err := decodeBlobHeader(buf)
+		if err != nil {
+			return nil, err
+		}
+		if len(offsets) == 0 && typ != "OSMHeader" {
+			return nil, errors.Errorf("reading PBF header: expected OSMHeader, got %q", typ)
+		}
+		offsets = append(offsets, offset)
+		offset += 4 + int64(n) + int64(dataSize)
+	}
+	if offset > size || len(offsets) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return append(offsets, offset), nil
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func decodeBlobHeader(data []byte) (string, int, error) {
+	var typ string
+	dataSize := -1
+	m := message{data: data}
+	for m.next() {
+		switch m.field {
+		case 1:
+			typ = string(m.bytes())
+		case 3:
+			dataSize = int(m.varint())
+		default:
+			m.skip()
+		}
+	}
+	if m.err != nil {
+		return "", 0, errors.Wrap(m.err, "decoding blob header")
+	}
+	if dataSize < 0 || dataSize > maxBlobSize {
+		return "", 0, errors.Errorf("invalid blob size %d", dataSize)
+	}
+	return typ, dataSize, nil
+}
+
+// decodeBlob returns the uncompressed data of the blob. Only raw and zlib
+// compressed blobs are supported.
+func decodeBlob(data []byte) ([]byte, error) {
+	var raw, zlibData []byte
+	rawSize := 0
+	m := message{data: data}
+	for m.next() {
+		switch m.field {
+		case 1:
+			raw = m.bytes()
+		case 2:
+			rawSize = int(m.varint())
+		case 3:
+			zlibData = m.bytes()
+		case 4, 5, 6, 7:
+			return nil, errors.New("decoding blob: only zlib compression is supported")
+		default:
+			m.skip()
+		}
+	}
+	if m.err != nil {
+		return nil, errors.Wrap(m.err, "decoding blob")
+	}
+	if raw != nil {
+		return raw, nil
+	}
+	if rawSize < 0 || rawSize > maxBlobSize {
+		return nil, errors.Errorf("invalid raw blob size %d", rawSize)
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(zlibData))
+	if err != nil {
+		return nil, errors.Wrap(err, "decompressing blob")
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, rawSize))
+	if _, err := io.Copy(buf, zr); err != nil {
+		return nil, errors.Wrap(err, "decompressing blob")
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeHeader(data []byte) (*Header, error) {
+	header := &Header{}
+	m := message{data: data}
+	for m.next() {
+		switch m.field {
+		case 4:
+			header.RequiredFeatures = append(header.RequiredFeatures, string(m.bytes()))
+		case 5:
+			header.OptionalFeatures = append(header.OptionalFeatures, string(m.bytes()))
+		case 32:
+			if ts := int64(m.varint()); ts > 0 {
+				header.Time = time.Unix(ts, 0).UTC()
+			}
+		default:
+			m.skip()
+		}
+	}
+	if m.err != nil {
+		return nil, errors.Wrap(m.err, "decoding PBF header")
+	}
+	for _, f := range header.RequiredFeatures {
+		if !supportedFeatures[f] {
+			return nil, errors.Errorf("decoding PBF header: unsupported feature %q", f)
+		}
+	}
+	return header, nil
+}
@@ -0,0 +1,229 @@
+package osmpbf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	osm "github.com/omniscale/go-osm"
+)
+
+func uvarint(v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutUvarint(buf, v)]
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func varintField(field int, v uint64) []byte {
+	return append(uvarint(uint64(field<<3|wireVarint)), uvarint(v)...)
+}
+
+func bytesField(field int, parts ...[]byte) []byte {
+	data := bytes.Join(parts, nil)
+	b := append(uvarint(uint64(field<<3|wireBytes)), uvarint(uint64(len(data)))...)
+	return append(b, data...)
+}
+
+func packedSints(field int, vs ...int64) []byte {
+	var data []byte
+	for _, v := range vs {
+		data = append(data, uvarint(zigzag(v))...)
+	}
+	return bytesField(field, data)
+}
+
+func packedUints(field int, vs ...uint64) []byte {
+	var data []byte
+	for _, v := range vs {
+		data = append(data, uvarint(v)...)
+	}
+	return bytesField(field, data)
+}
+
+// blob returns a blob with header, the data is zlib compressed if compress
+// is set.
+func blob(typ string, data []byte, compress bool) []byte {
+	var b []byte
+	if compress {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+		b = append(bytesField(3, buf.Bytes()), varintField(2, uint64(len(data)))...)
+	} else {
+		b = bytesField(1, data)
+	}
+	header := append(bytesField(1, []byte(typ)), varintField(3, uint64(len(b)))...)
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(header)))
+	return bytes.Join([][]byte{size, header, b}, nil)
+}
+
+func testFile() []byte {
+	header := bytes.Join([][]byte{
+		bytesField(4, []byte("OsmSchema-V0.6")),
+		bytesField(4, []byte("DenseNodes")),
+		bytesField(5, []byte("LocationsOnWays")),
+		varintField(32, 1551441600),
+	}, nil)
+
+	strings := bytesField(1,
+		bytesField(1, nil),
+		bytesField(1, []byte("amenity")),
+		bytesField(1, []byte("cafe")),
+		bytesField(1, []byte("highway")),
+		bytesField(1, []byte("residential")),
+		bytesField(1, []byte("outer")),
+	)
+	nodes := bytesField(2, bytesField(2,
+		packedSints(1, 1, 1),                // ids 1, 2
+		packedSints(8, 420000000, 10000000), // lats 42, 43
+		packedSints(9, 100000000, 10000000), // lons 10, 11
+		packedUints(10, 1, 2, 0, 0),         // amenity=cafe for 1
+	))
+	ways := bytesField(2, bytesField(3,
+		varintField(1, 10),
+		packedUints(2, 3),
+		packedUints(3, 4),
+		packedSints(8, 1, 1),
+		packedSints(9, 420000000, 10000000),
+		packedSints(10, 100000000, 10000000),
+	))
+	rels := bytesField(2, bytesField(4,
+		varintField(1, 20),
+		packedUints(8, 5),
+		packedSints(9, 10),
+		packedUints(10, 1),
+	))
+
+	return bytes.Join([][]byte{
+		blob("OSMHeader", header, true),
+		blob("OSMData", bytes.Join([][]byte{strings, nodes}, nil), true),
+		blob("OSMData", bytes.Join([][]byte{strings, ways}, nil), false),
+		blob("OSMData", bytes.Join([][]byte{strings, rels}, nil), true),
+	}, nil)
+}
+
+func TestDecoder(t *testing.T) {
+	dec := NewDecoder(bytes.NewReader(testFile()))
+	defer dec.Close()
+
+	header, err := dec.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !header.LocationsOnWays() {
+		t.Error("expected LocationsOnWays")
+	}
+	if !header.Time.Equal(time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Error("unexpected timestamp", header.Time)
+	}
+
+	var blocks []*Block
+	for {
+		b, err := dec.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, b)
+	}
+	if len(blocks) != 3 {
+		t.Fatalf("unexpected blocks %v", blocks)
+	}
+
+	nds := blocks[0].Nodes
+	if len(nds) != 2 || nds[0].ID != 1 || nds[1].ID != 2 {
+		t.Fatalf("unexpected nodes %v", nds)
+	}
+	if nds[0].Tags["amenity"] != "cafe" || nds[1].Tags != nil {
+		t.Errorf("unexpected tags %v", nds)
+	}
+	if !near(nds[1].Long, 11) || !near(nds[1].Lat, 43) {
+		t.Errorf("unexpected coords %v", nds[1])
+	}
+
+	ws := blocks[1].Ways
+	if len(ws) != 1 || ws[0].ID != 10 || ws[0].Tags["highway"] != "residential" {
+		t.Fatalf("unexpected ways %v", ws)
+	}
+	if len(ws[0].Refs) != 2 || ws[0].Refs[0] != 1 || ws[0].Refs[1] != 2 {
+		t.Errorf("unexpected refs %v", ws[0].Refs)
+	}
+	if len(ws[0].Nodes) != 2 || ws[0].Nodes[1].ID != 2 ||
+		!near(ws[0].Nodes[1].Long, 11) || !near(ws[0].Nodes[1].Lat, 43) {
+		t.Errorf("unexpected way nodes %v", ws[0].Nodes)
+	}
+
+	rels := blocks[2].Relations
+	if len(rels) != 1 || rels[0].ID != 20 || len(rels[0].Members) != 1 ||
+		rels[0].Members[0] != (osm.Member{ID: 10, Type: osm.WayMember, Role: "outer"}) {
+		t.Errorf("unexpected relations %v", rels)
+	}
+}
+
+func TestPeekHeader(t *testing.T) {
+	r := bufio.NewReader(bytes.NewReader(testFile()))
+	header, err := PeekHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !header.LocationsOnWays() {
+		t.Error("expected LocationsOnWays")
+	}
+	// nothing was consumed
+	if _, err := NewDecoder(r).Header(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := PeekHeader(bufio.NewReader(bytes.NewReader([]byte("<osm>")))); err == nil {
+		t.Error("expected error for non-PBF data")
+	}
+}
+
+func TestBlobOffsets(t *testing.T) {
+	data := testFile()
+	offsets, err := BlobOffsets(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offsets) != 5 || offsets[0] != 0 || offsets[4] != int64(len(data)) {
+		t.Fatalf("unexpected offsets %v", offsets)
+	}
+	// each offset starts a blob with the remaining blocks
+	dec := NewDecoder(bytes.NewReader(data[offsets[2]:]))
+	defer dec.Close()
+	typ, _, err := dec.readBlob()
+	if err != nil || typ != "OSMData" {
+		t.Errorf("no blob at offset %d: %q %v", offsets[2], typ, err)
+	}
+
+	if _, err := BlobOffsets(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Error("expected error for truncated file")
+	}
+	if _, err := BlobOffsets(bytes.NewReader([]byte("<osm></osm>"))); err == nil {
+		t.Error("expected error for non-PBF data")
+	}
+}
+
+func TestUnsupportedFeature(t *testing.T) {
+	header := bytesField(4, []byte("HistoricalInformation"))
+	dec := NewDecoder(bytes.NewReader(blob("OSMHeader", header, false)))
+	if _, err := dec.Header(); err == nil {
+		t.Error("expected error for unsupported feature")
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-7
+}
@@ -0,0 +1,235 @@
+package reader
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"io"
+	"time"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/go-osm/parser/pbf"
+	"github.com/omniscale/imposm3/reader/o5m"
+	"github.com/omniscale/imposm3/reader/osmpbf"
+	"github.com/pkg/errors"
+)
+
+// Input files are PBF, o5m or OSM XML files. XML files can be compressed
+// with gzip or bzip2 (.osm.gz and .osm.bz2). The format is detected by the
+// content of the file, so that it also works for remote files and stdin.
+//
+// PBF files with node locations on ways (LocationsOnWays, as written by
+// `osmium add-locations-to-ways`) are parsed by the osmpbf package, as the
+// parser of go-osm skips these locations.
+//
+// o5m and XML files are parsed by a single goroutine and they do not need
+// to be sorted by type. The -limitto option requires files where all nodes
+// come before ways and all ways before relations.
+
+// osmParser parses the input file into the channels of the pbf.Config.
+type osmParser interface {
+	// Timestamp returns the replication timestamp from the header of the
+	// file, or a zero time if the file has no timestamp.
+	Timestamp() (time.Time, error)
+	Parse(ctx context.Context) error
+}
+
+// newParser returns a PBF, o5m or XML parser for the content of r.
+func newParser(r io.Reader, conf pbf.Config) (osmParser, error) {
+	br := bufio.NewReaderSize(r, maxHeaderSize)
+	magic, err := br.Peek(3)
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "reading file header")
+	}
+	switch {
+	case o5m.HasMagic(magic):
+		return newO5MParser(br, conf), nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading gzip header")
+		}
+		return newXMLParser(zr, conf), nil
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return newXMLParser(bzip2.NewReader(br), conf), nil
+	case isXML(magic):
+		return newXMLParser(br, conf), nil
+	}
+	if header, err := osmpbf.PeekHeader(br); err == nil && header.LocationsOnWays() {
+		return newOSMPBFParser(br, conf), nil
+	}
+	return &pbfParser{pbf.New(br, conf)}, nil
+}
+
+// maxHeaderSize is the size of the read buffer, large enough to peek into
+// the header of PBF files.
+const maxHeaderSize = 64 * 1024
+
+// isXML returns whether the file starts with an XML declaration or
+// element, optionally after whitespace or an UTF-8 BOM.
+func isXML(magic []byte) bool {
+	magic = bytes.TrimPrefix(magic, []byte{0xef, 0xbb, 0xbf})
+	magic = bytes.TrimLeft(magic, " \t\r\n")
+	return len(magic) == 0 || magic[0] == '<'
+}
+
+type pbfParser struct {
+	*pbf.Parser
+}
+
+func (p *pbfParser) Timestamp() (time.Time, error) {
+	header, err := p.Header()
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "parsing PBF header")
+	}
+	if header.Time.Unix() > 0 {
+		return header.Time, nil
+	}
+	return time.Time{}, nil
+}
+
+// ReadTimestamp returns the replication timestamp from the header of the
+// input file, or a zero time if the file has no timestamp.
+func ReadTimestamp(filename string) (time.Time, error) {
+	f, err := OpenInput(filename)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "opening input file")
+	}
+	defer f.Close()
+	p, err := newParser(f, pbf.Config{})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return p.Timestamp()
+}
+
+// batchSize is the number of elements that are sent in a single batch,
+// similar to the size of PBF blocks.
+const batchSize = 8000
+
+// batchSender sends the elements of single-threaded parsers in batches to
+// the channels of the pbf.Config. Nodes are sent to Coords and nodes with
+// tags are also sent to Nodes. All nodes are sent to Nodes if Coords is
+// nil, like with the PBF parser. OnFirstWay and OnFirstRelation are called
+// once, after all previous elements were sent.
+type batchSender struct {
+	conf     pbf.Config
+	coords   []osm.Node
+	nodes    []osm.Node
+	ways     []osm.Way
+	rels     []osm.Relation
+	firstWay bool
+	firstRel bool
+}
+
+func newBatchSender(conf pbf.Config) *batchSender {
+	return &batchSender{conf: conf, firstWay: true, firstRel: true}
+}
+
+func (b *batchSender) node(n osm.Node) {
+	if b.conf.Coords == nil {
+		b.nodes = append(b.nodes, n)
+		if len(b.nodes) >= batchSize {
+			b.flushNodes()
+		}
+		return
+	}
+	b.coords = append(b.coords, osm.Node{Element: osm.Element{ID: n.ID}, Lat: n.Lat, Long: n.Long})
+	if len(n.Tags) > 0 {
+		b.nodes = append(b.nodes, n)
+	}
+	if len(b.coords) >= batchSize {
+		b.flushNodes()
+	}
+}
+
+func (b *batchSender) way(w osm.Way) {
+	b.onFirstWay()
+	b.ways = append(b.ways, w)
+	if len(b.ways) >= batchSize {
+		b.flushWays()
+	}
+}
+
+func (b *batchSender) relation(r osm.Relation) {
+	b.onFirstRelation()
+	b.rels = append(b.rels, r)
+	if len(b.rels) >= batchSize {
+		b.flushRelations()
+	}
+}
+
+func (b *batchSender) onFirstWay() {
+	if b.firstWay {
+		b.firstWay = false
+		b.flushNodes()
+		if b.conf.OnFirstWay != nil {
+			b.conf.OnFirstWay()
+		}
+	}
+}
+
+func (b *batchSender) onFirstRelation() {
+	b.onFirstWay()
+	if b.firstRel {
+		b.firstRel = false
+		b.flushWays()
+		if b.conf.OnFirstRelation != nil {
+			b.conf.OnFirstRelation()
+		}
+	}
+}
+
+func (b *batchSender) flushNodes() {
+	if len(b.coords) > 0 && b.conf.Coords != nil {
+		b.conf.Coords <- b.coords
+	}
+	if len(b.nodes) > 0 && b.conf.Nodes != nil {
+		b.conf.Nodes <- b.nodes
+	}
+	b.coords, b.nodes = nil, nil
+}
+
+func (b *batchSender) flushWays() {
+	if len(b.ways) > 0 && b.conf.Ways != nil {
+		b.conf.Ways <- b.ways
+	}
+	b.ways = nil
+}
+
+func (b *batchSender) flushRelations() {
+	if len(b.rels) > 0 && b.conf.Relations != nil {
+		b.conf.Relations <- b.rels
+	}
+	b.rels = nil
+}
+
+// flush sends all remaining elements.
+func (b *batchSender) flush() {
+	b.flushNodes()
+	b.onFirstWay()
+	b.flushWays()
+	b.onFirstRelation()
+	b.flushRelations()
+}
+
+// close closes all channels, unless KeepOpen is set.
+func (b *batchSender) close() {
+	if b.conf.KeepOpen {
+		return
+	}
+	if b.conf.Coords != nil {
+		close(b.conf.Coords)
+	}
+	if b.conf.Nodes != nil {
+		close(b.conf.Nodes)
+	}
+	if b.conf.Ways != nil {
+		close(b.conf.Ways)
+	}
+	if b.conf.Relations != nil {
+		close(b.conf.Relations)
+	}
+}
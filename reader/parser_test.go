@@ -0,0 +1,30 @@
+package reader
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/omniscale/go-osm/parser/pbf"
+)
+
+func TestNewParser(t *testing.T) {
+	for _, tc := range []struct {
+		data     []byte
+		expected string
+	}{
+		{[]byte{0, 0, 0, 0x0d, 0x0a}, "*reader.pbfParser"},
+		{[]byte{0xff, 0xe0, 0x04, 'o', '5', 'm', '2'}, "*reader.o5mParser"},
+		{[]byte("<?xml version='1.0'?>"), "*reader.xmlParser"},
+		{[]byte("\xef\xbb\xbf\n<osm>"), "*reader.xmlParser"},
+		{[]byte("BZh91AY&SY"), "*reader.xmlParser"},
+	} {
+		p, err := newParser(bytes.NewReader(tc.data), pbf.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ := fmt.Sprintf("%T", p); typ != tc.expected {
+			t.Errorf("expected %s for %q, got %s", tc.expected, tc.data, typ)
+		}
+	}
+}
@@ -2,12 +2,14 @@ package reader
 
 import (
 	"context"
+	"io"
 	"math"
 	"os"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	osm "github.com/omniscale/go-osm"
 	"github.com/omniscale/go-osm/parser/pbf"
@@ -16,6 +18,7 @@ import (
 	"github.com/omniscale/imposm3/geom/limit"
 	"github.com/omniscale/imposm3/log"
 	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/memory"
 	"github.com/omniscale/imposm3/stats"
 	"github.com/pkg/errors"
 )
@@ -50,224 +53,391 @@ func readersForCpus(cpus int) (int64, int64, int64, int64, int64) {
 	return int64(math.Ceil(cpuf * 0.75)), int64(math.Ceil(cpuf * 0.25)), int64(math.Ceil(cpuf * 0.25)), int64(math.Ceil(cpuf * 0.25)), int64(math.Ceil(cpuf * 0.25))
 }
 
+// ReadPbf reads the PBF or OSM XML file (or remote file, or stdin for -)
+// into the cache. Returns the replication timestamp of the file, or a zero time if
+// the file has no timestamp. With lowDisk, only ways that are members of
+// relations are cached. Reading stops with an error if ctx is cancelled,
+// all elements that were parsed till then are cached.
+//
+// The coords are not cached for PBF files with node locations on ways, the
+// locations are cached with the ways instead. Nodes without tags are not
+// available in this case, e.g. for relation members. With diff, the coords
+// are always cached, as they are required for updates.
+//
+// With pos, local PBF files are read in chunks of blocks, starting at the
+// offset of pos. See ReadPosition.
 func ReadPbf(
+	ctx context.Context,
 	filename string,
 	cache *osmcache.OSMCache,
 	progress *stats.Statistics,
 	tagmapping *mapping.Mapping,
 	limiter *limit.Limiter,
-) error {
-	nodes := make(chan []osm.Node, 4)
-	coords := make(chan []osm.Node, 4)
-	ways := make(chan []osm.Way, 4)
-	relations := make(chan []osm.Relation, 4)
-
+	bbox *BBox,
+	lowDisk bool,
+	diff bool,
+	pos *ReadPosition,
+) (time.Time, error) {
+	if lowDisk && filename == Stdin {
+		return time.Time{}, errors.New("-low-disk requires a file, not stdin")
+	}
 	withLimiter := false
 	if limiter != nil {
 		withLimiter = true
 	}
-
-	config := pbf.Config{
-		Coords:    coords,
-		Nodes:     nodes,
-		Ways:      ways,
-		Relations: relations,
-	}
-
-	// wait for all coords/nodes to be processed before continuing with
-	// ways. required for -limitto checks
-	coordsSync := sync.WaitGroup{}
-	config.OnFirstWay = func() {
-		for i := 0; int64(i) < nCoords; i++ {
-			coords <- nil
-		}
-		for i := 0; int64(i) < nNodes; i++ {
-			nodes <- nil
-		}
-		coordsSync.Wait()
+	var bboxFilter *bboxFilter
+	if bbox != nil {
+		bboxFilter = newBBoxFilter(bbox)
 	}
-
-	// wait for all ways to be processed before continuing with
-	// relations. required for -limitto checks
-	waysSync := sync.WaitGroup{}
-	config.OnFirstRelation = func() {
-		for i := 0; int64(i) < nWays; i++ {
-			ways <- nil
-		}
-		waysSync.Wait()
+	var keptWays *idSet
+	if lowDisk {
+		keptWays = newIDSet()
 	}
 
-	f, err := os.Open(filename)
+	f, err := OpenInput(filename)
 	if err != nil {
-		return errors.Wrap(err, "opening PBF file")
+		return time.Time{}, errors.Wrap(err, "opening input file")
 	}
 	defer f.Close()
 
-	parser := pbf.New(f, config)
-	header, err := parser.Header()
+	chunks, inChunks, err := resumeChunks(filename, f, pos, bbox, lowDisk)
 	if err != nil {
-		return errors.Wrap(err, "parsing PBF header")
+		return time.Time{}, err
 	}
 
-	if header.Time.Unix() != 0 {
-		log.Printf("[info] reading %s with data till %v", filename, header.Time.Local())
-	}
+	var timestamp time.Time
+	var wayLocations bool
+	// parse caches all elements from r. It is called for each chunk of
+	// blocks, the header of the file is parsed again for each chunk.
+	parse := func(r io.Reader, first bool) error {
+		nodes := make(chan []osm.Node, memory.ReadBuffer())
+		coords := make(chan []osm.Node, memory.ReadBuffer())
+		ways := make(chan []osm.Way, memory.ReadBuffer())
+		relations := make(chan []osm.Relation, memory.ReadBuffer())
 
-	waitWriter := sync.WaitGroup{}
+		config := pbf.Config{
+			Coords:    coords,
+			Nodes:     nodes,
+			Ways:      ways,
+			Relations: relations,
+		}
+		if memory.Limited() {
+			config.Concurrency = memory.Workers(runtime.NumCPU())
+			if os.Getenv("IMPOSM_READ_PROCS") == "" {
+				nParser, nRels, nWays, nNodes, nCoords = readersForCpus(config.Concurrency)
+			}
+		}
 
-	for i := 0; int64(i) < nWays; i++ {
-		waysSync.Add(1)
-		waitWriter.Add(1)
-		go func() {
-			var skip, hit int
+		// all nodes are parsed with metadata to compare their versions, see
+		// splitNodes
+		var allNodes chan []osm.Node
+		flushedNodes := make(chan struct{})
+		splitErr := make(chan error, 1)
+		if cache.Versions != nil {
+			config.IncludeMetadata = true
+			allNodes = make(chan []osm.Node, memory.ReadBuffer())
+			config.Coords = nil
+			config.Nodes = allNodes
+			go func() {
+				splitErr <- splitNodes(allNodes, cache.Versions, coords, nodes, flushedNodes)
+			}()
+		}
 
-			m := tagmapping.WayTagFilter()
-			for ws := range ways {
-				if ws == nil {
-					waysSync.Done()
-					waysSync.Wait()
-					continue
-				}
-				if skipWays {
-					continue
-				}
-				for i := range ws {
-					m.Filter(&ws[i].Tags)
-					if withLimiter {
-						cached, err := cache.Coords.FirstRefIsCached(ws[i].Refs)
-						if err != nil {
-							log.Printf("[error] checking for cached refs of way %d: %v", ws[i].ID, err)
-							cached = true // don't skip in case of error
+		// wait for all coords/nodes to be processed before continuing with
+		// ways. required for -limitto checks
+		coordsSync := sync.WaitGroup{}
+		config.OnFirstWay = func() {
+			if allNodes != nil {
+				allNodes <- nil
+				<-flushedNodes
+			}
+			for i := 0; int64(i) < nCoords; i++ {
+				coords <- nil
+			}
+			for i := 0; int64(i) < nNodes; i++ {
+				nodes <- nil
+			}
+			coordsSync.Wait()
+		}
+
+		// wait for all ways to be processed before continuing with
+		// relations. required for -limitto checks
+		waysSync := sync.WaitGroup{}
+		config.OnFirstRelation = func() {
+			for i := 0; int64(i) < nWays; i++ {
+				ways <- nil
+			}
+			waysSync.Wait()
+		}
+
+		parser, err := newParser(r, config)
+		if err != nil {
+			return err
+		}
+		if first {
+			timestamp, err = parser.Timestamp()
+			if err != nil {
+				return err
+			}
+			if !timestamp.IsZero() {
+				log.Printf("[info] reading %s with data till %v", filename, timestamp.Local())
+			}
+
+			wayLocations = locationsOnWays(parser) && !diff
+			if wayLocations {
+				log.Printf("[info] %s contains node locations on ways, coords are not cached", filename)
+			}
+		}
+
+		waitWriter := sync.WaitGroup{}
+
+		for i := 0; int64(i) < nWays; i++ {
+			waysSync.Add(1)
+			waitWriter.Add(1)
+			go func() {
+				var skip, hit int
+				g := geos.NewGeos()
+				defer g.Finish()
+
+				m := tagmapping.WayTagFilter()
+				for ws := range ways {
+					memory.Backoff()
+					if ws == nil {
+						waysSync.Done()
+						waysSync.Wait()
+						continue
+					}
+					if skipWays {
+						continue
+					}
+					if cache.Versions != nil {
+						if err := keepNewerWays(cache.Versions, ws); err != nil {
+							log.Printf("[error] comparing versions of ways: %v", err)
 						}
-						if cached {
-							hit++
-						} else {
-							ws[i].ID = osmcache.SKIP
-							skip++
+					}
+					if bboxFilter != nil {
+						bboxFilter.filterWays(ws)
+					}
+					for i := range ws {
+						m.Filter(&ws[i].Tags)
+						if !wayLocations {
+							ws[i].Nodes = nil
+						}
+						if withLimiter && ws[i].ID != osmcache.SKIP {
+							var cached bool
+							var err error
+							if len(ws[i].Nodes) > 0 {
+								cached = limiter.IntersectsBuffer(g, ws[i].Nodes[0].Long, ws[i].Nodes[0].Lat)
+							} else {
+								cached, err = cache.Coords.FirstRefIsCached(ws[i].Refs)
+							}
+							if err != nil {
+								log.Printf("[error] checking for cached refs of way %d: %v", ws[i].ID, err)
+								cached = true // don't skip in case of error
+							}
+							if cached {
+								hit++
+							} else {
+								ws[i].ID = osmcache.SKIP
+								skip++
+							}
 						}
 					}
+					if keptWays != nil {
+						var ids []int64
+						for i := range ws {
+							if ws[i].ID != osmcache.SKIP {
+								ids = append(ids, ws[i].ID)
+							}
+						}
+						keptWays.add(ids...)
+					} else if err := cache.Ways.PutWays(ws); err != nil {
+						log.Printf("[error] caching ways: %v", err)
+					}
+					progress.AddWays(len(ws))
 				}
-				err := cache.Ways.PutWays(ws)
-				if err != nil {
-					log.Printf("[error] caching ways: %v", err)
-				}
-				progress.AddWays(len(ws))
-			}
 
-			waitWriter.Done()
-		}()
-	}
+				waitWriter.Done()
+			}()
+		}
 
-	for i := 0; int64(i) < nRels; i++ {
-		waitWriter.Add(1)
-		go func() {
-			var skip, hit int
+		for i := 0; int64(i) < nRels; i++ {
+			waitWriter.Add(1)
+			go func() {
+				var skip, hit int
 
-			m := tagmapping.RelationTagFilter()
-			for rels := range relations {
-				numWithTags := 0
-				for i := range rels {
-					m.Filter(&rels[i].Tags)
-					if len(rels[i].Tags) > 0 {
-						numWithTags++
+				m := tagmapping.RelationTagFilter()
+				for rels := range relations {
+					memory.Backoff()
+					if cache.Versions != nil {
+						if err := keepNewerRelations(cache.Versions, rels); err != nil {
+							log.Printf("[error] comparing versions of relations: %v", err)
+						}
 					}
-					if withLimiter {
-						cached, err := cache.FirstMemberIsCached(rels[i].Members)
-						if err != nil {
-							log.Printf("[error] checking for cached members of relation %d: %v", rels[i].ID, err)
-							cached = true // don't skip in case of error
+					if bboxFilter != nil {
+						bboxFilter.filterRelations(rels)
+					}
+					numWithTags := 0
+					for i := range rels {
+						m.Filter(&rels[i].Tags)
+						if len(rels[i].Tags) > 0 {
+							numWithTags++
 						}
-						if cached {
-							hit++
-						} else {
-							skip++
-							rels[i].ID = osmcache.SKIP
+						if withLimiter && rels[i].ID != osmcache.SKIP {
+							var cached bool
+							var err error
+							if keptWays != nil {
+								cached, err = firstMemberIsKept(cache, keptWays, rels[i].Members)
+							} else {
+								cached, err = cache.FirstMemberIsCached(rels[i].Members)
+							}
+							if err != nil {
+								log.Printf("[error] checking for cached members of relation %d: %v", rels[i].ID, err)
+								cached = true // don't skip in case of error
+							}
+							if cached {
+								hit++
+							} else {
+								skip++
+								rels[i].ID = osmcache.SKIP
+							}
 						}
 					}
+					err := cache.Relations.PutRelations(rels)
+					if err != nil {
+						log.Printf("[error] caching relation: %v", err)
+					}
+					progress.AddRelations(numWithTags)
 				}
-				err := cache.Relations.PutRelations(rels)
-				if err != nil {
-					log.Printf("[error] caching relation: %v", err)
-				}
-				progress.AddRelations(numWithTags)
-			}
 
-			waitWriter.Done()
-		}()
-	}
+				waitWriter.Done()
+			}()
+		}
 
-	for i := 0; int64(i) < nCoords; i++ {
-		coordsSync.Add(1)
-		waitWriter.Add(1)
-		go func() {
-			var skip, hit int
-			g := geos.NewGeos()
-			defer g.Finish()
-			for nds := range coords {
-				if nds == nil {
-					coordsSync.Done()
-					coordsSync.Wait()
-					continue
-				}
-				if skipCoords {
-					continue
-				}
-				if withLimiter {
-					for i := range nds {
-						if !limiter.IntersectsBuffer(g, nds[i].Long, nds[i].Lat) {
-							skip++
-							nds[i].ID = osmcache.SKIP
-						} else {
-							hit++
+		for i := 0; int64(i) < nCoords; i++ {
+			coordsSync.Add(1)
+			waitWriter.Add(1)
+			go func() {
+				var skip, hit int
+				g := geos.NewGeos()
+				defer g.Finish()
+				for nds := range coords {
+					memory.Backoff()
+					if nds == nil {
+						coordsSync.Done()
+						coordsSync.Wait()
+						continue
+					}
+					if skipCoords {
+						continue
+					}
+					if bboxFilter != nil {
+						bboxFilter.filterCoords(nds)
+					}
+					if wayLocations {
+						progress.AddCoords(len(nds))
+						continue
+					}
+					if withLimiter {
+						for i := range nds {
+							if nds[i].ID == osmcache.SKIP {
+								continue
+							}
+							if !limiter.IntersectsBuffer(g, nds[i].Long, nds[i].Lat) {
+								skip++
+								nds[i].ID = osmcache.SKIP
+							} else {
+								hit++
+							}
 						}
 					}
+					cache.Coords.PutCoords(nds)
+					progress.AddCoords(len(nds))
 				}
-				cache.Coords.PutCoords(nds)
-				progress.AddCoords(len(nds))
-			}
-			waitWriter.Done()
-		}()
-	}
+				waitWriter.Done()
+			}()
+		}
 
-	for i := 0; int64(i) < nNodes; i++ {
-		coordsSync.Add(1)
-		waitWriter.Add(1)
-		go func() {
-			g := geos.NewGeos()
-			defer g.Finish()
-			m := tagmapping.NodeTagFilter()
-			for nds := range nodes {
-				if nds == nil {
-					coordsSync.Done()
-					coordsSync.Wait()
-					continue
-				}
-				if skipNodes {
-					continue
-				}
-				numWithTags := 0
-				for i := range nds {
-					m.Filter(&nds[i].Tags)
-					if len(nds[i].Tags) > 0 {
-						numWithTags++
+		for i := 0; int64(i) < nNodes; i++ {
+			coordsSync.Add(1)
+			waitWriter.Add(1)
+			go func() {
+				g := geos.NewGeos()
+				defer g.Finish()
+				m := tagmapping.NodeTagFilter()
+				for nds := range nodes {
+					memory.Backoff()
+					if nds == nil {
+						coordsSync.Done()
+						coordsSync.Wait()
+						continue
 					}
-					if withLimiter {
-						if !limiter.IntersectsBuffer(g, nds[i].Long, nds[i].Lat) {
-							nds[i].ID = osmcache.SKIP
+					if skipNodes {
+						continue
+					}
+					if bboxFilter != nil {
+						bboxFilter.filterNodes(nds)
+					}
+					numWithTags := 0
+					for i := range nds {
+						m.Filter(&nds[i].Tags)
+						if len(nds[i].Tags) > 0 {
+							numWithTags++
+						}
+						if withLimiter {
+							if !limiter.IntersectsBuffer(g, nds[i].Long, nds[i].Lat) {
+								nds[i].ID = osmcache.SKIP
+							}
 						}
 					}
+					cache.Nodes.PutNodes(nds)
+					progress.AddNodes(numWithTags)
 				}
-				cache.Nodes.PutNodes(nds)
-				progress.AddNodes(numWithTags)
+				waitWriter.Done()
+			}()
+		}
+		err = parser.Parse(ctx)
+		// all parsed elements are cached, even if the parser was cancelled
+		waitWriter.Wait()
+		if err != nil {
+			return errors.Wrapf(err, "parsing %s", filename)
+		}
+		if allNodes != nil {
+			if err := <-splitErr; err != nil {
+				return errors.Wrap(err, "comparing versions of nodes")
 			}
-			waitWriter.Done()
-		}()
+		}
+		return nil
 	}
-	ctx := context.Background()
-	if err := parser.Parse(ctx); err != nil {
-		return errors.Wrap(err, "parsing PBF")
+
+	if !inChunks {
+		if err := parse(f, true); err != nil {
+			return time.Time{}, err
+		}
+	}
+	for i, c := range chunks {
+		if err := parse(c.r, i == 0); err != nil {
+			return time.Time{}, err
+		}
+		// coords of the last bunches are only kept in memory
+		if err := cache.Coords.Flush(); err != nil {
+			return time.Time{}, errors.Wrap(err, "flushing coords cache")
+		}
+		if err := pos.Read(c.end); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	if bboxFilter != nil && !wayLocations {
+		if err := bboxFilter.readMissingCoords(ctx, filename, cache); err != nil {
+			return time.Time{}, err
+		}
+	}
+	if keptWays != nil {
+		if err := readMemberWays(ctx, filename, cache, tagmapping, keptWays); err != nil {
+			return time.Time{}, err
+		}
 	}
-	waitWriter.Wait()
 
-	return nil
+	return timestamp, nil
 }
@@ -0,0 +1,97 @@
+package reader
+
+import (
+	"io"
+	"os"
+
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/reader/osmpbf"
+	"github.com/pkg/errors"
+)
+
+// ReadPosition is the position of an interrupted read of a PBF file.
+//
+// Local PBF files are read in chunks of resumeBlocks blocks. All elements of
+// a chunk are cached before the following chunk is parsed, so that the read
+// can continue after the last chunk. Files from stdin or remote files, o5m
+// and XML files, and reads with bbox or lowDisk are always read from the
+// start, as they can't be read from an offset or as their filters depend on
+// all elements before.
+type ReadPosition struct {
+	// Offset is the offset of the first block that is read. All blocks
+	// before were read completely.
+	Offset int64
+	// Read is called after all elements of the blocks before offset were
+	// cached, e.g. to record the position in a checkpoint.
+	Read func(offset int64) error
+}
+
+// resumeBlocks is the number of blocks of each chunk.
+var resumeBlocks = 1000
+
+// blockChunk is a PBF file with the header of the read file and the
+// blocks of a single chunk.
+type blockChunk struct {
+	r io.Reader
+	// end is the offset after the last block
+	end int64
+}
+
+// resumeChunks returns the chunks of blocks of f, starting at the offset
+// of pos. Returns false if the file can't be read in chunks.
+func resumeChunks(filename string, f io.Reader, pos *ReadPosition, bbox *BBox, lowDisk bool) ([]blockChunk, bool, error) {
+	if pos == nil {
+		return nil, false, nil
+	}
+	file, ok := f.(*os.File)
+	if !ok || bbox != nil || lowDisk {
+		if pos.Offset > 0 {
+			log.Printf("[info] unable to continue reading %s at offset %d, reading from the start", filename, pos.Offset)
+		}
+		return nil, false, nil
+	}
+	offsets, err := osmpbf.BlobOffsets(file)
+	if _, seekErr := file.Seek(0, io.SeekStart); seekErr != nil {
+		return nil, false, seekErr
+	}
+	if err != nil {
+		if pos.Offset > 0 {
+			return nil, false, errors.Wrap(err, "reading PBF blocks")
+		}
+		// not a PBF file
+		return nil, false, nil
+	}
+	return blockChunks(file, offsets, pos.Offset)
+}
+
+// blockChunks splits the blocks of the file into chunks of resumeBlocks
+// blocks, starting with the block at offset.
+func blockChunks(r io.ReaderAt, offsets []int64, offset int64) ([]blockChunk, bool, error) {
+	headerSize := offsets[1]
+	last := len(offsets) - 1
+	first := 1
+	if offset > 0 {
+		for first <= last && offsets[first] < offset {
+			first++
+		}
+		if first > last || offsets[first] != offset {
+			return nil, false, errors.Errorf("no PBF block at offset %d", offset)
+		}
+	}
+	chunks := []blockChunk{}
+	for i := first; i < last; i += resumeBlocks {
+		end := i + resumeBlocks
+		if end > last {
+			end = last
+		}
+		start, size := offsets[i], offsets[end]-offsets[i]
+		chunks = append(chunks, blockChunk{
+			r: io.MultiReader(
+				io.NewSectionReader(r, 0, headerSize),
+				io.NewSectionReader(r, start, size),
+			),
+			end: offsets[end],
+		})
+	}
+	return chunks, true, nil
+}
@@ -0,0 +1,52 @@
+package reader
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestBlockChunks(t *testing.T) {
+	// header of 2 bytes, followed by 5 blocks
+	data := []byte("hhaabbccddee")
+	offsets := []int64{0, 2, 4, 6, 8, 10, 12}
+
+	old := resumeBlocks
+	resumeBlocks = 2
+	defer func() { resumeBlocks = old }()
+
+	for _, tc := range []struct {
+		offset int64
+		chunks []string
+		ends   []int64
+	}{
+		{0, []string{"hhaabb", "hhccdd", "hhee"}, []int64{6, 10, 12}},
+		{6, []string{"hhccdd", "hhee"}, []int64{10, 12}},
+		{10, []string{"hhee"}, []int64{12}},
+		{12, nil, nil},
+	} {
+		chunks, ok, err := blockChunks(bytes.NewReader(data), offsets, tc.offset)
+		if err != nil || !ok {
+			t.Fatal(tc.offset, ok, err)
+		}
+		if len(chunks) != len(tc.chunks) {
+			t.Fatalf("%d: unexpected chunks %v", tc.offset, chunks)
+		}
+		for i, c := range chunks {
+			b, err := ioutil.ReadAll(c.r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tc.chunks[i] || c.end != tc.ends[i] {
+				t.Errorf("%d: unexpected chunk %q %d", tc.offset, b, c.end)
+			}
+		}
+	}
+
+	if _, _, err := blockChunks(bytes.NewReader(data), offsets, 5); err == nil {
+		t.Error("expected error for offset within a block")
+	}
+	if _, _, err := blockChunks(bytes.NewReader(data), offsets, 14); err == nil {
+		t.Error("expected error for offset after the file")
+	}
+}
@@ -0,0 +1,118 @@
+package reader
+
+import (
+	osm "github.com/omniscale/go-osm"
+	osmcache "github.com/omniscale/imposm3/cache"
+)
+
+// Elements can be in multiple files of an import, e.g. ways that cross
+// the borders of two extracts. The files are read from the oldest to the
+// newest, but the extracts can contain older versions of single elements,
+// e.g. if a newer extract was created from an outdated planet. With the
+// versions cache, the version of each element is compared with the
+// version of the already cached element and only newer (or equal)
+// versions are cached. Elements without version (from files without
+// metadata) are always cached.
+//
+// The PBF parser only adds the metadata to nodes with tags if the nodes
+// are split into coords and nodes. All nodes are parsed as nodes with
+// metadata instead and splitNodes sends them to the coords and nodes
+// channels, after older versions were skipped.
+
+func version(m *osm.Metadata) int32 {
+	if m == nil {
+		return 0
+	}
+	return m.Version
+}
+
+// keepNewerNodes skips all nodes that are older than the cached nodes.
+func keepNewerNodes(v *osmcache.Versions, nds []osm.Node) error {
+	ids := make([]int64, len(nds))
+	versions := make([]int32, len(nds))
+	for i := range nds {
+		ids[i], versions[i] = nds[i].ID, version(nds[i].Metadata)
+	}
+	keep, err := v.Keep(osmcache.NodeVersion, ids, versions)
+	if err != nil {
+		return err
+	}
+	for i := range nds {
+		if !keep[i] {
+			nds[i].ID = osmcache.SKIP
+		}
+	}
+	return nil
+}
+
+// keepNewerWays skips all ways that are older than the cached ways.
+func keepNewerWays(v *osmcache.Versions, ws []osm.Way) error {
+	ids := make([]int64, len(ws))
+	versions := make([]int32, len(ws))
+	for i := range ws {
+		ids[i], versions[i] = ws[i].ID, version(ws[i].Metadata)
+	}
+	keep, err := v.Keep(osmcache.WayVersion, ids, versions)
+	if err != nil {
+		return err
+	}
+	for i := range ws {
+		if !keep[i] {
+			ws[i].ID = osmcache.SKIP
+		}
+	}
+	return nil
+}
+
+// keepNewerRelations skips all relations that are older than the cached
+// relations.
+func keepNewerRelations(v *osmcache.Versions, rels []osm.Relation) error {
+	ids := make([]int64, len(rels))
+	versions := make([]int32, len(rels))
+	for i := range rels {
+		ids[i], versions[i] = rels[i].ID, version(rels[i].Metadata)
+	}
+	keep, err := v.Keep(osmcache.RelationVersion, ids, versions)
+	if err != nil {
+		return err
+	}
+	for i := range rels {
+		if !keep[i] {
+			rels[i].ID = osmcache.SKIP
+		}
+	}
+	return nil
+}
+
+// splitNodes skips older versions of all nodes and sends them to coords
+// and the nodes with tags to nodes. A nil batch is a flush request,
+// flushed receives a value after all previous nodes were sent. The coords
+// and nodes channels are closed after all nodes are sent.
+func splitNodes(all <-chan []osm.Node, v *osmcache.Versions, coords, nodes chan<- []osm.Node, flushed chan<- struct{}) error {
+	defer close(coords)
+	defer close(nodes)
+	var err error
+	for nds := range all {
+		if nds == nil {
+			flushed <- struct{}{}
+			continue
+		}
+		if err != nil {
+			continue // drain channel
+		}
+		if err = keepNewerNodes(v, nds); err != nil {
+			continue
+		}
+		var tagged []osm.Node
+		for i := range nds {
+			if nds[i].ID != osmcache.SKIP && len(nds[i].Tags) > 0 {
+				tagged = append(tagged, nds[i])
+			}
+		}
+		coords <- nds
+		if len(tagged) > 0 {
+			nodes <- tagged
+		}
+	}
+	return err
+}
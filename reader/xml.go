@@ -0,0 +1,237 @@
+package reader
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"time"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/go-osm/parser/pbf"
+	"github.com/pkg/errors"
+)
+
+// xmlParser parses OSM XML files (.osm).
+type xmlParser struct {
+	dec        *xml.Decoder
+	conf       pbf.Config
+	timestamp  time.Time
+	headerRead bool
+	first      *xml.StartElement // first element after the header
+}
+
+func newXMLParser(r io.Reader, conf pbf.Config) *xmlParser {
+	return &xmlParser{dec: xml.NewDecoder(r), conf: conf}
+}
+
+// Timestamp returns the timestamp of the <osm> element, or osm_base of the
+// <meta> element of Overpass results.
+func (p *xmlParser) Timestamp() (time.Time, error) {
+	if err := p.readHeader(); err != nil {
+		return time.Time{}, err
+	}
+	return p.timestamp, nil
+}
+
+// readHeader reads all elements till the first node, way or relation.
+func (p *xmlParser) readHeader() error {
+	if p.headerRead {
+		return nil
+	}
+	root := false
+	for {
+		tok, err := p.dec.Token()
+		if err == io.EOF {
+			if !root {
+				return errors.New("parsing XML header: missing <osm> element")
+			}
+			p.headerRead = true
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "parsing XML header")
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if !root {
+			if start.Name.Local != "osm" {
+				return errors.Errorf("parsing XML header: expected <osm> element, got <%s>", start.Name.Local)
+			}
+			root = true
+			p.timestamp = parseTimestamp(start.Attr, "timestamp")
+			continue
+		}
+		switch start.Name.Local {
+		case "meta":
+			if t := parseTimestamp(start.Attr, "osm_base"); !t.IsZero() {
+				p.timestamp = t
+			}
+		case "node", "way", "relation":
+			first := start.Copy()
+			p.first = &first
+			p.headerRead = true
+			return nil
+		}
+	}
+}
+
+func parseTimestamp(attrs []xml.Attr, name string) time.Time {
+	for _, attr := range attrs {
+		if attr.Name.Local == name {
+			t, err := time.Parse(time.RFC3339, attr.Value)
+			if err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// Parse parses all elements and sends them in batches to the channels of
+// the config. Elements that are deleted (action="delete" from JOSM or
+// visible="false") are skipped.
+func (p *xmlParser) Parse(ctx context.Context) error {
+	if err := p.readHeader(); err != nil {
+		return err
+	}
+	b := newBatchSender(p.conf)
+	defer b.close()
+
+	var elem osm.Element
+	var node osm.Node
+	var way osm.Way
+	var rel osm.Relation
+	deleted := false
+
+	for {
+		var tok xml.Token
+		if p.first != nil {
+			tok, p.first = *p.first, nil
+		} else {
+			var err error
+			tok, err = p.dec.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return errors.Wrap(err, "parsing XML")
+			}
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			switch tok.Name.Local {
+			case "node", "way", "relation":
+				elem = osm.Element{}
+				deleted = false
+				for _, attr := range tok.Attr {
+					switch attr.Name.Local {
+					case "id":
+						elem.ID, _ = strconv.ParseInt(attr.Value, 10, 64)
+					case "action":
+						deleted = deleted || attr.Value == "delete"
+					case "visible":
+						deleted = deleted || attr.Value == "false"
+					case "version":
+						if p.conf.IncludeMetadata {
+							v, _ := strconv.ParseInt(attr.Value, 10, 32)
+							elem.Metadata = &osm.Metadata{Version: int32(v)}
+						}
+					}
+				}
+				switch tok.Name.Local {
+				case "node":
+					node = osm.Node{}
+					for _, attr := range tok.Attr {
+						switch attr.Name.Local {
+						case "lat":
+							node.Lat, _ = strconv.ParseFloat(attr.Value, 64)
+						case "lon":
+							node.Long, _ = strconv.ParseFloat(attr.Value, 64)
+						}
+					}
+				case "way":
+					way = osm.Way{}
+				case "relation":
+					rel = osm.Relation{}
+				}
+			case "nd":
+				for _, attr := range tok.Attr {
+					if attr.Name.Local == "ref" {
+						ref, _ := strconv.ParseInt(attr.Value, 10, 64)
+						way.Refs = append(way.Refs, ref)
+					}
+				}
+			case "member":
+				member := osm.Member{}
+				valid := true
+				for _, attr := range tok.Attr {
+					switch attr.Name.Local {
+					case "type":
+						var ok bool
+						member.Type, ok = memberTypes[attr.Value]
+						valid = valid && ok
+					case "role":
+						member.Role = attr.Value
+					case "ref":
+						var err error
+						member.ID, err = strconv.ParseInt(attr.Value, 10, 64)
+						valid = valid && err == nil
+					}
+				}
+				if valid {
+					rel.Members = append(rel.Members, member)
+				}
+			case "tag":
+				var k, v string
+				for _, attr := range tok.Attr {
+					if attr.Name.Local == "k" {
+						k = attr.Value
+					} else if attr.Name.Local == "v" {
+						v = attr.Value
+					}
+				}
+				if elem.Tags == nil {
+					elem.Tags = make(osm.Tags)
+				}
+				elem.Tags[k] = v
+			}
+		case xml.EndElement:
+			switch tok.Name.Local {
+			case "node":
+				if deleted {
+					continue
+				}
+				node.Element = elem
+				b.node(node)
+			case "way":
+				if deleted {
+					continue
+				}
+				way.Element = elem
+				b.way(way)
+			case "relation":
+				if deleted {
+					continue
+				}
+				rel.Element = elem
+				b.relation(rel)
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+	}
+
+	b.flush()
+	return nil
+}
+
+var memberTypes = map[string]osm.MemberType{
+	"node":     osm.NodeMember,
+	"way":      osm.WayMember,
+	"relation": osm.RelationMember,
+}
@@ -0,0 +1,194 @@
+package reader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/go-osm/parser/pbf"
+)
+
+const testXML = `<?xml version='1.0' encoding='UTF-8'?>
+<osm version="0.6" generator="JOSM" timestamp="2019-03-01T12:00:00Z">
+  <bounds minlat="42" minlon="10" maxlat="44" maxlon="12"/>
+  <node id="1" lat="42" lon="10">
+    <tag k="amenity" v="cafe"/>
+  </node>
+  <node id="2" lat="43" lon="11"/>
+  <node id="3" action="delete" lat="44" lon="12"/>
+  <way id="10">
+    <nd ref="1"/>
+    <nd ref="2"/>
+    <tag k="highway" v="residential"/>
+  </way>
+  <node id="4" lat="44" lon="11"/>
+  <relation id="20" visible="false"/>
+  <relation id="21">
+    <member type="way" ref="10" role="outer"/>
+    <member type="unknown" ref="11" role=""/>
+    <tag k="type" v="multipolygon"/>
+  </relation>
+</osm>
+`
+
+type parsedXML struct {
+	coords, nodes []osm.Node
+	ways          []osm.Way
+	rels          []osm.Relation
+	events        []string
+}
+
+func parseTestXML(t *testing.T, data []byte) (parsedXML, time.Time) {
+	var result parsedXML
+	coords := make(chan []osm.Node)
+	nodes := make(chan []osm.Node)
+	ways := make(chan []osm.Way)
+	relations := make(chan []osm.Relation)
+	conf := pbf.Config{
+		Coords:          coords,
+		Nodes:           nodes,
+		Ways:            ways,
+		Relations:       relations,
+		OnFirstWay:      func() { result.events = append(result.events, "first way") },
+		OnFirstRelation: func() { result.events = append(result.events, "first relation") },
+	}
+	p, err := newParser(bytes.NewReader(data), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.(*xmlParser); !ok {
+		t.Fatalf("expected XML parser, got %T", p)
+	}
+	ts, err := p.Timestamp()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() { done <- p.Parse(context.Background()) }()
+	for coords != nil || nodes != nil || ways != nil || relations != nil {
+		select {
+		case c, ok := <-coords:
+			if !ok {
+				coords = nil
+			}
+			result.coords = append(result.coords, c...)
+		case n, ok := <-nodes:
+			if !ok {
+				nodes = nil
+			}
+			result.nodes = append(result.nodes, n...)
+		case w, ok := <-ways:
+			if !ok {
+				ways = nil
+			}
+			result.ways = append(result.ways, w...)
+		case r, ok := <-relations:
+			if !ok {
+				relations = nil
+			}
+			result.rels = append(result.rels, r...)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	return result, ts
+}
+
+func TestXMLParser(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(testXML))
+	w.Close()
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"plain", []byte(testXML)},
+		{"gzip", gz.Bytes()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result, ts := parseTestXML(t, tc.data)
+
+			if !ts.Equal(time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)) {
+				t.Error("unexpected timestamp", ts)
+			}
+			if len(result.coords) != 3 || result.coords[0].ID != 1 || result.coords[1].ID != 2 || result.coords[2].ID != 4 {
+				t.Errorf("unexpected coords %v", result.coords)
+			}
+			if result.coords[1].Lat != 43 || result.coords[1].Long != 11 || result.coords[0].Tags != nil {
+				t.Errorf("unexpected coord %v", result.coords[1])
+			}
+			if len(result.nodes) != 1 || result.nodes[0].ID != 1 || result.nodes[0].Tags["amenity"] != "cafe" {
+				t.Errorf("unexpected nodes %v", result.nodes)
+			}
+			if len(result.ways) != 1 || result.ways[0].ID != 10 ||
+				len(result.ways[0].Refs) != 2 || result.ways[0].Tags["highway"] != "residential" {
+				t.Errorf("unexpected ways %v", result.ways)
+			}
+			if len(result.rels) != 1 || result.rels[0].ID != 21 || len(result.rels[0].Members) != 1 ||
+				result.rels[0].Members[0] != (osm.Member{ID: 10, Type: osm.WayMember, Role: "outer"}) {
+				t.Errorf("unexpected relations %v", result.rels)
+			}
+			if strings.Join(result.events, ",") != "first way,first relation" {
+				t.Errorf("unexpected events %v", result.events)
+			}
+		})
+	}
+}
+
+func TestXMLParserOverpassTimestamp(t *testing.T) {
+	p := newXMLParser(strings.NewReader(`<osm version="0.6">
+  <note>The data included in this document is from www.openstreetmap.org.</note>
+  <meta osm_base="2020-06-01T08:30:02Z"/>
+  <node id="1" lat="42" lon="10"/>
+</osm>`), pbf.Config{})
+	ts, err := p.Timestamp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ts.Equal(time.Date(2020, 6, 1, 8, 30, 2, 0, time.UTC)) {
+		t.Error("unexpected timestamp", ts)
+	}
+}
+
+func TestXMLParserInvalidRoot(t *testing.T) {
+	p := newXMLParser(strings.NewReader(`<osmChange version="0.6"><create/></osmChange>`), pbf.Config{})
+	if _, err := p.Timestamp(); err == nil {
+		t.Error("expected error for osmChange file")
+	}
+}
+
+func TestXMLParserAllNodesWithVersions(t *testing.T) {
+	// all nodes are sent to Nodes without Coords, see splitNodes
+	nodes := make(chan []osm.Node, 8)
+	p := newXMLParser(strings.NewReader(`<osm version="0.6">
+  <node id="1" version="3" lat="42" lon="10">
+    <tag k="amenity" v="cafe"/>
+  </node>
+  <node id="2" version="7" lat="43" lon="11"/>
+  <way id="10" version="2">
+    <nd ref="1"/>
+    <nd ref="2"/>
+  </way>
+</osm>`), pbf.Config{Nodes: nodes, IncludeMetadata: true})
+	if err := p.Parse(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var result []osm.Node
+	for nds := range nodes {
+		result = append(result, nds...)
+	}
+	if len(result) != 2 || result[0].Tags["amenity"] != "cafe" || result[1].Lat != 43 {
+		t.Fatalf("unexpected nodes %v", result)
+	}
+	if version(result[0].Metadata) != 3 || version(result[1].Metadata) != 7 {
+		t.Errorf("unexpected versions %v %v", result[0].Metadata, result[1].Metadata)
+	}
+}
@@ -11,6 +11,9 @@ type ElementCount struct {
 	Total   int64
 	Rps     float64
 	LastRps float64
+	// Duration is the time between the first and the last added element,
+	// till the last tick.
+	Duration time.Duration
 }
 
 func NewRpsCounter() *RpsCounter {
@@ -68,13 +71,23 @@ func (r *RpsCounter) Progress() float64 {
 
 func (r *RpsCounter) Count() ElementCount {
 	return ElementCount{
-		Current: r.Value(),
-		Total:   r.total,
-		Rps:     r.Rps(),
-		LastRps: r.LastRps(),
+		Current:  r.Value(),
+		Total:    r.total,
+		Rps:      r.Rps(),
+		LastRps:  r.LastRps(),
+		Duration: r.Duration(),
 	}
 }
 
+func (r *RpsCounter) Duration() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.start.IsZero() || r.stop.Before(r.start) {
+		return 0
+	}
+	return r.stop.Sub(r.start)
+}
+
 func (r *RpsCounter) Tick() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -0,0 +1,209 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/omniscale/imposm3/log"
+)
+
+// Metrics are exposed in the Prometheus text format. Counters are collected
+// all the time, the HTTP listener is only started with -httpmetrics.
+
+var (
+	tableRows   sync.Map // table name -> *int64
+	cacheHits   sync.Map // cache name -> *int64
+	cacheMisses sync.Map // cache name -> *int64
+
+	dbWrites = struct {
+		sync.Mutex
+		ops map[string]*histogram
+	}{ops: make(map[string]*histogram)}
+
+	stepDurations = struct {
+		sync.Mutex
+		seconds map[string]float64
+	}{seconds: make(map[string]float64)}
+
+	diffState = struct {
+		sync.Mutex
+		sequence int
+		time     time.Time
+		err      string
+		errTime  time.Time
+	}{}
+)
+
+// dbWriteBuckets are the upper bounds of the DB write latency histogram in
+// seconds.
+var dbWriteBuckets = []float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 30}
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func addToMap(m *sync.Map, key string, n int) {
+	v, ok := m.Load(key)
+	if !ok {
+		v, _ = m.LoadOrStore(key, new(int64))
+	}
+	atomic.AddInt64(v.(*int64), int64(n))
+}
+
+// CacheHit counts a lookup that was answered by the in-memory cache.
+func CacheHit(cache string) { addToMap(&cacheHits, cache, 1) }
+
+// CacheMiss counts a lookup that needed to read from disk.
+func CacheMiss(cache string) { addToMap(&cacheMisses, cache, 1) }
+
+// ObserveDBWrite records the duration of a database write operation (e.g.
+// insert, delete or commit).
+func ObserveDBWrite(op string, d time.Duration) {
+	dbWrites.Lock()
+	h, ok := dbWrites.ops[op]
+	if !ok {
+		h = &histogram{buckets: dbWriteBuckets, counts: make([]uint64, len(dbWriteBuckets))}
+		dbWrites.ops[op] = h
+	}
+	h.observe(d.Seconds())
+	dbWrites.Unlock()
+}
+
+// SetDiffState sets the sequence and timestamp of the last imported diff.
+// It resets the error of SetDiffError.
+func SetDiffState(sequence int, t time.Time) {
+	diffState.Lock()
+	diffState.sequence = sequence
+	diffState.time = t
+	diffState.err = ""
+	diffState.Unlock()
+}
+
+var diffSequenceSuffix = regexp.MustCompile(` #\d+$`)
+
+// observeStep records the duration of finished steps. Sequence numbers of
+// diff imports are removed from the names (Importing #1234).
+func observeStep(name string, d time.Duration) {
+	name = diffSequenceSuffix.ReplaceAllString(name, "")
+	stepDurations.Lock()
+	stepDurations.seconds[name] = d.Seconds()
+	stepDurations.Unlock()
+}
+
+// StartHTTPMetrics starts an HTTP listener on bind with the Prometheus
+// metrics at /metrics and the replication status as JSON at /status.
+func StartHTTPMetrics(bind string) {
+	log.ObserveSteps(observeStep)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, time.Now())
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		writeStatus(w, time.Now())
+	})
+	go func() {
+		log.Println(http.ListenAndServe(bind, mux))
+	}()
+}
+
+func sortedKeys(m *sync.Map) []string {
+	var keys []string
+	m.Range(func(k, v interface{}) bool {
+		keys = append(keys, k.(string))
+		return true
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+func writeMapCounter(w io.Writer, m *sync.Map, name, label, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for _, k := range sortedKeys(m) {
+		v, _ := m.Load(k)
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, k, atomic.LoadInt64(v.(*int64)))
+	}
+}
+
+func writeMetrics(w io.Writer, now time.Time) {
+	fmt.Fprintf(w, "# HELP imposm_step_running Steps that are running.\n# TYPE imposm_step_running gauge\n")
+	for _, name := range log.RunningSteps() {
+		fmt.Fprintf(w, "imposm_step_running{step=%q} 1\n", diffSequenceSuffix.ReplaceAllString(name, ""))
+	}
+
+	stepDurations.Lock()
+	var steps []string
+	for name := range stepDurations.seconds {
+		steps = append(steps, name)
+	}
+	sort.Strings(steps)
+	fmt.Fprintf(w, "# HELP imposm_step_duration_seconds Duration of the last run of each step.\n# TYPE imposm_step_duration_seconds gauge\n")
+	for _, name := range steps {
+		fmt.Fprintf(w, "imposm_step_duration_seconds{step=%q} %g\n", name, stepDurations.seconds[name])
+	}
+	stepDurations.Unlock()
+
+	current.Lock()
+	c := current.counter
+	current.Unlock()
+	if c != nil {
+		fmt.Fprintf(w, "# HELP imposm_elements_total Elements processed by the running step.\n# TYPE imposm_elements_total counter\n")
+		for _, e := range []struct {
+			typ     string
+			counter *RpsCounter
+		}{{"coords", c.Coords}, {"nodes", c.Nodes}, {"ways", c.Ways}, {"relations", c.Relations}} {
+			fmt.Fprintf(w, "imposm_elements_total{type=%q} %d\n", e.typ, e.counter.Value())
+		}
+	}
+
+	writeMapCounter(w, &tableRows, "imposm_rows_written_total", "table", "Rows written to the database.")
+	writeMapCounter(w, &cacheHits, "imposm_cache_hits_total", "cache", "Lookups answered by the in-memory cache.")
+	writeMapCounter(w, &cacheMisses, "imposm_cache_misses_total", "cache", "Lookups that needed to read from disk.")
+
+	dbWrites.Lock()
+	var ops []string
+	for op := range dbWrites.ops {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	fmt.Fprintf(w, "# HELP imposm_db_write_duration_seconds Duration of database writes.\n# TYPE imposm_db_write_duration_seconds histogram\n")
+	for _, op := range ops {
+		h := dbWrites.ops[op]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "imposm_db_write_duration_seconds_bucket{op=%q,le=\"%g\"} %d\n", op, b, h.counts[i])
+		}
+		fmt.Fprintf(w, "imposm_db_write_duration_seconds_bucket{op=%q,le=\"+Inf\"} %d\n", op, h.count)
+		fmt.Fprintf(w, "imposm_db_write_duration_seconds_sum{op=%q} %g\n", op, h.sum)
+		fmt.Fprintf(w, "imposm_db_write_duration_seconds_count{op=%q} %d\n", op, h.count)
+	}
+	dbWrites.Unlock()
+
+	diffState.Lock()
+	if !diffState.time.IsZero() {
+		fmt.Fprintf(w, "# HELP imposm_diff_sequence Sequence of the last imported diff.\n# TYPE imposm_diff_sequence gauge\n")
+		fmt.Fprintf(w, "imposm_diff_sequence %d\n", diffState.sequence)
+		fmt.Fprintf(w, "# HELP imposm_diff_lag_seconds Age of the data of the last imported diff.\n# TYPE imposm_diff_lag_seconds gauge\n")
+		fmt.Fprintf(w, "imposm_diff_lag_seconds %g\n", now.Sub(diffState.time).Seconds())
+	}
+	diffState.Unlock()
+}
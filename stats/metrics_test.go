@@ -0,0 +1,40 @@
+package stats
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	AddRows("osm_buildings", 3)
+	CacheHit("coords")
+	CacheHit("coords")
+	CacheMiss("coords")
+	ObserveDBWrite("insert", 2*time.Millisecond)
+	ObserveDBWrite("insert", 2*time.Second)
+	observeStep("Importing #4231", 3*time.Second)
+	now := time.Now()
+	SetDiffState(4231, now.Add(-90*time.Second))
+
+	buf := bytes.Buffer{}
+	writeMetrics(&buf, now)
+	out := buf.String()
+	for _, line := range []string{
+		`imposm_rows_written_total{table="osm_buildings"} 3`,
+		`imposm_cache_hits_total{cache="coords"} 2`,
+		`imposm_cache_misses_total{cache="coords"} 1`,
+		`imposm_db_write_duration_seconds_bucket{op="insert",le="0.001"} 0`,
+		`imposm_db_write_duration_seconds_bucket{op="insert",le="0.005"} 1`,
+		`imposm_db_write_duration_seconds_bucket{op="insert",le="+Inf"} 2`,
+		`imposm_db_write_duration_seconds_count{op="insert"} 2`,
+		`imposm_step_duration_seconds{step="Importing"} 3`,
+		`imposm_diff_sequence 4231`,
+		`imposm_diff_lag_seconds 90`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, out)
+		}
+	}
+}
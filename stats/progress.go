@@ -0,0 +1,221 @@
+package stats
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/omniscale/imposm3/log"
+	"github.com/pkg/errors"
+)
+
+// Progress records are written as JSON lines for other tools, in addition
+// to the progress log lines.
+
+// progressCount is the progress of a single element type.
+type progressCount struct {
+	Count int64   `json:"count"`
+	Total int64   `json:"total,omitempty"`
+	Rps   float64 `json:"rps"`
+	// ETA is the estimated number of seconds till all elements are
+	// processed, if the total is known.
+	ETA float64 `json:"eta,omitempty"`
+}
+
+type progressRecord struct {
+	Time       time.Time        `json:"time"`
+	Phase      string           `json:"phase"`
+	Elapsed    float64          `json:"elapsed"`
+	Coords     *progressCount   `json:"coords,omitempty"`
+	Nodes      *progressCount   `json:"nodes,omitempty"`
+	Ways       *progressCount   `json:"ways,omitempty"`
+	Relations  *progressCount   `json:"relations,omitempty"`
+	ETA        float64          `json:"eta,omitempty"`
+	CacheBytes map[string]int64 `json:"cache_bytes,omitempty"`
+	Rows       int64            `json:"rows"`
+}
+
+var (
+	rows    int64
+	current struct {
+		sync.Mutex
+		counter *Counter
+	}
+)
+
+// AddRows adds n to the number of rows that were written to the table.
+func AddRows(table string, n int) {
+	atomic.AddInt64(&rows, int64(n))
+	addToMap(&tableRows, table, n)
+}
+
+func setCurrentCounter(c *Counter) {
+	current.Lock()
+	current.counter = c
+	current.Unlock()
+}
+
+func clearCurrentCounter(c *Counter) {
+	current.Lock()
+	if current.counter == c {
+		current.counter = nil
+	}
+	current.Unlock()
+}
+
+// progressCount returns the progress of r, or nil if the type was not
+// processed and no total is known.
+func (r *RpsCounter) progressCount() *progressCount {
+	count := r.Value()
+	if count == 0 && r.total == 0 {
+		return nil
+	}
+	r.mu.Lock()
+	start := r.start
+	r.mu.Unlock()
+
+	pc := &progressCount{Count: count, Total: r.total}
+	if !start.IsZero() {
+		if d := time.Since(start).Seconds(); d > 0 {
+			pc.Rps = float64(count) / d
+		}
+	}
+	if r.total > count && pc.Rps > 0 {
+		pc.ETA = float64(r.total-count) / pc.Rps
+	}
+	return pc
+}
+
+func newProgressRecord(start time.Time, cacheDir string) progressRecord {
+	rec := progressRecord{
+		Time:    time.Now(),
+		Phase:   log.CurrentStep(),
+		Elapsed: time.Since(start).Seconds(),
+		Rows:    atomic.LoadInt64(&rows),
+	}
+	current.Lock()
+	c := current.counter
+	current.Unlock()
+	if c != nil {
+		rec.Coords = c.Coords.progressCount()
+		rec.Nodes = c.Nodes.progressCount()
+		rec.Ways = c.Ways.progressCount()
+		rec.Relations = c.Relations.progressCount()
+		// ETA of the types that are processed at the moment, types that did
+		// not start are not included
+		for _, pc := range []*progressCount{rec.Coords, rec.Nodes, rec.Ways, rec.Relations} {
+			if pc != nil {
+				rec.ETA += pc.ETA
+			}
+		}
+	}
+	if cacheDir != "" {
+		rec.CacheBytes = cacheSizes(cacheDir)
+	}
+	return rec
+}
+
+// cacheSizes returns the size of all existing caches in cacheDir.
+func cacheSizes(cacheDir string) map[string]int64 {
+	sizes := make(map[string]int64)
+	for _, name := range []string{"coords", "nodes", "ways", "relations"} {
+		dir := filepath.Join(cacheDir, name)
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		var size int64
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				size += info.Size()
+			}
+			return nil
+		})
+		sizes[name] = size
+	}
+	return sizes
+}
+
+// StartProgress writes a JSON progress record each interval to dest. dest
+// is json for stdout, or json:/path/to/socket for a unix socket. All
+// clients that are connected to the socket receive the records.
+func StartProgress(dest string, cacheDir string, interval time.Duration) error {
+	var w io.Writer
+	switch {
+	case dest == "json":
+		w = os.Stdout
+	case strings.HasPrefix(dest, "json:"):
+		s, err := listenProgress(strings.TrimPrefix(dest, "json:"))
+		if err != nil {
+			return err
+		}
+		w = s
+	default:
+		return errors.Errorf("unknown progress output %q, expected json or json:/path/to/socket", dest)
+	}
+
+	go func() {
+		start := time.Now()
+		enc := json.NewEncoder(w)
+		for range time.Tick(interval) {
+			if err := enc.Encode(newProgressRecord(start, cacheDir)); err != nil {
+				log.Println("[warn] writing progress: ", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// progressSocket writes to all clients of a unix socket.
+type progressSocket struct {
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func listenProgress(path string) (*progressSocket, error) {
+	// remove socket of a previous run
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "removing progress socket")
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating progress socket")
+	}
+	s := &progressSocket{}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				log.Println("[warn] accepting progress client: ", err)
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+		}
+	}()
+	return s, nil
+}
+
+// Write writes p to all clients. Clients that do not receive the record
+// within a second are disconnected.
+func (s *progressSocket) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conns := s.conns[:0]
+	for _, conn := range s.conns {
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write(p); err != nil {
+			conn.Close()
+			continue
+		}
+		conns = append(conns, conn)
+	}
+	s.conns = conns
+	return len(p), nil
+}
@@ -0,0 +1,93 @@
+package stats
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/omniscale/imposm3/log"
+)
+
+func TestProgressRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imposm_progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "ways"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "ways", "000001.ldb"), make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewStatsReporterWithEstimate(&ElementCounts{Ways: ElementCount{Current: 100}})
+	defer s.Stop()
+	s.AddWays(25)
+	AddRows("osm_roads", 10)
+	done := log.Step("Writing test data")
+	defer done()
+
+	time.Sleep(10 * time.Millisecond)
+	rec := newProgressRecord(time.Now(), dir)
+	if rec.Phase != "Writing test data" {
+		t.Errorf("unexpected phase %q", rec.Phase)
+	}
+	if rec.Ways == nil || rec.Ways.Count != 25 || rec.Ways.Total != 100 || rec.Ways.Rps <= 0 || rec.Ways.ETA <= 0 {
+		t.Errorf("unexpected ways progress %#v", rec.Ways)
+	}
+	if rec.Coords != nil || rec.Nodes != nil || rec.Relations != nil {
+		t.Errorf("unexpected progress of other types %v %v %v", rec.Coords, rec.Nodes, rec.Relations)
+	}
+	if rec.ETA != rec.Ways.ETA {
+		t.Errorf("unexpected ETA %v", rec.ETA)
+	}
+	if rec.Rows < 10 {
+		t.Errorf("unexpected rows %d", rec.Rows)
+	}
+	if len(rec.CacheBytes) != 1 || rec.CacheBytes["ways"] != 1000 {
+		t.Errorf("unexpected cache sizes %v", rec.CacheBytes)
+	}
+}
+
+func TestProgressSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imposm_progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "progress.sock")
+
+	if err := StartProgress("json:"+path, "", 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec progressRecord
+	if err := json.Unmarshal(line, &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Time.IsZero() {
+		t.Errorf("unexpected record %s", line)
+	}
+}
+
+func TestStartProgressInvalid(t *testing.T) {
+	if err := StartProgress("xml", "", time.Second); err == nil {
+		t.Error("expected error for unknown output")
+	}
+}
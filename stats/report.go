@@ -0,0 +1,111 @@
+package stats
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/omniscale/imposm3/log"
+	"github.com/pkg/errors"
+)
+
+// Report collects the duration and the processed elements of each phase of
+// an import. It is logged at the end of the import and can be written as
+// JSON with -report.
+type Report struct {
+	mu     sync.Mutex
+	Phases []PhaseReport `json:"phases"`
+}
+
+// PhaseReport is the duration of a phase (e.g. read or write), with the
+// number of elements for phases that process OSM elements.
+type PhaseReport struct {
+	Name     string          `json:"name"`
+	Seconds  float64         `json:"seconds"`
+	Elements []ElementReport `json:"elements,omitempty"`
+}
+
+// ElementReport is the number of elements of one type (coords, nodes, ways
+// or relations) that were processed in a phase, with the throughput while
+// these elements were processed.
+type ElementReport struct {
+	Type      string  `json:"type"`
+	Count     int64   `json:"count"`
+	Seconds   float64 `json:"seconds"`
+	PerSecond float64 `json:"per_second"`
+}
+
+func NewReport() *Report {
+	return &Report{}
+}
+
+// Phase starts the timer of a phase. The returned function finishes the
+// phase, counts can be nil for phases without elements.
+func (r *Report) Phase(name string) func(counts *ElementCounts) {
+	start := time.Now()
+	return func(counts *ElementCounts) {
+		p := PhaseReport{Name: name, Seconds: time.Since(start).Seconds()}
+		if counts != nil {
+			for _, e := range []struct {
+				typ   string
+				count ElementCount
+			}{
+				{"coords", counts.Coords},
+				{"nodes", counts.Nodes},
+				{"ways", counts.Ways},
+				{"relations", counts.Relations},
+			} {
+				if e.count.Current == 0 {
+					continue
+				}
+				er := ElementReport{Type: e.typ, Count: e.count.Current, Seconds: e.count.Duration.Seconds()}
+				if er.Seconds > 0 {
+					er.PerSecond = float64(er.Count) / er.Seconds
+				}
+				p.Elements = append(p.Elements, er)
+			}
+		}
+		r.mu.Lock()
+		r.Phases = append(r.Phases, p)
+		r.mu.Unlock()
+	}
+}
+
+// Print logs the duration of all phases and the throughput of all elements.
+func (r *Report) Print() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.Phases) == 0 {
+		return
+	}
+	var total float64
+	for _, p := range r.Phases {
+		log.Printf("[report] %-12s %10s", p.Name, roundSeconds(p.Seconds))
+		for _, e := range p.Elements {
+			log.Printf("[report]   %-10s %10s %12d %10d/s", e.Type, roundSeconds(e.Seconds), e.Count, int64(e.PerSecond))
+		}
+		total += p.Seconds
+	}
+	log.Printf("[report] %-12s %10s", "total", roundSeconds(total))
+}
+
+// WriteJSON writes the report as JSON into filename.
+func (r *Report) WriteJSON(filename string) error {
+	r.mu.Lock()
+	b, err := json.MarshalIndent(r, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(ioutil.WriteFile(filename, b, 0644), "writing report")
+}
+
+// roundSeconds rounds durations to seconds, short durations to 100ms.
+func roundSeconds(s float64) time.Duration {
+	d := time.Duration(s * float64(time.Second))
+	if d < time.Minute {
+		return d.Round(100 * time.Millisecond)
+	}
+	return d.Round(time.Second)
+}
@@ -0,0 +1,72 @@
+package stats
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	r := NewReport()
+	finished := r.Phase("read")
+	finished(&ElementCounts{
+		Coords: ElementCount{Current: 1000, Duration: 2 * time.Second},
+		Ways:   ElementCount{Current: 10},
+	})
+	r.Phase("index")(nil)
+
+	if len(r.Phases) != 2 || r.Phases[0].Name != "read" || r.Phases[1].Name != "index" {
+		t.Fatalf("unexpected phases %v", r.Phases)
+	}
+	elems := r.Phases[0].Elements
+	if len(elems) != 2 {
+		t.Fatalf("unexpected elements %v", elems)
+	}
+	if elems[0] != (ElementReport{Type: "coords", Count: 1000, Seconds: 2, PerSecond: 500}) {
+		t.Errorf("unexpected coords %v", elems[0])
+	}
+	if elems[1] != (ElementReport{Type: "ways", Count: 10}) {
+		t.Errorf("unexpected ways %v", elems[1])
+	}
+	if r.Phases[1].Elements != nil {
+		t.Errorf("unexpected elements for index %v", r.Phases[1].Elements)
+	}
+
+	dir, err := ioutil.TempDir("", "imposm_report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "report.json")
+	if err := r.WriteJSON(filename); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Phases []struct {
+			Name     string
+			Elements []map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Phases) != 2 || decoded.Phases[0].Elements[0]["per_second"] != 500.0 {
+		t.Errorf("unexpected JSON %s", b)
+	}
+}
+
+func TestRoundSeconds(t *testing.T) {
+	if d := roundSeconds(1.2345); d != 1200*time.Millisecond {
+		t.Error(d)
+	}
+	if d := roundSeconds(123.45); d != 123*time.Second {
+		t.Error(d)
+	}
+}
@@ -78,6 +78,7 @@ func (s *Statistics) AddWays(n int)      { s.counter.Ways.Add(n) }
 func (s *Statistics) AddRelations(n int) { s.counter.Relations.Add(n) }
 func (s *Statistics) Stop() *ElementCounts {
 	s.done <- true
+	clearCurrentCounter(s.counter)
 	return s.counter.CurrentCount()
 }
 
@@ -85,6 +86,7 @@ func NewStatsReporter() *Statistics {
 	s := Statistics{}
 	s.counter = NewCounter()
 	s.done = make(chan bool)
+	setCurrentCounter(s.counter)
 
 	go s.loop()
 	return &s
@@ -98,6 +100,7 @@ func NewStatsReporterWithEstimate(counts *ElementCounts) *Statistics {
 		s.counter = NewCounter()
 	}
 	s.done = make(chan bool)
+	setCurrentCounter(s.counter)
 
 	go s.loop()
 	return &s
@@ -109,6 +112,7 @@ func (s *Statistics) loop() {
 		select {
 		case <-s.done:
 			tock.Stop()
+			s.counter.Tick()
 			s.counter.PrintStats()
 			return
 		case <-tock.C:
@@ -0,0 +1,45 @@
+package stats
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// status is the JSON response of /status.
+type status struct {
+	Sequence   *int       `json:"sequence"`
+	Timestamp  *time.Time `json:"timestamp"`
+	LagSeconds *float64   `json:"lag_seconds"`
+	// LastError is the error of the last failed diff import or download,
+	// empty after the next successful import.
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+}
+
+// SetDiffError sets the error of a failed diff import. It is reported till
+// the next SetDiffState.
+func SetDiffError(err error) {
+	diffState.Lock()
+	diffState.err = err.Error()
+	diffState.errTime = time.Now()
+	diffState.Unlock()
+}
+
+// writeStatus writes the replication status as JSON. The sequence,
+// timestamp and lag are null till the first diff state is set.
+func writeStatus(w io.Writer, now time.Time) error {
+	s := status{}
+	diffState.Lock()
+	if !diffState.time.IsZero() {
+		seq, t := diffState.sequence, diffState.time
+		lag := now.Sub(t).Seconds()
+		s.Sequence, s.Timestamp, s.LagSeconds = &seq, &t, &lag
+	}
+	if diffState.err != "" {
+		errTime := diffState.errTime
+		s.LastError, s.LastErrorTime = diffState.err, &errTime
+	}
+	diffState.Unlock()
+	return json.NewEncoder(w).Encode(s)
+}
@@ -0,0 +1,47 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWriteStatus(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	SetDiffState(4231, now.Add(-90*time.Second))
+	SetDiffError(errors.New("importing #4232: connection refused"))
+
+	var s status
+	buf := bytes.Buffer{}
+	if err := writeStatus(&buf, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &s); err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if s.Sequence == nil || *s.Sequence != 4231 || s.LagSeconds == nil || *s.LagSeconds != 90 {
+		t.Errorf("unexpected status %s", buf.String())
+	}
+	if !s.Timestamp.Equal(now.Add(-90 * time.Second)) {
+		t.Errorf("unexpected timestamp %s", buf.String())
+	}
+	if s.LastError != "importing #4232: connection refused" || s.LastErrorTime == nil {
+		t.Errorf("unexpected error %s", buf.String())
+	}
+
+	// errors are reset by the next import
+	SetDiffState(4232, now.Add(-30*time.Second))
+	buf.Reset()
+	if err := writeStatus(&buf, now); err != nil {
+		t.Fatal(err)
+	}
+	s = status{}
+	if err := json.Unmarshal(buf.Bytes(), &s); err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if s.LastError != "" || *s.Sequence != 4232 {
+		t.Errorf("unexpected status %s", buf.String())
+	}
+}
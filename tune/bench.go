@@ -0,0 +1,70 @@
+package tune
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// benchmark are the results of the disk benchmark.
+type benchmark struct {
+	// WriteMBs is the throughput of sequential writes in MB/s.
+	WriteMBs float64
+	// SyncLatency is the average duration of a small write with fsync.
+	SyncLatency time.Duration
+}
+
+const (
+	benchBlockSize = 1024 * 1024
+	syncWrites     = 50
+)
+
+// benchDisk writes a file of sizeMB into dir and measures the write
+// throughput and the latency of fsync. The latency is a better indicator
+// for the disk type than the throughput, as the writes go into the page
+// cache.
+func benchDisk(dir string, sizeMB int) (benchmark, error) {
+	var result benchmark
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return result, errors.Wrap(err, "creating cache directory")
+	}
+	f, err := ioutil.TempFile(dir, "imposm-tune-")
+	if err != nil {
+		return result, errors.Wrap(err, "creating benchmark file")
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	block := make([]byte, benchBlockSize)
+	for i := range block {
+		block[i] = byte(i)
+	}
+	start := time.Now()
+	for i := 0; i < sizeMB; i++ {
+		if _, err := f.Write(block); err != nil {
+			return result, errors.Wrap(err, "writing benchmark file")
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return result, errors.Wrap(err, "writing benchmark file")
+	}
+	result.WriteMBs = float64(sizeMB) / time.Since(start).Seconds()
+
+	start = time.Now()
+	for i := 0; i < syncWrites; i++ {
+		if _, err := f.WriteAt(block[:4096], int64(i)*4096); err != nil {
+			return result, errors.Wrap(err, "writing benchmark file")
+		}
+		if err := f.Sync(); err != nil {
+			return result, errors.Wrap(err, "writing benchmark file")
+		}
+	}
+	result.SyncLatency = time.Since(start) / syncWrites
+	return result, nil
+}
+
+// slowSync is the fsync latency above which the disk is handled like a
+// rotational disk.
+const slowSync = 4 * time.Millisecond
@@ -0,0 +1,5 @@
+/*
+Package tune provides the tune sub command, that recommends settings for
+the config file based on the memory, CPUs and disk of the system.
+*/
+package tune
@@ -0,0 +1,96 @@
+package tune
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// system describes the hardware that is relevant for the settings.
+type system struct {
+	// MemoryMB is the total memory, 0 if unknown.
+	MemoryMB int
+	CPUs     int
+	// Disk is the type of the disk of the cache directory.
+	Disk diskType
+}
+
+type diskType string
+
+const (
+	diskUnknown diskType = "unknown"
+	diskSSD     diskType = "ssd"
+	diskHDD     diskType = "hdd"
+)
+
+func inspectSystem(cacheDir string) system {
+	sys := system{CPUs: runtime.NumCPU(), Disk: diskUnknown}
+	if b, err := ioutil.ReadFile("/proc/meminfo"); err == nil {
+		sys.MemoryMB = parseMeminfo(b)
+	}
+	if rotational, err := isRotational(cacheDir); err == nil {
+		if rotational {
+			sys.Disk = diskHDD
+		} else {
+			sys.Disk = diskSSD
+		}
+	}
+	return sys
+}
+
+// parseMeminfo returns MemTotal of /proc/meminfo in MB.
+func parseMeminfo(b []byte) int {
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return 0
+			}
+			return kb / 1024
+		}
+	}
+	return 0
+}
+
+// isRotational returns whether the block device of dir is a rotational
+// disk. dir or its first existing parent is used. Only supported on Linux.
+func isRotational(dir string) (bool, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false, err
+	}
+	var st syscall.Stat_t
+	for {
+		err = syscall.Stat(dir, &st)
+		if !os.IsNotExist(err) || dir == filepath.Dir(dir) {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	if err != nil {
+		return false, err
+	}
+	dev := uint64(st.Dev)
+	major, minor := (dev>>8)&0xfff|(dev>>32)&^0xfff, dev&0xff|(dev>>12)&^0xff
+	// /sys/dev/block/X:Y links to the device or to a partition of the device
+	devDir, err := filepath.EvalSymlinks(fmt.Sprintf("/sys/dev/block/%d:%d", major, minor))
+	if err != nil {
+		return false, err
+	}
+	for _, d := range []string{devDir, filepath.Dir(devDir)} {
+		b, err := ioutil.ReadFile(filepath.Join(d, "queue", "rotational"))
+		if err == nil {
+			return strings.TrimSpace(string(b)) == "1", nil
+		}
+	}
+	return false, fmt.Errorf("no rotational flag for %s", devDir)
+}
@@ -0,0 +1,178 @@
+package tune
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/omniscale/imposm3/cache"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/memory"
+	"github.com/pkg/errors"
+)
+
+const defaultCacheDir = "/tmp/imposm3"
+
+var flags = flag.NewFlagSet("tune", flag.ExitOnError)
+
+var (
+	cacheDir   = flags.String("cachedir", defaultCacheDir, "cache directory, the disk of this directory is tested")
+	configFile = flags.String("config", "", "existing config (json), the recommended settings are merged into this config")
+	output     = flags.String("o", "", "write the config to this file instead of stdout")
+	benchSize  = flags.Int("bench-size", 256, "size of the disk benchmark in MB")
+	noBench    = flags.Bool("no-bench", false, "skip the disk benchmark")
+)
+
+// settings are the recommended settings of the config file.
+type settings struct {
+	CacheDir  string         `json:"cachedir"`
+	MaxMemory int            `json:"max_memory"`
+	IndexJobs int            `json:"index_jobs"`
+	Buffers   memory.Buffers `json:"buffers"`
+	Cache     cache.Sizes    `json:"cache"`
+}
+
+// Memory sizes in MB for the recommendations. Systems with less than
+// smallMB are limited with max_memory, the defaults are made for
+// smallMB.
+const (
+	smallMB = 16 * 1024
+	largeMB = 32 * 1024
+)
+
+// recommend returns the settings for the system. bench is nil if the
+// benchmark was skipped.
+func recommend(sys system, bench *benchmark, cacheDir string) settings {
+	s := settings{CacheDir: cacheDir, IndexJobs: sys.CPUs}
+
+	slowDisk := sys.Disk == diskHDD
+	if bench != nil && bench.SyncLatency > slowSync {
+		// network storage or a busy disk
+		slowDisk = true
+	}
+	if slowDisk {
+		// parallel index builds compete for the disk
+		s.IndexJobs = 2
+		if sys.CPUs < 2 {
+			s.IndexJobs = 1
+		}
+	}
+
+	mb := sys.MemoryMB
+	switch {
+	case mb <= 0:
+		// unknown memory, keep the defaults
+	case mb < smallMB:
+		// leave memory for the OS and the page cache, the budget reduces
+		// all buffers, caches and workers
+		s.MaxMemory = mb * 3 / 4
+	case mb < largeMB:
+		s.Buffers = memory.Buffers{Read: max(4, sys.CPUs/2), Cache: 2048, Database: 128}
+		s.Cache = cache.Sizes{LRUMB: 64, CoordsBunches: 16384}
+	default:
+		s.Buffers = memory.Buffers{Read: max(4, sys.CPUs), Cache: 4096, Database: 256}
+		s.Cache = cache.Sizes{LRUMB: 128, CoordsBunches: 65536}
+	}
+	if slowDisk && mb >= smallMB {
+		// larger write buffers result in fewer compactions
+		s.Cache.WriteBufferMB = 128
+	}
+	return s
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// mergeConfig returns the JSON of the config with all recommended
+// settings. Other settings of the config are kept.
+func mergeConfig(config []byte, s settings) ([]byte, error) {
+	conf := map[string]json.RawMessage{}
+	if len(config) > 0 {
+		if err := json.Unmarshal(config, &conf); err != nil {
+			return nil, errors.Wrap(err, "parsing config")
+		}
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	recommended := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &recommended); err != nil {
+		return nil, err
+	}
+	for k, v := range recommended {
+		conf[k] = v
+	}
+	if s.MaxMemory == 0 {
+		delete(conf, "max_memory")
+	}
+	b, err = json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// Tune inspects the system, runs a short disk benchmark and writes a config
+// with the recommended settings.
+func Tune(args []string) {
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [args]\n\n", os.Args[0], os.Args[1])
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+
+	var config []byte
+	if *configFile != "" {
+		var err error
+		config, err = ioutil.ReadFile(*configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *cacheDir == defaultCacheDir {
+			var conf struct {
+				CacheDir string `json:"cachedir"`
+			}
+			if err := json.Unmarshal(config, &conf); err == nil && conf.CacheDir != "" {
+				*cacheDir = conf.CacheDir
+			}
+		}
+	}
+
+	sys := inspectSystem(*cacheDir)
+	log.Printf("[info] %d CPUs, %dMB memory, %s disk for %s", sys.CPUs, sys.MemoryMB, sys.Disk, *cacheDir)
+
+	var bench *benchmark
+	if !*noBench {
+		step := log.Step(fmt.Sprintf("Benchmarking disk with %dMB", *benchSize))
+		b, err := benchDisk(*cacheDir, *benchSize)
+		step()
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("[info] %.0fMB/s write, %v fsync latency", b.WriteMBs, b.SyncLatency)
+		bench = &b
+	}
+
+	b, err := mergeConfig(config, recommend(sys, bench, *cacheDir))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *output == "" {
+		os.Stdout.Write(b)
+		return
+	}
+	if err := ioutil.WriteFile(*output, b, 0644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("[info] Wrote config to %s", *output)
+}
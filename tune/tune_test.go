@@ -0,0 +1,111 @@
+package tune
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/omniscale/imposm3/cache"
+	"github.com/omniscale/imposm3/memory"
+)
+
+func TestParseMeminfo(t *testing.T) {
+	b := []byte("MemTotal:       16318412 kB\nMemFree:         1102244 kB\n")
+	if mb := parseMeminfo(b); mb != 15935 {
+		t.Error("unexpected memory", mb)
+	}
+	if mb := parseMeminfo([]byte("MemFree: 1 kB\n")); mb != 0 {
+		t.Error("unexpected memory", mb)
+	}
+}
+
+func TestRecommend(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		sys      system
+		bench    *benchmark
+		expected settings
+	}{
+		{
+			name:     "small",
+			sys:      system{MemoryMB: 4096, CPUs: 2, Disk: diskSSD},
+			expected: settings{CacheDir: "/cache", MaxMemory: 3072, IndexJobs: 2},
+		},
+		{
+			name: "large",
+			sys:  system{MemoryMB: 64 * 1024, CPUs: 16, Disk: diskSSD},
+			expected: settings{
+				CacheDir:  "/cache",
+				IndexJobs: 16,
+				Buffers:   memory.Buffers{Read: 16, Cache: 4096, Database: 256},
+				Cache:     cache.Sizes{LRUMB: 128, CoordsBunches: 65536},
+			},
+		},
+		{
+			name:  "slow disk",
+			sys:   system{MemoryMB: 16 * 1024, CPUs: 8, Disk: diskUnknown},
+			bench: &benchmark{WriteMBs: 100, SyncLatency: 10 * time.Millisecond},
+			expected: settings{
+				CacheDir:  "/cache",
+				IndexJobs: 2,
+				Buffers:   memory.Buffers{Read: 4, Cache: 2048, Database: 128},
+				Cache:     cache.Sizes{LRUMB: 64, WriteBufferMB: 128, CoordsBunches: 16384},
+			},
+		},
+		{
+			name:     "unknown memory",
+			sys:      system{CPUs: 4, Disk: diskHDD},
+			expected: settings{CacheDir: "/cache", IndexJobs: 2},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if s := recommend(tc.sys, tc.bench, "/cache"); s != tc.expected {
+				t.Errorf("unexpected settings %+v", s)
+			}
+		})
+	}
+}
+
+func TestMergeConfig(t *testing.T) {
+	config := []byte(`{"cachedir": "/old", "mapping": "mapping.yml", "max_memory": 1000}`)
+	b, err := mergeConfig(config, settings{CacheDir: "/new", IndexJobs: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conf map[string]interface{}
+	if err := json.Unmarshal(b, &conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf["cachedir"] != "/new" || conf["mapping"] != "mapping.yml" || conf["index_jobs"] != 4.0 {
+		t.Errorf("unexpected config %s", b)
+	}
+	if _, ok := conf["max_memory"]; ok {
+		t.Errorf("unexpected max_memory in %s", b)
+	}
+
+	if _, err := mergeConfig([]byte("{"), settings{}); err == nil {
+		t.Error("expected error for invalid config")
+	}
+}
+
+func TestBenchDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imposm-tune-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b, err := benchDisk(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.WriteMBs <= 0 || b.SyncLatency <= 0 {
+		t.Errorf("unexpected benchmark %+v", b)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Error("benchmark file not removed")
+	}
+}
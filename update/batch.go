@@ -0,0 +1,126 @@
+package update
+
+import (
+	"context"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/go-osm/parser/diff"
+)
+
+// batchParser parses multiple consecutive change files and passes only the
+// last version of each element to Diffs, nodes before ways before
+// relations. Elements that are changed by multiple files are only imported
+// once.
+type batchParser struct {
+	files []string
+	conf  diff.Config
+}
+
+func (p *batchParser) Parse(ctx context.Context) error {
+	if !p.conf.KeepOpen {
+		defer close(p.conf.Diffs)
+	}
+	merged := newMergedDiffs()
+	for _, f := range p.files {
+		if err := p.parseFile(ctx, f, merged); err != nil {
+			return errors.Wrapf(err, "parsing %s", f)
+		}
+	}
+	for _, elem := range merged.sorted() {
+		select {
+		case p.conf.Diffs <- elem:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (p *batchParser) parseFile(ctx context.Context, oscFile string, merged *mergedDiffs) error {
+	var r io.Reader = os.Stdin
+	if oscFile != "-" {
+		f, err := os.Open(oscFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	diffs := make(chan osm.Diff)
+	parser, err := newDiffParser(r, diff.Config{
+		Diffs:           diffs,
+		IncludeMetadata: p.conf.IncludeMetadata,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	parseError := make(chan error, 1)
+	go func() {
+		parseError <- parser.Parse(ctx)
+	}()
+	for elem := range diffs {
+		merged.add(elem)
+	}
+	return <-parseError
+}
+
+// mergedDiffs keeps the last version of each changed element.
+type mergedDiffs struct {
+	nodes map[int64]osm.Diff
+	ways  map[int64]osm.Diff
+	rels  map[int64]osm.Diff
+}
+
+func newMergedDiffs() *mergedDiffs {
+	return &mergedDiffs{
+		nodes: make(map[int64]osm.Diff),
+		ways:  make(map[int64]osm.Diff),
+		rels:  make(map[int64]osm.Diff),
+	}
+}
+
+// add replaces the previous version of the element. Elements that were
+// created by a previous file stay created.
+func (m *mergedDiffs) add(elem osm.Diff) {
+	var elems map[int64]osm.Diff
+	var id int64
+	switch {
+	case elem.Node != nil:
+		elems, id = m.nodes, elem.Node.ID
+	case elem.Way != nil:
+		elems, id = m.ways, elem.Way.ID
+	case elem.Rel != nil:
+		elems, id = m.rels, elem.Rel.ID
+	default:
+		return
+	}
+	if prev, ok := elems[id]; ok && prev.Create && elem.Modify {
+		elem.Create, elem.Modify = true, false
+	}
+	elems[id] = elem
+}
+
+// sorted returns all elements, nodes before ways before relations, each
+// sorted by ID.
+func (m *mergedDiffs) sorted() []osm.Diff {
+	result := make([]osm.Diff, 0, len(m.nodes)+len(m.ways)+len(m.rels))
+	for _, elems := range []map[int64]osm.Diff{m.nodes, m.ways, m.rels} {
+		ids := make([]int64, 0, len(elems))
+		for id := range elems {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for _, id := range ids {
+			result = append(result, elems[id])
+		}
+	}
+	return result
+}
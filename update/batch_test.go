@@ -0,0 +1,94 @@
+package update
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/go-osm/parser/diff"
+	"github.com/omniscale/go-osm/replication"
+)
+
+func TestBatchParser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imposm3-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := []string{filepath.Join(dir, "1.osc"), filepath.Join(dir, "2.osc")}
+	ioutil.WriteFile(files[0], []byte(`<osmChange version="0.6">
+<create>
+  <node id="2" version="1" lat="53.0" lon="8.0"/>
+  <node id="1" version="1" lat="53.0" lon="8.0"/>
+  <way id="1" version="1"><nd ref="1"/><nd ref="2"/></way>
+</create>
+<modify>
+  <node id="3" version="2" lat="53.0" lon="8.0"/>
+</modify>
+</osmChange>
+`), 0644)
+	ioutil.WriteFile(files[1], []byte(`<osmChange version="0.6">
+<modify>
+  <node id="1" version="2" lat="54.0" lon="9.0"/>
+</modify>
+<delete>
+  <node id="3" version="3" lat="53.0" lon="8.0"/>
+</delete>
+</osmChange>
+`), 0644)
+
+	diffs := make(chan osm.Diff)
+	p := &batchParser{files: files, conf: diff.Config{Diffs: diffs}}
+	parseError := make(chan error, 1)
+	go func() {
+		parseError <- p.Parse(context.Background())
+	}()
+	var result []string
+	for elem := range diffs {
+		result = append(result, diffElemString(elem)+" "+diffAction(elem))
+		if elem.Node != nil && elem.Node.ID == 1 && elem.Node.Lat != 54.0 {
+			t.Errorf("unexpected version of node 1 %v", elem.Node)
+		}
+	}
+	if err := <-parseError; err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"node 1 create", "node 2 create", "node 3 delete", "way 1 create"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("unexpected elements %v", result)
+	}
+}
+
+func diffAction(elem osm.Diff) string {
+	switch {
+	case elem.Create:
+		return "create"
+	case elem.Modify:
+		return "modify"
+	}
+	return "delete"
+}
+
+func TestNextBatch(t *testing.T) {
+	nextSeq := make(chan replication.Sequence, 4)
+	nextSeq <- replication.Sequence{Sequence: 2}
+	nextSeq <- replication.Sequence{Sequence: 3}
+	nextSeq <- replication.Sequence{Sequence: 4}
+
+	batch := nextBatch(replication.Sequence{Sequence: 1}, nextSeq, 3)
+	if len(batch) != 3 || batch[2].Sequence != 3 {
+		t.Errorf("unexpected batch %v", batch)
+	}
+	batch = nextBatch(replication.Sequence{Sequence: 5}, nextSeq, 3)
+	if len(batch) != 2 || batch[1].Sequence != 4 {
+		t.Errorf("unexpected batch %v", batch)
+	}
+	if batch := nextBatch(replication.Sequence{Sequence: 6}, nextSeq, 0); len(batch) != 1 {
+		t.Errorf("unexpected batch %v", batch)
+	}
+}
@@ -0,0 +1,198 @@
+package update
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/go-osm/replication"
+	"github.com/omniscale/go-osm/state"
+	"github.com/omniscale/imposm3/config"
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/reader/changeset"
+)
+
+// LastChangesetStateFilename is the state of the last imported changeset
+// replication file in the diff directory.
+const LastChangesetStateFilename = "last.changesets.state.txt"
+
+// changesetBatchSize is the number of changesets that are inserted in one
+// call.
+const changesetBatchSize = 1000
+
+// newChangesetDownloader starts downloading the changeset replication
+// from url into dest, starting with sequence seq or with the latest
+// sequence if seq is 0.
+func newChangesetDownloader(dest, url string, seq int) *downloader {
+	d := baseDownloader(dest, url, seq, time.Minute)
+	d.fileExt = ".osm.gz"
+	d.stateExt = ""
+	d.serverState = "state.yaml"
+	d.parseState = parseChangesetState
+	go d.run()
+	return d
+}
+
+// parseChangesetState parses the state.yaml of the changeset replication.
+func parseChangesetState(r io.Reader) (*state.DiffState, error) {
+	s := &state.DiffState{}
+	found := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case "sequence":
+			seq, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parsing sequence %q", value)
+			}
+			s.Sequence = seq
+			found = true
+		case "last_run":
+			t, err := time.Parse("2006-01-02 15:04:05.999999999 -07:00", value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parsing last_run %q", value)
+			}
+			s.Time = t.UTC()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("missing sequence in changeset state")
+	}
+	return s, nil
+}
+
+// importChangesets inserts all changesets of a compressed changeset file.
+func importChangesets(db database.ChangesetInserter, filename string) (int, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return 0, errors.Wrapf(err, "reading %s", filename)
+	}
+
+	dec := changeset.NewDecoder(r)
+	n := 0
+	batch := make([]osm.Changeset, 0, changesetBatchSize)
+	for {
+		cs, err := dec.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, errors.Wrapf(err, "reading %s", filename)
+		}
+		batch = append(batch, *cs)
+		if len(batch) == changesetBatchSize {
+			if err := db.InsertChangesets(batch); err != nil {
+				return n, err
+			}
+			n += len(batch)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		if err := db.InsertChangesets(batch); err != nil {
+			return n, err
+		}
+		n += len(batch)
+	}
+	return n, nil
+}
+
+// startChangesets starts the import of the changeset replication into the
+// changesets table. It continues after the sequence of
+// LastChangesetStateFilename, or it starts with the latest changesets. The
+// returned downloader needs to be stopped.
+func startChangesets(baseOpts config.Base, db database.ChangesetInserter) (*downloader, error) {
+	stateFile := filepath.Join(baseOpts.DiffDir, LastChangesetStateFilename)
+	seq := 0
+	s, err := state.ParseFile(stateFile)
+	if err == nil {
+		seq = s.Sequence + 1
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "reading %s", stateFile)
+	}
+	log.Printf("[info] Starting changeset replication from %s", baseOpts.ChangesetReplicationURL)
+
+	d := newChangesetDownloader(
+		filepath.Join(baseOpts.DiffDir, "changesets"),
+		baseOpts.ChangesetReplicationURL,
+		seq,
+	)
+
+	go func() {
+		exp := newExpBackoff(2*time.Second, 5*time.Minute)
+		for seq := range d.Sequences() {
+			if seq.Error != nil {
+				log.Printf("[error] Downloading changesets #%d: %s", seq.Sequence, seq.Error)
+				continue
+			}
+			for {
+				n, err := importChangesets(db, seq.Filename)
+				if err == nil {
+					log.Printf("[info] Imported %d changesets of #%d", n, seq.Sequence)
+					exp.Reset()
+					break
+				}
+				log.Printf("[error] Importing changesets #%d: %s", seq.Sequence, err)
+				log.Println("[info] Retrying in", exp.Duration())
+				exp.Wait()
+			}
+			err := state.WriteFile(stateFile, &state.DiffState{
+				Time:     time.Now().UTC(),
+				Sequence: seq.Sequence,
+				URL:      baseOpts.ChangesetReplicationURL,
+			})
+			if err != nil {
+				log.Println("[error] Unable to write last changeset state:", err)
+			}
+		}
+	}()
+	return d, nil
+}
+
+// openChangesets opens the database of the diff imports and starts the
+// changeset replication.
+func openChangesets(baseOpts config.Base) (replication.Source, error) {
+	tagmapping, err := mapping.FromFile(baseOpts.MappingFile)
+	if err != nil {
+		return nil, err
+	}
+	dbConf := database.Config{
+		Srid:             baseOpts.Srid,
+		ImportSchema:     baseOpts.Schemas.Production,
+		ProductionSchema: baseOpts.Schemas.Production,
+		BackupSchema:     baseOpts.Schemas.Backup,
+	}
+	db, err := database.OpenAll(dbConf, baseOpts.Connections, &tagmapping.Conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening database")
+	}
+	csDb, ok := db.(database.ChangesetInserter)
+	if !ok {
+		db.Close()
+		return nil, errors.New("database does not support changesets")
+	}
+	return startChangesets(baseOpts, csDb)
+}
@@ -0,0 +1,71 @@
+package update
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	osm "github.com/omniscale/go-osm"
+)
+
+func TestParseChangesetState(t *testing.T) {
+	s, err := parseChangesetState(strings.NewReader("---\nlast_run: 2020-01-01 10:00:01.123456000 +00:00\nsequence: 3724000\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Sequence != 3724000 || !s.Time.Equal(time.Date(2020, 1, 1, 10, 0, 1, 123456000, time.UTC)) {
+		t.Errorf("unexpected state %#v", s)
+	}
+
+	if _, err := parseChangesetState(strings.NewReader("---\n")); err == nil {
+		t.Error("expected error for missing sequence")
+	}
+}
+
+type changesetRecorder struct {
+	batches [][]osm.Changeset
+}
+
+func (r *changesetRecorder) InsertChangesets(changesets []osm.Changeset) error {
+	r.batches = append(r.batches, append([]osm.Changeset(nil), changesets...))
+	return nil
+}
+
+func TestImportChangesets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imposm3-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "001.osm.gz")
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := gzip.NewWriter(f)
+	fmt.Fprintln(w, `<osm version="0.6">`)
+	for i := 1; i <= changesetBatchSize+1; i++ {
+		fmt.Fprintf(w, `<changeset id="%d" created_at="2020-01-01T10:00:00Z" open="true" user="mapper" uid="1"/>`+"\n", i)
+	}
+	fmt.Fprintln(w, `</osm>`)
+	w.Close()
+	f.Close()
+
+	r := &changesetRecorder{}
+	n, err := importChangesets(r, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != changesetBatchSize+1 || len(r.batches) != 2 || len(r.batches[1]) != 1 {
+		t.Fatalf("unexpected import of %d changesets in %d batches", n, len(r.batches))
+	}
+	if last := r.batches[1][0]; last.ID != changesetBatchSize+1 || last.UserName != "mapper" {
+		t.Errorf("unexpected changeset %#v", last)
+	}
+}
@@ -0,0 +1,132 @@
+package update
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/element"
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+)
+
+// deadLetter is an element that could not be inserted by a diff import.
+type deadLetter struct {
+	Time time.Time `json:"time"`
+	// File is the change file of the element.
+	File string `json:"file"`
+	// Type is node, way or relation.
+	Type string `json:"type"`
+	ID   int64  `json:"id"`
+	// Reason is insert for failed inserts and geometry for elements
+	// with invalid geometries.
+	Reason string   `json:"reason"`
+	Tables []string `json:"tables"`
+	Error  string   `json:"error"`
+}
+
+// deadLetters appends the elements that could not be inserted to a file,
+// one JSON object per line.
+type deadLetters struct {
+	mu    sync.Mutex
+	f     *os.File
+	enc   *json.Encoder
+	file  string
+	count int
+}
+
+func openDeadLetters(path string) (*deadLetters, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &deadLetters{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// setFile sets the change file of the following dead letters.
+func (d *deadLetters) setFile(file string) {
+	d.mu.Lock()
+	d.file = file
+	d.mu.Unlock()
+}
+
+func (d *deadLetters) add(typ string, id int64, reason string, matches []mapping.Match, err error) {
+	l := deadLetter{
+		Time:   time.Now().UTC(),
+		Type:   typ,
+		ID:     id,
+		Reason: reason,
+		Error:  err.Error(),
+	}
+	for _, m := range matches {
+		l.Tables = append(l.Tables, m.Table.Name)
+	}
+	log.Printf("[warn] Skipping %s %d: %s", typ, id, err)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	l.File = d.file
+	d.count++
+	if err := d.enc.Encode(l); err != nil {
+		log.Println("[error] Writing dead letter:", err)
+	}
+}
+
+func (d *deadLetters) Close() error {
+	return d.f.Close()
+}
+
+// deadLetterDB records failed inserts of one element type as dead letters
+// and continues with the next element. Elements with invalid geometries
+// are recorded and passed to the quarantine of db.
+type deadLetterDB struct {
+	forwarder
+	letters *deadLetters
+	// typ is the element type of the writer (node, way or relation).
+	typ           string
+	singleIDSpace bool
+}
+
+// osmID returns the OSM ID of an element from the ID in the database.
+func (d *deadLetterDB) osmID(id int64) int64 {
+	switch {
+	case d.typ == "way" && d.singleIDSpace:
+		return -id
+	case d.typ == "relation" && d.singleIDSpace:
+		return element.RelIDOffset - id
+	case d.typ == "relation":
+		return -id
+	}
+	return id
+}
+
+func (d *deadLetterDB) check(id int64, matches []mapping.Match, err error) error {
+	if err != nil {
+		d.letters.add(d.typ, d.osmID(id), "insert", matches, err)
+	}
+	return nil
+}
+
+func (d *deadLetterDB) InsertPoint(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return d.check(elem.ID, matches, d.db.InsertPoint(elem, geom, matches))
+}
+
+func (d *deadLetterDB) InsertLineString(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return d.check(elem.ID, matches, d.db.InsertLineString(elem, geom, matches))
+}
+
+func (d *deadLetterDB) InsertPolygon(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return d.check(elem.ID, matches, d.db.InsertPolygon(elem, geom, matches))
+}
+
+func (d *deadLetterDB) InsertRelationMember(rel osm.Relation, member osm.Member, geom geom.Geometry, matches []mapping.Match) error {
+	return d.check(rel.ID, matches, d.db.InsertRelationMember(rel, member, geom, matches))
+}
+
+func (d *deadLetterDB) InsertInvalid(elem osm.Element, reason error, wkb []byte, matches []mapping.Match) error {
+	d.letters.add(d.typ, d.osmID(elem.ID), "geometry", matches, reason)
+	return d.forwarder.InsertInvalid(elem, reason, wkb, matches)
+}
@@ -0,0 +1,84 @@
+package update
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/mapping"
+)
+
+// failingDB fails all inserts of the element with the ID fail.
+type failingDB struct {
+	recordingDB
+	fail int64
+}
+
+func (db *failingDB) InsertPolygon(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	if elem.ID == db.fail {
+		return errors.New("duplicate key value")
+	}
+	return db.recordingDB.InsertPolygon(elem, geom, matches)
+}
+
+func TestDeadLetterDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imposm3-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dead.jsonl")
+	letters, err := openDeadLetters(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	letters.setFile("001.osc.gz")
+
+	buildings := []mapping.Match{{Table: mapping.DestTable{Name: "buildings"}}}
+	db := &failingDB{fail: -3}
+	d := &deadLetterDB{forwarder: forwarder{db}, letters: letters, typ: "relation"}
+	if err := d.InsertPolygon(osm.Element{ID: -2}, geom.Geometry{}, buildings); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.InsertPolygon(osm.Element{ID: -3}, geom.Geometry{}, buildings); err != nil {
+		t.Error("failed insert not skipped", err)
+	}
+	d.InsertInvalid(osm.Element{ID: -4}, errors.New("self-intersection"), nil, buildings)
+	letters.Close()
+
+	if len(db.calls) != 1 || letters.count != 2 {
+		t.Errorf("unexpected calls %v and count %d", db.calls, letters.count)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var result []deadLetter
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var l deadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, l)
+	}
+	if len(result) != 2 {
+		t.Fatalf("unexpected dead letters %v", result)
+	}
+	if l := result[0]; l.Type != "relation" || l.ID != 3 || l.Reason != "insert" || l.File != "001.osc.gz" ||
+		l.Tables[0] != "buildings" || l.Error != "duplicate key value" {
+		t.Errorf("unexpected dead letter %v", l)
+	}
+	if l := result[1]; l.ID != 4 || l.Reason != "geometry" {
+		t.Errorf("unexpected dead letter %v", l)
+	}
+}
@@ -0,0 +1,467 @@
+package update
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/omniscale/go-osm/replication"
+	"github.com/omniscale/go-osm/state"
+	"github.com/omniscale/imposm3/config"
+	"github.com/omniscale/imposm3/log"
+)
+
+// parallelDownloads is the number of diffs that are downloaded in parallel
+// while the import is behind the replication server.
+const parallelDownloads = 4
+
+// downloadAhead is the maximum number of diffs that are downloaded before
+// they are imported.
+const downloadAhead = 4 * parallelDownloads
+
+// notAvailable is returned for diffs and states that are not (yet)
+// available on the replication server.
+type notAvailable struct {
+	url string
+}
+
+func (e *notAvailable) Error() string {
+	return "not available: " + e.url
+}
+
+// downloader downloads diff files from a replication server into the diff
+// directory. It downloads multiple diffs in parallel while the import is
+// behind the server. After it caught up, it only checks for a new diff
+// when it is expected, based on the replication interval.
+type downloader struct {
+	baseURL  string
+	dest     string
+	interval time.Duration
+	// next is the next sequence, 0 to start with the latest sequence of
+	// the server.
+	next     int
+	parallel int
+	errWait  time.Duration
+	// fileExt and stateExt are the extensions of the replication files and
+	// their states. stateExt is empty if the server has no state for each
+	// sequence.
+	fileExt  string
+	stateExt string
+	// serverState is the state file with the latest sequence of the server.
+	serverState string
+	parseState  func(io.Reader) (*state.DiffState, error)
+	client      *http.Client
+	sequences   chan replication.Sequence
+	ctx         context.Context
+	cancel      context.CancelFunc
+	// access configures the authentication and the checksums of the
+	// requests.
+	access config.Replication
+	// ahead is the maximum number of diffs that are downloaded before
+	// they are imported.
+	ahead int
+}
+
+var _ replication.Source = &downloader{}
+
+// newDownloader starts downloading diffs from url into diffDir, starting
+// with sequence seq. interval is used if it can't be detected from the
+// state files of the server. It downloads up to ahead diffs before they
+// are imported, at least downloadAhead.
+func newDownloader(diffDir, url string, seq int, interval time.Duration, access config.Replication, ahead int) *downloader {
+	d := baseDownloader(diffDir, url, seq, interval)
+	d.access = access
+	if ahead > d.ahead {
+		d.ahead = ahead
+		d.sequences = make(chan replication.Sequence, ahead)
+	}
+	go d.run()
+	return d
+}
+
+// splitURLAuth removes the user info from rawurl and returns it as the
+// username and password of access, if access has no username. This keeps
+// the password out of the logs and the state files.
+func splitURLAuth(rawurl string, access config.Replication) (string, config.Replication) {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.User == nil {
+		return rawurl, access
+	}
+	if access.Username == "" {
+		access.Username = u.User.Username()
+		access.Password, _ = u.User.Password()
+	}
+	u.User = nil
+	return u.String(), access
+}
+
+// baseDownloader returns a downloader for diffs that is not started.
+func baseDownloader(dest, url string, seq int, interval time.Duration) *downloader {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &downloader{
+		baseURL:     url,
+		dest:        dest,
+		interval:    interval,
+		next:        seq,
+		parallel:    parallelDownloads,
+		errWait:     time.Minute,
+		fileExt:     ".osc.gz",
+		stateExt:    ".state.txt",
+		serverState: "state.txt",
+		parseState:  state.Parse,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				Dial: (&net.Dialer{
+					Timeout:   30 * time.Second,
+					KeepAlive: 30 * time.Second,
+				}).Dial,
+				TLSHandshakeTimeout:   10 * time.Second,
+				ResponseHeaderTimeout: 10 * time.Second,
+				ExpectContinueTimeout: 1 * time.Second,
+			},
+		},
+		sequences: make(chan replication.Sequence, downloadAhead),
+		ahead:     downloadAhead,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+func (d *downloader) Sequences() <-chan replication.Sequence {
+	return d.sequences
+}
+
+func (d *downloader) Stop() {
+	d.cancel()
+}
+
+// notAvailableWait is the wait time before a diff that is not available is
+// requested again.
+func (d *downloader) notAvailableWait() time.Duration {
+	switch {
+	case d.interval >= 24*time.Hour:
+		return 5 * time.Minute
+	case d.interval >= time.Hour:
+		return time.Minute
+	default:
+		return 10 * time.Second
+	}
+}
+
+func (d *downloader) run() {
+	defer close(d.sequences)
+
+	detected := false
+	for d.ctx.Err() == nil {
+		latest, err := d.fetchState(d.baseURL + d.serverState)
+		if d.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			d.send(replication.Sequence{Sequence: d.next, Error: err})
+			d.wait(d.errWait)
+			continue
+		}
+		if !detected && d.stateExt != "" {
+			d.detectInterval(latest)
+			detected = true
+		}
+		if d.next == 0 {
+			d.next = latest.Sequence
+		}
+
+		if latest.Sequence < d.next {
+			// caught up, wait till the next diff is expected (with a small
+			// buffer for time differences between the servers)
+			wait := time.Until(latest.Time.Add(d.interval + 2*time.Second))
+			if wait < d.notAvailableWait() {
+				wait = d.notAvailableWait()
+			}
+			d.wait(wait)
+			continue
+		}
+
+		behind := latest.Sequence - d.next + 1
+		if behind > 1 {
+			log.Printf("[info] Downloading %d diffs till #%d", behind, latest.Sequence)
+		}
+		last := latest.Sequence
+		if last >= d.next+d.ahead {
+			last = d.next + d.ahead - 1
+		}
+		d.downloadRange(d.next, last)
+	}
+}
+
+// detectInterval sets the interval from the time between the latest and
+// the previous state of the server.
+func (d *downloader) detectInterval(latest *state.DiffState) {
+	prev, err := d.fetchState(d.baseURL + seqPath(latest.Sequence-1) + d.stateExt)
+	if err != nil {
+		log.Printf("[warn] Unable to detect replication interval, using %s: %s", d.interval, err)
+		return
+	}
+	var interval time.Duration
+	switch diff := latest.Time.Sub(prev.Time); {
+	case diff >= 12*time.Hour:
+		interval = 24 * time.Hour
+	case diff >= 30*time.Minute:
+		interval = time.Hour
+	default:
+		interval = time.Minute
+	}
+	if interval != d.interval {
+		log.Printf("[info] Using replication interval of %s from %s", interval, d.baseURL)
+	}
+	d.interval = interval
+}
+
+type downloadResult struct {
+	seq replication.Sequence
+	err error
+}
+
+// downloadRange downloads the diffs from first to last in parallel and
+// passes them in order to Sequences. Stops at the first failed download,
+// the next call continues with this diff.
+func (d *downloader) downloadRange(first, last int) {
+	results := make([]chan downloadResult, last-first+1)
+	for i := range results {
+		results[i] = make(chan downloadResult, 1)
+	}
+
+	ctx, cancel := context.WithCancel(d.ctx)
+	defer cancel()
+
+	jobs := make(chan int)
+	for i := 0; i < d.parallel; i++ {
+		go func() {
+			for seq := range jobs {
+				s, err := d.download(seq)
+				results[seq-first] <- downloadResult{s, err}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for seq := first; seq <= last; seq++ {
+			select {
+			case jobs <- seq:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for _, result := range results {
+		var r downloadResult
+		select {
+		case r = <-result:
+		case <-ctx.Done():
+			return
+		}
+		if d.ctx.Err() != nil {
+			return
+		}
+		if r.err != nil {
+			if _, ok := errors.Cause(r.err).(*notAvailable); ok {
+				// state.txt of the server was updated before the diff
+				d.wait(d.notAvailableWait())
+			} else {
+				d.send(replication.Sequence{Sequence: d.next, Error: r.err})
+				d.wait(d.errWait)
+			}
+			return
+		}
+		if !d.send(r.seq) {
+			return
+		}
+		d.next++
+	}
+}
+
+// send passes s to Sequences, returns false if the downloader was stopped.
+func (d *downloader) send(s replication.Sequence) bool {
+	select {
+	case d.sequences <- s:
+		return true
+	case <-d.ctx.Done():
+		return false
+	}
+}
+
+// download downloads the state and the file of seq, if they were not
+// already downloaded.
+func (d *downloader) download(seq int) (replication.Sequence, error) {
+	base := filepath.Join(d.dest, seqPath(seq))
+	s := replication.Sequence{
+		Sequence: seq,
+		Filename: base + d.fileExt,
+	}
+	if d.stateExt != "" {
+		s.StateFilename = base + d.stateExt
+		if err := d.downloadFile(seqPath(seq)+d.stateExt, s.StateFilename, ""); err != nil {
+			return s, err
+		}
+	}
+	if err := d.downloadFile(seqPath(seq)+d.fileExt, s.Filename, d.access.Checksum); err != nil {
+		return s, err
+	}
+	if d.stateExt == "" {
+		return s, nil
+	}
+	st, err := state.ParseFile(s.StateFilename)
+	if err != nil {
+		return s, errors.Wrapf(err, "parsing %s", s.StateFilename)
+	}
+	s.Time = st.Time
+	return s, nil
+}
+
+func (d *downloader) get(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(d.ctx)
+	req.Header.Set("User-Agent", "imposm3")
+	if d.access.Username != "" {
+		req.SetBasicAuth(d.access.Username, d.access.Password)
+	}
+	if d.access.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+d.access.Token)
+	}
+	for k, v := range d.access.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, &notAvailable{url}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.Errorf("requesting %s: %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+func (d *downloader) fetchState(url string) (*state.DiffState, error) {
+	resp, err := d.get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	s, err := d.parseState(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", url)
+	}
+	return s, nil
+}
+
+// downloadFile downloads path into dest. The file is written to a
+// temporary file first, so that dest is always complete. The file is
+// verified with the md5 or sha256 checksum file next to it, if checksum is
+// set.
+func (d *downloader) downloadFile(path, dest, checksum string) error {
+	if _, err := os.Stat(dest); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	resp, err := d.get(d.baseURL + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	tmp := fmt.Sprintf("%s~%d", dest, os.Getpid())
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	var h hash.Hash
+	switch checksum {
+	case "md5":
+		h = md5.New()
+	case "sha256":
+		h = sha256.New()
+	}
+	var w io.Writer = f
+	if h != nil {
+		w = io.MultiWriter(f, h)
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return errors.Wrapf(err, "downloading %s", path)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if h != nil {
+		if err := d.verifyChecksum(path+"."+checksum, h.Sum(nil)); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	return os.Rename(tmp, dest)
+}
+
+// verifyChecksum compares sum with the checksum file at path. The file
+// contains the hex encoded checksum, optionally followed by the file name
+// (output of md5sum or sha256sum).
+func (d *downloader) verifyChecksum(path string, sum []byte) error {
+	resp, err := d.get(d.baseURL + path)
+	if err != nil {
+		if _, ok := err.(*notAvailable); ok {
+			// not a missing diff, do not wait silently for the checksum
+			return errors.Errorf("missing checksum %s", path)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return errors.Wrapf(err, "downloading %s", path)
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 || !strings.EqualFold(fields[0], hex.EncodeToString(sum)) {
+		return errors.Errorf("checksum mismatch for %s", strings.TrimSuffix(path, filepath.Ext(path)))
+	}
+	return nil
+}
+
+func (d *downloader) wait(duration time.Duration) {
+	select {
+	case <-d.ctx.Done():
+	case <-time.After(duration):
+	}
+}
+
+// seqPath returns the path of a sequence on the replication server,
+// N = AAA*1000000 + BBB*1000 + CCC
+func seqPath(seq int) string {
+	return fmt.Sprintf("%03d/%03d/%03d", seq/1000000, seq/1000%1000, seq%1000)
+}
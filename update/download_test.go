@@ -0,0 +1,144 @@
+package update
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/omniscale/imposm3/config"
+)
+
+func TestSeqPath(t *testing.T) {
+	if p := seqPath(3012345); p != "003/012/345" {
+		t.Error("unexpected path", p)
+	}
+}
+
+func TestDownloader(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	stateFile := func(seq int) string {
+		ts := start.Add(time.Duration(seq) * time.Minute).Format("2006-01-02T15\\:04\\:05Z")
+		return fmt.Sprintf("sequenceNumber=%d\ntimestamp=%s\n", seq, ts)
+	}
+	files := map[string]string{"/state.txt": stateFile(5)}
+	for seq := 1; seq <= 5; seq++ {
+		files["/"+seqPath(seq)+".state.txt"] = stateFile(seq)
+		files["/"+seqPath(seq)+".osc.gz"] = fmt.Sprintf("diff %d", seq)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "imposm3-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := newDownloader(dir, srv.URL+"/", 3, time.Hour, config.Replication{}, 0)
+	for seq := 3; seq <= 5; seq++ {
+		s := <-d.Sequences()
+		if s.Error != nil {
+			t.Fatal(s.Error)
+		}
+		if s.Sequence != seq || !s.Time.Equal(start.Add(time.Duration(seq)*time.Minute)) {
+			t.Errorf("unexpected sequence %v, expected %d", s, seq)
+		}
+		content, err := ioutil.ReadFile(s.Filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != fmt.Sprintf("diff %d", seq) {
+			t.Errorf("unexpected content %q in %s", content, s.Filename)
+		}
+		if !strings.HasPrefix(s.StateFilename, dir) {
+			t.Errorf("unexpected state file %s", s.StateFilename)
+		}
+	}
+	if d.interval != time.Minute {
+		t.Errorf("unexpected interval %s", d.interval)
+	}
+
+	d.Stop()
+	select {
+	case s, ok := <-d.Sequences():
+		if ok {
+			t.Errorf("unexpected sequence %v after catching up", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("sequences not closed after Stop")
+	}
+}
+
+func TestDownloaderAccess(t *testing.T) {
+	sum := sha256.Sum256([]byte("diff 1"))
+	files := map[string]string{
+		"/000/000/001.osc.gz":        "diff 1",
+		"/000/000/001.osc.gz.sha256": hex.EncodeToString(sum[:]) + "  001.osc.gz\n",
+		"/000/000/002.osc.gz":        "diff 2",
+		"/000/000/002.osc.gz.sha256": hex.EncodeToString(sum[:]) + "  002.osc.gz\n",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "osm" || pass != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("X-Mirror") != "internal" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "imposm3-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	url, access := splitURLAuth(strings.Replace(srv.URL, "://", "://osm:secret@", 1)+"/", config.Replication{
+		Headers:  map[string]string{"X-Mirror": "internal"},
+		Checksum: "sha256",
+	})
+	if url != srv.URL+"/" {
+		t.Errorf("user info not removed from %s", url)
+	}
+
+	d := baseDownloader(dir, url, 1, time.Minute)
+	d.access = access
+	d.stateExt = ""
+	if _, err := d.download(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.download(2); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := os.Stat(dir + "/000/000/002.osc.gz"); !os.IsNotExist(err) {
+		t.Errorf("diff with invalid checksum not removed: %v", err)
+	}
+
+	d.access.Headers = nil
+	if _, err := d.download(2); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package update
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+)
+
+// checkMapping compares the mapping with the mapping fingerprint of the
+// import. New columns are added by the migration of the database and only
+// logged. All other changes are logged as errors and refused, unless
+// allowChanges is set. The fingerprint is updated with accepted changes,
+// or created for caches of older imports.
+func checkMapping(cacheDir string, tagmapping *mapping.Mapping, allowChanges bool) error {
+	stored, err := mapping.ReadFingerprint(cacheDir)
+	if err != nil {
+		return errors.Wrap(err, "reading mapping fingerprint")
+	}
+	current := tagmapping.Fingerprint()
+	if stored == nil {
+		log.Printf("[info] Storing mapping fingerprint in %s", cacheDir)
+		return errors.Wrap(mapping.WriteFingerprint(cacheDir, current), "writing mapping fingerprint")
+	}
+
+	changes := stored.Changes(current)
+	if len(changes) == 0 {
+		return nil
+	}
+	incompatible := 0
+	for _, c := range changes {
+		if c.Compatible() {
+			log.Printf("[info] Mapping changed since the import: %s", c)
+		} else {
+			incompatible++
+			log.Printf("[error] Mapping changed since the import: %s", c)
+		}
+	}
+	if incompatible > 0 {
+		if !allowChanges {
+			return errors.Errorf("mapping has %d incompatible changes since the import, re-import or use -allow-mapping-changes", incompatible)
+		}
+		log.Printf("[warn] Continuing with %d incompatible mapping changes, existing rows are not updated", incompatible)
+	}
+	return errors.Wrap(mapping.WriteFingerprint(cacheDir, current), "writing mapping fingerprint")
+}
@@ -0,0 +1,52 @@
+package update
+
+import (
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/mapping"
+)
+
+// forwarder passes invalid elements and OSM elements to the Quarantiner
+// and OSMInserter of db, if db implements them. It is embedded by the
+// inserters that wrap the database of diff imports.
+type forwarder struct {
+	db database.Inserter
+}
+
+// StoresOSMElements returns whether db stores OSM elements.
+func (f forwarder) StoresOSMElements() bool {
+	return database.StoresOSMElements(f.db)
+}
+
+// StoresInvalid returns whether db stores invalid elements.
+func (f forwarder) StoresInvalid() bool {
+	return database.StoresInvalid(f.db)
+}
+
+func (f forwarder) InsertInvalid(elem osm.Element, reason error, wkb []byte, matches []mapping.Match) error {
+	if q, ok := f.db.(database.Quarantiner); ok {
+		return q.InsertInvalid(elem, reason, wkb, matches)
+	}
+	return nil
+}
+
+func (f forwarder) InsertNode(n osm.Node) error {
+	if oi, ok := f.db.(database.OSMInserter); ok {
+		return oi.InsertNode(n)
+	}
+	return nil
+}
+
+func (f forwarder) InsertWay(w osm.Way) error {
+	if oi, ok := f.db.(database.OSMInserter); ok {
+		return oi.InsertWay(w)
+	}
+	return nil
+}
+
+func (f forwarder) InsertRelation(r osm.Relation) error {
+	if oi, ok := f.db.(database.OSMInserter); ok {
+		return oi.InsertRelation(r)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package update
+
+import (
+	"errors"
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/mapping"
+)
+
+// quarantineDB records invalid elements and OSM elements.
+type quarantineDB struct {
+	recordingDB
+}
+
+func (db *quarantineDB) InsertInvalid(elem osm.Element, reason error, wkb []byte, matches []mapping.Match) error {
+	return db.record("invalid", elem.ID, matches)
+}
+
+func (db *quarantineDB) InsertNode(n osm.Node) error {
+	db.calls = append(db.calls, "node")
+	return nil
+}
+
+func (db *quarantineDB) InsertWay(w osm.Way) error {
+	db.calls = append(db.calls, "way")
+	return nil
+}
+
+func (db *quarantineDB) InsertRelation(r osm.Relation) error {
+	db.calls = append(db.calls, "relation")
+	return nil
+}
+
+func TestForwarder(t *testing.T) {
+	roads := []mapping.Match{{Table: mapping.DestTable{Name: "roads"}}}
+	reason := errors.New("self-intersection")
+
+	db := &quarantineDB{}
+	f := forwarder{db}
+	for _, err := range []error{
+		f.InsertInvalid(osm.Element{ID: 1}, reason, nil, roads),
+		f.InsertNode(osm.Node{}),
+		f.InsertWay(osm.Way{}),
+		f.InsertRelation(osm.Relation{}),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(db.calls) != 4 || db.calls[0] != "invalid roads 1" || db.calls[3] != "relation" {
+		t.Errorf("unexpected calls %v", db.calls)
+	}
+
+	// databases without quarantine and OSM tables are skipped
+	plain := &recordingDB{}
+	f = forwarder{plain}
+	if err := f.InsertInvalid(osm.Element{ID: 1}, reason, nil, roads); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.InsertNode(osm.Node{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(plain.calls) != 0 {
+		t.Errorf("unexpected calls %v", plain.calls)
+	}
+
+	// way writers only fill coords of reused geometries for databases that
+	// store OSM elements
+	for _, tc := range []struct {
+		db     database.Deleter
+		stores bool
+	}{
+		{&recordingDB{}, false},
+		{&quarantineDB{}, true},
+	} {
+		for _, wrapped := range []database.Inserter{
+			&countingDB{forwarder: forwarder{tc.db}, db: tc.db},
+			&deadLetterDB{forwarder: forwarder{tc.db}},
+			newUnchangedFilter(tc.db, nil, nil),
+		} {
+			if database.StoresOSMElements(wrapped) != tc.stores || database.StoresInvalid(wrapped) != tc.stores {
+				t.Errorf("unexpected capabilities of %T for %T", wrapped, tc.db)
+			}
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package update
+
+import (
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/cache"
+	"github.com/omniscale/imposm3/config"
+)
+
+// openGeometries opens the stored geometries for -reuse-geometries.
+// Otherwise it removes them, as they are outdated after updates without
+// them.
+func openGeometries(baseOpts config.Base, diffCache *cache.DiffCache) error {
+	if baseOpts.ReuseGeometries {
+		return diffCache.OpenGeometries()
+	}
+	return diffCache.RemoveGeometries()
+}
+
+// geometryChanges collects the ways and relations of a diff whose
+// geometry is unchanged, because only their tags were modified. Ways are
+// unchanged if their refs are equal and if none of their nodes changed.
+// Relations are unchanged if their members are equal and if none of their
+// member ways and nodes changed.
+type geometryChanges struct {
+	store *cache.Geometries
+	// ways and rels are the IDs of the unchanged geometries
+	ways        map[int64]struct{}
+	rels        map[int64]struct{}
+	wayRefs     map[int64][]int64
+	relMembers  map[int64][]osm.Member
+	changedWays map[int64]struct{}
+	changedRels map[int64]struct{}
+}
+
+func newGeometryChanges(store *cache.Geometries) *geometryChanges {
+	return &geometryChanges{
+		store:       store,
+		ways:        make(map[int64]struct{}),
+		rels:        make(map[int64]struct{}),
+		wayRefs:     make(map[int64][]int64),
+		relMembers:  make(map[int64][]osm.Member),
+		changedWays: make(map[int64]struct{}),
+		changedRels: make(map[int64]struct{}),
+	}
+}
+
+// add checks whether the geometry of the changed element is unchanged.
+// It needs to be called before the element is updated in the cache.
+// Stored geometries of deleted elements are removed.
+func (c *geometryChanges) add(elem osm.Diff, osmCache *cache.OSMCache) error {
+	if c == nil {
+		return nil
+	}
+	if elem.Way != nil {
+		id := elem.Way.ID
+		if elem.Delete {
+			c.wayChanged(id)
+			if err := c.store.Delete(cache.LineStringGeometry, id); err != nil {
+				return err
+			}
+			return c.store.Delete(cache.PolygonGeometry, id)
+		}
+		if _, ok := c.changedWays[id]; ok || !elem.Modify {
+			c.wayChanged(id)
+			return nil
+		}
+		old, err := osmCache.Ways.GetWay(id)
+		if err != nil {
+			if err == cache.NotFound {
+				c.wayChanged(id)
+				return nil
+			}
+			return err
+		}
+		if refsEqual(old.Refs, elem.Way.Refs) {
+			c.ways[id] = struct{}{}
+			c.wayRefs[id] = elem.Way.Refs
+		} else {
+			c.wayChanged(id)
+		}
+	} else if elem.Rel != nil {
+		id := elem.Rel.ID
+		if elem.Delete {
+			c.relChanged(id)
+			return c.store.Delete(cache.MultiPolygonGeometry, id)
+		}
+		if _, ok := c.changedRels[id]; ok || !elem.Modify {
+			c.relChanged(id)
+			return nil
+		}
+		old, err := osmCache.Relations.GetRelation(id)
+		if err != nil {
+			if err == cache.NotFound {
+				c.relChanged(id)
+				return nil
+			}
+			return err
+		}
+		if membersEqual(old.Members, elem.Rel.Members) {
+			c.rels[id] = struct{}{}
+			c.relMembers[id] = elem.Rel.Members
+		} else {
+			c.relChanged(id)
+		}
+	}
+	return nil
+}
+
+func (c *geometryChanges) wayChanged(id int64) {
+	delete(c.ways, id)
+	delete(c.wayRefs, id)
+	c.changedWays[id] = struct{}{}
+}
+
+func (c *geometryChanges) relChanged(id int64) {
+	delete(c.rels, id)
+	delete(c.relMembers, id)
+	c.changedRels[id] = struct{}{}
+}
+
+// checkMembers marks ways and relations as changed if any of their nodes
+// or member ways are changed. nodeIDs and wayIDs are the changed nodes and
+// the ways that are inserted again. The diff cache can not be used, as the
+// references of modified elements are already removed.
+func (c *geometryChanges) checkMembers(nodeIDs, wayIDs map[int64]struct{}) {
+	if c == nil {
+		return
+	}
+	for id, refs := range c.wayRefs {
+		for _, ref := range refs {
+			if _, ok := nodeIDs[ref]; ok {
+				c.wayChanged(id)
+				break
+			}
+		}
+	}
+	for id, members := range c.relMembers {
+		for _, m := range members {
+			changed := false
+			switch m.Type {
+			case osm.NodeMember:
+				_, changed = nodeIDs[m.ID]
+			case osm.WayMember:
+				_, inserted := wayIDs[m.ID]
+				_, unchanged := c.ways[m.ID]
+				_, modified := c.changedWays[m.ID]
+				changed = (inserted && !unchanged) || modified
+			}
+			if changed {
+				c.relChanged(id)
+				break
+			}
+		}
+	}
+}
+
+func refsEqual(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func membersEqual(a, b []osm.Member) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID || a[i].Type != b[i].Type || a[i].Role != b[i].Role {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+package update
+
+import (
+	"reflect"
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+)
+
+func TestGeometryChangesCheckMembers(t *testing.T) {
+	c := newGeometryChanges(nil)
+	// tag-only changes
+	c.ways[1], c.wayRefs[1] = struct{}{}, []int64{10, 11}
+	c.ways[2], c.wayRefs[2] = struct{}{}, []int64{11, 12}
+	c.rels[1], c.relMembers[1] = struct{}{}, []osm.Member{{ID: 1, Type: osm.WayMember}}
+	c.rels[2], c.relMembers[2] = struct{}{}, []osm.Member{{ID: 2, Type: osm.WayMember}}
+	c.rels[3], c.relMembers[3] = struct{}{}, []osm.Member{{ID: 3, Type: osm.WayMember}}
+	c.rels[4], c.relMembers[4] = struct{}{}, []osm.Member{{ID: 4, Type: osm.WayMember}}
+	c.rels[5], c.relMembers[5] = struct{}{}, []osm.Member{{ID: 13, Type: osm.NodeMember}}
+	// way 4 has new refs
+	c.wayChanged(4)
+
+	nodeIDs := map[int64]struct{}{12: {}, 13: {}}
+	// way 3 depends on node 12
+	wayIDs := map[int64]struct{}{1: {}, 2: {}, 3: {}, 4: {}}
+	c.checkMembers(nodeIDs, wayIDs)
+
+	if expected := map[int64]struct{}{1: {}}; !reflect.DeepEqual(c.ways, expected) {
+		t.Errorf("unexpected unchanged ways %v", c.ways)
+	}
+	if expected := map[int64]struct{}{1: {}}; !reflect.DeepEqual(c.rels, expected) {
+		t.Errorf("unexpected unchanged relations %v", c.rels)
+	}
+}
+
+func TestMembersEqual(t *testing.T) {
+	a := []osm.Member{{ID: 1, Type: osm.WayMember, Role: "outer"}, {ID: 2, Type: osm.WayMember, Role: "inner"}}
+	b := []osm.Member{{ID: 1, Type: osm.WayMember, Role: "outer"}, {ID: 2, Type: osm.WayMember, Role: "outer"}}
+	if !membersEqual(a, a) {
+		t.Error("members not equal")
+	}
+	if membersEqual(a, b) || membersEqual(a, a[:1]) {
+		t.Error("members equal")
+	}
+}
@@ -0,0 +1,79 @@
+package update
+
+import (
+	"fmt"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/cache"
+	"github.com/omniscale/imposm3/geom/geos"
+)
+
+// outsideLimitTo returns true if the element of the diff is entirely
+// outside of the limitto geometry and does not affect any cached
+// element. These elements are skipped without any further cache or
+// database updates.
+//
+// Elements that are cached are never skipped, as they need to be
+// removed if they move out of the limitto geometry. Ways and relations
+// that move into the limitto geometry are not skipped, as their first
+// node or member is cached.
+func (imp *diffImport) outsideLimitTo(g *geos.Geos, elem osm.Diff) (bool, error) {
+	if imp.geometryLimiter == nil {
+		return false, nil
+	}
+	if elem.Node != nil {
+		if !elem.Delete && imp.geometryLimiter.IntersectsBuffer(g, elem.Node.Long, elem.Node.Lat) {
+			return false, nil
+		}
+		if _, err := imp.osmCache.Coords.GetCoord(elem.Node.ID); err != cache.NotFound {
+			return false, err
+		}
+		// ways and relations can reference nodes outside the buffer
+		if len(imp.diffCache.Coords.Get(elem.Node.ID)) > 0 ||
+			len(imp.diffCache.CoordsRel.Get(elem.Node.ID)) > 0 {
+			return false, nil
+		}
+		return true, nil
+	}
+	if elem.Way != nil {
+		if _, err := imp.osmCache.Ways.GetWay(elem.Way.ID); err != cache.NotFound {
+			return false, err
+		}
+		if !elem.Delete {
+			cached, err := imp.osmCache.Coords.FirstRefIsCached(elem.Way.Refs)
+			if err != nil || cached {
+				return false, err
+			}
+		}
+		if len(imp.diffCache.Ways.Get(elem.Way.ID)) > 0 {
+			return false, nil
+		}
+		return true, nil
+	}
+	if elem.Rel != nil {
+		if _, err := imp.osmCache.Relations.GetRelation(elem.Rel.ID); err != cache.NotFound {
+			return false, err
+		}
+		if !elem.Delete {
+			cached, err := imp.osmCache.FirstMemberIsCached(elem.Rel.Members)
+			if err != nil || cached {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// diffElemString returns the type and ID of the element, e.g. "way 42".
+func diffElemString(elem osm.Diff) string {
+	switch {
+	case elem.Node != nil:
+		return fmt.Sprintf("node %d", elem.Node.ID)
+	case elem.Way != nil:
+		return fmt.Sprintf("way %d", elem.Way.ID)
+	case elem.Rel != nil:
+		return fmt.Sprintf("relation %d", elem.Rel.ID)
+	}
+	return "element"
+}
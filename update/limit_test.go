@@ -0,0 +1,30 @@
+package update
+
+import (
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+)
+
+func TestDiffElemString(t *testing.T) {
+	for _, tc := range []struct {
+		elem     osm.Diff
+		expected string
+	}{
+		{osm.Diff{Node: &osm.Node{Element: osm.Element{ID: 1}}}, "node 1"},
+		{osm.Diff{Way: &osm.Way{Element: osm.Element{ID: 2}}}, "way 2"},
+		{osm.Diff{Rel: &osm.Relation{Element: osm.Element{ID: -3}}}, "relation -3"},
+	} {
+		if s := diffElemString(tc.elem); s != tc.expected {
+			t.Errorf("unexpected %q, expected %q", s, tc.expected)
+		}
+	}
+}
+
+func TestOutsideLimitToWithoutLimiter(t *testing.T) {
+	imp := &diffImport{}
+	outside, err := imp.outsideLimitTo(nil, osm.Diff{Node: &osm.Node{}})
+	if err != nil || outside {
+		t.Errorf("element skipped without limitto: %v %v", outside, err)
+	}
+}
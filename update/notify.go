@@ -0,0 +1,85 @@
+package update
+
+import (
+	osm "github.com/omniscale/go-osm"
+	diffstate "github.com/omniscale/go-osm/state"
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/expire"
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/notify"
+)
+
+// countingDB counts the inserted and deleted rows of each table for the
+// notify summary.
+type countingDB struct {
+	forwarder
+	db      database.Deleter
+	changes *notify.Changes
+}
+
+func (c *countingDB) count(matches []mapping.Match, f func(string)) {
+	for _, m := range matches {
+		f(m.Table.Name)
+	}
+}
+
+func (c *countingDB) Delete(id int64, matches []mapping.Match) error {
+	c.count(matches, c.changes.Deleted)
+	return c.db.Delete(id, matches)
+}
+
+func (c *countingDB) InsertPoint(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	c.count(matches, c.changes.Inserted)
+	return c.db.InsertPoint(elem, geom, matches)
+}
+
+func (c *countingDB) InsertLineString(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	c.count(matches, c.changes.Inserted)
+	return c.db.InsertLineString(elem, geom, matches)
+}
+
+func (c *countingDB) InsertPolygon(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	c.count(matches, c.changes.Inserted)
+	return c.db.InsertPolygon(elem, geom, matches)
+}
+
+func (c *countingDB) InsertRelationMember(rel osm.Relation, member osm.Member, geom geom.Geometry, matches []mapping.Match) error {
+	c.count(matches, c.changes.Inserted)
+	return c.db.InsertRelationMember(rel, member, geom, matches)
+}
+
+// expireors passes all expired coordinates to each Expireor.
+type expireors []expire.Expireor
+
+func (e expireors) Expire(long, lat float64) {
+	for _, exp := range e {
+		exp.Expire(long, lat)
+	}
+}
+
+func (e expireors) ExpireNodes(nodes []osm.Node, closed bool) {
+	for _, exp := range e {
+		exp.ExpireNodes(nodes, closed)
+	}
+}
+
+// publishSummary publishes the summary of a diff import. Errors are only
+// logged, as the import is already committed.
+func publishSummary(url string, changes *notify.Changes, state *diffstate.DiffState, stats database.DiffStats) {
+	s := changes.Summary()
+	if state != nil {
+		s.Sequence = state.Sequence
+		s.Timestamp = &state.Time
+	}
+	s.Created, s.Modified, s.Deleted = stats.Created, stats.Modified, stats.Deleted
+
+	p, err := notify.New(url)
+	if err == nil {
+		err = p.Publish(s)
+	}
+	if err != nil {
+		log.Println("[error] Publishing diff summary:", err)
+	}
+}
@@ -0,0 +1,34 @@
+package update
+
+import (
+	"reflect"
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/notify"
+)
+
+func TestCountingDB(t *testing.T) {
+	roads := []mapping.Match{{Table: mapping.DestTable{Name: "roads"}}}
+	pois := []mapping.Match{{Table: mapping.DestTable{Name: "pois"}}}
+
+	db := &recordingDB{}
+	changes := notify.NewChanges()
+	c := &countingDB{forwarder: forwarder{db}, db: db, changes: changes}
+	c.Delete(1, roads)
+	c.InsertLineString(osm.Element{ID: 1}, geom.Geometry{}, roads)
+	c.Delete(2, pois)
+	c.InsertPoint(osm.Element{ID: 3}, geom.Geometry{}, pois)
+	c.InsertPoint(osm.Element{ID: 4}, geom.Geometry{}, pois)
+
+	expected := []string{"delete roads 1", "insert roads 1", "delete pois 2", "insert pois 3", "insert pois 4"}
+	if !reflect.DeepEqual(db.calls, expected) {
+		t.Errorf("unexpected calls %v", db.calls)
+	}
+	tables := changes.Summary().Tables
+	if tables["roads"] != (notify.TableCounts{Inserts: 1, Deletes: 1}) || tables["pois"] != (notify.TableCounts{Inserts: 2, Deletes: 1}) {
+		t.Errorf("unexpected counts %v", tables)
+	}
+}
@@ -1,11 +1,17 @@
 package update
 
 import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -15,12 +21,18 @@ import (
 	"github.com/omniscale/imposm3/cache"
 	"github.com/omniscale/imposm3/config"
 	"github.com/omniscale/imposm3/database"
+	_ "github.com/omniscale/imposm3/database/elasticsearch"
+	_ "github.com/omniscale/imposm3/database/kafka"
+	_ "github.com/omniscale/imposm3/database/mysql"
 	_ "github.com/omniscale/imposm3/database/postgis"
 	"github.com/omniscale/imposm3/expire"
 	"github.com/omniscale/imposm3/geom/geos"
 	"github.com/omniscale/imposm3/geom/limit"
 	"github.com/omniscale/imposm3/log"
 	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/notify"
+	"github.com/omniscale/imposm3/reader/adiff"
+	"github.com/omniscale/imposm3/reader/o5m"
 	"github.com/omniscale/imposm3/stats"
 	"github.com/omniscale/imposm3/writer"
 )
@@ -58,11 +70,26 @@ func Diff(baseOpts config.Base, files []string) {
 	if err != nil {
 		log.Fatal("[fatal] Opening diff cache:", err)
 	}
+	if err := openRowHashes(baseOpts, diffCache); err != nil {
+		log.Fatal("[fatal] Opening row hashes:", err)
+	}
+	if err := openGeometries(baseOpts, diffCache); err != nil {
+		log.Fatal("[fatal] Opening geometries:", err)
+	}
 
 	var exp expire.Expireor
 
-	if baseOpts.ExpireTilesDir != "" {
+	if baseOpts.ExpireTilesDir != "" || baseOpts.ExpireTilesWebhook.URL != "" {
 		tileexpire := expire.NewTileList(baseOpts.ExpireTilesZoom, baseOpts.ExpireTilesDir)
+		tileexpire.SetMinZoom(baseOpts.ExpireTilesMinZoom)
+		tileexpire.SetBuffer(baseOpts.ExpireTilesBuffer)
+		if err := tileexpire.SetFormats(baseOpts.ExpireTilesFormats); err != nil {
+			log.Fatal("[fatal] Expire tiles:", err)
+		}
+		if baseOpts.ExpireTilesWebhook.URL != "" {
+			tileexpire.SetWebhook(baseOpts.ExpireTilesWebhook)
+		}
+		tileexpire.SetRotation(baseOpts.ExpireTilesRotation)
 		exp = tileexpire
 		defer func() {
 			if err := tileexpire.Flush(); err != nil {
@@ -71,12 +98,21 @@ func Diff(baseOpts config.Base, files []string) {
 		}()
 	}
 
-	for _, oscFile := range files {
-		err := Update(baseOpts, oscFile, geometryLimiter, exp, osmCache, diffCache, baseOpts.ForceDiffImport)
+	if baseOpts.SingleTransaction {
+		err := updateFiles(baseOpts, files, geometryLimiter, exp, osmCache, diffCache, baseOpts.ForceDiffImport, false)
 		if err != nil {
 			osmCache.Close()
 			diffCache.Close()
-			log.Fatalf("[fatal] Unable to process %s: %v", oscFile, err)
+			log.Fatalf("[fatal] Unable to process %s: %v", strings.Join(files, ", "), err)
+		}
+	} else {
+		for _, oscFile := range files {
+			err := Update(baseOpts, oscFile, geometryLimiter, exp, osmCache, diffCache, baseOpts.ForceDiffImport)
+			if err != nil {
+				osmCache.Close()
+				diffCache.Close()
+				log.Fatalf("[fatal] Unable to process %s: %v", oscFile, err)
+			}
 		}
 	}
 	// explicitly Close since os.Exit prevents defers
@@ -84,6 +120,46 @@ func Diff(baseOpts config.Base, files []string) {
 	diffCache.Close()
 }
 
+// diffParser parses change files into the Diffs channel of diff.Config.
+type diffParser interface {
+	Parse(ctx context.Context) error
+}
+
+// oldStateParser is implemented by parsers of diffs that contain the
+// previous state of modified and deleted elements.
+type oldStateParser interface {
+	Old(osm.Diff) (osm.Element, bool)
+}
+
+// newDiffParser returns a parser for .osc, .o5c or augmented diff (.adiff)
+// files, uncompressed or compressed with gzip or bzip2. The format and the
+// compression are detected by the content. The XML declaration of .osc
+// files is optional.
+func newDiffParser(r io.Reader, config diff.Config) (diffParser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(3)
+	if bytes.HasPrefix(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(zr)
+	} else if bytes.HasPrefix(magic, []byte("BZh")) {
+		br = bufio.NewReader(bzip2.NewReader(br))
+	}
+	magic, _ = br.Peek(2)
+	if o5m.HasMagic(magic) {
+		return o5m.NewChangeParser(br, config), nil
+	}
+	// the root element is within the first few lines
+	start, _ := br.Peek(4096)
+	if adiff.IsAugmentedDiff(start) {
+		return adiff.NewParser(br, config), nil
+	}
+	return diff.New(br, config), nil
+}
+
+// Update applies a single change file in its own database transaction.
 func Update(
 	baseOpts config.Base,
 	oscFile string,
@@ -93,63 +169,181 @@ func Update(
 	diffCache *cache.DiffCache,
 	force bool,
 ) error {
-	var state *diffstate.DiffState
-	if strings.HasSuffix(oscFile, ".osc.gz") {
-		var err error
-		stateFile := oscFile[:len(oscFile)-len(".osc.gz")] + ".state.txt"
-		state, err = diffstate.ParseFile(stateFile)
-		if err != nil && !os.IsNotExist(err) {
-			return errors.Wrapf(err, "reading state %s", stateFile)
+	return updateFiles(baseOpts, []string{oscFile}, geometryLimiter, expireor, osmCache, diffCache, force, false)
+}
+
+// changeFileExts are the extensions of change files that are replaced by
+// .state.txt for the state file.
+var changeFileExts = []string{".osc.gz", ".osc.bz2", ".osc", ".o5c.gz", ".o5c.bz2", ".o5c"}
+
+// oscState returns the state of a change file from the .state.txt file
+// next to it (123.state.txt for 123.osc.gz). Returns nil if there is no
+// state file.
+func oscState(oscFile string) (*diffstate.DiffState, error) {
+	stateFile := ""
+	for _, ext := range changeFileExts {
+		if strings.HasSuffix(oscFile, ext) {
+			stateFile = oscFile[:len(oscFile)-len(ext)] + ".state.txt"
+			break
 		}
 	}
-	lastStateFile := filepath.Join(baseOpts.DiffDir, LastStateFilename)
-	lastState, err := diffstate.ParseFile(lastStateFile)
-	if err != nil && !os.IsNotExist(err) {
-		return errors.Wrapf(err, "parsing last state from %s", lastStateFile)
+	if stateFile == "" {
+		return nil, nil
 	}
-
-	if lastState != nil && lastState.Sequence != 0 && state != nil && state.Sequence <= lastState.Sequence {
-		if !force {
-			log.Println("[warn] Skipping ", state, ", already imported")
-			return nil
+	state, err := diffstate.ParseFile(stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
 		}
+		return nil, errors.Wrapf(err, "reading state %s", stateFile)
+	}
+	return state, nil
+}
+
+// openDiffDB opens the production schema of all databases.
+func openDiffDB(baseOpts config.Base, tagmapping *mapping.Mapping) (database.DB, error) {
+	dbConf := database.Config{
+		Srid: baseOpts.Srid,
+		// we apply diff imports on the Production schema
+		ImportSchema:     baseOpts.Schemas.Production,
+		ProductionSchema: baseOpts.Schemas.Production,
+		BackupSchema:     baseOpts.Schemas.Backup,
+		DiffWorkers:      baseOpts.DiffWorkers,
+		// failed inserts are recorded as dead letters
+		Savepoints:  baseOpts.DeadLetters != "",
+		ClipLimitTo: baseOpts.LimitTo != "",
 	}
+	db, err := database.OpenAll(dbConf, baseOpts.Connections, &tagmapping.Conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening database")
+	}
+	return db, nil
+}
 
-	defer log.Step(fmt.Sprintf("Processing %s", oscFile))()
+// readLastState returns the state of the last imported diff. The state is
+// read from the database, if it stores the diff state, and from
+// last.state.txt otherwise or if the database has no state yet. Returns
+// nil if there is no state.
+func readLastState(baseOpts config.Base, db database.DB) (*diffstate.DiffState, error) {
+	if baseOpts.DiffStateInDatabase() {
+		store, ok := database.StateStoreOf(db)
+		if !ok {
+			return nil, errors.New("database does not store diff states")
+		}
+		s, err := store.LastState()
+		if err != nil {
+			return nil, errors.Wrap(err, "reading last state from database")
+		}
+		if s != nil {
+			return s, nil
+		}
+	}
+	lastStateFile := filepath.Join(baseOpts.DiffDir, LastStateFilename)
+	s, err := diffstate.ParseFile(lastStateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "parsing last state from %s", lastStateFile)
+	}
+	return s, nil
+}
 
-	diffs := make(chan osm.Diff)
-	config := diff.Config{
-		Diffs: diffs,
+// pendingFiles returns the change files that are not imported yet, and the
+// state of the last of these files. All files are returned if force is set.
+func pendingFiles(lastState *diffstate.DiffState, oscFiles []string, force bool) ([]string, *diffstate.DiffState, error) {
+	lastSeq := 0
+	if lastState != nil {
+		lastSeq = lastState.Sequence
+	}
+	var files []string
+	var state *diffstate.DiffState
+	for _, oscFile := range oscFiles {
+		fileState, err := oscState(oscFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		if lastSeq != 0 && fileState != nil && fileState.Sequence <= lastSeq && !force {
+			log.Println("[warn] Skipping ", fileState, ", already imported")
+			continue
+		}
+		files = append(files, oscFile)
+		if fileState != nil {
+			state = fileState
+			lastSeq = fileState.Sequence
+		}
 	}
+	return files, state, nil
+}
 
-	f, err := os.Open(oscFile)
+// updateFiles applies all change files in a single database transaction,
+// so that readers never see the changes of only some of the files.
+// Files with a sequence that was already imported are skipped, unless
+// force is set. The files are merged into a single batch if merge is
+// set, otherwise they are applied one after another.
+func updateFiles(
+	baseOpts config.Base,
+	oscFiles []string,
+	geometryLimiter *limit.Limiter,
+	expireor expire.Expireor,
+	osmCache *cache.OSMCache,
+	diffCache *cache.DiffCache,
+	force bool,
+	merge bool,
+) error {
+	start := time.Now()
+
+	tagmapping, err := mapping.FromFile(baseOpts.MappingFile)
 	if err != nil {
-		return errors.Wrap(err, "opening diff file")
+		return err
 	}
-	defer f.Close()
-	parser, err := diff.NewGZIP(f, config)
+	if err := checkMapping(baseOpts.CacheDir, tagmapping, baseOpts.AllowMappingChanges); err != nil {
+		return err
+	}
+	static, err := tagmapping.SkipStaticTables()
 	if err != nil {
-		return errors.Wrap(err, "initializing diff parser")
+		return err
+	}
+	if len(static) > 0 {
+		log.Printf("[info] Not updating static tables %s", strings.Join(static, ", "))
 	}
 
-	tagmapping, err := mapping.FromFile(baseOpts.MappingFile)
+	db, err := openDiffDB(baseOpts, tagmapping)
 	if err != nil {
 		return err
 	}
+	defer db.Close()
 
-	dbConf := database.Config{
-		ConnectionParams: baseOpts.Connection,
-		Srid:             baseOpts.Srid,
-		// we apply diff imports on the Production schema
-		ImportSchema:     baseOpts.Schemas.Production,
-		ProductionSchema: baseOpts.Schemas.Production,
-		BackupSchema:     baseOpts.Schemas.Backup,
+	lastState, err := readLastState(baseOpts, db)
+	if err != nil {
+		return err
 	}
-	db, err := database.Open(dbConf, &tagmapping.Conf)
+	files, state, err := pendingFiles(lastState, oscFiles, force)
 	if err != nil {
-		return errors.Wrap(err, "opening database")
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	if state != nil && lastState != nil {
+		state.URL = lastState.URL
+	}
+
+	defer log.Step(fmt.Sprintf("Processing %s", strings.Join(files, ", ")))()
+
+	if db, ok := db.(database.Migrator); ok {
+		if err := db.Migrate(); err != nil {
+			return errors.Wrap(err, "migrating tables")
+		}
+	}
+
+	if db, ok := db.(database.DiffSequencer); ok {
+		seq := 0
+		if state != nil {
+			seq = state.Sequence
+		}
+		db.SetDiffSequence(seq)
 	}
-	defer db.Close()
 
 	err = db.Begin()
 	if err != nil {
@@ -161,63 +355,253 @@ func Update(
 		return errors.New("database not deletable")
 	}
 
+	recorder, ok := db.(database.ChangeRecorder)
+	if ok && !recorder.RecordsChanges() {
+		recorder = nil
+	}
+
+	var inserter database.Inserter = db
+	var changes *notify.Changes
+	if baseOpts.NotifyURL != "" {
+		changes = notify.NewChanges()
+		counter := &countingDB{forwarder: forwarder{delDb}, db: delDb, changes: changes}
+		delDb, inserter = counter, counter
+		if expireor != nil {
+			expireor = expireors{expireor, changes}
+		} else {
+			expireor = changes
+		}
+	}
+
+	imp := &diffImport{
+		srid:            baseOpts.Srid,
+		tagmapping:      tagmapping,
+		geometryLimiter: geometryLimiter,
+		logSkipped:      baseOpts.LimitToLogSkipped,
+		expireor:        expireor,
+		osmCache:        osmCache,
+		diffCache:       diffCache,
+		inserter:        inserter,
+		delDb:           delDb,
+		recorder:        recorder,
+	}
+
+	var unchanged *unchangedFilter
+	if diffCache.RowHashes != nil {
+		// one filter for all files, rows that are changed by multiple
+		// files are always replaced
+		unchanged = newUnchangedFilter(delDb, diffCache.RowHashes, sharedIDTables(tagmapping))
+		imp.delDb = unchanged
+		imp.inserter = unchanged
+	}
+
+	if baseOpts.DeadLetters != "" {
+		imp.deadLetters, err = openDeadLetters(baseOpts.DeadLetters)
+		if err != nil {
+			return errors.Wrap(err, "opening dead letters")
+		}
+		defer imp.deadLetters.Close()
+	}
+
 	genDb, ok := db.(database.Generalizer)
 	if ok {
 		genDb.EnableGeneralizeUpdates()
 	}
 
+	if merge && len(files) > 1 {
+		if err := imp.apply(files...); err != nil {
+			return err
+		}
+	} else {
+		for _, oscFile := range files {
+			if err := imp.apply(oscFile); err != nil {
+				return err
+			}
+		}
+	}
+
+	if unchanged != nil {
+		if err := unchanged.flush(); err != nil {
+			return errors.Wrap(err, "removing unchanged rows")
+		}
+	}
+
+	if genDb != nil {
+		genDb.GeneralizeUpdates()
+	}
+
+	if baseOpts.DiffStateInDatabase() {
+		imp.stats.Duration = time.Since(start)
+		// readLastState fails for databases without state store
+		store, _ := database.StateStoreOf(db)
+		if err := store.WriteState(state, imp.stats); err != nil {
+			return err
+		}
+	}
+
+	err = db.End()
+	if err != nil {
+		return err
+	}
+	if imp.deadLetters != nil && imp.deadLetters.count > 0 {
+		log.Printf("[warn] Recorded %d failed elements in %s", imp.deadLetters.count, baseOpts.DeadLetters)
+	}
+	if unchanged != nil {
+		if err := unchanged.commit(); err != nil {
+			return errors.Wrap(err, "storing row hashes")
+		}
+		log.Printf("[info] Skipped %d unchanged rows", unchanged.skipped)
+	}
+	err = db.Close()
+	if err != nil {
+		return err
+	}
+
+	if state != nil && baseOpts.DiffStateInFile() {
+		err = diffstate.WriteFile(filepath.Join(baseOpts.DiffDir, LastStateFilename), state)
+		if err != nil {
+			log.Println("[error] Unable to write last state:", err)
+		}
+	}
+
+	if changes != nil {
+		publishSummary(baseOpts.NotifyURL, changes, state, imp.stats)
+	}
+	return nil
+}
+
+// diffImport applies change files to the database and the caches. All
+// files are applied in the transaction of the database.
+type diffImport struct {
+	srid            int
+	tagmapping      *mapping.Mapping
+	geometryLimiter *limit.Limiter
+	expireor        expire.Expireor
+	osmCache        *cache.OSMCache
+	diffCache       *cache.DiffCache
+	inserter        database.Inserter
+	delDb           database.Deleter
+	recorder        database.ChangeRecorder
+	stats           database.DiffStats
+	// logSkipped logs each element outside of geometryLimiter.
+	logSkipped bool
+	// deadLetters records failed inserts, or nil.
+	deadLetters *deadLetters
+}
+
+// writerInserter returns the inserter for the writer of an element type
+// (node, way or relation).
+func (imp *diffImport) writerInserter(typ string) database.Inserter {
+	if imp.deadLetters == nil {
+		return imp.inserter
+	}
+	return &deadLetterDB{
+		forwarder:     forwarder{imp.inserter},
+		letters:       imp.deadLetters,
+		typ:           typ,
+		singleIDSpace: imp.tagmapping.Conf.SingleIDSpace,
+	}
+}
+
+// apply parses the change files and updates all changed elements. Multiple
+// files are merged, so that each element is only updated once.
+func (imp *diffImport) apply(oscFiles ...string) error {
+	diffs := make(chan osm.Diff)
+	config := diff.Config{
+		Diffs: diffs,
+		// metadata is only needed for the change table
+		IncludeMetadata: imp.recorder != nil,
+	}
+	oscFile := strings.Join(oscFiles, ", ")
+
+	var parser diffParser
+	if len(oscFiles) > 1 {
+		parser = &batchParser{files: oscFiles, conf: config}
+	} else {
+		var r io.Reader = os.Stdin
+		if oscFile != "-" {
+			f, err := os.Open(oscFile)
+			if err != nil {
+				return errors.Wrap(err, "opening diff file")
+			}
+			defer f.Close()
+			r = f
+		}
+		var err error
+		parser, err = newDiffParser(r, config)
+		if err != nil {
+			return errors.Wrap(err, "initializing diff parser")
+		}
+	}
+	oldStates, _ := parser.(oldStateParser)
+
 	deleter := NewDeleter(
-		delDb,
-		osmCache,
-		diffCache,
-		tagmapping.Conf.SingleIDSpace,
-		tagmapping.PointMatcher,
-		tagmapping.LineStringMatcher,
-		tagmapping.PolygonMatcher,
-		tagmapping.RelationMatcher,
-		tagmapping.RelationMemberMatcher,
+		imp.delDb,
+		imp.osmCache,
+		imp.diffCache,
+		imp.tagmapping.Conf.SingleIDSpace,
+		imp.tagmapping.PointMatcher,
+		imp.tagmapping.LineStringMatcher,
+		imp.tagmapping.PolygonMatcher,
+		imp.tagmapping.RelationMatcher,
+		imp.tagmapping.RelationMemberMatcher,
 	)
-	deleter.SetExpireor(expireor)
+	deleter.SetExpireor(imp.expireor)
+
+	var geomChanges *geometryChanges
+	if imp.diffCache.Geometries != nil {
+		geomChanges = newGeometryChanges(imp.diffCache.Geometries)
+	}
 
 	progress := stats.NewStatsReporter()
 
-	relTagFilter := tagmapping.RelationTagFilter()
-	wayTagFilter := tagmapping.WayTagFilter()
-	nodeTagFilter := tagmapping.NodeTagFilter()
+	relTagFilter := imp.tagmapping.RelationTagFilter()
+	wayTagFilter := imp.tagmapping.WayTagFilter()
+	nodeTagFilter := imp.tagmapping.NodeTagFilter()
 
 	relations := make(chan *osm.Relation)
 	ways := make(chan *osm.Way)
 	nodes := make(chan *osm.Node)
 
-	relWriter := writer.NewRelationWriter(osmCache, diffCache,
-		tagmapping.Conf.SingleIDSpace,
+	if imp.deadLetters != nil {
+		imp.deadLetters.setFile(oscFile)
+	}
+
+	relWriter := writer.NewRelationWriter(imp.osmCache, imp.diffCache,
+		imp.tagmapping.Conf.SingleIDSpace,
 		relations,
-		db, progress,
-		tagmapping.PolygonMatcher,
-		tagmapping.RelationMatcher,
-		tagmapping.RelationMemberMatcher,
-		baseOpts.Srid)
-	relWriter.SetLimiter(geometryLimiter)
-	relWriter.SetExpireor(expireor)
+		imp.writerInserter("relation"), progress,
+		imp.tagmapping.PolygonMatcher,
+		imp.tagmapping.RelationMatcher,
+		imp.tagmapping.RelationMemberMatcher,
+		imp.srid)
+	relWriter.SetLimiter(imp.geometryLimiter)
+	relWriter.SetExpireor(imp.expireor)
+	if geomChanges != nil {
+		relWriter.SetGeometries(geomChanges.store, geomChanges.rels)
+	}
 	relWriter.Start()
 
-	wayWriter := writer.NewWayWriter(osmCache, diffCache,
-		tagmapping.Conf.SingleIDSpace,
-		ways, db,
+	wayWriter := writer.NewWayWriter(imp.osmCache, imp.diffCache,
+		imp.tagmapping.Conf.SingleIDSpace,
+		ways, imp.writerInserter("way"),
 		progress,
-		tagmapping.PolygonMatcher,
-		tagmapping.LineStringMatcher,
-		baseOpts.Srid)
-	wayWriter.SetLimiter(geometryLimiter)
-	wayWriter.SetExpireor(expireor)
+		imp.tagmapping.PolygonMatcher,
+		imp.tagmapping.LineStringMatcher,
+		imp.srid)
+	wayWriter.SetLimiter(imp.geometryLimiter)
+	wayWriter.SetExpireor(imp.expireor)
+	if geomChanges != nil {
+		wayWriter.SetGeometries(geomChanges.store, geomChanges.ways)
+	}
 	wayWriter.Start()
 
-	nodeWriter := writer.NewNodeWriter(osmCache, nodes, db,
+	nodeWriter := writer.NewNodeWriter(imp.osmCache, nodes, imp.writerInserter("node"),
 		progress,
-		tagmapping.PointMatcher,
-		baseOpts.Srid)
-	nodeWriter.SetLimiter(geometryLimiter)
-	nodeWriter.SetExpireor(expireor)
+		imp.tagmapping.PointMatcher,
+		imp.srid)
+	nodeWriter.SetLimiter(imp.geometryLimiter)
+	nodeWriter.SetExpireor(imp.expireor)
 	nodeWriter.Start()
 
 	nodeIDs := make(map[int64]struct{})
@@ -236,7 +620,39 @@ func Update(
 		parseError <- parser.Parse(ctx)
 	}()
 
+	skipped := 0
 	for elem := range diffs {
+		outside, err := imp.outsideLimitTo(g, elem)
+		if err != nil {
+			return errors.Wrapf(err, "check limitto of %#v", elem)
+		}
+		if outside {
+			if imp.logSkipped {
+				log.Printf("[info] Skipping %s outside of limitto", diffElemString(elem))
+			}
+			skipped++
+			continue
+		}
+		if imp.recorder != nil {
+			// record before the tags are filtered
+			var old *osm.Element
+			if oldStates != nil {
+				if o, ok := oldStates.Old(elem); ok {
+					old = &o
+				}
+			}
+			if err := imp.recorder.RecordChange(elem, old); err != nil {
+				return errors.Wrapf(err, "record change %#v", elem)
+			}
+		}
+		switch {
+		case elem.Create:
+			imp.stats.Created++
+		case elem.Modify:
+			imp.stats.Modified++
+		case elem.Delete:
+			imp.stats.Deleted++
+		}
 		if elem.Rel != nil {
 			relTagFilter.Filter(&elem.Rel.Tags)
 			progress.AddRelations(1)
@@ -251,6 +667,11 @@ func Update(
 			progress.AddCoords(1)
 		}
 
+		// compare with the cached element before it is updated
+		if err := geomChanges.add(elem, imp.osmCache); err != nil {
+			return errors.Wrapf(err, "compare geometry of %#v", elem)
+		}
+
 		// always delete, to prevent duplicate elements from overlap of initial
 		// import and diff import
 		if err := deleter.Delete(elem); err != nil && err != cache.NotFound {
@@ -259,28 +680,28 @@ func Update(
 		if elem.Delete {
 			// no new or modified elem -> remove from cache
 			if elem.Rel != nil {
-				if err := osmCache.Relations.DeleteRelation(elem.Rel.ID); err != nil && err != cache.NotFound {
+				if err := imp.osmCache.Relations.DeleteRelation(elem.Rel.ID); err != nil && err != cache.NotFound {
 					return errors.Wrapf(err, "delete relation %v", elem.Rel)
 				}
 			} else if elem.Way != nil {
-				if err := osmCache.Ways.DeleteWay(elem.Way.ID); err != nil && err != cache.NotFound {
+				if err := imp.osmCache.Ways.DeleteWay(elem.Way.ID); err != nil && err != cache.NotFound {
 					return errors.Wrapf(err, "delete way %v", elem.Way)
 				}
-				if err := diffCache.Ways.Delete(elem.Way.ID); err != nil && err != cache.NotFound {
+				if err := imp.diffCache.Ways.Delete(elem.Way.ID); err != nil && err != cache.NotFound {
 					return errors.Wrapf(err, "delete way references %v", elem.Way)
 				}
 			} else if elem.Node != nil {
-				if err := osmCache.Nodes.DeleteNode(elem.Node.ID); err != nil && err != cache.NotFound {
+				if err := imp.osmCache.Nodes.DeleteNode(elem.Node.ID); err != nil && err != cache.NotFound {
 					return errors.Wrapf(err, "delete node %v", elem.Node)
 				}
-				if err := osmCache.Coords.DeleteCoord(elem.Node.ID); err != nil && err != cache.NotFound {
+				if err := imp.osmCache.Coords.DeleteCoord(elem.Node.ID); err != nil && err != cache.NotFound {
 					return errors.Wrapf(err, "delete coord %v", elem.Node)
 				}
 			}
 		}
 		if elem.Modify && elem.Node != nil && elem.Node.Tags == nil {
 			// handle modifies where a node drops all tags
-			if err := osmCache.Nodes.DeleteNode(elem.Node.ID); err != nil && err != cache.NotFound {
+			if err := imp.osmCache.Nodes.DeleteNode(elem.Node.ID); err != nil && err != cache.NotFound {
 				return errors.Wrapf(err, "delete node %v", elem.Node)
 			}
 		}
@@ -288,12 +709,12 @@ func Update(
 			if elem.Rel != nil {
 				// check if first member is cached to avoid caching
 				// unneeded relations (typical outside of our coverage)
-				cached, err := osmCache.FirstMemberIsCached(elem.Rel.Members)
+				cached, err := imp.osmCache.FirstMemberIsCached(elem.Rel.Members)
 				if err != nil {
 					return errors.Wrapf(err, "query first member %v", elem.Rel)
 				}
 				if cached {
-					err := osmCache.Relations.PutRelation(elem.Rel)
+					err := imp.osmCache.Relations.PutRelation(elem.Rel)
 					if err != nil {
 						return errors.Wrapf(err, "put relation %v", elem.Rel)
 					}
@@ -302,12 +723,12 @@ func Update(
 			} else if elem.Way != nil {
 				// check if first coord is cached to avoid caching
 				// unneeded ways (typical outside of our coverage)
-				cached, err := osmCache.Coords.FirstRefIsCached(elem.Way.Refs)
+				cached, err := imp.osmCache.Coords.FirstRefIsCached(elem.Way.Refs)
 				if err != nil {
 					return errors.Wrapf(err, "query first ref %v", elem.Way)
 				}
 				if cached {
-					err := osmCache.Ways.PutWay(elem.Way)
+					err := imp.osmCache.Ways.PutWay(elem.Way)
 					if err != nil {
 						return errors.Wrapf(err, "put way %v", elem.Way)
 					}
@@ -315,17 +736,17 @@ func Update(
 				}
 			} else if elem.Node != nil {
 				addNode := true
-				if geometryLimiter != nil {
-					if !geometryLimiter.IntersectsBuffer(g, elem.Node.Long, elem.Node.Lat) {
+				if imp.geometryLimiter != nil {
+					if !imp.geometryLimiter.IntersectsBuffer(g, elem.Node.Long, elem.Node.Lat) {
 						addNode = false
 					}
 				}
 				if addNode {
-					err := osmCache.Nodes.PutNode(elem.Node)
+					err := imp.osmCache.Nodes.PutNode(elem.Node)
 					if err != nil {
 						return errors.Wrapf(err, "put node %v", elem.Node)
 					}
-					err = osmCache.Coords.PutCoords([]osm.Node{*elem.Node})
+					err = imp.osmCache.Coords.PutCoords([]osm.Node{*elem.Node})
 					if err != nil {
 						return errors.Wrapf(err, "put coord %v", elem.Node)
 					}
@@ -343,10 +764,13 @@ func Update(
 	progress.Stop()
 	step()
 
-	err = <-parseError
+	err := <-parseError
 	if err != nil {
 		return errors.Wrapf(err, "parsing diff %s", oscFile)
 	}
+	if skipped > 0 {
+		log.Printf("[info] Skipped %d elements outside of limitto", skipped)
+	}
 
 	step = log.Step("Importing added/modified elements")
 
@@ -354,7 +778,7 @@ func Update(
 
 	// mark depending ways for (re)insert
 	for nodeID := range nodeIDs {
-		dependers := diffCache.Coords.Get(nodeID)
+		dependers := imp.diffCache.Coords.Get(nodeID)
 		for _, way := range dependers {
 			wayIDs[way] = struct{}{}
 		}
@@ -362,21 +786,23 @@ func Update(
 
 	// mark depending relations for (re)insert
 	for nodeID := range nodeIDs {
-		dependers := diffCache.CoordsRel.Get(nodeID)
+		dependers := imp.diffCache.CoordsRel.Get(nodeID)
 		for _, rel := range dependers {
 			relIDs[rel] = struct{}{}
 		}
 	}
 	for wayID := range wayIDs {
-		dependers := diffCache.Ways.Get(wayID)
+		dependers := imp.diffCache.Ways.Get(wayID)
 		// mark depending relations for (re)insert
 		for _, rel := range dependers {
 			relIDs[rel] = struct{}{}
 		}
 	}
 
+	geomChanges.checkMembers(nodeIDs, wayIDs)
+
 	for relID := range relIDs {
-		rel, err := osmCache.Relations.GetRelation(relID)
+		rel, err := imp.osmCache.Relations.GetRelation(relID)
 		if err != nil {
 			if err != cache.NotFound {
 				return errors.Wrapf(err, "fetching cached relation %v", relID)
@@ -389,7 +815,7 @@ func Update(
 	}
 
 	for wayID := range wayIDs {
-		way, err := osmCache.Ways.GetWay(wayID)
+		way, err := imp.osmCache.Ways.GetWay(wayID)
 		if err != nil {
 			if err != cache.NotFound {
 				return errors.Wrapf(err, "fetching cached way %v", wayID)
@@ -402,7 +828,7 @@ func Update(
 	}
 
 	for nodeID := range nodeIDs {
-		node, err := osmCache.Nodes.GetNode(nodeID)
+		node, err := imp.osmCache.Nodes.GetNode(nodeID)
 		if err != nil {
 			if err != cache.NotFound {
 				return errors.Wrapf(err, "fetching cached node %v", nodeID)
@@ -425,31 +851,7 @@ func Update(
 	relWriter.Wait()
 	wayWriter.Wait()
 
-	if genDb != nil {
-		genDb.GeneralizeUpdates()
-	}
-
-	err = db.End()
-	if err != nil {
-		return err
-	}
-	err = db.Close()
-	if err != nil {
-		return err
-	}
-
-	step()
-
 	progress.Stop()
-
-	if state != nil {
-		if lastState != nil {
-			state.URL = lastState.URL
-		}
-		err = diffstate.WriteFile(filepath.Join(baseOpts.DiffDir, LastStateFilename), state)
-		if err != nil {
-			log.Println("[error] Unable to write last state:", err)
-		}
-	}
+	step()
 	return nil
 }
@@ -0,0 +1,134 @@
+package update
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/go-osm/parser/diff"
+	diffstate "github.com/omniscale/go-osm/state"
+	"github.com/omniscale/imposm3/reader/adiff"
+)
+
+const testOsc = `<?xml version="1.0" encoding="UTF-8"?>
+<osmChange version="0.6">
+<create>
+  <node id="1" version="1" lat="53.0" lon="8.0"/>
+</create>
+</osmChange>
+`
+
+// testOscBzip2 is testOsc without XML declaration, compressed with bzip2.
+const testOscBzip2 = "QlpoOTFBWSZTWfiAA1wAABTdgAAQUAHrRwgALuedACAAdBKmpo0aYQAbUyDTSmhoemoMgGiSArtAExiS6BspQucMFRypZGPQnVc9TeexyKNJOosFiJ8WJim1hBB7KknRNGzsakh49kD8XckU4UJD4gANcA=="
+
+func TestNewDiffParser(t *testing.T) {
+	gz := bytes.Buffer{}
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(testOsc))
+	zw.Close()
+
+	bz2, err := base64.StdEncoding.DecodeString(testOscBzip2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noDecl := testOsc[strings.Index(testOsc, "\n")+1:]
+
+	for _, test := range []struct {
+		name string
+		data []byte
+	}{
+		{"osc", []byte(testOsc)},
+		// stdin is not seekable, compression is detected by the content
+		{"gzip", gz.Bytes()},
+		{"bzip2", bz2},
+		{"without declaration", []byte(noDecl)},
+		{"byte order mark", []byte("\ufeff" + testOsc)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			diffs := make(chan osm.Diff)
+			p, err := newDiffParser(bytes.NewReader(test.data), diff.Config{Diffs: diffs})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := p.(*adiff.Parser); ok {
+				t.Fatal("unexpected augmented diff parser")
+			}
+			ctx, stop := context.WithCancel(context.Background())
+			defer stop()
+			go func() {
+				if err := p.Parse(ctx); err != nil {
+					t.Error(err)
+				}
+			}()
+			var ids []int64
+			for d := range diffs {
+				if d.Node != nil {
+					ids = append(ids, d.Node.ID)
+				}
+			}
+			if len(ids) != 1 || ids[0] != 1 {
+				t.Errorf("unexpected nodes %v", ids)
+			}
+		})
+	}
+
+	p, err := newDiffParser(bytes.NewReader([]byte(`<?xml version="1.0"?><osm version="0.6" generator="Overpass API"><action type="create"></action></osm>`)), diff.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.(*adiff.Parser); !ok {
+		t.Errorf("expected augmented diff parser, got %T", p)
+	}
+}
+
+func TestPendingFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imposm3-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var files []string
+	for _, seq := range []int{3, 4, 2} {
+		name := filepath.Join(dir, fmt.Sprintf("%03d", seq))
+		state := &diffstate.DiffState{Time: time.Now(), Sequence: seq}
+		if err := diffstate.WriteFile(name+".state.txt", state); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, name+".osc.gz")
+	}
+	files = append(files, "-")
+
+	lastState := &diffstate.DiffState{Sequence: 3}
+	pending, state, err := pendingFiles(lastState, files, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pending, []string{files[1], "-"}) {
+		t.Errorf("unexpected pending files %v", pending)
+	}
+	if state == nil || state.Sequence != 4 {
+		t.Errorf("unexpected state %v", state)
+	}
+
+	pending, state, err = pendingFiles(lastState, files, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pending, files) {
+		t.Errorf("unexpected pending files %v", pending)
+	}
+	if state == nil || state.Sequence != 2 {
+		t.Errorf("unexpected state %v", state)
+	}
+}
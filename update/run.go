@@ -4,17 +4,20 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"syscall"
 	"time"
 
-	"github.com/omniscale/go-osm/replication/diff"
+	"github.com/omniscale/go-osm/replication"
 	"github.com/omniscale/go-osm/state"
 	"github.com/omniscale/imposm3/cache"
 	"github.com/omniscale/imposm3/config"
+	"github.com/omniscale/imposm3/database"
 	"github.com/omniscale/imposm3/expire"
 	"github.com/omniscale/imposm3/geom/limit"
 	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/stats"
+	"github.com/pkg/errors"
 )
 
 func Run(baseOpts config.Base) {
@@ -37,25 +40,32 @@ func Run(baseOpts config.Base) {
 		step()
 	}
 
-	s, err := state.ParseFile(filepath.Join(baseOpts.DiffDir, LastStateFilename))
+	s, err := runLastState(baseOpts)
 	if err != nil {
-		log.Fatal("[fatal] Unable to read last.state.txt:", err)
+		log.Fatal("[fatal] Unable to read last state:", err)
+	}
+	if s == nil {
+		log.Fatal("[fatal] No last state in last.state.txt or in the database, import with -diff first")
 	}
 	replicationURL := baseOpts.ReplicationURL
 	if replicationURL == "" {
 		replicationURL = s.URL
 	}
 	if replicationURL == "" {
-		log.Fatal("[fatal] No replicationURL in last.state.txt " +
+		log.Fatal("[fatal] No replicationURL in last state " +
 			"or replication_url in -config")
 	}
+	replicationURL, access := splitURLAuth(replicationURL, baseOpts.Replication)
 	log.Printf("[info] Starting replication from %s with %s interval", replicationURL, baseOpts.ReplicationInterval)
+	stats.SetDiffState(s.Sequence, s.Time)
 
-	downloader := diff.NewDownloader(
+	downloader := newDownloader(
 		baseOpts.DiffDir,
 		replicationURL,
 		s.Sequence+1,
 		baseOpts.ReplicationInterval,
+		access,
+		baseOpts.CatchUpBatch,
 	)
 	nextSeq := downloader.Sequences()
 
@@ -71,22 +81,56 @@ func Run(baseOpts config.Base) {
 	if err != nil {
 		log.Fatal("[fatal] Opening diff cache:", err)
 	}
+	if err := openRowHashes(baseOpts, diffCache); err != nil {
+		log.Fatal("[fatal] Opening row hashes:", err)
+	}
+	if err := openGeometries(baseOpts, diffCache); err != nil {
+		log.Fatal("[fatal] Opening geometries:", err)
+	}
 	defer diffCache.Close()
 
+	var changesets replication.Source
+	if baseOpts.Changesets {
+		changesets, err = openChangesets(baseOpts)
+		if err != nil {
+			log.Fatal("[fatal] Starting changeset replication:", err)
+		}
+	}
+
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
 
 	var tilelist *expire.TileList
 	var lastTlFlush = time.Now()
 	var tileExpireor expire.Expireor
-	if baseOpts.ExpireTilesDir != "" {
+	if baseOpts.ExpireTilesDir != "" || baseOpts.ExpireTilesWebhook.URL != "" {
 		tilelist = expire.NewTileList(baseOpts.ExpireTilesZoom, baseOpts.ExpireTilesDir)
+		tilelist.SetMinZoom(baseOpts.ExpireTilesMinZoom)
+		tilelist.SetBuffer(baseOpts.ExpireTilesBuffer)
+		if err := tilelist.SetFormats(baseOpts.ExpireTilesFormats); err != nil {
+			log.Fatal("[fatal] Expire tiles:", err)
+		}
+		if baseOpts.ExpireTilesWebhook.URL != "" {
+			tilelist.SetWebhook(baseOpts.ExpireTilesWebhook)
+		}
+		tilelist.SetRotation(baseOpts.ExpireTilesRotation)
 		tileExpireor = tilelist
 	}
 
+	notifier, err := newSystemdNotifier()
+	if err != nil {
+		log.Println("[warn] Connecting to systemd:", err)
+	}
+	defer notifier.Close()
+	watchdog := notifier.WatchdogTicks()
+
 	shutdown := func() {
 		log.Println("[info] Exiting. (SIGTERM/SIGINT/SIGHUP)")
+		notifier.Stopping()
 		downloader.Stop()
+		if changesets != nil {
+			changesets.Stop()
+		}
 		osmCache.Close()
 		diffCache.Close()
 		if tilelist != nil {
@@ -100,23 +144,38 @@ func Run(baseOpts config.Base) {
 
 	exp := newExpBackoff(2*time.Second, 5*time.Minute)
 
+	notifier.Ready(fmt.Sprintf("Imported #%d with changes till %s", s.Sequence, s.Time))
+
 	for {
 		select {
 		case <-sigc:
 			shutdown()
+		case <-watchdog:
+			notifier.Watchdog()
 		case seq := <-nextSeq:
 			if seq.Error != nil {
 				log.Printf("[error] Downloading #%d: %s", seq.Sequence, seq.Error)
+				stats.SetDiffError(errors.Wrapf(seq.Error, "downloading #%d", seq.Sequence))
+				notifier.Status(fmt.Sprintf("Error downloading #%d: %s", seq.Sequence, seq.Error))
 				continue
 			}
-			fname := seq.Filename
-			seqID := seq.Sequence
-			seqTime := seq.Time
+			batch := nextBatch(seq, nextSeq, baseOpts.CatchUpBatch)
+			fnames := make([]string, len(batch))
+			for i := range batch {
+				fnames[i] = batch[i].Filename
+			}
+			seqID := batch[len(batch)-1].Sequence
+			seqTime := batch[len(batch)-1].Time
+			seqName := fmt.Sprintf("#%d", seqID)
+			if len(batch) > 1 {
+				seqName = fmt.Sprintf("#%d-#%d", batch[0].Sequence, seqID)
+			}
 			for {
-				log.Printf("[info] Importing #%d including changes till %s (%s behind)", seqID, seqTime, time.Since(seqTime).Truncate(time.Second))
-				finishedImport := log.Step(fmt.Sprintf("Importing #%d", seqID))
+				log.Printf("[info] Importing %s including changes till %s (%s behind)", seqName, seqTime, time.Since(seqTime).Truncate(time.Second))
+				finishedImport := log.Step(fmt.Sprintf("Importing %s", seqName))
+				notifier.Status(fmt.Sprintf("Importing %s with changes till %s", seqName, seqTime))
 
-				err := Update(baseOpts, fname, geometryLimiter, tileExpireor, osmCache, diffCache, false)
+				err := updateFiles(baseOpts, fnames, geometryLimiter, tileExpireor, osmCache, diffCache, false, true)
 
 				osmCache.Coords.Flush()
 				diffCache.Flush()
@@ -132,6 +191,7 @@ func Run(baseOpts config.Base) {
 				}
 
 				finishedImport()
+				notifier.Watchdog()
 
 				select {
 				case <-sigc:
@@ -140,11 +200,15 @@ func Run(baseOpts config.Base) {
 				}
 
 				if err != nil {
-					log.Printf("[error] Importing #%d: %s", seqID, err)
+					log.Printf("[error] Importing %s: %s", seqName, err)
+					stats.SetDiffError(errors.Wrapf(err, "importing %s", seqName))
+					notifier.Status(fmt.Sprintf("Error importing %s, retrying in %s: %s", seqName, exp.Duration(), err))
 					log.Println("[info] Retrying in", exp.Duration())
 					// TODO handle <-sigc during wait
 					exp.Wait()
 				} else {
+					stats.SetDiffState(seqID, seqTime)
+					notifier.Status(fmt.Sprintf("Imported #%d with changes till %s", seqID, seqTime))
 					exp.Reset()
 					break
 				}
@@ -156,6 +220,29 @@ func Run(baseOpts config.Base) {
 	}
 }
 
+// nextBatch returns seq and all following sequences that are already
+// downloaded, up to max sequences. Download errors of the following
+// sequences are logged, they are retried by the downloader.
+func nextBatch(seq replication.Sequence, nextSeq <-chan replication.Sequence, max int) []replication.Sequence {
+	batch := []replication.Sequence{seq}
+	for len(batch) < max {
+		select {
+		case s, ok := <-nextSeq:
+			if !ok {
+				return batch
+			}
+			if s.Error != nil {
+				log.Printf("[error] Downloading #%d: %s", s.Sequence, s.Error)
+				return batch
+			}
+			batch = append(batch, s)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
 type expBackoff struct {
 	current time.Duration
 	min     time.Duration
@@ -181,3 +268,21 @@ func (eb *expBackoff) Wait() {
 func (eb *expBackoff) Reset() {
 	eb.current = eb.min
 }
+
+// runLastState returns the state of the last imported diff. The database
+// is only opened if it stores the diff state.
+func runLastState(baseOpts config.Base) (*state.DiffState, error) {
+	var db database.DB
+	if baseOpts.DiffStateInDatabase() {
+		tagmapping, err := mapping.FromFile(baseOpts.MappingFile)
+		if err != nil {
+			return nil, err
+		}
+		db, err = openDiffDB(baseOpts, tagmapping)
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+	}
+	return readLastState(baseOpts, db)
+}
@@ -0,0 +1,168 @@
+package update
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/omniscale/go-osm/state"
+	"github.com/omniscale/imposm3/config"
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+)
+
+// SetSequence sets the state of the last imported diff, e.g. to import
+// diffs again after a bad diff. The next diff or run imports all diffs
+// after the new sequence. Diff imports are idempotent, so diffs that
+// were already imported can be imported again.
+func SetSequence(opts config.SetSequence) {
+	if opts.Base.Quiet {
+		log.SetMinLevel(log.LInfo)
+	}
+	if err := setSequence(opts); err != nil {
+		log.Fatal("[fatal] Setting sequence:", err)
+	}
+}
+
+func setSequence(opts config.SetSequence) error {
+	baseOpts := opts.Base
+	var db database.DB
+	if baseOpts.DiffStateInDatabase() {
+		tagmapping, err := mapping.FromFile(baseOpts.MappingFile)
+		if err != nil {
+			return err
+		}
+		db, err = openDiffDB(baseOpts, tagmapping)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+	}
+	last, err := readLastState(baseOpts, db)
+	if err != nil {
+		return err
+	}
+
+	replicationURL := baseOpts.ReplicationURL
+	if replicationURL == "" && last != nil {
+		replicationURL = last.URL
+	}
+	if replicationURL == "" {
+		return errors.New("no replicationURL in last state or replication_url in -config")
+	}
+	replicationURL, access := splitURLAuth(replicationURL, baseOpts.Replication)
+
+	d := baseDownloader(baseOpts.DiffDir, replicationURL, 0, baseOpts.ReplicationInterval)
+	d.access = access
+	latest, err := d.fetchState(d.baseURL + d.serverState)
+	if err != nil {
+		return errors.Wrap(err, "fetching latest state")
+	}
+
+	var s *state.DiffState
+	if opts.RewindHours > 0 {
+		if last == nil {
+			return errors.New("-rewind-hours requires a last state")
+		}
+		d.detectInterval(latest)
+		s, err = d.rewindState(last, time.Duration(opts.RewindHours)*time.Hour)
+	} else {
+		if opts.Sequence > latest.Sequence {
+			return errors.Errorf("sequence %d is after the latest sequence %d of the server", opts.Sequence, latest.Sequence)
+		}
+		if last != nil && opts.Sequence > last.Sequence && !opts.Force {
+			return errors.Errorf("sequence %d is after the last imported sequence %d, use -force to skip diffs", opts.Sequence, last.Sequence)
+		}
+		s, err = d.fetchState(d.baseURL + seqPath(opts.Sequence) + d.stateExt)
+	}
+	if err != nil {
+		return err
+	}
+	s.URL = replicationURL
+	if last != nil {
+		log.Printf("[info] Setting sequence from #%d (%s) to #%d (%s)", last.Sequence, last.Time, s.Sequence, s.Time)
+	} else {
+		log.Printf("[info] Setting sequence to #%d (%s)", s.Sequence, s.Time)
+	}
+
+	// diffs after the new sequence are downloaded again, in case a bad
+	// diff was replaced on the server
+	removed, err := removeDiffsAfter(baseOpts.DiffDir, s.Sequence)
+	if err != nil {
+		return errors.Wrap(err, "removing downloaded diffs")
+	}
+	if removed > 0 {
+		log.Printf("[info] Removed %d downloaded files after #%d", removed, s.Sequence)
+	}
+
+	if baseOpts.DiffStateInDatabase() {
+		// readLastState fails for databases without state store
+		store, _ := database.StateStoreOf(db)
+		if err := store.WriteState(s, database.DiffStats{}); err != nil {
+			return err
+		}
+	}
+	if baseOpts.DiffStateInFile() {
+		if err := state.WriteFile(filepath.Join(baseOpts.DiffDir, LastStateFilename), s); err != nil {
+			return errors.Wrap(err, "writing last state")
+		}
+	}
+	return nil
+}
+
+// rewindState returns the state of a sequence that is at least rewind
+// before last. The sequence is estimated from the interval and corrected
+// with the timestamps of the fetched states.
+func (d *downloader) rewindState(last *state.DiffState, rewind time.Duration) (*state.DiffState, error) {
+	target := last.Time.Add(-rewind)
+	seq := last.Sequence - int(rewind/d.interval)
+	for {
+		if seq < 1 {
+			return nil, errors.Errorf("no sequence before %s", target)
+		}
+		s, err := d.fetchState(d.baseURL + seqPath(seq) + d.stateExt)
+		if err != nil {
+			return nil, err
+		}
+		if !s.Time.After(target) {
+			return s, nil
+		}
+		step := int(s.Time.Sub(target) / d.interval)
+		if step < 1 {
+			step = 1
+		}
+		seq -= step
+	}
+}
+
+// removeDiffsAfter removes all downloaded diff and state files with a
+// sequence after seq from diffDir. Returns the number of removed files.
+func removeDiffsAfter(diffDir string, seq int) (int, error) {
+	files, err := filepath.Glob(filepath.Join(diffDir, "[0-9][0-9][0-9]", "[0-9][0-9][0-9]", "[0-9][0-9][0-9].*"))
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, f := range files {
+		rel, err := filepath.Rel(diffDir, f)
+		if err != nil {
+			return removed, err
+		}
+		// AAA/BBB/CCC.osc.gz -> AAABBBCCC
+		digits := strings.Replace(strings.SplitN(rel, ".", 2)[0], string(filepath.Separator), "", -1)
+		fileSeq, err := strconv.Atoi(digits)
+		if err != nil || fileSeq <= seq {
+			continue
+		}
+		if err := os.Remove(f); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
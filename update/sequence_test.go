@@ -0,0 +1,95 @@
+package update
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/omniscale/go-osm/state"
+	"github.com/omniscale/imposm3/config"
+)
+
+func TestSetSequence(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	stateFile := func(seq int) string {
+		ts := start.Add(time.Duration(seq) * time.Hour).Format("2006-01-02T15\\:04\\:05Z")
+		return fmt.Sprintf("sequenceNumber=%d\ntimestamp=%s\n", seq, ts)
+	}
+	files := map[string]string{"/state.txt": stateFile(100)}
+	for seq := 1; seq <= 100; seq++ {
+		files["/"+seqPath(seq)+".state.txt"] = stateFile(seq)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "imposm3-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lastStateFile := filepath.Join(dir, LastStateFilename)
+	last := &state.DiffState{Sequence: 90, Time: start.Add(90 * time.Hour), URL: srv.URL + "/"}
+	if err := state.WriteFile(lastStateFile, last); err != nil {
+		t.Fatal(err)
+	}
+	for _, seq := range []int{40, 41, 90} {
+		for _, ext := range []string{".osc.gz", ".state.txt"} {
+			f := filepath.Join(dir, seqPath(seq)+ext)
+			os.MkdirAll(filepath.Dir(f), 0755)
+			if err := ioutil.WriteFile(f, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	opts := config.SetSequence{
+		Base:        config.Base{DiffDir: dir, DiffState: "file", ReplicationInterval: time.Minute},
+		RewindHours: 50,
+	}
+	if err := setSequence(opts); err != nil {
+		t.Fatal(err)
+	}
+	s, err := state.ParseFile(lastStateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Sequence != 40 || !s.Time.Equal(start.Add(40*time.Hour)) || s.URL != srv.URL+"/" {
+		t.Errorf("unexpected state %v", s)
+	}
+	if _, err := os.Stat(filepath.Join(dir, seqPath(40)+".osc.gz")); err != nil {
+		t.Error("diff before sequence removed", err)
+	}
+	for _, seq := range []int{41, 90} {
+		if _, err := os.Stat(filepath.Join(dir, seqPath(seq)+".osc.gz")); !os.IsNotExist(err) {
+			t.Errorf("diff %d after sequence not removed: %v", seq, err)
+		}
+	}
+
+	opts = config.SetSequence{
+		Base:     config.Base{DiffDir: dir, DiffState: "file"},
+		Sequence: 50,
+	}
+	if err := setSequence(opts); err == nil {
+		t.Error("sequence after last state set without force")
+	}
+	opts.Force = true
+	if err := setSequence(opts); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := state.ParseFile(lastStateFile); s.Sequence != 50 {
+		t.Errorf("unexpected state %v", s)
+	}
+}
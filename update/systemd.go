@@ -0,0 +1,88 @@
+package update
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// systemdNotifier sends the state of imposm run to systemd, if it is
+// started as a service with Type=notify. The methods do nothing if the
+// notifier is nil.
+type systemdNotifier struct {
+	conn *net.UnixConn
+	// watchdog is the WatchdogSec of the service, 0 if disabled.
+	watchdog time.Duration
+}
+
+// newSystemdNotifier returns a notifier for the NOTIFY_SOCKET of systemd,
+// or nil if imposm is not started by systemd.
+func newSystemdNotifier() (*systemdNotifier, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil, nil
+	}
+	if socket[0] == '@' {
+		// abstract namespace
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	n := &systemdNotifier{conn: conn}
+
+	// WATCHDOG_PID is not set for the main process of the service in
+	// older systemd versions
+	pid := os.Getenv("WATCHDOG_PID")
+	if usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64); err == nil && usec > 0 &&
+		(pid == "" || pid == strconv.Itoa(os.Getpid())) {
+		n.watchdog = time.Duration(usec) * time.Microsecond
+	}
+	return n, nil
+}
+
+func (n *systemdNotifier) send(state string) error {
+	if n == nil {
+		return nil
+	}
+	_, err := n.conn.Write([]byte(state))
+	return err
+}
+
+// Ready notifies systemd that the startup is finished.
+func (n *systemdNotifier) Ready(status string) error {
+	return n.send("READY=1\nSTATUS=" + status)
+}
+
+// Status sets the status that is shown by systemctl status.
+func (n *systemdNotifier) Status(status string) error {
+	return n.send("STATUS=" + status)
+}
+
+// Watchdog notifies systemd that imposm is still running.
+func (n *systemdNotifier) Watchdog() error {
+	return n.send("WATCHDOG=1")
+}
+
+// Stopping notifies systemd that imposm is shutting down.
+func (n *systemdNotifier) Stopping() error {
+	return n.send("STOPPING=1")
+}
+
+// WatchdogTicks returns a channel that ticks twice per watchdog interval,
+// or nil if the watchdog is not enabled.
+func (n *systemdNotifier) WatchdogTicks() <-chan time.Time {
+	if n == nil || n.watchdog == 0 {
+		return nil
+	}
+	return time.NewTicker(n.watchdog / 2).C
+}
+
+func (n *systemdNotifier) Close() error {
+	if n == nil {
+		return nil
+	}
+	return n.conn.Close()
+}
@@ -0,0 +1,65 @@
+package update
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSystemdNotifier(t *testing.T) {
+	if n, err := newSystemdNotifier(); n != nil || err != nil {
+		t.Fatalf("notifier without NOTIFY_SOCKET: %v %v", n, err)
+	}
+	var n *systemdNotifier
+	if err := n.Ready("ok"); err != nil || n.WatchdogTicks() != nil {
+		t.Error("nil notifier not ignored")
+	}
+
+	dir, err := ioutil.TempDir("", "imposm3-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", socket)
+	os.Setenv("WATCHDOG_USEC", "2000000")
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	defer func() {
+		os.Unsetenv("NOTIFY_SOCKET")
+		os.Unsetenv("WATCHDOG_USEC")
+		os.Unsetenv("WATCHDOG_PID")
+	}()
+
+	n, err = newSystemdNotifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	if n.watchdog != 2*time.Second {
+		t.Errorf("unexpected watchdog %s", n.watchdog)
+	}
+	n.Ready("Imported #42")
+	n.Watchdog()
+
+	buf := make([]byte, 1024)
+	for _, expected := range []string{"READY=1\nSTATUS=Imported #42", "WATCHDOG=1"} {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		l, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:l]) != expected {
+			t.Errorf("unexpected notification %q, expected %q", buf[:l], expected)
+		}
+	}
+}
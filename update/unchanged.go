@@ -0,0 +1,309 @@
+package update
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sync"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/cache"
+	"github.com/omniscale/imposm3/config"
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/mapping"
+)
+
+// rowHashStore is implemented by cache.RowHashes.
+type rowHashStore interface {
+	Get(cache.RowKey) (uint64, bool, error)
+	Put(map[cache.RowKey]uint64) error
+	Delete([]cache.RowKey) error
+}
+
+// unchangedFilter skips the delete and insert of rows that are not changed
+// by a diff, e.g. if only a tag was modified that is not mapped to any
+// column.
+//
+// Deletes are deferred till the element is inserted again. The rows are
+// only replaced if the hash of the new rows differs from the hash of the
+// rows that were inserted by a previous diff import. Elements that are not
+// inserted again are deleted by flush.
+type unchangedFilter struct {
+	forwarder
+	db     database.Deleter
+	hashes rowHashStore
+	// excluded are the tables with rows of different elements with the
+	// same ID (geometry tables without use_single_id_space).
+	excluded map[string]bool
+
+	mu      sync.Mutex
+	pending map[int64]map[string][]mapping.Match
+	seen    map[cache.RowKey]struct{}
+	updated map[cache.RowKey]uint64
+	removed map[cache.RowKey]struct{}
+	skipped int
+}
+
+func newUnchangedFilter(db database.Deleter, hashes rowHashStore, excluded map[string]bool) *unchangedFilter {
+	return &unchangedFilter{
+		forwarder: forwarder{db},
+		db:        db,
+		hashes:    hashes,
+		excluded:  excluded,
+		pending:   make(map[int64]map[string][]mapping.Match),
+		seen:      make(map[cache.RowKey]struct{}),
+		updated:   make(map[cache.RowKey]uint64),
+		removed:   make(map[cache.RowKey]struct{}),
+	}
+}
+
+func (f *unchangedFilter) Delete(id int64, matches []mapping.Match) error {
+	var direct []mapping.Match
+	f.mu.Lock()
+	for _, m := range matches {
+		if f.excluded[m.Table.Name] {
+			direct = append(direct, m)
+			continue
+		}
+		tables, ok := f.pending[id]
+		if !ok {
+			tables = make(map[string][]mapping.Match)
+			f.pending[id] = tables
+		}
+		tables[m.Table.Name] = append(tables[m.Table.Name], m)
+	}
+	f.mu.Unlock()
+	if len(direct) > 0 {
+		return f.db.Delete(id, direct)
+	}
+	return nil
+}
+
+func (f *unchangedFilter) InsertPoint(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return f.insert(elem, geom, matches, f.db.InsertPoint)
+}
+
+func (f *unchangedFilter) InsertLineString(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return f.insert(elem, geom, matches, f.db.InsertLineString)
+}
+
+func (f *unchangedFilter) InsertPolygon(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return f.insert(elem, geom, matches, f.db.InsertPolygon)
+}
+
+// InsertRelationMember inserts the member rows without comparing them, as
+// a relation has multiple rows in relation_member tables.
+func (f *unchangedFilter) InsertRelationMember(rel osm.Relation, member osm.Member, geom geom.Geometry, matches []mapping.Match) error {
+	if err := f.deletePending(rel.ID, matches); err != nil {
+		return err
+	}
+	return f.db.InsertRelationMember(rel, member, geom, matches)
+}
+
+// InsertInvalid passes invalid elements to the quarantine of the database,
+// if it has one. The previous rows of the element are deleted in any case.
+func (f *unchangedFilter) InsertInvalid(elem osm.Element, reason error, wkb []byte, matches []mapping.Match) error {
+	if err := f.deletePending(elem.ID, matches); err != nil {
+		return err
+	}
+	return f.forwarder.InsertInvalid(elem, reason, wkb, matches)
+}
+
+type insertFunc func(osm.Element, geom.Geometry, []mapping.Match) error
+
+func (f *unchangedFilter) insert(elem osm.Element, geom geom.Geometry, matches []mapping.Match, insert insertFunc) error {
+	for _, tableMatches := range matchesByTable(matches) {
+		table := tableMatches[0].Table.Name
+		if f.excluded[table] {
+			if err := insert(elem, geom, tableMatches); err != nil {
+				return err
+			}
+			continue
+		}
+		key := cache.RowKey{Table: table, ID: elem.ID}
+		hash := hashRows(elem, geom, tableMatches)
+
+		unchanged, deletes, err := f.compare(key, hash)
+		if err != nil {
+			return err
+		}
+		if unchanged {
+			continue
+		}
+		if len(deletes) > 0 {
+			if err := f.db.Delete(elem.ID, deletes); err != nil {
+				return err
+			}
+		}
+		if err := insert(elem, geom, tableMatches); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compare records the hash of the new rows and returns whether they are
+// unchanged. Returns the pending deletes for these rows if they changed.
+func (f *unchangedFilter) compare(key cache.RowKey, hash uint64) (bool, []mapping.Match, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	deletes, isPending := f.pending[key.ID][key.Table]
+	if isPending {
+		delete(f.pending[key.ID], key.Table)
+		if len(f.pending[key.ID]) == 0 {
+			delete(f.pending, key.ID)
+		}
+	}
+
+	if _, ok := f.seen[key]; ok {
+		// Multiple inserts for the same rows. The hash only covers the
+		// first insert, so the rows need to be replaced on the next
+		// update.
+		delete(f.updated, key)
+		f.removed[key] = struct{}{}
+		return false, deletes, nil
+	}
+	f.seen[key] = struct{}{}
+	f.updated[key] = hash
+	delete(f.removed, key)
+
+	if !isPending {
+		return false, nil, nil
+	}
+	old, ok, err := f.hashes.Get(key)
+	if err != nil {
+		return false, nil, err
+	}
+	if ok && old == hash {
+		f.skipped++
+		return true, nil, nil
+	}
+	return false, deletes, nil
+}
+
+// deletePending deletes the rows of the element from the tables of
+// matches, if they are pending. Their hashes are removed.
+func (f *unchangedFilter) deletePending(id int64, matches []mapping.Match) error {
+	var deletes []mapping.Match
+	f.mu.Lock()
+	for _, m := range matches {
+		table := m.Table.Name
+		if ms, ok := f.pending[id][table]; ok {
+			deletes = append(deletes, ms...)
+			delete(f.pending[id], table)
+		}
+		key := cache.RowKey{Table: table, ID: id}
+		delete(f.updated, key)
+		f.removed[key] = struct{}{}
+	}
+	if len(f.pending[id]) == 0 {
+		delete(f.pending, id)
+	}
+	f.mu.Unlock()
+	if len(deletes) > 0 {
+		return f.db.Delete(id, deletes)
+	}
+	return nil
+}
+
+// flush deletes the rows of all elements that were not inserted again and
+// removes the hashes of all updated rows. Needs to be called after all
+// elements are inserted and before the database transaction is committed.
+// The hashes of the updated rows are only stored with commit, so that they
+// never refer to rows of a transaction that was not committed.
+func (f *unchangedFilter) flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for id, tables := range f.pending {
+		var deletes []mapping.Match
+		for table, ms := range tables {
+			deletes = append(deletes, ms...)
+			f.removed[cache.RowKey{Table: table, ID: id}] = struct{}{}
+		}
+		if err := f.db.Delete(id, deletes); err != nil {
+			return err
+		}
+	}
+	f.pending = make(map[int64]map[string][]mapping.Match)
+
+	keys := make([]cache.RowKey, 0, len(f.removed)+len(f.updated))
+	for key := range f.removed {
+		keys = append(keys, key)
+	}
+	for key := range f.updated {
+		keys = append(keys, key)
+	}
+	return f.hashes.Delete(keys)
+}
+
+// commit stores the hashes of the updated rows. Needs to be called after
+// the database transaction was committed.
+func (f *unchangedFilter) commit() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.hashes.Put(f.updated)
+}
+
+// matchesByTable groups the matches by their table, in the order of the
+// first match of each table.
+func matchesByTable(matches []mapping.Match) [][]mapping.Match {
+	var groups [][]mapping.Match
+	idx := make(map[string]int)
+	for _, m := range matches {
+		i, ok := idx[m.Table.Name]
+		if !ok {
+			i = len(groups)
+			idx[m.Table.Name] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], m)
+	}
+	return groups
+}
+
+// hashRows returns a hash of the mapped values of all rows.
+func hashRows(elem osm.Element, geom geom.Geometry, matches []mapping.Match) uint64 {
+	h := fnv.New64a()
+	for _, m := range matches {
+		for _, v := range m.Row(&elem, &geom) {
+			switch v := v.(type) {
+			case string:
+				io.WriteString(h, v)
+			case []byte:
+				h.Write(v)
+			default:
+				// maps are printed with sorted keys
+				fmt.Fprintf(h, "%#v", v)
+			}
+			h.Write([]byte{0})
+		}
+		h.Write([]byte{'\n'})
+	}
+	return h.Sum64()
+}
+
+// sharedIDTables returns the geometry tables that can contain nodes and
+// ways with the same ID, as their rows can't be compared by ID.
+func sharedIDTables(m *mapping.Mapping) map[string]bool {
+	tables := make(map[string]bool)
+	if m.Conf.SingleIDSpace {
+		return tables
+	}
+	for name, t := range m.Conf.Tables {
+		if t.Type == "geometry" {
+			tables[name] = true
+		}
+	}
+	return tables
+}
+
+// openRowHashes opens the row hashes for -skip-unchanged. Otherwise it
+// removes them, as they are outdated after updates without them.
+func openRowHashes(baseOpts config.Base, diffCache *cache.DiffCache) error {
+	if baseOpts.SkipUnchanged {
+		return diffCache.OpenRowHashes()
+	}
+	return diffCache.RemoveRowHashes()
+}
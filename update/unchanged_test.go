@@ -0,0 +1,129 @@
+package update
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/cache"
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/mapping"
+)
+
+type recordingDB struct {
+	calls []string
+}
+
+func (db *recordingDB) record(op string, id int64, matches []mapping.Match) error {
+	for _, m := range matches {
+		db.calls = append(db.calls, fmt.Sprintf("%s %s %d", op, m.Table.Name, id))
+	}
+	return nil
+}
+
+func (db *recordingDB) InsertPoint(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return db.record("insert", elem.ID, matches)
+}
+
+func (db *recordingDB) InsertLineString(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return db.record("insert", elem.ID, matches)
+}
+
+func (db *recordingDB) InsertPolygon(elem osm.Element, geom geom.Geometry, matches []mapping.Match) error {
+	return db.record("insert", elem.ID, matches)
+}
+
+func (db *recordingDB) InsertRelationMember(rel osm.Relation, member osm.Member, geom geom.Geometry, matches []mapping.Match) error {
+	return db.record("insert", rel.ID, matches)
+}
+
+func (db *recordingDB) Delete(id int64, matches []mapping.Match) error {
+	return db.record("delete", id, matches)
+}
+
+type memRowHashes map[cache.RowKey]uint64
+
+func (h memRowHashes) Get(key cache.RowKey) (uint64, bool, error) {
+	hash, ok := h[key]
+	return hash, ok, nil
+}
+
+func (h memRowHashes) Put(hashes map[cache.RowKey]uint64) error {
+	for key, hash := range hashes {
+		h[key] = hash
+	}
+	return nil
+}
+
+func (h memRowHashes) Delete(keys []cache.RowKey) error {
+	for _, key := range keys {
+		delete(h, key)
+	}
+	return nil
+}
+
+func TestUnchangedFilter(t *testing.T) {
+	m, err := mapping.New([]byte(`
+tables:
+  pois:
+    type: point
+    columns:
+      - {name: osm_id, type: id}
+      - {name: name, type: string, key: name}
+    mapping:
+      amenity: [__any__]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashes := memRowHashes{}
+	// update applies a diff that modifies node 1 to tags, or deletes it if
+	// tags is nil.
+	update := func(oldTags, tags osm.Tags) []string {
+		db := &recordingDB{}
+		f := newUnchangedFilter(db, hashes, nil)
+		old := osm.Node{Element: osm.Element{ID: 1, Tags: oldTags}}
+		if err := f.Delete(1, m.PointMatcher.MatchNode(&old)); err != nil {
+			t.Fatal(err)
+		}
+		if tags != nil {
+			node := osm.Node{Element: osm.Element{ID: 1, Tags: tags}}
+			if err := f.InsertPoint(node.Element, geom.Geometry{Wkb: []byte("POINT")}, m.PointMatcher.MatchNode(&node)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := f.flush(); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.commit(); err != nil {
+			t.Fatal(err)
+		}
+		return db.calls
+	}
+
+	cafe := osm.Tags{"amenity": "cafe", "name": "A"}
+	cafeNote := osm.Tags{"amenity": "cafe", "name": "A", "note": "foo"}
+	renamed := osm.Tags{"amenity": "cafe", "name": "B"}
+	replaced := []string{"delete pois 1", "insert pois 1"}
+
+	for _, tc := range []struct {
+		old, new osm.Tags
+		calls    []string
+	}{
+		// no hash from previous diff
+		{cafe, cafe, replaced},
+		// unmapped tag changed
+		{cafe, cafeNote, nil},
+		{cafeNote, renamed, replaced},
+		// deleted
+		{renamed, nil, []string{"delete pois 1"}},
+		// hash was removed
+		{renamed, renamed, replaced},
+	} {
+		if calls := update(tc.old, tc.new); !reflect.DeepEqual(calls, tc.calls) {
+			t.Errorf("unexpected calls %v for %v -> %v, expected %v", calls, tc.old, tc.new, tc.calls)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package writer
+
+import (
+	"github.com/omniscale/imposm3/cache"
+	"github.com/omniscale/imposm3/log"
+)
+
+// geometryUpdate updates the stored geometries of a single element. A nil
+// geometryUpdate does nothing.
+type geometryUpdate struct {
+	store    *cache.Geometries
+	id       int64
+	reuse    bool
+	inserted map[cache.GeometryKind]bool
+}
+
+// geometryUpdate returns the update for the element with the OSM id, nil
+// if geometries are not stored.
+func (writer *OsmElemWriter) geometryUpdate(id int64) *geometryUpdate {
+	if writer.geometries == nil {
+		return nil
+	}
+	_, reuse := writer.reuse[id]
+	return &geometryUpdate{
+		store:    writer.geometries,
+		id:       id,
+		reuse:    reuse,
+		inserted: make(map[cache.GeometryKind]bool),
+	}
+}
+
+// cached returns the stored WKB of the geometry, if it can be reused.
+func (u *geometryUpdate) cached(kind cache.GeometryKind) []byte {
+	if u == nil || !u.reuse {
+		return nil
+	}
+	wkb, err := u.store.Get(kind, u.id)
+	if err != nil {
+		if err != cache.NotFound {
+			log.Println("[warn]: ", err)
+		}
+		return nil
+	}
+	return wkb
+}
+
+// insert records that the geometry was inserted and stores it. wkb is nil
+// if the geometry was reused.
+func (u *geometryUpdate) insert(kind cache.GeometryKind, wkb []byte) {
+	if u == nil {
+		return
+	}
+	u.inserted[kind] = true
+	if wkb != nil {
+		if err := u.store.Put(kind, u.id, wkb); err != nil {
+			log.Println("[warn]: ", err)
+		}
+	}
+}
+
+// finish removes the geometries of all kinds that were not inserted. Only
+// geometries of inserted elements can be reused, as changes of other
+// elements are not tracked by the diff cache.
+func (u *geometryUpdate) finish(kinds ...cache.GeometryKind) {
+	if u == nil {
+		return
+	}
+	for _, kind := range kinds {
+		if u.inserted[kind] {
+			continue
+		}
+		if err := u.store.Delete(kind, u.id); err != nil {
+			log.Println("[warn]: ", err)
+		}
+	}
+}
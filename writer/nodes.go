@@ -11,6 +11,7 @@ import (
 	"github.com/omniscale/imposm3/geom/geos"
 	"github.com/omniscale/imposm3/log"
 	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/memory"
 	"github.com/omniscale/imposm3/stats"
 )
 
@@ -49,6 +50,7 @@ func (nw *NodeWriter) loop() {
 	defer geos.Finish()
 
 	for n := range nw.nodes {
+		memory.Backoff()
 		nw.progress.AddNodes(1)
 		if matches := nw.pointMatcher.MatchNode(n); len(matches) > 0 {
 			nw.NodeToSrid(n)
@@ -57,14 +59,23 @@ func (nw *NodeWriter) loop() {
 				if errl, ok := err.(ErrorLevel); !ok || errl.Level() > 0 {
 					log.Println("[warn]: ", err)
 				}
+				nw.quarantine(n.Element, err, matches, []osm.Node{*n})
 				continue
 			}
 
 			geom, err := geomp.AsGeomElement(geos, point)
 			if err != nil {
 				log.Println("[warn]: ", err)
+				nw.quarantine(n.Element, err, matches, []osm.Node{*n})
 				continue
 			}
+			if ele, ok := geomp.ParseElevation(n.Tags["ele"]); ok && elevationMatch(matches) {
+				geom.WkbZ, err = geomp.WithElevation(geom.Wkb, func(x, y float64) float64 { return ele })
+				if err != nil {
+					log.Println("[warn]: ", err)
+					continue
+				}
+			}
 
 			inserted := false
 			if nw.limiter != nil {
@@ -74,6 +85,7 @@ func (nw *NodeWriter) loop() {
 					continue
 				}
 				if len(parts) >= 1 {
+					geom.LimitTo = nw.limiter.Properties(geom.Geom)
 					if err := nw.inserter.InsertPoint(n.Element, geom, matches); err != nil {
 						log.Println("[warn]: ", err)
 						continue
@@ -88,6 +100,13 @@ func (nw *NodeWriter) loop() {
 				inserted = true
 			}
 
+			if inserted {
+				if oi, ok := nw.inserter.(database.OSMInserter); ok {
+					if err := oi.InsertNode(*n); err != nil {
+						log.Println("[warn]: ", err)
+					}
+				}
+			}
 			if inserted && nw.expireor != nil {
 				expire.ExpireProjectedNode(nw.expireor, *n, nw.srid)
 			}
@@ -1,6 +1,7 @@
 package writer
 
 import (
+	"runtime"
 	"sync"
 	"time"
 
@@ -13,13 +14,14 @@ import (
 	geosp "github.com/omniscale/imposm3/geom/geos"
 	"github.com/omniscale/imposm3/log"
 	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/memory"
 	"github.com/omniscale/imposm3/stats"
 )
 
 type RelationWriter struct {
 	OsmElemWriter
 	singleIDSpace         bool
-	rel                   chan *osm.Relation
+	scheduler             *relScheduler
 	polygonMatcher        mapping.RelWayMatcher
 	relationMatcher       mapping.RelationMatcher
 	relationMemberMatcher mapping.RelationMatcher
@@ -55,8 +57,8 @@ func NewRelationWriter(
 		polygonMatcher:        matcher,
 		relationMatcher:       relMatcher,
 		relationMemberMatcher: relMemberMatcher,
-		rel:    rel,
-		maxGap: maxGap,
+		scheduler:             newRelScheduler(rel, memory.Scale(runtime.NumCPU()*256)),
+		maxGap:                maxGap,
 	}
 	rw.OsmElemWriter.writer = &rw
 	return &rw.OsmElemWriter
@@ -74,14 +76,22 @@ func (rw *RelationWriter) loop() {
 	geos.SetHandleSrid(rw.srid)
 	defer geos.Finish()
 
+	queue := rw.scheduler.queue()
 NextRel:
-	for r := range rw.rel {
+	for {
+		r := rw.scheduler.next(queue)
+		if r == nil {
+			break
+		}
+		memory.Backoff()
 		rw.progress.AddRelations(1)
+		geoms := rw.geometryUpdate(r.ID)
 		err := rw.osmCache.Ways.FillMembers(r.Members)
 		if err != nil {
 			if err != cache.NotFound {
 				log.Println("[warn]: ", err)
 			}
+			geoms.finish(cache.MultiPolygonGeometry)
 			continue
 		}
 		for i, m := range r.Members {
@@ -93,6 +103,7 @@ NextRel:
 				if err != cache.NotFound {
 					log.Println("[warn]: ", err)
 				}
+				geoms.finish(cache.MultiPolygonGeometry)
 				continue NextRel
 			}
 			rw.NodesToSrid(m.Way.Nodes)
@@ -111,10 +122,20 @@ NextRel:
 		if handleRelation(rw, r, geos) {
 			inserted = true
 		}
-		if handleMultiPolygon(rw, r, geos) {
+		if handleMultiPolygon(rw, r, geos, geoms) {
 			inserted = true
 		}
+		geoms.finish(cache.MultiPolygonGeometry)
 
+		if inserted {
+			if oi, ok := rw.inserter.(database.OSMInserter); ok {
+				rel := osm.Relation(*r)
+				rel.Members = allMembers
+				if err := oi.InsertRelation(rel); err != nil {
+					log.Println("[warn]: ", err)
+				}
+			}
+		}
 		if inserted && rw.diffCache != nil {
 			rw.diffCache.Ways.AddFromMembers(r.ID, allMembers)
 			rw.diffCache.CoordsRel.AddFromMembers(r.ID, allMembers)
@@ -135,51 +156,112 @@ NextRel:
 	rw.wg.Done()
 }
 
-func handleMultiPolygon(rw *RelationWriter, r *osm.Relation, geos *geosp.Geos) bool {
+func handleMultiPolygon(rw *RelationWriter, r *osm.Relation, geos *geosp.Geos, geoms *geometryUpdate) bool {
 	matches := rw.polygonMatcher.MatchRelation(r)
 	if matches == nil {
 		return false
 	}
 
-	// prepare relation (build rings)
-	prepedRel, err := geomp.PrepareRelation(r, rw.srid, rw.maxGap)
-	if err != nil {
-		if errl, ok := err.(ErrorLevel); !ok || errl.Level() > 0 {
-			log.Println("[warn]: ", err)
+	var geom geomp.Geometry
+	cached := geoms.cached(cache.MultiPolygonGeometry)
+	if cached != nil {
+		g := geos.FromWkb(cached)
+		if g == nil {
+			log.Printf("[warn]: invalid stored geometry of relation %d", r.ID)
+			return false
+		}
+		defer geos.Destroy(g)
+		geom = geomp.Geometry{Geom: g, Wkb: geos.AsEwkbHex(g)}
+	} else {
+		// prepare relation (build rings)
+		prepedRel, err := geomp.PrepareRelation(r, rw.srid, rw.maxGap)
+		if err != nil {
+			if errl, ok := err.(ErrorLevel); !ok || errl.Level() > 0 {
+				log.Println("[warn]: ", err)
+			}
+			rw.quarantineRelation(r, err, matches)
+			return false
 		}
-		return false
-	}
 
-	// build the multipolygon
-	geom, err := prepedRel.Build()
-	if geom.Geom != nil {
-		defer geos.Destroy(geom.Geom)
+		// build the multipolygon
+		geom, err = prepedRel.Build()
+		if geom.Geom != nil {
+			defer geos.Destroy(geom.Geom)
+		}
+		if err != nil {
+			if errl, ok := err.(ErrorLevel); !ok || errl.Level() > 0 {
+				log.Println("[warn]: ", err)
+			}
+			rw.quarantineRelation(r, err, matches)
+			return false
+		}
+		if geom.Repaired {
+			var strict []mapping.Match
+			matches, strict = splitStrictMatches(matches)
+			if len(strict) > 0 {
+				rw.quarantineRelation(r, errRepaired, strict)
+				// build again with the next change, for the strict tables
+				geoms = nil
+			}
+			if len(matches) == 0 {
+				return false
+			}
+		}
 	}
-	if err != nil {
-		if errl, ok := err.(ErrorLevel); !ok || errl.Level() > 0 {
-			log.Println("[warn]: ", err)
+
+	geom.MemberPoints = rw.memberPoints(r)
+
+	// stored records the inserted geometry for the reuse by later diffs
+	built := geom.Geom
+	stored := func(inserted bool) bool {
+		if inserted && geoms != nil {
+			var wkb []byte
+			if cached == nil {
+				wkb = geos.AsWkb(built)
+			}
+			geoms.insert(cache.MultiPolygonGeometry, wkb)
 		}
-		return false
+		return inserted
 	}
 
 	if rw.limiter != nil {
+		clipMatches, centroidMatches := splitLimitToMatches(matches)
+		inserted := false
+		if len(centroidMatches) > 0 && rw.limiter.ContainsCentroid(geom.Geom) {
+			rel := osm.Relation(*r)
+			rel.ID = rw.relID(r.ID)
+			whole := geom
+			whole.LimitTo = rw.limiter.Properties(whole.Geom)
+			if err := rw.insertPolygon(geos, rel.Element, whole, centroidMatches); err != nil {
+				if errl, ok := err.(ErrorLevel); !ok || errl.Level() > 0 {
+					log.Println("[warn]: ", err)
+				}
+			} else {
+				inserted = true
+			}
+		}
+		if len(clipMatches) == 0 {
+			return stored(inserted)
+		}
+		matches = clipMatches
+
 		start := time.Now()
 		parts, err := rw.limiter.Clip(geom.Geom)
 		if err != nil {
 			log.Println("[warn]: ", err)
-			return false
+			return stored(inserted)
 		}
 		if duration := time.Now().Sub(start); duration > time.Minute {
 			log.Printf("[warn]: clipping relation %d to -limitto took %s", r.ID, duration)
 		}
 		if len(parts) == 0 {
-			return false
+			return stored(inserted)
 		}
 		for _, g := range parts {
 			rel := osm.Relation(*r)
 			rel.ID = rw.relID(r.ID)
-			geom = geomp.Geometry{Geom: g, Wkb: geos.AsEwkbHex(g)}
-			err := rw.inserter.InsertPolygon(rel.Element, geom, matches)
+			geom = geomp.Geometry{Geom: g, Wkb: geos.AsEwkbHex(g), LimitTo: rw.limiter.Properties(g), MemberPoints: geom.MemberPoints}
+			err := rw.insertPolygon(geos, rel.Element, geom, matches)
 			if err != nil {
 				if errl, ok := err.(ErrorLevel); !ok || errl.Level() > 0 {
 					log.Println("[warn]: ", err)
@@ -190,7 +272,7 @@ func handleMultiPolygon(rw *RelationWriter, r *osm.Relation, geos *geosp.Geos) b
 	} else {
 		rel := osm.Relation(*r)
 		rel.ID = rw.relID(r.ID)
-		err := rw.inserter.InsertPolygon(rel.Element, geom, matches)
+		err := rw.insertPolygon(geos, rel.Element, geom, matches)
 		if err != nil {
 			if errl, ok := err.(ErrorLevel); !ok || errl.Level() > 0 {
 				log.Println("[warn]: ", err)
@@ -199,7 +281,51 @@ func handleMultiPolygon(rw *RelationWriter, r *osm.Relation, geos *geosp.Geos) b
 		}
 	}
 
-	return true
+	return stored(true)
+}
+
+// memberPoints returns the points of the node members with a role (e.g.
+// admin_centre or label), see geomp.Geometry.MemberPoints.
+func (rw *RelationWriter) memberPoints(r *osm.Relation) map[string][]byte {
+	var points map[string][]byte
+	for _, m := range r.Members {
+		if m.Type != osm.NodeMember || m.Role == "" {
+			continue
+		}
+		if _, ok := points[m.Role]; ok {
+			continue
+		}
+		nd, err := rw.osmCache.Nodes.GetNode(m.ID)
+		if err == cache.NotFound {
+			nd, err = rw.osmCache.Coords.GetCoord(m.ID)
+		}
+		if err != nil {
+			if err != cache.NotFound {
+				log.Println("[warn]: ", err)
+			}
+			continue
+		}
+		rw.NodeToSrid(nd)
+		if points == nil {
+			points = make(map[string][]byte)
+		}
+		points[m.Role] = geomp.NodeAsEWKBHexPoint(*nd, rw.srid)
+	}
+	return points
+}
+
+// quarantineRelation passes the relation with the nodes of all member ways
+// to the quarantine.
+func (rw *RelationWriter) quarantineRelation(r *osm.Relation, reason error, matches []mapping.Match) {
+	rel := osm.Relation(*r)
+	rel.ID = rw.relID(r.ID)
+	var nodes [][]osm.Node
+	for _, m := range r.Members {
+		if m.Way != nil {
+			nodes = append(nodes, m.Way.Nodes)
+		}
+	}
+	rw.quarantine(rel.Element, reason, matches, nodes...)
 }
 
 func handleRelation(rw *RelationWriter, r *osm.Relation, geos *geosp.Geos) bool {
@@ -209,10 +335,64 @@ func handleRelation(rw *RelationWriter, r *osm.Relation, geos *geosp.Geos) bool
 	}
 	rel := osm.Relation(*r)
 	rel.ID = rw.relID(r.ID)
-	rw.inserter.InsertPolygon(rel.Element, geomp.Geometry{}, relMatches)
+	relMatches, mergeMatches := splitMergeLinesMatches(relMatches)
+	if len(relMatches) > 0 {
+		rw.inserter.InsertPolygon(rel.Element, geomp.Geometry{}, relMatches)
+	}
+	if len(mergeMatches) > 0 {
+		handleMergedLines(rw, rel, geos, mergeMatches)
+	}
 	return true
 }
 
+// handleMergedLines inserts the relation with the merged linestrings of
+// all way members. Relations without ways are inserted without geometry.
+func handleMergedLines(rw *RelationWriter, rel osm.Relation, geos *geosp.Geos, matches []mapping.Match) {
+	var lines []*geosp.Geom
+	for _, nodes := range geomp.MergeLines(geomp.RouteLines(rel.Members)) {
+		g, err := geomp.LineString(geos, nodes)
+		if err != nil {
+			log.Println("[warn]: ", err)
+			continue
+		}
+		lines = append(lines, g)
+	}
+	if len(lines) == 0 {
+		rw.inserter.InsertPolygon(rel.Element, geomp.Geometry{}, matches)
+		return
+	}
+	g := geos.MultiLineString(lines)
+	if g == nil {
+		log.Printf("[warn]: unable to create merged lines of relation %d", rel.ID)
+		return
+	}
+	defer geos.Destroy(g)
+
+	if rw.limiter == nil {
+		geom, err := geomp.AsGeomElement(geos, g)
+		if err != nil {
+			log.Println("[warn]: ", err)
+			return
+		}
+		if err := rw.inserter.InsertPolygon(rel.Element, geom, matches); err != nil {
+			log.Println("[warn]: ", err)
+		}
+		return
+	}
+
+	parts, err := rw.limiter.Clip(g)
+	if err != nil {
+		log.Println("[warn]: ", err)
+		return
+	}
+	for _, p := range parts {
+		geom := geomp.Geometry{Geom: p, Wkb: geos.AsEwkbHex(p), LimitTo: rw.limiter.Properties(p)}
+		if err := rw.inserter.InsertPolygon(rel.Element, geom, matches); err != nil {
+			log.Println("[warn]: ", err)
+		}
+	}
+}
+
 func handleRelationMembers(rw *RelationWriter, r *osm.Relation, geos *geosp.Geos) bool {
 	relMemberMatches := rw.relationMemberMatcher.MatchRelation(r)
 	if relMemberMatches == nil {
@@ -250,7 +430,12 @@ func handleRelationMembers(rw *RelationWriter, r *osm.Relation, geos *geosp.Geos
 		}
 	}
 
-	for _, m := range r.Members {
+	var routes []*geomp.RoutePosition
+	if t := r.Tags["type"]; t == "route" || t == "superroute" {
+		routes = geomp.RoutePositions(r.Members, rw.srid)
+	}
+
+	for i, m := range r.Members {
 		var g *geosp.Geom
 		var err error
 		if m.Node != nil {
@@ -275,6 +460,9 @@ func handleRelationMembers(rw *RelationWriter, r *osm.Relation, geos *geosp.Geos
 				return false
 			}
 		}
+		if routes != nil {
+			gelem.Route = routes[i]
+		}
 		rel := osm.Relation(*r)
 		rel.ID = rw.relID(r.ID)
 		rw.inserter.InsertRelationMember(rel, m, gelem, relMemberMatches)
@@ -0,0 +1,181 @@
+package writer
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/cache"
+	"github.com/omniscale/imposm3/log"
+)
+
+// Building multipolygons of large relations (coastlines, national
+// boundaries) takes minutes, while most relations are built in a few
+// milliseconds. The relations are scheduled by their estimated cost, so
+// that these relations are started early and the remaining relations are
+// built on all other cores in the meantime.
+
+// heavyRelationCost is the cost of relations that are scheduled before all
+// other relations.
+const heavyRelationCost = 1000
+
+// relationCost estimates the cost of building a relation by the number of
+// its way members.
+func relationCost(r *osm.Relation) int {
+	cost := 1
+	for _, m := range r.Members {
+		if m.Type == osm.WayMember {
+			cost++
+		}
+	}
+	return cost
+}
+
+// HeavyRelationsFirst returns all relations of the cache, starting with the
+// heavy relations ordered by their cost. The cache is read twice, once to
+// find the heavy relations and once for all other relations. The channel
+// is closed early if ctx is cancelled.
+func HeavyRelationsFirst(ctx context.Context, relations *cache.RelationsCache) chan *osm.Relation {
+	type heavyRel struct {
+		id   int64
+		cost int
+	}
+	var heavy []heavyRel
+	for r := range relations.IterContext(ctx) {
+		if cost := relationCost(r); cost >= heavyRelationCost {
+			heavy = append(heavy, heavyRel{r.ID, cost})
+		}
+	}
+	sort.Slice(heavy, func(i, j int) bool { return heavy[i].cost > heavy[j].cost })
+	if len(heavy) > 0 {
+		log.Printf("[info] Scheduling %d relations with %d or more ways first", len(heavy), heavyRelationCost)
+	}
+
+	rels := make(chan *osm.Relation)
+	go func() {
+		defer close(rels)
+		ids := make(map[int64]struct{}, len(heavy))
+		for _, h := range heavy {
+			ids[h.id] = struct{}{}
+			r, err := relations.GetRelation(h.id)
+			if err != nil {
+				log.Println("[warn]: ", err)
+				continue
+			}
+			select {
+			case rels <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+		for r := range relations.IterContext(ctx) {
+			if _, ok := ids[r.ID]; !ok {
+				select {
+				case rels <- r:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return rels
+}
+
+// relQueue is the queue of relations of a single worker.
+type relQueue struct {
+	rels []*osm.Relation
+	cost int
+}
+
+// relScheduler distributes relations to the queues of all workers, each
+// relation to the queue with the lowest cost. Heavy relations are queued
+// in front of all other relations. Workers take relations from their own
+// queue and steal from the queue with the highest cost if their queue is
+// empty.
+type relScheduler struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queues  []*relQueue
+	pending int
+	window  int
+	closed  bool
+}
+
+// newRelScheduler starts to distribute the relations from in. It reads no
+// more than window relations ahead.
+func newRelScheduler(in chan *osm.Relation, window int) *relScheduler {
+	s := &relScheduler{window: window}
+	s.cond = sync.NewCond(&s.mu)
+	go s.feed(in)
+	return s
+}
+
+func (s *relScheduler) feed(in chan *osm.Relation) {
+	for r := range in {
+		cost := relationCost(r)
+		s.mu.Lock()
+		for s.pending >= s.window || len(s.queues) == 0 {
+			s.cond.Wait()
+		}
+		q := s.queues[0]
+		for _, other := range s.queues[1:] {
+			if other.cost < q.cost {
+				q = other
+			}
+		}
+		if cost >= heavyRelationCost {
+			q.rels = append([]*osm.Relation{r}, q.rels...)
+		} else {
+			q.rels = append(q.rels, r)
+		}
+		q.cost += cost
+		s.pending++
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// queue registers a new worker and returns its queue.
+func (s *relScheduler) queue() *relQueue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := &relQueue{}
+	s.queues = append(s.queues, q)
+	s.cond.Broadcast()
+	return q
+}
+
+// next returns the next relation for the worker of q. Blocks till a
+// relation is available. Returns nil after all relations were returned.
+func (s *relScheduler) next(q *relQueue) *osm.Relation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		from := q
+		if len(from.rels) == 0 {
+			for _, other := range s.queues {
+				if len(other.rels) > 0 && (len(from.rels) == 0 || other.cost > from.cost) {
+					from = other
+				}
+			}
+		}
+		if len(from.rels) > 0 {
+			r := from.rels[0]
+			from.rels[0] = nil
+			from.rels = from.rels[1:]
+			from.cost -= relationCost(r)
+			s.pending--
+			s.cond.Broadcast()
+			return r
+		}
+		if s.closed {
+			return nil
+		}
+		s.cond.Wait()
+	}
+}
@@ -0,0 +1,79 @@
+package writer
+
+import (
+	"sync"
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+)
+
+func relWithWays(id int64, ways int) *osm.Relation {
+	r := &osm.Relation{Element: osm.Element{ID: id}}
+	for i := 0; i < ways; i++ {
+		r.Members = append(r.Members, osm.Member{ID: int64(i), Type: osm.WayMember})
+	}
+	return r
+}
+
+func TestRelScheduler(t *testing.T) {
+	in := make(chan *osm.Relation)
+	s := newRelScheduler(in, 100)
+	q := s.queue()
+
+	// all relations are buffered before the first is returned
+	go func() {
+		in <- relWithWays(1, 2)
+		in <- relWithWays(2, 3)
+		in <- relWithWays(3, heavyRelationCost)
+		close(in)
+	}()
+	s.mu.Lock()
+	for !s.closed {
+		s.cond.Wait()
+	}
+	s.mu.Unlock()
+
+	var ids []int64
+	for r := s.next(q); r != nil; r = s.next(q) {
+		ids = append(ids, r.ID)
+	}
+	if len(ids) != 3 || ids[0] != 3 || ids[1] != 1 || ids[2] != 2 {
+		t.Errorf("unexpected order %v", ids)
+	}
+}
+
+func TestRelSchedulerSteal(t *testing.T) {
+	in := make(chan *osm.Relation)
+	s := newRelScheduler(in, 1000)
+	queues := []*relQueue{s.queue(), s.queue(), s.queue(), s.queue()}
+
+	go func() {
+		for i := int64(1); i <= 500; i++ {
+			in <- relWithWays(i, int(i%7))
+		}
+		close(in)
+	}()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	seen := make(map[int64]bool)
+	// only two workers, relations of the other queues need to be stolen
+	for _, q := range queues[:2] {
+		wg.Add(1)
+		go func(q *relQueue) {
+			defer wg.Done()
+			for r := s.next(q); r != nil; r = s.next(q) {
+				mu.Lock()
+				if seen[r.ID] {
+					t.Errorf("relation %d returned twice", r.ID)
+				}
+				seen[r.ID] = true
+				mu.Unlock()
+			}
+		}(q)
+	}
+	wg.Wait()
+	if len(seen) != 500 {
+		t.Errorf("expected 500 relations, got %d", len(seen))
+	}
+}
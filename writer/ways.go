@@ -11,7 +11,9 @@ import (
 	"github.com/omniscale/imposm3/geom/geos"
 	"github.com/omniscale/imposm3/log"
 	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/memory"
 	"github.com/omniscale/imposm3/stats"
+	"github.com/pkg/errors"
 )
 
 type WayWriter struct {
@@ -21,6 +23,8 @@ type WayWriter struct {
 	lineMatcher    mapping.WayMatcher
 	polygonMatcher mapping.WayMatcher
 	maxGap         float64
+	// storesOSM is set if the inserter stores the OSM elements
+	storesOSM bool
 }
 
 func NewWayWriter(
@@ -52,6 +56,7 @@ func NewWayWriter(
 		polygonMatcher: polygonMatcher,
 		ways:           ways,
 		maxGap:         maxGap,
+		storesOSM:      database.StoresOSMElements(inserter),
 	}
 	ww.OsmElemWriter.writer = &ww
 	return &ww.OsmElemWriter
@@ -64,13 +69,24 @@ func (ww *WayWriter) wayID(id int64) int64 {
 	return -id
 }
 
+// needsFill returns whether the coords of a way need to be loaded. Reused
+// geometries only need the coords for elevations and for inserters that
+// store the OSM elements.
+func (ww *WayWriter) needsFill(cached []byte, matches []mapping.Match) bool {
+	return cached == nil || ww.storesOSM || elevationMatch(matches)
+}
+
 func (ww *WayWriter) loop() {
 	geos := geos.NewGeos()
 	geos.SetHandleSrid(ww.srid)
 	defer geos.Finish()
 	for w := range ww.ways {
+		memory.Backoff()
 		ww.progress.AddWays(1)
+		geoms := ww.geometryUpdate(w.ID)
+		finish := func() { geoms.finish(cache.LineStringGeometry, cache.PolygonGeometry) }
 		if len(w.Tags) == 0 {
+			finish()
 			continue
 		}
 
@@ -89,42 +105,67 @@ func (ww *WayWriter) loop() {
 			return true
 		}
 
+		osmID := w.ID
 		w.ID = ww.wayID(w.ID)
 
 		var err error
 		inserted := false
 		insertedPolygon := false
 		if matches := ww.lineMatcher.MatchWay(w); len(matches) > 0 {
-			if !fill(w) {
+			cached := geoms.cached(cache.LineStringGeometry)
+			if ww.needsFill(cached, matches) && !fill(w) {
+				finish()
 				continue
 			}
-			err, inserted = ww.buildAndInsert(geos, w, matches, false)
+			err, inserted = ww.buildAndInsert(geos, w, matches, false, cached, geoms)
 			if err != nil {
 				if errl, ok := err.(ErrorLevel); !ok || errl.Level() > 0 {
 					log.Println("[warn]: ", err)
 				}
+				finish()
 				continue
 			}
 		}
 		if matches := ww.polygonMatcher.MatchWay(w); len(matches) > 0 {
-			if !fill(w) {
+			cached := geoms.cached(cache.PolygonGeometry)
+			if ww.needsFill(cached, matches) && !fill(w) {
+				finish()
 				continue
 			}
 			if w.IsClosed() {
-				err, insertedPolygon = ww.buildAndInsert(geos, w, matches, true)
+				err, insertedPolygon = ww.buildAndInsert(geos, w, matches, true, cached, geoms)
 				if err != nil {
 					if errl, ok := err.(ErrorLevel); !ok || errl.Level() > 0 {
 						log.Println("[warn]: ", err)
 					}
+					finish()
 					continue
 				}
 			}
 		}
+		finish()
 
+		if inserted || insertedPolygon {
+			if oi, ok := ww.inserter.(database.OSMInserter); ok {
+				way := osm.Way(*w)
+				way.ID = osmID
+				if err := oi.InsertWay(way); err != nil {
+					log.Println("[warn]: ", err)
+				}
+			}
+		}
 		if (inserted || insertedPolygon) && ww.expireor != nil {
 			expire.ExpireProjectedNodes(ww.expireor, w.Nodes, ww.srid, insertedPolygon)
 		}
 		if (inserted || insertedPolygon) && ww.diffCache != nil {
+			if !filled {
+				// the coords of reused geometries are not loaded,
+				// but AddFromWay only needs the IDs
+				w.Nodes = make([]osm.Node, len(w.Refs))
+				for i, ref := range w.Refs {
+					w.Nodes[i].ID = ref
+				}
+			}
 			ww.diffCache.Coords.AddFromWay(w)
 		}
 	}
@@ -136,6 +177,8 @@ func (ww *WayWriter) buildAndInsert(
 	w *osm.Way,
 	matches []mapping.Match,
 	isPolygon bool,
+	cached []byte,
+	geoms *geometryUpdate,
 ) (error, bool) {
 
 	// make copy to avoid interference with polygon/linestring matches
@@ -143,42 +186,116 @@ func (ww *WayWriter) buildAndInsert(
 
 	var err error
 	var geosgeom *geos.Geom
+	var repaired bool
 
+	kind := cache.LineStringGeometry
 	if isPolygon {
-		geosgeom, err = geomp.Polygon(g, way.Nodes)
-		if err == nil {
-			if g.NumCoordinates(geosgeom) > 5 {
-				// only check for valididty for non-simple geometries
-				geosgeom, err = g.MakeValid(geosgeom)
-			}
+		kind = cache.PolygonGeometry
+	}
+
+	if cached != nil {
+		geosgeom = g.FromWkb(cached)
+		if geosgeom == nil {
+			return errors.Errorf("invalid stored geometry of way %d", way.ID), false
 		}
+		g.DestroyLater(geosgeom)
+	} else if isPolygon {
+		geosgeom, repaired, err = geomp.RepairedPolygon(g, way.Nodes)
 	} else {
 		geosgeom, err = geomp.LineString(g, way.Nodes)
 	}
 	if err != nil {
+		ww.quarantine(way.Element, err, matches, way.Nodes)
 		return err, false
 	}
+	// stored records the inserted geometry for the reuse by later diffs
+	stored := func(inserted bool) bool {
+		if inserted && geoms != nil {
+			var wkb []byte
+			if cached == nil {
+				wkb = g.AsWkb(geosgeom)
+			}
+			geoms.insert(kind, wkb)
+		}
+		return inserted
+	}
 
 	geom, err := geomp.AsGeomElement(g, geosgeom)
 	if err != nil {
+		ww.quarantine(way.Element, err, matches, way.Nodes)
 		return err, false
 	}
+	geom.Repaired = repaired
+	if repaired {
+		var strict []mapping.Match
+		matches, strict = splitStrictMatches(matches)
+		if len(strict) > 0 {
+			ww.quarantine(way.Element, errRepaired, strict, way.Nodes)
+			// build again with the next change, for the strict tables
+			geoms = nil
+		}
+		if len(matches) == 0 {
+			return nil, false
+		}
+	}
+
+	var elevations map[[2]float64]float64
+	if elevationMatch(matches) {
+		elevations = ww.nodeElevations(way.Nodes)
+	}
+	// withElevation sets WkbZ if any node has an elevation. Nodes without
+	// elevation (and new nodes from clipping) get a Z coordinate of 0.
+	withElevation := func(geom *geomp.Geometry) error {
+		if len(elevations) == 0 {
+			return nil
+		}
+		var err error
+		geom.WkbZ, err = geomp.WithElevation(geom.Wkb, func(x, y float64) float64 {
+			return elevations[[2]float64{x, y}]
+		})
+		return err
+	}
 
 	inserted := true
 	if ww.limiter != nil {
+		clipMatches, centroidMatches := splitLimitToMatches(matches)
+		inserted = false
+		if len(centroidMatches) > 0 && ww.limiter.ContainsCentroid(geom.Geom) {
+			whole := geom
+			whole.LimitTo = ww.limiter.Properties(whole.Geom)
+			if err := withElevation(&whole); err != nil {
+				return err, false
+			}
+			if isPolygon {
+				if err := ww.insertPolygon(g, way.Element, whole, centroidMatches); err != nil {
+					return err, false
+				}
+			} else {
+				if err := ww.inserter.InsertLineString(way.Element, whole, centroidMatches); err != nil {
+					return err, false
+				}
+			}
+			inserted = true
+		}
+		if len(clipMatches) == 0 {
+			return nil, stored(inserted)
+		}
+		matches = clipMatches
 		parts, err := ww.limiter.Clip(geom.Geom)
 		if err != nil {
 			return err, false
 		}
-		if len(parts) == 0 {
-			// outside of limitto
-			inserted = false
+		if len(parts) > 0 {
+			inserted = true
 		}
 		for _, p := range parts {
 			way := osm.Way(*w)
-			geom = geomp.Geometry{Geom: p, Wkb: g.AsEwkbHex(p)}
+			geom = geomp.Geometry{Geom: p, Wkb: g.AsEwkbHex(p), LimitTo: ww.limiter.Properties(p)}
+			if err := withElevation(&geom); err != nil {
+				return err, false
+			}
 			if isPolygon {
-				if err := ww.inserter.InsertPolygon(way.Element, geom, matches); err != nil {
+				if err := ww.insertPolygon(g, way.Element, geom, matches); err != nil {
 					return err, false
 				}
 			} else {
@@ -188,8 +305,11 @@ func (ww *WayWriter) buildAndInsert(
 			}
 		}
 	} else {
+		if err := withElevation(&geom); err != nil {
+			return err, false
+		}
 		if isPolygon {
-			if err := ww.inserter.InsertPolygon(way.Element, geom, matches); err != nil {
+			if err := ww.insertPolygon(g, way.Element, geom, matches); err != nil {
 				return err, false
 			}
 		} else {
@@ -198,5 +318,22 @@ func (ww *WayWriter) buildAndInsert(
 			}
 		}
 	}
-	return nil, inserted
+	return nil, stored(inserted)
+}
+
+// nodeElevations returns the elevation (ele tag) of all way nodes, by
+// their (projected) coordinate. Only tagged nodes are stored in the nodes
+// cache.
+func (ww *WayWriter) nodeElevations(nodes []osm.Node) map[[2]float64]float64 {
+	elevations := make(map[[2]float64]float64)
+	for _, nd := range nodes {
+		node, err := ww.osmCache.Nodes.GetNode(nd.ID)
+		if err != nil {
+			continue
+		}
+		if ele, ok := geomp.ParseElevation(node.Tags["ele"]); ok {
+			elevations[[2]float64{nd.Long, nd.Lat}] = ele
+		}
+	}
+	return elevations
 }
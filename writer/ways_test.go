@@ -0,0 +1,61 @@
+package writer
+
+import (
+	"testing"
+
+	osm "github.com/omniscale/go-osm"
+	"github.com/omniscale/imposm3/database"
+	"github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/mapping"
+)
+
+type nullInserter struct{}
+
+func (nullInserter) InsertPoint(osm.Element, geom.Geometry, []mapping.Match) error      { return nil }
+func (nullInserter) InsertLineString(osm.Element, geom.Geometry, []mapping.Match) error { return nil }
+func (nullInserter) InsertPolygon(osm.Element, geom.Geometry, []mapping.Match) error    { return nil }
+func (nullInserter) InsertRelationMember(osm.Relation, osm.Member, geom.Geometry, []mapping.Match) error {
+	return nil
+}
+
+// osmInserter stores OSM elements.
+type osmInserter struct{ nullInserter }
+
+func (osmInserter) InsertNode(osm.Node) error         { return nil }
+func (osmInserter) InsertWay(osm.Way) error           { return nil }
+func (osmInserter) InsertRelation(osm.Relation) error { return nil }
+
+// forwardingInserter passes OSM elements to db, like the inserters of diff
+// imports.
+type forwardingInserter struct {
+	osmInserter
+	db database.Inserter
+}
+
+func (f forwardingInserter) StoresOSMElements() bool { return database.StoresOSMElements(f.db) }
+
+func TestWayWriterNeedsFill(t *testing.T) {
+	cached := []byte{1}
+	roads := []mapping.Match{{Table: mapping.DestTable{Name: "roads"}}}
+	elevation := []mapping.Match{{Table: mapping.DestTable{Name: "roads", Elevation: true}}}
+
+	for _, tc := range []struct {
+		name     string
+		inserter database.Inserter
+		cached   []byte
+		matches  []mapping.Match
+		fill     bool
+	}{
+		{"not cached", nullInserter{}, nil, roads, true},
+		{"cached", nullInserter{}, cached, roads, false},
+		{"cached elevation", nullInserter{}, cached, elevation, true},
+		{"cached osm", osmInserter{}, cached, roads, true},
+		{"cached forwarded", forwardingInserter{db: nullInserter{}}, cached, roads, false},
+		{"cached forwarded osm", forwardingInserter{db: osmInserter{}}, cached, roads, true},
+	} {
+		ww := NewWayWriter(nil, nil, false, nil, tc.inserter, nil, nil, nil, 3857).writer.(*WayWriter)
+		if fill := ww.needsFill(tc.cached, tc.matches); fill != tc.fill {
+			t.Errorf("%s: unexpected fill %v", tc.name, fill)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package writer
 
 import (
+	"encoding/hex"
 	"runtime"
 	"sync"
 
@@ -8,9 +9,16 @@ import (
 	"github.com/omniscale/imposm3/cache"
 	"github.com/omniscale/imposm3/database"
 	"github.com/omniscale/imposm3/expire"
+	geomp "github.com/omniscale/imposm3/geom"
+	"github.com/omniscale/imposm3/geom/ewkb"
+	"github.com/omniscale/imposm3/geom/geos"
 	"github.com/omniscale/imposm3/geom/limit"
+	"github.com/omniscale/imposm3/log"
+	"github.com/omniscale/imposm3/mapping"
+	"github.com/omniscale/imposm3/memory"
 	"github.com/omniscale/imposm3/proj"
 	"github.com/omniscale/imposm3/stats"
+	"github.com/pkg/errors"
 )
 
 type ErrorLevel interface {
@@ -32,6 +40,8 @@ type OsmElemWriter struct {
 	srid       int
 	expireor   expire.Expireor
 	concurrent bool
+	geometries *cache.Geometries
+	reuse      map[int64]struct{}
 }
 
 func (writer *OsmElemWriter) SetLimiter(limiter *limit.Limiter) {
@@ -45,7 +55,7 @@ func (writer *OsmElemWriter) EnableConcurrent() {
 func (writer *OsmElemWriter) Start() {
 	concurrency := 1
 	if writer.concurrent {
-		concurrency = runtime.NumCPU()
+		concurrency = memory.Workers(runtime.NumCPU())
 	}
 	for i := 0; i < concurrency; i++ {
 		writer.wg.Add(1)
@@ -57,20 +67,177 @@ func (writer *OsmElemWriter) SetExpireor(exp expire.Expireor) {
 	writer.expireor = exp
 }
 
+// SetGeometries enables the reuse of geometries for diff imports. The
+// geometries of all inserted elements are stored in geoms. The geometries
+// of elements in reuse are read from geoms instead of building them again,
+// as their refs or members and coordinates are unchanged.
+func (writer *OsmElemWriter) SetGeometries(geoms *cache.Geometries, reuse map[int64]struct{}) {
+	writer.geometries = geoms
+	writer.reuse = reuse
+}
+
 func (writer *OsmElemWriter) Wait() {
 	writer.wg.Wait()
 }
 
+// elevationMatch returns whether any match is for a table with elevation.
+func elevationMatch(matches []mapping.Match) bool {
+	for _, m := range matches {
+		if m.Table.Elevation {
+			return true
+		}
+	}
+	return false
+}
+
+// splitLimitToMatches splits matches into matches for tables that clip
+// geometries to the -limitto polygons and for tables that keep whole
+// geometries with a centroid inside.
+func splitLimitToMatches(matches []mapping.Match) (clip, centroid []mapping.Match) {
+	for _, m := range matches {
+		if m.Table.LimitToCentroid {
+			centroid = append(centroid, m)
+		} else {
+			clip = append(clip, m)
+		}
+	}
+	return clip, centroid
+}
+
+// splitStrictMatches splits matches into matches for tables that accept
+// repaired polygons and for tables with strict validity.
+func splitStrictMatches(matches []mapping.Match) (repair, strict []mapping.Match) {
+	for _, m := range matches {
+		if m.Table.StrictValidity {
+			strict = append(strict, m)
+		} else {
+			repair = append(repair, m)
+		}
+	}
+	return repair, strict
+}
+
+// splitSubdivideMatches splits matches into matches for tables that
+// insert whole polygons and for tables that subdivide large polygons.
+func splitSubdivideMatches(matches []mapping.Match) (whole, subdivide []mapping.Match) {
+	for _, m := range matches {
+		if m.Table.Subdivide > 0 {
+			subdivide = append(subdivide, m)
+		} else {
+			whole = append(whole, m)
+		}
+	}
+	return whole, subdivide
+}
+
+// insertPolygon inserts the polygon for all matches. Polygons of tables
+// with subdivide are inserted in multiple parts, see geomp.Subdivide.
+func (writer *OsmElemWriter) insertPolygon(g *geos.Geos, elem osm.Element, geom geomp.Geometry, matches []mapping.Match) error {
+	matches, subdivide := splitSubdivideMatches(matches)
+	if len(matches) > 0 {
+		if err := writer.inserter.InsertPolygon(elem, geom, matches); err != nil {
+			return err
+		}
+	}
+	for len(subdivide) > 0 {
+		maxVertices := subdivide[0].Table.Subdivide
+		var same, other []mapping.Match
+		for _, m := range subdivide {
+			if m.Table.Subdivide == maxVertices {
+				same = append(same, m)
+			} else {
+				other = append(other, m)
+			}
+		}
+		for _, p := range geomp.Subdivide(g, geom.Geom, maxVertices) {
+			part := geom
+			if p != geom.Geom {
+				part.Geom = p
+				part.Wkb = g.AsEwkbHex(p)
+			}
+			if err := writer.inserter.InsertPolygon(elem, part, same); err != nil {
+				return err
+			}
+		}
+		subdivide = other
+	}
+	return nil
+}
+
+// splitMergeLinesMatches splits matches into matches for relation tables
+// without geometry and for tables with merged lines.
+func splitMergeLinesMatches(matches []mapping.Match) (plain, merge []mapping.Match) {
+	for _, m := range matches {
+		if m.Table.MergeLines {
+			merge = append(merge, m)
+		} else {
+			plain = append(plain, m)
+		}
+	}
+	return plain, merge
+}
+
+// errRepaired is the quarantine reason of repaired polygons for tables
+// with strict validity.
+var errRepaired = errors.New("invalid polygon, not repaired for tables with strict validity")
+
+// quarantine passes an element with an invalid geometry to the inserter,
+// if it stores these elements. nodeLists are the coordinates that are
+// available for the element.
+func (writer *OsmElemWriter) quarantine(elem osm.Element, reason error, matches []mapping.Match, nodeLists ...[]osm.Node) {
+	q, ok := writer.inserter.(database.Quarantiner)
+	if !ok || !database.StoresInvalid(q) {
+		return
+	}
+	if err := q.InsertInvalid(elem, reason, partialEWKB(writer.srid, nodeLists), matches); err != nil {
+		log.Println("[warn]: ", err)
+	}
+}
+
+// partialEWKB returns the nodes as hex encoded EWKB. Each list of nodes is
+// a LineString (or a Point for single nodes). Returns nil if there are no
+// nodes.
+func partialEWKB(srid int, nodeLists [][]osm.Node) []byte {
+	var lines, points []ewkb.Geometry
+	for _, nodes := range nodeLists {
+		coords := make([]ewkb.Coord, len(nodes))
+		for i, nd := range nodes {
+			coords[i] = ewkb.Coord{X: nd.Long, Y: nd.Lat}
+		}
+		if len(coords) >= 2 {
+			lines = append(lines, ewkb.Geometry{Type: ewkb.LineString, Coords: coords})
+		} else if len(coords) == 1 {
+			points = append(points, ewkb.Geometry{Type: ewkb.Point, Coords: coords})
+		}
+	}
+	var g ewkb.Geometry
+	switch {
+	case len(lines) == 1:
+		g = lines[0]
+	case len(lines) > 1:
+		g = ewkb.Geometry{Type: ewkb.MultiLineString, Geoms: lines}
+	case len(points) == 1:
+		g = points[0]
+	case len(points) > 1:
+		g = ewkb.Geometry{Type: ewkb.MultiPoint, Geoms: points}
+	default:
+		return nil
+	}
+	g.SRID = srid
+	return []byte(hex.EncodeToString(g.EWKB()))
+}
+
 func (writer *OsmElemWriter) NodesToSrid(nodes []osm.Node) {
 	if writer.srid == 4326 {
 		return
 	}
-	if writer.srid != 3857 {
-		panic("invalid srid. only 4326 and 3857 are supported")
+	p := proj.Lookup(writer.srid)
+	if p == nil {
+		panic("invalid srid, not supported by proj")
 	}
 
 	for i, nd := range nodes {
-		nodes[i].Long, nodes[i].Lat = proj.WgsToMerc(nd.Long, nd.Lat)
+		nodes[i].Long, nodes[i].Lat = p.Forward(nd.Long, nd.Lat)
 	}
 }
 
@@ -78,8 +245,9 @@ func (writer *OsmElemWriter) NodeToSrid(node *osm.Node) {
 	if writer.srid == 4326 {
 		return
 	}
-	if writer.srid != 3857 {
-		panic("invalid srid. only 4326 and 3857 are supported")
+	p := proj.Lookup(writer.srid)
+	if p == nil {
+		panic("invalid srid, not supported by proj")
 	}
-	node.Long, node.Lat = proj.WgsToMerc(node.Long, node.Lat)
+	node.Long, node.Lat = p.Forward(node.Long, node.Lat)
 }